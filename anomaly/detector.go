@@ -0,0 +1,292 @@
+// Package anomaly detects abnormal publish bursts per publisher and topic.
+//
+// Each publisher/topic pair counts sends in fixed windows and keeps an
+// exponentially weighted baseline of past windows. A window whose count
+// exceeds Factor times the baseline (and at least MinCount) trips the pair:
+// in throttle mode further sends are rejected until the window ends, in
+// quarantine mode until the cooldown elapses or an admin releases it.
+package anomaly
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrThrottled is returned while a pair is throttled for the current window.
+	ErrThrottled = errors.New("publish rate anomaly: sends throttled")
+	// ErrQuarantined is returned while a pair is quarantined.
+	ErrQuarantined = errors.New("publish rate anomaly: sends quarantined")
+)
+
+// Modes
+const (
+	ModeThrottle   = "throttle"
+	ModeQuarantine = "quarantine"
+)
+
+// Config tunes the detector. Zero values get defaults.
+type Config struct {
+	Window    time.Duration // Counting window (default 1m)
+	Factor    float64       // Multiple of the baseline that counts as a burst (default 10)
+	MinCount  int           // Sends per window always allowed, so quiet pairs can't trip on a handful of sends (default 30)
+	Smoothing float64       // EWMA weight of the newest window, 0-1 (default 0.1)
+	Mode      string        // ModeThrottle (default) or ModeQuarantine
+	Cooldown  time.Duration // Quarantine duration; 0 means until released
+}
+
+// Event describes a tripped pair. It is passed to the OnTrip callback.
+type Event struct {
+	Publisher string    `json:"publisher"`
+	Topic     string    `json:"topic"`
+	Count     int       `json:"count"`
+	Baseline  float64   `json:"baseline"`
+	Mode      string    `json:"mode"`
+	Until     time.Time `json:"until,omitempty"` // Zero for quarantines that need a release
+}
+
+// Status is a snapshot of a pair for the admin API.
+type Status struct {
+	Publisher   string    `json:"publisher"`
+	Topic       string    `json:"topic"`
+	Count       int       `json:"count"` // Sends in the current window
+	Baseline    float64   `json:"baseline"`
+	Blocked     bool      `json:"blocked"`
+	Quarantined bool      `json:"quarantined"`
+	Until       time.Time `json:"until,omitempty"`
+	Exempt      bool      `json:"exempt"`
+}
+
+type key struct{ publisher, topic string }
+
+type pair struct {
+	windowStart time.Time
+	count       int
+	baseline    float64
+	warm        bool // At least one full window observed
+	blocked     bool
+	quarantined bool
+	until       time.Time // Zero with quarantined means until released
+	lastSeen    time.Time
+}
+
+// Detector tracks publish rates. It is safe for concurrent use.
+type Detector struct {
+	mu        sync.Mutex
+	cfg       Config
+	pairs     map[key]*pair
+	exempt    map[key]bool
+	onTrip    func(Event)
+	now       func() time.Time
+	lastPrune time.Time
+}
+
+// NewDetector creates a Detector with cfg.
+func NewDetector(cfg Config) *Detector {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.Factor <= 0 {
+		cfg.Factor = 10
+	}
+	if cfg.MinCount <= 0 {
+		cfg.MinCount = 30
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.1
+	}
+	if cfg.Mode != ModeQuarantine {
+		cfg.Mode = ModeThrottle
+	}
+	return &Detector{
+		cfg:    cfg,
+		pairs:  map[key]*pair{},
+		exempt: map[key]bool{},
+		now:    time.Now,
+	}
+}
+
+// OnTrip registers a callback invoked (outside the lock) when a pair trips.
+func (d *Detector) OnTrip(fn func(Event)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onTrip = fn
+}
+
+// Allow records a send by publisher to topic and reports whether it may proceed.
+func (d *Detector) Allow(publisher, topic string) error {
+	d.mu.Lock()
+	now := d.now()
+	k := key{publisher, topic}
+	if d.exempt[k] || d.exempt[key{publisher, ""}] {
+		d.mu.Unlock()
+		return nil
+	}
+	d.prune(now)
+
+	p, ok := d.pairs[k]
+	if !ok {
+		p = &pair{windowStart: now}
+		d.pairs[k] = p
+	}
+	p.lastSeen = now
+	d.roll(p, now)
+
+	if p.quarantined {
+		if p.until.IsZero() || now.Before(p.until) {
+			d.mu.Unlock()
+			return ErrQuarantined
+		}
+		p.quarantined = false
+		p.blocked = false
+	}
+	if p.blocked {
+		d.mu.Unlock()
+		return ErrThrottled
+	}
+
+	p.count++
+	threshold := float64(d.cfg.MinCount)
+	if p.warm && d.cfg.Factor*p.baseline > threshold {
+		threshold = d.cfg.Factor * p.baseline
+	}
+	if float64(p.count) <= threshold {
+		d.mu.Unlock()
+		return nil
+	}
+
+	// Tripped: this send is rejected too.
+	p.blocked = true
+	ev := Event{Publisher: publisher, Topic: topic, Count: p.count, Baseline: p.baseline, Mode: d.cfg.Mode}
+	err := ErrThrottled
+	if d.cfg.Mode == ModeQuarantine {
+		p.quarantined = true
+		if d.cfg.Cooldown > 0 {
+			p.until = now.Add(d.cfg.Cooldown)
+		}
+		err = ErrQuarantined
+	} else {
+		p.until = p.windowStart.Add(d.cfg.Window)
+	}
+	ev.Until = p.until
+	onTrip := d.onTrip
+	d.mu.Unlock()
+
+	if onTrip != nil {
+		onTrip(ev)
+	}
+	return err
+}
+
+// roll closes elapsed windows, folding their counts into the baseline.
+// Counts of a blocked window are capped at the threshold so a burst doesn't
+// inflate the baseline it is measured against.
+func (d *Detector) roll(p *pair, now time.Time) {
+	elapsed := int(now.Sub(p.windowStart) / d.cfg.Window)
+	if elapsed <= 0 {
+		return
+	}
+	a := d.cfg.Smoothing
+	count := float64(p.count)
+	if p.blocked && p.warm {
+		count = d.cfg.Factor * p.baseline
+	}
+	if !p.warm {
+		p.baseline = count
+		p.warm = true
+	} else {
+		p.baseline = a*count + (1-a)*p.baseline
+	}
+	// Empty windows in between
+	for i := 1; i < elapsed && i < 1000; i++ {
+		p.baseline *= 1 - a
+	}
+	p.count = 0
+	p.windowStart = p.windowStart.Add(time.Duration(elapsed) * d.cfg.Window)
+	if !p.quarantined {
+		p.blocked = false
+		p.until = time.Time{}
+	}
+}
+
+// prune drops idle pairs roughly once per window.
+func (d *Detector) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < d.cfg.Window {
+		return
+	}
+	d.lastPrune = now
+	idle := 100 * d.cfg.Window
+	for k, p := range d.pairs {
+		if !p.quarantined && now.Sub(p.lastSeen) > idle {
+			delete(d.pairs, k)
+		}
+	}
+}
+
+// Release clears a throttle or quarantine. An empty topic releases every
+// topic of the publisher.
+func (d *Detector) Release(publisher, topic string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	released := false
+	for k, p := range d.pairs {
+		if k.publisher == publisher && (topic == "" || k.topic == topic) && (p.blocked || p.quarantined) {
+			p.blocked = false
+			p.quarantined = false
+			p.until = time.Time{}
+			released = true
+		}
+	}
+	return released
+}
+
+// SetExempt exempts a publisher (all topics when topic is "") from detection.
+func (d *Detector) SetExempt(publisher, topic string, exempt bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	k := key{publisher, topic}
+	if exempt {
+		d.exempt[k] = true
+	} else {
+		delete(d.exempt, k)
+	}
+}
+
+// Snapshot returns the state of every tracked pair, blocked ones first.
+func (d *Detector) Snapshot() []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	out := []Status{}
+	for k, p := range d.pairs {
+		d.roll(p, now)
+		out = append(out, Status{
+			Publisher:   k.publisher,
+			Topic:       k.topic,
+			Count:       p.count,
+			Baseline:    p.baseline,
+			Blocked:     p.blocked || p.quarantined,
+			Quarantined: p.quarantined,
+			Until:       p.until,
+			Exempt:      d.exempt[k] || d.exempt[key{k.publisher, ""}],
+		})
+	}
+	for k := range d.exempt {
+		if _, ok := d.pairs[k]; !ok {
+			out = append(out, Status{Publisher: k.publisher, Topic: k.topic, Exempt: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Blocked != out[j].Blocked {
+			return out[i].Blocked
+		}
+		if out[i].Publisher != out[j].Publisher {
+			return out[i].Publisher < out[j].Publisher
+		}
+		return out[i].Topic < out[j].Topic
+	})
+	return out
+}
@@ -0,0 +1,124 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestDetector(cfg Config) (*Detector, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	d := NewDetector(cfg)
+	d.now = clock.now
+	return d, clock
+}
+
+// warmUp sends n messages per window for windows windows.
+func warmUp(d *Detector, c *fakeClock, n, windows int) {
+	for w := 0; w < windows; w++ {
+		for i := 0; i < n; i++ {
+			_ = d.Allow("pub", "news")
+		}
+		c.t = c.t.Add(time.Minute)
+	}
+}
+
+func TestDetector_Throttle(t *testing.T) {
+	d, clock := newTestDetector(Config{Window: time.Minute, Factor: 10, MinCount: 5})
+
+	var events []Event
+	d.OnTrip(func(ev Event) { events = append(events, ev) })
+
+	warmUp(d, clock, 2, 5)
+
+	// Baseline ~2/window: 20 sends are fine, the 21st trips
+	for i := 0; i < 20; i++ {
+		if err := d.Allow("pub", "news"); err != nil {
+			t.Fatalf("send %d: unexpected %v", i, err)
+		}
+	}
+	if err := d.Allow("pub", "news"); err != ErrThrottled {
+		t.Fatalf("Expected ErrThrottled, got %v", err)
+	}
+	if err := d.Allow("pub", "news"); err != ErrThrottled {
+		t.Errorf("Expected to stay throttled, got %v", err)
+	}
+	if len(events) != 1 || events[0].Topic != "news" || events[0].Mode != ModeThrottle {
+		t.Errorf("Expected one trip event, got %+v", events)
+	}
+
+	// Other topics are unaffected
+	if err := d.Allow("pub", "other"); err != nil {
+		t.Errorf("Expected other topic to be allowed, got %v", err)
+	}
+
+	// Next window is open again
+	clock.t = clock.t.Add(time.Minute)
+	if err := d.Allow("pub", "news"); err != nil {
+		t.Errorf("Expected next window to be allowed, got %v", err)
+	}
+}
+
+func TestDetector_MinCount(t *testing.T) {
+	d, _ := newTestDetector(Config{MinCount: 30})
+	for i := 0; i < 30; i++ {
+		if err := d.Allow("new", "t"); err != nil {
+			t.Fatalf("send %d: unexpected %v", i, err)
+		}
+	}
+	if err := d.Allow("new", "t"); err != ErrThrottled {
+		t.Errorf("Expected cold pair to trip above MinCount, got %v", err)
+	}
+}
+
+func TestDetector_QuarantineAndRelease(t *testing.T) {
+	d, clock := newTestDetector(Config{MinCount: 3, Mode: ModeQuarantine, Cooldown: 10 * time.Minute})
+
+	for i := 0; i < 3; i++ {
+		_ = d.Allow("pub", "news")
+	}
+	if err := d.Allow("pub", "news"); err != ErrQuarantined {
+		t.Fatalf("Expected ErrQuarantined, got %v", err)
+	}
+
+	// Still quarantined in later windows
+	clock.t = clock.t.Add(5 * time.Minute)
+	if err := d.Allow("pub", "news"); err != ErrQuarantined {
+		t.Errorf("Expected quarantine to persist, got %v", err)
+	}
+
+	if !d.Release("pub", "") {
+		t.Fatal("Expected release to succeed")
+	}
+	if err := d.Allow("pub", "news"); err != nil {
+		t.Errorf("Expected send after release, got %v", err)
+	}
+
+	// Cooldown expiry
+	for i := 0; i < 3; i++ {
+		_ = d.Allow("pub", "news")
+	}
+	clock.t = clock.t.Add(11 * time.Minute)
+	if err := d.Allow("pub", "news"); err != nil {
+		t.Errorf("Expected quarantine to expire, got %v", err)
+	}
+}
+
+func TestDetector_Exempt(t *testing.T) {
+	d, _ := newTestDetector(Config{MinCount: 1})
+	d.SetExempt("trusted", "", true)
+
+	for i := 0; i < 100; i++ {
+		if err := d.Allow("trusted", "news"); err != nil {
+			t.Fatalf("Exempt publisher was blocked: %v", err)
+		}
+	}
+
+	snap := d.Snapshot()
+	if len(snap) != 1 || !snap[0].Exempt {
+		t.Errorf("Expected exemption in snapshot, got %+v", snap)
+	}
+}
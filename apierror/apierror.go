@@ -0,0 +1,91 @@
+// Package apierror writes the error responses of the HTTP API. Every error
+// has the same envelope: a message for people and a code for programs,
+//
+//	{"error": "Topic not found", "code": "not_found"}
+//
+// plus, for some errors, fields describing them, such as the limit that was
+// exceeded. Codes are stable; messages may change.
+package apierror
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Code is a machine-readable error code.
+type Code string
+
+// Codes of every status the API returns.
+const (
+	BadRequest      Code = "bad_request"
+	Unauthorized    Code = "unauthorized"
+	Forbidden       Code = "forbidden"
+	NotFound        Code = "not_found"
+	Conflict        Code = "conflict"
+	Gone            Code = "gone"
+	PayloadTooLarge Code = "payload_too_large"
+	Unprocessable   Code = "unprocessable"
+	RateLimited     Code = "rate_limited"
+	Internal        Code = "internal_error"
+	Unavailable     Code = "unavailable"
+)
+
+// Codes of errors clients handle specifically.
+const (
+	TwoFactorRequired      Code = "two_factor_required"
+	PasswordChangeRequired Code = "password_change_required"
+	ContentFiltered        Code = "content_filtered"
+	SchemaMismatch         Code = "schema_mismatch"
+	SyncLimitExceeded      Code = "sync_limit_exceeded"
+	QueueFull              Code = "queue_full"
+	TopicLimitReached      Code = "topic_limit_reached"
+)
+
+var statusCodes = map[int]Code{
+	http.StatusBadRequest:            BadRequest,
+	http.StatusUnauthorized:          Unauthorized,
+	http.StatusForbidden:             Forbidden,
+	http.StatusNotFound:              NotFound,
+	http.StatusConflict:              Conflict,
+	http.StatusGone:                  Gone,
+	http.StatusRequestEntityTooLarge: PayloadTooLarge,
+	http.StatusUnprocessableEntity:   Unprocessable,
+	http.StatusTooManyRequests:       RateLimited,
+	http.StatusServiceUnavailable:    Unavailable,
+}
+
+// ForStatus returns the code of errors with an HTTP status.
+func ForStatus(status int) Code {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status < http.StatusInternalServerError {
+		return BadRequest
+	}
+	return Internal
+}
+
+// Body returns the envelope of an error, with fields added to it.
+func Body(code Code, message string, fields gin.H) gin.H {
+	body := gin.H{"error": message, "code": code}
+	for k, v := range fields {
+		body[k] = v
+	}
+	return body
+}
+
+// Respond writes an error with the code of its status.
+func Respond(c *gin.Context, status int, message string) {
+	c.JSON(status, Body(ForStatus(status), message, nil))
+}
+
+// RespondCode writes an error with a specific code and fields describing it.
+func RespondCode(c *gin.Context, status int, code Code, message string, fields gin.H) {
+	c.JSON(status, Body(code, message, fields))
+}
+
+// Abort writes an error with the code of its status and stops the handler chain.
+func Abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, Body(ForStatus(status), message, nil))
+}
@@ -0,0 +1,45 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestForStatus(t *testing.T) {
+	for status, want := range map[int]Code{
+		http.StatusNotFound:            NotFound,
+		http.StatusConflict:            Conflict,
+		http.StatusTooManyRequests:     RateLimited,
+		http.StatusTeapot:              BadRequest,
+		http.StatusInternalServerError: Internal,
+		http.StatusBadGateway:          Internal,
+	} {
+		if got := ForStatus(status); got != want {
+			t.Errorf("ForStatus(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestRespond(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	RespondCode(c, http.StatusServiceUnavailable, QueueFull, "Delivery queue is full", gin.H{"limit": 10})
+
+	var body map[string]any
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusServiceUnavailable || body["error"] != "Delivery queue is full" || body["code"] != "queue_full" || body["limit"] != float64(10) {
+		t.Errorf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	Abort(c, http.StatusNotFound, "Topic not found")
+	if !c.IsAborted() || w.Body.String() != `{"code":"not_found","error":"Topic not found"}` {
+		t.Errorf("Unexpected response %s", w.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+// Package backup takes scheduled database backups and keeps the newest ones
+// in a directory or an S3-compatible bucket.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Source writes a consistent copy of the database to a file.
+type Source interface {
+	BackupTo(path string) error
+}
+
+// Target stores backup files.
+type Target interface {
+	// Upload stores the file at path under name.
+	Upload(ctx context.Context, name, path string) error
+	// List returns the names of stored backups.
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+	String() string
+}
+
+const (
+	namePrefix = "no-spam-"
+	nameSuffix = ".db"
+)
+
+// Name returns the file name of a backup taken at t. Names sort chronologically.
+func Name(t time.Time) string {
+	return namePrefix + t.UTC().Format("20060102T150405Z") + nameSuffix
+}
+
+// isBackup reports whether name looks like a backup created by this package.
+func isBackup(name string) bool {
+	return strings.HasPrefix(name, namePrefix) && strings.HasSuffix(name, nameSuffix)
+}
+
+// Run takes a backup every interval until ctx is done.
+func Run(ctx context.Context, src Source, targets []Target, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Once(ctx, src, targets, keep); err != nil {
+				log.Printf("[Backup] %v", err)
+			}
+		}
+	}
+}
+
+// Once takes a backup, uploads it to every target and deletes all but the
+// newest keep backups from each (keep <= 0 keeps everything). A failing
+// target doesn't stop the others; the first error is returned.
+func Once(ctx context.Context, src Source, targets []Target, keep int) error {
+	f, err := os.CreateTemp("", "no-spam-backup-*.db")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	if err := src.BackupTo(path); err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	name := Name(time.Now())
+	var firstErr error
+	for _, t := range targets {
+		if err := t.Upload(ctx, name, path); err != nil {
+			err = fmt.Errorf("upload to %s failed: %w", t, err)
+			log.Printf("[Backup] %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("[Backup] Stored %s in %s", name, t)
+		if err := prune(ctx, t, keep); err != nil {
+			log.Printf("[Backup] Pruning %s failed: %v", t, err)
+		}
+	}
+	return firstErr
+}
+
+// prune deletes all but the newest keep backups from t.
+func prune(ctx context.Context, t Target, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	names, err := t.List(ctx)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, name := range names {
+		if isBackup(name) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := t.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
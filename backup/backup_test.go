@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fileSource struct {
+	data string
+	err  error
+}
+
+func (f fileSource) BackupTo(path string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return os.WriteFile(path, []byte(f.data), 0600)
+}
+
+func TestOnceDirTarget(t *testing.T) {
+	dir := t.TempDir()
+	// Older backups, one of which should be pruned, and an unrelated file
+	for _, name := range []string{Name(time.Now().Add(-48 * time.Hour)), Name(time.Now().Add(-24 * time.Hour)), "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := DirTarget{Dir: dir}
+	if err := Once(context.Background(), fileSource{data: "snapshot"}, []Target{target}, 2); err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+
+	names, _ := target.List(context.Background())
+	want := map[string]bool{Name(time.Now().Add(-24 * time.Hour)): true, "notes.txt": true}
+	backups := 0
+	for _, name := range names {
+		if isBackup(name) {
+			backups++
+		}
+		if !isBackup(name) && !want[name] {
+			t.Errorf("Unexpected file %s", name)
+		}
+	}
+	if backups != 2 {
+		t.Errorf("Expected 2 backups to be kept, got %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, Name(time.Now().Add(-48*time.Hour)))); !os.IsNotExist(err) {
+		t.Error("Expected the oldest backup to be pruned")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error("Files that aren't backups should be left alone")
+	}
+}
+
+func TestOnceSourceError(t *testing.T) {
+	dir := t.TempDir()
+	err := Once(context.Background(), fileSource{err: errors.New("disk full")}, []Target{DirTarget{Dir: dir}}, 0)
+	if err == nil {
+		t.Fatal("Expected the source error")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Nothing should be stored after a failed backup, got %v", entries)
+	}
+}
+
+func TestNameSortsChronologically(t *testing.T) {
+	earlier := Name(time.Date(2026, 9, 30, 23, 59, 59, 0, time.UTC))
+	later := Name(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	if earlier >= later {
+		t.Errorf("Expected %s < %s", earlier, later)
+	}
+}
@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DirTarget keeps backups in a local directory.
+type DirTarget struct {
+	Dir string
+}
+
+func (d DirTarget) String() string {
+	return d.Dir
+}
+
+// Upload copies the file into the directory, renaming it into place once
+// complete so a partial backup is never left under a backup name.
+func (d DirTarget) Upload(ctx context.Context, name, path string) error {
+	if err := os.MkdirAll(d.Dir, 0700); err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(d.Dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.Dir, name))
+}
+
+func (d DirTarget) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d DirTarget) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(d.Dir, name))
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"context"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config locates a bucket on S3 or an S3-compatible service (MinIO, R2, ...).
+type S3Config struct {
+	Endpoint  string // e.g. "s3.amazonaws.com" or "minio.internal:9000"
+	Bucket    string
+	Prefix    string // Key prefix, e.g. "backups/"
+	AccessKey string
+	SecretKey string
+	Insecure  bool // Plain HTTP
+}
+
+// S3Target keeps backups as objects in a bucket.
+type S3Target struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Target(cfg S3Config) (*S3Target, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: !cfg.Insecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Target{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3Target) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *S3Target) Upload(ctx context.Context, name, path string) error {
+	_, err := s.client.FPutObject(ctx, s.bucket, s.prefix+name, path, minio.PutObjectOptions{
+		ContentType: "application/vnd.sqlite3",
+	})
+	return err
+}
+
+func (s *S3Target) List(ctx context.Context) ([]string, error) {
+	var names []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + namePrefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		names = append(names, strings.TrimPrefix(obj.Key, s.prefix))
+	}
+	return names, nil
+}
+
+func (s *S3Target) Delete(ctx context.Context, name string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"no-spam/config"
+	"no-spam/password"
+	"no-spam/rbac"
+	"no-spam/store"
+)
+
+// bootstrap creates the users and topics listed in the config file that
+// don't exist yet, so provisioning tools can set up a fresh instance without
+// reading the generated admin password from the log. It runs before the
+// admin check, so a bootstrap admin prevents the generated one.
+func bootstrap(s store.Store, b *config.Bootstrap) error {
+	if b == nil {
+		return nil
+	}
+
+	for _, name := range b.Topics {
+		exists, err := s.TopicExists(name)
+		if err != nil {
+			return fmt.Errorf("bootstrap topic %s: %w", name, err)
+		}
+		if exists {
+			continue
+		}
+		if err := s.CreateTopic(name); err != nil {
+			return fmt.Errorf("bootstrap topic %s: %w", name, err)
+		}
+		log.Printf("[Bootstrap] Created topic %s", name)
+	}
+
+	for _, u := range b.Users {
+		existing, err := s.GetUser(u.Username)
+		if err != nil {
+			return fmt.Errorf("bootstrap user %s: %w", u.Username, err)
+		}
+		if existing != nil {
+			continue
+		}
+
+		role := u.Role
+		if role == "" {
+			role = "subscriber"
+		}
+		if _, err := rbac.Permissions(s, role); err != nil {
+			return fmt.Errorf("bootstrap user %s: %w", u.Username, err)
+		}
+		hash := u.PasswordHash
+		if hash == "" {
+			if hash, err = password.Hash(u.Password); err != nil {
+				return fmt.Errorf("bootstrap user %s: %w", u.Username, err)
+			}
+		}
+		if err := s.CreateUser(u.Username, hash, role); err != nil {
+			return fmt.Errorf("bootstrap user %s: %w", u.Username, err)
+		}
+		log.Printf("[Bootstrap] Created user %s (%s)", u.Username, role)
+	}
+	return nil
+}
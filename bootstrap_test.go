@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"no-spam/config"
+	"no-spam/password"
+	"no-spam/store"
+)
+
+func TestBootstrap(t *testing.T) {
+	s := store.NewMemoryStore()
+	s.CreateUser("alice", "existing-hash", "subscriber")
+	hash, _ := password.Hash("publisher-secret")
+
+	b := &config.Bootstrap{
+		Topics: []string{"news", "alerts"},
+		Users: []config.BootstrapUser{
+			{Username: "ops", Password: "ops-secret", Role: "admin"},
+			{Username: "ci", PasswordHash: hash, Role: "publisher"},
+			{Username: "alice", Password: "ignored", Role: "admin"},
+		},
+	}
+	if err := bootstrap(s, b); err != nil {
+		t.Fatalf("bootstrap failed: %v", err)
+	}
+
+	if topics, _ := s.ListTopics(); len(topics) != 2 {
+		t.Errorf("Expected 2 topics, got %v", topics)
+	}
+	ops, _ := s.GetUser("ops")
+	if ops == nil || ops.Role != "admin" || password.Verify(ops.PasswordHash, "ops-secret") != nil {
+		t.Errorf("Unexpected ops user %+v", ops)
+	}
+	if ci, _ := s.GetUser("ci"); ci == nil || ci.PasswordHash != hash {
+		t.Errorf("Expected the given hash to be stored, got %+v", ci)
+	}
+	if alice, _ := s.GetUser("alice"); alice.PasswordHash != "existing-hash" || alice.Role != "subscriber" {
+		t.Errorf("Existing users must not be modified, got %+v", alice)
+	}
+
+	// A bootstrap admin replaces the generated one
+	setupAdminUser(s, nil)
+	if admin, _ := s.GetUser("admin"); admin != nil {
+		t.Error("Expected no generated admin when the bootstrap creates one")
+	}
+
+	// Running again is a no-op
+	if err := bootstrap(s, b); err != nil {
+		t.Errorf("Second bootstrap failed: %v", err)
+	}
+}
+
+func TestBootstrap_UnknownRole(t *testing.T) {
+	s := store.NewMemoryStore()
+	err := bootstrap(s, &config.Bootstrap{Users: []config.BootstrapUser{{Username: "bob", Password: "x", Role: "superuser"}}})
+	if err == nil {
+		t.Fatal("Expected error for an unknown role")
+	}
+	if u, _ := s.GetUser("bob"); u != nil {
+		t.Error("Expected no user to be created")
+	}
+}
@@ -0,0 +1,134 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"no-spam/hub"
+)
+
+// Record is a single event read from an external system such as Kafka.
+type Record struct {
+	Source  string // Source topic/subject the record was read from
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// Reader reads records from an external system.
+type Reader interface {
+	// Read blocks until the next record is available or ctx is done.
+	Read(ctx context.Context) (Record, error)
+	Close() error
+}
+
+// Route maps records from a source to a no-spam topic, optionally
+// transforming the payload with a template.
+type Route struct {
+	Topic    string
+	Template *template.Template
+}
+
+// templateData is passed to payload templates.
+type templateData struct {
+	Source  string
+	Key     string
+	Raw     string
+	Value   interface{} // Record value decoded as JSON, nil if not JSON
+	Headers map[string]string
+}
+
+// Render produces the payload published for rec.
+// Without a template the record value is used as is (quoted if it is not JSON).
+func (r Route) Render(rec Record) (json.RawMessage, error) {
+	if r.Template == nil {
+		return toJSON(rec.Value), nil
+	}
+
+	data := templateData{
+		Source:  rec.Source,
+		Key:     string(rec.Key),
+		Raw:     string(rec.Value),
+		Headers: rec.Headers,
+	}
+	_ = json.Unmarshal(rec.Value, &data.Value)
+
+	var buf bytes.Buffer
+	if err := r.Template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template %s did not produce valid JSON", r.Template.Name())
+	}
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+// LoadRoutes builds routes from source=topic mappings. If dir is set, a
+// template named <source>.tmpl in dir is used to transform that source's payloads.
+func LoadRoutes(mappings map[string]string, dir string) (map[string]Route, error) {
+	routes := map[string]Route{}
+	for source, topic := range mappings {
+		route := Route{Topic: topic}
+		if dir != "" {
+			path := filepath.Join(dir, source+".tmpl")
+			if _, err := os.Stat(path); err == nil {
+				tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse template %s: %w", path, err)
+				}
+				route.Template = tmpl
+			}
+		}
+		routes[source] = route
+	}
+	return routes, nil
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value so it can be embedded safely in the JSON output
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Ingest reads records until ctx is done and publishes each one to its mapped topic.
+func Ingest(ctx context.Context, h *hub.Hub, r Reader, routes map[string]Route, source string) {
+	for {
+		rec, err := r.Read(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("[Ingest] Failed to read from %s: %v", source, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		route, ok := routes[rec.Source]
+		if !ok {
+			continue
+		}
+
+		payload, err := route.Render(rec)
+		if err != nil {
+			log.Printf("[Ingest] Dropping record from %s: %v", rec.Source, err)
+			continue
+		}
+
+		routeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = h.Route(routeCtx, hub.Message{Topic: route.Topic, Payload: payload, Source: source})
+		cancel()
+		if err != nil {
+			log.Printf("[Ingest] Failed to publish record from %s to topic %s: %v", rec.Source, route.Topic, err)
+		}
+	}
+}
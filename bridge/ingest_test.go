@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"no-spam/hub"
+	"no-spam/store"
+)
+
+type fakeReader struct {
+	records []Record
+}
+
+func (f *fakeReader) Read(ctx context.Context) (Record, error) {
+	if len(f.records) == 0 {
+		<-ctx.Done()
+		return Record{}, ctx.Err()
+	}
+	rec := f.records[0]
+	f.records = f.records[1:]
+	return rec, nil
+}
+
+func (f *fakeReader) Close() error { return nil }
+
+func TestRouteRender_Template(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := `{"title": {{json .Value.name}}, "key": {{json .Key}}}`
+	if err := os.WriteFile(filepath.Join(dir, "orders.tmpl"), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := LoadRoutes(map[string]string{"orders": "order-topic", "raw": "raw-topic"}, dir)
+	if err != nil {
+		t.Fatalf("LoadRoutes failed: %v", err)
+	}
+
+	out, err := routes["orders"].Render(Record{Source: "orders", Key: []byte("k1"), Value: []byte(`{"name":"Order #1"}`)})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("Invalid output %s: %v", out, err)
+	}
+	if got["title"] != "Order #1" || got["key"] != "k1" {
+		t.Errorf("Unexpected output: %v", got)
+	}
+
+	// No template: value passes through
+	out, _ = routes["raw"].Render(Record{Value: []byte("hello")})
+	if string(out) != `"hello"` {
+		t.Errorf("Expected quoted raw value, got %s", out)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	s, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hub.NewHub(s)
+	h.CreateTopic("events")
+
+	r := &fakeReader{records: []Record{
+		{Source: "backend.events", Value: []byte(`{"a":1}`)},
+		{Source: "unmapped", Value: []byte(`{"b":2}`)},
+	}}
+	routes := map[string]Route{"backend.events": {Topic: "events"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	Ingest(ctx, h, r, routes, "kafka")
+
+	msgs, err := s.GetRecentMessages("events", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 ingested message, got %d", len(msgs))
+	}
+}
@@ -0,0 +1,53 @@
+//go:build kafka
+
+package bridge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaReader reads records from Kafka topics as part of a consumer group.
+type KafkaReader struct {
+	reader *kafka.Reader
+}
+
+// NewKafkaReader creates a consumer group reader for topics.
+func NewKafkaReader(brokers []string, groupID string, topics []string) (Reader, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no kafka brokers configured")
+	}
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		GroupID:     groupID,
+		GroupTopics: topics,
+	})
+	return &KafkaReader{reader: r}, nil
+}
+
+// Read fetches the next record and commits its offset.
+func (k *KafkaReader) Read(ctx context.Context) (Record, error) {
+	m, err := k.reader.ReadMessage(ctx)
+	if err != nil {
+		return Record{}, err
+	}
+
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	return Record{
+		Source:  m.Topic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}, nil
+}
+
+// Close closes the reader.
+func (k *KafkaReader) Close() error {
+	return k.reader.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !kafka
+
+package bridge
+
+import "errors"
+
+// NewKafkaReader is unavailable unless the binary is built with -tags kafka.
+func NewKafkaReader(brokers []string, groupID string, topics []string) (Reader, error) {
+	return nil, errors.New("kafka support not compiled in; rebuild with -tags kafka")
+}
@@ -0,0 +1,107 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"no-spam/hub"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultNATSSubjectPrefix is the subject prefix used when publishing accepted messages.
+const DefaultNATSSubjectPrefix = "no-spam.topics"
+
+// NATSBridge publishes every accepted message to NATS and publishes messages
+// received on mapped NATS subjects to no-spam topics.
+type NATSBridge struct {
+	conn   *nats.Conn
+	prefix string
+	subs   []*nats.Subscription
+}
+
+// NewNATSBridge connects to the NATS server at url.
+func NewNATSBridge(url, prefix string) (*NATSBridge, error) {
+	if prefix == "" {
+		prefix = DefaultNATSSubjectPrefix
+	}
+
+	conn, err := nats.Connect(url, nats.Name("no-spam"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+
+	return &NATSBridge{conn: conn, prefix: prefix}, nil
+}
+
+// Subject returns the NATS subject accepted messages for topic are published to.
+func (b *NATSBridge) Subject(topic string) string {
+	return b.prefix + "." + topic
+}
+
+// Attach registers the bridge with the hub: accepted messages are published
+// to NATS, and each subject in mappings is consumed into its mapped topic.
+func (b *NATSBridge) Attach(h *hub.Hub, mappings map[string]string) error {
+	h.OnPublish(func(msg hub.Message, messageID int64) {
+		// Don't echo messages that came from NATS back onto NATS
+		if msg.Source == "nats" {
+			return
+		}
+		if err := b.conn.Publish(b.Subject(msg.Topic), msg.Payload); err != nil {
+			log.Printf("[NATS] Failed to publish message %d: %v", messageID, err)
+		}
+	})
+
+	for subject, topic := range mappings {
+		topic := topic
+		sub, err := b.conn.Subscribe(subject, func(m *nats.Msg) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			msg := hub.Message{Topic: topic, Payload: toJSON(m.Data), Source: "nats"}
+			if err := h.Route(ctx, msg); err != nil {
+				log.Printf("[NATS] Failed to route message from %s to topic %s: %v", m.Subject, topic, err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+		}
+		b.subs = append(b.subs, sub)
+		log.Printf("[NATS] Bridging subject %s to topic %s", subject, topic)
+	}
+	return nil
+}
+
+// Close drains subscriptions and closes the connection.
+func (b *NATSBridge) Close() error {
+	return b.conn.Drain()
+}
+
+// toJSON returns data unchanged if it is valid JSON, otherwise as a JSON string.
+func toJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	quoted, _ := json.Marshal(string(data))
+	return json.RawMessage(quoted)
+}
+
+// ParseMappings parses "source=topic,source2=topic2" into a map.
+func ParseMappings(s string) (map[string]string, error) {
+	mappings := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return mappings, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected source=topic", pair)
+		}
+		mappings[parts[0]] = parts[1]
+	}
+	return mappings, nil
+}
@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestParseMappings(t *testing.T) {
+	m, err := ParseMappings("orders.created=orders, alerts.*=alerts")
+	if err != nil {
+		t.Fatalf("ParseMappings failed: %v", err)
+	}
+	if m["orders.created"] != "orders" || m["alerts.*"] != "alerts" {
+		t.Errorf("Unexpected mappings: %v", m)
+	}
+
+	if m, err := ParseMappings(""); err != nil || len(m) != 0 {
+		t.Errorf("Expected empty mappings, got %v, %v", m, err)
+	}
+
+	if _, err := ParseMappings("missing-topic"); err == nil {
+		t.Error("Expected error for invalid mapping")
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	if got := string(toJSON([]byte(`{"a":1}`))); got != `{"a":1}` {
+		t.Errorf("Valid JSON should pass through, got %s", got)
+	}
+	if got := string(toJSON([]byte("plain text"))); got != `"plain text"` {
+		t.Errorf("Plain text should be quoted, got %s", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	opts := certOptions{
+		Hosts:    []string{"push.lab.example", "10.1.2.3"},
+		KeyType:  "ecdsa",
+		Validity: 30 * 24 * time.Hour,
+	}
+	if err := generateSelfSignedCert(certPath, keyPath, opts); err != nil {
+		t.Fatalf("generateSelfSignedCert failed: %v", err)
+	}
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Generated pair doesn't load: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Errorf("Expected ECDSA key, got %T", cert.PublicKey)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1", "push.lab.example", "10.1.2.3"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("Expected certificate to cover %s: %v", host, err)
+		}
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity < 29*24*time.Hour || validity > 31*24*time.Hour {
+		t.Errorf("Expected 30 day validity, got %v", validity)
+	}
+
+	if err := generateSelfSignedCert(certPath, keyPath, certOptions{KeyType: "dsa"}); err == nil {
+		t.Error("Expected error for unsupported key type")
+	}
+}
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Bus is a pub/sub channel shared by all nodes in a cluster.
+type Bus interface {
+	// Publish sends data to every subscriber of channel, on every node.
+	Publish(ctx context.Context, channel string, data []byte) error
+	// Subscribe calls handler for each message published to channel until ctx is done.
+	Subscribe(ctx context.Context, channel string, handler func(data []byte)) error
+	// Close releases resources held by the bus.
+	Close() error
+}
+
+// DefaultNodeID returns an identifier for this process, based on the hostname and PID.
+func DefaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "node"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// LocalBus is an in-process Bus, used for single-node deployments and tests.
+type LocalBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]func([]byte)
+}
+
+// NewLocalBus creates an empty LocalBus.
+func NewLocalBus() *LocalBus {
+	return &LocalBus{handlers: map[string][]func([]byte){}}
+}
+
+// Publish delivers data synchronously to local subscribers.
+func (b *LocalBus) Publish(ctx context.Context, channel string, data []byte) error {
+	b.mu.RLock()
+	handlers := append([]func([]byte){}, b.handlers[channel]...)
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(data)
+	}
+	return nil
+}
+
+// Subscribe registers handler for channel.
+func (b *LocalBus) Subscribe(ctx context.Context, channel string, handler func([]byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[channel] = append(b.handlers[channel], handler)
+	return nil
+}
+
+// Close is a no-op for LocalBus.
+func (b *LocalBus) Close() error {
+	return nil
+}
@@ -0,0 +1,30 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLocalBus_PublishSubscribe(t *testing.T) {
+	b := NewLocalBus()
+	ctx := context.Background()
+
+	var got []string
+	b.Subscribe(ctx, "events", func(data []byte) { got = append(got, string(data)) })
+	b.Subscribe(ctx, "other", func(data []byte) { t.Error("Unexpected message on other channel") })
+
+	if err := b.Publish(ctx, "events", []byte("hello")); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != "hello" {
+		t.Errorf("Expected [hello], got %v", got)
+	}
+}
+
+func TestDefaultNodeID(t *testing.T) {
+	id := DefaultNodeID()
+	if id == "" || !strings.Contains(id, "-") {
+		t.Errorf("Unexpected node ID: %q", id)
+	}
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBus is a Bus backed by Redis PUBLISH/SUBSCRIBE.
+type RedisBus struct {
+	client *redis.Client
+}
+
+// NewRedisBus connects to the Redis server at addr and verifies the connection.
+func NewRedisBus(addr, password string, db int) (*RedisBus, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RedisBus{client: client}, nil
+}
+
+// Publish publishes data on channel.
+func (b *RedisBus) Publish(ctx context.Context, channel string, data []byte) error {
+	return b.client.Publish(ctx, channel, data).Err()
+}
+
+// Subscribe starts a goroutine that calls handler for every message on channel.
+func (b *RedisBus) Subscribe(ctx context.Context, channel string, handler func([]byte)) error {
+	sub := b.client.Subscribe(ctx, channel)
+	// Wait for the subscription to be confirmed so no message is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					log.Printf("[Cluster] Subscription to %s closed", channel)
+					return
+				}
+				handler([]byte(msg.Payload))
+			}
+		}
+	}()
+	return nil
+}
+
+// Close closes the Redis client.
+func (b *RedisBus) Close() error {
+	return b.client.Close()
+}
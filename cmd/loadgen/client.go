@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// client calls the no-spam API with an admin token.
+type client struct {
+	base  string // e.g. https://localhost:8443/v1
+	token string
+	http  *http.Client
+}
+
+// apiError is a non-2xx response of the API.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+// do sends a JSON request and decodes a JSON response into out, if set.
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &e)
+		return &apiError{Status: resp.StatusCode, Message: e.Error}
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (c *client) login(ctx context.Context, username, password string) error {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/admin/login", map[string]string{"username": username, "password": password}, &resp); err != nil {
+		return err
+	}
+	c.token = resp.Token
+	return nil
+}
+
+func (c *client) createTopic(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodPost, "/admin/topics", map[string]string{"name": name}, nil)
+	if e, ok := err.(*apiError); ok && e.Status == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+func (c *client) subscribe(ctx context.Context, topic, provider, token string) error {
+	return c.do(ctx, http.MethodPost, "/subscribe", map[string]string{"topic": topic, "provider": provider, "token": token}, nil)
+}
+
+func (c *client) send(ctx context.Context, topic string, payload []byte) error {
+	return c.do(ctx, http.MethodPost, "/send", map[string]any{"topic": topic, "payload": json.RawMessage(payload)}, nil)
+}
+
+// topicLatency is a topic's entry in /admin/stats/latency.
+type topicLatency struct {
+	Topic       string  `json:"topic"`
+	Delivered   int     `json:"delivered"`
+	Failed      int     `json:"failed"`
+	FailureRate float64 `json:"failure_rate"`
+	P50Ms       int64   `json:"p50_ms"`
+	P95Ms       int64   `json:"p95_ms"`
+	P99Ms       int64   `json:"p99_ms"`
+}
+
+func (c *client) latency(ctx context.Context, window string) ([]topicLatency, error) {
+	var resp struct {
+		Topics []topicLatency `json:"topics"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/stats/latency?window="+url.QueryEscape(window), nil, &resp)
+	return resp.Topics, err
+}
+
+// deleteTopic removes a topic with its subscribers and messages.
+func (c *client) deleteTopic(ctx context.Context, name string) error {
+	path := "/admin/topics/" + url.PathEscape(name)
+	for _, p := range []string{path + "/subscribers", path + "/messages", path} {
+		if err := c.do(ctx, http.MethodDelete, p, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
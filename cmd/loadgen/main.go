@@ -0,0 +1,312 @@
+// Command loadgen measures a running no-spam server under load. It creates
+// topics with subscribers, publishes to them at a fixed rate and reports
+// publish latency, end-to-end delivery latency and error rates, so
+// performance regressions in the hub and store show up as numbers.
+//
+// Webhook subscribers point at a receiver run by loadgen, which timestamps
+// each delivery; the server must be started with -webhook-allow-private to
+// deliver to it. Mock subscribers are only measured by the server, through
+// GET /admin/stats/latency.
+//
+// Usage:
+//
+//	loadgen [-url https://localhost:8443] [-user admin -password ...] [-topics 10] [-subscribers 100] [-rate 100] [-duration 30s]
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// probe is the payload published by loadgen.
+type probe struct {
+	Loadgen struct {
+		Run    string `json:"run"`
+		Seq    int64  `json:"seq"`
+		SentAt int64  `json:"sent_at"` // Unix nanoseconds
+	} `json:"loadgen"`
+}
+
+func main() {
+	serverURL := flag.String("url", "https://localhost:8443", "Base URL of the server")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification, e.g. for the auto-generated certificate")
+	username := flag.String("user", "admin", "Admin username")
+	password := flag.String("password", os.Getenv("NOSPAM_PASSWORD"), "Admin password (default $NOSPAM_PASSWORD)")
+	token := flag.String("token", "", "Admin JWT, instead of -user and -password")
+	topics := flag.Int("topics", 10, "Topics to create")
+	subscribers := flag.Int("subscribers", 100, "Subscribers per topic")
+	provider := flag.String("provider", "webhook", "Provider of the subscribers: webhook or mock")
+	rate := flag.Float64("rate", 100, "Publishes per second, spread over the topics")
+	duration := flag.Duration("duration", 30*time.Second, "How long to publish")
+	concurrency := flag.Int("concurrency", 16, "Publishes in flight at most")
+	listen := flag.String("listen", "127.0.0.1:9090", "Address of the webhook receiver")
+	receiverURL := flag.String("receiver-url", "", "URL the server reaches the webhook receiver at (default http://<listen>)")
+	wait := flag.Duration("wait", 30*time.Second, "How long to wait for outstanding deliveries after publishing")
+	prefix := flag.String("prefix", "loadgen", "Prefix of the topics created")
+	keep := flag.Bool("keep", false, "Keep the topics and subscribers instead of deleting them")
+	flag.Parse()
+
+	if *topics <= 0 || *subscribers <= 0 || *rate <= 0 || *concurrency <= 0 {
+		log.Fatal("-topics, -subscribers, -rate and -concurrency must be positive")
+	}
+	if *provider != "webhook" && *provider != "mock" {
+		log.Fatalf("Unknown provider %q, expected webhook or mock", *provider)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency
+	if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	c := &client{
+		base:  strings.TrimSuffix(*serverURL, "/") + "/v1",
+		token: *token,
+		http:  &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+	ctx := context.Background()
+	if c.token == "" {
+		if err := c.login(ctx, *username, *password); err != nil {
+			log.Fatalf("Login failed: %v", err)
+		}
+	}
+
+	run := fmt.Sprintf("%x", time.Now().UnixNano())
+	rcv := &receiver{run: run}
+	if *provider == "webhook" {
+		ln, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatalf("Failed to start the webhook receiver: %v", err)
+		}
+		go http.Serve(ln, rcv)
+		if *receiverURL == "" {
+			*receiverURL = "http://" + ln.Addr().String()
+		}
+	}
+
+	names := make([]string, *topics)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%s-%d", *prefix, run, i)
+	}
+	log.Printf("Creating %d topics with %d %s subscribers each", *topics, *subscribers, *provider)
+	if err := setup(ctx, c, names, *subscribers, *provider, *receiverURL, *concurrency); err != nil {
+		log.Fatalf("Setup failed: %v", err)
+	}
+	if !*keep {
+		defer func() {
+			for _, name := range names {
+				if err := c.deleteTopic(ctx, name); err != nil {
+					log.Printf("Failed to delete %s: %v", name, err)
+				}
+			}
+		}()
+	}
+
+	log.Printf("Publishing %.0f/s for %s", *rate, *duration)
+	start := time.Now()
+	pub := publish(ctx, c, run, names, *rate, *duration, *concurrency)
+	published := time.Since(start)
+
+	expected := pub.ok.Load() * int64(*subscribers)
+	if *provider == "webhook" {
+		log.Printf("Waiting up to %s for %d deliveries", *wait, expected)
+		deadline := time.Now().Add(*wait)
+		for rcv.count() < expected && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+	} else {
+		time.Sleep(min(*wait, 10*time.Second)) // Let the queue drain before asking the server
+	}
+
+	fmt.Printf("\nPublished %d messages in %s (%.1f/s), %d failed\n",
+		pub.ok.Load()+pub.failed.Load(), published.Round(time.Millisecond), float64(pub.ok.Load())/published.Seconds(), pub.failed.Load())
+	for reason, n := range pub.errorCounts() {
+		fmt.Printf("  %6d  %s\n", n, reason)
+	}
+	printPercentiles("Publish latency", pub.latencies())
+
+	if *provider == "webhook" {
+		received := rcv.count()
+		missing := max(expected-received, 0)
+		fmt.Printf("\nReceived %d of %d deliveries (%.2f%% missing)\n", received, expected, percent(missing, expected))
+		printPercentiles("End-to-end latency", rcv.latencies())
+	}
+
+	window := (time.Since(start) + time.Minute).Round(time.Minute).String()
+	stats, err := c.latency(ctx, window)
+	if err != nil {
+		log.Printf("Failed to read server latency stats: %v", err)
+		return
+	}
+	fmt.Printf("\nServer-side queue-to-delivery latency (%s window):\n", window)
+	fmt.Printf("  %-40s %9s %7s %8s %8s %8s\n", "topic", "delivered", "failed", "p50", "p95", "p99")
+	for _, t := range stats {
+		if slices.Contains(names, t.Topic) {
+			fmt.Printf("  %-40s %9d %7d %6dms %6dms %6dms\n", t.Topic, t.Delivered, t.Failed, t.P50Ms, t.P95Ms, t.P99Ms)
+		}
+	}
+}
+
+// setup creates the topics and their subscribers.
+func setup(ctx context.Context, c *client, names []string, subscribers int, provider, receiverURL string, concurrency int) error {
+	for _, name := range names {
+		if err := c.createTopic(ctx, name); err != nil {
+			return fmt.Errorf("create topic %s: %w", name, err)
+		}
+	}
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, name := range names {
+		for i := 0; i < subscribers; i++ {
+			token := fmt.Sprintf("%s-%d", name, i)
+			if provider == "webhook" {
+				token = fmt.Sprintf("%s/%s/%d", receiverURL, name, i)
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				if err := c.subscribe(ctx, name, provider, token); err != nil {
+					errOnce.Do(func() { firstErr = fmt.Errorf("subscribe to %s: %w", name, err) })
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// publishResult collects the outcome of publishes.
+type publishResult struct {
+	ok, failed atomic.Int64
+
+	mu     sync.Mutex
+	ms     []float64
+	errors map[string]int64
+}
+
+func (r *publishResult) record(took time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.failed.Add(1)
+		reason := err.Error()
+		var e *apiError
+		if errors.As(err, &e) {
+			reason = fmt.Sprintf("HTTP %d: %s", e.Status, e.Message)
+		}
+		r.errors[reason]++
+		return
+	}
+	r.ok.Add(1)
+	r.ms = append(r.ms, float64(took)/float64(time.Millisecond))
+}
+
+func (r *publishResult) latencies() []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.ms)
+}
+
+func (r *publishResult) errorCounts() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errors
+}
+
+// publish sends probes round-robin to the topics at rate per second for
+// duration, with at most concurrency in flight. A publish that can't start
+// on time is sent late rather than skipped.
+func publish(ctx context.Context, c *client, run string, names []string, rate float64, duration time.Duration, concurrency int) *publishResult {
+	r := &publishResult{errors: map[string]int64{}}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	interval := time.Duration(float64(time.Second) / rate)
+	start := time.Now()
+	for seq := int64(0); time.Since(start) < duration; seq++ {
+		if d := time.Until(start.Add(time.Duration(seq) * interval)); d > 0 {
+			time.Sleep(d)
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			var p probe
+			p.Loadgen.Run, p.Loadgen.Seq, p.Loadgen.SentAt = run, seq, time.Now().UnixNano()
+			payload, _ := json.Marshal(p)
+			sent := time.Now()
+			err := c.send(ctx, names[seq%int64(len(names))], payload)
+			r.record(time.Since(sent), err)
+		}()
+	}
+	wg.Wait()
+	return r
+}
+
+// receiver records the latency of the probes delivered to it by webhook.
+type receiver struct {
+	run string
+
+	mu sync.Mutex
+	ms []float64
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	var p probe
+	if err := json.NewDecoder(req.Body).Decode(&p); err != nil || p.Loadgen.Run != rcv.run {
+		http.Error(w, "not a probe of this run", http.StatusBadRequest)
+		return
+	}
+	rcv.mu.Lock()
+	rcv.ms = append(rcv.ms, float64(now.UnixNano()-p.Loadgen.SentAt)/float64(time.Millisecond))
+	rcv.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rcv *receiver) count() int64 {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return int64(len(rcv.ms))
+}
+
+func (rcv *receiver) latencies() []float64 {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return slices.Clone(rcv.ms)
+}
+
+func printPercentiles(label string, ms []float64) {
+	if len(ms) == 0 {
+		fmt.Printf("%s: no samples\n", label)
+		return
+	}
+	slices.Sort(ms)
+	at := func(p float64) float64 {
+		return ms[min(int(p*float64(len(ms))), len(ms)-1)]
+	}
+	fmt.Printf("%s: p50 %.1fms, p95 %.1fms, p99 %.1fms, max %.1fms\n", label, at(0.50), at(0.95), at(0.99), ms[len(ms)-1])
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
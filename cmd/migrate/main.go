@@ -0,0 +1,94 @@
+// Command migrate inspects and changes the schema version of a no-spam
+// SQLite database. The server migrates to the latest version on startup;
+// this tool is for checking status and rolling back.
+//
+// Usage:
+//
+//	migrate [-db no-spam.db] status
+//	migrate [-db no-spam.db] up [version]
+//	migrate [-db no-spam.db] down [version]
+//
+// up defaults to the latest version. down defaults to one version back, and
+// down 0 reverts everything, deleting all data.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"no-spam/store"
+)
+
+func main() {
+	dbPath := flag.String("db", "no-spam.db", "Path to the SQLite database")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-db path] status | up [version] | down [version]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := store.OpenSQLiteDB(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *dbPath, err)
+	}
+	defer db.Close()
+
+	m, err := store.NewSQLiteMigrator(db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	current, err := m.Version()
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+
+	target := -1
+	if flag.NArg() == 2 {
+		if target, err = strconv.Atoi(flag.Arg(1)); err != nil || target < 0 {
+			log.Fatalf("Invalid version %q", flag.Arg(1))
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			log.Fatalf("Failed to read status: %v", err)
+		}
+		fmt.Printf("Current version: %d (latest %d)\n", current, m.Latest())
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, s.Name, applied)
+		}
+		return
+	case "up":
+		if target < 0 {
+			target = 0 // Latest
+		}
+		err = m.Up(target)
+	case "down":
+		if target < 0 {
+			target = m.Previous(current)
+		}
+		err = m.Down(target)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	after, _ := m.Version()
+	fmt.Printf("Schema version %d -> %d\n", current, after)
+}
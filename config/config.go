@@ -0,0 +1,242 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/errtrack"
+	"no-spam/events"
+	"no-spam/middleware"
+	"no-spam/notification"
+	"no-spam/password"
+	"no-spam/sso"
+)
+
+// File is the optional JSON configuration file passed with -config.
+type File struct {
+	Connectors    []connectors.Config             `json:"connectors"`
+	RateLimits    map[string]connectors.RateLimit `json:"rate_limits"` // Keyed by provider name
+	WebhookPolicy *connectors.URLPolicyConfig     `json:"webhook_policy"`
+	OIDC          *sso.Config                     `json:"oidc"`
+	Tokens        *TokenConfig                    `json:"tokens"`
+	Passwords     *PasswordConfig                 `json:"passwords"`
+	Bootstrap     *Bootstrap                      `json:"bootstrap"`
+	Hooks         []events.Hook                   `json:"hooks"`
+	Listeners     []Listener                      `json:"listeners"`  // Replace -addr when set
+	Categories    notification.Categories         `json:"categories"` // Platform mappings of notification categories
+	ErrorTracking *errtrack.Config                `json:"error_tracking"`
+	LogLevel      string                          `json:"log_level"` // Minimum level of structured logs: debug, info (default), warn or error
+	Retention     *Retention                      `json:"retention"`
+}
+
+// Retention sets how long data is kept, overriding the matching flags.
+type Retention struct {
+	StaleSubscriptionDays *int `json:"stale_subscription_days"` // Overrides -stale-subscription-days
+}
+
+// Listener is an address the server accepts connections on.
+type Listener struct {
+	Network string `json:"network"` // "tcp" (default) or "unix"
+	Address string `json:"address"` // host:port, or the socket path for unix
+	Mode    string `json:"mode"`    // Octal permissions of a unix socket, e.g. "0660"
+	TLS     *bool  `json:"tls"`     // Defaults to TLS unless -http for tcp; plain HTTP for unix
+}
+
+// FileMode parses Mode, returning 0 if unset.
+func (l Listener) FileMode() (os.FileMode, error) {
+	if l.Mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(l.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q", l.Mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// TokenConfig sets access-token lifetimes as Go durations, e.g. "24h".
+type TokenConfig struct {
+	Lifetime string            `json:"lifetime"` // Default for all roles
+	Max      string            `json:"max"`      // Cap, also applied to GET /admin/token?expires_in=
+	Roles    map[string]string `json:"roles"`    // Per-role lifetimes
+}
+
+// Policy parses the configured durations.
+func (t TokenConfig) Policy() (middleware.TokenPolicy, error) {
+	var p middleware.TokenPolicy
+	var err error
+	if p.Lifetime, err = parseDuration(t.Lifetime); err != nil {
+		return p, fmt.Errorf("tokens.lifetime: %w", err)
+	}
+	if p.Max, err = parseDuration(t.Max); err != nil {
+		return p, fmt.Errorf("tokens.max: %w", err)
+	}
+	p.Roles = make(map[string]time.Duration, len(t.Roles))
+	for role, v := range t.Roles {
+		if p.Roles[role], err = parseDuration(v); err != nil {
+			return p, fmt.Errorf("tokens.roles.%s: %w", role, err)
+		}
+	}
+	return p, nil
+}
+
+// PasswordConfig sets how new password hashes are made. Existing hashes keep
+// working and are upgraded on the user's next login.
+type PasswordConfig struct {
+	Algorithm  string `json:"algorithm"`   // "argon2id" (default) or "bcrypt"
+	BcryptCost int    `json:"bcrypt_cost"` // Default 10
+	Argon2     struct {
+		MemoryKiB   uint32 `json:"memory_kib"`  // Default 19456
+		Iterations  uint32 `json:"iterations"`  // Default 2
+		Parallelism uint8  `json:"parallelism"` // Default 1
+	} `json:"argon2"`
+}
+
+// Policy converts the config into a password hashing policy.
+func (p PasswordConfig) Policy() (password.Policy, error) {
+	policy := password.Policy{
+		Algorithm:  p.Algorithm,
+		BcryptCost: p.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      p.Argon2.MemoryKiB,
+			Iterations:  p.Argon2.Iterations,
+			Parallelism: p.Argon2.Parallelism,
+		},
+	}
+	if err := policy.Validate(); err != nil {
+		return policy, fmt.Errorf("passwords: %w", err)
+	}
+	return policy, nil
+}
+
+// Bootstrap lists users and topics created at startup if they don't exist
+// yet. Existing ones are never modified.
+type Bootstrap struct {
+	Users  []BootstrapUser `json:"users"`
+	Topics []string        `json:"topics"`
+}
+
+// BootstrapUser is a user to create, with either a plaintext password or a
+// hash made by the password package.
+type BootstrapUser struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"` // Default subscriber
+}
+
+func (b Bootstrap) validate() error {
+	for i, u := range b.Users {
+		if u.Username == "" {
+			return fmt.Errorf("bootstrap.users %d: missing username", i)
+		}
+		if (u.Password == "") == (u.PasswordHash == "") {
+			return fmt.Errorf("bootstrap.users %s: set exactly one of password and password_hash", u.Username)
+		}
+		if u.PasswordHash != "" {
+			// Any password other than the hashed one is a mismatch; other errors mean a malformed hash
+			if err := password.Verify(u.PasswordHash, ""); !errors.Is(err, password.ErrMismatch) {
+				return fmt.Errorf("bootstrap.users %s: invalid password_hash", u.Username)
+			}
+		}
+	}
+	for i, t := range b.Topics {
+		if t == "" {
+			return fmt.Errorf("bootstrap.topics %d: empty name", i)
+		}
+	}
+	return nil
+}
+
+func parseDuration(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", v)
+	}
+	return d, nil
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	for i, c := range f.Connectors {
+		if c.Type == "" {
+			return nil, fmt.Errorf("connector %d: missing type", i)
+		}
+	}
+	for i, l := range f.Listeners {
+		if l.Network != "" && l.Network != "tcp" && l.Network != "unix" {
+			return nil, fmt.Errorf("listener %d: unsupported network %q", i, l.Network)
+		}
+		if l.Address == "" {
+			return nil, fmt.Errorf("listener %d: missing address", i)
+		}
+		if _, err := l.FileMode(); err != nil {
+			return nil, fmt.Errorf("listener %d: %w", i, err)
+		}
+	}
+	if f.Tokens != nil {
+		if _, err := f.Tokens.Policy(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Passwords != nil {
+		if _, err := f.Passwords.Policy(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Bootstrap != nil {
+		if err := f.Bootstrap.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for i, h := range f.Hooks {
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("hook %d: %w", i, err)
+		}
+	}
+	if f.ErrorTracking != nil {
+		if err := f.ErrorTracking.Validate(); err != nil {
+			return nil, fmt.Errorf("error_tracking: %w", err)
+		}
+	}
+	if f.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(f.LogLevel)); err != nil {
+			return nil, fmt.Errorf("log_level: %w", err)
+		}
+	}
+	if f.Retention != nil && f.Retention.StaleSubscriptionDays != nil && *f.Retention.StaleSubscriptionDays < 0 {
+		return nil, fmt.Errorf("retention: stale_subscription_days must not be negative")
+	}
+	for name, c := range f.Categories {
+		if !notification.ValidCategory(name) {
+			return nil, fmt.Errorf("category %q: invalid name", name)
+		}
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("category %q: %w", name, err)
+		}
+	}
+	return &f, nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `{
+		"connectors": [
+			{"type": "http", "name": "sms", "settings": {"url": "http://sms-gateway/send"}}
+		]
+	}`)
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(f.Connectors) != 1 || f.Connectors[0].Name != "sms" || f.Connectors[0].Settings["url"] == "" {
+		t.Errorf("Unexpected connectors: %+v", f.Connectors)
+	}
+}
+
+func TestTokenConfig(t *testing.T) {
+	f, err := Load(writeConfig(t, `{"tokens": {"lifetime": "12h", "max": "720h", "roles": {"subscriber": "168h"}}}`))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	p, err := f.Tokens.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Lifetime != 12*time.Hour || p.Max != 720*time.Hour || p.Roles["subscriber"] != 168*time.Hour {
+		t.Errorf("Unexpected policy: %+v", p)
+	}
+}
+
+func TestPasswordConfig(t *testing.T) {
+	f, err := Load(writeConfig(t, `{"passwords": {"algorithm": "argon2id", "argon2": {"memory_kib": 65536, "iterations": 3, "parallelism": 2}}}`))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	p, err := f.Passwords.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Algorithm != "argon2id" || p.Argon2.Memory != 65536 || p.Argon2.Iterations != 3 || p.Argon2.Parallelism != 2 {
+		t.Errorf("Unexpected policy: %+v", p)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+	if _, err := Load(writeConfig(t, `{not json`)); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+	if _, err := Load(writeConfig(t, `{"connectors": [{"name": "x"}]}`)); err == nil {
+		t.Error("Expected error for connector without type")
+	}
+	if _, err := Load(writeConfig(t, `{"listeners": [{"network": "udp", "address": ":53"}]}`)); err == nil {
+		t.Error("Expected error for unsupported listener network")
+	}
+	if _, err := Load(writeConfig(t, `{"listeners": [{"network": "unix", "address": "/tmp/x.sock", "mode": "rw"}]}`)); err == nil {
+		t.Error("Expected error for invalid socket mode")
+	}
+	if _, err := Load(writeConfig(t, `{"tokens": {"roles": {"admin": "forever"}}}`)); err == nil {
+		t.Error("Expected error for invalid token lifetime")
+	}
+	if _, err := Load(writeConfig(t, `{"bootstrap": {"users": [{"username": "ops", "role": "admin"}]}}`)); err == nil {
+		t.Error("Expected error for bootstrap user without a password")
+	}
+	if _, err := Load(writeConfig(t, `{"bootstrap": {"users": [{"username": "ops", "password": "x", "password_hash": "y"}]}}`)); err == nil {
+		t.Error("Expected error for bootstrap user with both password and hash")
+	}
+	if _, err := Load(writeConfig(t, `{"bootstrap": {"topics": [""]}}`)); err == nil {
+		t.Error("Expected error for empty bootstrap topic")
+	}
+	if _, err := Load(writeConfig(t, `{"passwords": {"algorithm": "md5"}}`)); err == nil {
+		t.Error("Expected error for unknown password algorithm")
+	}
+	if _, err := Load(writeConfig(t, `{"passwords": {"algorithm": "bcrypt", "bcrypt_cost": 40}}`)); err == nil {
+		t.Error("Expected error for out of range bcrypt cost")
+	}
+	if _, err := Load(writeConfig(t, `{"hooks": [{"url": "https://ops.example.com/hook", "topic": "ops"}]}`)); err == nil {
+		t.Error("Expected error for hook with both url and topic")
+	}
+	if _, err := Load(writeConfig(t, `{"hooks": [{"topic": "ops", "events": ["user.renamed"]}]}`)); err == nil {
+		t.Error("Expected error for hook with an unknown event")
+	}
+	if _, err := Load(writeConfig(t, `{"categories": {"Marketing": {}}}`)); err == nil {
+		t.Error("Expected error for an invalid category name")
+	}
+	if _, err := Load(writeConfig(t, `{"categories": {"security": {"interruption_level": "loud"}}}`)); err == nil {
+		t.Error("Expected error for an unknown interruption level")
+	}
+	if _, err := Load(writeConfig(t, `{"error_tracking": {"dsn": "not a dsn"}}`)); err == nil {
+		t.Error("Expected error for an invalid error tracking DSN")
+	}
+	if _, err := Load(writeConfig(t, `{"log_level": "loud"}`)); err == nil {
+		t.Error("Expected error for an unknown log level")
+	}
+	if _, err := Load(writeConfig(t, `{"retention": {"stale_subscription_days": -1}}`)); err == nil {
+		t.Error("Expected error for a negative stale subscription retention")
+	}
+}
+
+func TestBootstrapConfig(t *testing.T) {
+	f, err := Load(writeConfig(t, `{"bootstrap": {
+		"users": [{"username": "ops", "password": "secret", "role": "admin"}],
+		"topics": ["news"]
+	}}`))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(f.Bootstrap.Users) != 1 || f.Bootstrap.Users[0].Role != "admin" || len(f.Bootstrap.Topics) != 1 {
+		t.Errorf("Unexpected bootstrap: %+v", f.Bootstrap)
+	}
+
+	if _, err := Load(writeConfig(t, `{"bootstrap": {"users": [{"username": "ops", "password_hash": "not-a-hash"}]}}`)); err == nil {
+		t.Error("Expected error for a malformed password hash")
+	}
+}
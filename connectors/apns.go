@@ -2,7 +2,10 @@ package connectors
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+
+	"no-spam/notification"
 )
 
 // APNSConnector is a skeleton for Apple Push Notification Service.
@@ -17,7 +20,42 @@ func NewAPNSConnector() *APNSConnector {
 
 // Send sends a message via APNS.
 func (a *APNSConnector) Send(ctx context.Context, token string, payload []byte) error {
+	payload, err := apnsPayload(payload)
+	if err != nil {
+		return err
+	}
+
 	// TODO: Implement actual APNS sending logic here (e.g. HTTP/2 call to APNS)
 	fmt.Printf("[APNSConnector] (Skeleton) Sending to %s: %s\n", token, string(payload))
 	return nil
 }
+
+// Preview returns the APNS payload Send would send.
+func (a *APNSConnector) Preview(payload []byte) (json.RawMessage, error) {
+	return apnsPayload(payload)
+}
+
+// apnsPayload renders a canonical notification as an APNS payload. Other
+// payloads are sent unchanged.
+func apnsPayload(payload []byte) ([]byte, error) {
+	envelope, inner := unwrap(payload)
+	p, err := notification.Parse(inner)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return payload, nil
+	}
+	aps := renderAPNS(p)
+	if envelope.DeliveryID != "" {
+		aps["delivery_id"] = envelope.DeliveryID
+	}
+	if envelope.Seq != 0 {
+		aps["seq"] = envelope.Seq
+	}
+	rendered, err := json.Marshal(aps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render APNS payload: %w", err)
+	}
+	return rendered, nil
+}
@@ -2,6 +2,7 @@ package connectors
 
 import (
 	"context"
+	"strings"
 	"testing"
 )
 
@@ -22,3 +23,16 @@ func TestAPNSSend(t *testing.T) {
 		t.Errorf("Expected nil error for skeleton implementation, got %v", err)
 	}
 }
+
+func TestAPNSPreview(t *testing.T) {
+	payload := []byte(`{"topic":"news","delivery_id":"d1","payload":{"notification":{"title":"Hi","body":"There"}}}`)
+	out, err := NewAPNSConnector().Preview(payload)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	for _, want := range []string{`"aps"`, `"title":"Hi"`, `"delivery_id":"d1"`} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Expected %s in %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without attempting a send while a target's circuit is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// BreakerConfig controls when a circuit opens and how long it stays open.
+type BreakerConfig struct {
+	FailureThreshold int           // Consecutive failures before the circuit opens
+	Cooldown         time.Duration // Time the circuit stays open before a probe is allowed
+}
+
+// CircuitStatus is a snapshot of one target's circuit, used for metrics.
+type CircuitStatus struct {
+	Provider  string    `json:"provider"`
+	Target    string    `json:"target"`
+	State     string    `json:"state"`
+	Failures  int       `json:"consecutive_failures"`
+	Successes int64     `json:"successes"`
+	Errors    int64     `json:"errors"`
+	Rejected  int64     `json:"rejected"`
+	Opens     int64     `json:"opens"`
+	OpenedAt  time.Time `json:"opened_at,omitempty"`
+}
+
+type circuit struct {
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	successes int64
+	errors    int64
+	rejected  int64
+	opens     int64
+}
+
+// CircuitBreaker wraps a Connector and stops sending to a target that is
+// failing consistently. After the cooldown a single probe is let through
+// (half-open); its result closes or re-opens the circuit.
+//
+// Webhook-style tokens (URLs) get one circuit per host; other tokens share a
+// single circuit for the whole connector.
+type CircuitBreaker struct {
+	name     string
+	next     Connector
+	cfg      BreakerConfig
+	now      func() time.Time
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker wraps next with a circuit breaker.
+func NewCircuitBreaker(name string, next Connector, cfg BreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	return &CircuitBreaker{
+		name:     name,
+		next:     next,
+		cfg:      cfg,
+		now:      time.Now,
+		circuits: map[string]*circuit{},
+	}
+}
+
+// Unwrap returns the wrapped connector.
+func (b *CircuitBreaker) Unwrap() Connector {
+	return b.next
+}
+
+// targetKey returns the circuit key for a token.
+func targetKey(token string) string {
+	if u, err := url.Parse(token); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "*"
+}
+
+// Send delivers via the wrapped connector unless the target's circuit is open.
+func (b *CircuitBreaker) Send(ctx context.Context, token string, payload []byte) error {
+	key := targetKey(token)
+	if !b.allow(key) {
+		return ErrCircuitOpen
+	}
+
+	err := b.next.Send(ctx, token, payload)
+	b.record(key, err)
+	return err
+}
+
+// SendBatch delivers via the wrapped connector's batches, one per target,
+// unless the target's circuit is open. A batch counts as a single send,
+// successful if any of its deliveries was.
+func (b *CircuitBreaker) SendBatch(ctx context.Context, tokens []string, payload []byte) []error {
+	var keys []string
+	groups := map[string][]int{}
+	for i, token := range tokens {
+		key := targetKey(token)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	errs := make([]error, len(tokens))
+	next, batches := b.next.(BatchSender)
+	for _, key := range keys {
+		group := groups[key]
+		if !b.allow(key) {
+			for _, i := range group {
+				errs[i] = ErrCircuitOpen
+			}
+			continue
+		}
+		batch := make([]string, len(group))
+		for j, i := range group {
+			batch[j] = tokens[i]
+		}
+		var results []error
+		if batches {
+			results = next.SendBatch(ctx, batch, payload)
+		} else {
+			results = make([]error, len(batch))
+			for j, token := range batch {
+				results[j] = b.next.Send(ctx, token, payload)
+			}
+		}
+
+		for j, err := range results {
+			errs[group[j]] = err
+		}
+		b.record(key, batchOutcome(results))
+	}
+	return errs
+}
+
+// batchOutcome is nil if any send of a batch succeeded, else its first error.
+func batchOutcome(errs []error) error {
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return errs[0]
+}
+
+func (b *CircuitBreaker) allow(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		b.circuits[key] = c
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		if b.now().Sub(c.openedAt) < b.cfg.Cooldown {
+			c.rejected++
+			return false
+		}
+		c.state = CircuitHalfOpen
+		c.probing = true
+		return true
+	case CircuitHalfOpen:
+		// Only one probe at a time
+		if c.probing {
+			c.rejected++
+			return false
+		}
+		c.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) record(key string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuits[key]
+	c.probing = false
+
+	// Being throttled locally says nothing about the target's health, nor
+	// does a rejected token, e.g. an unregistered device sharing the "*" circuit
+	if errors.Is(err, ErrRateLimited) || IsPermanent(err) {
+		return
+	}
+
+	if err == nil {
+		c.successes++
+		c.failures = 0
+		c.state = CircuitClosed
+		return
+	}
+
+	c.errors++
+	c.failures++
+	if c.state == CircuitHalfOpen || c.failures >= b.cfg.FailureThreshold {
+		if c.state != CircuitOpen {
+			c.opens++
+		}
+		c.state = CircuitOpen
+		c.openedAt = b.now()
+	}
+}
+
+// Snapshot returns the status of every known target circuit.
+func (b *CircuitBreaker) Snapshot() []CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make([]CircuitStatus, 0, len(b.circuits))
+	for key, c := range b.circuits {
+		s := CircuitStatus{
+			Provider:  b.name,
+			Target:    key,
+			State:     c.state,
+			Failures:  c.failures,
+			Successes: c.successes,
+			Errors:    c.errors,
+			Rejected:  c.rejected,
+			Opens:     c.opens,
+		}
+		if c.state != CircuitClosed {
+			s.OpenedAt = c.openedAt
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Target < statuses[j].Target })
+	return statuses
+}
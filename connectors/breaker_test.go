@@ -0,0 +1,166 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type flakyConnector struct {
+	fail  bool
+	calls int
+}
+
+func (f *flakyConnector) Send(ctx context.Context, token string, payload []byte) error {
+	f.calls++
+	if f.fail {
+		return errors.New("provider down")
+	}
+	return nil
+}
+
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	next := &flakyConnector{fail: true}
+	b := NewCircuitBreaker("webhook", next, BreakerConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	target := "https://hooks.example.com/a"
+	ctx := context.Background()
+
+	b.Send(ctx, target, nil)
+	b.Send(ctx, target, nil)
+	if err := b.Send(ctx, target, nil); err != ErrCircuitOpen {
+		t.Fatalf("Expected ErrCircuitOpen after threshold, got %v", err)
+	}
+	if next.calls != 2 {
+		t.Errorf("Expected 2 calls to provider, got %d", next.calls)
+	}
+
+	// Other hosts are unaffected
+	if err := b.Send(ctx, "https://other.example.com/b", nil); err == ErrCircuitOpen {
+		t.Error("Circuit should be per host")
+	}
+
+	// After cooldown a failing probe re-opens the circuit
+	now = now.Add(2 * time.Minute)
+	if err := b.Send(ctx, target, nil); err == ErrCircuitOpen {
+		t.Fatal("Expected probe to be let through after cooldown")
+	}
+	if err := b.Send(ctx, target, nil); err != ErrCircuitOpen {
+		t.Fatalf("Expected circuit to re-open after failed probe, got %v", err)
+	}
+
+	// A successful probe closes it
+	now = now.Add(2 * time.Minute)
+	next.fail = false
+	if err := b.Send(ctx, target, nil); err != nil {
+		t.Fatalf("Expected successful probe, got %v", err)
+	}
+
+	for _, s := range b.Snapshot() {
+		if s.Target == "hooks.example.com" {
+			if s.State != CircuitClosed || s.Opens != 2 || s.Successes != 1 {
+				t.Errorf("Unexpected status: %+v", s)
+			}
+			return
+		}
+	}
+	t.Error("Target missing from snapshot")
+}
+
+// staleTokens rejects every token for good, as for unregistered devices.
+type staleTokens struct{ calls int }
+
+func (s *staleTokens) Send(ctx context.Context, token string, payload []byte) error {
+	s.calls++
+	return Permanent(errors.New("unregistered token"))
+}
+
+func TestCircuitBreaker_IgnoresPermanentErrors(t *testing.T) {
+	next := &staleTokens{}
+	b := NewCircuitBreaker("fcm", next, BreakerConfig{FailureThreshold: 2, Cooldown: time.Minute})
+
+	for i := 0; i < 5; i++ {
+		if err := b.Send(context.Background(), fmt.Sprintf("device-%d", i), nil); !IsPermanent(err) {
+			t.Fatalf("Expected the token's permanent error, got %v", err)
+		}
+	}
+	if next.calls != 5 {
+		t.Errorf("Expected every token sent, got %d calls", next.calls)
+	}
+	for _, s := range b.Snapshot() {
+		if s.State != CircuitClosed || s.Opens != 0 {
+			t.Errorf("Expected the circuit closed after permanent errors, got %+v", s)
+		}
+	}
+}
+
+// flakyBatcher sends batches, failing every token while fail is set.
+type flakyBatcher struct {
+	flakyConnector
+	batches [][]string
+}
+
+func (f *flakyBatcher) SendBatch(ctx context.Context, tokens []string, payload []byte) []error {
+	f.batches = append(f.batches, tokens)
+	errs := make([]error, len(tokens))
+	for i := range tokens {
+		errs[i] = f.Send(ctx, tokens[i], payload)
+	}
+	return errs
+}
+
+func TestCircuitBreaker_SendBatch(t *testing.T) {
+	next := &flakyBatcher{}
+	b := NewCircuitBreaker("webhook", next, BreakerConfig{FailureThreshold: 2, Cooldown: time.Minute})
+	ctx := context.Background()
+	tokens := []string{"https://a.example.com/1", "https://b.example.com/1", "https://a.example.com/2"}
+
+	// One batch per target
+	if errs := b.SendBatch(ctx, tokens, nil); errs[0] != nil || errs[1] != nil || errs[2] != nil {
+		t.Fatalf("Expected no errors, got %v", errs)
+	}
+	if len(next.batches) != 2 || len(next.batches[0]) != 2 || next.batches[0][1] != tokens[2] {
+		t.Errorf("Expected batches per host, got %v", next.batches)
+	}
+
+	// A failed batch counts once towards the threshold
+	next.fail = true
+	b.SendBatch(ctx, tokens[:1], nil)
+	if errs := b.SendBatch(ctx, tokens, nil); errs[0] == ErrCircuitOpen {
+		t.Fatal("Expected a single failed batch not to open the circuit")
+	}
+	errs := b.SendBatch(ctx, tokens, nil)
+	if errs[0] != ErrCircuitOpen || errs[2] != ErrCircuitOpen || errs[1] == ErrCircuitOpen {
+		t.Errorf("Expected only a.example.com's circuit open, got %v", errs)
+	}
+}
+
+func TestAsBatchSender(t *testing.T) {
+	cfg := BreakerConfig{}
+	if _, ok := AsBatchSender(NewCircuitBreaker("mock", NewMockConnector(), cfg)); !ok {
+		t.Error("Expected a breaker around a batching connector to send batches")
+	}
+	if _, ok := AsBatchSender(NewCircuitBreaker("flaky", &flakyConnector{}, cfg)); ok {
+		t.Error("Expected a breaker around a single-send connector not to send batches")
+	}
+	limited, err := NewRateLimiter(NewMockConnector(), RateLimit{Rate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := AsBatchSender(NewCircuitBreaker("mock", limited, cfg)); ok {
+		t.Error("Expected a rate limited connector not to send batches")
+	}
+}
+
+func TestTargetKey(t *testing.T) {
+	if k := targetKey("https://discord.com/api/webhooks/1"); k != "discord.com" {
+		t.Errorf("Expected host key, got %s", k)
+	}
+	if k := targetKey("fcm-device-token"); k != "*" {
+		t.Errorf("Expected connector-wide key, got %s", k)
+	}
+}
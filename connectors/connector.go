@@ -1,6 +1,10 @@
 package connectors
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
 
 // Connector defines the interface that all notification providers must implement.
 // It allows the Hub to route messages without knowing the underlying implementation details.
@@ -8,3 +12,57 @@ type Connector interface {
 	// Send sends a payload to a specific device identified by the token.
 	Send(ctx context.Context, token string, payload []byte) error
 }
+
+// BatchSender is implemented by connectors that can send one payload to many
+// tokens at once, cheaper than a Send per token.
+type BatchSender interface {
+	// SendBatch sends payload to every token and returns the error of each,
+	// in the order of tokens.
+	SendBatch(ctx context.Context, tokens []string, payload []byte) []error
+}
+
+// Previewer is implemented by connectors that can show what they would send
+// for a payload, so drafts can be checked before they go out.
+type Previewer interface {
+	// Preview renders payload as Send would send it, without sending it.
+	Preview(payload []byte) (json.RawMessage, error)
+}
+
+// AsBatchSender returns c if it sends batches, and so does every connector
+// it wraps.
+func AsBatchSender(c Connector) (BatchSender, bool) {
+	b, ok := c.(BatchSender)
+	if !ok {
+		return nil, false
+	}
+	if w, ok := c.(interface{ Unwrap() Connector }); ok {
+		if _, ok := AsBatchSender(w.Unwrap()); !ok {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// PermanentError marks a delivery failure that retrying won't fix, such as a
+// rejected webhook request or an unregistered device token.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err as a PermanentError. It returns nil for a nil err.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, is permanent.
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
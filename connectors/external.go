@@ -0,0 +1,132 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// externalRequest is the JSON document handed to external connectors.
+type externalRequest struct {
+	Token   string          `json:"token"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func newExternalRequest(token string, payload []byte) ([]byte, error) {
+	p := json.RawMessage(payload)
+	if !json.Valid(payload) {
+		quoted, _ := json.Marshal(string(payload))
+		p = quoted
+	}
+	return json.Marshal(externalRequest{Token: token, Payload: p})
+}
+
+// ExecConnector delivers by running a command. The request is written to the
+// command's stdin as JSON ({"token": ..., "payload": ...}); a zero exit status
+// means the delivery succeeded.
+type ExecConnector struct {
+	command string
+	args    []string
+}
+
+// NewExecConnector creates an ExecConnector from settings "command" and optional "args"
+// (whitespace separated).
+func NewExecConnector(settings map[string]string) (Connector, error) {
+	command := settings["command"]
+	if command == "" {
+		return nil, fmt.Errorf("exec connector requires a command setting")
+	}
+	return &ExecConnector{command: command, args: strings.Fields(settings["args"])}, nil
+}
+
+// Send runs the command with the request on stdin.
+func (e *ExecConnector) Send(ctx context.Context, token string, payload []byte) error {
+	body, err := newExternalRequest(token, payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, e.command, e.args...)
+	cmd.Stdin = bytes.NewReader(body)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec connector failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// HTTPConnector delivers by POSTing the request as JSON to an external service
+// that implements the actual provider.
+type HTTPConnector struct {
+	url    string
+	header string
+	value  string
+	client *http.Client
+}
+
+// NewHTTPConnector creates an HTTPConnector from settings "url", optional
+// "auth_header" (default Authorization), "auth_value" and "timeout" (Go duration).
+func NewHTTPConnector(settings map[string]string) (Connector, error) {
+	url := settings["url"]
+	if url == "" {
+		return nil, fmt.Errorf("http connector requires a url setting")
+	}
+
+	timeout := 5 * time.Second
+	if t := settings["timeout"]; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		timeout = d
+	}
+
+	header := settings["auth_header"]
+	if header == "" {
+		header = "Authorization"
+	}
+
+	return &HTTPConnector{
+		url:    url,
+		header: header,
+		value:  settings["auth_value"],
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Send POSTs the request to the configured URL.
+func (h *HTTPConnector) Send(ctx context.Context, token string, payload []byte) error {
+	body, err := newExternalRequest(token, payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.value != "" {
+		req.Header.Set(h.header, h.value)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach external connector: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("external connector failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
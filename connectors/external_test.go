@@ -0,0 +1,75 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPConnector_Send(t *testing.T) {
+	var got externalRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("X-Key")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	c, err := NewHTTPConnector(map[string]string{"url": server.URL, "auth_header": "X-Key", "auth_value": "secret"})
+	if err != nil {
+		t.Fatalf("NewHTTPConnector failed: %v", err)
+	}
+
+	if err := c.Send(context.Background(), "device-1", []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got.Token != "device-1" || string(got.Payload) != `{"a":1}` {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if auth != "secret" {
+		t.Errorf("Expected auth header, got %q", auth)
+	}
+}
+
+func TestHTTPConnector_Errors(t *testing.T) {
+	if _, err := NewHTTPConnector(map[string]string{}); err == nil {
+		t.Error("Expected error without url")
+	}
+	if _, err := NewHTTPConnector(map[string]string{"url": "http://x", "timeout": "soon"}); err == nil {
+		t.Error("Expected error for invalid timeout")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c, _ := NewHTTPConnector(map[string]string{"url": server.URL})
+	if err := c.Send(context.Background(), "t", []byte("plain")); err == nil {
+		t.Error("Expected error for non-2xx status")
+	}
+}
+
+func TestExecConnector_Send(t *testing.T) {
+	if _, err := NewExecConnector(map[string]string{}); err == nil {
+		t.Error("Expected error without command")
+	}
+
+	ok, err := NewExecConnector(map[string]string{"command": "sh", "args": "-c cat>/dev/null"})
+	if err != nil {
+		t.Fatalf("NewExecConnector failed: %v", err)
+	}
+	if err := ok.Send(context.Background(), "t", []byte(`{}`)); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+
+	fail, _ := NewExecConnector(map[string]string{"command": "false"})
+	if err := fail.Send(context.Background(), "t", []byte(`{}`)); err == nil {
+		t.Error("Expected error for non-zero exit status")
+	}
+}
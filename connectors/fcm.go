@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 
+	"no-spam/notification"
 	"no-spam/store"
 
 	firebase "firebase.google.com/go/v4"
@@ -65,9 +67,92 @@ func (f *FCMConnector) Send(ctx context.Context, token string, payload []byte) e
 		return fmt.Errorf("FCM client is not initialized")
 	}
 
+	message, err := fcmMessage(token, payload)
+	if err != nil {
+		return err
+	}
+	response, err := f.client.Send(ctx, message)
+	if err != nil {
+		return fcmError(err)
+	}
+
+	log.Printf("[FCM] Successfully sent message: %s", response)
+	return nil
+}
+
+// Preview returns the FCM message Send would send, without its token.
+func (f *FCMConnector) Preview(payload []byte) (json.RawMessage, error) {
+	message, err := fcmMessage("", payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
+
+// fcmBatchSize is the most messages FCM accepts in one batch.
+const fcmBatchSize = 500
+
+// fcmBatchSender is implemented by FCM clients that can send many messages
+// in one call, such as *messaging.Client.
+type fcmBatchSender interface {
+	SendEach(ctx context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error)
+}
+
+// SendBatch sends payload to every token, up to fcmBatchSize messages per
+// FCM request when the client supports batches.
+func (f *FCMConnector) SendBatch(ctx context.Context, tokens []string, payload []byte) []error {
+	errs := make([]error, len(tokens))
+	batcher, ok := f.client.(fcmBatchSender)
+	if !ok {
+		for i, token := range tokens {
+			errs[i] = f.Send(ctx, token, payload)
+		}
+		return errs
+	}
+
+	for start := 0; start < len(tokens); start += fcmBatchSize {
+		chunk := tokens[start:min(start+fcmBatchSize, len(tokens))]
+		messages := make([]*messaging.Message, len(chunk))
+		for i, token := range chunk {
+			message, err := fcmMessage(token, payload)
+			if err != nil {
+				// Every message of the batch has the same payload
+				for j := range chunk {
+					errs[start+j] = err
+				}
+				return errs
+			}
+			messages[i] = message
+		}
+		resp, err := batcher.SendEach(ctx, messages)
+		for i := range chunk {
+			switch {
+			case err != nil:
+				errs[start+i] = fcmError(err)
+			case i < len(resp.Responses) && resp.Responses[i].Error != nil:
+				errs[start+i] = fcmError(resp.Responses[i].Error)
+			}
+		}
+		if err == nil {
+			log.Printf("[FCM] Sent batch of %d messages, %d failed", len(chunk), resp.FailureCount)
+		}
+	}
+	return errs
+}
+
+// fcmError wraps an FCM send error, marking those retrying won't fix as permanent.
+func fcmError(err error) error {
+	if messaging.IsUnregistered(err) || messaging.IsInvalidArgument(err) {
+		return Permanent(fmt.Errorf("FCM send failed: %w", err))
+	}
+	return fmt.Errorf("FCM send failed: %w", err)
+}
+
+// fcmMessage builds the FCM message of a payload to token.
+func fcmMessage(token string, payload []byte) (*messaging.Message, error) {
 	var notif store.Notification
 	if err := json.Unmarshal(payload, &notif); err != nil {
-		return fmt.Errorf("failed to unmarshal notification for FCM: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal notification for FCM: %v", err)
 	}
 
 	// Map Notification fields to FCM Message
@@ -79,12 +164,114 @@ func (f *FCMConnector) Send(ctx context.Context, token string, payload []byte) e
 			"payload": string(notif.Payload),
 		},
 	}
+	if notif.MessageID != 0 {
+		message.Data["message_id"] = strconv.FormatInt(notif.MessageID, 10)
+	}
+	if notif.DeliveryID != "" {
+		message.Data["delivery_id"] = notif.DeliveryID
+	}
+	if notif.Seq != 0 {
+		message.Data["seq"] = strconv.FormatInt(notif.Seq, 10)
+	}
+	if notif.From != "" {
+		message.Data["sender"] = notif.From // FCM reserves "from"
+	}
 
-	response, err := f.client.Send(ctx, message)
+	p, err := notification.Parse(notif.Payload)
 	if err != nil {
-		return fmt.Errorf("FCM send failed: %v", err)
+		return nil, err
+	}
+	if p != nil {
+		renderFCM(message, p)
 	}
+	return message, nil
+}
 
-	log.Printf("[FCM] Successfully sent message: %s", response)
-	return nil
+// renderFCM fills the platform notification blocks of message from a
+// canonical notification. The raw payload stays in Data for custom handling.
+func renderFCM(message *messaging.Message, p *notification.Payload) {
+	n := p.Notification
+	for k, v := range p.Data {
+		if k != "topic" && k != "payload" && k != "message_id" && k != "delivery_id" && k != "seq" && k != "sender" {
+			message.Data[k] = v
+		}
+	}
+	if n.URL != "" {
+		message.Data["url"] = n.URL
+	}
+	if len(n.Actions) > 0 {
+		// Android and iOS apps build their buttons from the data; FCM has no
+		// native action buttons for them
+		actions, _ := json.Marshal(n.Actions)
+		message.Data["actions"] = string(actions)
+	}
+
+	message.Notification = &messaging.Notification{
+		Title:    n.Title,
+		Body:     n.Body,
+		ImageURL: n.Image,
+	}
+
+	priority := "normal"
+	if n.Priority == notification.PriorityHigh {
+		priority = "high"
+	}
+	var category notification.Category
+	if n.Category != "" {
+		category = notification.LookupCategory(n.Category)
+	}
+	message.Android = &messaging.AndroidConfig{
+		Priority: priority,
+		Notification: &messaging.AndroidNotification{
+			Icon:              n.Icon,
+			Sound:             n.Sound,
+			ChannelID:         category.AndroidChannel,
+			NotificationCount: n.Badge,
+		},
+	}
+
+	apnsPriority := "5"
+	if n.Priority == notification.PriorityHigh {
+		apnsPriority = "10"
+	}
+	message.APNS = &messaging.APNSConfig{
+		Headers: map[string]string{"apns-priority": apnsPriority},
+		Payload: &messaging.APNSPayload{
+			Aps: &messaging.Aps{
+				Sound:          n.Sound,
+				Badge:          n.Badge,
+				ThreadID:       n.Thread,
+				MutableContent: n.Image != "",
+				Category:       category.APNSCategory,
+			},
+		},
+	}
+	if category.InterruptionLevel != "" {
+		message.APNS.Payload.Aps.CustomData = map[string]interface{}{"interruption-level": category.InterruptionLevel}
+	}
+
+	webpush := &messaging.WebpushNotification{
+		Title: n.Title,
+		Body:  n.Body,
+		Icon:  n.Icon,
+		Image: n.Image,
+	}
+	links := map[string]string{} // Opened by the service worker when a button is clicked
+	for _, a := range n.Actions {
+		webpush.Actions = append(webpush.Actions, &messaging.WebpushNotificationAction{Action: a.ID, Title: a.Title})
+		if a.URL != "" {
+			links[a.ID] = a.URL
+		}
+	}
+	if len(links) > 0 {
+		data := map[string]interface{}{"actions": links}
+		if n.URL != "" {
+			data["url"] = n.URL
+		}
+		webpush.Data = data
+	}
+	message.Webpush = &messaging.WebpushConfig{Notification: webpush}
+	if n.URL != "" {
+		message.Webpush.FCMOptions = &messaging.WebpushFCMOptions{Link: n.URL}
+	}
 }
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"no-spam/notification"
 	"no-spam/store"
+	"strconv"
 	"testing"
 
 	"firebase.google.com/go/v4/messaging"
@@ -30,8 +32,10 @@ func TestFCMSend_Success(t *testing.T) {
 
 	// Prepare payload
 	notif := store.Notification{
-		Topic:   "news",
-		Payload: json.RawMessage(`{"alert":"breaking"}`),
+		Topic:      "news",
+		DeliveryID: "d1",
+		Seq:        7,
+		Payload:    json.RawMessage(`{"alert":"breaking"}`),
 	}
 	payload, _ := json.Marshal(notif)
 
@@ -55,6 +59,9 @@ func TestFCMSend_Success(t *testing.T) {
 	if msg.Data["payload"] != expectedPayload {
 		t.Errorf("Expected payload %s, got %s", expectedPayload, msg.Data["payload"])
 	}
+	if msg.Data["delivery_id"] != "d1" || msg.Data["seq"] != "7" {
+		t.Errorf("Expected delivery ID d1 and sequence number 7, got %q and %q", msg.Data["delivery_id"], msg.Data["seq"])
+	}
 }
 
 func TestFCMSend_Errors(t *testing.T) {
@@ -84,3 +91,161 @@ func TestFCMSend_Errors(t *testing.T) {
 		t.Errorf("Unexpected error message: %v", err)
 	}
 }
+
+func TestFCMSend_CanonicalNotification(t *testing.T) {
+	mock := &MockFCMSender{}
+	connector := &FCMConnector{client: mock}
+
+	payload, _ := json.Marshal(store.Notification{
+		Topic: "news",
+		Payload: json.RawMessage(`{"notification": {"title": "Hi", "body": "There", "image": "https://img/x.png",
+			"url": "https://example.com", "badge": 3, "thread": "builds", "priority": "high", "actions": [{"id": "ok", "title": "OK"}]},
+			"data": {"id": "7"}}`),
+	})
+
+	if err := connector.Send(context.Background(), "device", payload); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	msg := mock.SentMessages[0]
+	if msg.Notification == nil || msg.Notification.Title != "Hi" || msg.Notification.ImageURL != "https://img/x.png" {
+		t.Errorf("Unexpected notification: %+v", msg.Notification)
+	}
+	if msg.Android.Priority != "high" || msg.APNS.Headers["apns-priority"] != "10" {
+		t.Errorf("Expected high priority, got android=%s apns=%s", msg.Android.Priority, msg.APNS.Headers["apns-priority"])
+	}
+	if msg.APNS.Payload.Aps.Badge == nil || *msg.APNS.Payload.Aps.Badge != 3 {
+		t.Errorf("Expected badge 3")
+	}
+	if n := msg.Android.Notification.NotificationCount; n == nil || *n != 3 {
+		t.Errorf("Expected Android notification count 3")
+	}
+	if msg.APNS.Payload.Aps.ThreadID != "builds" {
+		t.Errorf("Expected thread-id builds, got %q", msg.APNS.Payload.Aps.ThreadID)
+	}
+	if msg.Webpush.FCMOptions.Link != "https://example.com" || len(msg.Webpush.Notification.Actions) != 1 {
+		t.Errorf("Unexpected webpush config: %+v", msg.Webpush)
+	}
+	if msg.Data["id"] != "7" || msg.Data["topic"] != "news" {
+		t.Errorf("Unexpected data: %v", msg.Data)
+	}
+}
+
+func TestFCMSend_Category(t *testing.T) {
+	notification.SetCategories(notification.Categories{"security": {AndroidChannel: "alerts", InterruptionLevel: "critical"}})
+	defer notification.SetCategories(nil)
+	mock := &MockFCMSender{}
+	connector := &FCMConnector{client: mock}
+
+	payload, _ := json.Marshal(store.Notification{
+		Topic:   "news",
+		Payload: json.RawMessage(`{"notification": {"title": "Hi", "category": "security"}}`),
+	})
+	if err := connector.Send(context.Background(), "device", payload); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	msg := mock.SentMessages[0]
+	if msg.Android.Notification.ChannelID != "alerts" {
+		t.Errorf("Expected the alerts channel, got %q", msg.Android.Notification.ChannelID)
+	}
+	if aps := msg.APNS.Payload.Aps; aps.Category != "security" || aps.CustomData["interruption-level"] != "critical" {
+		t.Errorf("Expected the security category and critical level, got %+v", aps)
+	}
+}
+
+func TestFCMSend_Actions(t *testing.T) {
+	mock := &MockFCMSender{}
+	connector := &FCMConnector{client: mock}
+
+	payload, _ := json.Marshal(store.Notification{
+		Topic: "orders",
+		Payload: json.RawMessage(`{"notification": {"title": "Shipped", "actions": [
+			{"id": "track", "title": "Track", "url": "shop://orders/42"}, {"id": "dismiss", "title": "Dismiss"}]}}`),
+	})
+	if err := connector.Send(context.Background(), "device", payload); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	msg := mock.SentMessages[0]
+	var actions []notification.Action
+	if err := json.Unmarshal([]byte(msg.Data["actions"]), &actions); err != nil || len(actions) != 2 || actions[0].URL != "shop://orders/42" {
+		t.Errorf("Expected the actions in the data, got %q", msg.Data["actions"])
+	}
+	data, _ := msg.Webpush.Notification.Data.(map[string]interface{})
+	if links, _ := data["actions"].(map[string]string); len(links) != 1 || links["track"] != "shop://orders/42" {
+		t.Errorf("Expected the track link for the service worker, got %v", msg.Webpush.Notification.Data)
+	}
+}
+
+// mockFCMBatcher also sends batches, failing the tokens in fail.
+type mockFCMBatcher struct {
+	MockFCMSender
+	batches []int
+	fail    map[string]bool
+}
+
+func (m *mockFCMBatcher) SendEach(ctx context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error) {
+	m.batches = append(m.batches, len(messages))
+	resp := &messaging.BatchResponse{}
+	for _, msg := range messages {
+		r := &messaging.SendResponse{Success: !m.fail[msg.Token]}
+		if m.fail[msg.Token] {
+			r.Error = errors.New("mock fcm error")
+			resp.FailureCount++
+		} else {
+			resp.SuccessCount++
+		}
+		resp.Responses = append(resp.Responses, r)
+	}
+	return resp, nil
+}
+
+func TestFCMSendBatch(t *testing.T) {
+	mock := &mockFCMBatcher{fail: map[string]bool{"t-3": true, "t-700": true}}
+	connector := &FCMConnector{client: mock}
+	payload, _ := json.Marshal(store.Notification{Topic: "news", Payload: json.RawMessage(`{"alert":"breaking"}`)})
+
+	tokens := make([]string, 750)
+	for i := range tokens {
+		tokens[i] = "t-" + strconv.Itoa(i)
+	}
+	errs := connector.SendBatch(context.Background(), tokens, payload)
+	if len(mock.batches) != 2 || mock.batches[0] != 500 || mock.batches[1] != 250 {
+		t.Errorf("Expected batches of 500 and 250, got %v", mock.batches)
+	}
+	for i, err := range errs {
+		if failed := tokens[i] == "t-3" || tokens[i] == "t-700"; failed != (err != nil) {
+			t.Errorf("Token %s: unexpected error %v", tokens[i], err)
+		}
+	}
+	if len(mock.SentMessages) != 0 {
+		t.Errorf("Expected no single sends, got %d", len(mock.SentMessages))
+	}
+
+	// Clients without batches send one by one
+	single := &MockFCMSender{}
+	errs = (&FCMConnector{client: single}).SendBatch(context.Background(), tokens[:3], payload)
+	if len(single.SentMessages) != 3 || errs[0] != nil {
+		t.Errorf("Expected 3 single sends, got %d (%v)", len(single.SentMessages), errs)
+	}
+}
+
+func TestFCMPreview(t *testing.T) {
+	connector := &FCMConnector{client: &MockFCMSender{}}
+	payload := []byte(`{"topic":"news","seq":3,"payload":{"notification":{"title":"Hi"}}}`)
+	out, err := connector.Preview(payload)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	var msg messaging.Message
+	if err := json.Unmarshal(out, &msg); err != nil {
+		t.Fatalf("Invalid preview %s: %v", out, err)
+	}
+	if msg.Data["topic"] != "news" || msg.Data["seq"] != "3" || msg.Notification == nil || msg.Notification.Title != "Hi" {
+		t.Errorf("Unexpected preview %s", out)
+	}
+	if len(connector.client.(*MockFCMSender).SentMessages) != 0 {
+		t.Error("Expected nothing sent")
+	}
+}
@@ -0,0 +1,110 @@
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// TopicConnector is implemented by connectors whose platform fans messages
+// out itself. Their subscriptions are also registered with the platform,
+// which then gets one message per publish instead of one per token.
+type TopicConnector interface {
+	SubscribeToTopic(ctx context.Context, topic string, tokens []string) error
+	UnsubscribeFromTopic(ctx context.Context, topic string, tokens []string) error
+	SendToTopic(ctx context.Context, topic string, payload []byte) error
+}
+
+// fcmTopicManager is implemented by FCM clients that manage topic
+// subscriptions, such as *messaging.Client.
+type fcmTopicManager interface {
+	SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+	UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+}
+
+// FCMTopicConnector delivers through FCM topic messaging, for audiences too
+// large to send to token by token. Deliveries to a single token, such as
+// history replays, are sent like the FCM connector does.
+type FCMTopicConnector struct {
+	*FCMConnector
+}
+
+// NewFCMTopicConnector creates an FCMTopicConnector sending with f's client.
+func NewFCMTopicConnector(f *FCMConnector) *FCMTopicConnector {
+	return &FCMTopicConnector{FCMConnector: f}
+}
+
+// FCMTopicName returns the FCM topic of a topic. Characters FCM doesn't
+// allow in topic names, such as the "/" of namespaces, are percent-encoded.
+func FCMTopicName(topic string) string {
+	var b strings.Builder
+	for i := 0; i < len(topic); i++ {
+		c := topic[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (c *FCMTopicConnector) manager() (fcmTopicManager, error) {
+	m, ok := c.client.(fcmTopicManager)
+	if !ok {
+		return nil, fmt.Errorf("FCM client can't manage topic subscriptions")
+	}
+	return m, nil
+}
+
+// SubscribeToTopic registers tokens with the FCM topic of topic.
+func (c *FCMTopicConnector) SubscribeToTopic(ctx context.Context, topic string, tokens []string) error {
+	m, err := c.manager()
+	if err != nil {
+		return err
+	}
+	resp, err := m.SubscribeToTopic(ctx, tokens, FCMTopicName(topic))
+	return topicManagementError("subscribe", resp, err)
+}
+
+// UnsubscribeFromTopic removes tokens from the FCM topic of topic.
+func (c *FCMTopicConnector) UnsubscribeFromTopic(ctx context.Context, topic string, tokens []string) error {
+	m, err := c.manager()
+	if err != nil {
+		return err
+	}
+	resp, err := m.UnsubscribeFromTopic(ctx, tokens, FCMTopicName(topic))
+	return topicManagementError("unsubscribe", resp, err)
+}
+
+// topicManagementError returns the first failure of a topic management call.
+func topicManagementError(op string, resp *messaging.TopicManagementResponse, err error) error {
+	if err != nil {
+		return fmt.Errorf("FCM topic %s failed: %w", op, err)
+	}
+	if resp != nil && len(resp.Errors) > 0 {
+		return fmt.Errorf("FCM topic %s failed for %d tokens: %s", op, resp.FailureCount, resp.Errors[0].Reason)
+	}
+	return nil
+}
+
+// SendToTopic sends payload once to the FCM topic of topic.
+func (c *FCMTopicConnector) SendToTopic(ctx context.Context, topic string, payload []byte) error {
+	if c.client == nil {
+		return fmt.Errorf("FCM client is not initialized")
+	}
+	message, err := fcmMessage("", payload)
+	if err != nil {
+		return err
+	}
+	message.Topic = FCMTopicName(topic)
+	response, err := c.client.Send(ctx, message)
+	if err != nil {
+		return fmt.Errorf("FCM send failed: %w", err)
+	}
+	log.Printf("[FCM] Sent topic message to %s: %s", message.Topic, response)
+	return nil
+}
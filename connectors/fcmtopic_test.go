@@ -0,0 +1,84 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"no-spam/store"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// mockFCMTopicClient also manages topic subscriptions, failing the tokens in fail.
+type mockFCMTopicClient struct {
+	MockFCMSender
+	subscribed map[string][]string
+	fail       map[string]bool
+}
+
+func (m *mockFCMTopicClient) manage(tokens []string, topic string, subscribe bool) (*messaging.TopicManagementResponse, error) {
+	resp := &messaging.TopicManagementResponse{}
+	for i, token := range tokens {
+		if m.fail[token] {
+			resp.FailureCount++
+			resp.Errors = append(resp.Errors, &messaging.ErrorInfo{Index: i, Reason: "invalid-argument"})
+			continue
+		}
+		resp.SuccessCount++
+		if subscribe {
+			m.subscribed[topic] = append(m.subscribed[topic], token)
+		}
+	}
+	return resp, nil
+}
+
+func (m *mockFCMTopicClient) SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	return m.manage(tokens, topic, true)
+}
+
+func (m *mockFCMTopicClient) UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error) {
+	return m.manage(tokens, topic, false)
+}
+
+func TestFCMTopicName(t *testing.T) {
+	for topic, want := range map[string]string{
+		"news":          "news",
+		"alice/alerts":  "alice%2Falerts",
+		"build_2.0~rc":  "build_2.0~rc",
+		"100% uptime!?": "100%25%20uptime%21%3F",
+	} {
+		if got := FCMTopicName(topic); got != want {
+			t.Errorf("FCMTopicName(%q) = %q, want %q", topic, got, want)
+		}
+	}
+}
+
+func TestFCMTopicConnector(t *testing.T) {
+	client := &mockFCMTopicClient{subscribed: map[string][]string{}, fail: map[string]bool{"bad": true}}
+	c := NewFCMTopicConnector(&FCMConnector{client: client})
+	ctx := context.Background()
+
+	if err := c.SubscribeToTopic(ctx, "alice/alerts", []string{"device"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.subscribed["alice%2Falerts"]; len(got) != 1 || got[0] != "device" {
+		t.Errorf("Expected the device in the FCM topic, got %v", client.subscribed)
+	}
+	if err := c.SubscribeToTopic(ctx, "alice/alerts", []string{"bad"}); err == nil {
+		t.Error("Expected an error for a rejected token")
+	}
+
+	payload, _ := json.Marshal(store.Notification{Topic: "alice/alerts", Payload: json.RawMessage(`{"notification": {"title": "Hi"}}`)})
+	if err := c.SendToTopic(ctx, "alice/alerts", payload); err != nil {
+		t.Fatal(err)
+	}
+	msg := client.SentMessages[0]
+	if msg.Topic != "alice%2Falerts" || msg.Token != "" || msg.Notification == nil || msg.Notification.Title != "Hi" {
+		t.Errorf("Expected a rendered message to the FCM topic, got %+v", msg)
+	}
+
+	if _, ok := any(&FCMConnector{client: client}).(TopicConnector); ok {
+		t.Error("Expected the plain FCM connector to deliver token by token")
+	}
+}
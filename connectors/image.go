@@ -0,0 +1,148 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrImageRejected is returned by ImageProxy.Fetch when the response isn't
+// an image it will serve.
+var ErrImageRejected = errors.New("image rejected")
+
+// Defaults of the image proxy.
+const (
+	MaxImageSize    = 5 << 20  // Larger images aren't proxied; FCM and APNS don't show them either
+	imageCacheSize  = 64 << 20 // Total bytes of cached images
+	imageCacheTTL   = time.Hour
+	imageFetchLimit = 10 * time.Second
+)
+
+// Image is an image fetched by the image proxy.
+type Image struct {
+	ContentType string
+	Data        []byte
+	fetchedAt   time.Time
+}
+
+// ImageProxy fetches notification images on behalf of devices, so they
+// don't reveal their IP addresses to the hosts publishers link to. Fetched
+// images are cached in memory.
+type ImageProxy struct {
+	client *http.Client
+	policy *URLPolicy
+
+	mu    sync.Mutex
+	cache map[string]*Image
+	order []string // Cached URLs, oldest first
+	size  int
+}
+
+// NewImageProxy returns an image proxy without a URL policy.
+func NewImageProxy() *ImageProxy {
+	return &ImageProxy{
+		client: &http.Client{Timeout: imageFetchLimit},
+		cache:  map[string]*Image{},
+	}
+}
+
+// SetURLPolicy restricts the hosts images are fetched from like webhook
+// destinations, since publishers choose the URLs.
+func (p *ImageProxy) SetURLPolicy(policy *URLPolicy) {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: policy.dialControl,
+	}
+	client := &http.Client{
+		Timeout: imageFetchLimit,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        20,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("stopped after 5 redirects")
+			}
+			return policy.Check(req.Context(), req.URL.String())
+		},
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policy, p.client = policy, client
+}
+
+// Fetch returns the image at rawURL, from the cache when it was fetched
+// less than an hour ago.
+func (p *ImageProxy) Fetch(ctx context.Context, rawURL string) (*Image, error) {
+	p.mu.Lock()
+	img, ok := p.cache[rawURL]
+	policy, client := p.policy, p.client
+	p.mu.Unlock()
+	if ok && time.Since(img.fetchedAt) < imageCacheTTL {
+		return img, nil
+	}
+
+	if policy != nil {
+		if err := policy.Check(ctx, rawURL); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrImageRejected, resp.StatusCode)
+	}
+	// SVG can carry scripts, which would run on this server's origin
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") || contentType == "image/svg+xml" {
+		return nil, fmt.Errorf("%w: content type %q", ErrImageRejected, contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxImageSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrImageRejected, MaxImageSize)
+	}
+
+	img = &Image{ContentType: contentType, Data: data, fetchedAt: time.Now()}
+	p.store(rawURL, img)
+	return img, nil
+}
+
+// store caches img, evicting the oldest images past the cache size.
+func (p *ImageProxy) store(rawURL string, img *Image) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old, ok := p.cache[rawURL]; ok {
+		p.size -= len(old.Data)
+		p.order = slices.DeleteFunc(p.order, func(u string) bool { return u == rawURL })
+	}
+	p.cache[rawURL] = img
+	p.order = append(p.order, rawURL)
+	p.size += len(img.Data)
+	for p.size > imageCacheSize && len(p.order) > 0 {
+		oldest := p.order[0]
+		p.order = p.order[1:]
+		p.size -= len(p.cache[oldest].Data)
+		delete(p.cache, oldest)
+	}
+}
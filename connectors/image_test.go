@@ -0,0 +1,62 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestImageProxy(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		case "/logo.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte("<svg/>"))
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>"))
+		case "/huge.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(bytes.Repeat([]byte{0}, MaxImageSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewImageProxy()
+	for range 2 {
+		img, err := p.Fetch(context.Background(), srv.URL+"/logo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.ContentType != "image/png" || string(img.Data) != "png" {
+			t.Errorf("Unexpected image %+v", img)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected the second fetch served from the cache, got %d fetches", n)
+	}
+
+	for _, path := range []string{"/logo.svg", "/page", "/huge.jpg", "/missing.png"} {
+		if _, err := p.Fetch(context.Background(), srv.URL+path); !errors.Is(err, ErrImageRejected) {
+			t.Errorf("%s: expected ErrImageRejected, got %v", path, err)
+		}
+	}
+
+	policy, _ := NewURLPolicy(URLPolicyConfig{})
+	p = NewImageProxy()
+	p.SetURLPolicy(policy)
+	if _, err := p.Fetch(context.Background(), srv.URL+"/logo.png"); !errors.Is(err, ErrTargetNotAllowed) {
+		t.Errorf("Expected the loopback image blocked by the policy, got %v", err)
+	}
+}
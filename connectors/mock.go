@@ -18,3 +18,9 @@ func (m *MockConnector) Send(ctx context.Context, token string, payload []byte)
 	log.Printf("[MockConnector] Sending to %s: %s", token, string(payload))
 	return nil
 }
+
+// SendBatch logs the message payload once for all tokens.
+func (m *MockConnector) SendBatch(ctx context.Context, tokens []string, payload []byte) []error {
+	log.Printf("[MockConnector] Sending to %d tokens: %s", len(tokens), string(payload))
+	return make([]error, len(tokens))
+}
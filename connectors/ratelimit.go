@@ -0,0 +1,84 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned when a send could not get a rate limit token
+// before its context expired. The item stays pending and is retried later.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit configures a token bucket for a provider.
+type RateLimit struct {
+	Rate    float64 `json:"rate"`     // Sustained sends per second
+	Burst   int     `json:"burst"`    // Maximum burst size (default: max(1, rate))
+	PerHost bool    `json:"per_host"` // One bucket per webhook host instead of per provider
+}
+
+// RateLimiter wraps a Connector with a token bucket. Queue workers block in
+// Send until a token is available, so the worker pool never exceeds the
+// configured rate.
+type RateLimiter struct {
+	next    Connector
+	limit   rate.Limit
+	burst   int
+	perHost bool
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimiter wraps next with the given rate limit.
+func NewRateLimiter(next Connector, cfg RateLimit) (*RateLimiter, error) {
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive")
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = int(cfg.Rate)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &RateLimiter{
+		next:     next,
+		limit:    rate.Limit(cfg.Rate),
+		burst:    burst,
+		perHost:  cfg.PerHost,
+		limiters: map[string]*rate.Limiter{},
+	}, nil
+}
+
+// Unwrap returns the wrapped connector.
+func (r *RateLimiter) Unwrap() Connector {
+	return r.next
+}
+
+func (r *RateLimiter) limiter(token string) *rate.Limiter {
+	key := "*"
+	if r.perHost {
+		key = targetKey(token)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.limiters[key]
+	if !ok {
+		l = rate.NewLimiter(r.limit, r.burst)
+		r.limiters[key] = l
+	}
+	return l
+}
+
+// Send waits for a token and then delivers via the wrapped connector.
+func (r *RateLimiter) Send(ctx context.Context, token string, payload []byte) error {
+	if err := r.limiter(token).Wait(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrRateLimited, err)
+	}
+	return r.next.Send(ctx, token, payload)
+}
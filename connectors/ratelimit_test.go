@@ -0,0 +1,65 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Blocks(t *testing.T) {
+	next := &flakyConnector{}
+	r, err := NewRateLimiter(next, RateLimit{Rate: 1, Burst: 1})
+	if err != nil {
+		t.Fatalf("NewRateLimiter failed: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := r.Send(ctx, "t", nil); err != nil {
+		t.Fatalf("First send should pass: %v", err)
+	}
+
+	// The bucket is empty; a short deadline can't wait for the next token
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := r.Send(short, "t", nil); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+	if next.calls != 1 {
+		t.Errorf("Expected 1 provider call, got %d", next.calls)
+	}
+}
+
+func TestRateLimiter_PerHost(t *testing.T) {
+	next := &flakyConnector{}
+	r, _ := NewRateLimiter(next, RateLimit{Rate: 1, Burst: 1, PerHost: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Send(ctx, "https://a.example.com/hook", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Send(ctx, "https://b.example.com/hook", nil); err != nil {
+		t.Errorf("Different hosts should have separate buckets: %v", err)
+	}
+}
+
+func TestNewRateLimiter_Invalid(t *testing.T) {
+	if _, err := NewRateLimiter(&flakyConnector{}, RateLimit{}); err == nil {
+		t.Error("Expected error for zero rate")
+	}
+}
+
+func TestCircuitBreaker_IgnoresRateLimited(t *testing.T) {
+	b := NewCircuitBreaker("p", &rateLimitedConnector{}, BreakerConfig{FailureThreshold: 1})
+	b.Send(context.Background(), "t", nil)
+	if err := b.Send(context.Background(), "t", nil); err == ErrCircuitOpen {
+		t.Error("Rate limited sends should not open the circuit")
+	}
+}
+
+type rateLimitedConnector struct{}
+
+func (rateLimitedConnector) Send(ctx context.Context, token string, payload []byte) error {
+	return ErrRateLimited
+}
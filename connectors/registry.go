@@ -0,0 +1,95 @@
+package connectors
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Config describes a connector instance, typically loaded from the config file.
+type Config struct {
+	Type     string            `json:"type"`     // Registered connector type, e.g. "webhook", "exec"
+	Name     string            `json:"name"`     // Provider name used by subscriptions; defaults to Type
+	Settings map[string]string `json:"settings"` // Type-specific settings
+}
+
+// Factory builds a connector from its settings.
+type Factory func(settings map[string]string) (Connector, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+func init() {
+	Register("mock", func(map[string]string) (Connector, error) {
+		return NewMockConnector(), nil
+	})
+	Register("apns", func(map[string]string) (Connector, error) {
+		return NewAPNSConnector(), nil
+	})
+	Register("webhook", func(map[string]string) (Connector, error) {
+		return NewWebhookConnector(), nil
+	})
+	Register("fcm", func(settings map[string]string) (Connector, error) {
+		c := NewFCMConnector(settings["credentials"])
+		if c == nil {
+			return nil, fmt.Errorf("failed to initialize FCM connector")
+		}
+		return c, nil
+	})
+	Register("fcm-topic", func(settings map[string]string) (Connector, error) {
+		c := NewFCMConnector(settings["credentials"])
+		if c == nil {
+			return nil, fmt.Errorf("failed to initialize FCM connector")
+		}
+		return NewFCMTopicConnector(c), nil
+	})
+	Register("exec", NewExecConnector)
+	Register("http", NewHTTPConnector)
+	Register("webpush", NewWebPushConnector)
+}
+
+// Register makes a connector type available for config-driven instantiation.
+// Registering an existing type replaces it.
+func Register(typ string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[typ] = f
+}
+
+// Types returns the registered connector types.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New instantiates a connector from its config.
+func New(cfg Config) (Connector, error) {
+	registryMu.RLock()
+	f, ok := registry[cfg.Type]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown connector type: %s", cfg.Type)
+	}
+
+	settings := cfg.Settings
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	return f(settings)
+}
+
+// ProviderName returns the name subscriptions use to select this connector.
+func (c Config) ProviderName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
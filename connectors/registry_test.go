@@ -0,0 +1,57 @@
+package connectors
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRegistry_New(t *testing.T) {
+	c, err := New(Config{Type: "mock"})
+	if err != nil {
+		t.Fatalf("New(mock) failed: %v", err)
+	}
+	if _, ok := c.(*MockConnector); !ok {
+		t.Errorf("Expected *MockConnector, got %T", c)
+	}
+
+	if _, err := New(Config{Type: "does-not-exist"}); err == nil {
+		t.Error("Expected error for unknown type")
+	}
+}
+
+type customConnector struct{ prefix string }
+
+func (c *customConnector) Send(ctx context.Context, token string, payload []byte) error { return nil }
+
+func TestRegistry_Register(t *testing.T) {
+	Register("custom-test", func(settings map[string]string) (Connector, error) {
+		return &customConnector{prefix: settings["prefix"]}, nil
+	})
+
+	c, err := New(Config{Type: "custom-test", Settings: map[string]string{"prefix": "x"}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if cc, ok := c.(*customConnector); !ok || cc.prefix != "x" {
+		t.Errorf("Unexpected connector: %#v", c)
+	}
+
+	found := false
+	for _, typ := range Types() {
+		if typ == "custom-test" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Registered type missing from Types()")
+	}
+}
+
+func TestConfig_ProviderName(t *testing.T) {
+	if name := (Config{Type: "http"}).ProviderName(); name != "http" {
+		t.Errorf("Expected type as default name, got %s", name)
+	}
+	if name := (Config{Type: "http", Name: "sms"}).ProviderName(); name != "sms" {
+		t.Errorf("Expected configured name, got %s", name)
+	}
+}
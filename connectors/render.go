@@ -0,0 +1,184 @@
+package connectors
+
+import (
+	"encoding/json"
+	"sort"
+
+	"no-spam/notification"
+	"no-spam/store"
+)
+
+// unwrap splits a Hub envelope from its inner payload. Payloads that aren't
+// wrapped (direct messages) are returned as-is, with an empty envelope.
+func unwrap(payload []byte) (store.Notification, []byte) {
+	var notif store.Notification
+	if err := json.Unmarshal(payload, &notif); err == nil && len(notif.Payload) > 0 {
+		return notif, notif.Payload
+	}
+	return store.Notification{}, payload
+}
+
+// WebhookRenderer turns a canonical notification into a webhook request body.
+type WebhookRenderer func(topic string, p *notification.Payload) interface{}
+
+// webhookRenderers are selected with the "format" webhook subscription option.
+// Without a format the published payload is forwarded unchanged.
+var webhookRenderers = map[string]WebhookRenderer{
+	"json":    renderWebhookJSON,
+	"slack":   renderSlack,
+	"discord": renderDiscord,
+}
+
+// IsWebhookFormat reports whether format is a known webhook format ("" is the raw payload).
+func IsWebhookFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+	_, ok := webhookRenderers[format]
+	return ok
+}
+
+// renderWebhookJSON emits the canonical notification with the topic attached.
+func renderWebhookJSON(topic string, p *notification.Payload) interface{} {
+	return struct {
+		Topic        string                     `json:"topic,omitempty"`
+		Notification *notification.Notification `json:"notification"`
+		Data         map[string]string          `json:"data,omitempty"`
+	}{topic, p.Notification, p.Data}
+}
+
+// renderSlack builds a Slack incoming-webhook message using Block Kit.
+func renderSlack(_ string, p *notification.Payload) interface{} {
+	n := p.Notification
+	text := n.Body
+	if n.Title != "" {
+		text = "*" + n.Title + "*\n" + n.Body
+	}
+
+	section := map[string]interface{}{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": text},
+	}
+	if n.Icon != "" {
+		section["accessory"] = map[string]string{"type": "image", "image_url": n.Icon, "alt_text": "icon"}
+	}
+	blocks := []interface{}{section}
+
+	if n.Image != "" {
+		blocks = append(blocks, map[string]string{"type": "image", "image_url": n.Image, "alt_text": n.Title})
+	}
+
+	var buttons []interface{}
+	if n.URL != "" {
+		buttons = append(buttons, slackButton("open", "Open", n.URL))
+	}
+	for _, a := range n.Actions {
+		buttons = append(buttons, slackButton(a.ID, a.Title, a.URL))
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "actions", "elements": buttons})
+	}
+
+	fallback := n.Title
+	if fallback == "" {
+		fallback = n.Body
+	}
+	return map[string]interface{}{"text": fallback, "blocks": blocks}
+}
+
+func slackButton(id, title, url string) map[string]interface{} {
+	b := map[string]interface{}{
+		"type":      "button",
+		"action_id": id,
+		"text":      map[string]string{"type": "plain_text", "text": title},
+	}
+	if url != "" {
+		b["url"] = url
+	}
+	return b
+}
+
+// renderDiscord builds a Discord webhook message with a single embed.
+func renderDiscord(_ string, p *notification.Payload) interface{} {
+	n := p.Notification
+	embed := map[string]interface{}{}
+	if n.Title != "" {
+		embed["title"] = n.Title
+	}
+	if n.Body != "" {
+		embed["description"] = n.Body
+	}
+	if n.URL != "" {
+		embed["url"] = n.URL
+	}
+	if n.Icon != "" {
+		embed["thumbnail"] = map[string]string{"url": n.Icon}
+	}
+	if n.Image != "" {
+		embed["image"] = map[string]string{"url": n.Image}
+	}
+	if len(p.Data) > 0 {
+		keys := make([]string, 0, len(p.Data))
+		for k := range p.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var fields []interface{}
+		for _, k := range keys {
+			fields = append(fields, map[string]interface{}{"name": k, "value": p.Data[k], "inline": true})
+		}
+		embed["fields"] = fields
+	}
+	return map[string]interface{}{"embeds": []interface{}{embed}}
+}
+
+// renderAPNS builds an APNS payload: the aps dictionary plus custom keys for
+// the URL, image and data.
+func renderAPNS(p *notification.Payload) map[string]interface{} {
+	n := p.Notification
+	aps := map[string]interface{}{}
+
+	alert := map[string]string{}
+	if n.Title != "" {
+		alert["title"] = n.Title
+	}
+	if n.Body != "" {
+		alert["body"] = n.Body
+	}
+	aps["alert"] = alert
+	if n.Sound != "" {
+		aps["sound"] = n.Sound
+	}
+	if n.Badge != nil {
+		aps["badge"] = *n.Badge
+	}
+	if n.Thread != "" {
+		aps["thread-id"] = n.Thread
+	}
+	if n.Image != "" {
+		// Lets a notification service extension download the image
+		aps["mutable-content"] = 1
+	}
+	if n.Category != "" {
+		c := notification.LookupCategory(n.Category)
+		aps["category"] = c.APNSCategory
+		if c.InterruptionLevel != "" {
+			aps["interruption-level"] = c.InterruptionLevel
+		}
+	}
+
+	out := map[string]interface{}{"aps": aps}
+	for k, v := range p.Data {
+		out[k] = v
+	}
+	if n.URL != "" {
+		out["url"] = n.URL
+	}
+	if n.Image != "" {
+		out["image"] = n.Image
+	}
+	if len(n.Actions) > 0 {
+		out["actions"] = n.Actions
+	}
+	return out
+}
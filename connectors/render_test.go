@@ -0,0 +1,75 @@
+package connectors
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"no-spam/notification"
+)
+
+func TestRenderWebhook(t *testing.T) {
+	body := []byte(`{"notification": {"title": "Deploy", "body": "v2 is live", "url": "https://ci/1",
+		"actions": [{"id": "rollback", "title": "Rollback", "url": "https://ci/1/rollback"}]}, "data": {"env": "prod"}}`)
+
+	tests := []struct {
+		format   string
+		contains []string
+	}{
+		{"json", []string{`"topic":"deploys"`, `"title":"Deploy"`, `"env":"prod"`}},
+		{"slack", []string{`"blocks"`, `*Deploy*\nv2 is live`, `"action_id":"rollback"`, `"text":"Deploy"`}},
+		{"discord", []string{`"embeds"`, `"description":"v2 is live"`, `"name":"env"`}},
+	}
+	for _, tt := range tests {
+		out, err := renderWebhook(tt.format, "deploys", body)
+		if err != nil {
+			t.Fatalf("%s: render failed: %v", tt.format, err)
+		}
+		for _, c := range tt.contains {
+			if !strings.Contains(string(out), c) {
+				t.Errorf("%s: expected %s in %s", tt.format, c, out)
+			}
+		}
+	}
+
+	// Non-canonical payloads pass through unchanged
+	raw := []byte(`{"content": "hello"}`)
+	out, err := renderWebhook("slack", "t", raw)
+	if err != nil || string(out) != string(raw) {
+		t.Errorf("Expected raw payload, got %s (%v)", out, err)
+	}
+
+	if _, err := renderWebhook("teams", "t", body); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestRenderAPNS(t *testing.T) {
+	badge := 2
+	out := renderAPNS(&notification.Payload{
+		Notification: &notification.Notification{Title: "T", Body: "B", Sound: "ping.aiff", Badge: &badge, Thread: "chat-7", Image: "https://img"},
+		Data:         map[string]string{"id": "1"},
+	})
+
+	data, _ := json.Marshal(out)
+	for _, c := range []string{`"alert":{"body":"B","title":"T"}`, `"badge":2`, `"sound":"ping.aiff"`, `"thread-id":"chat-7"`, `"mutable-content":1`, `"id":"1"`} {
+		if !strings.Contains(string(data), c) {
+			t.Errorf("Expected %s in %s", c, data)
+		}
+	}
+}
+
+func TestRenderAPNS_Category(t *testing.T) {
+	notification.SetCategories(notification.Categories{"security": {APNSCategory: "SECURITY", InterruptionLevel: "time-sensitive"}})
+	defer notification.SetCategories(nil)
+
+	for category, want := range map[string]string{
+		"security":  `"category":"SECURITY","interruption-level":"time-sensitive"`,
+		"marketing": `"category":"marketing"}`,
+	} {
+		out := renderAPNS(&notification.Payload{Notification: &notification.Notification{Title: "T", Category: category}})
+		if data, _ := json.Marshal(out); !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in %s", want, data)
+		}
+	}
+}
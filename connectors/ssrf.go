@@ -0,0 +1,191 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ErrTargetNotAllowed is returned when a webhook URL violates the URL policy.
+var ErrTargetNotAllowed = errors.New("webhook target not allowed")
+
+// ErrTargetUnresolved is returned when a target's host can't be resolved.
+// Unlike ErrTargetNotAllowed, it may be temporary, e.g. during a DNS outage.
+var ErrTargetUnresolved = errors.New("webhook target could not be resolved")
+
+// TargetValidator is implemented by connectors that can reject a subscription
+// target (token) before it is stored.
+type TargetValidator interface {
+	ValidateTarget(ctx context.Context, token string) error
+}
+
+// URLPolicyConfig is the config file representation of a URLPolicy.
+type URLPolicyConfig struct {
+	AllowedSchemes []string `json:"allowed_schemes"` // Default: https, http
+	AllowHosts     []string `json:"allow_hosts"`     // If set, only these hosts (and subdomains) are allowed
+	DenyHosts      []string `json:"deny_hosts"`      // Hosts (and subdomains) that are always rejected
+	AllowCIDRs     []string `json:"allow_cidrs"`     // Ranges allowed even if private
+	DenyCIDRs      []string `json:"deny_cidrs"`      // Additional ranges to reject
+	AllowPrivate   bool     `json:"allow_private"`   // Allow loopback, private and link-local addresses
+}
+
+// URLPolicy restricts which destinations outbound webhooks may reach.
+type URLPolicy struct {
+	schemes      map[string]bool
+	allowHosts   []string
+	denyHosts    []string
+	allowCIDRs   []*net.IPNet
+	denyCIDRs    []*net.IPNet
+	allowPrivate bool
+	resolver     *net.Resolver
+}
+
+// blockedRanges are rejected unless AllowPrivate is set or an AllowCIDRs entry matches.
+var blockedRanges = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10", // Carrier-grade NAT
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96", // NAT64
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// NewURLPolicy builds a URLPolicy from its config.
+func NewURLPolicy(cfg URLPolicyConfig) (*URLPolicy, error) {
+	p := &URLPolicy{
+		schemes:      map[string]bool{},
+		allowPrivate: cfg.AllowPrivate,
+		resolver:     net.DefaultResolver,
+	}
+
+	schemes := cfg.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"https", "http"}
+	}
+	for _, s := range schemes {
+		p.schemes[strings.ToLower(s)] = true
+	}
+
+	for _, h := range cfg.AllowHosts {
+		p.allowHosts = append(p.allowHosts, strings.ToLower(strings.TrimPrefix(h, "*.")))
+	}
+	for _, h := range cfg.DenyHosts {
+		p.denyHosts = append(p.denyHosts, strings.ToLower(strings.TrimPrefix(h, "*.")))
+	}
+
+	var err error
+	if p.allowCIDRs, err = parseCIDRs(cfg.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	if p.denyCIDRs, err = parseCIDRs(cfg.DenyCIDRs); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// matchHost reports whether host equals an entry or is a subdomain of one.
+func matchHost(host string, entries []string) bool {
+	for _, e := range entries {
+		if host == e || strings.HasSuffix(host, "."+e) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIP rejects addresses in denied or (unless allowed) internal ranges.
+func (p *URLPolicy) CheckIP(ip net.IP) error {
+	if containsIP(p.denyCIDRs, ip) {
+		return fmt.Errorf("%w: address %s is denied", ErrTargetNotAllowed, ip)
+	}
+	if p.allowPrivate || containsIP(p.allowCIDRs, ip) {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || containsIP(blockedRanges, ip) {
+		return fmt.Errorf("%w: address %s is internal", ErrTargetNotAllowed, ip)
+	}
+	return nil
+}
+
+// Check validates the scheme and host of rawURL and resolves the host to make
+// sure none of its addresses are internal.
+func (p *URLPolicy) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: invalid URL", ErrTargetNotAllowed)
+	}
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrTargetNotAllowed, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if matchHost(host, p.denyHosts) {
+		return fmt.Errorf("%w: host %s is denied", ErrTargetNotAllowed, host)
+	}
+	if len(p.allowHosts) > 0 && !matchHost(host, p.allowHosts) {
+		return fmt.Errorf("%w: host %s is not in the allow list", ErrTargetNotAllowed, host)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrTargetUnresolved, host, err)
+	}
+	for _, a := range addrs {
+		if err := p.CheckIP(a.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialControl re-checks the address actually dialed, so a host that resolves
+// differently at send time (DNS rebinding) still can't reach internal ranges.
+func (p *URLPolicy) dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: unexpected dial address %s", ErrTargetNotAllowed, address)
+	}
+	return p.CheckIP(ip)
+}
@@ -0,0 +1,103 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestURLPolicy_Check(t *testing.T) {
+	p, err := NewURLPolicy(URLPolicyConfig{
+		AllowedSchemes: []string{"https"},
+		DenyHosts:      []string{"evil.example"},
+		DenyCIDRs:      []string{"203.0.113.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("NewURLPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"http://93.184.216.34/hook", false},
+		{"ftp://93.184.216.34/hook", false},
+		{"https://127.0.0.1/hook", false},
+		{"https://10.1.2.3/hook", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://[::1]/hook", false},
+		{"https://[fd00::1]/hook", false},
+		{"https://100.64.0.1/hook", false},
+		{"https://203.0.113.5/hook", false},
+		{"https://evil.example/hook", false},
+		{"https://api.evil.example/hook", false},
+		{"https://localhost/hook", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		err := p.Check(context.Background(), tt.url)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: expected allowed, got %v", tt.url, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrTargetNotAllowed) {
+			t.Errorf("%s: expected ErrTargetNotAllowed, got %v", tt.url, err)
+		}
+	}
+}
+
+func TestURLPolicy_AllowLists(t *testing.T) {
+	p, err := NewURLPolicy(URLPolicyConfig{
+		AllowHosts: []string{"*.hooks.example", "10.0.0.5"},
+		AllowCIDRs: []string{"10.0.0.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("NewURLPolicy failed: %v", err)
+	}
+
+	if err := p.Check(context.Background(), "http://10.0.0.5/hook"); err != nil {
+		t.Errorf("Expected allow-listed private address to pass, got %v", err)
+	}
+	if err := p.Check(context.Background(), "https://93.184.216.34/hook"); err == nil {
+		t.Error("Expected host outside allow list to be rejected")
+	}
+
+	if _, err := NewURLPolicy(URLPolicyConfig{DenyCIDRs: []string{"bogus"}}); err == nil {
+		t.Error("Expected invalid CIDR to fail")
+	}
+}
+
+func TestWebhookSend_BlockedByPolicy(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	p, _ := NewURLPolicy(URLPolicyConfig{})
+	c := NewWebhookConnector()
+	c.SetURLPolicy(p)
+
+	err := c.Send(context.Background(), server.URL, []byte(`{}`))
+	if !errors.Is(err, ErrTargetNotAllowed) {
+		t.Errorf("Expected ErrTargetNotAllowed, got %v", err)
+	}
+	if called {
+		t.Error("Server should not have been called")
+	}
+}
+
+func TestURLPolicy_DialControl(t *testing.T) {
+	p, _ := NewURLPolicy(URLPolicyConfig{})
+
+	// Simulates a hostname that passed Check but resolves to loopback at dial time
+	if err := p.dialControl("tcp", net.JoinHostPort("127.0.0.1", "80"), nil); !errors.Is(err, ErrTargetNotAllowed) {
+		t.Errorf("Expected dial to loopback to be rejected, got %v", err)
+	}
+	if err := p.dialControl("tcp", net.JoinHostPort("93.184.216.34", "443"), nil); err != nil {
+		t.Errorf("Expected dial to public address to pass, got %v", err)
+	}
+}
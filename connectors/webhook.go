@@ -2,52 +2,227 @@ package connectors
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"no-spam/notification"
 	"no-spam/store"
+	"strconv"
 	"time"
 )
 
 type WebhookConnector struct {
 	client *http.Client
+	policy *URLPolicy
 }
 
+// defaultWebhookTimeout bounds each attempt of subscriptions without a
+// timeout_ms. The client itself has no timeout, which would cap theirs.
+var defaultWebhookTimeout = 5 * time.Second
+
 func NewWebhookConnector() *WebhookConnector {
-	return &WebhookConnector{
-		client: &http.Client{
-			// Shorter timeout for webhooks
-			Timeout: 5 * time.Second,
+	return &WebhookConnector{client: &http.Client{}}
+}
+
+// SetURLPolicy restricts webhook destinations. The policy is enforced both
+// when validating subscription targets and on every connection the client dials.
+func (c *WebhookConnector) SetURLPolicy(p *URLPolicy) {
+	c.policy = p
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.dialControl,
+	}
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			// No proxy: the dial check must see the real destination
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("stopped after 5 redirects")
+			}
+			return p.Check(req.Context(), req.URL.String())
 		},
 	}
 }
 
+// ValidateTarget checks a webhook URL against the URL policy.
+func (c *WebhookConnector) ValidateTarget(ctx context.Context, token string) error {
+	if c.policy == nil {
+		return nil
+	}
+	return c.policy.Check(ctx, token)
+}
+
+type webhookOptionsKey struct{}
+
+// WithWebhookOptions attaches per-subscription webhook options to ctx.
+func WithWebhookOptions(ctx context.Context, opts *store.WebhookOptions) context.Context {
+	if opts == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, webhookOptionsKey{}, opts)
+}
+
+func webhookOptionsFrom(ctx context.Context) *store.WebhookOptions {
+	opts, _ := ctx.Value(webhookOptionsKey{}).(*store.WebhookOptions)
+	return opts
+}
+
+type unsubscribeLinksKey struct{}
+
+// WithUnsubscribeLinks attaches a function building a subscription's
+// unsubscribe link to ctx. Topic webhooks send it in a List-Unsubscribe header.
+func WithUnsubscribeLinks(ctx context.Context, link func(topic, token string) string) context.Context {
+	return context.WithValue(ctx, unsubscribeLinksKey{}, link)
+}
+
+// unsubscribeLink returns the unsubscribe link of a subscription, or "".
+func unsubscribeLink(ctx context.Context, topic, token string) string {
+	link, _ := ctx.Value(unsubscribeLinksKey{}).(func(topic, token string) string)
+	if link == nil || topic == "" {
+		return ""
+	}
+	return link(topic, token)
+}
+
+// retryBackoff is the base delay between webhook retries; it doubles per attempt.
+var retryBackoff = 500 * time.Millisecond
+
 func (c *WebhookConnector) Send(ctx context.Context, token string, payload []byte) error {
 	// For Webhook Connector, token is the Webhook URL
 	webhookURL := token
 	if webhookURL == "" {
-		return fmt.Errorf("webhook url is missing")
+		return Permanent(fmt.Errorf("webhook url is missing"))
+	}
+	if err := c.ValidateTarget(ctx, webhookURL); err != nil {
+		// A denied URL stays denied, but a failed lookup may succeed later
+		if errors.Is(err, ErrTargetNotAllowed) {
+			return Permanent(err)
+		}
+		return err
 	}
 
 	// Unwrap the payload if it's wrapped in a store.Notification (from Hub)
-	var notif store.Notification
-	var body []byte
-	if err := json.Unmarshal(payload, &notif); err == nil && len(notif.Payload) > 0 {
-		// It's a wrapped notification, send the inner payload
-		body = notif.Payload
-	} else {
-		// Fallback: send original payload (maybe not wrapped)
-		body = payload
+	envelope, body := unwrap(payload)
+	topic := envelope.Topic
+
+	opts := webhookOptionsFrom(ctx)
+	if opts != nil && opts.Format != "" {
+		rendered, err := renderWebhook(opts.Format, topic, body)
+		if err != nil {
+			return err
+		}
+		body = rendered
 	}
+	if opts != nil && opts.ContentEncoding == "gzip" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	unsubscribe := unsubscribeLink(ctx, topic, token)
+	method := "POST"
+	retries := 0
+	if opts != nil {
+		if opts.Method != "" {
+			method = opts.Method
+		}
+		retries = opts.MaxRetries
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff << (attempt - 1)):
+			case <-ctx.Done():
+				return fmt.Errorf("webhook retry aborted: %w (last error: %v)", ctx.Err(), err)
+			}
+		}
+		if err = c.send(ctx, method, webhookURL, body, opts, unsubscribe, envelope); err == nil || IsPermanent(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Preview returns the request body of every webhook format, "raw" being the
+// body of subscriptions without a format.
+func (c *WebhookConnector) Preview(payload []byte) (json.RawMessage, error) {
+	envelope, body := unwrap(payload)
+	bodies := map[string]json.RawMessage{"raw": body}
+	for format := range webhookRenderers {
+		rendered, err := renderWebhook(format, envelope.Topic, body)
+		if err != nil {
+			return nil, err
+		}
+		bodies[format] = rendered
+	}
+	return json.Marshal(bodies)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewBuffer(body))
+// renderWebhook renders a canonical notification in the given format.
+// Payloads that don't use the canonical structure are sent unchanged.
+func renderWebhook(format, topic string, body []byte) ([]byte, error) {
+	render, ok := webhookRenderers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown webhook format: %s", format)
+	}
+	p, err := notification.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return body, nil
+	}
+	return json.Marshal(render(topic, p))
+}
+
+func (c *WebhookConnector) send(ctx context.Context, method, webhookURL string, body []byte, opts *store.WebhookOptions, unsubscribe string, envelope store.Notification) error {
+	timeout := defaultWebhookTimeout
+	if opts != nil && opts.TimeoutMs > 0 {
+		timeout = time.Duration(opts.TimeoutMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, webhookURL, bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Assume JSON payload
 	req.Header.Set("Content-Type", "application/json")
+	if unsubscribe != "" {
+		req.Header.Set("List-Unsubscribe", "<"+unsubscribe+">")
+	}
+	if envelope.DeliveryID != "" {
+		// Same on every retry of the delivery, so receivers can drop copies
+		req.Header.Set("Idempotency-Key", envelope.DeliveryID)
+	}
+	if envelope.Seq != 0 {
+		req.Header.Set("X-No-Spam-Sequence", strconv.FormatInt(envelope.Seq, 10))
+	}
+	if opts != nil {
+		for k, v := range opts.Headers {
+			req.Header.Set(k, v)
+		}
+		if opts.ContentEncoding != "" {
+			req.Header.Set("Content-Encoding", opts.ContentEncoding)
+		}
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -56,8 +231,22 @@ func (c *WebhookConnector) Send(ctx context.Context, token string, payload []byt
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("webhook failed with status: %d", resp.StatusCode)
+		err := fmt.Errorf("webhook failed with status: %d", resp.StatusCode)
+		if permanentStatus(resp.StatusCode) {
+			return Permanent(err)
+		}
+		return err
 	}
 
 	return nil
 }
+
+// permanentStatus reports whether a webhook response status means the
+// request will never succeed as is. Timeouts and throttling are retried.
+func permanentStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return code >= 400 && code < 500
+}
@@ -1,9 +1,12 @@
 package connectors
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"no-spam/store"
@@ -19,8 +22,33 @@ func TestNewWebhookConnector(t *testing.T) {
 	if wc.client == nil {
 		t.Error("Client was not initialized")
 	}
-	if wc.client.Timeout != 5*time.Second {
-		t.Errorf("Expected timeout 5s, got %v", wc.client.Timeout)
+	if wc.client.Timeout != 0 {
+		t.Errorf("Expected no client timeout, which would cap per-subscription ones, got %v", wc.client.Timeout)
+	}
+	wc.SetURLPolicy(&URLPolicy{})
+	if wc.client.Timeout != 0 {
+		t.Errorf("Expected no client timeout with a URL policy, got %v", wc.client.Timeout)
+	}
+}
+
+func TestWebhookSend_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	defaultWebhookTimeout = 20 * time.Millisecond
+	defer func() { defaultWebhookTimeout = 5 * time.Second }()
+
+	wc := NewWebhookConnector()
+	if err := wc.Send(context.Background(), server.URL, []byte(`{}`)); err == nil {
+		t.Error("Expected the default timeout to cut a slow attempt short")
+	}
+	// A longer timeout_ms isn't capped by the default
+	ctx := WithWebhookOptions(context.Background(), &store.WebhookOptions{TimeoutMs: 1000})
+	if err := wc.Send(ctx, server.URL, []byte(`{}`)); err != nil {
+		t.Errorf("Expected a slow attempt within timeout_ms to succeed, got %v", err)
 	}
 }
 
@@ -124,3 +152,207 @@ func TestWebhookSend_Errors(t *testing.T) {
 		t.Error("Expected error for network failure")
 	}
 }
+
+func TestWebhookSend_Options(t *testing.T) {
+	attempts := 0
+	var method, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		method = r.Method
+		auth = r.Header.Get("Authorization")
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 500 * time.Millisecond }()
+
+	wc := NewWebhookConnector()
+	ctx := WithWebhookOptions(context.Background(), &store.WebhookOptions{
+		Method:     "PUT",
+		Headers:    map[string]string{"Authorization": "Bearer secret"},
+		TimeoutMs:  1000,
+		MaxRetries: 2,
+	})
+
+	if err := wc.Send(ctx, server.URL, []byte(`{}`)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+	if method != "PUT" || auth != "Bearer secret" {
+		t.Errorf("Options not applied: method=%s auth=%s", method, auth)
+	}
+}
+
+func TestWebhookSend_PermanentErrors(t *testing.T) {
+	attempts := 0
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 500 * time.Millisecond }()
+
+	wc := NewWebhookConnector()
+	ctx := WithWebhookOptions(context.Background(), &store.WebhookOptions{MaxRetries: 2})
+
+	err := wc.Send(ctx, server.URL, []byte(`{}`))
+	if !IsPermanent(err) {
+		t.Errorf("Expected a permanent error for 404, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Permanent errors should not be retried, got %d attempts", attempts)
+	}
+
+	attempts, status = 0, http.StatusTooManyRequests
+	if err := wc.Send(ctx, server.URL, []byte(`{}`)); err == nil || IsPermanent(err) {
+		t.Errorf("Expected a retryable error for 429, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts for 429, got %d", attempts)
+	}
+
+	if err := wc.Send(context.Background(), "", nil); !IsPermanent(err) {
+		t.Errorf("Expected a missing URL to be permanent, got %v", err)
+	}
+
+	// A URL rejected by the policy is never attempted nor retried
+	policy, err := NewURLPolicy(URLPolicyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.SetURLPolicy(policy)
+	attempts = 0
+	err = wc.Send(ctx, server.URL, []byte(`{}`))
+	if !IsPermanent(err) || !errors.Is(err, ErrTargetNotAllowed) {
+		t.Errorf("Expected a permanent ErrTargetNotAllowed for a loopback URL, got %v", err)
+	}
+	if attempts != 0 {
+		t.Errorf("Expected no attempt for a rejected URL, got %d", attempts)
+	}
+
+	// A failed lookup may succeed later, so the delivery stays pending
+	policy.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("resolver down")
+	}}
+	err = wc.Send(ctx, "https://hooks.example.com/hook", []byte(`{}`))
+	if IsPermanent(err) || !errors.Is(err, ErrTargetUnresolved) {
+		t.Errorf("Expected a retryable ErrTargetUnresolved when resolving fails, got %v", err)
+	}
+}
+
+func TestWebhookSend_UnsubscribeLink(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("List-Unsubscribe")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	wc := NewWebhookConnector()
+	ctx := WithUnsubscribeLinks(context.Background(), func(topic, token string) string {
+		return "https://push.example.com/unsubscribe?sig=" + topic
+	})
+	notif, _ := json.Marshal(store.Notification{Topic: "news", Payload: json.RawMessage(`{}`)})
+	if err := wc.Send(ctx, server.URL, notif); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if received != "<https://push.example.com/unsubscribe?sig=news>" {
+		t.Errorf("Unexpected List-Unsubscribe header %q", received)
+	}
+
+	// Raw payloads have no topic to unsubscribe from
+	received = ""
+	if err := wc.Send(ctx, server.URL, []byte(`{}`)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if received != "" {
+		t.Errorf("Expected no List-Unsubscribe header, got %q", received)
+	}
+}
+
+func TestWebhookSend_DeliveryHeaders(t *testing.T) {
+	var received []string
+	var seq string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Idempotency-Key"))
+		seq = r.Header.Get("X-No-Spam-Sequence")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 500 * time.Millisecond }()
+
+	wc := NewWebhookConnector()
+	ctx := WithWebhookOptions(context.Background(), &store.WebhookOptions{MaxRetries: 1})
+	notif, _ := json.Marshal(store.Notification{Topic: "news", DeliveryID: "d1", Seq: 7, Payload: json.RawMessage(`{}`)})
+	if err := wc.Send(ctx, server.URL, notif); err == nil {
+		t.Fatal("Expected the send to fail")
+	}
+	if len(received) != 2 || received[0] != "d1" || received[1] != "d1" {
+		t.Errorf("Expected every attempt to carry the delivery ID, got %q", received)
+	}
+	if seq != "7" {
+		t.Errorf("Expected sequence number 7, got %q", seq)
+	}
+}
+
+func TestWebhookSend_Gzip(t *testing.T) {
+	var encoding string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(r.Body)
+		if err == nil {
+			body, _ = io.ReadAll(zr)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	wc := NewWebhookConnector()
+	ctx := WithWebhookOptions(context.Background(), &store.WebhookOptions{ContentEncoding: "gzip"})
+	notif, _ := json.Marshal(store.Notification{Topic: "news", Payload: json.RawMessage(`{"title":"hi"}`)})
+	if err := wc.Send(ctx, server.URL, notif); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if encoding != "gzip" {
+		t.Errorf("Expected Content-Encoding gzip, got %q", encoding)
+	}
+	if string(body) != `{"title":"hi"}` {
+		t.Errorf("Expected the gzipped payload, got %q", body)
+	}
+}
+
+func TestWebhookPreview(t *testing.T) {
+	payload := []byte(`{"topic":"deploys","payload":{"notification":{"title":"Deploy","body":"v2 is live"}}}`)
+	out, err := NewWebhookConnector().Preview(payload)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	var bodies map[string]json.RawMessage
+	if err := json.Unmarshal(out, &bodies); err != nil {
+		t.Fatalf("Invalid preview %s: %v", out, err)
+	}
+	if string(bodies["raw"]) != `{"notification":{"title":"Deploy","body":"v2 is live"}}` {
+		t.Errorf("Expected the raw payload, got %s", bodies["raw"])
+	}
+	for _, format := range []string{"json", "slack", "discord"} {
+		if len(bodies[format]) == 0 {
+			t.Errorf("Expected a %s preview, got %s", format, out)
+		}
+	}
+	if _, err := NewWebhookConnector().Preview([]byte(`{"topic":"deploys","payload":{"notification":{}}}`)); err == nil {
+		t.Error("Expected an invalid notification to fail")
+	}
+}
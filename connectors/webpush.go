@@ -0,0 +1,317 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// maxWebPushPayload is the largest payload push services must accept, less
+// the encryption overhead of one aes128gcm record.
+const maxWebPushPayload = 4096 - 86 - 17
+
+// WebPushSubscription is the token of a browser subscription: the JSON
+// form of the PushSubscription a browser returns from pushManager.subscribe.
+type WebPushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// ParseWebPushSubscription parses and checks a browser subscription token.
+func ParseWebPushSubscription(token string) (*WebPushSubscription, error) {
+	var sub WebPushSubscription
+	if err := json.Unmarshal([]byte(token), &sub); err != nil {
+		return nil, fmt.Errorf("invalid push subscription: %w", err)
+	}
+	if u, err := url.Parse(sub.Endpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid push subscription: endpoint must be an https URL")
+	}
+	if _, err := sub.publicKey(); err != nil {
+		return nil, err
+	}
+	if auth, err := decodeBase64URL(sub.Keys.Auth); err != nil || len(auth) != 16 {
+		return nil, fmt.Errorf("invalid push subscription: auth must be 16 bytes")
+	}
+	return &sub, nil
+}
+
+func (s *WebPushSubscription) publicKey() (*ecdh.PublicKey, error) {
+	raw, err := decodeBase64URL(s.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push subscription: p256dh: %w", err)
+	}
+	key, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push subscription: p256dh: %w", err)
+	}
+	return key, nil
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers
+// and key generators differ.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// WebPushConnector delivers to browsers through the Web Push protocol
+// (RFC 8030), encrypting payloads (RFC 8291) and identifying the server with
+// VAPID (RFC 8292). Tokens are JSON push subscriptions.
+type WebPushConnector struct {
+	key       *ecdsa.PrivateKey
+	publicKey string // Uncompressed P-256 point, base64url
+	subject   string
+	ttl       time.Duration
+	client    *http.Client
+	policy    *URLPolicy
+}
+
+// NewWebPushConnector creates a WebPushConnector from settings
+// "vapid_private_key" (the base64url P-256 private key, as printed by
+// common VAPID key generators), "vapid_subject" (a mailto: or https: contact)
+// and optional "ttl" (Go duration push services keep undelivered messages,
+// default 24h).
+func NewWebPushConnector(settings map[string]string) (Connector, error) {
+	if settings["vapid_private_key"] == "" {
+		return nil, fmt.Errorf("webpush connector requires a vapid_private_key setting")
+	}
+	key, public, err := parseVAPIDKey(settings["vapid_private_key"])
+	if err != nil {
+		return nil, err
+	}
+	subject := settings["vapid_subject"]
+	if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https:") {
+		return nil, fmt.Errorf("webpush connector requires a mailto: or https: vapid_subject setting")
+	}
+	ttl := 24 * time.Hour
+	if v := settings["ttl"]; v != "" {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid webpush ttl: %q", v)
+		}
+	}
+	return &WebPushConnector{
+		key:       key,
+		publicKey: base64.RawURLEncoding.EncodeToString(public),
+		subject:   subject,
+		ttl:       ttl,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// parseVAPIDKey returns the signing key and its uncompressed public key.
+func parseVAPIDKey(s string) (*ecdsa.PrivateKey, []byte, error) {
+	raw, err := decodeBase64URL(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid vapid_private_key: %w", err)
+	}
+	priv, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid vapid_private_key: %w", err)
+	}
+	pub := priv.PublicKey().Bytes() // 0x04 || X || Y
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}, pub, nil
+}
+
+// PublicKey returns the VAPID public key browsers pass as the
+// applicationServerKey when subscribing.
+func (c *WebPushConnector) PublicKey() string {
+	return c.publicKey
+}
+
+// SetURLPolicy restricts push endpoints like webhook destinations, since
+// anyone may submit a subscription through a public topic page.
+func (c *WebPushConnector) SetURLPolicy(p *URLPolicy) {
+	c.policy = p
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.dialControl,
+	}
+	c.client = &http.Client{
+		Timeout: c.client.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return fmt.Errorf("push service redirected to %s", req.URL.Host)
+		},
+	}
+}
+
+// ValidateTarget checks a push subscription and its endpoint.
+func (c *WebPushConnector) ValidateTarget(ctx context.Context, token string) error {
+	sub, err := ParseWebPushSubscription(token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTargetNotAllowed, err)
+	}
+	if c.policy == nil {
+		return nil
+	}
+	return c.policy.Check(ctx, sub.Endpoint)
+}
+
+// Preview returns the payload Send would encrypt, which browsers receive as is.
+func (c *WebPushConnector) Preview(payload []byte) (json.RawMessage, error) {
+	if len(payload) > maxWebPushPayload {
+		return nil, fmt.Errorf("payload of %d bytes exceeds the web push limit of %d", len(payload), maxWebPushPayload)
+	}
+	return payload, nil
+}
+
+// Send encrypts the payload for the browser and posts it to its push service.
+func (c *WebPushConnector) Send(ctx context.Context, token string, payload []byte) error {
+	sub, err := ParseWebPushSubscription(token)
+	if err != nil {
+		return Permanent(err)
+	}
+	if len(payload) > maxWebPushPayload {
+		return Permanent(fmt.Errorf("payload of %d bytes exceeds the web push limit of %d", len(payload), maxWebPushPayload))
+	}
+	body, err := encryptWebPush(sub, payload)
+	if err != nil {
+		return Permanent(err)
+	}
+	auth, err := c.vapidAuthorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(c.ttl.Seconds())))
+	req.Header.Set("Authorization", auth)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("web push request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("push service returned status: %d", resp.StatusCode)
+	default:
+		// 404 and 410 mean the subscription expired or was revoked
+		return Permanent(fmt.Errorf("push service returned status: %d", resp.StatusCode))
+	}
+}
+
+// vapidAuthorization signs a VAPID token for the endpoint's push service.
+func (c *WebPushConnector) vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", Permanent(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": c.subject,
+	})
+	signed, err := token.SignedString(c.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign VAPID token: %w", err)
+	}
+	return "vapid t=" + signed + ", k=" + c.publicKey, nil
+}
+
+// encryptWebPush encrypts payload as a single aes128gcm record (RFC 8188)
+// with keys derived as in RFC 8291.
+func encryptWebPush(sub *WebPushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := sub.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeBase64URL(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push subscription: auth: %w", err)
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic.Bytes()...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdf.Key(sha256.New, secret, authSecret, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key ID length and the key ID (our public key)
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, 4096)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	plaintext := append(append([]byte{}, payload...), 0x02) // Last record delimiter
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
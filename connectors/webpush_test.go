@@ -0,0 +1,176 @@
+package connectors
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testBrowser holds the keys a browser creates when subscribing.
+type testBrowser struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newTestBrowser(t *testing.T) *testBrowser {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &testBrowser{key: key, auth: auth}
+}
+
+func (b *testBrowser) subscription(endpoint string) string {
+	var sub WebPushSubscription
+	sub.Endpoint = endpoint
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(b.key.PublicKey().Bytes())
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(b.auth)
+	data, _ := json.Marshal(sub)
+	return string(data)
+}
+
+// decrypt reverses encryptWebPush as a browser would.
+func (b *testBrowser) decrypt(t *testing.T, body []byte) []byte {
+	salt, idLen := body[:16], int(body[20])
+	asPublic, err := ecdh.P256().NewPublicKey(body[21 : 21+idLen])
+	if err != nil {
+		t.Fatalf("Invalid key ID: %v", err)
+	}
+	secret, _ := b.key.ECDH(asPublic)
+	info := append([]byte("WebPush: info\x00"), b.key.PublicKey().Bytes()...)
+	info = append(info, asPublic.Bytes()...)
+	ikm, _ := hkdf.Key(sha256.New, secret, b.auth, string(info), 32)
+	cek, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	return plaintext[:len(plaintext)-1] // Record delimiter
+}
+
+func newTestWebPushConnector(t *testing.T, client *http.Client) *WebPushConnector {
+	key, _ := ecdh.P256().GenerateKey(rand.Reader)
+	c, err := NewWebPushConnector(map[string]string{
+		"vapid_private_key": base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		"vapid_subject":     "mailto:ops@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewWebPushConnector failed: %v", err)
+	}
+	wc := c.(*WebPushConnector)
+	wc.client = client
+	return wc
+}
+
+func TestNewWebPushConnector_Settings(t *testing.T) {
+	key, _ := ecdh.P256().GenerateKey(rand.Reader)
+	private := base64.RawURLEncoding.EncodeToString(key.Bytes())
+	for _, settings := range []map[string]string{
+		{},
+		{"vapid_private_key": "not-a-key", "vapid_subject": "mailto:ops@example.com"},
+		{"vapid_private_key": private},
+		{"vapid_private_key": private, "vapid_subject": "mailto:ops@example.com", "ttl": "soon"},
+	} {
+		if _, err := NewWebPushConnector(settings); err == nil {
+			t.Errorf("Expected error for settings %v", settings)
+		}
+	}
+
+	c, err := NewWebPushConnector(map[string]string{"vapid_private_key": private, "vapid_subject": "https://example.com"})
+	if err != nil {
+		t.Fatalf("NewWebPushConnector failed: %v", err)
+	}
+	if got := c.(*WebPushConnector).PublicKey(); got != base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()) {
+		t.Errorf("Unexpected public key %s", got)
+	}
+}
+
+func TestWebPushSend(t *testing.T) {
+	browser := newTestBrowser(t)
+	var body []byte
+	var header http.Header
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	wc := newTestWebPushConnector(t, server.Client())
+	payload := []byte(`{"topic":"news","payload":{"notification":{"title":"Hi"}}}`)
+	if err := wc.Send(context.Background(), browser.subscription(server.URL+"/push/abc"), payload); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if header.Get("Content-Encoding") != "aes128gcm" || header.Get("TTL") != "86400" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+	if got := browser.decrypt(t, body); string(got) != string(payload) {
+		t.Errorf("Expected payload %s, got %s", payload, got)
+	}
+
+	// The VAPID token is signed for the push service's origin
+	auth := strings.TrimPrefix(header.Get("Authorization"), "vapid t=")
+	signed, k, _ := strings.Cut(auth, ", k=")
+	if k != wc.PublicKey() {
+		t.Errorf("Expected k=%s, got %s", wc.PublicKey(), k)
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (any, error) { return &wc.key.PublicKey, nil },
+		jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience(server.URL))
+	if err != nil {
+		t.Errorf("Invalid VAPID token: %v", err)
+	}
+}
+
+func TestWebPushSend_Errors(t *testing.T) {
+	browser := newTestBrowser(t)
+	status := http.StatusGone
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	wc := newTestWebPushConnector(t, server.Client())
+	ctx := context.Background()
+	token := browser.subscription(server.URL)
+
+	// An expired subscription won't come back
+	if err := wc.Send(ctx, token, []byte(`{}`)); !IsPermanent(err) {
+		t.Errorf("Expected a permanent error for 410, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := wc.Send(ctx, token, []byte(`{}`)); err == nil || IsPermanent(err) {
+		t.Errorf("Expected a retryable error for 503, got %v", err)
+	}
+	if err := wc.Send(ctx, token, make([]byte, maxWebPushPayload+1)); !IsPermanent(err) {
+		t.Errorf("Expected a permanent error for an oversized payload, got %v", err)
+	}
+
+	for _, bad := range []string{
+		"not json",
+		browser.subscription("http://push.example.com/abc"),
+		`{"endpoint": "https://push.example.com/abc", "keys": {"p256dh": "AAAA", "auth": "AAAA"}}`,
+	} {
+		if err := wc.ValidateTarget(ctx, bad); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package cron
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		if _, err := Parse(expr); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q): expected ErrInvalid, got %v", expr, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 3, 10, 8, 59, 30, 0, time.UTC) // A Tuesday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"30 8 * * *", time.Date(2026, 3, 11, 8, 30, 0, 0, time.UTC)},
+		{"*/15 8-18 * * 1-5", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"0 10 * * 6,7", time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2026, 3, 10, 9, 5, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		// Either restricted day field matches
+		{"0 0 13 * 5", time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 11 * 5", time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	s, _ := Parse("0 9 * * *")
+	// Clocks went forward overnight; 09:00 is still 09:00 local time
+	got := s.Next(time.Date(2026, 3, 28, 12, 0, 0, 0, loc))
+	if want := time.Date(2026, 3, 29, 9, 0, 0, 0, loc); !got.Equal(want) || got.Hour() != 9 {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// 02:30 doesn't exist on the 29th
+	s, _ = Parse("30 2 * * *")
+	got = s.Next(time.Date(2026, 3, 28, 12, 0, 0, 0, loc))
+	if want := time.Date(2026, 3, 30, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -38,7 +38,7 @@ func TestMain(m *testing.M) {
 	// Run server in goroutine
 	go func() {
 		if _, err := os.Stat(cfg.CertFile); os.IsNotExist(err) {
-			_ = generateSelfSignedCert(cfg.CertFile, cfg.KeyFile)
+			_ = generateSelfSignedCert(cfg.CertFile, cfg.KeyFile, certOptions{})
 		}
 		if err := srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("Server failed: %v\n", err)
@@ -342,3 +342,27 @@ func TestE2E_SubscriberFlow(t *testing.T) {
 
 	t.Log("✅ Subscriber flow test passed")
 }
+
+// TestE2E_Versioning tests that the API is served under /v1 and that
+// legacy paths still work, marked deprecated
+func TestE2E_Versioning(t *testing.T) {
+	resp, body := makeRequest(t, "POST", "/v1/admin/login", map[string]string{
+		"username": "admin",
+		"password": "UOOOWWW4",
+	}, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Login under /v1 failed: %v", body)
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.Errorf("Expected no Deprecation header under /v1")
+	}
+	token := body["token"].(string)
+
+	resp, _ = makeArrayRequest(t, "GET", "/admin/topics", nil, token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Legacy path failed: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Deprecation") != "true" || resp.Header.Get("Link") != `</v1/admin/topics>; rel="successor-version"` {
+		t.Errorf("Expected deprecation headers on the legacy path, got %v", resp.Header)
+	}
+}
@@ -0,0 +1,140 @@
+// Package errtrack reports panics and unexpected errors to Sentry, or a
+// service speaking its protocol such as GlitchTip.
+//
+// Reporting is off until Configure is given a DSN; until then, and after it
+// is configured without one, the capture functions do nothing. Events are
+// sent in the background and never fail the code reporting them.
+package errtrack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Config is the "error_tracking" section of the config file.
+type Config struct {
+	DSN         string  `json:"dsn"`         // Project DSN; reporting is off without one
+	Environment string  `json:"environment"` // e.g. "production"
+	Release     string  `json:"release"`     // Version of the server, to tell regressions apart
+	SampleRate  float64 `json:"sample_rate"` // Share of errors sent, between 0 and 1; 0 sends all
+}
+
+// Validate checks the DSN and sample rate.
+func (c Config) Validate() error {
+	if c.DSN == "" {
+		return nil
+	}
+	if _, err := sentry.NewDsn(c.DSN); err != nil {
+		return fmt.Errorf("invalid dsn: %v", err)
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("sample_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// client sends the events; nil while reporting is off.
+var client atomic.Pointer[sentry.Client]
+
+// Configure starts reporting to the DSN of cfg, or stops reporting if it
+// has none. Events of the previous configuration are flushed first.
+func Configure(cfg Config) error {
+	apply, err := Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare checks cfg and builds its client without reporting to it, so a
+// configuration can be checked before anything changes. apply then does
+// what Configure does.
+func Prepare(cfg Config) (apply func(), err error) {
+	if cfg.DSN == "" {
+		return func() { swap(nil) }, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         cfg.DSN,
+		Environment: cfg.Environment,
+		Release:     cfg.Release,
+		SampleRate:  cfg.SampleRate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { swap(c) }, nil
+}
+
+// start reports with a client of opts.
+func start(opts sentry.ClientOptions) error {
+	c, err := sentry.NewClient(opts)
+	if err != nil {
+		return err
+	}
+	swap(c)
+	return nil
+}
+
+func swap(c *sentry.Client) {
+	if old := client.Swap(c); old != nil {
+		old.Flush(2 * time.Second)
+	}
+}
+
+// Enabled reports whether errors are being reported.
+func Enabled() bool {
+	return client.Load() != nil
+}
+
+// scope returns a scope with tags and, unless group is "", a fingerprint
+// grouping every event of group into one issue, whatever its message.
+func scope(group string, tags map[string]string) *sentry.Scope {
+	s := sentry.NewScope()
+	s.SetTags(tags)
+	if group != "" {
+		s.SetFingerprint([]string{group})
+	}
+	return s
+}
+
+// Capture reports err, tagged with what it concerns, such as a topic, a
+// provider or a queue item. Errors with the same group are one issue.
+func Capture(err error, group string, tags map[string]string) {
+	c := client.Load()
+	if c == nil || err == nil {
+		return
+	}
+	c.CaptureException(err, nil, scope(group, tags))
+}
+
+// CapturePanic reports a value recovered from a panic while serving r.
+func CapturePanic(recovered any, r *http.Request, tags map[string]string) {
+	c := client.Load()
+	if c == nil {
+		return
+	}
+	s := scope("", tags)
+	ctx := context.Background()
+	if r != nil {
+		s.SetRequest(r)
+		ctx = r.Context()
+	}
+	c.Recover(recovered, &sentry.EventHint{Context: ctx, RecoveredException: recovered}, s)
+}
+
+// Flush waits up to timeout for the events sent so far, such as before
+// the process exits.
+func Flush(timeout time.Duration) {
+	if c := client.Load(); c != nil {
+		c.Flush(timeout)
+	}
+}
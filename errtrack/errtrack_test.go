@@ -0,0 +1,100 @@
+package errtrack
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// record starts reporting to a transport that keeps the events.
+func record(t *testing.T) *sentry.MockTransport {
+	t.Helper()
+	transport := &sentry.MockTransport{}
+	if err := start(sentry.ClientOptions{Dsn: "https://key@sentry.example.com/1", Transport: transport}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { swap(nil) })
+	return transport
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []Config{
+		{},
+		{DSN: "https://key@sentry.example.com/1", SampleRate: 0.5},
+	} {
+		if err := c.Validate(); err != nil {
+			t.Errorf("Validate(%+v) = %v", c, err)
+		}
+	}
+	for _, c := range []Config{
+		{DSN: "not a dsn"},
+		{DSN: "https://key@sentry.example.com/1", SampleRate: 2},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected an error validating %+v", c)
+		}
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	if _, err := Prepare(Config{DSN: "not a dsn"}); err == nil {
+		t.Error("Expected an error for an invalid DSN")
+	}
+	apply, err := Prepare(Config{DSN: "https://key@sentry.example.com/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Enabled() {
+		t.Fatal("Expected reporting off until applied")
+	}
+	apply()
+	t.Cleanup(func() { swap(nil) })
+	if !Enabled() {
+		t.Error("Expected reporting on once applied")
+	}
+}
+
+func TestCapture(t *testing.T) {
+	Capture(errors.New("ignored"), "", nil) // Off: does nothing
+	if Enabled() {
+		t.Fatal("Expected reporting off by default")
+	}
+
+	transport := record(t)
+	Capture(errors.New("disk full"), "store.SaveMessage", map[string]string{"store_method": "SaveMessage"})
+	Capture(nil, "", nil)
+
+	events := transport.Events()
+	if len(events) != 1 {
+		t.Fatalf("Expected one event, got %d", len(events))
+	}
+	e := events[0]
+	if e.Tags["store_method"] != "SaveMessage" || !slices.Equal(e.Fingerprint, []string{"store.SaveMessage"}) {
+		t.Errorf("Unexpected event tags %v, fingerprint %v", e.Tags, e.Fingerprint)
+	}
+	if len(e.Exception) == 0 || e.Exception[len(e.Exception)-1].Value != "disk full" {
+		t.Errorf("Expected the error reported, got %+v", e.Exception)
+	}
+}
+
+func TestCapturePanic(t *testing.T) {
+	transport := record(t)
+	r := httptest.NewRequest("POST", "/v1/send", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	CapturePanic("boom", r, map[string]string{"route": "/v1/send"})
+
+	events := transport.Events()
+	if len(events) != 1 {
+		t.Fatalf("Expected one event, got %d", len(events))
+	}
+	e := events[0]
+	if e.Tags["route"] != "/v1/send" || e.Request == nil || e.Request.Method != "POST" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if _, ok := e.Request.Headers["Authorization"]; ok {
+		t.Error("Expected the Authorization header left out")
+	}
+}
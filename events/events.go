@@ -0,0 +1,222 @@
+// Package events notifies configured hooks of administrative and lifecycle
+// events, such as a user being created or a delivery failing for good.
+//
+// Each event is a versioned JSON document:
+//
+//	{"version": 1, "id": "...", "type": "user.created", "time": "...", "actor": "admin", "data": {...}}
+//
+// Hooks either POST it to a URL or publish it to a topic. Delivery is
+// asynchronous and never fails the action that caused the event.
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// Version is the format version of the event JSON. Fields may be added
+// without changing it; it changes when existing fields change meaning.
+const Version = 1
+
+// Event types
+const (
+	UserCreated    = "user.created"
+	UserDeleted    = "user.deleted"
+	TopicCreated   = "topic.created"
+	TopicDeleted   = "topic.deleted"
+	DeliveryFailed = "delivery.failed" // A delivery failed and won't be retried
+	QuotaExceeded  = "quota.exceeded"  // A publisher exceeded its send rate
+	SLOBreached    = "slo.breached"    // A topic's deliveries exceeded their latency or failure objectives
+)
+
+// Types lists every event type.
+var Types = []string{UserCreated, UserDeleted, TopicCreated, TopicDeleted, DeliveryFailed, QuotaExceeded, SLOBreached}
+
+// Event is the JSON document sent to hooks.
+type Event struct {
+	Version int            `json:"version"`
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Actor   string         `json:"actor,omitempty"` // User who caused the event, if any
+	Data    map[string]any `json:"data"`
+}
+
+// Hook sends events to a URL or a topic.
+type Hook struct {
+	URL    string   `json:"url"`    // POSTed every matching event
+	Topic  string   `json:"topic"`  // Or: published to this topic
+	Events []string `json:"events"` // Types or prefixes like "user.*"; all types if empty
+	Secret string   `json:"secret"` // Signs URL deliveries with HMAC-SHA256
+}
+
+// SignatureHeader carries "sha256=<hex HMAC of the body>" when a hook has a secret.
+const SignatureHeader = "X-No-Spam-Signature"
+
+// urlRetries is how many times a failed URL delivery is retried.
+const urlRetries = 2
+
+// Validate checks that the hook has one destination and known event types.
+func (h Hook) Validate() error {
+	if (h.URL == "") == (h.Topic == "") {
+		return errors.New("set exactly one of url and topic")
+	}
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q", h.URL)
+		}
+	}
+	if h.Secret != "" && h.URL == "" {
+		return errors.New("secret only applies to url hooks")
+	}
+	for _, e := range h.Events {
+		if !knownPattern(e) {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the hook subscribes to events of type typ.
+func (h Hook) Wants(typ string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if matches(e, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(pattern, typ string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(typ, prefix)
+	}
+	return pattern == typ
+}
+
+func knownPattern(pattern string) bool {
+	for _, t := range Types {
+		if matches(pattern, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publisher publishes an event payload to a topic.
+type Publisher func(topic string, payload []byte) error
+
+var (
+	mu      sync.RWMutex
+	hooks   []Hook
+	sender  connectors.Connector
+	publish Publisher
+)
+
+// SetHooks replaces the configured hooks.
+func SetHooks(hs []Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = hs
+}
+
+// SetTransport sets how events reach hooks: URL hooks are sent with c
+// (normally the webhook connector, so the webhook policy applies), topic
+// hooks with p. Hooks without a transport are skipped.
+func SetTransport(c connectors.Connector, p Publisher) {
+	mu.Lock()
+	defer mu.Unlock()
+	sender, publish = c, p
+}
+
+// Emit sends an event to every hook that wants it. data must marshal to a
+// JSON object; a "topic" entry names the topic the event is about.
+func Emit(typ, actor string, data map[string]any) {
+	mu.RLock()
+	hs, c, p := hooks, sender, publish
+	mu.RUnlock()
+
+	var matched []Hook
+	for _, h := range hs {
+		// An event about the hook's own topic, such as a failed delivery to
+		// one of its subscribers, would feed back into it
+		if h.Wants(typ) && (h.Topic == "" || h.Topic != data["topic"]) {
+			matched = append(matched, h)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	ev := Event{Version: Version, ID: newID(), Type: typ, Time: time.Now().UTC(), Actor: actor, Data: data}
+	if ev.Data == nil {
+		ev.Data = map[string]any{}
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[Events] Failed to encode %s: %v", typ, err)
+		return
+	}
+	for _, h := range matched {
+		go deliver(h, c, p, typ, body)
+	}
+}
+
+func deliver(h Hook, c connectors.Connector, p Publisher, typ string, body []byte) {
+	if h.Topic != "" {
+		if p == nil {
+			return
+		}
+		if err := p(h.Topic, body); err != nil {
+			log.Printf("[Events] Failed to publish %s to topic %s: %v", typ, h.Topic, err)
+		}
+		return
+	}
+
+	if c == nil {
+		return
+	}
+	opts := &store.WebhookOptions{
+		Headers:    map[string]string{"X-No-Spam-Event": typ},
+		MaxRetries: urlRetries,
+	}
+	if h.Secret != "" {
+		opts.Headers[SignatureHeader] = Sign(h.Secret, body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := c.Send(connectors.WithWebhookOptions(ctx, opts), h.URL, body); err != nil {
+		log.Printf("[Events] Failed to send %s to %s: %v", typ, h.URL, err)
+	}
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"no-spam/connectors"
+)
+
+func TestHookValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		hook  Hook
+		valid bool
+	}{
+		{"URL", Hook{URL: "https://ops.example.com/hook"}, true},
+		{"Topic with prefix", Hook{Topic: "ops", Events: []string{"user.*", DeliveryFailed}}, true},
+		{"No destination", Hook{}, false},
+		{"Both destinations", Hook{URL: "https://ops.example.com/hook", Topic: "ops"}, false},
+		{"Bad scheme", Hook{URL: "ftp://ops.example.com/hook"}, false},
+		{"Unknown event", Hook{Topic: "ops", Events: []string{"user.renamed"}}, false},
+		{"Secret on topic", Hook{Topic: "ops", Secret: "s"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hook.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid=%v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestHookWants(t *testing.T) {
+	h := Hook{Events: []string{"user.*", TopicDeleted}}
+	for typ, want := range map[string]bool{UserCreated: true, UserDeleted: true, TopicDeleted: true, TopicCreated: false} {
+		if h.Wants(typ) != want {
+			t.Errorf("Wants(%s) = %v, want %v", typ, !want, want)
+		}
+	}
+	if !(Hook{}).Wants(QuotaExceeded) {
+		t.Error("A hook without events should want every event")
+	}
+}
+
+func TestEmit(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	published := make(chan string, 2)
+	SetTransport(connectors.NewWebhookConnector(), func(topic string, payload []byte) error {
+		published <- topic
+		return nil
+	})
+	SetHooks([]Hook{
+		{URL: server.URL, Events: []string{UserCreated}, Secret: "s3cret"},
+		{Topic: "ops"},
+	})
+	t.Cleanup(func() {
+		SetHooks(nil)
+		SetTransport(nil, nil)
+	})
+
+	Emit(UserCreated, "admin", map[string]any{"username": "alice"})
+
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-received:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the URL hook")
+	}
+	var ev Event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		t.Fatalf("Invalid event JSON: %v", err)
+	}
+	if ev.Version != Version || ev.Type != UserCreated || ev.Actor != "admin" || ev.ID == "" || ev.Data["username"] != "alice" {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+	if got := r.Header.Get(SignatureHeader); got != Sign("s3cret", body) {
+		t.Errorf("Expected a valid signature, got %q", got)
+	}
+	if r.Header.Get("X-No-Spam-Event") != UserCreated {
+		t.Errorf("Expected the event type header, got %q", r.Header.Get("X-No-Spam-Event"))
+	}
+
+	select {
+	case topic := <-published:
+		if topic != "ops" {
+			t.Errorf("Expected the event on ops, got %s", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the topic hook")
+	}
+
+	// Events about the hook topic itself aren't published to it, and the URL
+	// hook only wants user.created
+	Emit(DeliveryFailed, "", map[string]any{"topic": "ops"})
+	select {
+	case topic := <-published:
+		t.Errorf("Unexpected publish to %s", topic)
+	case <-received:
+		t.Error("Unexpected URL delivery")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
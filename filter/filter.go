@@ -0,0 +1,193 @@
+// Package filter evaluates admin-managed content rules against published payloads.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"no-spam/store"
+)
+
+// Rule types
+const (
+	TypeKeyword      = "keyword"       // Pattern: comma-separated words, matched case-insensitively in string values
+	TypeRegex        = "regex"         // Pattern: RE2 expression matched against string values
+	TypeMaxSize      = "max_size"      // Pattern: maximum payload size in bytes
+	TypeURLAllowlist = "url_allowlist" // Pattern: comma-separated hosts; URLs in string values must match one (or a subdomain)
+)
+
+// Violation describes why a payload was rejected.
+type Violation struct {
+	Rule   store.FilterRule
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("rejected by content filter %d (%s): %s", v.Rule.ID, v.Rule.Type, v.Reason)
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>]+`)
+
+// compiled is a rule prepared for evaluation.
+type compiled struct {
+	rule     store.FilterRule
+	keywords []string
+	re       *regexp.Regexp
+	maxSize  int
+	hosts    []string
+}
+
+// Compile validates a rule and prepares it for evaluation.
+func Compile(r store.FilterRule) (*compiled, error) {
+	c := &compiled{rule: r}
+	switch r.Type {
+	case TypeKeyword:
+		for _, w := range strings.Split(r.Pattern, ",") {
+			if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+				c.keywords = append(c.keywords, w)
+			}
+		}
+		if len(c.keywords) == 0 {
+			return nil, fmt.Errorf("keyword rule needs at least one word")
+		}
+	case TypeRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		c.re = re
+	case TypeMaxSize:
+		n, err := strconv.Atoi(strings.TrimSpace(r.Pattern))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("max_size needs a positive number of bytes")
+		}
+		c.maxSize = n
+	case TypeURLAllowlist:
+		for _, h := range strings.Split(r.Pattern, ",") {
+			if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+				c.hosts = append(c.hosts, strings.TrimPrefix(h, "*."))
+			}
+		}
+		if len(c.hosts) == 0 {
+			return nil, fmt.Errorf("url_allowlist rule needs at least one host")
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule type %q", r.Type)
+	}
+	return c, nil
+}
+
+// Engine evaluates a set of rules.
+type Engine struct {
+	rules []*compiled
+}
+
+// NewEngine compiles rules. Invalid rules are returned as an error.
+func NewEngine(rules []store.FilterRule) (*Engine, error) {
+	e := &Engine{}
+	for _, r := range rules {
+		c, err := Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", r.ID, err)
+		}
+		e.rules = append(e.rules, c)
+	}
+	return e, nil
+}
+
+// Check returns the first violated rule applying to topic, or nil.
+func (e *Engine) Check(topic string, payload []byte) *Violation {
+	if e == nil || len(e.rules) == 0 {
+		return nil
+	}
+	var strs []string
+	extracted := false
+
+	for _, c := range e.rules {
+		if c.rule.Topic != "" && c.rule.Topic != topic {
+			continue
+		}
+		if c.rule.Type == TypeMaxSize {
+			if len(payload) > c.maxSize {
+				return &Violation{Rule: c.rule, Reason: fmt.Sprintf("payload is %d bytes, limit is %d", len(payload), c.maxSize)}
+			}
+			continue
+		}
+
+		if !extracted {
+			strs = stringValues(payload)
+			extracted = true
+		}
+		if reason := c.match(strs); reason != "" {
+			return &Violation{Rule: c.rule, Reason: reason}
+		}
+	}
+	return nil
+}
+
+func (c *compiled) match(strs []string) string {
+	for _, s := range strs {
+		switch c.rule.Type {
+		case TypeKeyword:
+			lower := strings.ToLower(s)
+			for _, w := range c.keywords {
+				if strings.Contains(lower, w) {
+					return fmt.Sprintf("contains blocked keyword %q", w)
+				}
+			}
+		case TypeRegex:
+			if c.re.MatchString(s) {
+				return "matches blocked pattern"
+			}
+		case TypeURLAllowlist:
+			for _, raw := range urlPattern.FindAllString(s, -1) {
+				u, err := url.Parse(raw)
+				if err != nil || !hostAllowed(strings.ToLower(u.Hostname()), c.hosts) {
+					return fmt.Sprintf("URL %q is not allowed", raw)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func hostAllowed(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringValues returns every string (keys included) in a JSON document, or
+// the raw text if it isn't JSON.
+func stringValues(payload []byte) []string {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return []string{string(payload)}
+	}
+	var out []string
+	var walk func(interface{})
+	walk = func(v interface{}) {
+		switch t := v.(type) {
+		case string:
+			out = append(out, t)
+		case map[string]interface{}:
+			for k, child := range t {
+				out = append(out, k)
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range t {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+	return out
+}
@@ -0,0 +1,57 @@
+package filter
+
+import (
+	"testing"
+
+	"no-spam/store"
+)
+
+func TestEngine_Check(t *testing.T) {
+	e, err := NewEngine([]store.FilterRule{
+		{ID: 1, Type: TypeKeyword, Pattern: "casino, free money"},
+		{ID: 2, Type: TypeRegex, Pattern: `\b\d{16}\b`},
+		{ID: 3, Type: TypeMaxSize, Pattern: "64"},
+		{ID: 4, Topic: "news", Type: TypeURLAllowlist, Pattern: "example.com"},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		rule    int64 // 0 means allowed
+	}{
+		{"clean", "news", `{"title":"Hello"}`, 0},
+		{"keyword case-insensitive", "alerts", `{"title":"Visit our CASINO"}`, 1},
+		{"regex", "alerts", `{"card":"4111111111111111"}`, 2},
+		{"too large", "alerts", `{"body":"` + string(make([]byte, 64)) + `"}`, 3},
+		{"allowed subdomain", "news", `{"url":"https://www.example.com/a"}`, 0},
+		{"blocked host", "news", `{"url":"https://evil.test/a"}`, 4},
+		{"allowlist scoped to topic", "alerts", `{"url":"https://evil.test/a"}`, 0},
+		{"lookalike host", "news", `{"url":"https://notexample.com"}`, 4},
+	}
+	for _, tt := range tests {
+		v := e.Check(tt.topic, []byte(tt.payload))
+		switch {
+		case tt.rule == 0 && v != nil:
+			t.Errorf("%s: expected allowed, got %v", tt.name, v)
+		case tt.rule != 0 && (v == nil || v.Rule.ID != tt.rule):
+			t.Errorf("%s: expected rule %d, got %v", tt.name, tt.rule, v)
+		}
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	for _, r := range []store.FilterRule{
+		{Type: TypeRegex, Pattern: "("},
+		{Type: TypeMaxSize, Pattern: "-1"},
+		{Type: TypeKeyword, Pattern: " , "},
+		{Type: "bogus", Pattern: "x"},
+	} {
+		if _, err := Compile(r); err == nil {
+			t.Errorf("Expected error for %+v", r)
+		}
+	}
+}
@@ -1,21 +1,49 @@
 package handlers
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"no-spam/anomaly"
+	"no-spam/apierror"
+	"no-spam/backup"
+	"no-spam/events"
 	"no-spam/hub"
 	"no-spam/middleware"
+	"no-spam/migrate"
+	"no-spam/rbac"
 	"no-spam/store"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ListTopicsHandler lists topic names or, with ?details=true, the metadata
+// and settings of every topic.
 func ListTopicsHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if c.Query("details") == "true" {
+			topics, err := h.ListTopicInfo()
+			if err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to list topics")
+				return
+			}
+			if topics == nil {
+				topics = []store.TopicInfo{}
+			}
+			c.JSON(http.StatusOK, topics)
+			return
+		}
 		topics, err := h.ListTopics()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list topics"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list topics")
 			return
 		}
 		c.JSON(http.StatusOK, topics)
@@ -25,51 +53,475 @@ func ListTopicsHandler(h *hub.Hub) gin.HandlerFunc {
 func CreateTopicHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Name string `json:"name" binding:"required"`
+			Name          string `json:"name" binding:"required"`
+			Description   string `json:"description"`
+			Owner         string `json:"owner"`
+			ReplayCount   *int   `json:"replay_count"`
+			RetentionDays int    `json:"retention_days"`
+			Public        bool   `json:"public"`
+
+			MaxAttempts         int `json:"max_attempts"`
+			RetryBackoffSeconds int `json:"retry_backoff_seconds"`
+			TTLSeconds          int `json:"ttl_seconds"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing topic name"})
+			apierror.Respond(c, http.StatusBadRequest, "Missing topic name")
+			return
+		}
+		if !middleware.Can(c, rbac.ManageTopics, req.Name) {
+			apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot manage this topic")
 			return
 		}
 
-		if err := h.CreateTopic(req.Name); err != nil {
-			if strings.Contains(err.Error(), "UNIQUE constraint") {
-				c.JSON(http.StatusConflict, gin.H{"error": "Topic already exists"})
+		info := store.TopicInfo{
+			Name:          req.Name,
+			Description:   req.Description,
+			Owner:         req.Owner,
+			ReplayCount:   req.ReplayCount,
+			RetentionDays: req.RetentionDays,
+			Public:        req.Public,
+
+			MaxAttempts:         req.MaxAttempts,
+			RetryBackoffSeconds: req.RetryBackoffSeconds,
+			TTLSeconds:          req.TTLSeconds,
+		}
+		if err := h.CreateTopicWithInfo(info); err != nil {
+			if errors.Is(err, hub.ErrInvalidTopicInfo) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			if errors.Is(err, hub.ErrTopicExists) || errors.Is(err, store.ErrDuplicate) {
+				apierror.Respond(c, http.StatusConflict, "Topic already exists")
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create topic"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to create topic")
 			return
 		}
 
+		audit(c, h, "topic.create", req.Name, nil)
+		events.Emit(events.TopicCreated, middleware.GetUsername(c), map[string]any{"topic": req.Name})
 		c.JSON(http.StatusCreated, gin.H{"message": "Topic created"})
 	}
 }
 
+// GetTopicHandler returns a topic's metadata and settings.
+func GetTopicHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, err := h.GetTopicInfo(c.Param("name"))
+		if errors.Is(err, hub.ErrTopicNotFound) {
+			apierror.Respond(c, http.StatusNotFound, "Topic not found")
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get topic")
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// UpdateTopicHandler changes the fields of a topic's metadata and settings
+// present in the body. A null replay_count restores the default. A new name
+// renames the topic, keeping the old name as an alias with keep_alias.
+func UpdateTopicHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name          string          `json:"name"`
+			KeepAlias     bool            `json:"keep_alias"`
+			Description   *string         `json:"description"`
+			Owner         *string         `json:"owner"`
+			ReplayCount   json.RawMessage `json:"replay_count"`
+			RetentionDays *int            `json:"retention_days"`
+			Public        *bool           `json:"public"`
+
+			MaxAttempts         *int `json:"max_attempts"`
+			RetryBackoffSeconds *int `json:"retry_backoff_seconds"`
+			TTLSeconds          *int `json:"ttl_seconds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		name := c.Param("name")
+		if req.Name != "" && req.Name != name {
+			if !middleware.Can(c, rbac.ManageTopics, req.Name) {
+				apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot manage this topic")
+				return
+			}
+			if err := h.RenameTopic(name, req.Name, req.KeepAlias); err != nil {
+				switch {
+				case errors.Is(err, hub.ErrTopicNotFound):
+					apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				case errors.Is(err, hub.ErrTopicExists):
+					apierror.Respond(c, http.StatusConflict, err.Error())
+				default:
+					apierror.Respond(c, http.StatusInternalServerError, "Failed to rename topic")
+				}
+				return
+			}
+			audit(c, h, "topic.rename", name, map[string]string{"to": req.Name, "alias": strconv.FormatBool(req.KeepAlias)})
+			name = req.Name
+		}
+
+		info, err := h.GetTopicInfo(name)
+		if errors.Is(err, hub.ErrTopicNotFound) {
+			apierror.Respond(c, http.StatusNotFound, "Topic not found")
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get topic")
+			return
+		}
+
+		if req.Description != nil {
+			info.Description = *req.Description
+		}
+		if req.Owner != nil {
+			info.Owner = *req.Owner
+		}
+		if req.RetentionDays != nil {
+			info.RetentionDays = *req.RetentionDays
+		}
+		if req.Public != nil {
+			info.Public = *req.Public
+		}
+		if req.MaxAttempts != nil {
+			info.MaxAttempts = *req.MaxAttempts
+		}
+		if req.RetryBackoffSeconds != nil {
+			info.RetryBackoffSeconds = *req.RetryBackoffSeconds
+		}
+		if req.TTLSeconds != nil {
+			info.TTLSeconds = *req.TTLSeconds
+		}
+		if len(req.ReplayCount) > 0 {
+			info.ReplayCount = nil
+			if string(req.ReplayCount) != "null" {
+				if err := json.Unmarshal(req.ReplayCount, &info.ReplayCount); err != nil {
+					apierror.Respond(c, http.StatusBadRequest, "Invalid replay_count")
+					return
+				}
+			}
+		}
+
+		if req.Description == nil && req.Owner == nil && req.RetentionDays == nil && req.Public == nil && len(req.ReplayCount) == 0 &&
+			req.MaxAttempts == nil && req.RetryBackoffSeconds == nil && req.TTLSeconds == nil {
+			c.JSON(http.StatusOK, info)
+			return
+		}
+		if err := h.SetTopicInfo(*info); err != nil {
+			if errors.Is(err, hub.ErrInvalidTopicInfo) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update topic")
+			return
+		}
+
+		audit(c, h, "topic.update", name, nil)
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// ListTopicAliasesHandler lists the old names that still resolve to a topic.
+func ListTopicAliasesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		aliases, err := h.TopicAliases(c.Param("name"))
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list aliases")
+			return
+		}
+		c.JSON(http.StatusOK, aliases)
+	}
+}
+
+// DeleteTopicAliasHandler stops an old name from resolving to a topic.
+func DeleteTopicAliasHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, alias := c.Param("name"), c.Param("alias")
+		if err := h.DeleteTopicAlias(name, alias); err != nil {
+			if errors.Is(err, hub.ErrAliasNotFound) {
+				apierror.Respond(c, http.StatusNotFound, "Alias not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete alias")
+			return
+		}
+		audit(c, h, "topic.alias.delete", name, map[string]string{"alias": alias})
+		c.JSON(http.StatusOK, gin.H{"message": "Alias deleted"})
+	}
+}
+
 func DeleteTopicHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 
 		if err := h.DeleteTopic(name); err != nil {
-			if strings.Contains(err.Error(), "cannot delete topic") {
-				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			if errors.Is(err, store.ErrInUse) {
+				apierror.Respond(c, http.StatusConflict, err.Error())
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete topic"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete topic")
 			return
 		}
 
+		audit(c, h, "topic.delete", name, nil)
+		events.Emit(events.TopicDeleted, middleware.GetUsername(c), map[string]any{"topic": name})
 		c.JSON(http.StatusOK, gin.H{"message": "Topic deleted"})
 	}
 }
 
+func GetTopicSchemaHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		schema, err := h.GetTopicSchema(name)
+		if err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get schema")
+			return
+		}
+		if schema == "" {
+			apierror.Respond(c, http.StatusNotFound, "Topic has no schema")
+			return
+		}
+
+		c.Data(http.StatusOK, "application/schema+json", []byte(schema))
+	}
+}
+
+// SetTopicSchemaHandler expects the JSON Schema document itself as the request body.
+func SetTopicSchemaHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil || !json.Valid(body) {
+			apierror.Respond(c, http.StatusBadRequest, "Request body must be a JSON Schema document")
+			return
+		}
+
+		if err := h.SetTopicSchema(name, string(body)); err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			if errors.Is(err, hub.ErrInvalidSchema) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to set schema")
+			return
+		}
+
+		audit(c, h, "topic.schema.set", name, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Schema updated"})
+	}
+}
+
+func DeleteTopicSchemaHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		if err := h.SetTopicSchema(name, ""); err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete schema")
+			return
+		}
+
+		audit(c, h, "topic.schema.delete", name, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Schema removed"})
+	}
+}
+
+func ListTemplatesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templates, err := h.ListTemplates(c.Param("name"))
+		if err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list templates")
+			return
+		}
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+func SaveTemplateHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Locale string `json:"locale"`
+			Body   string `json:"body" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing template body")
+			return
+		}
+
+		err := h.SaveTemplate(store.Template{
+			Topic:  c.Param("name"),
+			Name:   c.Param("template"),
+			Locale: req.Locale,
+			Body:   req.Body,
+		})
+		if err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			if errors.Is(err, hub.ErrInvalidTemplate) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to save template")
+			return
+		}
+
+		audit(c, h, "template.save", c.Param("name"), map[string]string{"template": c.Param("template"), "locale": req.Locale})
+		c.JSON(http.StatusOK, gin.H{"message": "Template saved"})
+	}
+}
+
+// DeleteTemplateHandler removes the variant given by ?locale=, or every variant without it.
+func DeleteTemplateHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale, ok := c.GetQuery("locale")
+		if !ok {
+			locale = "*"
+		}
+
+		if err := h.DeleteTemplate(c.Param("name"), c.Param("template"), locale); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete template")
+			return
+		}
+
+		audit(c, h, "template.delete", c.Param("name"), map[string]string{"template": c.Param("template"), "locale": locale})
+		c.JSON(http.StatusOK, gin.H{"message": "Template deleted"})
+	}
+}
+
+func GetApprovalThresholdHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		threshold, err := h.GetApprovalThreshold(c.Param("name"))
+		if err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get approval threshold")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"threshold": threshold})
+	}
+}
+
+// SetApprovalThresholdHandler sets the audience size at which sends need approval; 0 disables it.
+func SetApprovalThresholdHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Threshold *int `json:"threshold" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || *req.Threshold < 0 {
+			apierror.Respond(c, http.StatusBadRequest, "threshold must be a non-negative number")
+			return
+		}
+
+		if err := h.SetApprovalThreshold(c.Param("name"), *req.Threshold); err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to set approval threshold")
+			return
+		}
+
+		audit(c, h, "topic.approval.set", c.Param("name"), map[string]string{"threshold": strconv.Itoa(*req.Threshold)})
+		c.JSON(http.StatusOK, gin.H{"message": "Approval threshold updated"})
+	}
+}
+
+// ListApprovalsHandler lists held messages (?status=pending|approved|rejected, default pending).
+func ListApprovalsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := c.DefaultQuery("status", store.ApprovalPending)
+		if status == "all" {
+			status = ""
+		}
+
+		approvals, err := h.ListApprovals(status)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list approvals")
+			return
+		}
+		c.JSON(http.StatusOK, approvals)
+	}
+}
+
+func ApproveMessageHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid message id")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		n, err := h.ApproveMessage(ctx, id, middleware.GetUsername(c))
+		if err != nil {
+			if err == hub.ErrApprovalNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Message is not awaiting approval")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		audit(c, h, "message.approve", strconv.FormatInt(id, 10), map[string]string{"subscribers": strconv.Itoa(n)})
+		c.JSON(http.StatusOK, gin.H{"message": "Message approved", "subscribers": n})
+	}
+}
+
+func RejectMessageHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid message id")
+			return
+		}
+
+		if err := h.RejectMessage(id, middleware.GetUsername(c)); err != nil {
+			if err == hub.ErrApprovalNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Message is not awaiting approval")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to reject message")
+			return
+		}
+
+		audit(c, h, "message.reject", strconv.FormatInt(id, 10), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Message rejected"})
+	}
+}
+
 func GetMessagesHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 
 		msgs, err := h.GetRecentMessages(name, 100)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get messages")
 			return
 		}
 
@@ -77,42 +529,233 @@ func GetMessagesHandler(h *hub.Hub) gin.HandlerFunc {
 	}
 }
 
+// SearchMessagesHandler searches a topic's message history. Filters: q
+// (words that must all appear in the payload), from and to (RFC 3339) and
+// limit (default 100).
+func SearchMessagesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := store.MessageSearch{
+			Topic: c.Param("name"),
+			Query: c.Query("q"),
+			Limit: 100,
+		}
+
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"from", &f.From}, {"to", &f.To}} {
+			v := c.Query(p.name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid "+p.name+", expected RFC 3339")
+				return
+			}
+			*p.dst = t
+		}
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				apierror.Respond(c, http.StatusBadRequest, "limit must be between 1 and 1000")
+				return
+			}
+			f.Limit = n
+		}
+
+		msgs, err := h.SearchMessages(f)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to search messages")
+			return
+		}
+		c.JSON(http.StatusOK, msgs)
+	}
+}
+
 func ClearMessagesHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 
 		if err := h.ClearTopicMessages(name); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear messages"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to clear messages")
 			return
 		}
 
+		audit(c, h, "topic.messages.clear", name, nil)
 		c.JSON(http.StatusOK, gin.H{"message": "Messages cleared"})
 	}
 }
 
+// ResendMessageHandler enqueues a stored message again for the topic's
+// current subscribers; ?missing_only=true skips those who already got it.
+func ResendMessageHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid message id")
+			return
+		}
+		missingOnly := c.Query("missing_only") == "true"
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		n, err := h.Resend(ctx, name, id, missingOnly)
+		if err != nil {
+			if err == hub.ErrMessageNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Message not found")
+				return
+			}
+			if err == hub.ErrMessageNotApproved {
+				apierror.Respond(c, http.StatusConflict, "Message was not approved")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to resend message")
+			return
+		}
+
+		audit(c, h, "message.resend", strconv.FormatInt(id, 10), map[string]string{
+			"topic":        name,
+			"missing_only": strconv.FormatBool(missingOnly),
+			"enqueued":     strconv.Itoa(n),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Message resent", "enqueued": n, "status_url": statusURL(id)})
+	}
+}
+
 func GetSubscribersHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 
 		subs, err := h.GetSubscribers(name)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subscribers"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get subscribers")
+			return
+		}
+
+		c.JSON(http.StatusOK, redactSubscribers(subs))
+	}
+}
+
+// GetStaleSubscriptionsHandler lists, least recently seen first, the
+// subscriptions without a successful delivery for since (e.g. "30d" or
+// "12h", default 30d), counting from their creation when they never had
+// one. limit defaults to 100.
+func GetStaleSubscriptionsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		age, err := parseAge(c.DefaultQuery("since", "30d"))
+		if err != nil || age <= 0 {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid since, expected a duration like 30d or 12h")
+			return
+		}
+		limit := 100
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				apierror.Respond(c, http.StatusBadRequest, "limit must be between 1 and 1000")
+				return
+			}
+			limit = n
+		}
+
+		before := time.Now().Add(-age)
+		subs, err := h.StaleSubscriptions(before, limit)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list stale subscriptions")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"before": before.UTC(), "subscriptions": redactSubscribers(subs)})
+	}
+}
+
+// parseAge parses a duration in days like "30d", or a Go duration.
+func parseAge(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// ExportSubscribersHandler streams every subscriber of a topic as CSV or,
+// by default, as a JSON array, without loading the topic into memory.
+func ExportSubscribersHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid format. Must be json or csv")
+			return
+		}
+		exists, err := h.TopicExists(name)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get subscribers")
+			return
+		}
+		if !exists {
+			apierror.Respond(c, http.StatusNotFound, "Topic not found")
 			return
 		}
 
-		c.JSON(http.StatusOK, subs)
+		audit(c, h, "topic.subscribers.export", name, map[string]string{"format": format})
+		c.Header("Content-Disposition", `attachment; filename="`+name+`-subscribers.`+format+`"`)
+		c.Status(http.StatusOK)
+
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			w := csv.NewWriter(c.Writer)
+			w.Write([]string{"token", "provider", "username", "locale", "platform", "app_version", "tags", "unsubscribe_url"})
+			err = h.EachSubscriber(name, func(sub store.Subscriber) error {
+				w.Write([]string{sub.Token, sub.Provider, sub.Username, sub.Locale, sub.Platform, sub.AppVersion, strings.Join(sub.Tags, " "), h.UnsubscribeURL(name, sub.Token)})
+				w.Flush()
+				return w.Error()
+			})
+		} else {
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			enc := json.NewEncoder(c.Writer)
+			sep := "["
+			err = h.EachSubscriber(name, func(sub store.Subscriber) error {
+				if _, err := io.WriteString(c.Writer, sep); err != nil {
+					return err
+				}
+				sep = ","
+				return enc.Encode(exportedSubscriber{Subscriber: redactSubscriber(sub), Username: sub.Username, UnsubscribeURL: h.UnsubscribeURL(name, sub.Token)})
+			})
+			if sep == "[" {
+				io.WriteString(c.Writer, sep)
+			}
+			io.WriteString(c.Writer, "]\n")
+		}
+		if err != nil {
+			// The status is already sent, so the client sees a truncated file
+			log.Printf("[Admin] Subscriber export of %s failed: %v", name, err)
+		}
 	}
 }
 
+// exportedSubscriber includes the username that Subscriber hides from JSON,
+// and a link to embed in emails. Webhook header values are redacted.
+type exportedSubscriber struct {
+	store.Subscriber
+	Username       string `json:"username"`
+	UnsubscribeURL string `json:"unsubscribe_url,omitempty"`
+}
+
 func ClearSubscribersHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 
 		if err := h.ClearTopicSubscribers(name); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear subscribers"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to clear subscribers")
 			return
 		}
 
+		audit(c, h, "topic.subscribers.clear", name, nil)
 		c.JSON(http.StatusOK, gin.H{"message": "Subscribers cleared"})
 	}
 }
@@ -121,32 +764,52 @@ func GetTokenHandler(s store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username := c.Query("username")
 		if username == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "username parameter is required"})
+			apierror.Respond(c, http.StatusBadRequest, "username parameter is required")
 			return
 		}
 
 		// Verify user exists
 		user, err := s.GetUser(username)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check user"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to check user")
 			return
 		}
 		if user == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			apierror.Respond(c, http.StatusNotFound, "User not found")
+			return
+		}
+		if user.Disabled {
+			apierror.Respond(c, http.StatusConflict, errUserDisabled.Error())
 			return
 		}
 
+		lifetime := middleware.TokenLifetime(user.Role)
+		if v := c.Query("expires_in"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid expires_in duration")
+				return
+			}
+			lifetime = d
+		}
+
 		// Generate token with user's stored role
-		token, err := middleware.GenerateToken(user.Username, user.Role)
+		token, err := issueToken(c, s, user.Username, user.Role, "mint", lifetime)
+		if err == middleware.ErrLifetimeTooLong {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to generate token")
 			return
 		}
 
+		audit(c, s, "token.mint", user.Username, map[string]string{"role": user.Role, "expires_in": lifetime.String()})
 		c.JSON(http.StatusOK, gin.H{
-			"token":    token,
-			"role":     user.Role,
-			"username": user.Username,
+			"token":      token.Token,
+			"role":       user.Role,
+			"username":   user.Username,
+			"expires_at": token.ExpiresAt,
 		})
 	}
 }
@@ -158,13 +821,420 @@ func GetQueueHandler(h *hub.Hub) gin.HandlerFunc {
 		queue, err := h.GetQueue(name)
 		if err != nil {
 			if err == hub.ErrTopicNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get queue"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get queue")
 			return
 		}
 
 		c.JSON(http.StatusOK, queue)
 	}
 }
+
+// ListAttemptsHandler lists the delivery attempts of a queue item.
+func ListAttemptsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid queue item id")
+			return
+		}
+
+		attempts, err := h.ListAttempts(c.Param("name"), id)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list attempts")
+			return
+		}
+		if attempts == nil {
+			attempts = []store.Attempt{}
+		}
+
+		c.JSON(http.StatusOK, attempts)
+	}
+}
+
+// CancelQueueItemHandler cancels a pending delivery.
+func CancelQueueItemHandler(h *hub.Hub) gin.HandlerFunc {
+	return queueItemHandler(h, "queue.cancel", "Delivery canceled", h.CancelQueueItem)
+}
+
+// RequeueQueueItemHandler puts a failed or canceled delivery back in the queue.
+func RequeueQueueItemHandler(h *hub.Hub) gin.HandlerFunc {
+	return queueItemHandler(h, "queue.requeue", "Delivery requeued", h.RequeueQueueItem)
+}
+
+func queueItemHandler(h *hub.Hub, action, message string, op func(topic string, queueID int64) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid queue item id")
+			return
+		}
+
+		if err := op(name, id); err != nil {
+			if err == hub.ErrQueueItemNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Queue item not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update queue item")
+			return
+		}
+
+		audit(c, h, action, strconv.FormatInt(id, 10), map[string]string{"topic": name})
+		c.JSON(http.StatusOK, gin.H{"message": message})
+	}
+}
+
+// PurgeQueueHandler cancels the pending deliveries of the :name topic and/or
+// the ?token= token.
+func PurgeQueueHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, token := c.Param("name"), c.Query("token")
+		if name == "" && token == "" {
+			apierror.Respond(c, http.StatusBadRequest, "Missing token")
+			return
+		}
+
+		n, err := h.PurgeQueue(name, token)
+		if err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to purge queue")
+			return
+		}
+
+		target := name
+		if target == "" {
+			target = token
+		}
+		audit(c, h, "queue.purge", target, map[string]string{"token": token, "canceled": strconv.FormatInt(n, 10)})
+		c.JSON(http.StatusOK, gin.H{"message": "Queue purged", "canceled": n})
+	}
+}
+
+func GetCircuitsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, h.CircuitStatus())
+	}
+}
+
+func GetStoreStatsHandler(s *store.InstrumentedStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.Snapshot())
+	}
+}
+
+func GetAnomaliesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := h.AnomalyDetector()
+		if d == nil {
+			c.JSON(http.StatusOK, []anomaly.Status{})
+			return
+		}
+		c.JSON(http.StatusOK, d.Snapshot())
+	}
+}
+
+// ReleaseAnomalyHandler lifts a throttle or quarantine. Omitting topic
+// releases every topic of the publisher.
+func ReleaseAnomalyHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Publisher string `json:"publisher" binding:"required"`
+			Topic     string `json:"topic"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing publisher")
+			return
+		}
+
+		d := h.AnomalyDetector()
+		if d == nil {
+			apierror.Respond(c, http.StatusNotFound, "Anomaly detection is not enabled")
+			return
+		}
+		if !d.Release(req.Publisher, req.Topic) {
+			apierror.Respond(c, http.StatusNotFound, "Nothing to release")
+			return
+		}
+
+		audit(c, h, "anomaly.release", req.Publisher, map[string]string{"topic": req.Topic})
+		c.JSON(http.StatusOK, gin.H{"message": "Released"})
+	}
+}
+
+// SetAnomalyExemptionHandler exempts a publisher (optionally for one topic) from detection.
+func SetAnomalyExemptionHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Publisher string `json:"publisher" binding:"required"`
+			Topic     string `json:"topic"`
+			Exempt    *bool  `json:"exempt" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (publisher, exempt)")
+			return
+		}
+
+		d := h.AnomalyDetector()
+		if d == nil {
+			apierror.Respond(c, http.StatusNotFound, "Anomaly detection is not enabled")
+			return
+		}
+		d.SetExempt(req.Publisher, req.Topic, *req.Exempt)
+		if *req.Exempt {
+			d.Release(req.Publisher, req.Topic)
+		}
+
+		audit(c, h, "anomaly.exempt", req.Publisher, map[string]string{"topic": req.Topic, "exempt": strconv.FormatBool(*req.Exempt)})
+		c.JSON(http.StatusOK, gin.H{"message": "Exemption updated"})
+	}
+}
+
+func ListFilterRulesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules, err := h.ListFilterRules()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list filter rules")
+			return
+		}
+		c.JSON(http.StatusOK, rules)
+	}
+}
+
+func CreateFilterRuleHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Topic   string `json:"topic"`
+			Type    string `json:"type" binding:"required"`
+			Pattern string `json:"pattern" binding:"required"`
+			Record  bool   `json:"record"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (type, pattern)")
+			return
+		}
+
+		rule, err := h.CreateFilterRule(store.FilterRule{
+			Topic:   req.Topic,
+			Type:    req.Type,
+			Pattern: req.Pattern,
+			Record:  req.Record,
+		})
+		if err != nil {
+			if err == hub.ErrTopicNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			if errors.Is(err, hub.ErrInvalidFilter) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to create filter rule")
+			return
+		}
+
+		audit(c, h, "filter.create", strconv.FormatInt(rule.ID, 10), map[string]string{"type": rule.Type, "pattern": rule.Pattern, "topic": rule.Topic})
+		c.JSON(http.StatusCreated, rule)
+	}
+}
+
+func DeleteFilterRuleHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid rule id")
+			return
+		}
+
+		if err := h.DeleteFilterRule(id); err != nil {
+			if err == hub.ErrFilterNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Filter rule not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete filter rule")
+			return
+		}
+
+		audit(c, h, "filter.delete", strconv.FormatInt(id, 10), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Filter rule deleted"})
+	}
+}
+
+// GetModerationLogHandler lists recorded rejections, newest first (?limit=, default 100).
+func GetModerationLogHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 100
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+			limit = n
+		}
+
+		entries, err := h.ListModerationLog(limit)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get moderation log")
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// ReloadHandler re-applies the config file through reload. The running
+// configuration is kept if it fails.
+func ReloadHandler(h *hub.Hub, reload func() error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := reload(); err != nil {
+			audit(c, h, "config.reload", "", map[string]string{"error": err.Error()})
+			apierror.Respond(c, http.StatusInternalServerError, "Reload failed: "+err.Error())
+			return
+		}
+		audit(c, h, "config.reload", "", nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Configuration reloaded"})
+	}
+}
+
+// Backuper is implemented by stores that support online backup and restore.
+type Backuper interface {
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// BackupHandler streams a consistent copy of the database.
+func BackupHandler(b Backuper, a auditor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := backup.Name(time.Now())
+		c.Header("Content-Type", "application/vnd.sqlite3")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		if err := b.Backup(c.Writer); err != nil {
+			if !c.Writer.Written() {
+				c.Writer.Header().Del("Content-Type")
+				c.Writer.Header().Del("Content-Disposition")
+				apierror.Respond(c, http.StatusInternalServerError, "Backup failed: "+err.Error())
+			}
+			return
+		}
+		audit(c, a, "store.backup", "", nil)
+	}
+}
+
+// RestoreHandler replaces the database with the backup in the request body.
+func RestoreHandler(b Backuper, a auditor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := b.Restore(c.Request.Body)
+		if errors.Is(err, store.ErrInvalidBackup) || errors.Is(err, migrate.ErrSchemaTooNew) {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Restore failed: "+err.Error())
+			return
+		}
+		audit(c, a, "store.restore", "", nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Database restored"})
+	}
+}
+
+// StorageReporter is implemented by stores that report their disk usage.
+type StorageReporter interface {
+	Storage() (*store.StorageUsage, error)
+}
+
+// Compacter is implemented by stores that can reclaim their free space.
+type Compacter interface {
+	Compact() (*store.CompactResult, error)
+}
+
+// GetStorageHandler reports the database size, each topic's message bytes
+// and the queue size.
+func GetStorageHandler(s StorageReporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u, err := s.Storage()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to measure storage")
+			return
+		}
+		c.JSON(http.StatusOK, u)
+	}
+}
+
+// CompactStorageHandler shrinks the database to the space its data uses,
+// e.g. after a large purge.
+func CompactStorageHandler(s Compacter, a auditor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res, err := s.Compact()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Compaction failed: "+err.Error())
+			return
+		}
+		audit(c, a, "store.compact", "", map[string]string{
+			"bytes_before": strconv.FormatInt(res.BytesBefore, 10),
+			"bytes_after":  strconv.FormatInt(res.BytesAfter, 10),
+		})
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// ExportHandler downloads a portable JSON dump of the store. ?messages=N
+// includes each topic's N most recent messages.
+func ExportHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var opts store.ExportOptions
+		if v := c.Query("messages"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid messages count")
+				return
+			}
+			opts.Messages = n
+		}
+
+		d, err := store.Export(s, opts)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Export failed: "+err.Error())
+			return
+		}
+		audit(c, s, "store.export", "", map[string]string{
+			"topics": strconv.Itoa(len(d.Topics)),
+			"users":  strconv.Itoa(len(d.Users)),
+		})
+		name := "no-spam-export-" + d.ExportedAt.Format("20060102T150405Z") + ".json"
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		c.JSON(http.StatusOK, d)
+	}
+}
+
+// ImportHandler merges a dump produced by ExportHandler into the store.
+func ImportHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var d store.Dump
+		if err := c.ShouldBindJSON(&d); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid dump: "+err.Error())
+			return
+		}
+
+		res, err := store.Import(s, &d)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, store.ErrInvalidDump) {
+				status = http.StatusBadRequest
+			}
+			// Entries before the failure were imported
+			apierror.RespondCode(c, status, apierror.ForStatus(status), err.Error(), gin.H{"imported": res})
+			return
+		}
+		audit(c, s, "store.import", "", map[string]string{
+			"topics":        strconv.Itoa(res.Topics),
+			"users":         strconv.Itoa(res.Users),
+			"subscriptions": strconv.Itoa(res.Subscriptions),
+		})
+		c.JSON(http.StatusOK, res)
+	}
+}
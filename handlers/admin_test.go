@@ -2,11 +2,21 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"no-spam/anomaly"
+	"no-spam/apierror"
+	"no-spam/connectors"
 	"no-spam/hub"
 	"no-spam/store"
 
@@ -69,6 +79,13 @@ func TestCreateTopicHandler(t *testing.T) {
 			if w.Code != tt.expectedStatus {
 				t.Errorf("Expected status %d, got %d. Body: %s", tt.expectedStatus, w.Code, w.Body.String())
 			}
+			if w.Code >= 400 {
+				var body map[string]string
+				json.Unmarshal(w.Body.Bytes(), &body)
+				if body["code"] != string(apierror.ForStatus(w.Code)) || body["error"] == "" {
+					t.Errorf("Expected the error envelope, got %s", w.Body.String())
+				}
+			}
 		})
 	}
 }
@@ -147,6 +164,117 @@ func TestListTopicsHandler(t *testing.T) {
 	}
 }
 
+func TestUpdateTopicHandler(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("news")
+	_ = s.CreateUser("alice", "hash", "publisher")
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: "news"}}
+		c.Request = httptest.NewRequest("PATCH", "/admin/topics/news", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		UpdateTopicHandler(h)(c)
+		return w
+	}
+
+	if w := patch(`{"description": "Headlines", "owner": "alice", "replay_count": 5, "retention_days": 30}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	// Fields left out are kept, and a null replay_count restores the default
+	if w := patch(`{"replay_count": null}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := patch(`{"owner": "nobody"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown owner, got %d", w.Code)
+	}
+	if w := patch(`{"max_attempts": 5, "retry_backoff_seconds": 30, "ttl_seconds": 3600}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := patch(`{"ttl_seconds": -1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative TTL, got %d", w.Code)
+	}
+
+	c, w := setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "news"}}
+	c.Request = httptest.NewRequest("GET", "/admin/topics/news", nil)
+	GetTopicHandler(h)(c)
+	var info store.TopicInfo
+	json.Unmarshal(w.Body.Bytes(), &info)
+	if info.Description != "Headlines" || info.Owner != "alice" || info.ReplayCount != nil || info.RetentionDays != 30 || info.CreatedAt.IsZero() ||
+		info.MaxAttempts != 5 || info.RetryBackoffSeconds != 30 || info.TTLSeconds != 3600 {
+		t.Errorf("Unexpected topic %+v", info)
+	}
+
+	c, w = setupTestContext()
+	c.Request = httptest.NewRequest("GET", "/admin/topics?details=true", nil)
+	ListTopicsHandler(h)(c)
+	var topics []store.TopicInfo
+	json.Unmarshal(w.Body.Bytes(), &topics)
+	if len(topics) != 1 || topics[0].Owner != "alice" {
+		t.Errorf("Unexpected topic details %+v", topics)
+	}
+
+	c, w = setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "missing"}}
+	c.Request = httptest.NewRequest("GET", "/admin/topics/missing", nil)
+	GetTopicHandler(h)(c)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing topic, got %d", w.Code)
+	}
+}
+
+func TestUpdateTopicHandler_Rename(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("news")
+	_ = s.CreateTopic("sports")
+	_ = s.AddSubscription("news", "token1", "mock", "user1")
+
+	patch := func(topic, body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: topic}}
+		c.Request = httptest.NewRequest("PATCH", "/admin/topics/"+topic, bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		UpdateTopicHandler(h)(c)
+		return w
+	}
+
+	if w := patch("news", `{"name": "sports"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 renaming onto an existing topic, got %d", w.Code)
+	}
+	if w := patch("missing", `{"name": "other"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing topic, got %d", w.Code)
+	}
+	w := patch("news", `{"name": "headlines", "keep_alias": true, "description": "Headlines"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var info store.TopicInfo
+	json.Unmarshal(w.Body.Bytes(), &info)
+	if info.Name != "headlines" || info.Description != "Headlines" {
+		t.Errorf("Unexpected topic %+v", info)
+	}
+	if subs, _ := s.GetSubscribers("headlines"); len(subs) != 1 {
+		t.Errorf("Expected the subscription to move, got %v", subs)
+	}
+
+	c, w := setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "headlines"}}
+	c.Request = httptest.NewRequest("GET", "/admin/topics/headlines/aliases", nil)
+	ListTopicAliasesHandler(h)(c)
+	if strings.TrimSpace(w.Body.String()) != `["news"]` {
+		t.Errorf("Expected the news alias, got %s", w.Body.String())
+	}
+
+	c, w = setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "headlines"}, {Key: "alias", Value: "news"}}
+	c.Request = httptest.NewRequest("DELETE", "/admin/topics/headlines/aliases/news", nil)
+	DeleteTopicAliasHandler(h)(c)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 deleting the alias, got %d", w.Code)
+	}
+}
+
 // TestGetMessagesHandler tests retrieving messages
 func TestGetMessagesHandler(t *testing.T) {
 	h, s := setupTestHubForAdmin(t)
@@ -205,6 +333,92 @@ func TestClearMessagesHandler(t *testing.T) {
 	}
 }
 
+func TestResendMessageHandler(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	handler := ResendMessageHandler(h)
+	_ = s.CreateTopic("test-topic")
+	_ = s.AddSubscription("test-topic", "delivered-token", "mock", "alice")
+	_ = s.AddSubscription("test-topic", "new-token", "mock", "bob")
+	id, _ := s.SaveMessage("test-topic", []byte(`{"topic":"test-topic","payload":{}}`))
+	qid, _ := s.EnqueueMessage(id, "delivered-token")
+	_ = s.MarkDelivered(qid)
+
+	resend := func(msgID, query string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: "test-topic"}, {Key: "id", Value: msgID}}
+		c.Request = httptest.NewRequest("POST", "/admin/topics/test-topic/messages/"+msgID+"/resend"+query, nil)
+		handler(c)
+		return w
+	}
+
+	w := resend(strconv.FormatInt(id, 10), "?missing_only=true")
+	var resp struct{ Enqueued int }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Enqueued != 1 {
+		t.Errorf("Expected 1 subscriber enqueued, got %d %s", w.Code, w.Body.String())
+	}
+	if w := resend("9999", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown message, got %d", w.Code)
+	}
+	if w := resend("abc", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid id, got %d", w.Code)
+	}
+}
+
+func TestQueueManagementHandlers(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("test-topic")
+	_ = s.AddSubscription("test-topic", "token1", "mock", "user1")
+	_ = s.AddSubscription("test-topic", "token2", "mock", "user2")
+	id, _ := s.SaveMessage("test-topic", []byte(`{}`))
+	q1, _ := s.EnqueueMessage(id, "token1")
+	q2, _ := s.EnqueueMessage(id, "token2")
+
+	call := func(handler gin.HandlerFunc, params gin.Params, path string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = params
+		c.Request = httptest.NewRequest("POST", path, nil)
+		handler(c)
+		return w
+	}
+	item := func(queueID int64) gin.Params {
+		return gin.Params{{Key: "name", Value: "test-topic"}, {Key: "id", Value: strconv.FormatInt(queueID, 10)}}
+	}
+
+	if w := call(CancelQueueItemHandler(h), item(q1), "/"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 canceling, got %d %s", w.Code, w.Body.String())
+	}
+	if w := call(CancelQueueItemHandler(h), item(q1), "/"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 canceling twice, got %d", w.Code)
+	}
+	if w := call(RequeueQueueItemHandler(h), item(q1), "/"); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 requeuing, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := call(PurgeQueueHandler(h), gin.Params{{Key: "name", Value: "test-topic"}}, "/?token=token2")
+	var resp struct{ Canceled int64 }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Canceled != 1 {
+		t.Errorf("Expected 1 item purged, got %d %s", w.Code, w.Body.String())
+	}
+	if items, _ := s.GetPendingMessagesByTopic("test-topic"); len(items) != 1 || items[0].ID != q1 {
+		t.Errorf("Expected only %d pending, got %+v (purged %d)", q1, items, q2)
+	}
+	_ = s.RecordAttempt(store.Attempt{QueueID: q1, Error: "timeout", AttemptedAt: time.Now()})
+	w = call(ListAttemptsHandler(h), item(q1), "/")
+	var attempts []store.Attempt
+	json.Unmarshal(w.Body.Bytes(), &attempts)
+	if w.Code != http.StatusOK || len(attempts) != 1 || attempts[0].Error != "timeout" {
+		t.Errorf("Expected 1 attempt, got %d %s", w.Code, w.Body.String())
+	}
+	if w := call(PurgeQueueHandler(h), nil, "/"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 purging without topic or token, got %d", w.Code)
+	}
+	if w := call(PurgeQueueHandler(h), gin.Params{{Key: "name", Value: "missing"}}, "/"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown topic, got %d", w.Code)
+	}
+}
+
 // TestClearSubscribersHandler tests clearing subscribers
 func TestClearSubscribersHandler(t *testing.T) {
 	h, s := setupTestHubForAdmin(t)
@@ -238,11 +452,11 @@ func TestGetSubscribersHandler(t *testing.T) {
 	h, s := setupTestHubForAdmin(t)
 	handler := GetSubscribersHandler(h)
 
-	// Create topic and subscribers
 	// Create topic and subscribers
 	_ = s.CreateTopic("test-topic")
 	_ = s.CreateUser("user1", "hash", "subscriber")
-	_ = s.AddSubscription("test-topic", "token1", "mock", "user1")
+	_ = s.AddSubscription("test-topic", "token1", "webhook", "user1")
+	_ = s.SetSubscriptionOptions("test-topic", "token1", &store.WebhookOptions{Headers: map[string]string{"Authorization": "Bearer secret"}})
 
 	c, w := setupTestContext()
 	c.Params = gin.Params{{Key: "name", Value: "test-topic"}}
@@ -262,4 +476,536 @@ func TestGetSubscribersHandler(t *testing.T) {
 	if len(subscribers) != 1 {
 		t.Errorf("Expected 1 subscriber, got %d", len(subscribers))
 	}
+	if strings.Contains(w.Body.String(), "Bearer secret") || !strings.Contains(w.Body.String(), `"Authorization":"***"`) {
+		t.Errorf("Expected the header value redacted, got %s", w.Body.String())
+	}
+	if subs, _ := s.GetSubscribers("test-topic"); len(subs) != 1 || subs[0].Options.Headers["Authorization"] != "Bearer secret" {
+		t.Errorf("Expected storage to keep the header value, got %+v", subs)
+	}
+}
+
+func TestGetStaleSubscriptionsHandler(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("news")
+	_ = s.AddSubscription("news", "dead", "mock", "")
+	_ = s.AddSubscription("news", "alive", "mock", "")
+	_ = s.MarkTokensSeen([]string{"alive"}, time.Now().Add(time.Hour))
+
+	get := func(query string) (int, []store.Subscriber) {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", "/admin/subscriptions/stale"+query, nil)
+		GetStaleSubscriptionsHandler(h)(c)
+		var resp struct {
+			Subscriptions []store.Subscriber `json:"subscriptions"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Subscriptions
+	}
+
+	if code, subs := get(""); code != http.StatusOK || len(subs) != 0 {
+		t.Errorf("Expected no subscriptions stale for 30 days, got %d %+v", code, subs)
+	}
+	if code, subs := get("?since=-30m"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative age, got %d %+v", code, subs)
+	}
+	if code, _ := get("?since=soon"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid age, got %d", code)
+	}
+	// Only alive, seen in the future, was seen within the last nanosecond
+	if code, subs := get("?since=1ns"); code != http.StatusOK || len(subs) != 1 || subs[0].Token != "dead" {
+		t.Errorf("Expected only the never delivered subscription, got %d %+v", code, subs)
+	}
+
+	for in, want := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "12h": 12 * time.Hour, "0d": 0} {
+		if got, err := parseAge(in); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+}
+
+func TestExportSubscribersHandler(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	handler := ExportSubscribersHandler(h)
+	_ = s.CreateTopic("test-topic")
+	_ = s.CreateTopic("empty")
+	_ = s.AddSubscription("test-topic", "token2", "webhook", "user2")
+	_ = s.AddSubscription("test-topic", "token1", "mock", "user1")
+	_ = s.SetSubscriptionOptions("test-topic", "token2", &store.WebhookOptions{Headers: map[string]string{"Authorization": "Bearer secret"}})
+
+	export := func(topic, query string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: topic}}
+		c.Request = httptest.NewRequest("GET", "/admin/topics/"+topic+"/subscribers/export"+query, nil)
+		handler(c)
+		return w
+	}
+
+	w := export("test-topic", "")
+	var subs []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &subs); err != nil {
+		t.Fatalf("Invalid JSON export %q: %v", w.Body.String(), err)
+	}
+	if len(subs) != 2 || subs[0]["token"] != "token1" || subs[0]["username"] != "user1" || subs[1]["provider"] != "webhook" {
+		t.Errorf("Unexpected JSON export %v", subs)
+	}
+	if strings.Contains(w.Body.String(), "Bearer secret") {
+		t.Errorf("Expected the header value redacted, got %s", w.Body.String())
+	}
+
+	w = export("test-topic", "?format=csv")
+	want := "token,provider,username,locale,platform,app_version,tags,unsubscribe_url\ntoken1,mock,user1,,,,,\ntoken2,webhook,user2,,,,,\n"
+	if w.Body.String() != want {
+		t.Errorf("Unexpected CSV export %q", w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "test-topic-subscribers.csv") {
+		t.Errorf("Expected an attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+
+	if w := export("empty", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("Expected an empty array, got %q", w.Body.String())
+	}
+	if w := export("missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing topic, got %d", w.Code)
+	}
+	if w := export("test-topic", "?format=xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", w.Code)
+	}
+}
+
+// TestGetCircuitsHandler tests listing connector circuits
+func TestGetCircuitsHandler(t *testing.T) {
+	h, _ := setupTestHubForAdmin(t)
+	h.RegisterConnector("mock", connectors.NewCircuitBreaker("mock", connectors.NewMockConnector(), connectors.BreakerConfig{}))
+	c, _ := h.GetConnector("mock")
+	c.Send(context.Background(), "token", []byte(`{}`))
+
+	ctx, w := setupTestContext()
+	ctx.Request = httptest.NewRequest("GET", "/admin/connectors/circuits", nil)
+	GetCircuitsHandler(h)(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var statuses []connectors.CircuitStatus
+	json.Unmarshal(w.Body.Bytes(), &statuses)
+	if len(statuses) != 1 || statuses[0].State != connectors.CircuitClosed || statuses[0].Successes != 1 {
+		t.Errorf("Unexpected statuses: %+v", statuses)
+	}
+}
+
+// TestGetStoreStatsHandler tests reporting store call metrics
+func TestGetStoreStatsHandler(t *testing.T) {
+	s := store.Instrument(store.NewMemoryStore(), 0)
+	s.CreateTopic("news")
+
+	ctx, w := setupTestContext()
+	ctx.Request = httptest.NewRequest("GET", "/admin/store/stats", nil)
+	GetStoreStatsHandler(s)(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var stats []store.MethodStats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if len(stats) != 1 || stats[0].Method != "CreateTopic" || stats[0].Calls != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+// TestTopicSchemaHandlers tests attaching a schema and rejecting non-matching sends
+func TestTopicSchemaHandlers(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("orders")
+
+	put := func(body string) int {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: "orders"}}
+		c.Request = httptest.NewRequest("PUT", "/admin/topics/orders/schema", bytes.NewBufferString(body))
+		SetTopicSchemaHandler(h)(c)
+		return w.Code
+	}
+
+	if code := put(`not json`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for non-JSON body, got %d", code)
+	}
+	if code := put(`{"type": 12}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid schema, got %d", code)
+	}
+	if code := put(`{"type": "object", "required": ["id"]}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	c, w := setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "orders"}}
+	c.Request = httptest.NewRequest("GET", "/admin/topics/orders/schema", nil)
+	GetTopicSchemaHandler(h)(c)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"required"`)) {
+		t.Errorf("Expected stored schema, got %d %s", w.Code, w.Body.String())
+	}
+
+	send := func(payload string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "orders", "payload": `+payload+`}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w
+	}
+
+	if w := send(`{"name": "x"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", w.Code)
+	} else {
+		var resp map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if details, _ := resp["details"].([]interface{}); len(details) == 0 {
+			t.Errorf("Expected validation details, got %s", w.Body.String())
+		}
+	}
+	if w := send(`{"id": 1}`); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	c, w = setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "orders"}}
+	c.Request = httptest.NewRequest("DELETE", "/admin/topics/orders/schema", nil)
+	DeleteTopicSchemaHandler(h)(c)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 on delete, got %d", w.Code)
+	}
+	if w := send(`{"name": "x"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 after schema removal, got %d", w.Code)
+	}
+}
+
+// TestTemplateHandlers tests registering templates and sending with them
+func TestTemplateHandlers(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("news")
+
+	save := func(body string) int {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: "news"}, {Key: "template", Value: "headline"}}
+		c.Request = httptest.NewRequest("PUT", "/admin/topics/news/templates/headline", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SaveTemplateHandler(h)(c)
+		return w.Code
+	}
+
+	if code := save(`{"body": "{{if}}"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid template, got %d", code)
+	}
+	if code := save(`{"body": "{\"text\": {{json .text}}}"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	send := func(body string) int {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w.Code
+	}
+
+	if code := send(`{"topic": "news", "template": "headline", "variables": {"text": "hello"}}`); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if code := send(`{"topic": "news", "template": "nope"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown template, got %d", code)
+	}
+	if code := send(`{"topic": "news", "template": "headline"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for missing variable, got %d", code)
+	}
+
+	c, w := setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "news"}}
+	c.Request = httptest.NewRequest("GET", "/admin/topics/news/templates", nil)
+	ListTemplatesHandler(h)(c)
+	var templates []store.Template
+	_ = json.Unmarshal(w.Body.Bytes(), &templates)
+	if len(templates) != 1 || templates[0].Name != "headline" {
+		t.Errorf("Unexpected templates: %s", w.Body.String())
+	}
+
+	c, w = setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "news"}, {Key: "template", Value: "headline"}}
+	c.Request = httptest.NewRequest("DELETE", "/admin/topics/news/templates/headline", nil)
+	DeleteTemplateHandler(h)(c)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 on delete, got %d", w.Code)
+	}
+	if remaining, _ := s.ListTemplates("news"); len(remaining) != 0 {
+		t.Errorf("Expected templates deleted, got %v", remaining)
+	}
+}
+
+// TestAnomalyHandlers tests listing, releasing and exempting throttled publishers
+func TestAnomalyHandlers(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("news")
+
+	c, w := setupTestContext()
+	c.Request = httptest.NewRequest("POST", "/admin/anomalies/release", bytes.NewBufferString(`{"publisher": "bob"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	ReleaseAnomalyHandler(h)(c)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when detection is disabled, got %d", w.Code)
+	}
+
+	h.SetAnomalyDetector(anomaly.NewDetector(anomaly.Config{MinCount: 1}), "")
+
+	send := func() int {
+		c, w := setupTestContext()
+		c.Set("username", "bob")
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "news", "payload": {}}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w.Code
+	}
+	send()
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", code)
+	}
+
+	c, w = setupTestContext()
+	c.Request = httptest.NewRequest("GET", "/admin/anomalies", nil)
+	GetAnomaliesHandler(h)(c)
+	var statuses []anomaly.Status
+	_ = json.Unmarshal(w.Body.Bytes(), &statuses)
+	if len(statuses) != 1 || !statuses[0].Blocked || statuses[0].Publisher != "bob" {
+		t.Errorf("Unexpected anomalies: %s", w.Body.String())
+	}
+
+	c, w = setupTestContext()
+	c.Request = httptest.NewRequest("PUT", "/admin/anomalies/exemptions", bytes.NewBufferString(`{"publisher": "bob", "exempt": true}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	SetAnomalyExemptionHandler(h)(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if code := send(); code != http.StatusOK {
+		t.Errorf("Expected exempt publisher to send, got %d", code)
+	}
+}
+
+func TestReloadHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+
+	reloadErr := error(nil)
+	handler := ReloadHandler(h, func() error { return reloadErr })
+
+	c, w := setupTestContext()
+	c.Request = httptest.NewRequest("POST", "/admin/reload", nil)
+	handler(c)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+
+	reloadErr = errors.New("bad config")
+	c, w = setupTestContext()
+	c.Request = httptest.NewRequest("POST", "/admin/reload", nil)
+	handler(c)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", w.Code)
+	}
+
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "config.reload"})
+	if len(events) != 2 || events[0].Details["error"] != "bad config" {
+		t.Errorf("Expected both reloads audited, got %+v", events)
+	}
+}
+
+type fakeBackuper struct {
+	data     string
+	err      error
+	restored string
+}
+
+func (f *fakeBackuper) Backup(w io.Writer) error {
+	if f.err != nil {
+		return f.err
+	}
+	_, err := io.WriteString(w, f.data)
+	return err
+}
+
+func (f *fakeBackuper) Restore(r io.Reader) error {
+	if f.err != nil {
+		return f.err
+	}
+	b, err := io.ReadAll(r)
+	f.restored = string(b)
+	return err
+}
+
+// TestBackupHandler tests downloading a backup
+func TestBackupHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	b := &fakeBackuper{data: "SQLite format 3"}
+
+	ctx, w := setupTestContext()
+	ctx.Request = httptest.NewRequest("GET", "/admin/backup", nil)
+	BackupHandler(b, s)(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if w.Body.String() != b.data {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), `attachment; filename="no-spam-`) {
+		t.Errorf("Unexpected Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "store.backup"})
+	if len(events) != 1 {
+		t.Errorf("Expected backup to be audited, got %v", events)
+	}
+
+	ctx, w = setupTestContext()
+	ctx.Request = httptest.NewRequest("GET", "/admin/backup", nil)
+	BackupHandler(&fakeBackuper{err: errors.New("disk full")}, s)(ctx)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Expected a JSON error, got %q", ct)
+	}
+}
+
+// TestRestoreHandler tests restoring a backup and rejecting invalid ones
+func TestRestoreHandler(t *testing.T) {
+	s := store.NewMemoryStore()
+	b := &fakeBackuper{}
+
+	ctx, w := setupTestContext()
+	ctx.Request = httptest.NewRequest("POST", "/admin/restore", strings.NewReader("SQLite format 3"))
+	RestoreHandler(b, s)(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if b.restored != "SQLite format 3" {
+		t.Errorf("Expected the request body to be restored, got %q", b.restored)
+	}
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "store.restore"})
+	if len(events) != 1 {
+		t.Errorf("Expected restore to be audited, got %v", events)
+	}
+
+	ctx, w = setupTestContext()
+	ctx.Request = httptest.NewRequest("POST", "/admin/restore", strings.NewReader("junk"))
+	RestoreHandler(&fakeBackuper{err: store.ErrInvalidBackup}, s)(ctx)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid backup, got %d", w.Code)
+	}
+}
+
+// TestStorageHandlers tests reporting storage usage and compacting
+func TestStorageHandlers(t *testing.T) {
+	s, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CreateTopic("news")
+	s.SaveMessage("news", []byte(`{"n":1}`))
+
+	ctx, w := setupTestContext()
+	ctx.Request = httptest.NewRequest("GET", "/admin/storage", nil)
+	GetStorageHandler(s)(ctx)
+	var u store.StorageUsage
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected usage, got %d: %s", w.Code, w.Body.String())
+	}
+	if u.Backend != "sqlite" || len(u.Topics) != 1 || u.Topics[0].MessageBytes != 7 {
+		t.Errorf("Unexpected usage %+v", u)
+	}
+
+	ctx, w = setupTestContext()
+	ctx.Request = httptest.NewRequest("POST", "/admin/storage/compact", nil)
+	CompactStorageHandler(s, s)(ctx)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"bytes_after"`) {
+		t.Fatalf("Expected the compaction result, got %d: %s", w.Code, w.Body.String())
+	}
+	if events, _ := s.ListAuditEvents(store.AuditFilter{Action: "store.compact"}); len(events) != 1 {
+		t.Errorf("Expected compaction to be audited, got %v", events)
+	}
+}
+
+// TestExportImportHandlers tests moving data between stores with a JSON dump
+func TestExportImportHandlers(t *testing.T) {
+	src := store.NewMemoryStore()
+	src.CreateTopic("news")
+	src.CreateUser("alice", "hash", "subscriber")
+	src.AddSubscription("news", "tok", "fcm", "alice")
+	src.SaveMessage("news", []byte(`{"n":1}`))
+
+	ctx, w := setupTestContext()
+	ctx.Request = httptest.NewRequest("GET", "/admin/export?messages=5", nil)
+	ExportHandler(src)(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"password"`) {
+		t.Error("Export must not contain plaintext passwords")
+	}
+	dump := w.Body.Bytes()
+
+	dst := store.NewMemoryStore()
+	ctx, w = setupTestContext()
+	ctx.Request = httptest.NewRequest("POST", "/admin/import", bytes.NewReader(dump))
+	ImportHandler(dst)(ctx)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res store.ImportResult
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Topics != 1 || res.Users != 1 || res.Subscriptions != 1 || res.Messages != 1 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if subs, _ := dst.GetSubscribers("news"); len(subs) != 1 || subs[0].Username != "alice" {
+		t.Errorf("Expected subscription to be imported, got %v", subs)
+	}
+	events, _ := dst.ListAuditEvents(store.AuditFilter{Action: "store.import"})
+	if len(events) != 1 {
+		t.Errorf("Expected import to be audited, got %v", events)
+	}
+
+	ctx, w = setupTestContext()
+	ctx.Request = httptest.NewRequest("POST", "/admin/import", strings.NewReader(`{"version": 42}`))
+	ImportHandler(dst)(ctx)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported version, got %d", w.Code)
+	}
+}
+
+func TestSearchMessagesHandler(t *testing.T) {
+	h, s := setupTestHubForAdmin(t)
+	_ = s.CreateTopic("news")
+	_, _ = s.SaveMessage("news", []byte(`{"title": "Disk full"}`))
+	_, _ = s.SaveMessage("news", []byte(`{"title": "CPU high"}`))
+
+	search := func(query string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "name", Value: "news"}}
+		c.Request = httptest.NewRequest("GET", "/admin/topics/news/messages/search?"+query, nil)
+		SearchMessagesHandler(h)(c)
+		return w
+	}
+
+	w := search("q=disk&from=2000-01-01T00:00:00Z")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var msgs []store.Message
+	json.Unmarshal(w.Body.Bytes(), &msgs)
+	if len(msgs) != 1 || string(msgs[0].Payload) != `{"title": "Disk full"}` {
+		t.Errorf("Expected the disk message, got %+v", msgs)
+	}
+
+	if w := search("to=tuesday"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid time, got %d", w.Code)
+	}
+	if w := search("limit=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid limit, got %d", w.Code)
+	}
 }
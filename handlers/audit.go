@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"no-spam/apierror"
+	"no-spam/hub"
+	"no-spam/middleware"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditor is implemented by both store.Store and *hub.Hub.
+type auditor interface {
+	AddAuditEvent(e store.AuditEvent) error
+}
+
+// audit records an action by the authenticated user. A failure to record is
+// logged but doesn't fail the request, since the action already happened.
+func audit(c *gin.Context, a auditor, action, target string, details map[string]string) {
+	auditAs(c, a, middleware.GetUsername(c), action, target, details)
+}
+
+// auditAs records an action for an explicit actor, e.g. the username of a failed login.
+func auditAs(c *gin.Context, a auditor, actor, action, target string, details map[string]string) {
+	err := a.AddAuditEvent(store.AuditEvent{
+		Actor:   actor,
+		IP:      c.ClientIP(),
+		Action:  action,
+		Target:  target,
+		Details: details,
+	})
+	if err != nil {
+		log.Printf("[Audit] Failed to record %s by %s: %v", action, actor, err)
+	}
+}
+
+// GetAuditLogHandler lists audit events, newest first. Filters: actor,
+// action (exact, or a prefix like "user.*"), target, since and until
+// (RFC 3339) and limit (default 100).
+func GetAuditLogHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := store.AuditFilter{
+			Actor:  c.Query("actor"),
+			Action: c.Query("action"),
+			Target: c.Query("target"),
+			Limit:  100,
+		}
+
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"since", &f.Since}, {"until", &f.Until}} {
+			v := c.Query(p.name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid "+p.name+", expected RFC 3339")
+				return
+			}
+			*p.dst = t
+		}
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				apierror.Respond(c, http.StatusBadRequest, "limit must be between 1 and 1000")
+				return
+			}
+			f.Limit = n
+		}
+
+		events, err := h.ListAuditEvents(f)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get audit log")
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	}
+}
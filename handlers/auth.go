@@ -1,16 +1,31 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"no-spam/apierror"
+	"no-spam/events"
 	"no-spam/middleware"
+	"no-spam/password"
+	"no-spam/rbac"
 	"no-spam/store"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// lastAdminMessage explains store.ErrLastAdmin to clients.
+const lastAdminMessage = "Cannot delete, demote or disable the last admin"
+
 func CreateUserHandler(s store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
@@ -20,86 +35,487 @@ func CreateUserHandler(s store.Store) gin.HandlerFunc {
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
 			return
 		}
 
-		// Validate Role
+		if status, err := createUser(c, s, req.Username, req.Password, req.Role); err != nil {
+			apierror.Respond(c, status, err.Error())
+			return
+		}
 		if req.Role == "" {
 			req.Role = "subscriber"
 		}
-		validRoles := map[string]bool{"admin": true, "publisher": true, "subscriber": true}
-		if !validRoles[req.Role] {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be admin, publisher, or subscriber"})
-			return
+		c.JSON(http.StatusCreated, gin.H{"message": "User created", "username": req.Username, "role": req.Role})
+	}
+}
+
+// createUser creates a user with the subscriber role by default, and
+// returns the status code and message of any failure.
+func createUser(c *gin.Context, s store.Store, username, pw, role string) (int, error) {
+	if role == "" {
+		role = "subscriber"
+	}
+	if _, err := rbac.Permissions(s, role); err != nil {
+		if err == rbac.ErrRoleNotFound {
+			return http.StatusBadRequest, errors.New("Invalid role. Must be admin, publisher, subscriber or a custom role")
+		}
+		return http.StatusInternalServerError, errors.New("Failed to check role")
+	}
+
+	hash, err := password.Hash(pw)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("Failed to hash password")
+	}
+
+	if err := s.CreateUser(username, hash, role); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			return http.StatusConflict, errors.New("User already exists")
 		}
+		return http.StatusInternalServerError, errors.New("Failed to create user")
+	}
 
-		// Hash password
-		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	audit(c, s, "user.create", username, map[string]string{"role": role})
+	events.Emit(events.UserCreated, middleware.GetUsername(c), map[string]any{"username": username, "role": role})
+	return 0, nil
+}
+
+// maxBulkUsers caps the rows of one bulk user creation.
+const maxBulkUsers = 1000
+
+// generatedPasswordLength is the length of passwords generated for bulk users.
+const generatedPasswordLength = 16
+
+// BulkUserRow is one user of a bulk creation.
+type BulkUserRow struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// BulkUserResult is the outcome of one row of a bulk creation.
+type BulkUserResult struct {
+	Row      int    `json:"row"` // 1-based, not counting a CSV header
+	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	Status   string `json:"status"`             // "created" or "error"
+	Password string `json:"password,omitempty"` // Generated password, only returned once
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkCreateUsersHandler creates many users from a JSON array or a CSV
+// upload (Content-Type text/csv) with a username,password,role header. Each
+// row succeeds or fails on its own. With ?generate_passwords=true, rows
+// without a password get a generated one, returned in the response only.
+func BulkCreateUsersHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rows []BulkUserRow
+		var err error
+		if c.ContentType() == "text/csv" {
+			rows, err = parseUserCSV(c.Request.Body)
+		} else {
+			err = c.ShouldBindJSON(&rows)
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request: "+err.Error())
 			return
 		}
+		if len(rows) == 0 {
+			apierror.Respond(c, http.StatusBadRequest, "No users given")
+			return
+		}
+		if len(rows) > maxBulkUsers {
+			apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("At most %d users per request", maxBulkUsers))
+			return
+		}
+		generate := c.Query("generate_passwords") == "true"
 
-		if err := s.CreateUser(req.Username, string(hash), req.Role); err != nil {
-			if strings.Contains(err.Error(), "UNIQUE constraint") {
-				c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
-				return
+		results := make([]BulkUserResult, 0, len(rows))
+		created := 0
+		for i, row := range rows {
+			r := BulkUserResult{Row: i + 1, Username: row.Username, Role: row.Role, Status: "error"}
+			if r.Role == "" {
+				r.Role = "subscriber"
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-			return
+			pw := row.Password
+			switch {
+			case row.Username == "":
+				r.Error = "Username required"
+			case pw == "" && !generate:
+				r.Error = "Password required"
+			}
+			if r.Error == "" && pw == "" {
+				if pw, err = password.Generate(generatedPasswordLength); err != nil {
+					r.Error = "Failed to generate password"
+				}
+			}
+			if r.Error == "" {
+				if _, err := createUser(c, s, row.Username, pw, row.Role); err != nil {
+					r.Error = err.Error()
+				} else {
+					r.Status = "created"
+					created++
+					if row.Password == "" {
+						r.Password = pw
+					}
+				}
+			}
+			results = append(results, r)
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"message": "User created", "username": req.Username, "role": req.Role})
+		c.JSON(http.StatusOK, gin.H{"created": created, "failed": len(rows) - created, "results": results})
+	}
+}
+
+// parseUserCSV reads users from CSV with a header row naming the username,
+// password and role columns, in any order. Only username is required.
+func parseUserCSV(r io.Reader) ([]BulkUserRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing CSV header")
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["username"]; !ok {
+		return nil, fmt.Errorf("CSV header has no username column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var rows []BulkUserRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, BulkUserRow{
+			Username: field(rec, "username"),
+			Password: field(rec, "password"),
+			Role:     field(rec, "role"),
+		})
+		if len(rows) > maxBulkUsers {
+			return rows, nil
+		}
 	}
 }
 
+// DeleteUserHandler deletes a user and revokes their tokens. A user who
+// still has subscriptions is only deleted when ?subscriptions= says what
+// happens to them: "delete" removes them, and "reassign" with ?to= moves
+// them to another user.
 func DeleteUserHandler(s store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username := c.Param("username")
 		if username == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Username required"})
+			apierror.Respond(c, http.StatusBadRequest, "Username required")
 			return
 		}
 
 		// Prevent deleting self? Use middleware.GetUsername(c)
 		operator := middleware.GetUsername(c)
 		if operator == username {
-			c.JSON(http.StatusConflict, gin.H{"error": "Cannot delete yourself"})
+			apierror.Respond(c, http.StatusConflict, "Cannot delete yourself")
+			return
+		}
+
+		user, err := s.GetUser(username)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to check user")
+			return
+		}
+		if user == nil {
+			apierror.Respond(c, http.StatusNotFound, "User not found")
 			return
 		}
 
+		// The store refuses to delete the last admin too; checking first
+		// leaves their subscriptions untouched.
+		if user.Role == "admin" && !user.Disabled {
+			last, err := lastAdmin(s, username)
+			if err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to check admins")
+				return
+			}
+			if last {
+				apierror.Respond(c, http.StatusConflict, lastAdminMessage)
+				return
+			}
+		}
+
+		mode, to := c.Query("subscriptions"), c.Query("to")
+		switch mode {
+		case "":
+			subs, err := s.GetSubscriptionsByUser(username)
+			if err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to check subscriptions")
+				return
+			}
+			if len(subs) > 0 {
+				apierror.Respond(c, http.StatusConflict, fmt.Sprintf("User has %d subscriptions; pass subscriptions=delete or subscriptions=reassign&to=<username>", len(subs)))
+				return
+			}
+		case "delete":
+			to = ""
+		case "reassign":
+			if to == "" || to == username {
+				apierror.Respond(c, http.StatusBadRequest, "reassign needs another user in to")
+				return
+			}
+			target, err := s.GetUser(to)
+			if err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to check user")
+				return
+			}
+			if target == nil {
+				apierror.Respond(c, http.StatusBadRequest, "User to reassign subscriptions to not found")
+				return
+			}
+		default:
+			apierror.Respond(c, http.StatusBadRequest, "subscriptions must be delete or reassign")
+			return
+		}
+
+		var moved int64
+		if mode != "" {
+			if moved, err = s.ReassignSubscriptions(username, to); err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to "+mode+" subscriptions")
+				return
+			}
+		}
 		if err := s.DeleteUser(username); err != nil {
-			if strings.Contains(err.Error(), "user not found") {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			if errors.Is(err, store.ErrNotFound) {
+				apierror.Respond(c, http.StatusNotFound, "User not found")
+				return
+			}
+			if errors.Is(err, store.ErrLastAdmin) {
+				apierror.Respond(c, http.StatusConflict, lastAdminMessage)
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete user")
+			return
+		}
+		if _, err := s.DeleteUserSessions(username); err != nil {
+			log.Printf("[AUTH] Failed to revoke sessions of deleted user %s: %v", username, err)
+		}
+
+		var details map[string]string
+		if mode != "" {
+			details = map[string]string{"subscriptions": mode, "count": strconv.FormatInt(moved, 10)}
+			if to != "" {
+				details["to"] = to
+			}
+		}
+		audit(c, s, "user.delete", username, details)
+		events.Emit(events.UserDeleted, middleware.GetUsername(c), map[string]any{"username": username})
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted", "subscriptions": moved})
+	}
+}
+
+// DisableUserHandler disables a user: they can't log in or get tokens, their
+// tokens are revoked and their subscriptions are paused until
+// EnableUserHandler reactivates them.
+func DisableUserHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if username == middleware.GetUsername(c) {
+			apierror.Respond(c, http.StatusConflict, "Cannot disable yourself")
+			return
+		}
+		if !setUserDisabled(c, s, username, true) {
+			return
+		}
+		if _, err := s.DeleteUserSessions(username); err != nil {
+			log.Printf("[AUTH] Failed to revoke sessions of disabled user %s: %v", username, err)
+		}
+		audit(c, s, "user.disable", username, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "User disabled"})
+	}
+}
+
+// EnableUserHandler reactivates a disabled user, resuming deliveries to their
+// subscriptions.
+func EnableUserHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if !setUserDisabled(c, s, username, false) {
+			return
+		}
+		audit(c, s, "user.enable", username, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "User enabled"})
+	}
+}
+
+// UpdateUserHandler changes a user's role, disablement and profile fields.
+// Omitted fields are kept. A new role or disabling revokes the user's
+// tokens, which carry the role. The store refuses to demote or disable the
+// last admin who isn't disabled.
+func UpdateUserHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Role        *string `json:"role"`
+			Disabled    *bool   `json:"disabled"`
+			DisplayName *string `json:"display_name"`
+			Email       *string `json:"email"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		username := c.Param("username")
+		user, err := s.GetUser(username)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to check user")
+			return
+		}
+		if user == nil {
+			apierror.Respond(c, http.StatusNotFound, "User not found")
 			return
 		}
+		updated := *user
+		if err := applyProfile(&updated, req.DisplayName, req.Email); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Role != nil && *req.Role != user.Role {
+			if _, err := rbac.Permissions(s, *req.Role); err != nil {
+				if err == rbac.ErrRoleNotFound {
+					apierror.Respond(c, http.StatusBadRequest, "Invalid role. Must be admin, publisher, subscriber or a custom role")
+					return
+				}
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to check role")
+				return
+			}
+			updated.Role = *req.Role
+		}
+		if req.Disabled != nil {
+			if *req.Disabled && username == middleware.GetUsername(c) {
+				apierror.Respond(c, http.StatusConflict, "Cannot disable yourself")
+				return
+			}
+			updated.Disabled = *req.Disabled
+		}
+
+		details := map[string]string{}
+		revoke := false
+		if updated.Role != user.Role || updated.Disabled != user.Disabled {
+			// Both at once, so a refused change doesn't leave the other applied
+			if err := s.SetUserAccess(username, updated.Role, updated.Disabled); err != nil {
+				if errors.Is(err, store.ErrNotFound) {
+					apierror.Respond(c, http.StatusNotFound, "User not found")
+					return
+				}
+				if errors.Is(err, store.ErrLastAdmin) {
+					apierror.Respond(c, http.StatusConflict, lastAdminMessage)
+					return
+				}
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to update user")
+				return
+			}
+		}
+		if updated.Role != user.Role {
+			details["role"] = updated.Role
+			revoke = true
+		}
+		if updated.Disabled != user.Disabled {
+			details["disabled"] = strconv.FormatBool(updated.Disabled)
+			revoke = revoke || updated.Disabled
+		}
+		if updated.DisplayName != user.DisplayName || updated.Email != user.Email {
+			if err := s.SetUserProfile(username, updated.DisplayName, updated.Email); err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to update profile")
+				return
+			}
+			details["display_name"], details["email"] = updated.DisplayName, updated.Email
+		}
+		if revoke {
+			if _, err := s.DeleteUserSessions(username); err != nil {
+				log.Printf("[AUTH] Failed to revoke sessions of updated user %s: %v", username, err)
+			}
+		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+		if len(details) > 0 {
+			audit(c, s, "user.update", username, details)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"username":     username,
+			"role":         updated.Role,
+			"disabled":     updated.Disabled,
+			"display_name": updated.DisplayName,
+			"email":        updated.Email,
+		})
 	}
 }
 
+// lastAdmin reports whether username is the only admin who isn't disabled.
+// The store enforces this itself; it lets handlers refuse before other
+// changes.
+func lastAdmin(s store.Store, username string) (bool, error) {
+	users, err := s.ListUsers()
+	if err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if u.Role == "admin" && !u.Disabled && u.Username != username {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// setUserDisabled stores the flag, responding and returning false on failure.
+func setUserDisabled(c *gin.Context, s store.Store, username string, disabled bool) bool {
+	if err := s.SetUserDisabled(username, disabled); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			apierror.Respond(c, http.StatusNotFound, "User not found")
+			return false
+		}
+		if errors.Is(err, store.ErrLastAdmin) {
+			apierror.Respond(c, http.StatusConflict, lastAdminMessage)
+			return false
+		}
+		apierror.Respond(c, http.StatusInternalServerError, "Failed to update user")
+		return false
+	}
+	return true
+}
+
 func ListUsersHandler(s store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		users, err := s.ListUsers()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list users")
 			return
 		}
 
 		type UserResponse struct {
-			Username string `json:"username"`
-			Role     string `json:"role"`
+			Username    string `json:"username"`
+			Role        string `json:"role"`
+			Disabled    bool   `json:"disabled,omitempty"`
+			DisplayName string `json:"display_name,omitempty"`
+			Email       string `json:"email,omitempty"`
 		}
 
 		var resp []UserResponse
 		for _, u := range users {
 			resp = append(resp, UserResponse{
-				Username: u.Username,
-				Role:     u.Role,
+				Username:    u.Username,
+				Role:        u.Role,
+				Disabled:    u.Disabled,
+				DisplayName: u.DisplayName,
+				Email:       u.Email,
 			})
 		}
 
@@ -110,58 +526,373 @@ func ListUsersHandler(s store.Store) gin.HandlerFunc {
 func LoginHandler(s store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Username string `json:"username" binding:"required"`
-			Password string `json:"password" binding:"required"`
+			Username     string `json:"username" binding:"required"`
+			Password     string `json:"password" binding:"required"`
+			OTP          string `json:"otp"`           // TOTP code, for users with 2FA
+			RecoveryCode string `json:"recovery_code"` // Instead of otp
+			NewPassword  string `json:"new_password"`  // For users who must change their password
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
 			return
 		}
 
 		user, err := s.GetUser(req.Username)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			apierror.Respond(c, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 		if user == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			auditAs(c, s, req.Username, "login.failure", req.Username, map[string]string{"reason": "unknown user"})
+			apierror.Respond(c, http.StatusUnauthorized, "Invalid credentials")
 			return
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		if err := password.Verify(user.PasswordHash, req.Password); err != nil {
+			auditAs(c, s, req.Username, "login.failure", req.Username, map[string]string{"reason": "wrong password"})
+			apierror.Respond(c, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+		if user.Disabled {
+			auditAs(c, s, req.Username, "login.failure", req.Username, map[string]string{"reason": "disabled"})
+			apierror.Respond(c, http.StatusForbidden, errUserDisabled.Error())
 			return
 		}
+		if !user.MustChangePassword {
+			rehash(s, user.Username, user.PasswordHash, req.Password)
+		}
+
+		if user.TOTPEnabled {
+			if req.OTP == "" && req.RecoveryCode == "" {
+				apierror.RespondCode(c, http.StatusUnauthorized, apierror.TwoFactorRequired, "Two-factor code required", gin.H{"two_factor_required": true})
+				return
+			}
+			ok, err := checkSecondFactor(c, s, user, req.OTP, req.RecoveryCode)
+			if err == errTwoFactorLocked {
+				auditAs(c, s, req.Username, "login.failure", req.Username, map[string]string{"reason": "2fa locked"})
+				apierror.RespondCode(c, http.StatusTooManyRequests, apierror.TwoFactorRequired, err.Error(), gin.H{"two_factor_required": true})
+				return
+			}
+			if err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if !ok {
+				auditAs(c, s, req.Username, "login.failure", req.Username, map[string]string{"reason": "invalid 2fa code"})
+				apierror.RespondCode(c, http.StatusUnauthorized, apierror.TwoFactorRequired, "Invalid two-factor code", gin.H{"two_factor_required": true})
+				return
+			}
+		}
+
+		if user.MustChangePassword {
+			if req.NewPassword == "" {
+				apierror.RespondCode(c, http.StatusForbidden, apierror.PasswordChangeRequired, "Password change required", gin.H{"password_change_required": true})
+				return
+			}
+			if req.NewPassword == req.Password {
+				apierror.RespondCode(c, http.StatusBadRequest, apierror.PasswordChangeRequired, "New password must differ from the current one", gin.H{"password_change_required": true})
+				return
+			}
+			if err := changePassword(s, user.Username, req.NewPassword); err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to change password")
+				return
+			}
+			auditAs(c, s, user.Username, "password.change", user.Username, nil)
+		}
 
 		// Generate Token
-		token, err := middleware.GenerateToken(user.Username, user.Role)
+		token, err := issueToken(c, s, user.Username, user.Role, "password", 0)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		auditAs(c, s, user.Username, "login.success", user.Username, nil)
+		c.JSON(http.StatusOK, gin.H{"token": token.Token})
+	}
+}
+
+// ListRolesHandler lists built-in and custom roles.
+func ListRolesHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		custom, err := s.ListRoles()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list roles")
+			return
+		}
+
+		type RoleResponse struct {
+			Name        string   `json:"name"`
+			Permissions []string `json:"permissions"`
+			Builtin     bool     `json:"builtin"`
+		}
+
+		resp := []RoleResponse{}
+		for _, name := range []string{"admin", "publisher", "subscriber"} {
+			resp = append(resp, RoleResponse{Name: name, Permissions: rbac.Builtin[name], Builtin: true})
+		}
+		for _, r := range custom {
+			resp = append(resp, RoleResponse{Name: r.Name, Permissions: r.Permissions})
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// SaveRoleHandler creates or replaces a custom role.
+func SaveRoleHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("role")
+		var req struct {
+			Permissions []string `json:"permissions" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing permissions")
+			return
+		}
+
+		if _, ok := rbac.Builtin[name]; ok {
+			apierror.Respond(c, http.StatusConflict, rbac.ErrBuiltinRole.Error())
+			return
+		}
+		for _, p := range req.Permissions {
+			if err := rbac.ValidatePermission(p); err != nil {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		if err := s.SaveRole(store.Role{Name: name, Permissions: req.Permissions}); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to save role")
+			return
+		}
+
+		audit(c, s, "role.save", name, map[string]string{"permissions": strings.Join(req.Permissions, ",")})
+		c.JSON(http.StatusOK, gin.H{"message": "Role saved", "name": name, "permissions": req.Permissions})
+	}
+}
+
+// DeleteRoleHandler removes a custom role that no user still has.
+func DeleteRoleHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("role")
+		if _, ok := rbac.Builtin[name]; ok {
+			apierror.Respond(c, http.StatusConflict, rbac.ErrBuiltinRole.Error())
+			return
+		}
+
+		users, err := s.ListUsers()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to check role usage")
+			return
+		}
+		for _, u := range users {
+			if u.Role == name {
+				apierror.Respond(c, http.StatusConflict, "Role is still assigned to users")
+				return
+			}
+		}
+
+		ok, err := s.DeleteRole(name)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete role")
+			return
+		}
+		if !ok {
+			apierror.Respond(c, http.StatusNotFound, "Role not found")
+			return
+		}
+
+		audit(c, s, "role.delete", name, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+	}
+}
+
+// CreateInvitationHandler issues an invitation code for self-registration.
+func CreateInvitationHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Role      string `json:"role"`
+			MaxUses   int    `json:"max_uses"`
+			ExpiresIn string `json:"expires_in"` // Go duration, e.g. "72h"
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		if req.Role == "" {
+			req.Role = "subscriber"
+		}
+		if _, err := rbac.Permissions(s, req.Role); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Unknown role")
+			return
+		}
+		if req.MaxUses == 0 {
+			req.MaxUses = 1
+		}
+		if req.MaxUses < 0 {
+			apierror.Respond(c, http.StatusBadRequest, "max_uses must be positive")
+			return
+		}
+
+		inv := store.Invitation{Role: req.Role, MaxUses: req.MaxUses, CreatedBy: middleware.GetUsername(c)}
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid expires_in duration")
+				return
+			}
+			expires := time.Now().Add(d)
+			inv.ExpiresAt = &expires
+		}
+
+		code := make([]byte, 16)
+		if _, err := rand.Read(code); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to generate code")
+			return
+		}
+		inv.Code = hex.EncodeToString(code)
+
+		if err := s.CreateInvitation(inv); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to create invitation")
+			return
+		}
+
+		audit(c, s, "invitation.create", req.Role, map[string]string{"max_uses": strconv.Itoa(req.MaxUses), "expires_in": req.ExpiresIn})
+		c.JSON(http.StatusCreated, inv)
+	}
+}
+
+func ListInvitationsHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitations, err := s.ListInvitations()
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list invitations")
+			return
+		}
+		c.JSON(http.StatusOK, invitations)
+	}
+}
+
+func DeleteInvitationHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, err := s.DeleteInvitation(c.Param("code"))
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to delete invitation")
+			return
+		}
+		if !ok {
+			apierror.Respond(c, http.StatusNotFound, "Invitation not found")
+			return
+		}
+
+		audit(c, s, "invitation.delete", c.Param("code"), nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation deleted"})
+	}
+}
+
+// RegisterHandler creates an account from an invitation code and logs the new user in.
+func RegisterHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code     string `json:"code" binding:"required"`
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (code, username, password)")
+			return
+		}
+
+		hash, err := password.Hash(req.Password)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to hash password")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"token": token})
+		inv, err := s.RedeemInvitation(req.Code, req.Username, hash)
+		if err != nil {
+			if errors.Is(err, store.ErrDuplicate) {
+				apierror.Respond(c, http.StatusConflict, "User already exists")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to register")
+			return
+		}
+		if inv == nil {
+			auditAs(c, s, req.Username, "register.failure", req.Username, map[string]string{"reason": "invalid invitation"})
+			apierror.Respond(c, http.StatusForbidden, "Invalid or expired invitation code")
+			return
+		}
+
+		token, err := issueToken(c, s, req.Username, inv.Role, "register", 0)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		auditAs(c, s, req.Username, "user.register", req.Username, map[string]string{"role": inv.Role})
+		events.Emit(events.UserCreated, req.Username, map[string]any{"username": req.Username, "role": inv.Role, "method": "register"})
+		c.JSON(http.StatusCreated, gin.H{"message": "User registered", "username": req.Username, "role": inv.Role, "token": token.Token})
 	}
 }
 
-func RefreshHandler() gin.HandlerFunc {
+// RefreshHandler replaces the request's token with a new one. The old
+// token's session is revoked.
+func RefreshHandler(s store.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username := middleware.GetUsername(c)
 		role := middleware.GetRole(c)
 
 		if username == "" || role == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			apierror.Respond(c, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
 		// Issue new token
-		newToken, err := middleware.GenerateToken(username, role)
+		newToken, err := issueToken(c, s, username, role, "refresh", 0)
+		if err == errUserDisabled {
+			apierror.Respond(c, http.StatusForbidden, err.Error())
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to refresh token")
 			return
 		}
+		if id := middleware.GetTokenID(c); id != "" {
+			if _, err := s.DeleteSession(username, id); err != nil {
+				log.Printf("[AUTH] Failed to revoke refreshed session %s: %v", id, err)
+			}
+		}
 
-		c.JSON(http.StatusOK, gin.H{"token": newToken})
+		c.JSON(http.StatusOK, gin.H{"token": newToken.Token})
+	}
+}
+
+// rehash upgrades a verified password's hash to the current hashing policy.
+// A failure is logged but doesn't fail the login.
+func rehash(s store.Store, username, hash, plain string) {
+	if !password.NeedsRehash(hash) {
+		return
+	}
+	newHash, err := password.Hash(plain)
+	if err == nil {
+		err = s.SetUserPassword(username, newHash)
+	}
+	if err != nil {
+		log.Printf("[AUTH] Failed to rehash password of %s: %v", username, err)
+	}
+}
+
+// changePassword replaces a user's password and lifts any requirement to change it.
+func changePassword(s store.Store, username, plain string) error {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+	if err := s.SetUserPassword(username, hash); err != nil {
+		return err
 	}
+	return s.SetMustChangePassword(username, false)
 }
@@ -5,19 +5,25 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"no-spam/middleware"
+	"no-spam/password"
 	"no-spam/store"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// setupTestContext creates a test Gin context
+// setupTestContext creates a test Gin context. Handlers are tested without
+// the permission middleware, so the context grants every permission.
 func setupTestContext() (*gin.Context, *httptest.ResponseRecorder) {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
+	c.Set("permissions", []string{"*"})
 	return c, w
 }
 
@@ -100,6 +106,105 @@ func TestLoginHandler(t *testing.T) {
 	}
 }
 
+// TestLoginHandler_RehashesPassword tests upgrading a bcrypt hash to argon2id on login
+func TestLoginHandler_RehashesPassword(t *testing.T) {
+	s := setupTestStore(t)
+	login := func(pw string) int {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"username": "testadmin", "password": "`+pw+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		LoginHandler(s)(c)
+		return w.Code
+	}
+
+	if code := login("wrongpassword"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", code)
+	}
+	if u, _ := s.GetUser("testadmin"); !strings.HasPrefix(u.PasswordHash, "$2a$") {
+		t.Errorf("A failed login must not rehash, got %s", u.PasswordHash)
+	}
+
+	if code := login("password123"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	u, _ := s.GetUser("testadmin")
+	if !strings.HasPrefix(u.PasswordHash, "$argon2id$") {
+		t.Fatalf("Expected hash to be upgraded to argon2id, got %s", u.PasswordHash)
+	}
+	if password.NeedsRehash(u.PasswordHash) {
+		t.Error("Upgraded hash should match the current policy")
+	}
+	if code := login("password123"); code != http.StatusOK {
+		t.Errorf("Expected login with upgraded hash to succeed, got %d", code)
+	}
+}
+
+// TestLoginHandler_PasswordChangeRequired tests forcing a new password at login
+func TestLoginHandler_PasswordChangeRequired(t *testing.T) {
+	s := setupTestStore(t)
+	s.SetMustChangePassword("testadmin", true)
+	login := func(body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/login", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		LoginHandler(s)(c)
+		return w
+	}
+
+	w := login(`{"username": "testadmin", "password": "password123"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "password_change_required") {
+		t.Fatalf("Expected 403 asking for a new password, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := login(`{"username": "testadmin", "password": "password123", "new_password": "password123"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when reusing the password, got %d", w.Code)
+	}
+	if w := login(`{"username": "testadmin", "password": "wrong", "new_password": "fresh-password"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong current password, got %d", w.Code)
+	}
+
+	w = login(`{"username": "testadmin", "password": "password123", "new_password": "fresh-password"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "token") {
+		t.Fatalf("Expected a token after changing the password, got %d: %s", w.Code, w.Body.String())
+	}
+	if u, _ := s.GetUser("testadmin"); u.MustChangePassword {
+		t.Error("Expected the requirement to be lifted")
+	}
+	if w := login(`{"username": "testadmin", "password": "password123"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old password to stop working, got %d", w.Code)
+	}
+	if w := login(`{"username": "testadmin", "password": "fresh-password"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected the new password to work, got %d", w.Code)
+	}
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "password.change"})
+	if len(events) != 1 {
+		t.Errorf("Expected the change to be audited, got %v", events)
+	}
+}
+
+func TestLoginHandler_AuditsFailures(t *testing.T) {
+	s := setupTestStore(t)
+	handler := LoginHandler(s)
+
+	for _, password := range []string{"wrongpassword", "password123"} {
+		c, _ := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"username": "testadmin", "password": "`+password+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.RemoteAddr = "192.0.2.7:1234"
+		handler(c)
+	}
+
+	events, err := s.ListAuditEvents(store.AuditFilter{Action: "login.*"})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Expected 2 login events, got %v (%v)", events, err)
+	}
+	if events[1].Action != "login.failure" || events[1].Actor != "testadmin" || events[1].IP != "192.0.2.7" {
+		t.Errorf("Unexpected failure event: %+v", events[1])
+	}
+	if events[0].Action != "login.success" {
+		t.Errorf("Expected login.success, got %+v", events[0])
+	}
+}
+
 // TestCreateUserHandler tests user creation
 func TestCreateUserHandler(t *testing.T) {
 	s := setupTestStore(t)
@@ -174,6 +279,11 @@ func TestDeleteUserHandler(t *testing.T) {
 			username:       "nonexistent",
 			expectedStatus: http.StatusNotFound,
 		},
+		{
+			name:           "Delete the last admin",
+			username:       "testadmin",
+			expectedStatus: http.StatusConflict,
+		},
 	}
 
 	for _, tt := range tests {
@@ -192,6 +302,157 @@ func TestDeleteUserHandler(t *testing.T) {
 	}
 }
 
+// TestDeleteUserHandler_Subscriptions tests that deleting a subscribed user
+// needs an explicit choice for the subscriptions
+func TestDeleteUserHandler_Subscriptions(t *testing.T) {
+	s := setupTestStore(t)
+	s.CreateTopic("news")
+	s.AddSubscription("news", "pub-1", "webhook", "testpublisher")
+	s.AddSubscription("news", "sub-1", "webhook", "testsubscriber")
+	handler := DeleteUserHandler(s)
+
+	del := func(username, query string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "username", Value: username}}
+		c.Request = httptest.NewRequest("DELETE", "/admin/users/"+username+query, nil)
+		handler(c)
+		return w
+	}
+
+	if w := del("testpublisher", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 without a choice, got %d", w.Code)
+	}
+	if w := del("testpublisher", "?subscriptions=reassign&to=nobody"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown target, got %d", w.Code)
+	}
+	if u, _ := s.GetUser("testpublisher"); u == nil {
+		t.Fatal("Expected the user kept after refused deletions")
+	}
+
+	if w := del("testpublisher", "?subscriptions=reassign&to=testadmin"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if subs, _ := s.GetSubscriptionsByUser("testadmin"); len(subs) != 1 || subs[0].Token != "pub-1" {
+		t.Errorf("Expected the subscription reassigned to testadmin, got %v", subs)
+	}
+
+	if w := del("testsubscriber", "?subscriptions=delete"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if subs, _ := s.GetSubscribers("news"); len(subs) != 1 {
+		t.Errorf("Expected the deleted user's subscription removed, got %v", subs)
+	}
+}
+
+// TestDisableUserHandler tests that disabled users can't log in until reactivated
+func TestDisableUserHandler(t *testing.T) {
+	s := setupTestStore(t)
+	s.CreateTopic("news")
+	s.AddSubscription("news", "sub-1", "webhook", "testsubscriber")
+
+	call := func(handler gin.HandlerFunc, username string) int {
+		c, w := setupTestContext()
+		c.Set("username", "testadmin")
+		c.Params = gin.Params{{Key: "username", Value: username}}
+		c.Request = httptest.NewRequest("POST", "/admin/users/"+username, nil)
+		handler(c)
+		return w.Code
+	}
+	login := func() int {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/login", strings.NewReader(`{"username":"testsubscriber","password":"password123"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		LoginHandler(s)(c)
+		return w.Code
+	}
+
+	if code := call(DisableUserHandler(s), "testadmin"); code != http.StatusConflict {
+		t.Errorf("Expected 409 disabling yourself, got %d", code)
+	}
+	if code := call(DisableUserHandler(s), "nobody"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown user, got %d", code)
+	}
+	if code := call(DisableUserHandler(s), "testsubscriber"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := login(); code != http.StatusForbidden {
+		t.Errorf("Expected a disabled user's login refused, got %d", code)
+	}
+	if subs, _ := s.GetSubscribers("news"); len(subs) != 0 {
+		t.Errorf("Expected the subscription paused, got %v", subs)
+	}
+	c, _ := setupTestContext()
+	c.Request = httptest.NewRequest("POST", "/refresh", nil)
+	if _, err := issueToken(c, s, "testsubscriber", "subscriber", "refresh", 0); err != errUserDisabled {
+		t.Errorf("Expected errUserDisabled issuing a token, got %v", err)
+	}
+
+	if code := call(EnableUserHandler(s), "testsubscriber"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("Expected a reactivated user to log in, got %d", code)
+	}
+	if subs, _ := s.GetSubscribers("news"); len(subs) != 1 {
+		t.Errorf("Expected the subscription resumed, got %v", subs)
+	}
+}
+
+// TestUpdateUserHandler tests changing a user's role, disablement and profile
+func TestUpdateUserHandler(t *testing.T) {
+	s := setupTestStore(t)
+	handler := UpdateUserHandler(s)
+	s.CreateSession(store.Session{ID: "pub-session", Username: "testpublisher", IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	patch := func(username, body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Set("username", "testadmin")
+		c.Params = gin.Params{{Key: "username", Value: username}}
+		c.Request = httptest.NewRequest("PATCH", "/admin/users/"+username, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler(c)
+		return w
+	}
+
+	tests := []struct {
+		name           string
+		username       string
+		body           string
+		expectedStatus int
+	}{
+		{"Unknown user", "nobody", `{"role": "admin"}`, http.StatusNotFound},
+		{"Unknown role", "testpublisher", `{"role": "superuser"}`, http.StatusBadRequest},
+		{"Invalid email", "testpublisher", `{"email": "not an address"}`, http.StatusBadRequest},
+		{"Disable yourself", "testadmin", `{"disabled": true}`, http.StatusConflict},
+		{"Demote the last admin", "testadmin", `{"role": "publisher"}`, http.StatusConflict},
+		{"Promote", "testpublisher", `{"role": "admin", "display_name": "Pub"}`, http.StatusOK},
+		{"Demote with another admin left", "testadmin", `{"role": "subscriber"}`, http.StatusOK},
+		{"Disable the last admin", "testpublisher", `{"disabled": true}`, http.StatusConflict},
+		{"Demote and disable the last admin", "testpublisher", `{"role": "subscriber", "disabled": true}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := patch(tt.username, tt.body); w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Body: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if u, _ := s.GetUser("testpublisher"); u.Role != "admin" || u.DisplayName != "Pub" || u.Disabled {
+		t.Errorf("Expected testpublisher promoted and renamed, with no refused change applied, got %+v", u)
+	}
+	if sess, _ := s.GetSession("pub-session"); sess != nil {
+		t.Error("Expected the role change to revoke the user's tokens")
+	}
+	if u, _ := s.GetUser("testadmin"); u.Role != "subscriber" {
+		t.Errorf("Expected testadmin demoted, got %+v", u)
+	}
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "user.update"})
+	if len(events) != 2 {
+		t.Errorf("Expected both updates audited, got %+v", events)
+	}
+}
+
 // TestListUsersHandler tests listing users
 func TestListUsersHandler(t *testing.T) {
 	s := setupTestStore(t)
@@ -281,3 +542,166 @@ func TestGetTokenHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTokenHandler_ExpiresIn(t *testing.T) {
+	s := setupTestStore(t)
+	handler := GetTokenHandler(s)
+	middleware.SetTokenPolicy(middleware.TokenPolicy{Max: 48 * time.Hour})
+	defer middleware.SetTokenPolicy(middleware.TokenPolicy{})
+
+	call := func(expiresIn string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", "/admin/token?username=testpublisher&expires_in="+expiresIn, nil)
+		handler(c)
+		return w
+	}
+
+	w := call("1h")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	claims, err := middleware.ParseToken(resp["token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != time.Hour {
+		t.Errorf("Expected 1h token, got %v", ttl)
+	}
+
+	if w := call("72h"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 above the cap, got %d", w.Code)
+	}
+	if w := call("soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid duration, got %d", w.Code)
+	}
+}
+
+func TestRoleHandlers(t *testing.T) {
+	s := setupTestStore(t)
+
+	call := func(handler gin.HandlerFunc, method, role, body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Params = gin.Params{{Key: "role", Value: role}}
+		c.Request = httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler(c)
+		return w
+	}
+
+	if w := call(SaveRoleHandler(s), "PUT", "topic-admin", `{"permissions": ["manage_topics:news*", "bogus"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown permission, got %d", w.Code)
+	}
+	if w := call(SaveRoleHandler(s), "PUT", "topic-admin", `{"permissions": ["manage_users:news"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for scoped manage_users, got %d", w.Code)
+	}
+	if w := call(SaveRoleHandler(s), "PUT", "admin", `{"permissions": []}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for built-in role, got %d", w.Code)
+	}
+	if w := call(SaveRoleHandler(s), "PUT", "topic-admin", `{"permissions": ["manage_topics:news*"]}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Custom roles can be assigned
+	if w := call(CreateUserHandler(s), "POST", "", `{"username": "carol", "password": "pw", "role": "topic-admin"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(DeleteRoleHandler(s), "DELETE", "topic-admin", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for role in use, got %d", w.Code)
+	}
+
+	w := call(ListRolesHandler(s), "GET", "", "")
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"name":"topic-admin"`)) {
+		t.Errorf("Expected topic-admin in list, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegisterHandler(t *testing.T) {
+	s := setupTestStore(t)
+
+	c, w := setupTestContext()
+	c.Request = httptest.NewRequest("POST", "/admin/invitations", bytes.NewBufferString(`{"role": "publisher", "max_uses": 1, "expires_in": "1h"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	CreateInvitationHandler(s)(c)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var inv store.Invitation
+	json.Unmarshal(w.Body.Bytes(), &inv)
+
+	register := func(code, username string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/register", bytes.NewBufferString(`{"code": "`+code+`", "username": "`+username+`", "password": "secret"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		RegisterHandler(s)(c)
+		return w
+	}
+
+	if w := register("bogus", "dave"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for unknown code, got %d", w.Code)
+	}
+	if w := register(inv.Code, "testadmin"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for taken username, got %d", w.Code)
+	}
+	if w := register(inv.Code, "dave"); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if user, _ := s.GetUser("dave"); user == nil || user.Role != "publisher" {
+		t.Errorf("Expected dave to be a publisher, got %+v", user)
+	}
+	// Single use
+	if w := register(inv.Code, "erin"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for used-up code, got %d", w.Code)
+	}
+}
+
+func TestBulkCreateUsersHandler(t *testing.T) {
+	s := setupTestStore(t)
+	handler := BulkCreateUsersHandler(s)
+
+	send := func(url, contentType, body string) map[string]interface{} {
+		t.Helper()
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", url, bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", contentType)
+		handler(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := send("/admin/users/bulk", "application/json", `[
+		{"username": "alice", "password": "pass123", "role": "publisher"},
+		{"username": "testadmin", "password": "pass123"},
+		{"username": "bob"},
+		{"username": "carol", "password": "pass123", "role": "invalid"}
+	]`)
+	if resp["created"] != float64(1) || resp["failed"] != float64(3) {
+		t.Errorf("Expected 1 created and 3 failed, got %v", resp)
+	}
+	results := resp["results"].([]interface{})
+	for i, want := range []string{"", "User already exists", "Password required", "Invalid role"} {
+		r := results[i].(map[string]interface{})
+		if got, _ := r["error"].(string); !strings.HasPrefix(got, want) || (want == "") != (got == "") {
+			t.Errorf("Row %d: expected error %q, got %q", i+1, want, got)
+		}
+	}
+	if u, _ := s.GetUser("alice"); u == nil || u.Role != "publisher" {
+		t.Errorf("Expected alice to be a publisher, got %+v", u)
+	}
+
+	resp = send("/admin/users/bulk?generate_passwords=true", "text/csv", "username,role\ndave,subscriber\n")
+	r := resp["results"].([]interface{})[0].(map[string]interface{})
+	pw, _ := r["password"].(string)
+	if r["status"] != "created" || pw == "" {
+		t.Fatalf("Expected dave with a generated password, got %v", r)
+	}
+	u, _ := s.GetUser("dave")
+	if u == nil || password.Verify(u.PasswordHash, pw) != nil {
+		t.Error("Expected the generated password to be dave's")
+	}
+}
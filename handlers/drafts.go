@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"no-spam/apierror"
+	"no-spam/hub"
+	"no-spam/middleware"
+	"no-spam/rbac"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bindDraftMessage binds the send request of a draft. It responds and
+// returns false if the body is invalid.
+func bindDraftMessage(c *gin.Context, msg *hub.Message) bool {
+	if err := c.ShouldBindJSON(msg); err != nil {
+		if middleware.IsBodyTooLarge(err) {
+			apierror.Respond(c, http.StatusRequestEntityTooLarge, "Request body too large")
+			return false
+		}
+		apierror.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	return true
+}
+
+// CreateDraftHandler saves a send request to a topic as a draft, to be
+// previewed, tested and published later.
+func CreateDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var msg hub.Message
+		if !bindDraftMessage(c, &msg) {
+			return
+		}
+		if !middleware.Can(c, rbac.Publish, msg.Topic) {
+			apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot publish to this topic")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		d, err := h.CreateDraft(ctx, middleware.GetUsername(c), msg)
+		if err != nil {
+			draftError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, d)
+	}
+}
+
+// draftError responds to a failed draft operation.
+func draftError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, hub.ErrDraftNotFound):
+		apierror.Respond(c, http.StatusNotFound, "Draft not found")
+	case errors.Is(err, hub.ErrDraftPublished):
+		apierror.Respond(c, http.StatusConflict, "Draft already published")
+	case errors.Is(err, hub.ErrInvalidDraft):
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, hub.ErrTestSendFailed):
+		apierror.Respond(c, http.StatusBadGateway, err.Error())
+	default:
+		// The draft's message is checked like a send
+		sendError(c, err)
+	}
+}
+
+// ListDraftsHandler lists the drafts of the topics the user can publish to,
+// optionally of one topic.
+func ListDraftsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		drafts, err := h.ListDrafts(c.Query("topic"))
+		if err == hub.ErrTopicNotFound {
+			apierror.Respond(c, http.StatusNotFound, "Topic not found")
+			return
+		}
+		if err != nil {
+			log.Printf("ListDrafts error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list drafts")
+			return
+		}
+
+		visible := []store.Draft{}
+		for _, d := range drafts {
+			if middleware.Can(c, rbac.Publish, d.Topic) {
+				visible = append(visible, d)
+			}
+		}
+		c.JSON(http.StatusOK, visible)
+	}
+}
+
+// draftParam returns the draft of the request's :id, after checking the
+// user can publish to its topic. It responds and returns nil otherwise.
+func draftParam(c *gin.Context, h *hub.Hub) *store.Draft {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "Invalid draft id")
+		return nil
+	}
+	d, err := h.GetDraft(id)
+	if err != nil {
+		draftError(c, err)
+		return nil
+	}
+	if !middleware.Can(c, rbac.Publish, d.Topic) {
+		// Not revealing drafts of other topics
+		apierror.Respond(c, http.StatusNotFound, "Draft not found")
+		return nil
+	}
+	return d
+}
+
+func GetDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d := draftParam(c, h); d != nil {
+			c.JSON(http.StatusOK, d)
+		}
+	}
+}
+
+// UpdateDraftHandler replaces the send request of a draft that wasn't
+// published. The draft stays on its topic.
+func UpdateDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := draftParam(c, h)
+		if d == nil {
+			return
+		}
+		var msg hub.Message
+		if !bindDraftMessage(c, &msg) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		d, err := h.UpdateDraft(ctx, d.ID, msg)
+		if err != nil {
+			draftError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, d)
+	}
+}
+
+func DeleteDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := draftParam(c, h)
+		if d == nil {
+			return
+		}
+		if err := h.DeleteDraft(d.ID); err != nil {
+			draftError(c, err)
+			return
+		}
+
+		audit(c, h, "draft.delete", strconv.FormatInt(d.ID, 10), map[string]string{"topic": d.Topic})
+		c.JSON(http.StatusOK, gin.H{"message": "Draft deleted"})
+	}
+}
+
+// PreviewDraftHandler renders a draft as each provider would send it, in the
+// locale of the "locale" query parameter, with the audience it would reach.
+func PreviewDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := draftParam(c, h)
+		if d == nil {
+			return
+		}
+		locale := c.Query("locale")
+		if !validLocale(locale) {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid locale")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		preview, err := h.PreviewDraft(ctx, d.ID, locale)
+		if err != nil {
+			draftError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, preview)
+	}
+}
+
+// TestDraftHandler sends a draft to a single device. The provider defaults to
+// the one of the device's subscription to the draft's topic.
+func TestDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := draftParam(c, h)
+		if d == nil {
+			return
+		}
+		var req struct {
+			Token    string `json:"token" binding:"required"`
+			Provider string `json:"provider"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required field (token)")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		if err := h.TestDraft(ctx, d.ID, req.Token, req.Provider); err != nil {
+			draftError(c, err)
+			return
+		}
+
+		audit(c, h, "draft.test", strconv.FormatInt(d.ID, 10), map[string]string{
+			"topic":    d.Topic,
+			"token":    req.Token,
+			"provider": req.Provider,
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Test sent"})
+	}
+}
+
+// PublishDraftHandler publishes a draft to its topic, once, and responds like
+// a send.
+func PublishDraftHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := draftParam(c, h)
+		if d == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		res, err := h.PublishDraft(ctx, d.ID, hub.Message{
+			Publisher: middleware.GetUsername(c),
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			LargeSend: middleware.Can(c, rbac.LargeSend, d.Topic),
+		})
+		var held *hub.PendingApprovalError
+		if err != nil && !errors.As(err, &held) {
+			draftError(c, err)
+			return
+		}
+
+		details := map[string]string{"topic": d.Topic}
+		if held != nil {
+			details["message_id"] = strconv.FormatInt(held.MessageID, 10)
+		} else {
+			details["message_id"] = strconv.FormatInt(res.MessageID, 10)
+		}
+		audit(c, h, "draft.publish", strconv.FormatInt(d.ID, 10), details)
+		if held != nil {
+			sendError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Message sent",
+			"message_id": res.MessageID,
+			"enqueued":   res.Enqueued,
+			"status_url": statusURL(res.MessageID),
+		})
+	}
+}
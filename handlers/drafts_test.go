@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"no-spam/connectors"
+	"no-spam/hub"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// draftRouter serves the draft endpoints to alice with perms.
+func draftRouter(h *hub.Hub, perms ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	auth := r.Group("/", func(c *gin.Context) {
+		c.Set("username", "alice")
+		c.Set("permissions", perms)
+	})
+	auth.POST("/drafts", CreateDraftHandler(h))
+	auth.GET("/drafts", ListDraftsHandler(h))
+	auth.GET("/drafts/:id", GetDraftHandler(h))
+	auth.PUT("/drafts/:id", UpdateDraftHandler(h))
+	auth.DELETE("/drafts/:id", DeleteDraftHandler(h))
+	auth.GET("/drafts/:id/preview", PreviewDraftHandler(h))
+	auth.POST("/drafts/:id/test", TestDraftHandler(h))
+	auth.POST("/drafts/:id/publish", PublishDraftHandler(h))
+	return r
+}
+
+func TestDraftHandlers(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	h.RegisterConnector("mock", connectors.NewMockConnector())
+	h.RegisterConnector("apns", connectors.NewAPNSConnector())
+	s.CreateTopic("news")
+	s.CreateTopic("alerts")
+	s.AddSubscription("news", "device-1", "mock", "bob")
+	r := draftRouter(h, "publish:news")
+
+	w := scheduleRequest(r, "POST", "/drafts", map[string]any{"topic": "alerts", "payload": map[string]string{"title": "Hi"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another topic, got %d", w.Code)
+	}
+	w = scheduleRequest(r, "POST", "/drafts", map[string]any{"topic": "news", "template": "nope"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown template, got %d", w.Code)
+	}
+
+	w = scheduleRequest(r, "POST", "/drafts", map[string]any{
+		"topic": "news", "payload": map[string]any{"notification": map[string]string{"title": "Launch"}},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var d store.Draft
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if d.Topic != "news" || d.CreatedBy != "alice" || d.Status != store.DraftOpen {
+		t.Errorf("Unexpected draft %+v", d)
+	}
+	path := fmt.Sprintf("/drafts/%d", d.ID)
+
+	// Drafts of topics the user can't publish to stay hidden
+	other, _ := s.CreateDraft(store.Draft{Topic: "alerts", Request: json.RawMessage(`{}`)})
+	var listed []store.Draft
+	w = scheduleRequest(r, "GET", "/drafts", nil)
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if w.Code != http.StatusOK || len(listed) != 1 || listed[0].ID != d.ID {
+		t.Errorf("Expected only the news draft, got %d %s", w.Code, w.Body.String())
+	}
+	if w := scheduleRequest(r, "GET", fmt.Sprintf("/drafts/%d/preview", other), nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a draft of another topic, got %d", w.Code)
+	}
+
+	w = scheduleRequest(r, "PUT", path, map[string]any{"payload": map[string]any{"notification": map[string]string{"title": "Launch day"}}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 updating, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var preview hub.DraftPreview
+	w = scheduleRequest(r, "GET", path+"/preview", nil)
+	json.Unmarshal(w.Body.Bytes(), &preview)
+	if w.Code != http.StatusOK || preview.Audience != 1 || !strings.Contains(string(preview.Providers["apns"]), "Launch day") {
+		t.Errorf("Unexpected preview %d %s", w.Code, w.Body.String())
+	}
+	if w := scheduleRequest(r, "GET", path+"/preview?locale=fr%20fr", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid locale, got %d", w.Code)
+	}
+
+	if w := scheduleRequest(r, "POST", path+"/test", map[string]string{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a token, got %d", w.Code)
+	}
+	if w := scheduleRequest(r, "POST", path+"/test", map[string]string{"token": "device-9"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsubscribed token without a provider, got %d", w.Code)
+	}
+	if w := scheduleRequest(r, "POST", path+"/test", map[string]string{"token": "device-1"}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a test send, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = scheduleRequest(r, "POST", path+"/publish", nil)
+	var sent struct {
+		MessageID int64 `json:"message_id"`
+		Enqueued  int   `json:"enqueued"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &sent)
+	if w.Code != http.StatusOK || sent.MessageID == 0 || sent.Enqueued != 1 {
+		t.Fatalf("Expected the draft published, got %d %s", w.Code, w.Body.String())
+	}
+	if w := scheduleRequest(r, "POST", path+"/publish", nil); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 publishing twice, got %d", w.Code)
+	}
+	if w := scheduleRequest(r, "PUT", path, map[string]any{"payload": map[string]string{}}); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 updating a published draft, got %d", w.Code)
+	}
+	w = scheduleRequest(r, "GET", path, nil)
+	json.Unmarshal(w.Body.Bytes(), &d)
+	if d.Status != store.DraftPublished || d.MessageID != sent.MessageID {
+		t.Errorf("Unexpected published draft %+v", d)
+	}
+
+	if w := scheduleRequest(r, "DELETE", path, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if w := scheduleRequest(r, "GET", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after deleting, got %d", w.Code)
+	}
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "draft.*"})
+	if len(events) != 3 {
+		t.Errorf("Expected the test, publish and delete audited, got %+v", events)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"no-spam/apierror"
+	"no-spam/events"
+	"no-spam/rbac"
+	"no-spam/sso"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcCookie carries the state and nonce of a login in progress.
+const oidcCookie = "no_spam_oidc"
+
+// oidcCookiePath covers the login and callback routes under /v1 and at
+// their legacy paths: the provider redirects to the configured callback,
+// whichever mount the login started at.
+const oidcCookiePath = "/"
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OIDCLoginHandler redirects to the identity provider.
+func OIDCLoginHandler(p *sso.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, err := randomHex(16)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+		nonce, err := randomHex(16)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     oidcCookie,
+			Value:    state + "." + nonce,
+			Path:     oidcCookiePath,
+			MaxAge:   int((10 * time.Minute).Seconds()),
+			HttpOnly: true,
+			Secure:   c.Request.TLS != nil,
+			SameSite: http.SameSiteLaxMode, // Sent on the provider's redirect back
+		})
+		c.Redirect(http.StatusFound, p.AuthURL(state, nonce))
+	}
+}
+
+// OIDCCallbackHandler completes the login: it verifies the ID token, maps
+// claims to a role, provisions the user if allowed and issues a token.
+func OIDCCallbackHandler(s store.Store, p *sso.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := p.Config()
+
+		if e := c.Query("error"); e != "" {
+			apierror.Respond(c, http.StatusUnauthorized, "Identity provider error: "+e)
+			return
+		}
+		cookie, err := c.Cookie(oidcCookie)
+		state, nonce, ok := strings.Cut(cookie, ".")
+		if err != nil || !ok || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid or expired login state")
+			return
+		}
+		http.SetCookie(c.Writer, &http.Cookie{Name: oidcCookie, Path: oidcCookiePath, MaxAge: -1})
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		id, err := p.Exchange(ctx, c.Query("code"), nonce)
+		if err != nil {
+			auditAs(c, s, "", "login.failure", "", map[string]string{"method": "oidc", "reason": err.Error()})
+			apierror.Respond(c, http.StatusUnauthorized, "OIDC login failed")
+			return
+		}
+
+		role, err := provisionOIDCUser(s, cfg, id)
+		if err != nil {
+			auditAs(c, s, id.Username, "login.failure", id.Username, map[string]string{"method": "oidc", "reason": err.Error()})
+			if _, ok := err.(oidcError); ok {
+				apierror.Respond(c, http.StatusForbidden, err.Error())
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to provision user")
+			return
+		}
+
+		issued, err := issueToken(c, s, id.Username, role, "oidc", 0)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+
+		token := issued.Token
+		auditAs(c, s, id.Username, "login.success", id.Username, map[string]string{"method": "oidc", "role": role})
+		if cfg.PostLoginRedirect != "" {
+			c.Redirect(http.StatusFound, cfg.PostLoginRedirect+"#token="+url.QueryEscape(token))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "username": id.Username, "role": role})
+	}
+}
+
+// oidcError is a login refusal whose message is safe to return to the client.
+type oidcError string
+
+func (e oidcError) Error() string { return string(e) }
+
+// oidcPasswordHash is the password hash of users created through OIDC. It
+// is not a valid hash, so they can't log in with a password.
+const oidcPasswordHash = "!"
+
+// provisionOIDCUser returns the role to log the user in with. Only users
+// linked to the same provider account, or created through OIDC before
+// accounts were linked, log in; local accounts with the same name don't. A
+// matching role mapping is authoritative and updates the stored role;
+// otherwise existing users keep theirs and new users get the default role.
+func provisionOIDCUser(s store.Store, cfg sso.Config, id *sso.Identity) (string, error) {
+	if id.Role != "" {
+		if _, err := rbac.Permissions(s, id.Role); err != nil {
+			return "", oidcError("Mapped role " + id.Role + " does not exist")
+		}
+	}
+
+	user, err := s.GetUser(id.Username)
+	if err != nil {
+		return "", err
+	}
+
+	if user == nil {
+		if !cfg.AutoProvision {
+			return "", oidcError("No local account for " + id.Username)
+		}
+		role := id.Role
+		if role == "" {
+			role = cfg.DefaultRole
+		}
+		if role == "" {
+			return "", oidcError("No role mapping matched")
+		}
+		if err := s.CreateUser(id.Username, oidcPasswordHash, role); err != nil {
+			return "", err
+		}
+		if _, err := s.LinkUser(id.Username, id.ExternalID); err != nil {
+			return "", err
+		}
+		events.Emit(events.UserCreated, id.Username, map[string]any{"username": id.Username, "role": role, "method": "oidc"})
+		return role, nil
+	}
+
+	if user.ExternalID == "" && user.PasswordHash == oidcPasswordHash {
+		// Created through OIDC before accounts were linked: link it on first login
+		if _, err := s.LinkUser(user.Username, id.ExternalID); err != nil {
+			return "", err
+		}
+		if user, err = s.GetUser(id.Username); err != nil {
+			return "", err
+		}
+		if user == nil {
+			return "", oidcError("No local account for " + id.Username)
+		}
+	}
+	if user.ExternalID != id.ExternalID {
+		return "", oidcError("Account " + id.Username + " is not linked to this identity provider account")
+	}
+	if user.Disabled {
+		return "", oidcError(errUserDisabled.Error())
+	}
+	if id.Role != "" && id.Role != user.Role {
+		if err := s.UpdateUserRole(user.Username, id.Role); err != nil {
+			if errors.Is(err, store.ErrLastAdmin) {
+				return "", oidcError("Mapped role " + id.Role + " would demote the last admin")
+			}
+			return "", err
+		}
+		return id.Role, nil
+	}
+	return user.Role, nil
+}
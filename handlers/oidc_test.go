@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"no-spam/sso"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer is a minimal OIDC provider issuing ID tokens with the given claims.
+func fakeIssuer(t *testing.T, claims func(nonce string) jwt.MapClaims) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce string
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                srv.URL,
+			"authorization_endpoint":                srv.URL + "/auth",
+			"token_endpoint":                        srv.URL + "/token",
+			"jwks_uri":                              srv.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		nonce = r.URL.Query().Get("nonce")
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		c := claims(nonce)
+		c["iss"] = srv.URL
+		c["aud"] = "no-spam"
+		c["exp"] = time.Now().Add(time.Hour).Unix()
+		c["iat"] = time.Now().Unix()
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+		tok.Header["kid"] = "k1"
+		signed, _ := tok.SignedString(key)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "x", "token_type": "Bearer", "id_token": signed})
+	})
+	return srv
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := setupTestStore(t)
+	verified := false
+	issuer := fakeIssuer(t, func(nonce string) jwt.MapClaims {
+		return jwt.MapClaims{"sub": "1", "nonce": nonce, "email": "alice@example.com", "email_verified": verified, "groups": []string{"ops"}}
+	})
+
+	p, err := sso.NewProvider(context.Background(), sso.Config{
+		Issuer:        issuer.URL,
+		ClientID:      "no-spam",
+		RedirectURL:   "https://no-spam.example.com/admin/login/oidc/callback",
+		RoleMappings:  []sso.RoleMapping{{Claim: "groups", Value: "ops", Role: "publisher"}},
+		AutoProvision: true,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider failed: %v", err)
+	}
+
+	// Start the login
+	c, w := setupTestContext()
+	c.Request = httptest.NewRequest("GET", "/admin/login/oidc", nil)
+	OIDCLoginHandler(p)(c)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected redirect, got %d", w.Code)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	cookie := w.Result().Cookies()[0]
+	if cookie.Path != "/" {
+		t.Errorf("Expected the login cookie sent to the callback under /v1 too, got path %q", cookie.Path)
+	}
+	http.Get(issuer.URL + "/auth?" + loc.RawQuery) // The user authenticates at the provider
+
+	callback := func(state string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", "/admin/login/oidc/callback?code=abc&state="+state, nil)
+		c.Request.AddCookie(cookie)
+		OIDCCallbackHandler(s, p)(c)
+		return w
+	}
+
+	if w := callback("forged"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for state mismatch, got %d", w.Code)
+	}
+	if w := callback(loc.Query().Get("state")); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unverified email, got %d", w.Code)
+	}
+	if user, _ := s.GetUser("alice@example.com"); user != nil {
+		t.Errorf("Expected no user provisioned for an unverified email, got %+v", user)
+	}
+
+	verified = true
+	w = callback(loc.Query().Get("state"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	user, _ := s.GetUser("alice@example.com")
+	if user == nil || user.Role != "publisher" || user.ExternalID != issuer.URL+" 1" {
+		t.Fatalf("Expected provisioned publisher linked to the provider account, got %+v", user)
+	}
+}
+
+func TestProvisionOIDCUser(t *testing.T) {
+	s := setupTestStore(t)
+	cfg := sso.Config{}
+	carol := &sso.Identity{Username: "carol", ExternalID: "https://idp.example.com 7"}
+
+	// Unknown users are rejected without auto-provisioning
+	if _, err := provisionOIDCUser(s, cfg, &sso.Identity{Username: "bob"}); err == nil {
+		t.Error("Expected error without auto_provision")
+	}
+	cfg.AutoProvision, cfg.DefaultRole = true, "publisher"
+	if role, err := provisionOIDCUser(s, cfg, carol); err != nil || role != "publisher" {
+		t.Fatalf("Expected carol provisioned as publisher, got %q (%v)", role, err)
+	}
+	// Existing users keep their role when no mapping matches
+	if role, err := provisionOIDCUser(s, cfg, carol); err != nil || role != "publisher" {
+		t.Errorf("Expected publisher, got %q (%v)", role, err)
+	}
+	// A mapping updates the stored role
+	if role, _ := provisionOIDCUser(s, cfg, &sso.Identity{Username: "carol", ExternalID: carol.ExternalID, Role: "admin"}); role != "admin" {
+		t.Errorf("Expected admin, got %q", role)
+	}
+	if user, _ := s.GetUser("carol"); user.Role != "admin" {
+		t.Errorf("Expected stored role admin, got %s", user.Role)
+	}
+	if _, err := provisionOIDCUser(s, cfg, &sso.Identity{Username: "carol", ExternalID: carol.ExternalID, Role: "ghost"}); err == nil {
+		t.Error("Expected error for unknown mapped role")
+	}
+
+	// Another provider account with the same username is refused
+	if _, err := provisionOIDCUser(s, cfg, &sso.Identity{Username: "carol", ExternalID: "https://idp.example.com 8"}); err == nil {
+		t.Error("Expected another provider account to be refused")
+	}
+
+	// A local account whose name collides is never logged in, nor its role changed
+	if _, err := provisionOIDCUser(s, cfg, &sso.Identity{Username: "testadmin", ExternalID: "https://idp.example.com 9", Role: "publisher"}); err == nil {
+		t.Error("Expected a local account with the same name to be refused")
+	} else if _, ok := err.(oidcError); !ok {
+		t.Errorf("Expected an oidcError, got %v", err)
+	}
+	if user, _ := s.GetUser("testadmin"); user.Role != "admin" || user.ExternalID != "" {
+		t.Errorf("Expected the local account unchanged, got %+v", user)
+	}
+
+	// Users created through OIDC before accounts were linked are linked on first login
+	_ = s.CreateUser("dave", oidcPasswordHash, "subscriber")
+	if role, err := provisionOIDCUser(s, cfg, &sso.Identity{Username: "dave", ExternalID: "https://idp.example.com 10"}); err != nil || role != "subscriber" {
+		t.Errorf("Expected dave logged in, got %q (%v)", role, err)
+	}
+	if user, _ := s.GetUser("dave"); user.ExternalID != "https://idp.example.com 10" {
+		t.Errorf("Expected dave linked, got %+v", user)
+	}
+}
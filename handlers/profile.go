@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"no-spam/anomaly"
+	"no-spam/apierror"
+	"no-spam/hub"
+	"no-spam/middleware"
+	"no-spam/rbac"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDisplayName caps the length of a display name, in characters.
+const maxDisplayName = 100
+
+// Profile describes the authenticated user.
+type Profile struct {
+	Username       string           `json:"username"`
+	Role           string           `json:"role"`
+	Permissions    []string         `json:"permissions"`
+	DisplayName    string           `json:"display_name"`
+	Email          string           `json:"email"`
+	TokenExpiresAt *time.Time       `json:"token_expires_at,omitempty"` // Unset for client certificates
+	Subscriptions  int              `json:"subscriptions"`
+	Unread         int              `json:"unread"`
+	Quota          []anomaly.Status `json:"quota"` // Publish rate per topic against the burst detector, when enabled
+}
+
+// GetProfileHandler returns the authenticated user's profile, so clients
+// don't have to decode their token.
+func GetProfileHandler(h *hub.Hub, s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c, s)
+		if !ok {
+			return
+		}
+		perms, err := rbac.Permissions(s, user.Role)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to resolve permissions")
+			return
+		}
+		subs, err := h.GetSubscriptionsByUser(user.Username)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to count subscriptions")
+			return
+		}
+
+		p := Profile{
+			Username:      user.Username,
+			Role:          user.Role,
+			Permissions:   perms,
+			DisplayName:   user.DisplayName,
+			Email:         user.Email,
+			Subscriptions: len(subs),
+			Unread:        user.Unread,
+			Quota:         []anomaly.Status{},
+		}
+		if exp := middleware.GetTokenExpiry(c); !exp.IsZero() {
+			p.TokenExpiresAt = &exp
+		}
+		if d := h.AnomalyDetector(); d != nil {
+			for _, st := range d.Snapshot() {
+				if st.Publisher == user.Username {
+					p.Quota = append(p.Quota, st)
+				}
+			}
+		}
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// UpdateProfileHandler changes the authenticated user's display name and
+// contact email, and sets the unread counter, e.g. to 0 once the app has
+// been opened. Omitted fields are kept; "" clears them.
+func UpdateProfileHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			DisplayName *string `json:"display_name"`
+			Email       *string `json:"email"`
+			Unread      *int    `json:"unread"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		user, ok := currentUser(c, s)
+		if !ok {
+			return
+		}
+
+		if err := applyProfile(user, req.DisplayName, req.Email); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Unread != nil && *req.Unread < 0 {
+			apierror.Respond(c, http.StatusBadRequest, "Unread count must not be negative")
+			return
+		}
+
+		if err := s.SetUserProfile(user.Username, user.DisplayName, user.Email); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				apierror.Respond(c, http.StatusNotFound, "User not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+		if req.Unread != nil {
+			if err := s.SetUnread(user.Username, *req.Unread); err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to update unread count")
+				return
+			}
+			user.Unread = *req.Unread
+		}
+		c.JSON(http.StatusOK, gin.H{"username": user.Username, "display_name": user.DisplayName, "email": user.Email, "unread": user.Unread})
+	}
+}
+
+// applyProfile validates the given profile fields and sets them on user.
+// nil fields are kept.
+func applyProfile(user *store.User, displayName, email *string) error {
+	if displayName != nil {
+		name := strings.TrimSpace(*displayName)
+		if utf8.RuneCountInString(name) > maxDisplayName {
+			return errors.New("Display name too long")
+		}
+		user.DisplayName = name
+	}
+	if email != nil {
+		addr := strings.TrimSpace(*email)
+		if addr != "" {
+			parsed, err := mail.ParseAddress(addr)
+			if err != nil || parsed.Address != addr {
+				return errors.New("Invalid email address")
+			}
+		}
+		user.Email = addr
+	}
+	return nil
+}
+
+// currentUser loads the authenticated user, responding and returning false
+// on failure.
+func currentUser(c *gin.Context, s store.Store) (*store.User, bool) {
+	username := middleware.GetUsername(c)
+	if username == "" {
+		apierror.Respond(c, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	user, err := s.GetUser(username)
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "Failed to load user")
+		return nil, false
+	}
+	if user == nil {
+		apierror.Respond(c, http.StatusNotFound, "User not found")
+		return nil, false
+	}
+	return user, true
+}
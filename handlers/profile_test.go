@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"no-spam/anomaly"
+)
+
+func TestProfileHandlers(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	s.CreateUser("alice", "hash", "publisher")
+	s.CreateTopic("news")
+	s.AddSubscription("news", "tok", "webhook", "alice")
+	d := anomaly.NewDetector(anomaly.Config{})
+	d.Allow("alice", "news")
+	d.Allow("bob", "news")
+	h.SetAnomalyDetector(d, "")
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	get := func() Profile {
+		c, w := setupTestContext()
+		c.Set("username", "alice")
+		c.Set("token_expires_at", expires)
+		c.Request = httptest.NewRequest("GET", "/me", nil)
+		GetProfileHandler(h, s)(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var p Profile
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return p
+	}
+	patch := func(body string) int {
+		c, w := setupTestContext()
+		c.Set("username", "alice")
+		c.Request = httptest.NewRequest("PATCH", "/me", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		UpdateProfileHandler(s)(c)
+		return w.Code
+	}
+
+	p := get()
+	if p.Username != "alice" || p.Role != "publisher" || len(p.Permissions) == 0 || p.Subscriptions != 1 {
+		t.Errorf("Unexpected profile %+v", p)
+	}
+	if p.TokenExpiresAt == nil || !p.TokenExpiresAt.Equal(expires) {
+		t.Errorf("Expected the token expiry %v, got %v", expires, p.TokenExpiresAt)
+	}
+	if len(p.Quota) != 1 || p.Quota[0].Topic != "news" || p.Quota[0].Count != 1 {
+		t.Errorf("Expected only alice's send rate, got %+v", p.Quota)
+	}
+
+	if code := patch(`{"display_name": " Alice ", "email": "alice@example.com"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if code := patch(`{"email": "Alice <alice@example.com>"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-bare address, got %d", code)
+	}
+	if code := patch(`{"display_name": "` + strings.Repeat("a", maxDisplayName+1) + `"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a long display name, got %d", code)
+	}
+	if p := get(); p.DisplayName != "Alice" || p.Email != "alice@example.com" {
+		t.Errorf("Expected the updated profile, got %+v", p)
+	}
+
+	if code := patch(`{"email": ""}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if p := get(); p.DisplayName != "Alice" || p.Email != "" {
+		t.Errorf("Expected only the email cleared, got %+v", p)
+	}
+
+	s.IncrementUnread([]string{"alice"})
+	if p := get(); p.Unread != 1 {
+		t.Errorf("Expected 1 unread, got %d", p.Unread)
+	}
+	if code := patch(`{"unread": -1}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative unread count, got %d", code)
+	}
+	if code := patch(`{"unread": 0}`); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if p := get(); p.Unread != 0 || p.DisplayName != "Alice" {
+		t.Errorf("Expected the unread count reset, got %+v", p)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"no-spam/apierror"
+	"no-spam/connectors"
+	"no-spam/hub"
+	"no-spam/store"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed public
+var publicFiles embed.FS
+
+var topicPage = template.Must(template.ParseFS(publicFiles, "public/topic.html"))
+
+// maxPushSubscriptionSize bounds the push subscription a browser posts.
+const maxPushSubscriptionSize = 4096
+
+// PublicPageConfig configures the public topic pages.
+type PublicPageConfig struct {
+	PublicURL string // Base URL of the server; taken from the request without it
+	AppLink   string // Deep link into the mobile app, with {topic} replaced by the escaped topic name
+	Subscribe bool   // Browsers may subscribe without an account, through PublicSubscribeHandler
+}
+
+// appLink returns the deep link to a topic, or "".
+func (cfg PublicPageConfig) appLink(topic string) string {
+	if cfg.AppLink == "" {
+		return ""
+	}
+	return strings.ReplaceAll(cfg.AppLink, "{topic}", url.QueryEscape(topic))
+}
+
+// pageURL returns the absolute URL of a topic's page.
+func (cfg PublicPageConfig) pageURL(c *gin.Context, topic string) string {
+	base := strings.TrimSuffix(cfg.PublicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return base + "/v1/t/" + url.PathEscape(topic)
+}
+
+// publicTopic looks up a public topic, writing the error response if it
+// isn't one.
+func publicTopic(c *gin.Context, h *hub.Hub) (*store.TopicInfo, bool) {
+	info, err := h.PublicTopic(c.Param("name"))
+	if errors.Is(err, hub.ErrTopicNotFound) {
+		apierror.Respond(c, http.StatusNotFound, "Topic not found")
+		return nil, false
+	}
+	if err != nil {
+		apierror.Respond(c, http.StatusInternalServerError, "Failed to get topic")
+		return nil, false
+	}
+	return info, true
+}
+
+// PublicTopicPageHandler serves the subscription page of a public topic:
+// a button subscribing the browser through web push, when configured, and
+// a deep link and QR code for the mobile app.
+func PublicTopicPageHandler(h *hub.Hub, cfg PublicPageConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, ok := publicTopic(c, h)
+		if !ok {
+			return
+		}
+		webPushKey := ""
+		if cfg.Subscribe {
+			webPushKey = h.WebPushKey()
+		}
+		// Relative to the page, so they work behind a path prefix too
+		escaped := url.PathEscape(info.Name)
+		var page bytes.Buffer
+		err := topicPage.Execute(&page, map[string]any{
+			"Topic":         info,
+			"WebPushKey":    webPushKey,
+			"AppLink":       template.URL(cfg.appLink(info.Name)),
+			"SubscribePath": escaped + "/subscribe",
+			"QRPath":        escaped + "/qr.png",
+		})
+		if err != nil {
+			log.Printf("Public page error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to render page")
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+	}
+}
+
+// PublicTopicQRHandler serves a QR code of the app deep link to a public
+// topic, or of its page without one.
+func PublicTopicQRHandler(h *hub.Hub, cfg PublicPageConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, ok := publicTopic(c, h)
+		if !ok {
+			return
+		}
+		target := cfg.appLink(info.Name)
+		if target == "" {
+			target = cfg.pageURL(c, info.Name)
+		}
+		code, err := qr.Encode(target, qr.M, qr.Auto)
+		if err == nil {
+			code, err = barcode.Scale(code, 256, 256)
+		}
+		var img bytes.Buffer
+		if err == nil {
+			err = png.Encode(&img, code)
+		}
+		if err != nil {
+			log.Printf("QR code error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to render QR code")
+			return
+		}
+		c.Data(http.StatusOK, "image/png", img.Bytes())
+	}
+}
+
+// PublicSubscribeHandler subscribes a browser to a public topic without an
+// account. The body is the browser's push subscription. Anyone can call it,
+// so it is only routed when enabled, behind a per-IP rate limit.
+func PublicSubscribeHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPushSubscriptionSize+1))
+		if err != nil || len(body) > maxPushSubscriptionSize {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid push subscription")
+			return
+		}
+		if _, err := connectors.ParseWebPushSubscription(string(body)); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Store the subscription compactly; it is the delivery token
+		var token bytes.Buffer
+		if err := json.Compact(&token, body); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid push subscription")
+			return
+		}
+
+		topic, err := h.SubscribePublic(c.Param("name"), token.String())
+		switch {
+		case err == nil:
+			c.JSON(http.StatusOK, subscribed(h, "Subscribed", topic, token.String()))
+		case errors.Is(err, hub.ErrTopicNotFound):
+			apierror.Respond(c, http.StatusNotFound, "Topic not found")
+		case errors.Is(err, hub.ErrWebPushDisabled):
+			apierror.Respond(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, hub.ErrPublicSubscriberLimit):
+			apierror.Respond(c, http.StatusForbidden, err.Error())
+		case errors.Is(err, connectors.ErrTargetNotAllowed), errors.Is(err, connectors.ErrTargetUnresolved):
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, store.ErrDuplicate):
+			// Anyone can post a known subscription, so it gets no unsubscribe link
+			c.JSON(http.StatusOK, gin.H{"message": "Already subscribed"})
+		default:
+			log.Printf("Public subscribe error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to subscribe")
+		}
+	}
+}
+
+// PublicServiceWorkerHandler serves the service worker that shows web push
+// notifications in browsers subscribed from a public topic page.
+func PublicServiceWorkerHandler() gin.HandlerFunc {
+	sw, _ := publicFiles.ReadFile("public/sw.js")
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/javascript; charset=utf-8", sw)
+	}
+}
@@ -0,0 +1,25 @@
+// Service worker of public topic pages: shows pushed notifications.
+self.addEventListener("push", event => {
+  let message = {};
+  try {
+    message = event.data.json();
+  } catch (err) {
+    message = {payload: {body: event.data ? event.data.text() : ""}};
+  }
+  const payload = message.payload || {};
+  const n = payload.notification || {};
+  event.waitUntil(self.registration.showNotification(n.title || message.topic || "New notification", {
+    body: n.body || payload.body || payload.message || "",
+    icon: n.icon,
+    image: n.image,
+    data: {url: n.url},
+  }));
+});
+
+self.addEventListener("notificationclick", event => {
+  event.notification.close();
+  const url = event.notification.data && event.notification.data.url;
+  if (url) {
+    event.waitUntil(clients.openWindow(url));
+  }
+});
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"no-spam/connectors"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPublicTopicPages(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	h.SetUnsubscribeLinks([]byte("secret"), "")
+	_ = s.CreateTopic("private")
+	_ = s.CreateTopic("news")
+	_ = s.SetTopicInfo(store.TopicInfo{Name: "news", Description: "Release notes", Public: true})
+
+	cfg := PublicPageConfig{PublicURL: "https://push.example.com", AppLink: "myapp://subscribe?topic={topic}", Subscribe: true}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/t/sw.js", PublicServiceWorkerHandler())
+	router.GET("/t/:name", PublicTopicPageHandler(h, cfg))
+	router.GET("/t/:name/qr.png", PublicTopicQRHandler(h, cfg))
+	router.POST("/t/:name/subscribe", PublicSubscribeHandler(h))
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+		return w
+	}
+
+	if w := request("GET", "/t/private", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a topic that isn't public, got %d", w.Code)
+	}
+	w := request("GET", "/t/news", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Release notes") || !strings.Contains(w.Body.String(), "myapp://subscribe?topic=news") {
+		t.Fatalf("Unexpected page %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "Subscribe in this browser") {
+		t.Error("Expected no browser subscription without web push")
+	}
+	if w := request("GET", "/t/news/qr.png", ""); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected a PNG QR code, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := request("GET", "/t/sw.js", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "showNotification") {
+		t.Errorf("Expected the service worker, got %d", w.Code)
+	}
+
+	browserKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	subscription := `{"endpoint": "https://push.example.com/abc", "keys": {"p256dh": "` +
+		base64.RawURLEncoding.EncodeToString(browserKey.PublicKey().Bytes()) + `", "auth": "AAAAAAAAAAAAAAAAAAAAAA"}}`
+	if w := request("POST", "/t/news/subscribe", subscription); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without web push, got %d", w.Code)
+	}
+
+	vapidKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	wp, err := connectors.NewWebPushConnector(map[string]string{
+		"vapid_private_key": base64.RawURLEncoding.EncodeToString(vapidKey.Bytes()),
+		"vapid_subject":     "mailto:ops@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.RegisterConnector("webpush", wp)
+
+	if w := request("GET", "/t/news", ""); !strings.Contains(w.Body.String(), wp.(*connectors.WebPushConnector).PublicKey()) {
+		t.Error("Expected the page to carry the VAPID key")
+	}
+	c, pageW := setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "news"}}
+	c.Request = httptest.NewRequest("GET", "/t/news", nil)
+	PublicTopicPageHandler(h, PublicPageConfig{})(c)
+	if strings.Contains(pageW.Body.String(), "Subscribe in this browser") {
+		t.Error("Expected no browser subscription unless public subscribe is enabled")
+	}
+	if w := request("POST", "/t/news/subscribe", `{"endpoint": "https://push.example.com/abc"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an incomplete subscription, got %d", w.Code)
+	}
+	if w := request("POST", "/t/private/subscribe", subscription); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 subscribing to a topic that isn't public, got %d", w.Code)
+	}
+	w = request("POST", "/t/news/subscribe", subscription)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		UnsubscribeURL string `json:"unsubscribe_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.UnsubscribeURL == "" {
+		t.Error("Expected an unsubscribe URL")
+	}
+	subs, _ := s.GetSubscribers("news")
+	if len(subs) != 1 || subs[0].Provider != "webpush" || subs[0].Platform != "web" {
+		t.Errorf("Expected one web push subscriber, got %+v", subs)
+	}
+	w = request("POST", "/t/news/subscribe", subscription)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected subscribing again to succeed, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "unsubscribe_url") {
+		t.Errorf("Expected no unsubscribe URL for an existing subscription, got %s", w.Body.String())
+	}
+
+	// Anonymous subscriptions are capped per topic
+	h.SetMaxPublicSubscribers(1)
+	otherKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	other := `{"endpoint": "https://push.example.com/def", "keys": {"p256dh": "` +
+		base64.RawURLEncoding.EncodeToString(otherKey.PublicKey().Bytes()) + `", "auth": "AAAAAAAAAAAAAAAAAAAAAA"}}`
+	if w := request("POST", "/t/news/subscribe", other); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 past the anonymous subscriber cap, got %d: %s", w.Code, w.Body.String())
+	}
+	if subs, _ := s.GetSubscribers("news"); len(subs) != 1 {
+		t.Errorf("Expected the cap to keep one subscriber, got %d", len(subs))
+	}
+}
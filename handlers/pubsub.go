@@ -2,13 +2,24 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"no-spam/anomaly"
+	"no-spam/apierror"
+	"no-spam/connectors"
+	"no-spam/events"
+	"no-spam/filter"
 	"no-spam/hub"
 	"no-spam/middleware"
+	"no-spam/notification"
+	"no-spam/rbac"
+	"no-spam/segment"
 	"no-spam/store"
 
 	"github.com/gin-gonic/gin"
@@ -17,14 +28,25 @@ import (
 func SubscribeHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Topic    string `json:"topic" binding:"required"`
-			Token    string `json:"token"`
-			Webhook  string `json:"webhook"`
-			Provider string `json:"provider" binding:"required"`
+			Topic    string                `json:"topic" binding:"required"`
+			Token    string                `json:"token"`
+			Webhook  string                `json:"webhook"`
+			Provider string                `json:"provider" binding:"required"`
+			Options  *store.WebhookOptions `json:"options"`
+			Locale   string                `json:"locale"`
+
+			// Device attributes for segment targeting
+			Platform   string   `json:"platform"`
+			AppVersion string   `json:"app_version"`
+			Tags       []string `json:"tags"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields (topic, provider)"})
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (topic, provider)")
+			return
+		}
+		if !middleware.Can(c, rbac.Subscribe, req.Topic) {
+			apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot subscribe to this topic")
 			return
 		}
 
@@ -35,37 +57,257 @@ func SubscribeHandler(h *hub.Hub) gin.HandlerFunc {
 
 		// Validate token
 		if req.Token == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token or webhook field"})
+			apierror.Respond(c, http.StatusBadRequest, "Missing token or webhook field")
 			return
 		}
 
+		if req.Options != nil {
+			if req.Provider != "webhook" {
+				apierror.Respond(c, http.StatusBadRequest, "Options are only supported for webhook subscriptions")
+				return
+			}
+			if err := validateWebhookOptions(req.Options); err != nil {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		if !validLocale(req.Locale) {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid locale")
+			return
+		}
+		if err := validateAttributes(req.Platform, req.AppVersion, req.Tags); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		hasAttributes := req.Platform != "" || req.AppVersion != "" || len(req.Tags) > 0
+
 		username := middleware.GetUsername(c)
 		if username == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No username in context"})
+			apierror.Respond(c, http.StatusUnauthorized, "No username in context")
 			return
 		}
 
 		if err := h.Subscribe(req.Topic, store.Subscriber{
-			Token:    req.Token,
-			Provider: req.Provider,
-			Username: username,
+			Token:      req.Token,
+			Provider:   req.Provider,
+			Username:   username,
+			Options:    req.Options,
+			Locale:     req.Locale,
+			Platform:   req.Platform,
+			AppVersion: req.AppVersion,
+			Tags:       req.Tags,
 		}); err != nil {
 			log.Printf("Subscribe error: %v", err)
 			if err == hub.ErrTopicNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+				apierror.Respond(c, http.StatusNotFound, "Topic not found")
+				return
+			}
+			if errors.Is(err, connectors.ErrTargetNotAllowed) || errors.Is(err, connectors.ErrTargetUnresolved) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
 				return
 			}
 			// Handle duplicate subscription (make it idempotent)
-			if strings.Contains(err.Error(), "UNIQUE constraint") {
-				c.JSON(http.StatusOK, gin.H{"message": "Already subscribed"})
+			if errors.Is(err, store.ErrDuplicate) {
+				// Only the owner may re-subscribe, which may update the subscription
+				topic, err := h.ResolveTopic(req.Topic)
+				if err != nil {
+					apierror.Respond(c, http.StatusInternalServerError, "Failed to update subscription")
+					return
+				}
+				if _, err := h.UserSubscription(username, topic, req.Token); err != nil {
+					if err == hub.ErrSubscriptionNotFound {
+						apierror.Respond(c, http.StatusForbidden, "Subscription belongs to another user")
+						return
+					}
+					apierror.Respond(c, http.StatusInternalServerError, "Failed to update subscription")
+					return
+				}
+				// Re-subscribing with options, a locale or attributes updates them
+				if req.Options != nil || req.Locale != "" || hasAttributes {
+					if req.Options != nil {
+						if err := h.UpdateSubscriptionOptions(topic, req.Token, req.Options); err != nil {
+							apierror.Respond(c, http.StatusInternalServerError, "Failed to update subscription options")
+							return
+						}
+					}
+					if req.Locale != "" {
+						if err := h.UpdateSubscriptionLocale(topic, req.Token, req.Locale); err != nil {
+							apierror.Respond(c, http.StatusInternalServerError, "Failed to update subscription locale")
+							return
+						}
+					}
+					if hasAttributes {
+						if err := h.UpdateSubscriptionAttributes(topic, req.Token, req.Platform, req.AppVersion, req.Tags); err != nil {
+							apierror.Respond(c, http.StatusInternalServerError, "Failed to update subscription attributes")
+							return
+						}
+					}
+					c.JSON(http.StatusOK, subscribed(h, "Subscription updated", topic, req.Token))
+					return
+				}
+				c.JSON(http.StatusOK, subscribed(h, "Already subscribed", topic, req.Token))
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, subscribed(h, "Subscribed", req.Topic, req.Token))
+	}
+}
+
+// subscribed is the response to a subscription, with its unsubscribe link
+// when links are enabled. Only send it to the subscription's owner.
+func subscribed(h *hub.Hub, message, topic, token string) gin.H {
+	resp := gin.H{"message": message}
+	if link := h.UnsubscribeURL(topic, token); link != "" {
+		resp["unsubscribe_url"] = link
+	}
+	return resp
+}
+
+// UnsubscribeLinkHandler removes the subscription named by a signed
+// unsubscribe link. It needs no login, so links work from emails.
+func UnsubscribeLinkHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topic, err := h.UnsubscribeWithLink(c.Query("sig"))
+		if err == hub.ErrInvalidUnsubscribeLink {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid unsubscribe link")
+			return
+		}
+		if err != nil {
+			log.Printf("Unsubscribe link error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to unsubscribe")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed", "topic": topic})
+	}
+}
+
+// ImageHandler serves a notification image through the image proxy, for a
+// link signed by this server. It needs no login, as devices load images
+// without credentials.
+func ImageHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		proxy := h.ImageProxy()
+		if proxy == nil {
+			apierror.Respond(c, http.StatusNotFound, "Image proxy disabled")
 			return
 		}
+		rawURL := c.Query("url")
+		if !h.VerifyImageURL(rawURL, c.Query("sig")) {
+			apierror.Respond(c, http.StatusForbidden, "Invalid image link")
+			return
+		}
+		img, err := proxy.Fetch(c.Request.Context(), rawURL)
+		if err != nil {
+			log.Printf("Image proxy error for %s: %v", rawURL, err)
+			apierror.Respond(c, http.StatusBadGateway, "Failed to fetch image")
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Data(http.StatusOK, img.ContentType, img.Data)
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Subscribed"})
+// Limits for per-subscription webhook options
+const (
+	maxWebhookTimeoutMs  = 30000
+	maxWebhookRetries    = 5
+	maxWebhookHeaderSize = 8192
+)
+
+func validateWebhookOptions(opts *store.WebhookOptions) error {
+	switch opts.Method {
+	case "", "POST", "PUT", "PATCH":
+	default:
+		return fmt.Errorf("Invalid webhook method. Must be POST, PUT, or PATCH")
+	}
+	if !connectors.IsWebhookFormat(opts.Format) {
+		return fmt.Errorf("Invalid webhook format: %q", opts.Format)
+	}
+	switch opts.ContentEncoding {
+	case "", "gzip":
+	default:
+		return fmt.Errorf("Invalid webhook content_encoding. Must be gzip")
+	}
+	if opts.TimeoutMs < 0 || opts.TimeoutMs > maxWebhookTimeoutMs {
+		return fmt.Errorf("timeout_ms must be between 0 and %d", maxWebhookTimeoutMs)
+	}
+	if opts.MaxRetries < 0 || opts.MaxRetries > maxWebhookRetries {
+		return fmt.Errorf("max_retries must be between 0 and %d", maxWebhookRetries)
+	}
+	size := 0
+	for k, v := range opts.Headers {
+		if k == "" || strings.ContainsAny(k, " :\r\n") || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("Invalid webhook header: %q", k)
+		}
+		size += len(k) + len(v)
 	}
+	if size > maxWebhookHeaderSize {
+		return fmt.Errorf("Webhook headers too large")
+	}
+	return nil
+}
+
+// redactedHeader replaces webhook header values, which often carry
+// credentials, in responses and exports. Storage keeps the real values.
+const redactedHeader = "***"
+
+// redactSubscribers returns subs with their webhook header values redacted,
+// leaving subs itself untouched.
+func redactSubscribers(subs []store.Subscriber) []store.Subscriber {
+	out := make([]store.Subscriber, len(subs))
+	for i, sub := range subs {
+		out[i] = redactSubscriber(sub)
+	}
+	return out
+}
+
+func redactSubscriber(sub store.Subscriber) store.Subscriber {
+	if sub.Options == nil || len(sub.Options.Headers) == 0 {
+		return sub
+	}
+	opts := *sub.Options
+	opts.Headers = make(map[string]string, len(sub.Options.Headers))
+	for k := range sub.Options.Headers {
+		opts.Headers[k] = redactedHeader
+	}
+	sub.Options = &opts
+	return sub
+}
+
+// validLocale accepts BCP 47-like tags such as "en", "pt-BR" or "zh_Hant_TW".
+func validLocale(locale string) bool {
+	if len(locale) > 35 {
+		return false
+	}
+	for _, r := range locale {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// maxTagLength limits the size of a single subscription tag.
+const maxTagLength = 64
+
+func validateAttributes(platform, appVersion string, tags []string) error {
+	if len(platform) > 32 || len(appVersion) > 32 {
+		return fmt.Errorf("platform and app_version must be at most 32 characters")
+	}
+	if len(tags) > hub.MaxTags {
+		return fmt.Errorf("At most %d tags are allowed", hub.MaxTags)
+	}
+	for _, t := range tags {
+		if t == "" || len(t) > maxTagLength || strings.ContainsAny(t, " \t\r\n\"'()") {
+			return fmt.Errorf("Invalid tag: %q", t)
+		}
+	}
+	return nil
 }
 
 func UnsubscribeHandler(h *hub.Hub) gin.HandlerFunc {
@@ -76,13 +318,13 @@ func UnsubscribeHandler(h *hub.Hub) gin.HandlerFunc {
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields (topic, token)"})
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (topic, token)")
 			return
 		}
 
 		if err := h.Unsubscribe(req.Topic, req.Token); err != nil {
 			log.Printf("Unsubscribe error: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
 			return
 		}
 
@@ -90,56 +332,527 @@ func UnsubscribeHandler(h *hub.Hub) gin.HandlerFunc {
 	}
 }
 
+// ReceiptHandler records that a client app opened a notification it was
+// delivered, for the message's open rate.
+func ReceiptHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MessageID int64  `json:"message_id" binding:"required"`
+			Token     string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (message_id, token)")
+			return
+		}
+
+		if err := h.RecordOpen(req.MessageID, req.Token); err != nil {
+			if err == hub.ErrDeliveryNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Delivery not found")
+				return
+			}
+			log.Printf("Receipt error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to record receipt")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Receipt recorded"})
+	}
+}
+
+// UpdateTagsHandler adds (POST) or removes (DELETE) tags on one of the caller's subscriptions.
+func UpdateTagsHandler(h *hub.Hub, remove bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Topic string   `json:"topic" binding:"required"`
+			Token string   `json:"token" binding:"required"`
+			Tags  []string `json:"tags" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (topic, token, tags)")
+			return
+		}
+		if err := validateAttributes("", "", req.Tags); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		username := middleware.GetUsername(c)
+		if username == "" {
+			apierror.Respond(c, http.StatusUnauthorized, "No username in context")
+			return
+		}
+
+		var add, del []string
+		if remove {
+			del = req.Tags
+		} else {
+			add = req.Tags
+		}
+		tags, err := h.UpdateTags(username, req.Topic, req.Token, add, del)
+		if err != nil {
+			if err == hub.ErrSubscriptionNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Subscription not found")
+				return
+			}
+			if err == hub.ErrTooManyTags {
+				apierror.Respond(c, http.StatusBadRequest, fmt.Sprintf("At most %d tags are allowed", hub.MaxTags))
+				return
+			}
+			log.Printf("UpdateTags error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update tags")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	}
+}
+
+// UpdatePreferencesHandler changes the preferences of one of the caller's
+// subscriptions: muted_until (RFC 3339, "" unmutes), min_priority ("normal"
+// or "high"), max_per_day (0 is unlimited) and muted_categories. Omitted
+// fields are kept.
+func UpdatePreferencesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Topic       string  `json:"topic" binding:"required"`
+			Token       string  `json:"token" binding:"required"`
+			MutedUntil  *string `json:"muted_until"`
+			MinPriority *string `json:"min_priority"`
+			MaxPerDay   *int    `json:"max_per_day"`
+
+			MutedCategories *[]string `json:"muted_categories"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required fields (topic, token)")
+			return
+		}
+
+		username := middleware.GetUsername(c)
+		if username == "" {
+			apierror.Respond(c, http.StatusUnauthorized, "No username in context")
+			return
+		}
+
+		sub, err := h.UserSubscription(username, req.Topic, req.Token)
+		if err != nil {
+			if err == hub.ErrSubscriptionNotFound {
+				apierror.Respond(c, http.StatusNotFound, "Subscription not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update preferences")
+			return
+		}
+		var prefs store.Preferences
+		if sub.Preferences != nil {
+			prefs = *sub.Preferences
+		}
+		if req.MutedUntil != nil {
+			prefs.MutedUntil = nil
+			if *req.MutedUntil != "" {
+				t, err := time.Parse(time.RFC3339, *req.MutedUntil)
+				if err != nil {
+					apierror.Respond(c, http.StatusBadRequest, "Invalid muted_until, expected RFC 3339")
+					return
+				}
+				prefs.MutedUntil = &t
+			}
+		}
+		if req.MinPriority != nil {
+			prefs.MinPriority = *req.MinPriority
+		}
+		if req.MaxPerDay != nil {
+			prefs.MaxPerDay = *req.MaxPerDay
+		}
+		if req.MutedCategories != nil {
+			prefs.MutedCategories = *req.MutedCategories
+		}
+
+		if err := h.SetPreferences(req.Topic, req.Token, prefs); err != nil {
+			if errors.Is(err, hub.ErrInvalidPreferences) {
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			log.Printf("SetPreferences error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update preferences")
+			return
+		}
+		sub, err = h.UserSubscription(username, req.Topic, req.Token)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to update preferences")
+			return
+		}
+		if sub.Preferences == nil {
+			sub.Preferences = &store.Preferences{}
+		}
+		c.JSON(http.StatusOK, gin.H{"preferences": sub.Preferences})
+	}
+}
+
+// UnsubscribeByTagHandler removes every subscription of the caller carrying a tag.
+func UnsubscribeByTagHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Tag string `json:"tag" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing required field (tag)")
+			return
+		}
+
+		username := middleware.GetUsername(c)
+		if username == "" {
+			apierror.Respond(c, http.StatusUnauthorized, "No username in context")
+			return
+		}
+
+		n, err := h.UnsubscribeByTag(username, req.Tag)
+		if err != nil {
+			log.Printf("UnsubscribeByTag error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to unsubscribe")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed", "removed": n})
+	}
+}
+
 func TopicsHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username := middleware.GetUsername(c)
 		if username == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			apierror.Respond(c, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
 		subs, err := h.GetSubscriptionsByUser(username)
 		if err != nil {
 			log.Printf("GetSubscriptions error: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			apierror.Respond(c, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		c.JSON(http.StatusOK, subs)
+		c.JSON(http.StatusOK, redactSubscribers(subs))
 	}
 }
 
+// syncSendTimeout bounds a synchronous send, which waits for every delivery.
+const syncSendTimeout = 30 * time.Second
+
 func SendHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var msg hub.Message
 		if err := c.ShouldBindJSON(&msg); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			if middleware.IsBodyTooLarge(err) {
+				apierror.Respond(c, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		msg.Publisher = middleware.GetUsername(c)
+		msg.LargeSend = middleware.Can(c, rbac.LargeSend, msg.Topic)
+		msg.ClientIP = c.ClientIP()
+		msg.UserAgent = c.Request.UserAgent()
+		if !middleware.Can(c, rbac.Publish, msg.Topic) {
+			apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot publish to this topic")
+			return
+		}
+
+		if c.Query("sync") == "true" {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), syncSendTimeout)
+			defer cancel()
+			results, err := h.SendSync(ctx, msg)
+			if err != nil {
+				sendError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "Message sent", "results": results})
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 
-		if err := h.Route(ctx, msg); err != nil {
-			log.Printf("Error routing message: %v", err)
-			if err == hub.ErrTopicNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
-				return
+		res, err := h.Publish(ctx, msg)
+		if err != nil {
+			sendError(c, err)
+			return
+		}
+		if res == nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Message sent"})
+			return
+		}
+
+		// Deliveries continue in the background; status_url tracks them
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Message sent",
+			"message_id": res.MessageID,
+			"enqueued":   res.Enqueued,
+			"status_url": statusURL(res.MessageID),
+		})
+	}
+}
+
+// statusURL returns the /v1 path of a message's delivery report, which is
+// served with or without the legacy routes.
+func statusURL(messageID int64) string {
+	return "/v1/messages/" + strconv.FormatInt(messageID, 10)
+}
+
+// MessageStatusHandler reports the deliveries of a sent topic message.
+func MessageStatusHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid message id")
+			return
+		}
+
+		report, err := h.MessageStatus(id)
+		if err == hub.ErrMessageNotFound {
+			apierror.Respond(c, http.StatusNotFound, "Message not found")
+			return
+		}
+		if err != nil {
+			log.Printf("MessageStatus error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to get message status")
+			return
+		}
+		if !middleware.Can(c, rbac.Publish, report.Topic) {
+			apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot publish to this topic")
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// CreateUserTopicHandler lets a publisher create a topic in their own
+// namespace, e.g. alice/news, up to the per-user topic limit.
+func CreateUserTopicHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name          string `json:"name" binding:"required"`
+			Description   string `json:"description"`
+			ReplayCount   *int   `json:"replay_count"`
+			RetentionDays int    `json:"retention_days"`
+			Public        bool   `json:"public"`
+
+			MaxAttempts         int `json:"max_attempts"`
+			RetryBackoffSeconds int `json:"retry_backoff_seconds"`
+			TTLSeconds          int `json:"ttl_seconds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing topic name")
+			return
+		}
+
+		username := middleware.GetUsername(c)
+		info := store.TopicInfo{
+			Name:          req.Name,
+			Description:   req.Description,
+			ReplayCount:   req.ReplayCount,
+			RetentionDays: req.RetentionDays,
+			Public:        req.Public,
+
+			MaxAttempts:         req.MaxAttempts,
+			RetryBackoffSeconds: req.RetryBackoffSeconds,
+			TTLSeconds:          req.TTLSeconds,
+		}
+		if err := h.CreateUserTopic(username, info); err != nil {
+			var limit *hub.TopicLimitError
+			switch {
+			case errors.Is(err, hub.ErrSelfServiceDisabled):
+				apierror.Respond(c, http.StatusForbidden, err.Error())
+			case errors.As(err, &limit):
+				apierror.RespondCode(c, http.StatusForbidden, apierror.TopicLimitReached, "Topic limit reached", gin.H{"count": limit.Count, "limit": limit.Limit})
+			case errors.Is(err, hub.ErrInvalidTopicName), errors.Is(err, hub.ErrInvalidTopicInfo):
+				apierror.Respond(c, http.StatusBadRequest, err.Error())
+			case errors.Is(err, hub.ErrTopicExists) || errors.Is(err, store.ErrDuplicate):
+				apierror.Respond(c, http.StatusConflict, "Topic already exists")
+			default:
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to create topic")
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Message sent"})
+		audit(c, h, "topic.create", req.Name, nil)
+		events.Emit(events.TopicCreated, username, map[string]any{"topic": req.Name})
+		c.JSON(http.StatusCreated, gin.H{"message": "Topic created", "topic": req.Name})
 	}
 }
 
+// sendError responds to a failed send.
+func sendError(c *gin.Context, err error) {
+	var pending *hub.PendingApprovalError
+	if errors.As(err, &pending) {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":    "Message awaiting approval",
+			"message_id": pending.MessageID,
+			"audience":   pending.Audience,
+			"status_url": statusURL(pending.MessageID),
+		})
+		return
+	}
+	log.Printf("Error routing message: %v", err)
+	if err == hub.ErrTopicNotFound {
+		apierror.Respond(c, http.StatusNotFound, "Topic not found")
+		return
+	}
+	if err == anomaly.ErrThrottled || err == anomaly.ErrQuarantined {
+		apierror.Respond(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err == hub.ErrTemplateNotFound {
+		apierror.Respond(c, http.StatusNotFound, "Template not found")
+		return
+	}
+	if errors.Is(err, hub.ErrInvalidTemplate) || errors.Is(err, hub.ErrRejected) {
+		apierror.Respond(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if errors.Is(err, segment.ErrInvalid) || errors.Is(err, hub.ErrInvalidCallback) || err == hub.ErrSyncRequiresTopic {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, notification.ErrInvalid) {
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	var violation *filter.Violation
+	if errors.As(err, &violation) {
+		apierror.RespondCode(c, http.StatusUnprocessableEntity, apierror.ContentFiltered, "Message rejected by content filter", gin.H{
+			"rule":   violation.Rule.ID,
+			"reason": violation.Reason,
+		})
+		return
+	}
+	var tooLarge *hub.PayloadTooLargeError
+	if errors.As(err, &tooLarge) {
+		apierror.RespondCode(c, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge, "Payload too large", gin.H{
+			"size":  tooLarge.Size,
+			"limit": tooLarge.Limit,
+		})
+		return
+	}
+	var syncLimit *hub.SyncLimitError
+	if errors.As(err, &syncLimit) {
+		apierror.RespondCode(c, http.StatusUnprocessableEntity, apierror.SyncLimitExceeded, "Too many subscribers for a synchronous send", gin.H{
+			"audience": syncLimit.Audience,
+			"limit":    syncLimit.Limit,
+		})
+		return
+	}
+	var queueFull *hub.QueueFullError
+	if errors.As(err, &queueFull) {
+		c.Header("Retry-After", strconv.Itoa(int(queueFull.RetryAfter.Seconds())))
+		apierror.RespondCode(c, http.StatusServiceUnavailable, apierror.QueueFull, "Delivery queue is full", gin.H{
+			"topic": queueFull.Topic,
+			"depth": queueFull.Depth,
+			"limit": queueFull.Limit,
+		})
+		return
+	}
+	var schemaErr *hub.SchemaError
+	if errors.As(err, &schemaErr) {
+		apierror.RespondCode(c, http.StatusUnprocessableEntity, apierror.SchemaMismatch, "Payload does not match topic schema", gin.H{
+			"details": schemaErr.Errors,
+		})
+		return
+	}
+	apierror.Respond(c, http.StatusInternalServerError, err.Error())
+}
+
+// statsOpenRates is how many of the latest messages /stats reports open rates for.
+const statsOpenRates = 20
+
 func StatsHandler(h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		stats := gin.H{
 			"total_messages_sent":  h.GetTotalMessagesSent(),
 			"active_subscriptions": h.GetSubscriptionCount(),
 		}
+		if rates, err := h.OpenRates(statsOpenRates); err != nil {
+			log.Printf("Open rates error: %v", err)
+		} else {
+			stats["open_rates"] = rates
+		}
+		if providers, err := h.ProviderStats(); err != nil {
+			log.Printf("Provider stats error: %v", err)
+		} else {
+			stats["providers"] = providers
+		}
 		c.JSON(http.StatusOK, stats)
 	}
 }
+
+// HistoryStatsHandler returns hourly publishes, deliveries, failures and
+// queue depth over a window, by default the last 24 hours.
+func HistoryStatsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		to := time.Now()
+		from := to.Add(-24 * time.Hour)
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"from", &from}, {"to", &to}} {
+			v := c.Query(p.name)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid "+p.name+", expected RFC 3339")
+				return
+			}
+			*p.dst = t
+		}
+		if !from.Before(to) {
+			apierror.Respond(c, http.StatusBadRequest, "from must be before to")
+			return
+		}
+		if to.Sub(from) > hub.MaxStatsWindow {
+			apierror.Respond(c, http.StatusBadRequest, "The window may span at most 31 days")
+			return
+		}
+
+		buckets, err := h.Stats(from, to)
+		if err != nil {
+			log.Printf("Stats history error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to read stats")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"from":     from.UTC().Truncate(time.Hour),
+			"to":       to.UTC(),
+			"interval": "1h",
+			"buckets":  buckets,
+		})
+	}
+}
+
+// LatencyStatsHandler returns per-topic delivery latency percentiles and
+// failure rates of the deliveries queued in a window, by default the last
+// hour.
+func LatencyStatsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		window := time.Hour
+		if v := c.Query("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > hub.MaxStatsWindow {
+				apierror.Respond(c, http.StatusBadRequest, "window must be a duration of at most 744h, e.g. 1h")
+				return
+			}
+			window = d
+		}
+		topics, err := h.DeliveryLatency(time.Now().Add(-window))
+		if err != nil {
+			log.Printf("Delivery latency error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to measure delivery latency")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"window": window.String(), "topics": topics})
+	}
+}
@@ -3,12 +3,19 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"no-spam/connectors"
 	"no-spam/hub"
+	"no-spam/middleware"
 	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
 )
 
 // setupTestHubAndStore creates test hub and store
@@ -271,4 +278,803 @@ func TestStatsHandler(t *testing.T) {
 	if _, ok := response["active_subscriptions"]; !ok {
 		t.Error("Expected active_subscriptions in response")
 	}
+	if providers, ok := response["providers"].([]interface{}); !ok || len(providers) != 0 {
+		t.Errorf("Expected an empty providers list, got %v", response["providers"])
+	}
+}
+
+func TestHistoryStatsHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	handler := HistoryStatsHandler(h)
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	_ = s.RecordStats(hour, map[string]int64{hub.StatPublishes: 4, hub.StatFailures: 1}, map[string]int64{hub.StatQueueDepth: 9})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", "/admin/stats"+query, nil)
+		handler(c)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Buckets []hub.StatsBucket `json:"buckets"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if n := len(response.Buckets); n < 24 || n > 25 {
+		t.Fatalf("Expected hourly buckets over the last day, got %d", n)
+	}
+	var found bool
+	for _, b := range response.Buckets {
+		if b.Time.Equal(hour) {
+			found = b.Publishes == 4 && b.Failures == 1 && b.QueueDepth == 9 && b.Deliveries == 0
+		}
+	}
+	if !found {
+		t.Errorf("Expected the recorded hour in %+v", response.Buckets)
+	}
+
+	w = get("?from=" + hour.Format(time.RFC3339) + "&to=" + hour.Add(time.Hour).Format(time.RFC3339))
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || len(response.Buckets) != 1 || response.Buckets[0].Publishes != 4 {
+		t.Errorf("Expected only the recorded hour, got %d %s", w.Code, w.Body.String())
+	}
+
+	for _, query := range []string{
+		"?from=yesterday",
+		"?from=2024-05-08T00:00:00Z&to=2024-05-07T00:00:00Z",
+		"?from=2024-01-01T00:00:00Z&to=2024-05-07T00:00:00Z",
+	} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestLatencyStatsHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	handler := LatencyStatsHandler(h)
+	_ = s.CreateTopic("news")
+	id, _ := s.SaveMessage("news", []byte(`{}`))
+	delivered, _ := s.EnqueueMessage(id, "tok-1")
+	failed, _ := s.EnqueueMessage(id, "tok-2")
+	_ = s.MarkDelivered(delivered)
+	_ = s.MarkFailed(failed)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", "/admin/stats/latency"+query, nil)
+		handler(c)
+		return w
+	}
+
+	w := get("?window=30m")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Window string             `json:"window"`
+		Topics []hub.TopicLatency `json:"topics"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Window != "30m0s" || len(response.Topics) != 1 {
+		t.Fatalf("Expected the news topic over 30m, got %s", w.Body.String())
+	}
+	if tl := response.Topics[0]; tl.Topic != "news" || tl.Delivered != 1 || tl.Failed != 1 || tl.FailureRate != 0.5 {
+		t.Errorf("Unexpected latency stats %+v", tl)
+	}
+
+	for _, query := range []string{"?window=soon", "?window=-1h", "?window=1000h"} {
+		if w := get(query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+// TestSubscribeHandler_WebhookOptions tests per-subscription webhook options
+func TestSubscribeHandler_WebhookOptions(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	handler := SubscribeHandler(h)
+	_ = s.CreateTopic("hooks")
+
+	tests := []struct {
+		name           string
+		body           map[string]interface{}
+		expectedStatus int
+	}{
+		{
+			name: "Valid options",
+			body: map[string]interface{}{
+				"topic": "hooks", "provider": "webhook", "webhook": "https://example.com/hook",
+				"options": map[string]interface{}{"method": "PUT", "headers": map[string]string{"Authorization": "Bearer x"}, "max_retries": 2},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Update options on re-subscribe",
+			body: map[string]interface{}{
+				"topic": "hooks", "provider": "webhook", "webhook": "https://example.com/hook",
+				"options": map[string]interface{}{"timeout_ms": 1000},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Options on non-webhook provider",
+			body: map[string]interface{}{
+				"topic": "hooks", "provider": "fcm", "token": "device",
+				"options": map[string]interface{}{"method": "PUT"},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid method",
+			body: map[string]interface{}{
+				"topic": "hooks", "provider": "webhook", "webhook": "https://example.com/other",
+				"options": map[string]interface{}{"method": "DELETE"},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Too many retries",
+			body: map[string]interface{}{
+				"topic": "hooks", "provider": "webhook", "webhook": "https://example.com/other",
+				"options": map[string]interface{}{"max_retries": 50},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Invalid content encoding",
+			body: map[string]interface{}{
+				"topic": "hooks", "provider": "webhook", "webhook": "https://example.com/other",
+				"options": map[string]interface{}{"content_encoding": "br"},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := setupTestContext()
+			c.Set("username", "testuser")
+
+			bodyBytes, _ := json.Marshal(tt.body)
+			c.Request = httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(bodyBytes))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Body: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	subs, _ := s.GetSubscribers("hooks")
+	if len(subs) != 1 || subs[0].Options == nil || subs[0].Options.TimeoutMs != 1000 {
+		t.Errorf("Expected updated options, got %+v", subs)
+	}
+
+	subscribe := func(username, topic string, options map[string]interface{}) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Set("username", username)
+		bodyBytes, _ := json.Marshal(map[string]interface{}{
+			"topic": topic, "provider": "webhook", "webhook": "https://example.com/hook", "options": options,
+		})
+		c.Request = httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(bodyBytes))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler(c)
+		return w
+	}
+
+	// Another user can't take over the subscription by re-subscribing
+	if w := subscribe("mallory", "hooks", map[string]interface{}{"method": "PUT", "timeout_ms": 2000}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's subscription, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	subs, _ = s.GetSubscribers("hooks")
+	if len(subs) != 1 || subs[0].Options.TimeoutMs != 1000 {
+		t.Errorf("Expected the options unchanged, got %+v", subs[0].Options)
+	}
+
+	// Re-subscribing through an alias updates the renamed topic's subscription
+	if err := h.RenameTopic("hooks", "hooks-v2", true); err != nil {
+		t.Fatal(err)
+	}
+	if w := subscribe("testuser", "hooks", map[string]interface{}{"timeout_ms": 3000}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 through the alias, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	subs, _ = s.GetSubscribers("hooks-v2")
+	if len(subs) != 1 || subs[0].Options.TimeoutMs != 3000 {
+		t.Errorf("Expected the options updated through the alias, got %+v", subs[0].Options)
+	}
+}
+
+// TestSubscribeHandler_WebhookPolicy tests that blocked webhook targets are rejected at subscribe time
+func TestSubscribeHandler_WebhookPolicy(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("hooks")
+
+	policy, _ := connectors.NewURLPolicy(connectors.URLPolicyConfig{AllowedSchemes: []string{"https"}})
+	wc := connectors.NewWebhookConnector()
+	wc.SetURLPolicy(policy)
+	h.RegisterConnector("webhook", connectors.NewCircuitBreaker("webhook", wc, connectors.BreakerConfig{}))
+
+	handler := SubscribeHandler(h)
+
+	tests := []struct {
+		name           string
+		webhook        string
+		expectedStatus int
+	}{
+		{"Public address", "https://93.184.216.34/hook", http.StatusOK},
+		{"Loopback", "https://127.0.0.1/hook", http.StatusBadRequest},
+		{"Metadata endpoint", "https://169.254.169.254/latest", http.StatusBadRequest},
+		{"Disallowed scheme", "http://93.184.216.34/hook", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := setupTestContext()
+			c.Set("username", "testuser")
+
+			bodyBytes, _ := json.Marshal(map[string]string{"topic": "hooks", "provider": "webhook", "webhook": tt.webhook})
+			c.Request = httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(bodyBytes))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler(c)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Body: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestTagHandlers tests tag updates and tag-based unsubscribe
+func TestTagHandlers(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("promo")
+	_ = s.CreateTopic("news")
+	_ = s.AddSubscription("promo", "device1", "fcm", "testuser")
+	_ = s.AddSubscription("news", "device1", "fcm", "testuser")
+	_ = s.AddSubscription("news", "device2", "fcm", "otheruser")
+
+	call := func(handler func(c *gin.Context), method, body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Set("username", "testuser")
+		c.Request = httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler(c)
+		return w
+	}
+
+	w := call(UpdateTagsHandler(h, false), "POST", `{"topic": "promo", "token": "device1", "tags": ["marketing", "beta"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = call(UpdateTagsHandler(h, true), "DELETE", `{"topic": "promo", "token": "device1", "tags": ["beta"]}`)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"tags":["marketing"]`)) {
+		t.Errorf("Expected [marketing], got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Can't tag another user's subscription
+	if w := call(UpdateTagsHandler(h, false), "POST", `{"topic": "news", "token": "device2", "tags": ["x"]}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+	if w := call(UpdateTagsHandler(h, false), "POST", `{"topic": "promo", "token": "device1", "tags": ["has space"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid tag, got %d", w.Code)
+	}
+
+	w = call(UnsubscribeByTagHandler(h), "POST", `{"tag": "marketing"}`)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"removed":1`)) {
+		t.Errorf("Expected 1 removed, got %d: %s", w.Code, w.Body.String())
+	}
+	if subs, _ := s.GetSubscriptionsByUser("testuser"); len(subs) != 1 || subs[0].Topic != "news" {
+		t.Errorf("Expected only news subscription left, got %+v", subs)
+	}
+}
+
+func TestUpdatePreferencesHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+	_ = s.AddSubscription("news", "device1", "fcm", "testuser")
+	_ = s.AddSubscription("news", "device2", "fcm", "otheruser")
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Set("username", "testuser")
+		c.Request = httptest.NewRequest("PATCH", "/subscriptions/preferences", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		UpdatePreferencesHandler(h)(c)
+		return w
+	}
+
+	w := patch(`{"topic": "news", "token": "device1", "muted_until": "2030-01-02T15:04:05Z", "max_per_day": 5}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"muted_until":"2030-01-02T15:04:05Z"`) {
+		t.Fatalf("Expected the mute set, got %d: %s", w.Code, w.Body.String())
+	}
+	w = patch(`{"topic": "news", "token": "device1", "min_priority": "high", "muted_until": ""}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"preferences":{"min_priority":"high","max_per_day":5}}` {
+		t.Errorf("Expected the mute cleared and the cap kept, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := patch(`{"topic": "news", "token": "device1", "min_priority": "urgent"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown priority, got %d", w.Code)
+	}
+	if w := patch(`{"topic": "news", "token": "device1", "muted_until": "tomorrow"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid time, got %d", w.Code)
+	}
+	if w := patch(`{"topic": "news", "token": "device2", "max_per_day": 1}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's subscription, got %d", w.Code)
+	}
+
+	w = patch(`{"topic": "news", "token": "device1", "min_priority": "normal", "max_per_day": 0}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"preferences":{}}` {
+		t.Errorf("Expected no preferences left, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSendHandler_ContentFilter(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+
+	admin := func(handler func(c *gin.Context), body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler(c)
+		return w
+	}
+	if w := admin(CreateFilterRuleHandler(h), `{"type": "regex", "pattern": "("}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid regex, got %d", w.Code)
+	}
+	if w := admin(CreateFilterRuleHandler(h), `{"type": "keyword", "pattern": "casino", "record": true}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	send := func(payload string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Set("username", "publisher1")
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "news", "payload": `+payload+`}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w
+	}
+	if w := send(`{"title": "Casino night"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send(`{"title": "Weather"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	entries, err := h.ListModerationLog(10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 moderation entry, got %v (%v)", entries, err)
+	}
+	if entries[0].Publisher != "publisher1" || entries[0].Topic != "news" {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+}
+
+func TestSendHandler_TopicPermission(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+	_ = s.CreateTopic("billing")
+
+	send := func(topic string) int {
+		c, w := setupTestContext()
+		c.Set("permissions", []string{"publish:news"})
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "`+topic+`", "payload": {}}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w.Code
+	}
+	if code := send("news"); code != http.StatusOK {
+		t.Errorf("Expected 200 for permitted topic, got %d", code)
+	}
+	if code := send("billing"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for other topic, got %d", code)
+	}
+}
+
+func TestSendHandler_LargeSend(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	h.RegisterConnector("mock", connectors.NewMockConnector())
+	h.SetLargeSendThreshold(1)
+	_ = s.CreateTopic("news")
+	_ = s.AddSubscription("news", "device-1", "mock", "alice")
+	_ = s.AddSubscription("news", "device-2", "mock", "bob")
+
+	send := func(perms ...string) int {
+		c, w := setupTestContext()
+		c.Set("permissions", perms)
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "news", "payload": {}}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w.Code
+	}
+	if code := send("publish"); code != http.StatusAccepted {
+		t.Errorf("Expected 202 for a large send without large_send, got %d", code)
+	}
+	if code := send("publish", "large_send:news"); code != http.StatusOK {
+		t.Errorf("Expected 200 with large_send, got %d", code)
+	}
+	if code := send("publish", "large_send:billing"); code != http.StatusAccepted {
+		t.Errorf("Expected 202 with large_send on another topic, got %d", code)
+	}
+}
+
+func TestSendHandler_PayloadTooLarge(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+	h.SetMaxPayloadSize(32)
+
+	send := func(payload string, limit gin.HandlerFunc) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "news", "payload": `+payload+`}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.ContentLength = -1 // Chunked, so only the body reader enforces the limit
+		limit(c)
+		SendHandler(h)(c)
+		return w
+	}
+	noLimit := func(*gin.Context) {}
+
+	if w := send(`{"title": "hi"}`, noLimit); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for small payload, got %d", w.Code)
+	}
+	w := send(`{"title": "`+strings.Repeat("x", 64)+`"}`, noLimit)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for large payload, got %d", w.Code)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["limit"] != float64(32) {
+		t.Errorf("Expected limit in response, got %v", resp)
+	}
+
+	if w := send(`{"title": "hi"}`, middleware.MaxBodySize(16)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for large body, got %d", w.Code)
+	}
+}
+
+func TestSendHandler_QueueFull(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+	_ = s.CreateTopic("ops")
+	_ = s.AddSubscription("news", "device-1", "missing", "alice")
+	_ = s.AddSubscription("ops", "device-2", "missing", "bob")
+	h.SetMaxQueueDepth(2, 1)
+
+	send := func(topic string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "`+topic+`", "payload": {"title": "hi"}}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w
+	}
+
+	// Without a connector, deliveries stay pending
+	if w := send("news"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for first send, got %d: %s", w.Code, w.Body.String())
+	}
+	w := send("news")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 once the topic queue is full, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["topic"] != "news" || resp["limit"] != float64(1) {
+		t.Errorf("Expected the topic limit in response, got %v", resp)
+	}
+
+	if w := send("ops"); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another topic, got %d", w.Code)
+	}
+	w = send("ops")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusServiceUnavailable || resp["topic"] != "" || resp["limit"] != float64(2) {
+		t.Errorf("Expected 503 for the full queue, got %d: %v", w.Code, resp)
+	}
+}
+
+func TestSendHandler_Sync(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	h.RegisterConnector("mock", connectors.NewMockConnector())
+	h.SetSyncLimit(2)
+	_ = s.CreateTopic("ops")
+	_ = s.AddSubscription("ops", "device-1", "mock", "alice")
+	_ = s.AddSubscription("ops", "device-2", "missing", "bob")
+
+	send := func(body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/send?sync=true", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		SendHandler(h)(c)
+		return w
+	}
+
+	w := send(`{"topic": "ops", "payload": {"title": "deploy done"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Results []hub.DeliveryResult `json:"results"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Results) != 2 {
+		t.Fatalf("Expected 2 results, got %+v", resp.Results)
+	}
+	byToken := map[string]hub.DeliveryResult{}
+	for _, r := range resp.Results {
+		byToken[r.Token] = r
+	}
+	if byToken["device-1"].Status != hub.ResultDelivered {
+		t.Errorf("Expected device-1 delivered, got %+v", byToken["device-1"])
+	}
+	if r := byToken["device-2"]; r.Status != hub.ResultPending || r.Error == "" {
+		t.Errorf("Expected device-2 pending with an error, got %+v", r)
+	}
+
+	_ = s.AddSubscription("ops", "device-3", "mock", "carol")
+	if w := send(`{"topic": "ops", "payload": {"title": "too many"}}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 above the sync limit, got %d", w.Code)
+	}
+	if n, _ := s.GetTotalMessagesSent(); n != 1 {
+		t.Errorf("A send above the sync limit should not be stored, got %d messages", n)
+	}
+	if w := send(`{"provider": "mock", "token": "device-1", "payload": {}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a direct sync send, got %d", w.Code)
+	}
+}
+
+func TestSendHandler_StatusURL(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	h.RegisterConnector("mock", connectors.NewMockConnector())
+	_ = s.CreateTopic("news")
+	_ = s.AddSubscription("news", "device-1", "mock", "alice")
+	_ = s.AddSubscription("news", "device-2", "mock", "bob")
+
+	c, w := setupTestContext()
+	c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "news", "payload": {"title": "hi"}}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	SendHandler(h)(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		MessageID int64  `json:"message_id"`
+		Enqueued  int    `json:"enqueued"`
+		StatusURL string `json:"status_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.MessageID == 0 || resp.Enqueued != 2 || resp.StatusURL != fmt.Sprintf("/v1/messages/%d", resp.MessageID) {
+		t.Fatalf("Unexpected send response %s", w.Body.String())
+	}
+
+	r := gin.New()
+	r.GET("/v1/messages/:id", func(c *gin.Context) {
+		c.Set("permissions", []string{"publish:news"})
+	}, MessageStatusHandler(h))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	var report hub.MessageReport
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := get(resp.StatusURL)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &report)
+		if report.Status == hub.CallbackComplete || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if report.Status != hub.CallbackComplete || report.Total != 2 || report.Delivered != 2 || report.CreatedAt.IsZero() {
+		t.Errorf("Unexpected status %+v", report)
+	}
+	for _, d := range report.Deliveries {
+		if d.Attempts != 1 || d.DeliveredAt == nil || d.QueuedAt.IsZero() {
+			t.Errorf("Unexpected delivery %+v", d)
+		}
+	}
+	if len(report.Deliveries) != 2 {
+		t.Errorf("Expected 2 deliveries, got %+v", report.Deliveries)
+	}
+
+	if w := get("/v1/messages/9999"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown message, got %d", w.Code)
+	}
+	_ = s.CreateTopic("ops")
+	id, _ := s.SaveMessage("ops", []byte(`{}`))
+	if w := get(fmt.Sprintf("/v1/messages/%d", id)); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a message on another topic, got %d", w.Code)
+	}
+}
+
+func TestCreateUserTopicHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateUser("alice", "hash", "publisher")
+	_ = s.CreateTopic("news")
+
+	create := func(body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Set("username", "alice")
+		c.Request = httptest.NewRequest("POST", "/topics", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		CreateUserTopicHandler(h)(c)
+		return w
+	}
+
+	if w := create(`{"name": "alice/news"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 while self-service is disabled, got %d", w.Code)
+	}
+
+	h.SetMaxUserTopics(1)
+	if w := create(`{"name": "alice/news", "description": "My news"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	info, _ := s.GetTopicInfo("alice/news")
+	if info == nil || info.Owner != "alice" || info.Description != "My news" {
+		t.Errorf("Unexpected topic %+v", info)
+	}
+
+	for _, name := range []string{"news", "bob/news", "alice/", "alice/a/b"} {
+		if w := create(`{"name": "` + name + `"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", name, w.Code)
+		}
+	}
+	if w := create(`{"name": "alice/news"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 once the limit is reached, got %d", w.Code)
+	}
+	h.SetMaxUserTopics(2)
+	if w := create(`{"name": "alice/news"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing topic, got %d", w.Code)
+	}
+}
+
+func TestReceiptHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+	_ = s.AddSubscription("news", "device-1", "missing", "alice")
+	id, _ := s.SaveMessage("news", []byte(`{}`))
+	q, _ := s.EnqueueMessage(id, "device-1")
+	_ = s.MarkDelivered(q)
+
+	receipt := func(body string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/receipts", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		ReceiptHandler(h)(c)
+		return w
+	}
+
+	if w := receipt(fmt.Sprintf(`{"message_id": %d, "token": "device-1"}`, id)); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := receipt(fmt.Sprintf(`{"message_id": %d, "token": "device-2"}`, id)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another device, got %d", w.Code)
+	}
+	if w := receipt(`{"token": "device-1"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a message ID, got %d", w.Code)
+	}
+
+	c, w := setupTestContext()
+	c.Request = httptest.NewRequest("GET", "/stats", nil)
+	StatsHandler(h)(c)
+	var stats struct {
+		OpenRates []hub.OpenRate `json:"open_rates"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if len(stats.OpenRates) != 1 || stats.OpenRates[0].Opened != 1 || stats.OpenRates[0].OpenRate != 1 {
+		t.Errorf("Unexpected open rates %s", w.Body.String())
+	}
+}
+
+func TestUnsubscribeLinkHandler(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	h.SetUnsubscribeLinks([]byte("secret"), "https://push.example.com")
+	_ = s.CreateTopic("news")
+	_ = s.CreateUser("alice", "hash", "subscriber")
+
+	c, w := setupTestContext()
+	c.Set("username", "alice")
+	c.Request = httptest.NewRequest("POST", "/subscribe", bytes.NewBufferString(`{"topic": "news", "token": "device-1", "provider": "mock"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	SubscribeHandler(h)(c)
+	var resp struct {
+		UnsubscribeURL string `json:"unsubscribe_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !strings.HasPrefix(resp.UnsubscribeURL, "https://push.example.com/v1/unsubscribe?sig=") {
+		t.Fatalf("Expected an unsubscribe URL, got %s", w.Body.String())
+	}
+
+	unsubscribe := func(target string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", target, nil)
+		UnsubscribeLinkHandler(h)(c)
+		return w
+	}
+
+	if w := unsubscribe("/unsubscribe?sig=bogus"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad signature, got %d", w.Code)
+	}
+	if w := unsubscribe(strings.TrimPrefix(resp.UnsubscribeURL, "https://push.example.com")); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if subs, _ := s.GetSubscribers("news"); len(subs) != 0 {
+		t.Errorf("Expected the subscription to be removed, got %v", subs)
+	}
+}
+
+func TestImageHandler(t *testing.T) {
+	h, _ := setupTestHubAndStore(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer srv.Close()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("GET", target, nil)
+		ImageHandler(h)(c)
+		return w
+	}
+	if w := get("/images?url=x&sig=y"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while the proxy is disabled, got %d", w.Code)
+	}
+
+	h.SetUnsubscribeLinks([]byte("secret"), "https://push.example.com")
+	h.SetImageProxy([]byte("secret"), connectors.NewImageProxy())
+	link := strings.TrimPrefix(h.ProxiedImageURL(srv.URL+"/a.png"), "https://push.example.com/v1")
+	if w := get(strings.Replace(link, "a.png", "b.png", 1)); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a link to another image, got %d", w.Code)
+	}
+	w := get(link)
+	if w.Code != http.StatusOK || w.Body.String() != "png" || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected the proxied image, got %d %q %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestSendHandler_Origin(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	_ = s.CreateTopic("news")
+
+	c, w := setupTestContext()
+	c.Set("username", "alice")
+	c.Request = httptest.NewRequest("POST", "/send", bytes.NewBufferString(`{"topic": "news", "payload": {"n": 1}, "include_from": true}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("User-Agent", "release-bot/1.0")
+	c.Request.RemoteAddr = "203.0.113.7:4711"
+	SendHandler(h)(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	c, w = setupTestContext()
+	c.Params = gin.Params{{Key: "name", Value: "news"}}
+	c.Request = httptest.NewRequest("GET", "/admin/topics/news/messages", nil)
+	GetMessagesHandler(h)(c)
+	var msgs []store.Message
+	json.Unmarshal(w.Body.Bytes(), &msgs)
+	want := store.MessageOrigin{Publisher: "alice", IP: "203.0.113.7", UserAgent: "release-bot/1.0"}
+	if len(msgs) != 1 || msgs[0].Origin != want {
+		t.Fatalf("Expected origin %+v, got %s", want, w.Body.String())
+	}
+	var n store.Notification
+	json.Unmarshal(msgs[0].Payload, &n)
+	if n.From != "alice" {
+		t.Errorf("Expected from alice in the stored payload, got %s", msgs[0].Payload)
+	}
 }
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"no-spam/apierror"
+	"no-spam/hub"
+	"no-spam/middleware"
+	"no-spam/rbac"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultScheduleRuns is how many runs GET /schedules/:id/runs lists by default.
+const defaultScheduleRuns = 20
+
+// CreateScheduleHandler schedules a recurring publish. The body is a send
+// request with a cron expression and an optional time zone.
+func CreateScheduleHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			hub.Message
+			Cron     string `json:"cron"`
+			Timezone string `json:"timezone"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if middleware.IsBodyTooLarge(err) {
+				apierror.Respond(c, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if !middleware.Can(c, rbac.Publish, req.Topic) {
+			apierror.Respond(c, http.StatusForbidden, "Forbidden: cannot publish to this topic")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		sc, err := h.CreateSchedule(ctx, middleware.GetUsername(c), req.Cron, req.Timezone, req.Message)
+		if err != nil {
+			scheduleError(c, err)
+			return
+		}
+
+		audit(c, h, "schedule.create", strconv.FormatInt(sc.ID, 10), map[string]string{
+			"topic": sc.Topic,
+			"cron":  sc.Cron,
+		})
+		c.JSON(http.StatusCreated, sc)
+	}
+}
+
+// scheduleError responds to a failed schedule operation.
+func scheduleError(c *gin.Context, err error) {
+	var limit *hub.ScheduleLimitError
+	switch {
+	case errors.Is(err, hub.ErrScheduleNotFound):
+		apierror.Respond(c, http.StatusNotFound, "Schedule not found")
+	case errors.Is(err, hub.ErrInvalidSchedule):
+		apierror.Respond(c, http.StatusBadRequest, err.Error())
+	case errors.As(err, &limit):
+		apierror.Respond(c, http.StatusConflict, err.Error())
+	default:
+		// The scheduled message is checked like a send
+		sendError(c, err)
+	}
+}
+
+// ListSchedulesHandler lists the schedules of the topics the user can
+// publish to, optionally of one topic.
+func ListSchedulesHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schedules, err := h.ListSchedules(c.Query("topic"))
+		if err == hub.ErrTopicNotFound {
+			apierror.Respond(c, http.StatusNotFound, "Topic not found")
+			return
+		}
+		if err != nil {
+			log.Printf("ListSchedules error: %v", err)
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list schedules")
+			return
+		}
+
+		visible := []store.Schedule{}
+		for _, sc := range schedules {
+			if middleware.Can(c, rbac.Publish, sc.Topic) {
+				visible = append(visible, sc)
+			}
+		}
+		c.JSON(http.StatusOK, visible)
+	}
+}
+
+// scheduleParam returns the schedule of the request's :id, after checking
+// the user can publish to its topic. It responds and returns nil otherwise.
+func scheduleParam(c *gin.Context, h *hub.Hub) *store.Schedule {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Respond(c, http.StatusBadRequest, "Invalid schedule id")
+		return nil
+	}
+	sc, err := h.GetSchedule(id)
+	if err != nil {
+		scheduleError(c, err)
+		return nil
+	}
+	if !middleware.Can(c, rbac.Publish, sc.Topic) {
+		// Not revealing schedules of other topics
+		apierror.Respond(c, http.StatusNotFound, "Schedule not found")
+		return nil
+	}
+	return sc
+}
+
+func GetScheduleHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sc := scheduleParam(c, h); sc != nil {
+			c.JSON(http.StatusOK, sc)
+		}
+	}
+}
+
+// PauseScheduleHandler pauses a schedule, or resumes it when resume is set.
+func PauseScheduleHandler(h *hub.Hub, resume bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sc := scheduleParam(c, h)
+		if sc == nil {
+			return
+		}
+
+		action, pause := "schedule.pause", h.PauseSchedule
+		if resume {
+			action, pause = "schedule.resume", h.ResumeSchedule
+		}
+		sc, err := pause(sc.ID)
+		if err != nil {
+			scheduleError(c, err)
+			return
+		}
+
+		audit(c, h, action, strconv.FormatInt(sc.ID, 10), map[string]string{"topic": sc.Topic})
+		c.JSON(http.StatusOK, sc)
+	}
+}
+
+func DeleteScheduleHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sc := scheduleParam(c, h)
+		if sc == nil {
+			return
+		}
+		if err := h.DeleteSchedule(sc.ID); err != nil {
+			scheduleError(c, err)
+			return
+		}
+
+		audit(c, h, "schedule.delete", strconv.FormatInt(sc.ID, 10), map[string]string{"topic": sc.Topic})
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+	}
+}
+
+// ListScheduleRunsHandler lists the latest runs of a schedule, newest first.
+func ListScheduleRunsHandler(h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sc := scheduleParam(c, h)
+		if sc == nil {
+			return
+		}
+		limit := defaultScheduleRuns
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > store.MaxScheduleRuns {
+				apierror.Respond(c, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+			limit = n
+		}
+
+		runs, err := h.ScheduleRuns(sc.ID, limit)
+		if err != nil {
+			scheduleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, runs)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"no-spam/hub"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scheduleRouter serves the schedule endpoints to alice with perms.
+func scheduleRouter(h *hub.Hub, perms ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	auth := r.Group("/", func(c *gin.Context) {
+		c.Set("username", "alice")
+		c.Set("permissions", perms)
+	})
+	auth.POST("/schedules", CreateScheduleHandler(h))
+	auth.GET("/schedules", ListSchedulesHandler(h))
+	auth.GET("/schedules/:id", GetScheduleHandler(h))
+	auth.DELETE("/schedules/:id", DeleteScheduleHandler(h))
+	auth.POST("/schedules/:id/pause", PauseScheduleHandler(h, false))
+	auth.POST("/schedules/:id/resume", PauseScheduleHandler(h, true))
+	auth.GET("/schedules/:id/runs", ListScheduleRunsHandler(h))
+	return r
+}
+
+func scheduleRequest(r *gin.Engine, method, path string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestScheduleHandlers(t *testing.T) {
+	h, s := setupTestHubAndStore(t)
+	s.CreateTopic("news")
+	s.CreateTopic("alerts")
+	r := scheduleRouter(h, "publish:news")
+
+	w := scheduleRequest(r, "POST", "/schedules", map[string]any{
+		"topic": "alerts", "cron": "@daily", "payload": map[string]string{"title": "Hi"},
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another topic, got %d", w.Code)
+	}
+	w = scheduleRequest(r, "POST", "/schedules", map[string]any{
+		"topic": "news", "cron": "every day", "payload": map[string]string{"title": "Hi"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid expression, got %d", w.Code)
+	}
+
+	w = scheduleRequest(r, "POST", "/schedules", map[string]any{
+		"topic": "news", "cron": "0 9 * * 1-5", "timezone": "America/New_York", "payload": map[string]string{"title": "Digest"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var sc store.Schedule
+	json.Unmarshal(w.Body.Bytes(), &sc)
+	if sc.Topic != "news" || sc.CreatedBy != "alice" || sc.Timezone != "America/New_York" || sc.NextRun.IsZero() {
+		t.Errorf("Unexpected schedule %+v", sc)
+	}
+	path := fmt.Sprintf("/schedules/%d", sc.ID)
+
+	// Schedules of topics the user can't publish to stay hidden
+	other, _ := s.CreateSchedule(store.Schedule{Topic: "alerts", Cron: "@hourly", Timezone: "UTC", Request: json.RawMessage(`{}`), NextRun: sc.NextRun})
+	var listed []store.Schedule
+	w = scheduleRequest(r, "GET", "/schedules", nil)
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if w.Code != http.StatusOK || len(listed) != 1 || listed[0].ID != sc.ID {
+		t.Errorf("Expected only the news schedule, got %d %s", w.Code, w.Body.String())
+	}
+	if w := scheduleRequest(r, "GET", fmt.Sprintf("/schedules/%d", other), nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a schedule of another topic, got %d", w.Code)
+	}
+
+	w = scheduleRequest(r, "POST", path+"/pause", nil)
+	json.Unmarshal(w.Body.Bytes(), &sc)
+	if w.Code != http.StatusOK || !sc.Paused {
+		t.Errorf("Expected the schedule paused, got %d %s", w.Code, w.Body.String())
+	}
+	w = scheduleRequest(r, "POST", path+"/resume", nil)
+	json.Unmarshal(w.Body.Bytes(), &sc)
+	if w.Code != http.StatusOK || sc.Paused {
+		t.Errorf("Expected the schedule resumed, got %d %s", w.Code, w.Body.String())
+	}
+
+	s.AddScheduleRun(store.ScheduleRun{ScheduleID: sc.ID, ScheduledAt: sc.NextRun, RanAt: sc.NextRun, Status: store.ScheduleRunSent, MessageID: 7})
+	var runs []store.ScheduleRun
+	w = scheduleRequest(r, "GET", path+"/runs", nil)
+	json.Unmarshal(w.Body.Bytes(), &runs)
+	if w.Code != http.StatusOK || len(runs) != 1 || runs[0].MessageID != 7 {
+		t.Errorf("Expected the run, got %d %s", w.Code, w.Body.String())
+	}
+	if w := scheduleRequest(r, "GET", path+"/runs?limit=0", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid limit, got %d", w.Code)
+	}
+
+	if w := scheduleRequest(r, "DELETE", path, nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if w := scheduleRequest(r, "GET", path, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after deleting, got %d", w.Code)
+	}
+	events, _ := s.ListAuditEvents(store.AuditFilter{Action: "schedule.*"})
+	if len(events) != 4 {
+		t.Errorf("Expected 4 audited schedule actions, got %+v", events)
+	}
+}
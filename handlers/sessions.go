@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"no-spam/apierror"
+	"no-spam/middleware"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionTouchInterval limits how often a session's last use is written.
+const sessionTouchInterval = time.Minute
+
+var (
+	errSessionRevoked = errors.New("Token revoked")
+	errSessionCheck   = errors.New("Failed to check token")
+	errUserDisabled   = errors.New("User is disabled")
+	errSessionMissing = errors.New("Token predates sessions; log in again")
+)
+
+// issueToken signs a token and records its session. lifetime 0 uses the
+// role's default. Disabled users get errUserDisabled.
+func issueToken(c *gin.Context, s store.Store, username, role, method string, lifetime time.Duration) (*middleware.IssuedToken, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.Disabled {
+		return nil, errUserDisabled
+	}
+	t, err := middleware.IssueToken(username, role, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	err = s.CreateSession(store.Session{
+		ID:        t.ID,
+		Username:  username,
+		Method:    method,
+		IP:        c.ClientIP(),
+		IssuedAt:  t.IssuedAt,
+		ExpiresAt: t.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SessionCheck rejects tokens whose session was revoked and records when
+// sessions are used. Tokens issued before sessions were tracked have no ID
+// and are rejected, as they could be neither revoked nor paused with their
+// user.
+func SessionCheck(s store.Store) middleware.SessionCheck {
+	return func(c *gin.Context, claims *middleware.Claims) error {
+		if claims.ID == "" {
+			return errSessionMissing
+		}
+		sess, err := s.GetSession(claims.ID)
+		if err != nil {
+			log.Printf("[AUTH] Failed to look up session %s: %v", claims.ID, err)
+			return errSessionCheck
+		}
+		if sess == nil || sess.Username != claims.Subject {
+			return errSessionRevoked
+		}
+		now := time.Now()
+		if sess.LastUsedAt == nil || now.Sub(*sess.LastUsedAt) >= sessionTouchInterval || sess.LastIP != c.ClientIP() {
+			if err := s.TouchSession(sess.ID, c.ClientIP(), now); err != nil {
+				log.Printf("[AUTH] Failed to record use of session %s: %v", sess.ID, err)
+			}
+		}
+		return nil
+	}
+}
+
+// ListSessionsHandler lists a user's unexpired tokens.
+func ListSessionsHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessions, err := s.ListSessions(c.Param("username"))
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to list sessions")
+			return
+		}
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSessionsHandler revokes every token of a user.
+func RevokeSessionsHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		n, err := s.DeleteUserSessions(username)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+		audit(c, s, "session.revoke", username, map[string]string{"count": strconv.FormatInt(n, 10)})
+		c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": n})
+	}
+}
+
+// RevokeSessionHandler revokes one token of a user.
+func RevokeSessionHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, id := c.Param("username"), c.Param("id")
+		ok, err := s.DeleteSession(username, id)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+		if !ok {
+			apierror.Respond(c, http.StatusNotFound, "Session not found")
+			return
+		}
+		audit(c, s, "session.revoke", username, map[string]string{"session": id})
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+	}
+}
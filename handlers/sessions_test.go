@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"no-spam/middleware"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionRouter serves login, refresh and the session admin endpoints with
+// the session check in front of the authenticated routes.
+func sessionRouter(s store.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", LoginHandler(s))
+	auth := r.Group("/", middleware.JWTAuthMiddleware(SessionCheck(s)), func(c *gin.Context) {
+		c.Set("permissions", []string{"*"})
+	})
+	auth.POST("/refresh", RefreshHandler(s))
+	auth.GET("/users/:username/sessions", ListSessionsHandler(s))
+	auth.DELETE("/users/:username/sessions", RevokeSessionsHandler(s))
+	auth.DELETE("/users/:username/sessions/:id", RevokeSessionHandler(s))
+	return r
+}
+
+func sessionRequest(r *gin.Engine, method, path, token string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func sessionLogin(t *testing.T, r *gin.Engine, username string) string {
+	t.Helper()
+	w := sessionRequest(r, "POST", "/login", "", map[string]string{"username": username, "password": "password123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Login failed: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct{ Token string }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp.Token
+}
+
+func listSessions(t *testing.T, r *gin.Engine, token, username string) []store.Session {
+	t.Helper()
+	w := sessionRequest(r, "GET", "/users/"+username+"/sessions", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Listing sessions failed: %d %s", w.Code, w.Body.String())
+	}
+	var sessions []store.Session
+	json.Unmarshal(w.Body.Bytes(), &sessions)
+	return sessions
+}
+
+func TestSessions_ListAndRevoke(t *testing.T) {
+	s := setupTestStore(t)
+	r := sessionRouter(s)
+
+	admin := sessionLogin(t, r, "testadmin")
+	first := sessionLogin(t, r, "testpublisher")
+	second := sessionLogin(t, r, "testpublisher")
+
+	sessions := listSessions(t, r, admin, "testpublisher")
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", sessions)
+	}
+	for _, sess := range sessions {
+		if sess.Method != "password" || sess.ID == "" || sess.IssuedAt.IsZero() {
+			t.Errorf("Unexpected session %+v", sess)
+		}
+	}
+
+	claims, _ := middleware.ParseToken(first)
+	w := sessionRequest(r, "DELETE", "/users/testpublisher/sessions/"+claims.ID, admin, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 revoking a session, got %d %s", w.Code, w.Body.String())
+	}
+	if w := sessionRequest(r, "GET", "/users/testpublisher/sessions", first, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked token to be rejected, got %d", w.Code)
+	}
+	if w := sessionRequest(r, "GET", "/users/testpublisher/sessions", second, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the other token to still work, got %d", w.Code)
+	}
+
+	if w := sessionRequest(r, "DELETE", "/users/testpublisher/sessions/"+claims.ID, admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking an unknown session, got %d", w.Code)
+	}
+	// Another user's session can't be revoked through the wrong username
+	adminClaims, _ := middleware.ParseToken(admin)
+	if w := sessionRequest(r, "DELETE", "/users/testpublisher/sessions/"+adminClaims.ID, admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a session of another user, got %d", w.Code)
+	}
+
+	w = sessionRequest(r, "DELETE", "/users/testpublisher/sessions", admin, nil)
+	var resp struct{ Revoked int64 }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Revoked != 1 {
+		t.Errorf("Expected 1 session revoked, got %d %s", w.Code, w.Body.String())
+	}
+	if w := sessionRequest(r, "GET", "/users/testpublisher/sessions", second, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected every token of the user to be rejected, got %d", w.Code)
+	}
+
+	entries, _ := s.ListAuditEvents(store.AuditFilter{Action: "session.revoke"})
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 session.revoke audit entries, got %d", len(entries))
+	}
+}
+
+func TestSessions_RefreshRevokesOldToken(t *testing.T) {
+	s := setupTestStore(t)
+	r := sessionRouter(s)
+
+	old := sessionLogin(t, r, "testsubscriber")
+	w := sessionRequest(r, "POST", "/refresh", old, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Refresh failed: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct{ Token string }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if w := sessionRequest(r, "POST", "/refresh", old, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the refreshed token to be revoked, got %d", w.Code)
+	}
+	sessions, _ := s.ListSessions("testsubscriber")
+	if len(sessions) != 1 || sessions[0].Method != "refresh" {
+		t.Errorf("Expected only the refreshed session, got %+v", sessions)
+	}
+	if w := sessionRequest(r, "POST", "/refresh", resp.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the new token to work, got %d", w.Code)
+	}
+}
+
+func TestSessionCheck_RejectsTokensWithoutID(t *testing.T) {
+	s := setupTestStore(t)
+	check := SessionCheck(s)
+	c, _ := setupTestContext()
+	c.Request = httptest.NewRequest("GET", "/", nil)
+
+	if err := check(c, &middleware.Claims{}); err != errSessionMissing {
+		t.Errorf("Expected a token without an ID to be rejected, got %v", err)
+	}
+	claims := &middleware.Claims{}
+	claims.ID = "unknown"
+	if err := check(c, claims); err != errSessionRevoked {
+		t.Errorf("Expected an unknown session to be rejected, got %v", err)
+	}
+}
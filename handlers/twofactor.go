@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	"no-spam/apierror"
+	"no-spam/middleware"
+	"no-spam/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// totpIssuer is the account label shown in authenticator apps.
+const totpIssuer = "no-spam"
+
+// recoveryCodeCount is how many single-use recovery codes are issued when 2FA is enabled.
+const recoveryCodeCount = 10
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(sum[:])
+}
+
+// totpPeriod is the TOTP time step in seconds, the authenticator app default.
+const totpPeriod = 30
+
+// After maxTwoFactorFailures failed attempts in a row, a user gets one more
+// attempt per twoFactorLockout, until one succeeds.
+const (
+	maxTwoFactorFailures = 5
+	twoFactorLockout     = 15 * time.Minute
+)
+
+// errTwoFactorLocked is returned by checkSecondFactor while the user is
+// locked out after failed attempts.
+var errTwoFactorLocked = errors.New("Too many failed two-factor attempts, try again later")
+
+// validateTOTP returns the time step of code if it is valid at t, allowing
+// a step of clock drift either way like totp.Validate.
+func validateTOTP(code, secret string, t time.Time) (int64, bool) {
+	for _, skew := range []int64{0, -1, 1} {
+		at := t.Add(time.Duration(skew*totpPeriod) * time.Second)
+		if ok, _ := totp.ValidateCustom(code, secret, at, totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}); ok {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// checkSecondFactor validates a TOTP code, which works once, or consumes a
+// recovery code. Failed attempts are counted, and after too many it returns
+// errTwoFactorLocked for a while.
+func checkSecondFactor(c *gin.Context, s store.Store, user *store.User, code, recoveryCode string) (bool, error) {
+	if user.TOTPFailures >= maxTwoFactorFailures && time.Since(user.TOTPFailedAt) < twoFactorLockout {
+		return false, errTwoFactorLocked
+	}
+	var ok bool
+	var err error
+	switch {
+	case code != "":
+		if step, valid := validateTOTP(code, user.TOTPSecret, time.Now()); valid {
+			ok, err = s.UseTOTPStep(user.Username, step)
+		}
+	case recoveryCode != "":
+		ok, err = s.UseRecoveryCode(user.Username, hashRecoveryCode(recoveryCode))
+		if ok {
+			auditAs(c, s, user.Username, "2fa.recovery_used", user.Username, nil)
+		}
+	default:
+		return false, nil
+	}
+	if err != nil || ok {
+		return ok, err
+	}
+	if _, err := s.AddTOTPFailure(user.Username); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// EnrollTOTPHandler generates a new TOTP secret for the current user. 2FA
+// is enabled once a code from it is confirmed with VerifyTOTPHandler.
+func EnrollTOTPHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := middleware.GetUsername(c)
+		user, err := s.GetUser(username)
+		if err != nil || user == nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to load user")
+			return
+		}
+		if user.TOTPEnabled {
+			apierror.Respond(c, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+
+		key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: username})
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to generate secret")
+			return
+		}
+		img, err := key.Image(256, 256)
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to render QR code")
+			return
+		}
+		var qr bytes.Buffer
+		if err := png.Encode(&qr, img); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to render QR code")
+			return
+		}
+
+		if err := s.SetUserTOTP(username, key.Secret(), false); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to save secret")
+			return
+		}
+
+		audit(c, s, "2fa.enroll", username, nil)
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      key.Secret(),
+			"otpauth_url": key.URL(),
+			"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+		})
+	}
+}
+
+// VerifyTOTPHandler confirms enrollment with a code and returns recovery codes, shown only once.
+func VerifyTOTPHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Missing code")
+			return
+		}
+
+		username := middleware.GetUsername(c)
+		user, err := s.GetUser(username)
+		if err != nil || user == nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to load user")
+			return
+		}
+		if user.TOTPEnabled {
+			apierror.Respond(c, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		if user.TOTPSecret == "" {
+			apierror.Respond(c, http.StatusBadRequest, "Call /2fa/enroll first")
+			return
+		}
+		step, valid := validateTOTP(req.Code, user.TOTPSecret, time.Now())
+		if !valid {
+			apierror.Respond(c, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+		// The confirming code can't be used again to log in
+		if ok, err := s.UseTOTPStep(username, step); err != nil || !ok {
+			if err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to check code")
+				return
+			}
+			apierror.Respond(c, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+
+		codes := make([]string, recoveryCodeCount)
+		hashes := make([]string, recoveryCodeCount)
+		for i := range codes {
+			b := make([]byte, 5)
+			if _, err := rand.Read(b); err != nil {
+				apierror.Respond(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+				return
+			}
+			code := hex.EncodeToString(b)
+			codes[i] = code[:5] + "-" + code[5:]
+			hashes[i] = hashRecoveryCode(code)
+		}
+
+		if err := s.SetRecoveryCodes(username, hashes); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to save recovery codes")
+			return
+		}
+		if err := s.SetUserTOTP(username, user.TOTPSecret, true); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+			return
+		}
+
+		audit(c, s, "2fa.enable", username, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+	}
+}
+
+// DisableTOTPHandler turns off 2FA for the current user; it needs a current code or a recovery code.
+func DisableTOTPHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		username := middleware.GetUsername(c)
+		user, err := s.GetUser(username)
+		if err != nil || user == nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to load user")
+			return
+		}
+		if !user.TOTPEnabled {
+			apierror.Respond(c, http.StatusConflict, "Two-factor authentication is not enabled")
+			return
+		}
+
+		ok, err := checkSecondFactor(c, s, user, req.Code, req.RecoveryCode)
+		if err == errTwoFactorLocked {
+			apierror.Respond(c, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to check code")
+			return
+		}
+		if !ok {
+			apierror.Respond(c, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+
+		if err := s.SetUserTOTP(username, "", false); err != nil {
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+			return
+		}
+
+		audit(c, s, "2fa.disable", username, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
+
+// ResetTOTPHandler lets an admin remove 2FA from a user who lost their device and recovery codes.
+func ResetTOTPHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := c.Param("username")
+		if err := s.SetUserTOTP(username, "", false); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				apierror.Respond(c, http.StatusNotFound, "User not found")
+				return
+			}
+			apierror.Respond(c, http.StatusInternalServerError, "Failed to reset two-factor authentication")
+			return
+		}
+
+		audit(c, s, "2fa.reset", username, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+)
+
+func TestTwoFactorFlow(t *testing.T) {
+	s := setupTestStore(t)
+
+	call := func(handler gin.HandlerFunc, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		c, w := setupTestContext()
+		c.Set("username", "testadmin")
+		c.Request = httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler(c)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+	login := func(extra string) int {
+		w, _ := call(LoginHandler(s), `{"username": "testadmin", "password": "password123"`+extra+`}`)
+		return w.Code
+	}
+
+	w, resp := call(EnrollTOTPHandler(s), `{}`)
+	if w.Code != http.StatusOK || resp["qr_code"] == nil {
+		t.Fatalf("Expected enrollment, got %d: %s", w.Code, w.Body.String())
+	}
+	secret := resp["secret"].(string)
+
+	// Not enforced until verified
+	if code := login(""); code != http.StatusOK {
+		t.Errorf("Expected login before verification, got %d", code)
+	}
+
+	if w, _ := call(VerifyTOTPHandler(s), `{"code": "000000"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for wrong code, got %d", w.Code)
+	}
+	otp, _ := totp.GenerateCode(secret, time.Now())
+	w, resp = call(VerifyTOTPHandler(s), `{"code": "`+otp+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 2FA enabled, got %d: %s", w.Code, w.Body.String())
+	}
+	recovery := resp["recovery_codes"].([]interface{})
+	if len(recovery) != recoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", recoveryCodeCount, len(recovery))
+	}
+
+	if code := login(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without code, got %d", code)
+	}
+	if code := login(`, "otp": "000000"`); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with wrong code, got %d", code)
+	}
+	// Each code works once: the one confirming enrollment is spent
+	if code := login(`, "otp": "` + otp + `"`); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 replaying the enrollment code, got %d", code)
+	}
+	next, _ := totp.GenerateCode(secret, time.Now().Add(totpPeriod*time.Second))
+	if code := login(`, "otp": "` + next + `"`); code != http.StatusOK {
+		t.Errorf("Expected 200 with code, got %d", code)
+	}
+	if code := login(`, "otp": "` + next + `"`); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 replaying a code, got %d", code)
+	}
+	if code := login(`, "recovery_code": "` + recovery[0].(string) + `"`); code != http.StatusOK {
+		t.Errorf("Expected 200 with recovery code, got %d", code)
+	}
+	if code := login(`, "recovery_code": "` + recovery[0].(string) + `"`); code != http.StatusUnauthorized {
+		t.Errorf("Expected recovery code to be single use, got %d", code)
+	}
+
+	if w, _ := call(DisableTOTPHandler(s), `{"recovery_code": "`+recovery[1].(string)+`"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected 2FA disabled, got %d", w.Code)
+	}
+	if code := login(""); code != http.StatusOK {
+		t.Errorf("Expected login without code after disabling, got %d", code)
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	s := setupTestStore(t)
+	key, _ := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "testadmin"})
+	s.SetUserTOTP("testadmin", key.Secret(), true)
+
+	login := func(otp string) *httptest.ResponseRecorder {
+		c, w := setupTestContext()
+		c.Request = httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"username": "testadmin", "password": "password123", "otp": "`+otp+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		LoginHandler(s)(c)
+		return w
+	}
+
+	for i := 0; i < maxTwoFactorFailures; i++ {
+		if w := login("000000"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for wrong code %d, got %d", i+1, w.Code)
+		}
+	}
+	otp, _ := totp.GenerateCode(key.Secret(), time.Now())
+	if w := login(otp); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 once locked out, even with a valid code, got %d: %s", w.Code, w.Body.String())
+	}
+
+	c, w := setupTestContext()
+	c.Set("username", "testadmin")
+	c.Request = httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"code": "`+otp+`"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	DisableTOTPHandler(s)(c)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected disabling 2FA locked out too, got %d", w.Code)
+	}
+
+	// Once the lockout passed, a valid code gets in and clears the failures
+	user, _ := s.GetUser("testadmin")
+	user.TOTPFailedAt = time.Now().Add(-twoFactorLockout)
+	if ok, err := checkSecondFactor(c, s, user, otp, ""); err != nil || !ok {
+		t.Errorf("Expected the code accepted after the lockout, got %v, %v", ok, err)
+	}
+	if user, _ := s.GetUser("testadmin"); user.TOTPFailures != 0 {
+		t.Errorf("Expected the failures cleared, got %d", user.TOTPFailures)
+	}
+}
@@ -0,0 +1,95 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"no-spam/anomaly"
+	"no-spam/events"
+)
+
+// anomalySource marks alert messages so they bypass detection.
+const anomalySource = "anomaly"
+
+// SetAnomalyDetector enables burst detection on topic publishes. When
+// alertTopic is set, an alert notification is published to it each time a
+// publisher/topic pair trips.
+func (h *Hub) SetAnomalyDetector(d *anomaly.Detector, alertTopic string) {
+	h.mu.Lock()
+	h.anomaly = d
+	h.mu.Unlock()
+
+	d.OnTrip(func(ev anomaly.Event) {
+		log.Printf("[Anomaly] %s on topic %s: %d sends vs baseline %.1f, %s", ev.Publisher, ev.Topic, ev.Count, ev.Baseline, ev.Mode)
+		events.Emit(events.QuotaExceeded, "", map[string]any{
+			"publisher": ev.Publisher,
+			"topic":     ev.Topic,
+			"count":     ev.Count,
+			"baseline":  ev.Baseline,
+			"mode":      ev.Mode,
+		})
+		if alertTopic == "" || alertTopic == ev.Topic {
+			return
+		}
+		go h.publishAnomalyAlert(alertTopic, ev)
+	})
+}
+
+// AnomalyDetector returns the configured detector, or nil.
+func (h *Hub) AnomalyDetector() *anomaly.Detector {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.anomaly
+}
+
+// publisherKey identifies who published msg for detection purposes.
+func publisherKey(msg Message) string {
+	if msg.Publisher != "" {
+		return msg.Publisher
+	}
+	if msg.Source != "" {
+		return "source:" + msg.Source
+	}
+	return "anonymous"
+}
+
+func (h *Hub) checkAnomaly(msg Message) error {
+	d := h.AnomalyDetector()
+	if d == nil || msg.Source == anomalySource || msg.Source == eventsSource || msg.Source == sloSource {
+		return nil
+	}
+	return d.Allow(publisherKey(msg), msg.Topic)
+}
+
+func (h *Hub) publishAnomalyAlert(topic string, ev anomaly.Event) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"notification": map[string]string{
+			"title":    "Publish burst detected",
+			"body":     fmt.Sprintf("%s sent %d messages to %s (baseline %.1f); sends %s.", ev.Publisher, ev.Count, ev.Topic, ev.Baseline, modeVerb(ev.Mode)),
+			"priority": "high",
+		},
+		"data": map[string]string{
+			"publisher": ev.Publisher,
+			"topic":     ev.Topic,
+			"count":     strconv.Itoa(ev.Count),
+			"baseline":  strconv.FormatFloat(ev.Baseline, 'f', 1, 64),
+			"mode":      ev.Mode,
+		},
+	})
+	if err != nil {
+		return
+	}
+	if err := h.Route(context.Background(), Message{Topic: topic, Payload: payload, Source: anomalySource}); err != nil {
+		log.Printf("[Anomaly] Failed to publish alert to %s: %v", topic, err)
+	}
+}
+
+func modeVerb(mode string) string {
+	if mode == anomaly.ModeQuarantine {
+		return "quarantined"
+	}
+	return "throttled"
+}
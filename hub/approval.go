@@ -0,0 +1,244 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"no-spam/segment"
+	"no-spam/store"
+)
+
+// ErrApprovalNotFound is returned when approving or rejecting a message
+// that isn't awaiting approval.
+var ErrApprovalNotFound = errors.New("message is not awaiting approval")
+
+// PendingApprovalError is returned by Route when a topic send reaches the
+// topic's approval threshold, or the large-send threshold. The message is stored but not fanned out
+// until an admin approves it.
+type PendingApprovalError struct {
+	MessageID int64
+	Audience  int
+}
+
+func (e *PendingApprovalError) Error() string {
+	return fmt.Sprintf("message %d to %d subscribers is awaiting approval", e.MessageID, e.Audience)
+}
+
+// heldRequest is what fan-out needs once a held message is approved.
+// Subscribers are resolved again at approval time.
+type heldRequest struct {
+	Payload   json.RawMessage            `json:"payload"`
+	Variants  map[string]json.RawMessage `json:"variants,omitempty"`
+	Segment   string                     `json:"segment,omitempty"`
+	Publisher string                     `json:"publisher,omitempty"`
+	Source    string                     `json:"source,omitempty"`
+	Callback  string                     `json:"callback_url,omitempty"`
+	From      string                     `json:"from,omitempty"` // Publisher shown in delivered payloads
+}
+
+// SetApprovalThreshold requires approval for sends reaching at least
+// threshold subscribers. 0 disables approval.
+func (h *Hub) SetApprovalThreshold(topic string, threshold int) error {
+	exists, err := h.store.TopicExists(topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTopicNotFound
+	}
+	return h.store.SetTopicApprovalThreshold(topic, threshold)
+}
+
+func (h *Hub) GetApprovalThreshold(topic string) (int, error) {
+	exists, err := h.store.TopicExists(topic)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrTopicNotFound
+	}
+	return h.store.GetTopicApprovalThreshold(topic)
+}
+
+func (h *Hub) ListApprovals(status string) ([]store.Approval, error) {
+	return h.store.ListApprovals(status)
+}
+
+// SetLargeSendThreshold requires approval for sends to more than n
+// subscribers on every topic, unless the publisher may make large sends.
+// 0 disables it.
+func (h *Hub) SetLargeSendThreshold(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.largeSend = n
+}
+
+// LargeSendThreshold returns the large-send threshold; 0 means disabled.
+func (h *Hub) LargeSendThreshold() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.largeSend
+}
+
+// needsApproval reports whether a send to audience subscribers must be
+// approved first: when it reaches the topic threshold, or exceeds the
+// large-send threshold without msg.LargeSend.
+func (h *Hub) needsApproval(msg Message, audience int) (bool, error) {
+	if large := h.LargeSendThreshold(); large > 0 && audience > large && !msg.LargeSend {
+		return true, nil
+	}
+	threshold, err := h.store.GetTopicApprovalThreshold(msg.Topic)
+	if err != nil {
+		return false, fmt.Errorf("failed to get approval threshold: %v", err)
+	}
+	return threshold > 0 && audience >= threshold, nil
+}
+
+// holdForApproval saves msg, wrapped in envelope, and stores it for review
+// when needsApproval says so. It returns nil when the message can be sent
+// now. Held messages are numbered once approved.
+func (h *Hub) holdForApproval(msg Message, envelope store.Notification, variants map[string][]byte, audience int) (*PendingApprovalError, error) {
+	hold, err := h.needsApproval(msg, audience)
+	if err != nil || !hold {
+		return nil, err
+	}
+	wrapped, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notification envelope: %v", err)
+	}
+	msgID, err := h.store.SaveMessageFrom(msg.Topic, wrapped, messageOrigin(msg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %v", err)
+	}
+
+	req := heldRequest{
+		Payload:   msg.Payload,
+		Segment:   msg.Segment,
+		Publisher: msg.Publisher,
+		Source:    msg.Source,
+		Callback:  msg.CallbackURL,
+	}
+	if msg.IncludeFrom {
+		req.From = msg.Publisher
+	}
+	if len(variants) > 0 {
+		req.Variants = make(map[string]json.RawMessage, len(variants))
+		for locale, v := range variants {
+			req.Variants[locale] = v
+		}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal held message: %v", err)
+	}
+
+	err = h.store.HoldMessage(store.Approval{
+		MessageID: msgID,
+		Topic:     msg.Topic,
+		Publisher: publisherKey(msg),
+		Audience:  audience,
+		Request:   data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hold message: %v", err)
+	}
+	log.Printf("[Approval] Holding message %d to %s for %d subscribers", msgID, msg.Topic, audience)
+	return &PendingApprovalError{MessageID: msgID, Audience: audience}, nil
+}
+
+// ApproveMessage releases a held message and fans it out to the topic's
+// current matching subscribers. It returns how many were targeted.
+func (h *Hub) ApproveMessage(ctx context.Context, messageID int64, admin string) (int, error) {
+	a, err := h.store.DecideApproval(messageID, store.ApprovalApproved, admin)
+	if err != nil {
+		return 0, err
+	}
+	if a == nil {
+		return 0, ErrApprovalNotFound
+	}
+
+	var req heldRequest
+	if err := json.Unmarshal(a.Request, &req); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal held message: %v", err)
+	}
+	var seg segment.Expr
+	if req.Segment != "" {
+		if seg, err = segment.Parse(req.Segment); err != nil {
+			return 0, err
+		}
+	}
+	variants := make(map[string][]byte, len(req.Variants))
+	for locale, v := range req.Variants {
+		variants[locale] = v
+	}
+
+	// Keep the delivery ID stored with the message, which resends carry
+	// too, and number the message now that it is released. Messages held
+	// before they were numbered on release keep their number.
+	envelope := store.Notification{Topic: a.Topic, From: req.From, Payload: req.Payload}
+	stored, _ := h.store.GetMessage(messageID)
+	if stored != nil {
+		var n store.Notification
+		json.Unmarshal(stored.Payload, &n)
+		envelope.DeliveryID, envelope.Seq = n.DeliveryID, n.Seq
+	}
+	if envelope.DeliveryID == "" {
+		envelope.DeliveryID = newDeliveryID()
+	}
+	var wrapped []byte
+	if stored != nil && envelope.Seq == 0 {
+		err = h.store.SequenceMessage(messageID, func(seq int64) (_ []byte, err error) {
+			envelope.Seq = seq
+			wrapped, err = json.Marshal(envelope)
+			return wrapped, err
+		})
+	} else {
+		wrapped, err = json.Marshal(envelope)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to number message: %v", err)
+	}
+	subscribers, err := h.audience(a.Topic, seg)
+	if err != nil {
+		return 0, err
+	}
+	subscribers, err = h.applyPreferences(a.Topic, req.Payload, subscribers, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[Approval] %s approved message %d to %s", admin, messageID, a.Topic)
+	h.runPublishHooks(Message{
+		Topic:     a.Topic,
+		Payload:   req.Payload,
+		Segment:   req.Segment,
+		Publisher: req.Publisher,
+		Source:    req.Source,
+	}, messageID)
+	h.fanOut(ctx, a.Topic, messageID, wrapped, variants, subscribers)
+	if req.Callback != "" {
+		go h.watchCallback(req.Callback, a.Topic, messageID)
+	}
+	return len(subscribers), nil
+}
+
+// RejectMessage discards a held message without delivering it.
+func (h *Hub) RejectMessage(messageID int64, admin string) error {
+	a, err := h.store.DecideApproval(messageID, store.ApprovalRejected, admin)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return ErrApprovalNotFound
+	}
+	log.Printf("[Approval] %s rejected message %d to %s", admin, messageID, a.Topic)
+	var req heldRequest
+	if json.Unmarshal(a.Request, &req) == nil && req.Callback != "" {
+		go h.sendCallback(req.Callback, &CallbackReport{MessageID: messageID, Topic: a.Topic, Status: CallbackRejected, FailedTokens: []string{}})
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package hub
+
+import "no-spam/store"
+
+// AddAuditEvent appends an event to the audit log.
+func (h *Hub) AddAuditEvent(e store.AuditEvent) error {
+	return h.store.AddAuditEvent(e)
+}
+
+func (h *Hub) ListAuditEvents(f store.AuditFilter) ([]store.AuditEvent, error) {
+	return h.store.ListAuditEvents(f)
+}
@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"no-spam/notification"
+	"no-spam/store"
+)
+
+// stampUnread increments, once per user, the unread counter of the users
+// whose payload is an "unread" notification, and sets the badge of those
+// payloads to the new counter. payloads[i] and entries[i] belong to subs[i].
+// Subscriptions without a user keep the payload as published.
+func (h *Hub) stampUnread(subs []store.Subscriber, entries []store.QueueEntry, payloads [][]byte) {
+	unread := map[string]bool{} // Keyed by payload, so each is parsed once
+	counted := map[string]bool{}
+	var usernames []string
+	for i, sub := range subs {
+		if sub.Username == "" {
+			continue
+		}
+		u, ok := unread[string(payloads[i])]
+		if !ok {
+			u = isUnread(payloads[i])
+			unread[string(payloads[i])] = u
+		}
+		if u && !counted[sub.Username] {
+			counted[sub.Username] = true
+			usernames = append(usernames, sub.Username)
+		}
+	}
+	if len(usernames) == 0 {
+		return
+	}
+
+	counts, err := h.store.IncrementUnread(usernames)
+	if err != nil {
+		log.Printf("[Queue] Failed to count unread notifications of %d users: %v", len(usernames), err)
+		return
+	}
+	stamped := map[string][]byte{}
+	for i, sub := range subs {
+		n, ok := counts[sub.Username]
+		if !ok || !unread[string(payloads[i])] {
+			continue
+		}
+		key := fmt.Sprintf("%d:%s", n, payloads[i])
+		p, ok := stamped[key]
+		if !ok {
+			p = withBadge(payloads[i], n)
+			stamped[key] = p
+		}
+		payloads[i], entries[i].Payload = p, p
+	}
+}
+
+// isUnread reports whether a wrapped payload is a notification counting
+// toward the unread counter.
+func isUnread(wrapped []byte) bool {
+	var envelope store.Notification
+	if err := json.Unmarshal(wrapped, &envelope); err != nil {
+		return false
+	}
+	p, err := notification.Parse(envelope.Payload)
+	return err == nil && p != nil && p.Notification.Unread
+}
+
+// withBadge replaces unread with a badge in the notification of a wrapped
+// payload, keeping every other field as published.
+func withBadge(wrapped []byte, badge int) []byte {
+	var envelope store.Notification
+	if err := json.Unmarshal(wrapped, &envelope); err != nil {
+		return wrapped
+	}
+	var payload map[string]json.RawMessage
+	var n map[string]json.RawMessage
+	if json.Unmarshal(envelope.Payload, &payload) != nil || json.Unmarshal(payload["notification"], &n) != nil {
+		return wrapped
+	}
+	delete(n, "unread")
+	n["badge"], _ = json.Marshal(badge)
+	var err error
+	if payload["notification"], err = json.Marshal(n); err != nil {
+		return wrapped
+	}
+	if envelope.Payload, err = json.Marshal(payload); err != nil {
+		return wrapped
+	}
+	stamped, err := json.Marshal(envelope)
+	if err != nil {
+		return wrapped
+	}
+	return stamped
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"no-spam/notification"
+	"no-spam/store"
+)
+
+func TestUnreadBadge(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mock := NewMockConnector()
+	h.RegisterConnector("mock", mock)
+	h.CreateTopic("news")
+	mockStore.CreateUser("alice", "hash", "subscriber")
+	mockStore.CreateUser("bob", "hash", "subscriber")
+	mockStore.SetUnread("bob", 4)
+	_ = h.Subscribe("news", store.Subscriber{Token: "alice-phone", Provider: "mock", Username: "alice"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "alice-tablet", Provider: "mock", Username: "alice"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "bob-phone", Provider: "mock", Username: "bob"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "anonymous", Provider: "mock"})
+
+	send := func(payload string) map[string]*int {
+		mock.SentMessages = nil
+		if _, err := h.SendSync(context.Background(), Message{Topic: "news", Payload: json.RawMessage(payload)}); err != nil {
+			t.Fatal(err)
+		}
+		badges := map[string]*int{}
+		for _, m := range mock.SentMessages {
+			var envelope store.Notification
+			json.Unmarshal(m.Payload, &envelope)
+			p, err := notification.Parse(envelope.Payload)
+			if err != nil || p == nil {
+				t.Fatalf("Expected a notification for %s, got %s", m.Token, envelope.Payload)
+			}
+			badges[m.Token] = p.Notification.Badge
+		}
+		return badges
+	}
+
+	badges := send(`{"notification": {"title": "Hi", "unread": true}, "data": {"id": "1"}}`)
+	for token, want := range map[string]int{"alice-phone": 1, "alice-tablet": 1, "bob-phone": 5} {
+		if badges[token] == nil || *badges[token] != want {
+			t.Errorf("Expected badge %d for %s, got %v", want, token, badges[token])
+		}
+	}
+	if badges["anonymous"] != nil {
+		t.Errorf("Expected no badge without a user, got %d", *badges["anonymous"])
+	}
+	if u, _ := mockStore.GetUser("alice"); u.Unread != 1 {
+		t.Errorf("Expected alice's counter incremented once for both devices, got %d", u.Unread)
+	}
+
+	if badges := send(`{"notification": {"title": "Hi"}}`); badges["alice-phone"] != nil {
+		t.Errorf("Expected no badge without unread, got %d", *badges["alice-phone"])
+	}
+	if u, _ := mockStore.GetUser("alice"); u.Unread != 1 {
+		t.Errorf("Expected the counter kept, got %d", u.Unread)
+	}
+}
@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// benchSubscribers is the audience of the fan-out benchmarks.
+const benchSubscribers = 10000
+
+// benchConnector counts sends.
+type benchConnector struct {
+	sent atomic.Int64
+}
+
+func (c *benchConnector) Send(ctx context.Context, token string, payload []byte) error {
+	c.sent.Add(1)
+	return nil
+}
+
+// benchBatchConnector also sends batches.
+type benchBatchConnector struct {
+	benchConnector
+}
+
+func (c *benchBatchConnector) SendBatch(ctx context.Context, tokens []string, payload []byte) []error {
+	c.sent.Add(int64(len(tokens)))
+	return make([]error, len(tokens))
+}
+
+// setupBenchHub returns a hub on a file-backed SQLite store, like
+// production, with benchSubscribers subscribers to "news" through the
+// "bench" provider.
+func setupBenchHub(b *testing.B) *Hub {
+	b.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+
+	s, err := store.NewSQLiteStore(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := s.CreateTopic("news"); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchSubscribers; i++ {
+		if err := s.AddSubscription("news", fmt.Sprintf("token-%d", i), "bench", "reader"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return NewHub(s)
+}
+
+// waitDrained waits until no delivery is pending.
+func waitDrained(b *testing.B, h *Hub) {
+	b.Helper()
+	for {
+		n, err := h.store.CountPending("")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// BenchmarkRoute publishes to benchSubscribers subscribers and waits until
+// every delivery is marked delivered.
+func BenchmarkRoute(b *testing.B) {
+	conns := map[string]connectors.Connector{
+		"single":  &benchConnector{},
+		"batched": &benchBatchConnector{},
+	}
+	for _, name := range []string{"single", "batched"} {
+		b.Run(name, func(b *testing.B) {
+			h := setupBenchHub(b)
+			h.RegisterConnector("bench", conns[name])
+			msg := Message{Topic: "news", Payload: []byte(`{"notification":{"title":"Hi"}}`)}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := h.Route(context.Background(), msg); err != nil {
+					b.Fatal(err)
+				}
+				waitDrained(b, h)
+			}
+			b.ReportMetric(float64(b.N*benchSubscribers)/b.Elapsed().Seconds(), "deliveries/s")
+		})
+	}
+}
+
+// BenchmarkProcessQueue retries benchSubscribers pending deliveries.
+func BenchmarkProcessQueue(b *testing.B) {
+	h := setupBenchHub(b)
+	h.RegisterConnector("bench", &benchBatchConnector{})
+	entries := make([]store.QueueEntry, benchSubscribers)
+	for i := range entries {
+		entries[i].Token = fmt.Sprintf("token-%d", i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		msgID, err := h.store.SaveMessage("news", []byte(`{"topic":"news","payload":{}}`))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := h.store.EnqueueMessages(msgID, entries); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		h.processQueue()
+	}
+	b.ReportMetric(float64(b.N*benchSubscribers)/b.Elapsed().Seconds(), "deliveries/s")
+}
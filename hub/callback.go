@@ -0,0 +1,200 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// ErrInvalidCallback is returned by Route for a callback URL it can't use.
+var ErrInvalidCallback = errors.New("invalid callback_url")
+
+// Callback report statuses
+const (
+	CallbackComplete = "complete"  // Every delivery succeeded or failed for good
+	CallbackTimedOut = "timed_out" // Some deliveries were still pending at the deadline
+	CallbackRejected = "rejected"  // The message was rejected in approval and not sent
+)
+
+// CallbackReport is POSTed to a send's callback URL once its fan-out is over.
+type CallbackReport struct {
+	MessageID    int64    `json:"message_id"`
+	Topic        string   `json:"topic"`
+	Status       string   `json:"status"`
+	Total        int      `json:"total"`
+	Delivered    int      `json:"delivered"`
+	Failed       int      `json:"failed"`
+	Pending      int      `json:"pending"`
+	Canceled     int      `json:"canceled"` // Canceled by an admin
+	FailedTokens []string `json:"failed_tokens"`
+}
+
+var (
+	// callbackPollInterval is how often the queue is checked for a send's progress.
+	callbackPollInterval = 5 * time.Second
+	// callbackTimeout bounds how long a report waits for pending deliveries.
+	callbackTimeout = time.Hour
+)
+
+// callbackRetries is how many times a failed report POST is retried.
+const callbackRetries = 2
+
+// checkCallback validates a send's callback URL against the webhook policy.
+func (h *Hub) checkCallback(ctx context.Context, msg Message) error {
+	if msg.CallbackURL == "" {
+		return nil
+	}
+	if msg.Topic == "" {
+		return fmt.Errorf("%w: callbacks require a topic", ErrInvalidCallback)
+	}
+	u, err := url.Parse(msg.CallbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: expected an http or https URL", ErrInvalidCallback)
+	}
+	if err := h.ValidateTarget(ctx, "webhook", msg.CallbackURL); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+	return nil
+}
+
+// watchCallback reports on a message's deliveries to callbackURL once none
+// is pending, or when callbackTimeout expires.
+func (h *Hub) watchCallback(callbackURL, topic string, msgID int64) {
+	deadline := time.Now().Add(callbackTimeout)
+	for {
+		report, err := h.callbackReport(topic, msgID)
+		if err != nil {
+			log.Printf("[Callback] Failed to check deliveries of message %d: %v", msgID, err)
+		} else if report.Pending == 0 {
+			report.Status = CallbackComplete
+			h.sendCallback(callbackURL, report)
+			return
+		}
+		if time.Now().After(deadline) {
+			if report == nil {
+				return
+			}
+			report.Status = CallbackTimedOut
+			h.sendCallback(callbackURL, report)
+			return
+		}
+		time.Sleep(callbackPollInterval)
+	}
+}
+
+func (h *Hub) callbackReport(topic string, msgID int64) (*CallbackReport, error) {
+	items, err := h.store.GetQueueItemsByMessage(msgID)
+	if err != nil {
+		return nil, err
+	}
+	return summarize(topic, msgID, items), nil
+}
+
+func summarize(topic string, msgID int64, items []store.QueueItem) *CallbackReport {
+	r := &CallbackReport{MessageID: msgID, Topic: topic, Total: len(items), FailedTokens: []string{}}
+	for _, item := range items {
+		switch item.Status {
+		case "delivered":
+			r.Delivered++
+		case "failed":
+			r.Failed++
+			r.FailedTokens = append(r.FailedTokens, item.Token)
+		case "canceled":
+			r.Canceled++
+		default:
+			r.Pending++
+		}
+	}
+	return r
+}
+
+// StatusInProgress is the MessageStatus of a message with pending deliveries.
+const StatusInProgress = "in_progress"
+
+// ErrMessageNotFound is returned by MessageStatus for an unknown message.
+var ErrMessageNotFound = errors.New("message not found")
+
+// MessageReport is the status of a message: its callback report, when it
+// was sent and how each of its deliveries went.
+type MessageReport struct {
+	CallbackReport
+	Opened     int        `json:"opened"`    // Deliveries opened on the device
+	OpenRate   float64    `json:"open_rate"` // Opened over delivered
+	CreatedAt  time.Time  `json:"created_at"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// Delivery is the state of one queue item of a message.
+type Delivery struct {
+	QueueID     int64      `json:"queue_id"`
+	Token       string     `json:"token"`
+	Status      string     `json:"status"`
+	QueuedAt    time.Time  `json:"queued_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	OpenedAt    *time.Time `json:"opened_at,omitempty"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// MessageStatus reports the deliveries of a stored topic message so far.
+func (h *Hub) MessageStatus(msgID int64) (*MessageReport, error) {
+	msg, err := h.store.GetMessage(msgID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+	items, err := h.store.GetQueueItemsByMessage(msgID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &MessageReport{
+		CallbackReport: *summarize(msg.Topic, msgID, items),
+		CreatedAt:      msg.CreatedAt,
+		Deliveries:     make([]Delivery, 0, len(items)),
+	}
+	r.Status = CallbackComplete
+	if r.Pending > 0 {
+		r.Status = StatusInProgress
+	}
+	for _, item := range items {
+		if item.OpenedAt != nil {
+			r.Opened++
+		}
+		r.Deliveries = append(r.Deliveries, Delivery{
+			QueueID:     item.ID,
+			Token:       item.Token,
+			Status:      item.Status,
+			QueuedAt:    item.CreatedAt,
+			DeliveredAt: item.DeliveredAt,
+			OpenedAt:    item.OpenedAt,
+			Attempts:    item.Attempts,
+			LastError:   item.LastError,
+		})
+	}
+	r.OpenRate = openRate(r.Opened, r.Delivered)
+	return r, nil
+}
+
+// sendCallback POSTs a report with the webhook connector.
+func (h *Hub) sendCallback(callbackURL string, r *CallbackReport) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = connectors.WithWebhookOptions(ctx, &store.WebhookOptions{MaxRetries: callbackRetries})
+	if err := (webhookSender{h}).Send(ctx, callbackURL, body); err != nil {
+		log.Printf("[Callback] Failed to report message %d to %s: %v", r.MessageID, callbackURL, err)
+	}
+}
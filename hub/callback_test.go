@@ -0,0 +1,68 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"no-spam/connectors"
+)
+
+func TestRoute_Callback(t *testing.T) {
+	reports := make(chan CallbackReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report CallbackReport
+		json.NewDecoder(r.Body).Decode(&report)
+		reports <- report
+	}))
+	defer server.Close()
+
+	callbackPollInterval = 10 * time.Millisecond
+	defer func() { callbackPollInterval = 5 * time.Second }()
+
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("webhook", connectors.NewWebhookConnector())
+	h.RegisterConnector("mock", NewMockConnector())
+	h.RegisterConnector("gone", permanentConnector{})
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "ok-token", "mock", "alice")
+	mockStore.AddSubscription("news", "bad-token", "gone", "bob")
+
+	err := h.Route(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":1}`), CallbackURL: server.URL})
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	select {
+	case r := <-reports:
+		if r.Status != CallbackComplete || r.Total != 2 || r.Delivered != 1 || r.Failed != 1 || r.Pending != 0 {
+			t.Errorf("Unexpected report %+v", r)
+		}
+		if len(r.FailedTokens) != 1 || r.FailedTokens[0] != "bad-token" {
+			t.Errorf("Expected bad-token to be reported as failed, got %v", r.FailedTokens)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the callback")
+	}
+}
+
+func TestRoute_InvalidCallback(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+
+	for _, msg := range []Message{
+		{Topic: "news", Payload: json.RawMessage(`{}`), CallbackURL: "ftp://example.com/report"},
+		{Provider: "mock", Token: "t", Payload: json.RawMessage(`{}`), CallbackURL: "https://example.com/report"},
+	} {
+		if err := h.Route(context.Background(), msg); !errors.Is(err, ErrInvalidCallback) {
+			t.Errorf("Expected ErrInvalidCallback for %+v, got %v", msg, err)
+		}
+	}
+}
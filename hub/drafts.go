@@ -0,0 +1,323 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/segment"
+	"no-spam/store"
+)
+
+var (
+	// ErrDraftNotFound is returned for operations on an unknown draft.
+	ErrDraftNotFound = errors.New("draft not found")
+	// ErrDraftPublished is returned when changing or publishing a draft that
+	// was published, or is being published.
+	ErrDraftPublished = errors.New("draft already published")
+	// ErrInvalidDraft is returned for a draft that can't be saved or tested
+	// as requested.
+	ErrInvalidDraft = errors.New("invalid draft")
+	// ErrTestSendFailed is returned when the connector fails to deliver a
+	// test send of a draft.
+	ErrTestSendFailed = errors.New("test send failed")
+)
+
+// DraftPreview shows what publishing a draft would send.
+type DraftPreview struct {
+	Payload   json.RawMessage            `json:"payload"`           // Rendered payload, in the requested locale if it has a variant
+	Locales   []string                   `json:"locales,omitempty"` // Locales with a variant of the payload
+	Providers map[string]json.RawMessage `json:"providers"`         // What each connector would send
+	Errors    map[string]string          `json:"errors,omitempty"`  // Connectors that couldn't render it
+	Audience  int                        `json:"audience"`          // Subscribers it would reach now
+}
+
+// CreateDraft validates and stores a draft of msg, to be previewed, tested
+// and published later. The message is checked like a send, but templates
+// render again on each preview, test and publish.
+func (h *Hub) CreateDraft(ctx context.Context, creator string, msg Message) (*store.Draft, error) {
+	if msg.Topic == "" || msg.Token != "" {
+		return nil, fmt.Errorf("%w: drafts publish to a topic", ErrInvalidDraft)
+	}
+	topic, err := h.resolveTopic(msg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	msg.Topic = topic
+	if err := h.checkStoredMessage(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	data, err := storedRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	id, err := h.store.CreateDraft(store.Draft{Topic: topic, Request: data, CreatedBy: creator})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrTopicNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.GetDraft(id)
+}
+
+func (h *Hub) GetDraft(id int64) (*store.Draft, error) {
+	d, err := h.store.GetDraft(id)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrDraftNotFound
+	}
+	return d, nil
+}
+
+// ListDrafts lists the drafts of a topic, or of every topic if topic is "".
+func (h *Hub) ListDrafts(topic string) ([]store.Draft, error) {
+	if topic != "" {
+		resolved, err := h.resolveTopic(topic)
+		if err != nil {
+			return nil, err
+		}
+		topic = resolved
+	}
+	return h.store.ListDrafts(topic)
+}
+
+// UpdateDraft replaces the message of a draft that wasn't published. The
+// draft stays on its topic.
+func (h *Hub) UpdateDraft(ctx context.Context, id int64, msg Message) (*store.Draft, error) {
+	d, err := h.GetDraft(id)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != store.DraftOpen {
+		return nil, ErrDraftPublished
+	}
+	if msg.Token != "" {
+		return nil, fmt.Errorf("%w: drafts publish to a topic", ErrInvalidDraft)
+	}
+	if msg.Topic != "" {
+		if topic, err := h.resolveTopic(msg.Topic); err != nil || topic != d.Topic {
+			return nil, fmt.Errorf("%w: a draft can't move to another topic", ErrInvalidDraft)
+		}
+	}
+	msg.Topic = d.Topic
+	if err := h.checkStoredMessage(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	data, err := storedRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := h.store.UpdateDraft(id, data)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDraftPublished // Or deleted meanwhile
+	}
+	return h.GetDraft(id)
+}
+
+func (h *Hub) DeleteDraft(id int64) error {
+	ok, err := h.store.DeleteDraft(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+// draftMessage returns the message of a draft, rendered and checked like a
+// send, with its segment and localized variants.
+func (h *Hub) draftMessage(ctx context.Context, d *store.Draft) (Message, segment.Expr, map[string][]byte, error) {
+	var msg Message
+	if err := json.Unmarshal(d.Request, &msg); err != nil {
+		return msg, nil, nil, fmt.Errorf("invalid draft message: %v", err)
+	}
+	msg.Topic = d.Topic
+	return h.prepareTopicMessage(ctx, msg)
+}
+
+// PreviewDraft renders a draft as it would be published now, in locale if
+// the draft has a variant for it, as each registered connector would send
+// it. Nothing is sent or stored.
+func (h *Hub) PreviewDraft(ctx context.Context, id int64, locale string) (*DraftPreview, error) {
+	d, err := h.GetDraft(id)
+	if err != nil {
+		return nil, err
+	}
+	msg, seg, variants, err := h.draftMessage(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := msg.Payload
+	if v := pickVariant(variants, locale); v != nil {
+		payload = v
+	}
+	envelope := store.Notification{Topic: d.Topic, Payload: payload}
+	if msg.IncludeFrom {
+		envelope.From = d.CreatedBy
+	}
+	wrapped, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal notification envelope: %v", err)
+	}
+
+	preview := &DraftPreview{Payload: payload, Providers: map[string]json.RawMessage{}}
+	for l := range variants {
+		preview.Locales = append(preview.Locales, l)
+	}
+	slices.Sort(preview.Locales)
+
+	h.mu.RLock()
+	conns := make(map[string]connectors.Connector, len(h.connectors))
+	for name, c := range h.connectors {
+		conns[name] = c
+	}
+	h.mu.RUnlock()
+	for name, c := range conns {
+		p, ok := connectorAs[connectors.Previewer](c)
+		if !ok {
+			continue
+		}
+		out, err := p.Preview(wrapped)
+		if err != nil {
+			if preview.Errors == nil {
+				preview.Errors = map[string]string{}
+			}
+			preview.Errors[name] = err.Error()
+			continue
+		}
+		preview.Providers[name] = out
+	}
+
+	subscribers, err := h.audience(d.Topic, seg)
+	if err != nil {
+		return nil, err
+	}
+	subscribers, err = h.applyPreferences(d.Topic, msg.Payload, subscribers, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	preview.Audience = len(subscribers)
+	return preview, nil
+}
+
+// TestDraft sends a draft to a single device, through provider, or through
+// the provider of the device's subscription to the draft's topic when
+// provider is "". Nothing is stored, and the draft stays unpublished.
+func (h *Hub) TestDraft(ctx context.Context, id int64, token, provider string) error {
+	if token == "" {
+		return fmt.Errorf("%w: a test send needs a token", ErrInvalidDraft)
+	}
+	d, err := h.GetDraft(id)
+	if err != nil {
+		return err
+	}
+	msg, _, variants, err := h.draftMessage(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	var sub *store.Subscriber
+	subs, err := h.store.GetSubscriptionsByToken(token)
+	if err != nil {
+		return err
+	}
+	for i := range subs {
+		if subs[i].Topic == d.Topic && (provider == "" || subs[i].Provider == provider) {
+			sub = &subs[i]
+			break
+		}
+	}
+	if provider == "" {
+		if sub == nil {
+			return fmt.Errorf("%w: %s is not subscribed to %s, give a provider", ErrInvalidDraft, token, d.Topic)
+		}
+		provider = sub.Provider
+	}
+	conn, ok := h.GetConnector(provider)
+	if !ok {
+		return fmt.Errorf("%w: unknown provider %s", ErrInvalidDraft, provider)
+	}
+
+	// The device gets what a subscription would, its own variant included
+	payload := msg.Payload
+	var opts *store.WebhookOptions
+	if sub != nil {
+		if v := pickVariant(variants, sub.Locale); v != nil {
+			payload = v
+		}
+		opts = sub.Options
+	}
+	envelope := store.Notification{Topic: d.Topic, DeliveryID: newDeliveryID(), Payload: payload}
+	if msg.IncludeFrom {
+		envelope.From = d.CreatedBy
+	}
+	wrapped, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification envelope: %v", err)
+	}
+	if err := conn.Send(h.deliveryContext(ctx, opts), token, wrapped); err != nil {
+		return fmt.Errorf("%w: %v", ErrTestSendFailed, err)
+	}
+	log.Printf("[Draft] Test of draft %d sent to %s via %s", id, token, provider)
+	return nil
+}
+
+// PublishDraft publishes a draft to its topic, once. by carries who
+// publishes it: its Publisher, ClientIP, UserAgent and LargeSend. A draft
+// held for approval counts as published. The draft can be published again
+// if the publish fails.
+func (h *Hub) PublishDraft(ctx context.Context, id int64, by Message) (*PublishResult, error) {
+	d, err := h.GetDraft(id)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != store.DraftOpen {
+		return nil, ErrDraftPublished
+	}
+	var msg Message
+	if err := json.Unmarshal(d.Request, &msg); err != nil {
+		return nil, fmt.Errorf("invalid draft message: %v", err)
+	}
+	msg.Topic = d.Topic
+	msg.Publisher, msg.ClientIP, msg.UserAgent, msg.LargeSend = by.Publisher, by.ClientIP, by.UserAgent, by.LargeSend
+
+	// Claimed first, so concurrent publishes send it once
+	ok, err := h.store.SetDraftStatus(id, store.DraftOpen, store.DraftPublishing, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDraftPublished
+	}
+
+	res, err := h.Publish(ctx, msg)
+	var held *PendingApprovalError
+	status, messageID := store.DraftPublished, int64(0)
+	switch {
+	case errors.As(err, &held):
+		messageID = held.MessageID
+	case err != nil:
+		status = store.DraftOpen
+	default:
+		messageID = res.MessageID
+	}
+	if _, serr := h.store.SetDraftStatus(id, store.DraftPublishing, status, messageID); serr != nil {
+		log.Printf("[Draft] Failed to record publish of draft %d: %v", id, serr)
+	}
+	return res, err
+}
@@ -0,0 +1,197 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+func TestDrafts(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("news")
+	h.CreateTopic("sports")
+	ctx := context.Background()
+
+	if _, err := h.CreateDraft(ctx, "alice", Message{Topic: "nope", Payload: json.RawMessage(`{}`)}); err != ErrTopicNotFound {
+		t.Errorf("Expected ErrTopicNotFound, got %v", err)
+	}
+	if _, err := h.CreateDraft(ctx, "alice", Message{Token: "device-1", Payload: json.RawMessage(`{}`)}); !errors.Is(err, ErrInvalidDraft) {
+		t.Errorf("Expected ErrInvalidDraft for a direct message, got %v", err)
+	}
+	if _, err := h.CreateDraft(ctx, "alice", Message{Topic: "news", Template: "nope"}); err != ErrTemplateNotFound {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+
+	d, err := h.CreateDraft(ctx, "alice", Message{Topic: "news", Payload: json.RawMessage(`{"title":"Launch"}`)})
+	if err != nil {
+		t.Fatalf("CreateDraft failed: %v", err)
+	}
+	if d.Topic != "news" || d.CreatedBy != "alice" || d.Status != store.DraftOpen {
+		t.Errorf("Unexpected draft %+v", d)
+	}
+
+	if _, err := h.UpdateDraft(ctx, d.ID, Message{Topic: "sports", Payload: json.RawMessage(`{}`)}); !errors.Is(err, ErrInvalidDraft) {
+		t.Errorf("Expected ErrInvalidDraft moving the draft, got %v", err)
+	}
+	d, err = h.UpdateDraft(ctx, d.ID, Message{Payload: json.RawMessage(`{"title":"Launch day"}`)})
+	if err != nil {
+		t.Fatalf("UpdateDraft failed: %v", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(d.Request, &msg); err != nil || string(msg.Payload) != `{"title":"Launch day"}` {
+		t.Errorf("Unexpected stored request %s", d.Request)
+	}
+
+	if drafts, _ := h.ListDrafts("news"); len(drafts) != 1 {
+		t.Errorf("Expected one news draft, got %+v", drafts)
+	}
+	if err := h.DeleteDraft(d.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.GetDraft(d.ID); err != ErrDraftNotFound {
+		t.Errorf("Expected ErrDraftNotFound, got %v", err)
+	}
+}
+
+func TestPreviewDraft(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.RegisterConnector("webhook", connectors.NewWebhookConnector())
+	h.RegisterConnector("apns", connectors.NewAPNSConnector())
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "bob")
+	mockStore.AddSubscription("news", "device-2", "apns", "carol")
+	ctx := context.Background()
+
+	d, err := h.CreateDraft(ctx, "alice", Message{
+		Topic:     "news",
+		Payload:   json.RawMessage(`{"notification":{"title":"Launch"}}`),
+		Localized: map[string]json.RawMessage{"fr": json.RawMessage(`{"notification":{"title":"Lancement"}}`)},
+	})
+	if err != nil {
+		t.Fatalf("CreateDraft failed: %v", err)
+	}
+
+	preview, err := h.PreviewDraft(ctx, d.ID, "fr-CA")
+	if err != nil {
+		t.Fatalf("PreviewDraft failed: %v", err)
+	}
+	if !strings.Contains(string(preview.Payload), "Lancement") || len(preview.Locales) != 1 || preview.Audience != 2 {
+		t.Errorf("Unexpected preview %+v", preview)
+	}
+	if _, ok := preview.Providers["mock"]; ok {
+		t.Error("Expected no preview from a connector that can't render one")
+	}
+	if !strings.Contains(string(preview.Providers["apns"]), `"aps"`) || !strings.Contains(string(preview.Providers["webhook"]), `"slack"`) {
+		t.Errorf("Expected APNS and webhook previews, got %+v", preview.Providers)
+	}
+
+	mockStore.mu.Lock()
+	sent := len(mockStore.Messages)
+	mockStore.mu.Unlock()
+	if sent != 0 {
+		t.Errorf("Expected nothing stored by a preview, got %d messages", sent)
+	}
+}
+
+func TestTestDraft(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := NewMockConnector()
+	h.RegisterConnector("mock", conn)
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "bob")
+	ctx := context.Background()
+
+	d, err := h.CreateDraft(ctx, "alice", Message{Topic: "news", Payload: json.RawMessage(`{"title":"Launch"}`)})
+	if err != nil {
+		t.Fatalf("CreateDraft failed: %v", err)
+	}
+
+	if err := h.TestDraft(ctx, d.ID, "device-9", ""); !errors.Is(err, ErrInvalidDraft) {
+		t.Errorf("Expected ErrInvalidDraft for an unsubscribed token without a provider, got %v", err)
+	}
+	if err := h.TestDraft(ctx, d.ID, "device-9", "nope"); !errors.Is(err, ErrInvalidDraft) {
+		t.Errorf("Expected ErrInvalidDraft for an unknown provider, got %v", err)
+	}
+	if err := h.TestDraft(ctx, d.ID, "device-1", ""); err != nil {
+		t.Fatalf("TestDraft failed: %v", err)
+	}
+	if err := h.TestDraft(ctx, d.ID, "device-9", "mock"); err != nil {
+		t.Fatalf("TestDraft with a provider failed: %v", err)
+	}
+
+	conn.mu.Lock()
+	sent := conn.SentMessages
+	conn.mu.Unlock()
+	if len(sent) != 2 || sent[0].Token != "device-1" || sent[1].Token != "device-9" {
+		t.Fatalf("Expected the test sends, got %+v", sent)
+	}
+	var envelope store.Notification
+	json.Unmarshal(sent[0].Payload, &envelope)
+	if envelope.Topic != "news" || envelope.DeliveryID == "" || string(envelope.Payload) != `{"title":"Launch"}` {
+		t.Errorf("Unexpected test payload %s", sent[0].Payload)
+	}
+	if d, _ := h.GetDraft(d.ID); d.Status != store.DraftOpen {
+		t.Errorf("Expected the draft still open, got %s", d.Status)
+	}
+}
+
+func TestPublishDraft(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "bob")
+	ctx := context.Background()
+
+	d, err := h.CreateDraft(ctx, "alice", Message{Topic: "news", Payload: json.RawMessage(`{"title":"Launch"}`)})
+	if err != nil {
+		t.Fatalf("CreateDraft failed: %v", err)
+	}
+
+	// A failed publish leaves the draft open
+	if err := h.SetTopicSchema("news", `{"type":"object","required":["body"]}`); err != nil {
+		t.Fatal(err)
+	}
+	var schemaErr *SchemaError
+	if _, err := h.PublishDraft(ctx, d.ID, Message{Publisher: "bob"}); !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected a SchemaError, got %v", err)
+	}
+	if d, _ := h.GetDraft(d.ID); d.Status != store.DraftOpen {
+		t.Errorf("Expected the draft open after a failed publish, got %s", d.Status)
+	}
+	h.SetTopicSchema("news", "")
+
+	res, err := h.PublishDraft(ctx, d.ID, Message{Publisher: "bob", ClientIP: "10.0.0.1", UserAgent: "test"})
+	if err != nil {
+		t.Fatalf("PublishDraft failed: %v", err)
+	}
+	if res.MessageID == 0 || res.Enqueued != 1 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	d, _ = h.GetDraft(d.ID)
+	if d.Status != store.DraftPublished || d.MessageID != res.MessageID || d.PublishedAt == nil {
+		t.Errorf("Unexpected published draft %+v", d)
+	}
+	mockStore.mu.Lock()
+	origin := mockStore.Messages[res.MessageID].Origin
+	mockStore.mu.Unlock()
+	if origin.Publisher != "bob" {
+		t.Errorf("Expected the message published by bob, got %+v", origin)
+	}
+
+	if _, err := h.PublishDraft(ctx, d.ID, Message{Publisher: "bob"}); err != ErrDraftPublished {
+		t.Errorf("Expected ErrDraftPublished publishing twice, got %v", err)
+	}
+	if _, err := h.UpdateDraft(ctx, d.ID, Message{Payload: json.RawMessage(`{}`)}); err != ErrDraftPublished {
+		t.Errorf("Expected ErrDraftPublished updating a published draft, got %v", err)
+	}
+}
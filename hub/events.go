@@ -0,0 +1,34 @@
+package hub
+
+import (
+	"context"
+	"errors"
+
+	"no-spam/connectors"
+	"no-spam/events"
+)
+
+// eventsSource marks event documents published to hook topics.
+const eventsSource = "events"
+
+// EventTransport connects event hooks to the hub: URL hooks are sent with the
+// registered webhook connector, so the webhook policy, rate limits and
+// breakers apply, and topic hooks are published like any other message.
+func (h *Hub) EventTransport() (connectors.Connector, events.Publisher) {
+	publish := func(topic string, payload []byte) error {
+		return h.Route(context.Background(), Message{Topic: topic, Payload: payload, Source: eventsSource})
+	}
+	return webhookSender{h}, publish
+}
+
+// webhookSender looks the webhook connector up on every send, since a config
+// reload replaces it.
+type webhookSender struct{ h *Hub }
+
+func (s webhookSender) Send(ctx context.Context, url string, payload []byte) error {
+	c, ok := s.h.GetConnector("webhook")
+	if !ok {
+		return errors.New("no webhook connector registered")
+	}
+	return c.Send(ctx, url, payload)
+}
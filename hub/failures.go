@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"no-spam/connectors"
+	"no-spam/errtrack"
+	"no-spam/store"
+)
+
+// failureReportStreak is how many consecutive failed deliveries through a
+// provider get reported to error tracking. Longer streaks are reported
+// again each time they double, so an outage isn't reported per delivery.
+const failureReportStreak = 5
+
+// failureStreaks counts the consecutive failed delivery attempts per provider.
+type failureStreaks struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+// record counts an attempt through provider that failed with err, or
+// succeeded if err is nil. It returns the provider's streak of failures
+// and whether it is one to report.
+func (s *failureStreaks) record(provider string, err error) (int, bool) {
+	// Devices gone for good, or sends held back locally, say nothing about
+	// the provider's health
+	if connectors.IsPermanent(err) || errors.Is(err, connectors.ErrRateLimited) || errors.Is(err, connectors.ErrCircuitOpen) {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.n, provider)
+		return 0, false
+	}
+	if s.n == nil {
+		s.n = map[string]int{}
+	}
+	s.n[provider]++
+	n := s.n[provider]
+	doublings := n / failureReportStreak
+	return n, n%failureReportStreak == 0 && doublings&(doublings-1) == 0
+}
+
+// trackFailure reports a failed delivery attempt of a queue item to error
+// tracking when it makes the provider's streak of failures one to report.
+func (h *Hub) trackFailure(queueID int64, provider string, payload []byte, err error) {
+	n, report := h.failures.record(provider, err)
+	if !report {
+		return
+	}
+	tags := map[string]string{
+		"provider": provider,
+		"queue_id": strconv.FormatInt(queueID, 10),
+		"failures": strconv.Itoa(n),
+	}
+	var notif store.Notification
+	if json.Unmarshal(payload, &notif) == nil && notif.Topic != "" {
+		tags["topic"] = notif.Topic
+	}
+	errtrack.Capture(err, "delivery."+provider, tags)
+}
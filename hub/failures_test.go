@@ -0,0 +1,37 @@
+package hub
+
+import (
+	"errors"
+	"testing"
+
+	"no-spam/connectors"
+)
+
+func TestFailureStreaks(t *testing.T) {
+	var s failureStreaks
+	timeout := errors.New("timeout")
+
+	var reported []int
+	for i := 0; i < 40; i++ {
+		if n, ok := s.record("fcm", timeout); ok {
+			reported = append(reported, n)
+		}
+	}
+	if len(reported) != 4 || reported[0] != 5 || reported[1] != 10 || reported[2] != 20 || reported[3] != 40 {
+		t.Errorf("Expected streaks reported as they double, got %v", reported)
+	}
+
+	// Unregistered devices and local throttling leave the streak alone
+	s.record("fcm", connectors.Permanent(timeout))
+	s.record("fcm", connectors.ErrRateLimited)
+	if n, _ := s.record("fcm", timeout); n != 41 {
+		t.Errorf("Expected the streak extended, got %d", n)
+	}
+	s.record("fcm", nil)
+	if n, _ := s.record("fcm", timeout); n != 1 {
+		t.Errorf("Expected a success to end the streak, got %d", n)
+	}
+	if n, _ := s.record("apns", timeout); n != 1 {
+		t.Errorf("Expected streaks per provider, got %d", n)
+	}
+}
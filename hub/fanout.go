@@ -0,0 +1,240 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/queue"
+	"no-spam/store"
+)
+
+// DefaultDeliveryConcurrency is the default number of inline deliveries, or
+// batches of them, in flight at once.
+const DefaultDeliveryConcurrency = 256
+
+// maxBatch caps the deliveries handed to a connector's SendBatch at once.
+const maxBatch = 500
+
+// batchTimeout bounds a batch send, well within the claim lease of its items.
+const batchTimeout = 20 * time.Second
+
+// delivery is a queue item to attempt.
+type delivery struct {
+	queueID   int64
+	messageID int64
+	provider  string
+	token     string
+	payload   []byte
+	opts      *store.WebhookOptions
+}
+
+// SetDeliveryConcurrency caps the inline deliveries, or batches of them, in
+// flight at once. 0 uses DefaultDeliveryConcurrency.
+func (h *Hub) SetDeliveryConcurrency(n int) {
+	if n <= 0 {
+		n = DefaultDeliveryConcurrency
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendSlots = make(chan struct{}, n)
+}
+
+func (h *Hub) deliverySlots() chan struct{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.sendSlots
+}
+
+// dispatch hands freshly enqueued deliveries to the push queue when one is
+// configured, and attempts the rest in the background.
+func (h *Hub) dispatch(ctx context.Context, ds []delivery) {
+	h.mu.RLock()
+	q := h.queue
+	h.mu.RUnlock()
+
+	inline := ds
+	if q != nil {
+		inline = nil
+		for _, d := range ds {
+			err := q.Push(ctx, queue.Delivery{
+				QueueID:   d.queueID,
+				MessageID: d.messageID,
+				Token:     d.token,
+				Provider:  d.provider,
+				Payload:   d.payload,
+				Options:   d.opts,
+			})
+			if err != nil {
+				log.Printf("[Queue] Failed to push delivery %d, delivering inline: %v", d.queueID, err)
+				inline = append(inline, d)
+			}
+		}
+	}
+	if len(inline) > 0 {
+		// Registered before returning, so the next publish queues behind it
+		h.lanes.add(inline...)
+		// Not bound to ctx, which ends with the publish request
+		go h.deliverAll(inline)
+	}
+}
+
+// deliverAll attempts deliveries registered in their lanes, in batches
+// where the connector sends them, with at most the delivery concurrency in
+// flight, and returns once all were attempted. Several deliveries to a
+// device are attempted one after the other, in queue order.
+func (h *Hub) deliverAll(ds []delivery) {
+	single, chained := chains(ds)
+	var wg sync.WaitGroup
+	run := func(fn func()) {
+		slots := h.deliverySlots()
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			fn()
+		}()
+	}
+	for _, batch := range h.batches(single) {
+		run(func() {
+			if len(batch) == 1 {
+				d := batch[0]
+				h.deliver(d.provider, d.token, d.payload, d.queueID, d.opts)
+				return
+			}
+			h.deliverBatch(batch)
+		})
+	}
+	for _, chain := range chained {
+		run(func() {
+			for _, d := range chain {
+				h.deliver(d.provider, d.token, d.payload, d.queueID, d.opts)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// batches groups deliveries of the same payload to a provider whose
+// connector sends batches, up to maxBatch each. Other deliveries, and those
+// with webhook options, stay on their own.
+func (h *Hub) batches(ds []delivery) [][]delivery {
+	var out [][]delivery
+	batchable := map[string]bool{}
+	open := map[string]int{} // Index in out of the batch being filled, by provider and payload
+	for _, d := range ds {
+		ok, seen := batchable[d.provider]
+		if !seen {
+			_, ok = h.batchSender(d.provider)
+			batchable[d.provider] = ok
+		}
+		if !ok || d.opts != nil {
+			out = append(out, []delivery{d})
+			continue
+		}
+		key := d.provider + "\x00" + string(d.payload)
+		if i, ok := open[key]; ok && len(out[i]) < maxBatch {
+			out[i] = append(out[i], d)
+			continue
+		}
+		open[key] = len(out)
+		out = append(out, []delivery{d})
+	}
+	return out
+}
+
+// batchSender returns the connector of provider if it sends batches. A
+// connector wrapped in a rate limiter doesn't, as those work per token.
+func (h *Hub) batchSender(provider string) (connectors.BatchSender, bool) {
+	c, ok := h.GetConnector(provider)
+	if !ok {
+		return nil, false
+	}
+	return connectors.AsBatchSender(c)
+}
+
+// deliverBatch sends deliveries of the same payload to a provider in one
+// batch, claiming and settling them in the store at once too. Items that
+// failed for good are marked failed like deliver does, and items behind an
+// unsettled delivery to their device are left for later.
+func (h *Hub) deliverBatch(batch []delivery) {
+	provider, payload := batch[0].provider, batch[0].payload
+	sender, ok := h.batchSender(provider)
+	if !ok {
+		// The connector was replaced since the batch was formed
+		for _, d := range batch {
+			h.deliver(d.provider, d.token, d.payload, d.queueID, d.opts)
+		}
+		return
+	}
+
+	byID := make(map[int64]delivery, len(batch))
+	var ids []int64
+	for _, d := range batch {
+		byID[d.queueID] = d
+		if h.lanes.ready(d.token, d.queueID) {
+			ids = append(ids, d.queueID)
+		}
+	}
+	outcomes := map[int64]error{} // By queue ID, of the items attempted
+	defer func() {
+		wake := false
+		for _, d := range batch {
+			err, attempted := outcomes[d.queueID]
+			wake = h.lanes.done(d.token, d.queueID, attempted, err) || wake
+		}
+		if wake {
+			h.wakeUp()
+		}
+	}()
+	if len(ids) == 0 {
+		return
+	}
+	ids, err := h.claimAll(ids)
+	if err != nil {
+		log.Printf("[Queue] Failed to claim %d messages: %v", len(batch), err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	defer h.release(ids...)
+	tokens := make([]string, len(ids))
+	for i, id := range ids {
+		tokens[i] = byID[id].token
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	start := time.Now()
+	errs := sender.SendBatch(h.deliveryContext(ctx, nil), tokens, payload)
+	cancel()
+	for i, id := range ids {
+		outcomes[id] = errs[i]
+	}
+
+	attempts := make([]store.Attempt, len(ids))
+	failed := 0
+	for i, id := range ids {
+		attempts[i] = h.attempt(id, provider, payload, start, errs[i])
+		if errs[i] != nil {
+			failed++
+			log.Printf("[Queue] Failed to deliver message %d to %s: %v", id, tokens[i], errs[i])
+		}
+	}
+	if err := h.store.SettleAttempts(attempts); err != nil {
+		log.Printf("[Queue] Failed to record %d attempts: %v", len(attempts), err)
+		return
+	}
+	var delivered []string
+	for i, id := range ids {
+		if errs[i] == nil {
+			delivered = append(delivered, tokens[i])
+		} else if connectors.IsPermanent(errs[i]) {
+			h.failDelivery(id, provider, tokens[i], payload, errs[i])
+		}
+	}
+	h.markSeen(delivered...)
+	log.Printf("[Queue] Delivered %d of %d messages via %s in a batch", len(ids)-failed, len(ids), provider)
+}
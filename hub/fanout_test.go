@@ -0,0 +1,123 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// batchConnector records the batches sent to it, failing the tokens in fail.
+type batchConnector struct {
+	MockConnector
+	batches [][]string
+	fail    map[string]error
+}
+
+func (c *batchConnector) SendBatch(ctx context.Context, tokens []string, payload []byte) []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batches = append(c.batches, slices.Clone(tokens))
+	errs := make([]error, len(tokens))
+	for i, token := range tokens {
+		errs[i] = c.fail[token]
+	}
+	return errs
+}
+
+func TestDeliverAllBatches(t *testing.T) {
+	s := NewMockStore()
+	h := NewHub(s)
+	bc := &batchConnector{fail: map[string]error{"b-2": connectors.Permanent(errors.New("unregistered"))}}
+	mc := NewMockConnector()
+	h.RegisterConnector("batch", bc)
+	h.RegisterConnector("mock", mc)
+
+	s.CreateTopic("news")
+	payload := []byte(`{"topic":"news","payload":{}}`)
+	msgID, _ := s.SaveMessage("news", payload)
+	tokens := []string{"b-1", "b-2", "m-1", "b-3", "b-4"}
+	entries := make([]store.QueueEntry, len(tokens))
+	for i, token := range tokens {
+		entries[i].Token = token
+	}
+	ids, err := s.EnqueueMessages(msgID, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := make([]delivery, len(tokens))
+	for i, token := range tokens {
+		ds[i] = delivery{queueID: ids[i], messageID: msgID, provider: "batch", token: token, payload: payload}
+	}
+	ds[2].provider = "mock"
+	ds[4].opts = &store.WebhookOptions{} // Delivered on its own
+
+	h.deliverAll(ds)
+
+	if want := [][]string{{"b-1", "b-2", "b-3"}}; !reflect.DeepEqual(bc.batches, want) {
+		t.Errorf("Expected batches %v, got %v", want, bc.batches)
+	}
+	if len(bc.SentMessages) != 1 || bc.SentMessages[0].Token != "b-4" {
+		t.Errorf("Expected b-4 sent on its own, got %+v", bc.SentMessages)
+	}
+	if len(mc.SentMessages) != 1 || mc.SentMessages[0].Token != "m-1" {
+		t.Errorf("Expected m-1 sent through mock, got %+v", mc.SentMessages)
+	}
+	want := map[string]string{"b-1": "delivered", "b-2": "failed", "m-1": "delivered", "b-3": "delivered", "b-4": "delivered"}
+	for _, item := range s.Queue {
+		if item.Status != want[item.Token] || item.Attempts != 1 {
+			t.Errorf("Expected %s %s after 1 attempt, got %s after %d", item.Token, want[item.Token], item.Status, item.Attempts)
+		}
+	}
+}
+
+// slowConnector tracks the most sends in flight at once.
+type slowConnector struct {
+	inFlight, peak atomic.Int64
+}
+
+func (c *slowConnector) Send(ctx context.Context, token string, payload []byte) error {
+	n := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		p := c.peak.Load()
+		if n <= p || c.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return nil
+}
+
+func TestDeliverAllBounded(t *testing.T) {
+	s := NewMockStore()
+	h := NewHub(s)
+	h.SetDeliveryConcurrency(3)
+	c := &slowConnector{}
+	h.RegisterConnector("slow", c)
+
+	s.CreateTopic("news")
+	msgID, _ := s.SaveMessage("news", []byte(`{}`))
+	var ds []delivery
+	for i := 0; i < 20; i++ {
+		token := fmt.Sprintf("tok-%d", i)
+		id, _ := s.EnqueueMessage(msgID, token)
+		ds = append(ds, delivery{queueID: id, messageID: msgID, provider: "slow", token: token, payload: []byte(`{}`)})
+	}
+
+	h.deliverAll(ds)
+
+	if peak := c.peak.Load(); peak > 3 || peak < 2 {
+		t.Errorf("Expected at most 3 sends in flight, peaked at %d", peak)
+	}
+	if n := len(s.DeliveredItems); n != 20 {
+		t.Errorf("Expected 20 deliveries, got %d", n)
+	}
+}
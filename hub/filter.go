@@ -0,0 +1,128 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"no-spam/filter"
+	"no-spam/store"
+)
+
+// ErrInvalidFilter is returned when a filter rule can't be compiled.
+var ErrInvalidFilter = errors.New("invalid filter rule")
+
+// ErrFilterNotFound is returned when deleting a rule that doesn't exist.
+var ErrFilterNotFound = errors.New("filter rule not found")
+
+// CreateFilterRule validates and stores a content filter rule.
+func (h *Hub) CreateFilterRule(r store.FilterRule) (store.FilterRule, error) {
+	if r.Topic != "" {
+		exists, err := h.store.TopicExists(r.Topic)
+		if err != nil {
+			return r, err
+		}
+		if !exists {
+			return r, ErrTopicNotFound
+		}
+	}
+	if _, err := filter.Compile(r); err != nil {
+		return r, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	id, err := h.store.CreateFilterRule(r)
+	if err != nil {
+		return r, err
+	}
+	r.ID = id
+	return r, nil
+}
+
+func (h *Hub) ListFilterRules() ([]store.FilterRule, error) {
+	return h.store.ListFilterRules()
+}
+
+func (h *Hub) DeleteFilterRule(id int64) error {
+	ok, err := h.store.DeleteFilterRule(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrFilterNotFound
+	}
+	return nil
+}
+
+func (h *Hub) ListModerationLog(limit int) ([]store.ModerationEntry, error) {
+	return h.store.ListModerationEntries(limit)
+}
+
+// filterEngine returns the compiled rules, recompiling only when the stored
+// rules change. Like schemas, the cache is keyed by content so nodes sharing
+// a store stay consistent.
+func (h *Hub) filterEngine() (*filter.Engine, error) {
+	rules, err := h.store.ListFilterRules()
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var key strings.Builder
+	for _, r := range rules {
+		fmt.Fprintf(&key, "%d\x00%s\x00%s\x00%s\x00%t\x00", r.ID, r.Topic, r.Type, r.Pattern, r.Record)
+	}
+
+	h.mu.RLock()
+	if h.filterKey == key.String() {
+		e := h.filters
+		h.mu.RUnlock()
+		return e, nil
+	}
+	h.mu.RUnlock()
+
+	e, err := filter.NewEngine(rules)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.filters, h.filterKey = e, key.String()
+	h.mu.Unlock()
+	return e, nil
+}
+
+// checkFilters evaluates the content rules against every payload that
+// would be delivered. Rejections from rules with Record set are written
+// to the moderation log.
+func (h *Hub) checkFilters(msg Message, payloads ...[]byte) error {
+	e, err := h.filterEngine()
+	if err != nil {
+		return fmt.Errorf("failed to load filter rules: %v", err)
+	}
+	if e == nil {
+		return nil
+	}
+
+	for _, p := range payloads {
+		v := e.Check(msg.Topic, p)
+		if v == nil {
+			continue
+		}
+		log.Printf("[Filter] Rejected publish by %s to %s: %s", publisherKey(msg), msg.Topic, v.Reason)
+		if v.Rule.Record {
+			err := h.store.AddModerationEntry(store.ModerationEntry{
+				RuleID:    v.Rule.ID,
+				Publisher: publisherKey(msg),
+				Topic:     msg.Topic,
+				Reason:    v.Reason,
+				Payload:   p,
+			})
+			if err != nil {
+				log.Printf("[Filter] Failed to record moderation entry: %v", err)
+			}
+		}
+		return v
+	}
+	return nil
+}
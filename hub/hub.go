@@ -1,48 +1,155 @@
 package hub
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
+	"no-spam/anomaly"
+	"no-spam/cluster"
 	"no-spam/connectors"
+	"no-spam/events"
+	"no-spam/filter"
+	"no-spam/notification"
+	"no-spam/queue"
+	"no-spam/segment"
 	"no-spam/store"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 var ErrTopicNotFound = errors.New("topic not found")
 
+// ErrSubscriptionNotFound is returned when a subscription doesn't exist or
+// belongs to another user.
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// ErrTooManyTags is returned when a subscription would exceed MaxTags.
+var ErrTooManyTags = errors.New("too many tags")
+
+// MaxTags is the maximum number of tags on a subscription.
+const MaxTags = 50
+
 // Message represents a notification to be sent.
 
 // Message represents a notification to be sent.
 type Message struct {
-	Token    string          `json:"token,omitempty"`
-	Provider string          `json:"provider,omitempty"` // fcm, apns
-	Topic    string          `json:"topic,omitempty"`    // If set, broadcasts to subscribers
-	Payload  json.RawMessage `json:"payload"`
+	Token     string          `json:"token,omitempty"`
+	Provider  string          `json:"provider,omitempty"` // fcm, apns
+	Topic     string          `json:"topic,omitempty"`    // If set, broadcasts to subscribers
+	Payload   json.RawMessage `json:"payload"`
+	Source    string          `json:"-"` // Origin of the message when not published via the API (e.g. "nats")
+	Publisher string          `json:"-"` // Username of the API publisher, used for anomaly detection
+	ClientIP  string          `json:"-"` // Address the API publisher sent the message from
+	UserAgent string          `json:"-"` // User agent of the API publisher
+
+	// LargeSend lets a topic send past the large-send threshold go out
+	// without approval. It is set when the publisher may make large sends.
+	LargeSend bool `json:"-"`
+
+	// IncludeFrom adds the publisher's username to delivered payloads as "from".
+	IncludeFrom bool `json:"include_from,omitempty"`
+
+	// Template renders the payload from a topic template instead of sending Payload.
+	Template  string                 `json:"template,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	Locale    string                 `json:"locale,omitempty"` // Picks a localized template variant for every subscriber
+
+	// Localized holds per-locale payload variants; subscribers whose locale
+	// matches none of them receive Payload.
+	Localized map[string]json.RawMessage `json:"localized,omitempty"`
+
+	// Segment restricts a topic send to matching subscriptions, e.g. "platform=android AND tag=beta".
+	Segment string `json:"segment,omitempty"`
+
+	// CallbackURL receives a CallbackReport once every delivery of a topic
+	// send has succeeded or failed for good.
+	CallbackURL string `json:"callback_url,omitempty"`
 }
 
+// PublishHook is called after a topic message has been accepted and stored.
+// msg carries the original, unwrapped payload.
+type PublishHook func(msg Message, messageID int64)
+
 // Hub manages the routing of messages to the appropriate connectors.
 type Hub struct {
-	mu         sync.RWMutex
-	connectors map[string]connectors.Connector
-	store      store.Store
+	mu           sync.RWMutex
+	connectors   map[string]connectors.Connector
+	store        store.Store
+	queue        queue.Queue // Optional push queue; nil means deliver inline and rely on polling
+	bus          cluster.Bus // Optional cluster bus for forwarding deliveries to other nodes
+	nodeID       string
+	hooks        []PublishHook
+	interceptors []namedInterceptor            // Added by Intercept, run after the built-in ones
+	schemas      map[string]*jsonschema.Schema // Compiled topic schemas keyed by source
+	templates    map[string]*template.Template // Parsed payload templates keyed by source
+	anomaly      *anomaly.Detector             // Optional publish burst detection
+	filters      *filter.Engine                // Compiled content rules
+	filterKey    string                        // Rules the compiled engine was built from
+	maxPayload   int                           // Payload size cap in bytes; 0 means no cap
+	syncLimit    int                           // Subscriber cap of synchronous sends; 0 means DefaultSyncLimit
+	largeSend    int                           // Sends to more subscribers need approval or LargeSend; 0 disables
+	maxQueue     int                           // Pending deliveries cap; 0 means no cap
+	maxTopicQ    int                           // Pending deliveries cap per topic; 0 means no cap
+	userTopics   int                           // Topics each publisher may create; 0 disables self-service
+	publicSubs   int                           // Anonymous subscriptions each public topic may have; 0 means no cap
+	publicMu     sync.Mutex                    // Serializes counting and adding anonymous subscriptions
+	staleDays    int                           // Subscriptions without a delivery this long are pruned; 0 keeps them
+	unsubKey     []byte                        // Signs unsubscribe links; nil disables them
+	publicURL    string                        // Base URL of unsubscribe links
+	imageKey     []byte                        // Signs proxied image links; nil disables the image proxy
+	images       *connectors.ImageProxy        // Fetches proxied images
+	stats        statsCounter                  // Counts not yet written to the hourly stats
+	seen         seenTracker                   // Throttles last delivery writes
+	lanes        laneSet                       // Keeps the deliveries to each device in order
+	sending      inFlight                      // Queue items this node is sending
+	failures     failureStreaks                // Reports repeated delivery failures per provider
+	sendSlots    chan struct{}                 // Bounds the inline deliveries in flight
+	wake         chan struct{}                 // Wakes the queue processor; wakes while it runs coalesce
 }
 
+// claimLease bounds how long a node may hold a queue item before another node may retry it.
+const claimLease = 30 * time.Second
+
+// deliveriesChannel is the cluster bus channel used to forward deliveries
+// for providers that are not registered on the publishing node.
+const deliveriesChannel = "no-spam:deliveries"
+
+// wakeChannel is the cluster bus channel used to wake the queue processors
+// of the other nodes. Messages carry the ID of the node sending them.
+const wakeChannel = "no-spam:queue-wake"
+
+// pollInterval is how often the queue processor runs without being woken.
+const pollInterval = 10 * time.Second
+
 // NewHub initializes a new Hub.
 func NewHub(s store.Store) *Hub {
 	return &Hub{
 		connectors: map[string]connectors.Connector{},
 		store:      s,
+		nodeID:     cluster.DefaultNodeID(),
+		schemas:    map[string]*jsonschema.Schema{},
+		templates:  map[string]*template.Template{},
+		publicSubs: DefaultMaxPublicSubscribers,
+		sendSlots:  make(chan struct{}, DefaultDeliveryConcurrency),
+		wake:       make(chan struct{}, 1),
 	}
 }
 
-// StartQueueProcessor starts a background goroutine that processes pending queue items every 10 seconds
+// StartQueueProcessor starts a background goroutine that processes pending
+// queue items when started, when woken by Notify, and every pollInterval.
 func (h *Hub) StartQueueProcessor(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(pollInterval)
+	h.wakeUp() // Items left pending before the start
 	go func() {
 		defer ticker.Stop()
 		for {
@@ -51,11 +158,35 @@ func (h *Hub) StartQueueProcessor(ctx context.Context) {
 				log.Println("[Queue] Processor stopped")
 				return
 			case <-ticker.C:
-				h.processQueue()
+			case <-h.wake:
 			}
+			h.processQueue()
 		}
 	}()
-	log.Println("[Queue] Processor started (10s interval)")
+	log.Printf("[Queue] Processor started (%s interval)", pollInterval)
+}
+
+// Notify wakes the queue processor, and those of the other cluster nodes, to
+// process pending items now rather than at their next poll.
+func (h *Hub) Notify() {
+	h.wakeUp()
+	h.mu.RLock()
+	b := h.bus
+	h.mu.RUnlock()
+	if b == nil {
+		return
+	}
+	if err := b.Publish(context.Background(), wakeChannel, []byte(h.NodeID())); err != nil {
+		log.Printf("[Cluster] Failed to wake the queue processors: %v", err)
+	}
+}
+
+// wakeUp wakes the local queue processor.
+func (h *Hub) wakeUp() {
+	select {
+	case h.wake <- struct{}{}:
+	default: // A run is already due
+	}
 }
 
 // processQueue processes all pending messages in the queue
@@ -72,98 +203,482 @@ func (h *Hub) processQueue() {
 	}
 
 	log.Printf("[Queue] Processing %d pending messages", len(pending))
+	pending, live := h.applyPolicies(pending, time.Now())
+	h.lanes.prune(live)
 
-	for _, item := range pending {
-		// Get the connector for this provider
-		h.mu.RLock()
-		conn, exists := h.connectors[item.Provider]
-		h.mu.RUnlock()
+	ds := make([]delivery, 0, len(pending))
+	for _, item := range fairOrder(pending) {
+		ds = append(ds, delivery{
+			queueID:   item.ID,
+			messageID: item.MessageID,
+			provider:  item.Provider,
+			token:     item.Token,
+			payload:   withMessageID(item.Payload, item.MessageID),
+			opts:      item.Options,
+		})
+	}
+	h.lanes.add(ds...)
+	h.deliverAll(ds)
+}
 
-		if !exists {
-			log.Printf("[Queue] No connector for provider: %s", item.Provider)
-			continue
+// fairOrder interleaves pending items round-robin across topics, oldest
+// first within each topic, so that the backlog of one huge publish doesn't
+// hold up the retries of every other topic.
+func fairOrder(items []store.QueueItem) []store.QueueItem {
+	slices.SortStableFunc(items, func(a, b store.QueueItem) int { return cmp.Compare(a.ID, b.ID) })
+
+	var topics []string
+	byTopic := map[string][]store.QueueItem{}
+	for _, item := range items {
+		if _, ok := byTopic[item.Topic]; !ok {
+			topics = append(topics, item.Topic)
 		}
+		byTopic[item.Topic] = append(byTopic[item.Topic], item)
+	}
 
-		// Attempt to send
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		err := conn.Send(ctx, item.Token, item.Payload)
-		cancel()
+	ordered := make([]store.QueueItem, 0, len(items))
+	for len(ordered) < len(items) {
+		for _, topic := range topics {
+			if q := byTopic[topic]; len(q) > 0 {
+				ordered = append(ordered, q[0])
+				byTopic[topic] = q[1:]
+			}
+		}
+	}
+	return ordered
+}
 
-		if err != nil {
-			log.Printf("[Queue] Failed to deliver message %d to %s: %v", item.ID, item.Token, err)
-			// Could implement retry logic here
-		} else {
-			// Mark as delivered
-			if err := h.store.MarkDelivered(item.ID); err != nil {
-				log.Printf("[Queue] Failed to mark message %d as delivered: %v", item.ID, err)
-			} else {
-				log.Printf("[Queue] Successfully delivered message %d to %s via %s", item.ID, item.Token, item.Provider)
+// SetQueue configures a push queue. Enqueued deliveries are handed to the
+// queue instead of being attempted inline; the store remains the system of record.
+func (h *Hub) SetQueue(q queue.Queue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queue = q
+}
+
+// StartQueueWorkers starts n goroutines consuming deliveries from the configured queue.
+func (h *Hub) StartQueueWorkers(ctx context.Context, n int) {
+	h.mu.RLock()
+	q := h.queue
+	h.mu.RUnlock()
+	if q == nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				d, err := q.Pop(ctx)
+				if err != nil {
+					if ctx.Err() != nil || errors.Is(err, queue.ErrClosed) {
+						return
+					}
+					log.Printf("[Queue] Failed to pop delivery: %v", err)
+					time.Sleep(time.Second)
+					continue
+				}
+				h.lanes.add(delivery{queueID: d.QueueID, token: d.Token})
+				h.deliver(d.Provider, d.Token, d.Payload, d.QueueID, d.Options)
 			}
+		}()
+	}
+	log.Printf("[Queue] Started %d queue workers", n)
+}
+
+// SetNodeID sets the identifier used when claiming queue items.
+func (h *Hub) SetNodeID(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nodeID = id
+}
+
+// NodeID returns the identifier used when claiming queue items.
+func (h *Hub) NodeID() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.nodeID
+}
+
+// StartCluster joins the cluster bus. Deliveries for providers that are not
+// registered locally are forwarded over the bus, and forwarded deliveries
+// from other nodes are handled here if the provider is registered.
+func (h *Hub) StartCluster(ctx context.Context, b cluster.Bus) error {
+	h.mu.Lock()
+	h.bus = b
+	h.mu.Unlock()
+
+	err := b.Subscribe(ctx, deliveriesChannel, func(data []byte) {
+		var d queue.Delivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			log.Printf("[Cluster] Invalid delivery on bus: %v", err)
+			return
+		}
+		if _, ok := h.GetConnector(d.Provider); !ok {
+			return
+		}
+		h.lanes.add(delivery{queueID: d.QueueID, token: d.Token})
+		h.deliver(d.Provider, d.Token, d.Payload, d.QueueID, d.Options)
+	})
+	if err != nil {
+		return err
+	}
+	err = b.Subscribe(ctx, wakeChannel, func(data []byte) {
+		if string(data) != h.NodeID() {
+			h.wakeUp()
 		}
+	})
+	if err != nil {
+		return err
 	}
+	log.Printf("[Cluster] Node %s joined cluster", h.NodeID())
+	return nil
 }
 
-// RegisterConnector adds a connector to the hub.
-func (h *Hub) RegisterConnector(name string, c connectors.Connector) {
+// forward publishes a delivery on the cluster bus for another node to handle.
+func (h *Hub) forward(provider, token string, payload []byte, queueID int64, opts *store.WebhookOptions) bool {
+	h.mu.RLock()
+	b := h.bus
+	h.mu.RUnlock()
+	if b == nil {
+		return false
+	}
+
+	data, err := json.Marshal(queue.Delivery{QueueID: queueID, Token: token, Provider: provider, Payload: payload, Options: opts})
+	if err != nil {
+		return false
+	}
+	if err := b.Publish(context.Background(), deliveriesChannel, data); err != nil {
+		log.Printf("[Cluster] Failed to forward delivery %d: %v", queueID, err)
+		return false
+	}
+	return true
+}
+
+// deliveryTimeout bounds a single delivery, leaving room for the retries
+// configured on a webhook subscription.
+func deliveryTimeout(opts *store.WebhookOptions) time.Duration {
+	timeout := 5 * time.Second
+	if opts == nil {
+		return timeout
+	}
+	if opts.TimeoutMs > 0 {
+		timeout = time.Duration(opts.TimeoutMs) * time.Millisecond
+	}
+	total := timeout * time.Duration(opts.MaxRetries+1)
+	// Backoff between retries doubles from 500ms
+	for i := 0; i < opts.MaxRetries; i++ {
+		total += 500 * time.Millisecond << i
+	}
+	return total
+}
+
+// inFlight tracks the queue items this node is sending. A node may claim
+// an item again while it holds the lease, e.g. to retry it, so the lease
+// alone doesn't keep two of its own workers from sending the same item.
+type inFlight struct {
+	mu  sync.Mutex
+	ids map[int64]bool
+}
+
+// start marks the items of ids that aren't in flight as such and returns them.
+func (f *inFlight) start(ids ...int64) []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ids == nil {
+		f.ids = map[int64]bool{}
+	}
+	started := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !f.ids[id] {
+			f.ids[id] = true
+			started = append(started, id)
+		}
+	}
+	return started
+}
+
+// done marks items as no longer in flight.
+func (f *inFlight) done(ids ...int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		delete(f.ids, id)
+	}
+}
+
+// claim leases a queue item to this node so no other node delivers it
+// concurrently, unless one of this node's workers is already sending it.
+// The caller releases a successful claim once the delivery is settled.
+func (h *Hub) claim(queueID int64) bool {
+	if len(h.sending.start(queueID)) == 0 {
+		return false
+	}
+	ok, err := h.store.ClaimQueueItem(queueID, h.NodeID(), claimLease)
+	if err != nil {
+		log.Printf("[Queue] Failed to claim message %d: %v", queueID, err)
+	}
+	if !ok {
+		h.release(queueID)
+	}
+	return ok
+}
+
+// claimAll claims the queue items of ids that no other node or local worker
+// holds, and returns them. The caller releases them once settled.
+func (h *Hub) claimAll(ids []int64) ([]int64, error) {
+	started := h.sending.start(ids...)
+	if len(started) == 0 {
+		return nil, nil
+	}
+	claimed, err := h.store.ClaimQueueItems(started, h.NodeID(), claimLease)
+	if err != nil {
+		h.release(started...)
+		return nil, err
+	}
+	h.release(slices.DeleteFunc(started, func(id int64) bool { return slices.Contains(claimed, id) })...)
+	return claimed, nil
+}
+
+// release ends the local claims of queue items once their delivery settled.
+func (h *Hub) release(ids ...int64) {
+	h.sending.done(ids...)
+}
+
+// recordAttempt logs the outcome of a delivery attempt of a queue item
+// through provider, started at start.
+func (h *Hub) recordAttempt(queueID int64, provider string, payload []byte, start time.Time, sendErr error) {
+	if err := h.store.RecordAttempt(h.attempt(queueID, provider, payload, start, sendErr)); err != nil {
+		log.Printf("[Queue] Failed to record attempt of message %d: %v", queueID, err)
+	}
+}
+
+// attempt describes a delivery attempt of a queue item of payload, counts
+// it in the stats and tracks repeated failures.
+func (h *Hub) attempt(queueID int64, provider string, payload []byte, start time.Time, sendErr error) store.Attempt {
+	a := store.Attempt{
+		QueueID:     queueID,
+		Node:        h.NodeID(),
+		Provider:    provider,
+		DurationMs:  time.Since(start).Milliseconds(),
+		AttemptedAt: time.Now(),
+	}
+	if sendErr != nil {
+		a.Error = sendErr.Error()
+		h.stats.add(StatFailures)
+	} else {
+		h.stats.add(StatDeliveries)
+	}
+	h.trackFailure(queueID, provider, payload, sendErr)
+	return a
+}
+
+// deliveryContext attaches a subscription's webhook options and the
+// unsubscribe links to the context of a delivery.
+func (h *Hub) deliveryContext(ctx context.Context, opts *store.WebhookOptions) context.Context {
+	h.mu.RLock()
+	links := h.unsubKey != nil
+	h.mu.RUnlock()
+	if links {
+		ctx = connectors.WithUnsubscribeLinks(ctx, h.UnsubscribeURL)
+	}
+	return connectors.WithWebhookOptions(ctx, opts)
+}
+
+// deliver sends a single queued item registered in its lane, unless an
+// earlier delivery to the device is still unsettled, and marks it delivered
+// on success.
+func (h *Hub) deliver(provider, token string, payload []byte, queueID int64, opts *store.WebhookOptions) {
+	var attempted bool
+	var err error
+	defer func() {
+		if h.lanes.done(token, queueID, attempted, err) {
+			h.wakeUp()
+		}
+	}()
+
+	conn, exists := h.GetConnector(provider)
+	if !exists {
+		if h.forward(provider, token, payload, queueID, opts) {
+			return
+		}
+		log.Printf("[Queue] No connector for provider: %s", provider)
+		return
+	}
+
+	// An earlier delivery to the device goes first
+	if !h.lanes.ready(token, queueID) || !h.claim(queueID) {
+		return
+	}
+	defer h.release(queueID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout(opts))
+	start := time.Now()
+	err = conn.Send(h.deliveryContext(ctx, opts), token, payload)
+	cancel()
+	attempted = true
+	h.settle(queueID, provider, token, payload, start, err)
+}
+
+// settle records the outcome of a delivery attempt started at start, and
+// marks the item delivered, or failed if err is permanent.
+func (h *Hub) settle(queueID int64, provider, token string, payload []byte, start time.Time, err error) {
+	h.recordAttempt(queueID, provider, payload, start, err)
+	if err != nil {
+		log.Printf("[Queue] Failed to deliver message %d to %s: %v", queueID, token, err)
+		if connectors.IsPermanent(err) {
+			h.failDelivery(queueID, provider, token, payload, err)
+		}
+		return
+	}
+	if err := h.store.MarkDelivered(queueID); err != nil {
+		log.Printf("[Queue] Failed to mark message %d as delivered: %v", queueID, err)
+		return
+	}
+	h.markSeen(token)
+	log.Printf("[Queue] Successfully delivered message %d to %s via %s", queueID, token, provider)
+}
+
+// failDelivery takes an item that can never be delivered out of the queue.
+func (h *Hub) failDelivery(queueID int64, provider, token string, payload []byte, err error) {
+	if err := h.store.MarkFailed(queueID); err != nil {
+		log.Printf("[Queue] Failed to mark message %d as failed: %v", queueID, err)
+		return
+	}
+	data := map[string]any{"queue_id": queueID, "provider": provider, "token": token, "error": err.Error()}
+	var notif store.Notification
+	if json.Unmarshal(payload, &notif) == nil && notif.Topic != "" {
+		data["topic"] = notif.Topic
+	}
+	events.Emit(events.DeliveryFailed, "", data)
+}
+
+// OnPublish registers a hook called for every accepted topic message.
+func (h *Hub) OnPublish(hook PublishHook) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *Hub) runPublishHooks(msg Message, messageID int64) {
+	h.stats.add(StatPublishes)
+	h.mu.RLock()
+	hooks := h.hooks
+	h.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(msg, messageID)
+	}
+}
+
+// RegisterConnector adds a connector to the hub, and wakes the queue
+// processor for the items that were waiting for it.
+func (h *Hub) RegisterConnector(name string, c connectors.Connector) {
+	h.mu.Lock()
 	h.connectors[name] = c
+	h.mu.Unlock()
+	h.wakeUp()
+}
+
+// UnregisterConnector removes a connector from the hub. Items queued for it
+// stay pending until a connector is registered under its name again.
+func (h *Hub) UnregisterConnector(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.connectors, name)
+}
+
+// ConnectorNames returns the names of the registered connectors, sorted.
+func (h *Hub) ConnectorNames() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return slices.Sorted(maps.Keys(h.connectors))
+}
+
+// PublishResult describes an accepted topic message.
+type PublishResult struct {
+	MessageID int64 `json:"message_id"`
+	Enqueued  int   `json:"enqueued"` // Subscribers the message was queued for
 }
 
 // Route directs the message to the requested provider's connector.
 func (h *Hub) Route(ctx context.Context, msg Message) error {
+	_, err := h.Publish(ctx, msg)
+	return err
+}
+
+// Publish routes the message like Route and reports what was enqueued.
+// Topic deliveries are attempted in the background, so it returns once
+// the message is queued. The result is nil for direct messages, which are
+// sent before it returns.
+func (h *Hub) Publish(ctx context.Context, msg Message) (*PublishResult, error) {
 	// Case 1: Broadcast to Topic
 	if msg.Topic != "" {
-		exists, err := h.store.TopicExists(msg.Topic)
+		topic, err := h.resolveTopic(msg.Topic)
 		if err != nil {
-			return fmt.Errorf("failed to check topic existence: %v", err)
-		}
-		if !exists {
-			return ErrTopicNotFound
+			return nil, err
 		}
+		msg.Topic = topic
 
-		// Wrap Payload with Topic
-		envelope := store.Notification{
-			Topic:   msg.Topic,
-			Payload: msg.Payload,
+		if err := h.checkAnomaly(msg); err != nil {
+			return nil, err
 		}
-		wrappedPayload, err := json.Marshal(envelope)
+		var seg segment.Expr
+		var variants map[string][]byte
+		msg, seg, variants, err = h.prepareTopicMessage(ctx, msg)
 		if err != nil {
-			return fmt.Errorf("failed to marshal notification envelope: %v", err)
+			return nil, err
 		}
-		msg.Payload = wrappedPayload
 
-		// 1. Save Message
-		msgID, err := h.store.SaveMessage(msg.Topic, msg.Payload)
+		original := msg
+
+		// 1. Get Subscribers
+		subscribers, err := h.audience(msg.Topic, seg)
 		if err != nil {
-			return fmt.Errorf("failed to save message: %v", err)
+			return nil, err
 		}
-
-		// 2. Get Subscribers
-		subscribers, err := h.store.GetSubscribers(msg.Topic)
+		subscribers, err = h.applyPreferences(msg.Topic, original.Payload, subscribers, time.Now())
 		if err != nil {
-			return fmt.Errorf("failed to get subscribers: %v", err)
+			return nil, err
 		}
-
-		if len(subscribers) == 0 {
-			log.Printf("No subscribers found for topic: %s", msg.Topic)
-			return nil
+		if err := h.checkSyncLimit(ctx, len(subscribers)); err != nil {
+			return nil, err
+		}
+		if err := h.checkQueueDepth(msg.Topic); err != nil {
+			return nil, err
 		}
 
-		var wg sync.WaitGroup
-		for _, sub := range subscribers {
-			// 3. Enqueue for each subscriber
-			queueID, err := h.store.EnqueueMessage(msgID, sub.Token)
+		// Wrap Payload with Topic
+		envelope := store.Notification{
+			Topic:      msg.Topic,
+			DeliveryID: newDeliveryID(),
+			Payload:    msg.Payload,
+		}
+		if msg.IncludeFrom {
+			envelope.From = msg.Publisher
+		}
+		if held, err := h.holdForApproval(original, envelope, variants, len(subscribers)); err != nil || held != nil {
 			if err != nil {
-				log.Printf("Failed to enqueue message for %s: %v", sub.Token, err)
-				continue
+				return nil, err
 			}
+			return nil, held
+		}
 
-			// 4. Attempt Delivery
-			h.attemptDelivery(ctx, sub, msg.Payload, queueID)
+		// 2. Save Message, numbered in the same transaction so sequence
+		// numbers follow the messages released for fan-out
+		msgID, err := h.store.SaveSequencedMessage(msg.Topic, messageOrigin(msg), func(seq int64) (_ []byte, err error) {
+			envelope.Seq = seq
+			msg.Payload, err = json.Marshal(envelope)
+			return msg.Payload, err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to save message: %v", err)
 		}
-		wg.Wait()
-		return nil
+		h.runPublishHooks(original, msgID)
+
+		enqueued := h.fanOut(ctx, msg.Topic, msgID, msg.Payload, variants, subscribers)
+		if msg.CallbackURL != "" {
+			go h.watchCallback(msg.CallbackURL, msg.Topic, msgID)
+		}
+		return &PublishResult{MessageID: msgID, Enqueued: enqueued}, nil
 	}
 
 	// Case 2: Direct Message (Ephemeral, no DB?)
@@ -175,32 +690,152 @@ func (h *Hub) Route(ctx context.Context, msg Message) error {
 	// Direct message support might be out of scope for the queue or just same logic.
 	// I'll stick to Route for Topics having queue support as per plan.
 
+	if msg.Template != "" {
+		return nil, fmt.Errorf("%w: templates require a topic", ErrInvalidTemplate)
+	}
+	if msg.Segment != "" {
+		return nil, fmt.Errorf("%w: segments require a topic", segment.ErrInvalid)
+	}
+	if err := h.checkCallback(ctx, msg); err != nil {
+		return nil, err
+	}
+	if err := h.checkPayloadSize(msg.Payload); err != nil {
+		return nil, err
+	}
+	if _, err := notification.Parse(msg.Payload); err != nil {
+		return nil, err
+	}
+
 	connector, ok := h.GetConnector(msg.Provider)
 	if !ok {
-		return fmt.Errorf("connector not found for provider: %s", msg.Provider)
+		return nil, fmt.Errorf("connector not found for provider: %s", msg.Provider)
 	}
 
 	if msg.Token == "" {
-		return errors.New("target token is required for direct message")
+		return nil, errors.New("target token is required for direct message")
 	}
 
-	return connector.Send(ctx, msg.Token, msg.Payload)
+	return nil, connector.Send(ctx, msg.Token, msg.Payload)
 }
 
-func (h *Hub) attemptDelivery(ctx context.Context, sub store.Subscriber, payload []byte, queueID int64) {
-	connector, ok := h.GetConnector(sub.Provider)
-	if !ok {
-		return
+// prepareTopicMessage checks a topic message like a send, and returns it
+// with its payload rendered, its segment and its localized variants, images
+// proxied. It runs the interceptor chain.
+func (h *Hub) prepareTopicMessage(ctx context.Context, msg Message) (Message, segment.Expr, map[string][]byte, error) {
+	out := &Outgoing{Message: msg}
+	if err := h.intercept(ctx, out); err != nil {
+		return msg, nil, nil, err
 	}
 
-	go func(c connectors.Connector, t string, p []byte, qID int64) {
-		// Store-and-Forward: If sent, mark delivered.
-		if err := c.Send(ctx, t, p); err == nil {
-			if err := h.store.MarkDelivered(qID); err != nil {
-				log.Printf("Failed to mark delivered: %v", err)
+	// Devices load images from this server, not from the publisher's hosts
+	out.Payload = h.proxyImages(out.Payload)
+	for locale, v := range out.Variants {
+		out.Variants[locale] = h.proxyImages(v)
+	}
+	return out.Message, out.Match, out.Variants, nil
+}
+
+// messageOrigin returns the origin stored with msg.
+func messageOrigin(msg Message) store.MessageOrigin {
+	origin := store.MessageOrigin{Publisher: msg.Publisher, IP: msg.ClientIP, UserAgent: msg.UserAgent}
+	if origin.Publisher == "" && msg.Source != "" {
+		origin.Publisher = "source:" + msg.Source
+	}
+	return origin
+}
+
+// audience returns the topic subscribers matching seg (all of them when seg is nil).
+func (h *Hub) audience(topic string, seg segment.Expr) ([]store.Subscriber, error) {
+	subscribers, err := h.store.GetSubscribers(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscribers: %v", err)
+	}
+	if seg == nil {
+		return subscribers, nil
+	}
+	matched := subscribers[:0]
+	for _, sub := range subscribers {
+		if seg.Match(sub) {
+			matched = append(matched, sub)
+		}
+	}
+	return matched, nil
+}
+
+// fanOut enqueues a stored topic message for every subscriber, with its
+// localized variant if any, and attempts delivery. Platforms fanning out
+// topic messages themselves get it once instead. It returns how many
+// subscribers were enqueued or reached through a platform.
+func (h *Hub) fanOut(ctx context.Context, topic string, msgID int64, wrapped []byte, variants map[string][]byte, subscribers []store.Subscriber) int {
+	if len(subscribers) == 0 {
+		log.Printf("No subscribers found for topic: %s", topic)
+		return 0
+	}
+
+	wrapped = withMessageID(wrapped, msgID)
+	var envelope store.Notification
+	json.Unmarshal(wrapped, &envelope) // Localized variants keep its sender, delivery ID and sequence number
+
+	subscribers, reached := h.sendPlatformTopics(ctx, topic, wrapped, variants, subscribers)
+	if len(subscribers) == 0 {
+		return reached
+	}
+
+	// 3. Enqueue for every subscriber at once, with its localized variant if any
+	subs := make([]store.Subscriber, 0, len(subscribers))
+	entries := make([]store.QueueEntry, 0, len(subscribers))
+	payloads := make([][]byte, 0, len(subscribers))
+	localized := map[string][]byte{} // Wrapped variants, so each is wrapped once
+	for _, sub := range subscribers {
+		payload := wrapped
+		entry := store.QueueEntry{Token: sub.Token, Provider: sub.Provider}
+		if variant := pickVariant(variants, sub.Locale); variant != nil {
+			p, ok := localized[string(variant)]
+			if !ok {
+				var err error
+				p, err = json.Marshal(store.Notification{Topic: topic, MessageID: msgID, DeliveryID: envelope.DeliveryID, Seq: envelope.Seq, From: envelope.From, Payload: variant})
+				if err != nil {
+					log.Printf("Failed to wrap localized payload for %s: %v", sub.Token, err)
+					continue
+				}
+				localized[string(variant)] = p
 			}
+			payload, entry.Payload = p, p
 		}
-	}(connector, sub.Token, payload, queueID)
+		subs = append(subs, sub)
+		entries = append(entries, entry)
+		payloads = append(payloads, payload)
+	}
+	h.stampUnread(subs, entries, payloads)
+	ids, err := h.store.EnqueueMessages(msgID, entries)
+	if err != nil {
+		log.Printf("Failed to enqueue message %d: %v", msgID, err)
+		return reached
+	}
+
+	// 4. Attempt Delivery, waiting for the result of synchronous sends
+	if s := syncSendFrom(ctx); s != nil {
+		var wg sync.WaitGroup
+		for i, sub := range subs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := h.deliverSync(ctx, sub, payloads[i], ids[i])
+				s.mu.Lock()
+				s.results = append(s.results, r)
+				s.mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		return reached + len(ids)
+	}
+
+	ds := make([]delivery, len(subs))
+	for i, sub := range subs {
+		ds[i] = delivery{queueID: ids[i], messageID: msgID, provider: sub.Provider, token: sub.Token, payload: payloads[i], opts: sub.Options}
+	}
+	h.dispatch(ctx, ds)
+	return reached + len(ids)
 }
 
 func (h *Hub) GetConnector(name string) (connectors.Connector, bool) {
@@ -210,41 +845,116 @@ func (h *Hub) GetConnector(name string) (connectors.Connector, bool) {
 	return c, ok
 }
 
+// CircuitStatus returns the circuit breaker status of every registered
+// connector that is wrapped in a breaker.
+func (h *Hub) CircuitStatus() []connectors.CircuitStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	statuses := []connectors.CircuitStatus{}
+	for _, c := range h.connectors {
+		if b, ok := c.(*connectors.CircuitBreaker); ok {
+			statuses = append(statuses, b.Snapshot()...)
+		}
+	}
+	return statuses
+}
+
+// ValidateTarget asks the provider's connector, unwrapping breakers and rate
+// limiters, whether token is an acceptable destination.
+func (h *Hub) ValidateTarget(ctx context.Context, provider, token string) error {
+	c, ok := h.GetConnector(provider)
+	if !ok {
+		return nil
+	}
+	if v, ok := connectorAs[connectors.TargetValidator](c); ok {
+		return v.ValidateTarget(ctx, token)
+	}
+	return nil
+}
+
+// connectorAs unwraps the breakers and rate limiters around c until it
+// finds a T.
+func connectorAs[T any](c connectors.Connector) (T, bool) {
+	for {
+		if v, ok := c.(T); ok {
+			return v, true
+		}
+		w, ok := c.(interface{ Unwrap() connectors.Connector })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		c = w.Unwrap()
+	}
+}
+
 // Subscribe adds a subscriber to a topic.
 func (h *Hub) Subscribe(topic string, sub store.Subscriber) error {
-	exists, err := h.store.TopicExists(topic)
+	topic, err := h.resolveTopic(topic)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return ErrTopicNotFound
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.ValidateTarget(ctx, sub.Provider, sub.Token); err != nil {
+		return err
+	}
+	if err := h.subscribePlatformTopic(ctx, topic, sub); err != nil {
+		return err
 	}
 
 	if err := h.store.AddSubscription(topic, sub.Token, sub.Provider, sub.Username); err != nil {
 		return err
 	}
+	if sub.Options != nil {
+		if err := h.store.SetSubscriptionOptions(topic, sub.Token, sub.Options); err != nil {
+			return err
+		}
+	}
+	if sub.Locale != "" {
+		sub.Locale = normalizeLocale(sub.Locale)
+		if err := h.store.SetSubscriptionLocale(topic, sub.Token, sub.Locale); err != nil {
+			return err
+		}
+	}
+	if sub.Platform != "" || sub.AppVersion != "" || len(sub.Tags) > 0 {
+		if err := h.UpdateSubscriptionAttributes(topic, sub.Token, sub.Platform, sub.AppVersion, sub.Tags); err != nil {
+			return err
+		}
+	}
 
-	// History Replay: Get last 20 messages
-	msgs, err := h.store.GetRecentMessages(topic, 20)
+	// History Replay: Get the topic's most recent messages
+	n := h.replayCount(topic)
+	if n == 0 {
+		return nil
+	}
+	msgs, err := h.store.GetRecentMessages(topic, n)
 	if err != nil {
 		log.Printf("Failed to get recent messages for replay: %v", err)
 		return nil // Don't fail subscription if replay fails
 	}
+	if ttl := h.topicPolicy(topic).ttl; ttl > 0 {
+		// Messages past their topic's TTL would only be failed
+		msgs = slices.DeleteFunc(msgs, func(m store.Message) bool { return time.Since(m.CreatedAt) > ttl })
+	}
 
 	if len(msgs) > 0 {
 		log.Printf("[Hub] Replaying %d recent messages to new subscriber %s", len(msgs), sub.Token)
 		go func() {
-			ctx := context.Background()
+			var ds []delivery
 			for _, m := range msgs {
 				// Enqueue
-				qID, err := h.store.EnqueueMessage(m.ID, sub.Token)
+				ids, err := h.store.EnqueueMessages(m.ID, []store.QueueEntry{{Token: sub.Token, Provider: sub.Provider}})
 				if err != nil {
 					log.Printf("Failed to enqueue replay message %d: %v", m.ID, err)
 					continue
 				}
-				// Attempt Delivery
-				h.attemptDelivery(ctx, sub, m.Payload, qID)
+				ds = append(ds, delivery{queueID: ids[0], messageID: m.ID, provider: sub.Provider, token: sub.Token, payload: withMessageID(m.Payload, m.ID), opts: sub.Options})
 			}
+			// Attempt Delivery
+			h.dispatch(context.Background(), ds)
 		}()
 	}
 	return nil
@@ -262,11 +972,67 @@ func (h *Hub) DeleteTopic(name string) error {
 	return h.store.DeleteTopic(name)
 }
 
-// Unsubscribe removes a subscriber from a topic.
+// UpdateSubscriptionAttributes replaces the device attributes used for segmentation.
+func (h *Hub) UpdateSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
+	return h.store.SetSubscriptionAttributes(topic, token, strings.ToLower(platform), appVersion, tags)
+}
+
+// UpdateTags adds and removes tags on one of username's subscriptions and
+// returns the resulting tags.
+func (h *Hub) UpdateTags(username, topic, token string, add, remove []string) ([]string, error) {
+	owned, err := h.UserSubscription(username, topic, token)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]bool{}
+	for _, t := range append(owned.Tags, add...) {
+		tags[t] = true
+	}
+	for _, t := range remove {
+		delete(tags, t)
+	}
+	if len(tags) > MaxTags {
+		return nil, ErrTooManyTags
+	}
+	return h.store.UpdateSubscriptionTags(topic, token, add, remove)
+}
+
+// UnsubscribeByTag removes every subscription of username carrying tag and
+// returns how many were removed.
+func (h *Hub) UnsubscribeByTag(username, tag string) (int64, error) {
+	return h.store.RemoveSubscriptionsByTag(username, tag)
+}
+
+// UpdateSubscriptionLocale changes the locale used to pick localized payloads.
+func (h *Hub) UpdateSubscriptionLocale(topic, token, locale string) error {
+	return h.store.SetSubscriptionLocale(topic, token, normalizeLocale(locale))
+}
+
+// UpdateSubscriptionOptions replaces the delivery options of an existing subscription.
+func (h *Hub) UpdateSubscriptionOptions(topic, token string, opts *store.WebhookOptions) error {
+	return h.store.SetSubscriptionOptions(topic, token, opts)
+}
 
-// Unsubscribe removes a subscriber from a topic.
+// Unsubscribe removes a subscriber from a topic, following an alias of a
+// renamed topic.
 func (h *Hub) Unsubscribe(topic string, token string) error {
-	return h.store.RemoveSubscription(topic, token)
+	if target, err := h.store.ResolveTopicAlias(topic); err == nil && target != "" {
+		topic = target
+	}
+	subs, err := h.store.GetSubscriptionsByToken(token)
+	if err != nil {
+		return err
+	}
+	if err := h.store.RemoveSubscription(topic, token); err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if sub.Topic == topic {
+			h.unsubscribePlatformTopic(topic, sub)
+		}
+	}
+	return nil
 }
 
 // GetQueue retrieves pending messages for a specific topic.
@@ -281,6 +1047,57 @@ func (h *Hub) GetQueue(topic string) ([]store.QueueItem, error) {
 	return h.store.GetPendingMessagesByTopic(topic)
 }
 
+// ErrQueueItemNotFound is returned when a queue item doesn't exist, belongs
+// to another topic or isn't in a status the operation applies to.
+var ErrQueueItemNotFound = errors.New("queue item not found")
+
+// CancelQueueItem cancels a pending delivery of a topic message.
+func (h *Hub) CancelQueueItem(topic string, queueID int64) error {
+	ok, err := h.store.CancelQueueItem(topic, queueID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrQueueItemNotFound
+	}
+	return nil
+}
+
+// RequeueQueueItem puts a failed or canceled delivery of a topic message
+// back in the queue, and wakes the queue processor to retry it.
+func (h *Hub) RequeueQueueItem(topic string, queueID int64) error {
+	ok, err := h.store.RequeueQueueItem(topic, queueID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrQueueItemNotFound
+	}
+	h.Notify()
+	return nil
+}
+
+// PurgeQueue cancels the pending deliveries of a topic, of a token, or of
+// both when both are set. It returns how many were canceled.
+func (h *Hub) PurgeQueue(topic, token string) (int64, error) {
+	if topic != "" {
+		exists, err := h.store.TopicExists(topic)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, ErrTopicNotFound
+		}
+	}
+	return h.store.PurgeQueue(topic, token)
+}
+
+// ListAttempts lists the delivery attempts of a queue item of a topic's
+// message, oldest first.
+func (h *Hub) ListAttempts(topic string, queueID int64) ([]store.Attempt, error) {
+	return h.store.ListAttempts(topic, queueID)
+}
+
 // Stats tracking proxies to store
 func (h *Hub) GetTotalMessagesSent() int64 {
 	count, _ := h.store.GetTotalMessagesSent()
@@ -305,10 +1122,49 @@ func (h *Hub) GetRecentMessages(topic string, limit int) ([]store.Message, error
 	return h.store.GetRecentMessages(topic, limit)
 }
 
+// SearchMessages returns a topic's stored messages matching f, newest first.
+func (h *Hub) SearchMessages(f store.MessageSearch) ([]store.Message, error) {
+	return h.store.SearchMessages(f)
+}
+
 func (h *Hub) GetSubscribers(topic string) ([]store.Subscriber, error) {
 	return h.store.GetSubscribers(topic)
 }
 
+func (h *Hub) TopicExists(topic string) (bool, error) {
+	return h.store.TopicExists(topic)
+}
+
+// subscriberPageSize is how many subscribers EachSubscriber loads at a time.
+const subscriberPageSize = 500
+
+// EachSubscriber calls fn for every subscriber of a topic in token order,
+// loading them a page at a time, and stops at the first error.
+func (h *Hub) EachSubscriber(topic string, fn func(store.Subscriber) error) error {
+	exists, err := h.store.TopicExists(topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTopicNotFound
+	}
+	for after := ""; ; {
+		subs, err := h.store.ListSubscribersAfter(topic, after, subscriberPageSize)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if err := fn(sub); err != nil {
+				return err
+			}
+		}
+		if len(subs) < subscriberPageSize {
+			return nil
+		}
+		after = subs[len(subs)-1].Token
+	}
+}
+
 func (h *Hub) ClearTopicMessages(topic string) error {
 	return h.store.ClearTopicMessages(topic)
 }
@@ -3,6 +3,9 @@ package hub
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"no-spam/connectors"
+	"no-spam/events"
 	"no-spam/store"
 	"testing"
 	"time"
@@ -104,3 +107,49 @@ func TestProcessQueue_Errors(t *testing.T) {
 	// To test MarkDelivered failure specifically, we'd need more granular control in MockStore.
 	// We can skip this edge case for now as global fail covers most.
 }
+
+// permanentConnector rejects every delivery for good.
+type permanentConnector struct{}
+
+func (permanentConnector) Send(ctx context.Context, token string, payload []byte) error {
+	return connectors.Permanent(errors.New("device unregistered"))
+}
+
+func TestProcessQueue_PermanentFailure(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("gone", permanentConnector{})
+
+	published := make(chan []byte, 1)
+	events.SetTransport(nil, func(topic string, payload []byte) error {
+		published <- payload
+		return nil
+	})
+	events.SetHooks([]events.Hook{{Topic: "ops", Events: []string{events.DeliveryFailed}}})
+	t.Cleanup(func() {
+		events.SetHooks(nil)
+		events.SetTransport(nil, nil)
+	})
+
+	payload, _ := json.Marshal(store.Notification{Topic: "news", Payload: json.RawMessage(`{}`)})
+	mockStore.Queue = append(mockStore.Queue, store.QueueItem{ID: 7, Token: "t", Provider: "gone", Status: "pending", Payload: payload})
+	h.processQueue()
+
+	mockStore.mu.Lock()
+	status := mockStore.Queue[0].Status
+	mockStore.mu.Unlock()
+	if status != "failed" {
+		t.Errorf("Expected the item to be marked failed, got %s", status)
+	}
+
+	select {
+	case data := <-published:
+		var ev events.Event
+		json.Unmarshal(data, &ev)
+		if ev.Type != events.DeliveryFailed || ev.Data["topic"] != "news" || ev.Data["token"] != "t" {
+			t.Errorf("Unexpected event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a delivery.failed event")
+	}
+}
@@ -1,7 +1,13 @@
 package hub
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"no-spam/anomaly"
+	"no-spam/segment"
 	"no-spam/store"
+	"strings"
 	"testing"
 	"time"
 )
@@ -115,3 +121,227 @@ func TestSubscribe_HistoryReplay(t *testing.T) {
 		t.Errorf("Expected 2 replayed messages, got %d", len(mc.SentMessages))
 	}
 }
+
+func TestOnPublish(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("hooked")
+
+	var gotTopic string
+	var gotPayload string
+	var gotID int64
+	h.OnPublish(func(msg Message, messageID int64) {
+		gotTopic = msg.Topic
+		gotPayload = string(msg.Payload)
+		gotID = messageID
+	})
+
+	if err := h.Route(context.Background(), Message{Topic: "hooked", Payload: []byte(`{"x":1}`)}); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	if gotTopic != "hooked" || gotPayload != `{"x":1}` || gotID != 1 {
+		t.Errorf("Hook got topic=%q payload=%q id=%d", gotTopic, gotPayload, gotID)
+	}
+}
+
+func TestRoute_TopicSchema(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	_ = h.CreateTopic("alerts")
+
+	if err := h.SetTopicSchema("alerts", `{"type": "object"`); !errors.Is(err, ErrInvalidSchema) {
+		t.Errorf("Expected ErrInvalidSchema, got %v", err)
+	}
+	if err := h.SetTopicSchema("missing", `{}`); err != ErrTopicNotFound {
+		t.Errorf("Expected ErrTopicNotFound, got %v", err)
+	}
+
+	schema := `{"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}, "count": {"type": "integer"}}}`
+	if err := h.SetTopicSchema("alerts", schema); err != nil {
+		t.Fatalf("SetTopicSchema failed: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := h.Route(ctx, Message{Topic: "alerts", Payload: []byte(`{"title": "hi", "count": 3}`)}); err != nil {
+		t.Errorf("Expected valid payload to pass, got %v", err)
+	}
+
+	err := h.Route(ctx, Message{Topic: "alerts", Payload: []byte(`{"count": 1.5}`)})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected SchemaError, got %v", err)
+	}
+	if len(schemaErr.Errors) < 2 {
+		t.Errorf("Expected errors for missing title and non-integer count, got %v", schemaErr.Errors)
+	}
+
+	// Removing the schema accepts anything again
+	_ = h.SetTopicSchema("alerts", "")
+	if err := h.Route(ctx, Message{Topic: "alerts", Payload: []byte(`{"count": 1.5}`)}); err != nil {
+		t.Errorf("Expected payload to pass without schema, got %v", err)
+	}
+}
+
+func TestRoute_Template(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	_ = h.CreateTopic("orders")
+
+	if err := h.SaveTemplate(store.Template{Topic: "orders", Name: "shipped", Body: `{{.name`}); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Expected ErrInvalidTemplate, got %v", err)
+	}
+	_ = h.SaveTemplate(store.Template{Topic: "orders", Name: "shipped",
+		Body: `{"notification": {"title": {{json .name}}, "body": "Order {{.id}} shipped"}}`})
+	_ = h.SaveTemplate(store.Template{Topic: "orders", Name: "shipped", Locale: "fr",
+		Body: `{"notification": {"title": {{json .name}}, "body": "Commande {{.id}} expédiée"}}`})
+
+	ctx := context.Background()
+	vars := map[string]interface{}{"name": `Jo "the" Bo`, "id": 7}
+
+	if err := h.Route(ctx, Message{Topic: "orders", Template: "shipped", Locale: "fr_CA", Variables: vars}); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	msgs, _ := mockStore.GetRecentMessages("orders", 10)
+	if len(msgs) != 1 || !strings.Contains(string(msgs[0].Payload), "Commande 7") || !strings.Contains(string(msgs[0].Payload), `Jo \"the\" Bo`) {
+		t.Errorf("Expected French variant with escaped name, got %+v", msgs)
+	}
+
+	if err := h.Route(ctx, Message{Topic: "orders", Template: "missing"}); err != ErrTemplateNotFound {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+	if err := h.Route(ctx, Message{Topic: "orders", Template: "shipped", Variables: map[string]interface{}{"name": "x"}}); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Expected missing variable to fail, got %v", err)
+	}
+	if err := h.Route(ctx, Message{Topic: "orders", Template: "shipped", Payload: []byte(`{}`), Variables: vars}); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Expected payload+template to fail, got %v", err)
+	}
+}
+
+func TestLocaleFallbacks(t *testing.T) {
+	got := strings.Join(localeFallbacks("zh_Hant_TW"), ",")
+	if got != "zh-hant-tw,zh-hant,zh," {
+		t.Errorf("Unexpected fallbacks: %q", got)
+	}
+}
+
+func TestRoute_Localized(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+	_ = h.CreateTopic("news")
+
+	_ = h.Subscribe("news", store.Subscriber{Token: "fr-device", Provider: "mock", Locale: "fr_CA"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "de-device", Provider: "mock", Locale: "de"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "any-device", Provider: "mock"})
+
+	err := h.Route(context.Background(), Message{
+		Topic:     "news",
+		Payload:   []byte(`{"text":"hello"}`),
+		Localized: map[string]json.RawMessage{"FR": []byte(`{"text":"bonjour"}`)},
+	})
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	payloads := map[string]string{}
+	for _, item := range mockStore.Queue {
+		payloads[item.Token] = string(item.Payload)
+	}
+	if !strings.Contains(payloads["fr-device"], "bonjour") {
+		t.Errorf("Expected French variant, got %s", payloads["fr-device"])
+	}
+	if !strings.Contains(payloads["de-device"], "hello") || !strings.Contains(payloads["any-device"], "hello") {
+		t.Errorf("Expected default payload, got %v", payloads)
+	}
+}
+
+func TestRoute_LocalizedTemplate(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	_ = h.CreateTopic("news")
+
+	_ = h.SaveTemplate(store.Template{Topic: "news", Name: "greet", Body: `{"text": "Hi {{.name}}"}`})
+	_ = h.SaveTemplate(store.Template{Topic: "news", Name: "greet", Locale: "es", Body: `{"text": "Hola {{.name}}"}`})
+	_ = h.Subscribe("news", store.Subscriber{Token: "es-device", Provider: "mock", Locale: "es-MX"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "en-device", Provider: "mock", Locale: "en"})
+
+	err := h.Route(context.Background(), Message{Topic: "news", Template: "greet", Variables: map[string]interface{}{"name": "Ana"}})
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	for _, item := range mockStore.Queue {
+		want := "Hi Ana"
+		if item.Token == "es-device" {
+			want = "Hola Ana"
+		}
+		if !strings.Contains(string(item.Payload), want) {
+			t.Errorf("%s: expected %q, got %s", item.Token, want, item.Payload)
+		}
+	}
+}
+
+func TestRoute_Segment(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	_ = h.CreateTopic("news")
+
+	_ = h.Subscribe("news", store.Subscriber{Token: "beta-android", Provider: "mock", Platform: "Android", Tags: []string{"beta"}})
+	_ = h.Subscribe("news", store.Subscriber{Token: "android", Provider: "mock", Platform: "android"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "ios", Provider: "mock", Platform: "ios", Tags: []string{"beta"}})
+
+	ctx := context.Background()
+	if err := h.Route(ctx, Message{Topic: "news", Payload: []byte(`{}`), Segment: "platform=android AND tag=beta"}); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if len(mockStore.Queue) != 1 || mockStore.Queue[0].Token != "beta-android" {
+		t.Errorf("Expected only beta-android to be targeted, got %+v", mockStore.Queue)
+	}
+
+	if err := h.Route(ctx, Message{Topic: "news", Payload: []byte(`{}`), Segment: "platform=="}); !errors.Is(err, segment.ErrInvalid) {
+		t.Errorf("Expected segment.ErrInvalid, got %v", err)
+	}
+}
+
+func TestRoute_Anomaly(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	_ = h.CreateTopic("news")
+	_ = h.CreateTopic("admin-alerts")
+
+	h.SetAnomalyDetector(anomaly.NewDetector(anomaly.Config{MinCount: 2}), "admin-alerts")
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := h.Route(ctx, Message{Topic: "news", Payload: []byte(`{}`), Publisher: "bob"}); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if err := h.Route(ctx, Message{Topic: "news", Payload: []byte(`{}`), Publisher: "bob"}); err != anomaly.ErrThrottled {
+		t.Fatalf("Expected ErrThrottled, got %v", err)
+	}
+	// Another publisher is unaffected
+	if err := h.Route(ctx, Message{Topic: "news", Payload: []byte(`{}`), Publisher: "alice"}); err != nil {
+		t.Errorf("Expected alice to be allowed, got %v", err)
+	}
+
+	// The alert is published asynchronously
+	deadline := time.Now().Add(time.Second)
+	for {
+		msgs, _ := mockStore.GetRecentMessages("admin-alerts", 10)
+		if len(msgs) == 1 {
+			if !strings.Contains(string(msgs[0].Payload), `"publisher":"bob"`) {
+				t.Errorf("Unexpected alert payload: %s", msgs[0].Payload)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected an alert on admin-alerts")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,7 +3,14 @@ package hub
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"no-spam/cluster"
+	"no-spam/queue"
 	"no-spam/store"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -183,6 +190,103 @@ func TestRoute_Broadcast(t *testing.T) {
 	}
 }
 
+func TestRoute_ApprovalThreshold(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+
+	topic := "big-topic"
+	_ = h.CreateTopic(topic)
+	for _, token := range []string{"t1", "t2"} {
+		_ = h.Subscribe(topic, store.Subscriber{Topic: topic, Token: token, Provider: "mock"})
+	}
+	if err := h.SetApprovalThreshold(topic, 2); err != nil {
+		t.Fatalf("SetApprovalThreshold failed: %v", err)
+	}
+
+	err := h.Route(context.Background(), Message{Topic: topic, Payload: json.RawMessage(`{"data":"hello"}`)})
+	pending, ok := err.(*PendingApprovalError)
+	if !ok {
+		t.Fatalf("Expected PendingApprovalError, got %v", err)
+	}
+	if pending.Audience != 2 || len(mockStore.Queue) != 0 {
+		t.Fatalf("Expected held message for 2 subscribers and nothing queued, got %+v, %d queued", pending, len(mockStore.Queue))
+	}
+
+	// Below the threshold sends go out immediately
+	res, err := h.Publish(context.Background(), Message{Topic: topic, Payload: json.RawMessage(`{}`), Segment: "tag=none"})
+	if err != nil {
+		t.Fatalf("Expected segmented send to pass, got %v", err)
+	}
+
+	// Messages are numbered as they are released, so the held one comes second
+	seq := func(id int64) int64 {
+		mockStore.mu.Lock()
+		defer mockStore.mu.Unlock()
+		var n store.Notification
+		json.Unmarshal(mockStore.Messages[id].Payload, &n)
+		return n.Seq
+	}
+	if got := seq(res.MessageID); got != 1 {
+		t.Errorf("Expected the send released first numbered 1, got %d", got)
+	}
+	if got := seq(pending.MessageID); got != 0 {
+		t.Errorf("Expected the held message unnumbered, got %d", got)
+	}
+
+	n, err := h.ApproveMessage(context.Background(), pending.MessageID, "admin")
+	if err != nil || n != 2 {
+		t.Fatalf("ApproveMessage: %d, %v", n, err)
+	}
+	if len(mockStore.Queue) != 2 {
+		t.Errorf("Expected 2 queued after approval, got %d", len(mockStore.Queue))
+	}
+	if got := seq(pending.MessageID); got != 2 {
+		t.Errorf("Expected the approved message numbered 2, got %d", got)
+	}
+	if _, err := h.ApproveMessage(context.Background(), pending.MessageID, "admin"); err != ErrApprovalNotFound {
+		t.Errorf("Expected ErrApprovalNotFound on second approval, got %v", err)
+	}
+}
+
+func TestRoute_LargeSendThreshold(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.SetLargeSendThreshold(2)
+
+	topic := "big-topic"
+	_ = h.CreateTopic(topic)
+	for _, token := range []string{"t1", "t2"} {
+		_ = h.Subscribe(topic, store.Subscriber{Topic: topic, Token: token, Provider: "mock"})
+	}
+
+	// Up to the threshold sends go out
+	if err := h.Route(context.Background(), Message{Topic: topic, Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("Expected a send at the threshold to pass, got %v", err)
+	}
+
+	_ = h.Subscribe(topic, store.Subscriber{Topic: topic, Token: "t3", Provider: "mock"})
+	err := h.Route(context.Background(), Message{Topic: topic, Payload: json.RawMessage(`{}`)})
+	var pending *PendingApprovalError
+	if !errors.As(err, &pending) || pending.Audience != 3 {
+		t.Fatalf("Expected a large send held for approval, got %v", err)
+	}
+
+	// Publishers allowed to make large sends skip approval
+	res, err := h.Publish(context.Background(), Message{Topic: topic, Payload: json.RawMessage(`{}`), LargeSend: true})
+	if err != nil || res.Enqueued != 3 {
+		t.Fatalf("Expected the large send to go out, got %+v, %v", res, err)
+	}
+
+	// The topic threshold still applies to them
+	h.SetApprovalThreshold(topic, 3)
+	if _, err := h.Publish(context.Background(), Message{Topic: topic, Payload: json.RawMessage(`{}`), LargeSend: true}); !errors.As(err, &pending) {
+		t.Errorf("Expected the topic threshold to hold the send, got %v", err)
+	}
+}
+
 func TestProcessQueue(t *testing.T) {
 	mockStore := NewMockStore()
 	h := NewHub(mockStore)
@@ -217,6 +321,23 @@ func TestProcessQueue(t *testing.T) {
 	mockStore.mu.Unlock()
 }
 
+func TestFairOrder(t *testing.T) {
+	var items []store.QueueItem
+	for id := int64(1); id <= 4; id++ {
+		items = append(items, store.QueueItem{ID: id, Topic: "big"})
+	}
+	items = append(items, store.QueueItem{ID: 6, Topic: "small"}, store.QueueItem{ID: 5, Topic: "small"}, store.QueueItem{ID: 7, Topic: "other"})
+
+	var got []int64
+	for _, item := range fairOrder(items) {
+		got = append(got, item.ID)
+	}
+	want := []int64{1, 5, 7, 2, 6, 3, 4}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected order %v, got %v", want, got)
+	}
+}
+
 func TestRoute_Direct(t *testing.T) {
 	mockStore := NewMockStore()
 	h := NewHub(mockStore)
@@ -239,3 +360,268 @@ func TestRoute_Direct(t *testing.T) {
 		t.Errorf("Expected 1 sent message, got %d", len(mc.SentMessages))
 	}
 }
+
+func TestRoute_PushQueue(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+
+	q := queue.NewMemoryQueue(10)
+	h.SetQueue(q)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.StartQueueWorkers(ctx, 2)
+
+	topic := "queued-topic"
+	h.CreateTopic(topic)
+	mockStore.AddSubscription(topic, "queued-token", "mock", "user1")
+
+	msg := Message{Topic: topic, Payload: json.RawMessage(`{"hello":"world"}`)}
+	if err := h.Route(context.Background(), msg); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mockStore.mu.Lock()
+		delivered := mockStore.DeliveredItems[1]
+		mockStore.mu.Unlock()
+		if delivered {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Queue worker did not deliver the pushed item")
+}
+
+func TestDeliver_ClaimedByOtherNode(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.SetNodeID("node-a")
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+
+	mockStore.Queue = append(mockStore.Queue, store.QueueItem{
+		ID: 7, Token: "t", Provider: "mock", Status: "pending", Payload: []byte("p"),
+	})
+	mockStore.Claims[7] = "node-b"
+
+	h.processQueue()
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.SentMessages) != 0 {
+		t.Errorf("Item claimed by another node should not be sent, got %d sends", len(mc.SentMessages))
+	}
+}
+
+// gatedConnector counts sends, which block until gate is closed.
+type gatedConnector struct {
+	sends atomic.Int64
+	gate  chan struct{}
+}
+
+func (c *gatedConnector) Send(ctx context.Context, token string, payload []byte) error {
+	c.sends.Add(1)
+	<-c.gate
+	return nil
+}
+
+func TestDeliver_SameNodeSendsOnce(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := &gatedConnector{gate: make(chan struct{})}
+	h.RegisterConnector("gated", conn)
+	mockStore.Queue = append(mockStore.Queue, store.QueueItem{
+		ID: 7, Token: "t", Provider: "gated", Status: "pending", Payload: []byte("p"),
+	})
+
+	// The node's claim covers both workers, so only the first sends
+	done := make(chan struct{})
+	go func() {
+		h.deliver("gated", "t", []byte("p"), 7, nil)
+		close(done)
+	}()
+	for deadline := time.Now().Add(2 * time.Second); conn.sends.Load() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("The first delivery never started")
+		}
+	}
+	second := make(chan struct{})
+	go func() {
+		h.deliver("gated", "t", []byte("p"), 7, nil)
+		close(second)
+	}()
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+	}
+	close(conn.gate)
+	<-done
+	<-second
+	if n := conn.sends.Load(); n != 1 {
+		t.Errorf("Expected one send while the item was in flight, got %d", n)
+	}
+
+	// Once settled, the node can claim it again, e.g. to retry
+	h.deliver("gated", "t", []byte("p"), 7, nil)
+	if n := conn.sends.Load(); n != 2 {
+		t.Errorf("Expected the item released after the first delivery, got %d sends", n)
+	}
+}
+
+func TestCluster_ForwardDelivery(t *testing.T) {
+	bus := cluster.NewLocalBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Node A has no connector for "remote"; node B does.
+	storeA := NewMockStore()
+	nodeA := NewHub(storeA)
+	nodeA.SetNodeID("node-a")
+	if err := nodeA.StartCluster(ctx, bus); err != nil {
+		t.Fatalf("StartCluster failed: %v", err)
+	}
+
+	nodeB := NewHub(storeA)
+	nodeB.SetNodeID("node-b")
+	mc := NewMockConnector()
+	nodeB.RegisterConnector("remote", mc)
+	if err := nodeB.StartCluster(ctx, bus); err != nil {
+		t.Fatalf("StartCluster failed: %v", err)
+	}
+
+	storeA.Queue = append(storeA.Queue, store.QueueItem{
+		ID: 1, Token: "t", Provider: "remote", Status: "pending", Payload: []byte("p"),
+	})
+	nodeA.processQueue()
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.SentMessages) != 1 {
+		t.Errorf("Expected node B to deliver forwarded item, got %d sends", len(mc.SentMessages))
+	}
+	if storeA.Claims[1] != "node-b" {
+		t.Errorf("Expected item to be claimed by node-b, got %q", storeA.Claims[1])
+	}
+}
+
+// sentCount returns how many messages mc sent.
+func sentCount(mc *MockConnector) int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return len(mc.SentMessages)
+}
+
+func TestQueueProcessor_Wake(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+	mockStore.CreateTopic("news")
+	msgID, _ := mockStore.SaveMessage("news", []byte("p"))
+	mockStore.EnqueueMessages(msgID, []store.QueueEntry{{Token: "t1", Provider: "mock"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.StartQueueProcessor(ctx)
+
+	waitSent := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); sentCount(mc) < n; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d sends well before the poll, got %d", n, sentCount(mc))
+			}
+		}
+	}
+	waitSent(1) // Pending when the processor started
+
+	mockStore.mu.Lock()
+	mockStore.Queue[0].Status = "failed"
+	mockStore.mu.Unlock()
+	if err := h.RequeueQueueItem("news", 1); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(2)
+}
+
+func TestCluster_Notify(t *testing.T) {
+	bus := cluster.NewLocalBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := make([]*Hub, 2)
+	for i := range nodes {
+		nodes[i] = NewHub(NewMockStore())
+		nodes[i].SetNodeID(fmt.Sprintf("node-%d", i))
+		if err := nodes[i].StartCluster(ctx, bus); err != nil {
+			t.Fatalf("StartCluster failed: %v", err)
+		}
+	}
+
+	nodes[0].Notify()
+	nodes[0].Notify() // Coalesces with the first
+	for i, n := range nodes {
+		if len(n.wake) != 1 {
+			t.Errorf("Expected node %d woken once, got %d", i, len(n.wake))
+		}
+	}
+}
+
+func TestRoute_Origin(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+	_ = h.CreateTopic("news")
+	_ = h.Subscribe("news", store.Subscriber{Topic: "news", Token: "t1", Provider: "mock"})
+	_ = h.Subscribe("news", store.Subscriber{Topic: "news", Token: "t2", Provider: "mock", Locale: "fr"})
+
+	msg := Message{
+		Topic:       "news",
+		Payload:     json.RawMessage(`{"title":"Hello"}`),
+		Localized:   map[string]json.RawMessage{"fr": json.RawMessage(`{"title":"Bonjour"}`)},
+		Publisher:   "alice",
+		ClientIP:    "203.0.113.7",
+		UserAgent:   "curl/8.5.0",
+		IncludeFrom: true,
+	}
+	if err := h.Route(context.Background(), msg); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	want := store.MessageOrigin{Publisher: "alice", IP: "203.0.113.7", UserAgent: "curl/8.5.0"}
+	if got := mockStore.Messages[1].Origin; got != want {
+		t.Errorf("Expected origin %+v, got %+v", want, got)
+	}
+
+	// Both the default payload and the localized variant name the sender
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mc.mu.Lock()
+		sent := slices.Clone(mc.SentMessages)
+		mc.mu.Unlock()
+		if len(sent) == 2 {
+			for _, m := range sent {
+				var n store.Notification
+				json.Unmarshal(m.Payload, &n)
+				if n.From != "alice" {
+					t.Errorf("Expected from alice in %s", m.Payload)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for deliveries, got %d", len(sent))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Without include_from the sender stays private; ingested messages
+	// record their source
+	if err := h.Route(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{}`), Source: "nats"}); err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if got := mockStore.Messages[2]; got.Origin.Publisher != "source:nats" || strings.Contains(string(got.Payload), `"from"`) {
+		t.Errorf("Unexpected message %+v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"no-spam/connectors"
+)
+
+// SetImageProxy serves the images and icons of published notifications
+// through this server: they are rewritten to signed links to /v1/images,
+// which fetches them with proxy. The links' key is derived from secret and
+// they start with the public URL set by SetUnsubscribeLinks. A nil proxy
+// disables it.
+func (h *Hub) SetImageProxy(secret []byte, proxy *connectors.ImageProxy) {
+	var key []byte
+	if proxy != nil {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte("no-spam image proxy"))
+		key = mac.Sum(nil)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.imageKey, h.images = key, proxy
+}
+
+// ImageProxy returns the image proxy, or nil if it is disabled.
+func (h *Hub) ImageProxy() *connectors.ImageProxy {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.images
+}
+
+// ProxiedImageURL returns the signed image proxy link for rawURL, or "" if
+// the proxy is disabled. Links don't expire.
+func (h *Hub) ProxiedImageURL(rawURL string) string {
+	h.mu.RLock()
+	key, base := h.imageKey, h.publicURL
+	h.mu.RUnlock()
+	if key == nil {
+		return ""
+	}
+	sig := base64.RawURLEncoding.EncodeToString(signLink(key, rawURL))
+	return base + "/v1/images?url=" + url.QueryEscape(rawURL) + "&sig=" + sig
+}
+
+// VerifyImageURL reports whether sig is this server's signature of an image
+// proxy link to rawURL.
+func (h *Hub) VerifyImageURL(rawURL, sig string) bool {
+	h.mu.RLock()
+	key := h.imageKey
+	h.mu.RUnlock()
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	return key != nil && err == nil && hmac.Equal(got, signLink(key, rawURL))
+}
+
+// proxyImages rewrites the image and icon of a canonical notification to
+// image proxy links, keeping every other field as published. Other payloads,
+// and all of them while the proxy is disabled, are returned as-is.
+func (h *Hub) proxyImages(payload []byte) []byte {
+	h.mu.RLock()
+	enabled, base := h.imageKey != nil, h.publicURL
+	h.mu.RUnlock()
+	if !enabled {
+		return payload
+	}
+	var p map[string]json.RawMessage
+	var n map[string]json.RawMessage
+	if json.Unmarshal(payload, &p) != nil || json.Unmarshal(p["notification"], &n) != nil || n == nil {
+		return payload
+	}
+	changed := false
+	for _, field := range []string{"image", "icon"} {
+		var raw string
+		if json.Unmarshal(n[field], &raw) != nil || raw == "" || strings.HasPrefix(raw, base+"/v1/images?") {
+			continue
+		}
+		n[field], _ = json.Marshal(h.ProxiedImageURL(raw))
+		changed = true
+	}
+	if !changed {
+		return payload
+	}
+	var err error
+	if p["notification"], err = json.Marshal(n); err != nil {
+		return payload
+	}
+	rewritten, err := json.Marshal(p)
+	if err != nil {
+		return payload
+	}
+	return rewritten
+}
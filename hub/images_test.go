@@ -0,0 +1,51 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"no-spam/connectors"
+	"no-spam/notification"
+	"no-spam/store"
+)
+
+func TestImageProxyLinks(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mock := NewMockConnector()
+	h.RegisterConnector("mock", mock)
+	h.CreateTopic("news")
+	_ = h.Subscribe("news", store.Subscriber{Token: "device", Provider: "mock"})
+
+	payload := `{"notification": {"title": "Hi", "image": "https://cdn.example.com/a.png?v=1"}, "data": {"id": "1"}}`
+	if got := h.proxyImages([]byte(payload)); string(got) != payload {
+		t.Errorf("Expected the payload kept while the proxy is disabled, got %s", got)
+	}
+
+	h.SetUnsubscribeLinks([]byte("secret"), "https://push.example.com")
+	h.SetImageProxy([]byte("secret"), connectors.NewImageProxy())
+	if _, err := h.SendSync(context.Background(), Message{Topic: "news", Payload: json.RawMessage(payload)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var envelope store.Notification
+	json.Unmarshal(mock.SentMessages[0].Payload, &envelope)
+	p, _ := notification.Parse(envelope.Payload)
+	if p == nil || !strings.HasPrefix(p.Notification.Image, "https://push.example.com/v1/images?") || p.Data["id"] != "1" {
+		t.Fatalf("Expected the image rewritten to the proxy, got %s", envelope.Payload)
+	}
+	u, _ := url.Parse(p.Notification.Image)
+	q := u.Query()
+	if q.Get("url") != "https://cdn.example.com/a.png?v=1" || !h.VerifyImageURL(q.Get("url"), q.Get("sig")) {
+		t.Errorf("Expected a signed link to the original image, got %s", p.Notification.Image)
+	}
+	if h.VerifyImageURL("https://evil.example.com/a.png", q.Get("sig")) {
+		t.Error("Expected the signature to only cover its URL")
+	}
+	if got := h.proxyImages(envelope.Payload); string(got) != string(envelope.Payload) {
+		t.Errorf("Expected proxied links kept, got %s", got)
+	}
+}
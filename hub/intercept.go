@@ -0,0 +1,135 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"no-spam/notification"
+	"no-spam/segment"
+)
+
+// ErrRejected is wrapped by interceptors rejecting a send, with the reason.
+var ErrRejected = errors.New("message rejected")
+
+// Outgoing is a topic message on its way to fan-out, as interceptors see it.
+type Outgoing struct {
+	Message
+	Match    segment.Expr      // Parsed Message.Segment; nil sends to every subscriber
+	Variants map[string][]byte // Localized payloads by locale
+}
+
+// payloads returns every payload that would be delivered.
+func (o *Outgoing) payloads() [][]byte {
+	payloads := [][]byte{o.Payload}
+	for _, v := range o.Variants {
+		payloads = append(payloads, v)
+	}
+	return payloads
+}
+
+// Interceptor inspects a topic message before it is fanned out. It may
+// change or enrich out, or reject the send by returning an error, such as
+// one wrapping ErrRejected.
+type Interceptor func(ctx context.Context, out *Outgoing) error
+
+type namedInterceptor struct {
+	name string
+	run  Interceptor
+}
+
+// builtinInterceptors check every topic send, in this order. Rendering the
+// template comes first so the next ones see the payload delivered.
+var builtinInterceptors = []struct {
+	name string
+	run  func(h *Hub, ctx context.Context, out *Outgoing) error
+}{
+	{"callback", func(h *Hub, ctx context.Context, out *Outgoing) error { return h.checkCallback(ctx, out.Message) }},
+	{"template", (*Hub).interceptTemplate},
+	{"payload", (*Hub).interceptPayload},
+	{"size", func(h *Hub, _ context.Context, out *Outgoing) error { return h.checkPayloadSize(out.payloads()...) }},
+	{"filters", func(h *Hub, _ context.Context, out *Outgoing) error {
+		return h.checkFilters(out.Message, out.payloads()...)
+	}},
+}
+
+// Intercept adds an interceptor run on every topic send after the built-in
+// ones (callback, template, payload, size and filters) and those added
+// before it. Images are proxied after the last one, so those it adds are too.
+func (h *Hub) Intercept(name string, i Interceptor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.interceptors = append(h.interceptors, namedInterceptor{name, i})
+}
+
+// interceptorChain returns the built-in interceptors, then the added ones.
+func (h *Hub) interceptorChain() []namedInterceptor {
+	h.mu.RLock()
+	added := h.interceptors
+	h.mu.RUnlock()
+
+	chain := make([]namedInterceptor, 0, len(builtinInterceptors)+len(added))
+	for _, b := range builtinInterceptors {
+		run := b.run
+		chain = append(chain, namedInterceptor{b.name, func(ctx context.Context, out *Outgoing) error { return run(h, ctx, out) }})
+	}
+	return append(chain, added...)
+}
+
+// intercept runs out through the interceptor chain, stopping at the first
+// rejection.
+func (h *Hub) intercept(ctx context.Context, out *Outgoing) error {
+	for _, i := range h.interceptorChain() {
+		if err := i.run(ctx, out); err != nil {
+			if errors.Is(err, ErrRejected) {
+				return fmt.Errorf("%s: %w", i.name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// interceptTemplate renders the payload of a send by template.
+func (h *Hub) interceptTemplate(_ context.Context, out *Outgoing) error {
+	if out.Template == "" {
+		return nil
+	}
+	if len(out.Payload) > 0 && string(out.Payload) != "null" {
+		return fmt.Errorf("%w: payload and template are mutually exclusive", ErrInvalidTemplate)
+	}
+	if len(out.Localized) > 0 {
+		return fmt.Errorf("%w: localized payloads can't be combined with a template", ErrInvalidTemplate)
+	}
+	payload, err := h.renderTemplate(out.Topic, out.Template, out.Locale, out.Variables)
+	if err != nil {
+		return err
+	}
+	out.Payload = payload
+	return nil
+}
+
+// interceptPayload checks the payload against the topic schema and the
+// notification format, parses the segment and collects the localized
+// variants.
+func (h *Hub) interceptPayload(_ context.Context, out *Outgoing) error {
+	if err := h.validatePayload(out.Topic, out.Payload); err != nil {
+		return err
+	}
+	if _, err := notification.Parse(out.Payload); err != nil {
+		return err
+	}
+	if out.Segment != "" {
+		seg, err := segment.Parse(out.Segment)
+		if err != nil {
+			return err
+		}
+		out.Match = seg
+	}
+	variants, err := h.localizedVariants(out.Message)
+	if err != nil {
+		return err
+	}
+	out.Variants = variants
+	return nil
+}
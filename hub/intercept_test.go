@@ -0,0 +1,71 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"no-spam/store"
+)
+
+func TestIntercept(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := NewMockConnector()
+	h.RegisterConnector("mock", conn)
+	h.CreateTopic("news")
+	_ = h.Subscribe("news", store.Subscriber{Token: "t1", Provider: "mock"})
+	h.SaveTemplate(store.Template{Topic: "news", Name: "greeting", Body: `{"notification": {"title": "Hello {{.name}}"}}`})
+
+	var order []string
+	h.Intercept("tag", func(ctx context.Context, out *Outgoing) error {
+		order = append(order, "tag")
+		var p map[string]any
+		if err := json.Unmarshal(out.Payload, &p); err != nil {
+			return err
+		}
+		p["data"] = map[string]string{"source": "interceptor"}
+		out.Payload, _ = json.Marshal(p)
+		return nil
+	})
+	h.Intercept("spam", func(ctx context.Context, out *Outgoing) error {
+		order = append(order, "spam")
+		if strings.Contains(string(out.Payload), "Spammer") {
+			return fmt.Errorf("%w: no spam", ErrRejected)
+		}
+		return nil
+	})
+
+	// Interceptors see the rendered template and run in order
+	if _, err := h.SendSync(context.Background(), Message{Topic: "news", Template: "greeting", Variables: map[string]interface{}{"name": "Ann"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != "tag" || order[1] != "spam" {
+		t.Errorf("Expected interceptors to run in order, got %v", order)
+	}
+	if len(conn.SentMessages) != 1 || !strings.Contains(string(conn.SentMessages[0].Payload), `"source":"interceptor"`) {
+		t.Fatalf("Expected the enriched payload delivered, got %+v", conn.SentMessages)
+	}
+
+	_, err := h.SendSync(context.Background(), Message{Topic: "news", Template: "greeting", Variables: map[string]interface{}{"name": "Spammer"}})
+	if !errors.Is(err, ErrRejected) || !strings.HasPrefix(err.Error(), "spam: ") {
+		t.Errorf("Expected a rejection by the spam interceptor, got %v", err)
+	}
+	if len(conn.SentMessages) != 1 {
+		t.Errorf("Expected the rejected message not to be delivered, got %d deliveries", len(conn.SentMessages))
+	}
+
+	// Built-in checks run first: a payload over the cap never reaches them
+	order = nil
+	h.SetMaxPayloadSize(10)
+	var tooLarge *PayloadTooLargeError
+	if _, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"notification": {"title": "Hi"}}`)}); !errors.As(err, &tooLarge) {
+		t.Errorf("Expected PayloadTooLargeError, got %v", err)
+	}
+	if len(order) != 0 {
+		t.Errorf("Expected no interceptor to run for a payload over the cap, got %v", order)
+	}
+}
@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"fmt"
+	"time"
+)
+
+// PayloadTooLargeError is returned by Route when a payload, or one of its
+// localized variants, exceeds the configured size cap.
+type PayloadTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("payload is %d bytes, the limit is %d", e.Size, e.Limit)
+}
+
+// SetMaxPayloadSize caps the size in bytes of each message payload. 0 disables the cap.
+func (h *Hub) SetMaxPayloadSize(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxPayload = n
+}
+
+// checkPayloadSize enforces the payload cap on every rendered payload.
+func (h *Hub) checkPayloadSize(payloads ...[]byte) error {
+	h.mu.RLock()
+	limit := h.maxPayload
+	h.mu.RUnlock()
+	if limit <= 0 {
+		return nil
+	}
+	for _, p := range payloads {
+		if len(p) > limit {
+			return &PayloadTooLargeError{Size: len(p), Limit: limit}
+		}
+	}
+	return nil
+}
+
+// QueueFullError is returned by Route when the pending queue is at its
+// configured depth, globally or for the message's topic.
+type QueueFullError struct {
+	Topic      string // "" for the global limit
+	Depth      int64
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	if e.Topic != "" {
+		return fmt.Sprintf("topic %s has %d pending deliveries, the limit is %d", e.Topic, e.Depth, e.Limit)
+	}
+	return fmt.Sprintf("queue has %d pending deliveries, the limit is %d", e.Depth, e.Limit)
+}
+
+// queueRetryAfter is how long publishers are asked to wait when the queue is full.
+const queueRetryAfter = 30 * time.Second
+
+// SetMaxQueueDepth caps the pending deliveries, in total and per topic. A
+// publish is rejected while a cap is reached. 0 disables a cap.
+func (h *Hub) SetMaxQueueDepth(total, perTopic int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxQueue, h.maxTopicQ = total, perTopic
+}
+
+// checkQueueDepth rejects a publish to topic while the queue is full.
+func (h *Hub) checkQueueDepth(topic string) error {
+	h.mu.RLock()
+	total, perTopic := h.maxQueue, h.maxTopicQ
+	h.mu.RUnlock()
+
+	if total > 0 {
+		depth, err := h.store.CountPending("")
+		if err != nil {
+			return fmt.Errorf("failed to count pending deliveries: %v", err)
+		}
+		if depth >= int64(total) {
+			return &QueueFullError{Depth: depth, Limit: total, RetryAfter: queueRetryAfter}
+		}
+	}
+	if perTopic > 0 {
+		depth, err := h.store.CountPending(topic)
+		if err != nil {
+			return fmt.Errorf("failed to count pending deliveries: %v", err)
+		}
+		if depth >= int64(perTopic) {
+			return &QueueFullError{Topic: topic, Depth: depth, Limit: perTopic, RetryAfter: queueRetryAfter}
+		}
+	}
+	return nil
+}
+
+// TopicLimitError is returned by CreateUserTopic when a user already owns
+// as many topics as allowed.
+type TopicLimitError struct {
+	Owner string
+	Count int
+	Limit int
+}
+
+func (e *TopicLimitError) Error() string {
+	return fmt.Sprintf("%s owns %d topics, the limit is %d", e.Owner, e.Count, e.Limit)
+}
+
+// SetMaxUserTopics caps how many topics each publisher may create in their
+// own namespace. 0 disables self-service topic creation.
+func (h *Hub) SetMaxUserTopics(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.userTopics = n
+}
@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"strings"
+
+	"no-spam/notification"
+)
+
+// normalizeLocale lowercases a locale and uses "-" as separator ("pt_BR" -> "pt-br").
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// localeFallbacks lists the variants to try for a locale: "fr-ca", "fr", "".
+func localeFallbacks(locale string) []string {
+	locale = normalizeLocale(locale)
+	var out []string
+	for locale != "" {
+		out = append(out, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return append(out, "")
+}
+
+// localizedVariants returns the non-default payload variants of a topic
+// message, keyed by normalized locale. They come from msg.Localized or, for a
+// template send without an explicit locale, from the template's locale variants.
+// Every variant is validated like the default payload.
+func (h *Hub) localizedVariants(msg Message) (map[string][]byte, error) {
+	variants := map[string][]byte{}
+
+	for locale, payload := range msg.Localized {
+		variants[normalizeLocale(locale)] = payload
+	}
+
+	if msg.Template != "" && msg.Locale == "" {
+		templates, err := h.store.ListTemplates(msg.Topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range templates {
+			if t.Name != msg.Template || t.Locale == "" {
+				continue
+			}
+			payload, err := h.renderTemplate(msg.Topic, t.Name, t.Locale, msg.Variables)
+			if err != nil {
+				return nil, err
+			}
+			variants[t.Locale] = payload
+		}
+	}
+
+	for _, payload := range variants {
+		if err := h.validatePayload(msg.Topic, payload); err != nil {
+			return nil, err
+		}
+		if _, err := notification.Parse(payload); err != nil {
+			return nil, err
+		}
+	}
+	return variants, nil
+}
+
+// pickVariant returns the closest variant for a subscriber locale, or nil
+// when the default payload applies.
+func pickVariant(variants map[string][]byte, locale string) []byte {
+	if len(variants) == 0 || locale == "" {
+		return nil
+	}
+	for _, l := range localeFallbacks(locale) {
+		if v, ok := variants[l]; ok {
+			return v
+		}
+	}
+	return nil
+}
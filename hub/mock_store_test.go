@@ -1,22 +1,47 @@
 package hub
 
 import (
+	"cmp"
+	"encoding/json"
 	"errors"
 	"no-spam/store"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 // MockStore is an in-memory implementation of store.Store for testing
 type MockStore struct {
 	mu             sync.Mutex
 	Topics         map[string]bool
+	TopicSchemas   map[string]string
+	Templates      map[string]store.Template     // Key: topic/name/locale
 	Subscriptions  map[string][]store.Subscriber // Key: Topic
 	Users          map[string]store.User
 	Messages       map[int64]store.Message
 	MessageSeq     int64
 	Queue          []store.QueueItem
 	QueueSeq       int64
-	DeliveredItems map[int64]bool // Key: QueueID
+	Attempts       []store.Attempt
+	DeliveredItems map[int64]bool   // Key: QueueID
+	Claims         map[int64]string // Key: QueueID, Value: NodeID
+	FilterRules    []store.FilterRule
+	FilterSeq      int64
+	ModerationLog  []store.ModerationEntry
+	Thresholds     map[string]int
+	Sequences      map[string]int64
+	TopicInfos     map[string]store.TopicInfo
+	Aliases        map[string]string
+	Approvals      []store.Approval
+	AuditLog       []store.AuditEvent
+	Roles          map[string]store.Role
+	Invitations    map[string]store.Invitation
+	RecoveryCodes  map[string][]string // Key: username
+	Stats          []store.StatPoint
+	Schedules      []store.Schedule
+	ScheduleRuns   []store.ScheduleRun
+	Drafts         []store.Draft
 
 	// Error simulation
 	FailAll bool
@@ -29,6 +54,7 @@ func NewMockStore() *MockStore {
 		Users:          make(map[string]store.User),
 		Messages:       make(map[int64]store.Message),
 		DeliveredItems: make(map[int64]bool),
+		Claims:         make(map[int64]string),
 	}
 }
 
@@ -45,6 +71,35 @@ func (m *MockStore) CreateTopic(name string) error {
 	return nil
 }
 
+func (m *MockStore) CreateOwnedTopic(name, owner, prefix string, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	count := 0
+	for topic := range m.Topics {
+		if m.TopicInfos[topic].Owner == owner && strings.HasPrefix(topic, prefix) {
+			count++
+		}
+	}
+	if count >= limit {
+		return count, store.ErrLimitReached
+	}
+	if m.Topics[name] {
+		return count, store.ErrDuplicate
+	}
+	if m.Topics == nil {
+		m.Topics = make(map[string]bool)
+	}
+	if m.TopicInfos == nil {
+		m.TopicInfos = make(map[string]store.TopicInfo)
+	}
+	m.Topics[name] = true
+	m.TopicInfos[name] = store.TopicInfo{Name: name, Owner: owner}
+	return count, nil
+}
+
 func (m *MockStore) DeleteTopic(name string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -65,6 +120,90 @@ func (m *MockStore) TopicExists(name string) (bool, error) {
 	return m.Topics[name], nil
 }
 
+func (m *MockStore) SetTopicSchema(name, schema string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if !m.Topics[name] {
+		return errors.New("topic not found")
+	}
+	if m.TopicSchemas == nil {
+		m.TopicSchemas = make(map[string]string)
+	}
+	if schema == "" {
+		delete(m.TopicSchemas, name)
+	} else {
+		m.TopicSchemas[name] = schema
+	}
+	return nil
+}
+
+func (m *MockStore) GetTopicSchema(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return "", errors.New("mock error")
+	}
+	return m.TopicSchemas[name], nil
+}
+
+func (m *MockStore) SaveTemplate(t store.Template) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if m.Templates == nil {
+		m.Templates = make(map[string]store.Template)
+	}
+	m.Templates[t.Topic+"/"+t.Name+"/"+t.Locale] = t
+	return nil
+}
+
+func (m *MockStore) GetTemplate(topic, name, locale string) (*store.Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	t, ok := m.Templates[topic+"/"+name+"/"+locale]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (m *MockStore) ListTemplates(topic string) ([]store.Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	templates := []store.Template{}
+	for _, t := range m.Templates {
+		if t.Topic == topic {
+			templates = append(templates, t)
+		}
+	}
+	return templates, nil
+}
+
+func (m *MockStore) DeleteTemplate(topic, name, locale string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	for k, t := range m.Templates {
+		if t.Topic == topic && t.Name == name && (locale == "*" || t.Locale == locale) {
+			delete(m.Templates, k)
+		}
+	}
+	return nil
+}
+
 func (m *MockStore) ListTopics() ([]string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -114,192 +253,1562 @@ func (m *MockStore) RemoveSubscription(topic, token string) error {
 	return nil
 }
 
-func (m *MockStore) GetSubscribers(topic string) ([]store.Subscriber, error) {
+func (m *MockStore) SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.FailAll {
-		return nil, errors.New("mock error")
+		return errors.New("mock error")
+	}
+	for i, s := range m.Subscriptions[topic] {
+		if s.Token == token {
+			m.Subscriptions[topic][i].Platform = platform
+			m.Subscriptions[topic][i].AppVersion = appVersion
+			m.Subscriptions[topic][i].Tags = tags
+		}
 	}
-	return m.Subscriptions[topic], nil
-}
-
-// Users
-func (m *MockStore) CreateUser(username, passwordHash, role string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.Users[username] = store.User{Username: username, PasswordHash: passwordHash, Role: role}
 	return nil
 }
-func (m *MockStore) DeleteUser(username string) error             { return nil }
-func (m *MockStore) ListUsers() ([]store.User, error)             { return nil, nil }
-func (m *MockStore) GetUser(username string) (*store.User, error) { return nil, nil }
-func (m *MockStore) HasAdminUser() (bool, error)                  { return false, nil }
-func (m *MockStore) UpdateUserRole(username, role string) error   { return nil }
 
-// Messages and Queue
-func (m *MockStore) SaveMessage(topic string, payload []byte) (int64, error) {
+func (m *MockStore) UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.FailAll {
-		return 0, errors.New("mock error")
+		return nil, errors.New("mock error")
 	}
-	m.MessageSeq++
-	id := m.MessageSeq
-	m.Messages[id] = store.Message{
-		ID:      id,
-		Topic:   topic,
-		Payload: payload,
+	for i, s := range m.Subscriptions[topic] {
+		if s.Token != token {
+			continue
+		}
+		drop := map[string]bool{}
+		for _, t := range remove {
+			drop[t] = true
+		}
+		seen := map[string]bool{}
+		tags := []string{}
+		for _, t := range append(s.Tags, add...) {
+			if !seen[t] && !drop[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+		m.Subscriptions[topic][i].Tags = tags
+		return tags, nil
 	}
-	return id, nil
+	return nil, errors.New("subscription not found")
 }
 
-func (m *MockStore) EnqueueMessage(messageID int64, token string) (int64, error) {
+func (m *MockStore) RemoveSubscriptionsByTag(username, tag string) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.FailAll {
 		return 0, errors.New("mock error")
 	}
-
-	msg, ok := m.Messages[messageID]
-	if !ok {
-		return 0, errors.New("message not found")
+	var removed int64
+	for topic, subs := range m.Subscriptions {
+		kept := subs[:0]
+		for _, s := range subs {
+			match := false
+			for _, t := range s.Tags {
+				match = match || t == tag
+			}
+			if match && s.Username == username {
+				removed++
+				continue
+			}
+			kept = append(kept, s)
+		}
+		m.Subscriptions[topic] = kept
 	}
+	return removed, nil
+}
 
-	m.QueueSeq++
-	id := m.QueueSeq
-	item := store.QueueItem{
-		ID:        id,
-		MessageID: messageID,
-		Token:     token,
-		Status:    "pending",
-		Payload:   msg.Payload,
+func (m *MockStore) ReassignSubscriptions(from, to string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for topic, subs := range m.Subscriptions {
+		kept := subs[:0]
+		for _, s := range subs {
+			if s.Username == from {
+				n++
+				if to == "" {
+					continue
+				}
+				s.Username = to
+			}
+			kept = append(kept, s)
+		}
+		m.Subscriptions[topic] = kept
 	}
-	m.Queue = append(m.Queue, item)
-	return id, nil
+	return n, nil
 }
 
-func (m *MockStore) GetAllPendingMessages() ([]store.QueueItem, error) {
+func (m *MockStore) SetSubscriptionPreferences(topic, token string, prefs *store.Preferences) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if m.FailAll {
-		return nil, errors.New("mock error")
+	for i, s := range m.Subscriptions[topic] {
+		if s.Token == token {
+			m.Subscriptions[topic][i].Preferences = prefs
+		}
 	}
+	return nil
+}
 
-	var pending []store.QueueItem
-	for _, item := range m.Queue {
-		if item.Status == "pending" {
-			pending = append(pending, item)
+func (m *MockStore) CountQueuedSince(topic string, tokens []string, since time.Time) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[string]int{}
+	for _, q := range m.Queue {
+		if m.Messages[q.MessageID].Topic == topic && !q.CreatedAt.Before(since) && slices.Contains(tokens, q.Token) {
+			counts[q.Token]++
 		}
 	}
-	return pending, nil
+	return counts, nil
 }
 
-func (m *MockStore) MarkDelivered(queueID int64) error {
+func (m *MockStore) MarkTokensSeen(tokens []string, at time.Time) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if m.FailAll {
-		return errors.New("mock error")
+	for _, subs := range m.Subscriptions {
+		for i := range subs {
+			if slices.Contains(tokens, subs[i].Token) {
+				subs[i].LastSeenAt = &at
+			}
+		}
 	}
+	return nil
+}
 
-	for i, item := range m.Queue {
-		if item.ID == queueID {
-			m.Queue[i].Status = "delivered"
-			m.DeliveredItems[queueID] = true
-			return nil
-		}
+// mockStale reports whether sub wasn't seen since before.
+func mockStale(sub store.Subscriber, before time.Time) bool {
+	seen := sub.LastSeenAt
+	if seen == nil {
+		seen = sub.CreatedAt
 	}
-	return errors.New("queue item not found")
+	return seen != nil && seen.Before(before)
 }
 
-// Previously failing stubs - now implemented
-func (m *MockStore) GetRecentMessages(topic string, limit int) ([]store.Message, error) {
+func (m *MockStore) ListStaleSubscriptions(before time.Time, limit int) ([]store.Subscriber, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if m.FailAll {
-		return nil, errors.New("mock error")
+	var stale []store.Subscriber
+	for _, subs := range m.Subscriptions {
+		for _, s := range subs {
+			if mockStale(s, before) && len(stale) < limit {
+				stale = append(stale, s)
+			}
+		}
 	}
-	var msgs []store.Message
-	for _, msg := range m.Messages {
-		if msg.Topic == topic {
-			msgs = append(msgs, msg)
+	return stale, nil
+}
+
+func (m *MockStore) RemoveStaleSubscriptions(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for topic, subs := range m.Subscriptions {
+		kept := subs[:0]
+		for _, s := range subs {
+			if mockStale(s, before) {
+				n++
+				continue
+			}
+			kept = append(kept, s)
 		}
+		m.Subscriptions[topic] = kept
 	}
-	return msgs, nil
+	return n, nil
 }
 
-func (m *MockStore) ClearTopicMessages(topic string) error {
+func (m *MockStore) SetSubscriptionLocale(topic, token, locale string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.FailAll {
 		return errors.New("mock error")
 	}
-	// Simplified: no-op for mock if we don't need verification
+	for i, s := range m.Subscriptions[topic] {
+		if s.Token == token {
+			m.Subscriptions[topic][i].Locale = locale
+		}
+	}
 	return nil
 }
 
-func (m *MockStore) ClearTopicSubscribers(topic string) error {
+func (m *MockStore) SetSubscriptionOptions(topic, token string, opts *store.WebhookOptions) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.FailAll {
 		return errors.New("mock error")
 	}
-	delete(m.Subscriptions, topic)
+	for i, s := range m.Subscriptions[topic] {
+		if s.Token == token {
+			m.Subscriptions[topic][i].Options = opts
+		}
+	}
 	return nil
 }
 
-func (m *MockStore) GetPendingMessages(token string) ([]store.QueueItem, error) {
-	return nil, nil
+func (m *MockStore) ListSubscribersAfter(topic, after string, limit int) ([]store.Subscriber, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var subs []store.Subscriber
+	for _, sub := range m.Subscriptions[topic] {
+		if sub.Token > after {
+			subs = append(subs, sub)
+		}
+	}
+	slices.SortFunc(subs, func(a, b store.Subscriber) int { return strings.Compare(a.Token, b.Token) })
+	if len(subs) > limit {
+		subs = subs[:limit]
+	}
+	return subs, nil
 }
 
-func (m *MockStore) GetPendingMessagesByTopic(topic string) ([]store.QueueItem, error) {
+func (m *MockStore) GetSubscribers(topic string) ([]store.Subscriber, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.FailAll {
 		return nil, errors.New("mock error")
 	}
-	return m.Queue, nil
+	return slices.Clone(m.Subscriptions[topic]), nil // Like the stores, callers may filter it in place
 }
 
-func (m *MockStore) GetTotalMessagesSent() (int64, error) {
+// Users
+func (m *MockStore) CreateUser(username, passwordHash, role string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return int64(len(m.DeliveredItems)), nil
+	m.Users[username] = store.User{Username: username, PasswordHash: passwordHash, Role: role}
+	return nil
 }
-
-func (m *MockStore) GetSubscriptionCount() (int, error) {
+func (m *MockStore) DeleteUser(username string) error { return nil }
+func (m *MockStore) ListUsers() ([]store.User, error) { return nil, nil }
+func (m *MockStore) GetUser(username string) (*store.User, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	count := 0
-	for _, subs := range m.Subscriptions {
-		count += len(subs)
+	if u, ok := m.Users[username]; ok {
+		return &u, nil
 	}
-	return count, nil
+	return nil, nil
 }
+func (m *MockStore) HasAdminUser() (bool, error)                                { return false, nil }
+func (m *MockStore) UpdateUserRole(username, role string) error                 { return nil }
+func (m *MockStore) SetMustChangePassword(username string, required bool) error { return nil }
+func (m *MockStore) SetUserPassword(username, passwordHash string) error        { return nil }
+func (m *MockStore) SetUserDisabled(username string, disabled bool) error       { return nil }
+func (m *MockStore) SetUserAccess(username, role string, disabled bool) error   { return nil }
+func (m *MockStore) SetUserProfile(username, displayName, email string) error   { return nil }
+func (m *MockStore) LinkUser(username, externalID string) (bool, error)         { return true, nil }
+func (m *MockStore) UseTOTPStep(username string, step int64) (bool, error)      { return true, nil }
+func (m *MockStore) AddTOTPFailure(username string) (int, error)                { return 1, nil }
 
-func (m *MockStore) GetSubscriptionsByToken(token string) ([]store.Subscriber, error) {
+func (m *MockStore) IncrementUnread(usernames []string) (map[string]int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var result []store.Subscriber
-	for _, subs := range m.Subscriptions {
-		for _, s := range subs {
-			if s.Token == token {
-				result = append(result, s)
-			}
+	counts := map[string]int{}
+	for _, username := range usernames {
+		if u, ok := m.Users[username]; ok {
+			u.Unread++
+			m.Users[username] = u
+			counts[username] = u.Unread
 		}
 	}
-	return result, nil
+	return counts, nil
 }
 
-func (m *MockStore) GetSubscriptionsByUser(username string) ([]store.Subscriber, error) {
+func (m *MockStore) SetUnread(username string, count int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	var result []store.Subscriber
-	for _, subs := range m.Subscriptions {
-		for _, s := range subs {
-			if s.Username == username {
-				result = append(result, s)
-			}
-		}
+	u, ok := m.Users[username]
+	if !ok {
+		return store.ErrNotFound
 	}
-	return result, nil
+	u.Unread = count
+	m.Users[username] = u
+	return nil
+}
+
+// Messages and Queue
+func (m *MockStore) SaveMessage(topic string, payload []byte) (int64, error) {
+	return m.SaveMessageFrom(topic, payload, store.MessageOrigin{})
+}
+
+func (m *MockStore) SaveMessageFrom(topic string, payload []byte, origin store.MessageOrigin) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	m.MessageSeq++
+	id := m.MessageSeq
+	m.Messages[id] = store.Message{
+		ID:      id,
+		Topic:   topic,
+		Payload: payload,
+		Origin:  origin,
+	}
+	return id, nil
+}
+
+func (m *MockStore) SaveSequencedMessage(topic string, origin store.MessageOrigin, seal func(seq int64) ([]byte, error)) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	payload, err := m.sealSequenced(topic, seal)
+	if err != nil {
+		return 0, err
+	}
+	m.MessageSeq++
+	id := m.MessageSeq
+	m.Messages[id] = store.Message{
+		ID:      id,
+		Topic:   topic,
+		Payload: payload,
+		Origin:  origin,
+	}
+	return id, nil
 }
+
+func (m *MockStore) SequenceMessage(id int64, seal func(seq int64) ([]byte, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	msg, ok := m.Messages[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	payload, err := m.sealSequenced(msg.Topic, seal)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	m.Messages[id] = msg
+	return nil
+}
+
+// sealSequenced counts the topic's next sequence number once seal succeeds.
+// The caller holds mu.
+func (m *MockStore) sealSequenced(topic string, seal func(seq int64) ([]byte, error)) ([]byte, error) {
+	if m.Sequences == nil {
+		m.Sequences = make(map[string]int64)
+	}
+	payload, err := seal(m.Sequences[topic] + 1)
+	if err != nil {
+		return nil, err
+	}
+	m.Sequences[topic]++
+	return payload, nil
+}
+
+func (m *MockStore) EnqueueMessage(messageID int64, token string) (int64, error) {
+	return m.EnqueueMessagePayload(messageID, token, nil)
+}
+
+func (m *MockStore) EnqueueMessagePayload(messageID int64, token string, payload []byte) (int64, error) {
+	return m.enqueue(messageID, store.QueueEntry{Token: token, Payload: payload})
+}
+
+func (m *MockStore) enqueue(messageID int64, e store.QueueEntry) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+
+	msg, ok := m.Messages[messageID]
+	if !ok {
+		return 0, errors.New("message not found")
+	}
+
+	payload := e.Payload
+	if payload == nil {
+		payload = msg.Payload
+	}
+
+	m.QueueSeq++
+	id := m.QueueSeq
+	item := store.QueueItem{
+		ID:        id,
+		MessageID: messageID,
+		Topic:     msg.Topic,
+		Token:     e.Token,
+		Provider:  e.Provider,
+		Status:    "pending",
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+	m.Queue = append(m.Queue, item)
+	return id, nil
+}
+
+func (m *MockStore) EnqueueMessages(messageID int64, entries []store.QueueEntry) ([]int64, error) {
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		id, err := m.enqueue(messageID, e)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (m *MockStore) CountPending(topic string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	var count int64
+	for _, item := range m.Queue {
+		if item.Status == "pending" && (topic == "" || m.Messages[item.MessageID].Topic == topic) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockStore) GetAllPendingMessages() ([]store.QueueItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+
+	var pending []store.QueueItem
+	for _, item := range m.Queue {
+		if item.Status == "pending" {
+			pending = append(pending, item)
+		}
+	}
+	return pending, nil
+}
+
+func (m *MockStore) GetQueueItemsByMessage(messageID int64) ([]store.QueueItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	var items []store.QueueItem
+	for _, item := range m.Queue {
+		if item.MessageID == messageID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *MockStore) ListQueueOutcomes(since time.Time) ([]store.QueueItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	var items []store.QueueItem
+	for _, item := range m.Queue {
+		if !item.CreatedAt.Before(since) && (item.Status == "delivered" || item.Status == "failed") {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *MockStore) MarkDelivered(queueID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+
+	for i, item := range m.Queue {
+		if item.ID == queueID {
+			t := time.Now()
+			m.Queue[i].Status, m.Queue[i].DeliveredAt = "delivered", &t
+			m.DeliveredItems[queueID] = true
+			return nil
+		}
+	}
+	return errors.New("queue item not found")
+}
+
+func (m *MockStore) MarkOpened(messageID int64, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := false
+	for i, item := range m.Queue {
+		if item.MessageID == messageID && item.Token == token {
+			if item.OpenedAt == nil {
+				t := time.Now()
+				m.Queue[i].OpenedAt = &t
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+func (m *MockStore) ListMessageOpens(limit int) ([]store.MessageOpens, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byMessage := map[int64]*store.MessageOpens{}
+	for _, item := range m.Queue {
+		o := byMessage[item.MessageID]
+		if o == nil {
+			o = &store.MessageOpens{MessageID: item.MessageID, Topic: m.Messages[item.MessageID].Topic}
+			byMessage[item.MessageID] = o
+		}
+		if item.Status == "delivered" {
+			o.Delivered++
+		}
+		if item.OpenedAt != nil {
+			o.Opened++
+		}
+	}
+	var opens []store.MessageOpens
+	for _, o := range byMessage {
+		if o.Delivered > 0 {
+			opens = append(opens, *o)
+		}
+	}
+	slices.SortFunc(opens, func(a, b store.MessageOpens) int { return cmp.Compare(b.MessageID, a.MessageID) })
+	if len(opens) > limit {
+		opens = opens[:limit]
+	}
+	return opens, nil
+}
+
+func (m *MockStore) RecordAttempt(a store.Attempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	for i, item := range m.Queue {
+		if item.ID == a.QueueID {
+			m.Queue[i].Attempts++
+			if a.Error != "" {
+				m.Queue[i].LastError = a.Error
+			}
+			m.Attempts = append(m.Attempts, a)
+		}
+	}
+	return nil
+}
+
+func (m *MockStore) ListAttempts(topic string, queueID int64) ([]store.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	var attempts []store.Attempt
+	for _, item := range m.Queue {
+		if item.ID == queueID && m.Messages[item.MessageID].Topic == topic {
+			for _, a := range m.Attempts {
+				if a.QueueID == queueID {
+					attempts = append(attempts, a)
+				}
+			}
+		}
+	}
+	return attempts, nil
+}
+
+func (m *MockStore) ProviderStats(since time.Time) ([]store.ProviderStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	byProvider := map[string]*store.ProviderStats{}
+	seen := map[int64]bool{}
+	latency := map[string]int64{}
+	for _, a := range m.Attempts {
+		retry := seen[a.QueueID]
+		seen[a.QueueID] = true
+		if a.Provider == "" || a.AttemptedAt.Before(since) {
+			continue
+		}
+		p, ok := byProvider[a.Provider]
+		if !ok {
+			p = &store.ProviderStats{Provider: a.Provider}
+			byProvider[a.Provider] = p
+		}
+		if a.Error == "" {
+			p.Sent++
+		} else {
+			p.Failed++
+		}
+		if retry {
+			p.Retried++
+		}
+		latency[a.Provider] += a.DurationMs
+	}
+	var stats []store.ProviderStats
+	for name, p := range byProvider {
+		p.AvgLatencyMs = float64(latency[name]) / float64(p.Sent+p.Failed)
+		stats = append(stats, *p)
+	}
+	slices.SortFunc(stats, func(a, b store.ProviderStats) int { return cmp.Compare(a.Provider, b.Provider) })
+	return stats, nil
+}
+
+func (m *MockStore) MarkFailed(queueID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+
+	for i, item := range m.Queue {
+		if item.ID == queueID {
+			if item.Status == "pending" {
+				m.Queue[i].Status = "failed"
+			}
+			return nil
+		}
+	}
+	return errors.New("queue item not found")
+}
+
+func (m *MockStore) CancelQueueItem(topic string, queueID int64) (bool, error) {
+	return m.setQueueStatus(topic, queueID, "canceled", "pending")
+}
+
+func (m *MockStore) RequeueQueueItem(topic string, queueID int64) (bool, error) {
+	return m.setQueueStatus(topic, queueID, "pending", "failed", "canceled")
+}
+
+func (m *MockStore) setQueueStatus(topic string, queueID int64, status string, from ...string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	for i, item := range m.Queue {
+		if item.ID == queueID && slices.Contains(from, item.Status) && m.Messages[item.MessageID].Topic == topic {
+			m.Queue[i].Status = status
+			delete(m.Claims, queueID)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockStore) PurgeQueue(topic, token string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	var n int64
+	for i, item := range m.Queue {
+		if item.Status != "pending" || (token != "" && item.Token != token) || (topic != "" && m.Messages[item.MessageID].Topic != topic) {
+			continue
+		}
+		m.Queue[i].Status = "canceled"
+		n++
+	}
+	return n, nil
+}
+
+func (m *MockStore) ClaimQueueItem(queueID int64, nodeID string, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	if m.Claims == nil {
+		m.Claims = make(map[int64]string)
+	}
+	if owner, ok := m.Claims[queueID]; ok && owner != nodeID {
+		return false, nil
+	}
+	m.Claims[queueID] = nodeID
+	return true, nil
+}
+
+func (m *MockStore) ClaimQueueItems(queueIDs []int64, nodeID string, lease time.Duration) ([]int64, error) {
+	var claimed []int64
+	for _, id := range queueIDs {
+		ok, err := m.ClaimQueueItem(id, nodeID, lease)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			claimed = append(claimed, id)
+		}
+	}
+	return claimed, nil
+}
+
+func (m *MockStore) SettleAttempts(attempts []store.Attempt) error {
+	for _, a := range attempts {
+		if err := m.RecordAttempt(a); err != nil {
+			return err
+		}
+		if a.Error == "" {
+			if err := m.MarkDelivered(a.QueueID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MockStore) GetMessage(id int64) (*store.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	msg, ok := m.Messages[id]
+	if !ok {
+		return nil, nil
+	}
+	return &msg, nil
+}
+
+// Previously failing stubs - now implemented
+func (m *MockStore) GetRecentMessages(topic string, limit int) ([]store.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	var msgs []store.Message
+	for _, msg := range m.Messages {
+		if msg.Topic == topic {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (m *MockStore) SearchMessages(f store.MessageSearch) ([]store.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var msgs []store.Message
+	for _, msg := range m.Messages {
+		if msg.Topic == f.Topic && strings.Contains(string(msg.Payload), f.Query) {
+			msgs = append(msgs, msg)
+		}
+	}
+	slices.SortFunc(msgs, func(a, b store.Message) int { return cmp.Compare(b.ID, a.ID) })
+	return msgs, nil
+}
+
+func (m *MockStore) DeleteMessagesBefore(topic string, t time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	var n int64
+	for id, msg := range m.Messages {
+		if msg.Topic == topic && msg.CreatedAt.Before(t) {
+			delete(m.Messages, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MockStore) ClearTopicMessages(topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	// Simplified: no-op for mock if we don't need verification
+	return nil
+}
+
+func (m *MockStore) ClearTopicSubscribers(topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	delete(m.Subscriptions, topic)
+	return nil
+}
+
+func (m *MockStore) GetPendingMessages(token string) ([]store.QueueItem, error) {
+	return nil, nil
+}
+
+func (m *MockStore) GetPendingMessagesByTopic(topic string) ([]store.QueueItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	return m.Queue, nil
+}
+
+func (m *MockStore) GetTotalMessagesSent() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.DeliveredItems)), nil
+}
+
+func (m *MockStore) RecordStats(bucket time.Time, counts, peaks map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	bucket = bucket.UTC().Truncate(time.Hour)
+	point := func(metric string) *store.StatPoint {
+		for i, p := range m.Stats {
+			if p.Bucket.Equal(bucket) && p.Metric == metric {
+				return &m.Stats[i]
+			}
+		}
+		m.Stats = append(m.Stats, store.StatPoint{Bucket: bucket, Metric: metric})
+		return &m.Stats[len(m.Stats)-1]
+	}
+	for metric, n := range counts {
+		point(metric).Value += n
+	}
+	for metric, n := range peaks {
+		p := point(metric)
+		p.Value = max(p.Value, n)
+	}
+	return nil
+}
+
+func (m *MockStore) ListStats(from, to time.Time) ([]store.StatPoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var points []store.StatPoint
+	for _, p := range m.Stats {
+		if !p.Bucket.Before(from) && p.Bucket.Before(to) {
+			points = append(points, p)
+		}
+	}
+	slices.SortFunc(points, func(a, b store.StatPoint) int {
+		return cmp.Or(a.Bucket.Compare(b.Bucket), cmp.Compare(a.Metric, b.Metric))
+	})
+	return points, nil
+}
+
+func (m *MockStore) DeleteStatsBefore(t time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.Stats)
+	m.Stats = slices.DeleteFunc(m.Stats, func(p store.StatPoint) bool { return p.Bucket.Before(t) })
+	return int64(n - len(m.Stats)), nil
+}
+
+func (m *MockStore) CountSubscriptions(topic, username string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, sub := range m.Subscriptions[topic] {
+		if sub.Username == username {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockStore) GetSubscriptionCount() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, subs := range m.Subscriptions {
+		count += len(subs)
+	}
+	return count, nil
+}
+
+func (m *MockStore) GetSubscriptionsByToken(token string) ([]store.Subscriber, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []store.Subscriber
+	for _, subs := range m.Subscriptions {
+		for _, s := range subs {
+			if s.Token == token {
+				result = append(result, s)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *MockStore) GetSubscriptionsByUser(username string) ([]store.Subscriber, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []store.Subscriber
+	for _, subs := range m.Subscriptions {
+		for _, s := range subs {
+			if s.Username == username {
+				result = append(result, s)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *MockStore) CreateFilterRule(r store.FilterRule) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	m.FilterSeq++
+	r.ID = m.FilterSeq
+	r.CreatedAt = time.Now()
+	m.FilterRules = append(m.FilterRules, r)
+	return r.ID, nil
+}
+
+func (m *MockStore) ListFilterRules() ([]store.FilterRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	return append([]store.FilterRule{}, m.FilterRules...), nil
+}
+
+func (m *MockStore) DeleteFilterRule(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	for i, r := range m.FilterRules {
+		if r.ID == id {
+			m.FilterRules = append(m.FilterRules[:i], m.FilterRules[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockStore) AddModerationEntry(e store.ModerationEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	e.ID = int64(len(m.ModerationLog) + 1)
+	e.CreatedAt = time.Now()
+	m.ModerationLog = append(m.ModerationLog, e)
+	return nil
+}
+
+func (m *MockStore) ListModerationEntries(limit int) ([]store.ModerationEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	entries := []store.ModerationEntry{}
+	for i := len(m.ModerationLog) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, m.ModerationLog[i])
+	}
+	return entries, nil
+}
+
+func (m *MockStore) GetTopicInfo(name string) (*store.TopicInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	if !m.Topics[name] {
+		return nil, nil
+	}
+	info := m.TopicInfos[name]
+	info.Name = name
+	return &info, nil
+}
+
+func (m *MockStore) ListTopicInfo() ([]store.TopicInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	var topics []store.TopicInfo
+	for name := range m.Topics {
+		info := m.TopicInfos[name]
+		info.Name = name
+		topics = append(topics, info)
+	}
+	slices.SortFunc(topics, func(a, b store.TopicInfo) int { return strings.Compare(a.Name, b.Name) })
+	return topics, nil
+}
+
+func (m *MockStore) SetTopicInfo(info store.TopicInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if !m.Topics[info.Name] {
+		return errors.New("topic not found")
+	}
+	if m.TopicInfos == nil {
+		m.TopicInfos = make(map[string]store.TopicInfo)
+	}
+	m.TopicInfos[info.Name] = info
+	return nil
+}
+
+func (m *MockStore) RenameTopic(oldName, newName string, alias bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if !m.Topics[oldName] {
+		return errors.New("topic not found")
+	}
+	if m.Topics[newName] {
+		return errors.New("topic already exists")
+	}
+	delete(m.Topics, oldName)
+	m.Topics[newName] = true
+	subs := m.Subscriptions[oldName]
+	for i := range subs {
+		subs[i].Topic = newName
+	}
+	delete(m.Subscriptions, oldName)
+	if subs != nil {
+		m.Subscriptions[newName] = subs
+	}
+	for id, msg := range m.Messages {
+		if msg.Topic == oldName {
+			msg.Topic = newName
+			m.Messages[id] = msg
+		}
+	}
+	if m.Aliases == nil {
+		m.Aliases = make(map[string]string)
+	}
+	delete(m.Aliases, newName)
+	if alias {
+		m.Aliases[oldName] = newName
+	}
+	return nil
+}
+
+func (m *MockStore) ResolveTopicAlias(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Aliases[name], nil
+}
+
+func (m *MockStore) ListTopicAliases() ([]store.TopicAlias, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var aliases []store.TopicAlias
+	for alias, topic := range m.Aliases {
+		aliases = append(aliases, store.TopicAlias{Alias: alias, Topic: topic})
+	}
+	return aliases, nil
+}
+
+func (m *MockStore) DeleteTopicAlias(alias string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.Aliases[alias]
+	delete(m.Aliases, alias)
+	return ok, nil
+}
+
+func (m *MockStore) SetTopicApprovalThreshold(name string, threshold int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if m.Thresholds == nil {
+		m.Thresholds = make(map[string]int)
+	}
+	m.Thresholds[name] = threshold
+	return nil
+}
+
+func (m *MockStore) GetTopicApprovalThreshold(name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	return m.Thresholds[name], nil
+}
+
+func (m *MockStore) HoldMessage(a store.Approval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	a.Status = store.ApprovalPending
+	a.CreatedAt = time.Now()
+	m.Approvals = append(m.Approvals, a)
+	return nil
+}
+
+func (m *MockStore) ListApprovals(status string) ([]store.Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	approvals := []store.Approval{}
+	for _, a := range m.Approvals {
+		if status == "" || a.Status == status {
+			approvals = append(approvals, a)
+		}
+	}
+	return approvals, nil
+}
+
+func (m *MockStore) DecideApproval(messageID int64, status, decidedBy string) (*store.Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	for i, a := range m.Approvals {
+		if a.MessageID == messageID && a.Status == store.ApprovalPending {
+			now := time.Now()
+			m.Approvals[i].Status = status
+			m.Approvals[i].DecidedBy = decidedBy
+			m.Approvals[i].DecidedAt = &now
+			a := m.Approvals[i]
+			return &a, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockStore) CreateSchedule(sc store.Schedule) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	if !m.Topics[sc.Topic] {
+		return 0, store.ErrNotFound
+	}
+	sc.ID = 1
+	if n := len(m.Schedules); n > 0 {
+		sc.ID = m.Schedules[n-1].ID + 1
+	}
+	sc.CreatedAt = time.Now()
+	m.Schedules = append(m.Schedules, sc)
+	return sc.ID, nil
+}
+
+// schedule returns the index of the schedule with id, or -1. m.mu must be held.
+func (m *MockStore) schedule(id int64) int {
+	return slices.IndexFunc(m.Schedules, func(sc store.Schedule) bool { return sc.ID == id })
+}
+
+func (m *MockStore) GetSchedule(id int64) (*store.Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	i := m.schedule(id)
+	if i < 0 {
+		return nil, nil
+	}
+	sc := m.Schedules[i]
+	return &sc, nil
+}
+
+func (m *MockStore) ListSchedules(topic string) ([]store.Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	schedules := []store.Schedule{}
+	for _, sc := range m.Schedules {
+		if topic == "" || sc.Topic == topic {
+			schedules = append(schedules, sc)
+		}
+	}
+	return schedules, nil
+}
+
+func (m *MockStore) SetSchedulePaused(id int64, paused bool, nextRun time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	i := m.schedule(id)
+	if i < 0 {
+		return false, nil
+	}
+	m.Schedules[i].Paused, m.Schedules[i].NextRun = paused, nextRun
+	return true, nil
+}
+
+func (m *MockStore) DeleteSchedule(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	i := m.schedule(id)
+	if i < 0 {
+		return false, nil
+	}
+	m.Schedules = slices.Delete(m.Schedules, i, i+1)
+	return true, nil
+}
+
+func (m *MockStore) DueSchedules(now time.Time) ([]store.Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	due := []store.Schedule{}
+	for _, sc := range m.Schedules {
+		if !sc.Paused && !sc.NextRun.After(now) {
+			due = append(due, sc)
+		}
+	}
+	return due, nil
+}
+
+func (m *MockStore) AdvanceSchedule(id int64, from, to time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	i := m.schedule(id)
+	if i < 0 || m.Schedules[i].Paused || !m.Schedules[i].NextRun.Equal(from) {
+		return false, nil
+	}
+	m.Schedules[i].NextRun = to
+	return true, nil
+}
+
+func (m *MockStore) AddScheduleRun(r store.ScheduleRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	r.ID = int64(len(m.ScheduleRuns) + 1)
+	m.ScheduleRuns = append(m.ScheduleRuns, r)
+	return nil
+}
+
+func (m *MockStore) ListScheduleRuns(scheduleID int64, limit int) ([]store.ScheduleRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	runs := []store.ScheduleRun{}
+	for i := len(m.ScheduleRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+		if m.ScheduleRuns[i].ScheduleID == scheduleID {
+			runs = append(runs, m.ScheduleRuns[i])
+		}
+	}
+	return runs, nil
+}
+
+func (m *MockStore) CreateDraft(d store.Draft) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return 0, errors.New("mock error")
+	}
+	if !m.Topics[d.Topic] {
+		return 0, store.ErrNotFound
+	}
+	d.ID = 1
+	if n := len(m.Drafts); n > 0 {
+		d.ID = m.Drafts[n-1].ID + 1
+	}
+	d.Status, d.CreatedAt, d.UpdatedAt = store.DraftOpen, time.Now(), time.Now()
+	m.Drafts = append(m.Drafts, d)
+	return d.ID, nil
+}
+
+// draft returns the index of the draft with id, or -1. m.mu must be held.
+func (m *MockStore) draft(id int64) int {
+	return slices.IndexFunc(m.Drafts, func(d store.Draft) bool { return d.ID == id })
+}
+
+func (m *MockStore) GetDraft(id int64) (*store.Draft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	i := m.draft(id)
+	if i < 0 {
+		return nil, nil
+	}
+	d := m.Drafts[i]
+	return &d, nil
+}
+
+func (m *MockStore) ListDrafts(topic string) ([]store.Draft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	drafts := []store.Draft{}
+	for _, d := range m.Drafts {
+		if topic == "" || d.Topic == topic {
+			drafts = append(drafts, d)
+		}
+	}
+	return drafts, nil
+}
+
+func (m *MockStore) UpdateDraft(id int64, request json.RawMessage) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	i := m.draft(id)
+	if i < 0 || m.Drafts[i].Status != store.DraftOpen {
+		return false, nil
+	}
+	m.Drafts[i].Request, m.Drafts[i].UpdatedAt = request, time.Now()
+	return true, nil
+}
+
+func (m *MockStore) DeleteDraft(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	i := m.draft(id)
+	if i < 0 {
+		return false, nil
+	}
+	m.Drafts = slices.Delete(m.Drafts, i, i+1)
+	return true, nil
+}
+
+func (m *MockStore) SetDraftStatus(id int64, from, to string, messageID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	i := m.draft(id)
+	if i < 0 || m.Drafts[i].Status != from {
+		return false, nil
+	}
+	m.Drafts[i].Status, m.Drafts[i].MessageID, m.Drafts[i].PublishedAt = to, messageID, nil
+	if to == store.DraftPublished {
+		now := time.Now()
+		m.Drafts[i].PublishedAt = &now
+	}
+	return true, nil
+}
+
+func (m *MockStore) AddAuditEvent(e store.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	e.ID = int64(len(m.AuditLog) + 1)
+	e.CreatedAt = time.Now()
+	m.AuditLog = append(m.AuditLog, e)
+	return nil
+}
+
+func (m *MockStore) ListAuditEvents(f store.AuditFilter) ([]store.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	events := []store.AuditEvent{}
+	for i := len(m.AuditLog) - 1; i >= 0; i-- {
+		e := m.AuditLog[i]
+		if (f.Actor == "" || e.Actor == f.Actor) && (f.Action == "" || e.Action == f.Action) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (m *MockStore) SaveRole(r store.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if m.Roles == nil {
+		m.Roles = make(map[string]store.Role)
+	}
+	m.Roles[r.Name] = r
+	return nil
+}
+
+func (m *MockStore) GetRole(name string) (*store.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	r, ok := m.Roles[name]
+	if !ok {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (m *MockStore) ListRoles() ([]store.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	roles := []store.Role{}
+	for _, r := range m.Roles {
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+func (m *MockStore) DeleteRole(name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	_, ok := m.Roles[name]
+	delete(m.Roles, name)
+	return ok, nil
+}
+
+func (m *MockStore) CreateInvitation(inv store.Invitation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if m.Invitations == nil {
+		m.Invitations = make(map[string]store.Invitation)
+	}
+	inv.CreatedAt = time.Now()
+	m.Invitations[inv.Code] = inv
+	return nil
+}
+
+func (m *MockStore) ListInvitations() ([]store.Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	invitations := []store.Invitation{}
+	for _, inv := range m.Invitations {
+		invitations = append(invitations, inv)
+	}
+	return invitations, nil
+}
+
+func (m *MockStore) DeleteInvitation(code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	_, ok := m.Invitations[code]
+	delete(m.Invitations, code)
+	return ok, nil
+}
+
+func (m *MockStore) RedeemInvitation(code, username, passwordHash string) (*store.Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return nil, errors.New("mock error")
+	}
+	inv, ok := m.Invitations[code]
+	if !ok || inv.Uses >= inv.MaxUses || (inv.ExpiresAt != nil && !inv.ExpiresAt.After(time.Now())) {
+		return nil, nil
+	}
+	if _, exists := m.Users[username]; exists {
+		return nil, errors.New("UNIQUE constraint failed: users.username")
+	}
+	inv.Uses++
+	m.Invitations[code] = inv
+	m.Users[username] = store.User{Username: username, PasswordHash: passwordHash, Role: inv.Role}
+	return &inv, nil
+}
+
+func (m *MockStore) SetUserTOTP(username, secret string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	u, ok := m.Users[username]
+	if !ok {
+		return errors.New("user not found")
+	}
+	u.TOTPSecret, u.TOTPEnabled = secret, enabled && secret != ""
+	m.Users[username] = u
+	return nil
+}
+
+func (m *MockStore) SetRecoveryCodes(username string, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return errors.New("mock error")
+	}
+	if m.RecoveryCodes == nil {
+		m.RecoveryCodes = make(map[string][]string)
+	}
+	m.RecoveryCodes[username] = hashes
+	return nil
+}
+
+func (m *MockStore) UseRecoveryCode(username, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailAll {
+		return false, errors.New("mock error")
+	}
+	for i, h := range m.RecoveryCodes[username] {
+		if h == hash {
+			m.RecoveryCodes[username] = append(m.RecoveryCodes[username][:i], m.RecoveryCodes[username][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Sessions
+func (m *MockStore) CreateSession(sess store.Session) error                { return nil }
+func (m *MockStore) GetSession(id string) (*store.Session, error)          { return nil, nil }
+func (m *MockStore) TouchSession(id, ip string, at time.Time) error        { return nil }
+func (m *MockStore) ListSessions(username string) ([]store.Session, error) { return nil, nil }
+func (m *MockStore) DeleteSession(username, id string) (bool, error)       { return false, nil }
+func (m *MockStore) DeleteUserSessions(username string) (int64, error)     { return 0, nil }
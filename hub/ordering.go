@@ -0,0 +1,144 @@
+package hub
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// laneSet keeps the deliveries to each device in queue order on this node.
+// Deliveries are registered in their lane before they are attempted, and a
+// delivery is only attempted once no earlier one of its lane is registered
+// or waiting for a retry. Those it holds back stay queued; the queue
+// processor attempts them in order on its next run.
+type laneSet struct {
+	mu    sync.Mutex
+	lanes map[string]*lane // By token
+}
+
+// lane tracks the unsettled deliveries to a device.
+type lane struct {
+	queued   map[int64]int  // Queue IDs registered for an attempt, with how many runs hold them
+	retrying map[int64]bool // Queue IDs that failed for now and wait for a retry
+	held     bool           // A delivery was held back since the lane was last clear
+}
+
+// add registers deliveries about to be attempted.
+func (s *laneSet) add(ds ...delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lanes == nil {
+		s.lanes = map[string]*lane{}
+	}
+	for _, d := range ds {
+		l, ok := s.lanes[d.token]
+		if !ok {
+			l = &lane{queued: map[int64]int{}, retrying: map[int64]bool{}}
+			s.lanes[d.token] = l
+		}
+		l.queued[d.queueID]++
+	}
+}
+
+// ready reports whether the delivery of queueID to token may be attempted
+// now, that is no earlier delivery to token is registered or retrying.
+func (s *laneSet) ready(token string, queueID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lanes[token]
+	if !ok {
+		return true
+	}
+	for id := range l.queued {
+		if id < queueID {
+			l.held = true
+			return false
+		}
+	}
+	for id := range l.retrying {
+		if id < queueID {
+			l.held = true
+			return false
+		}
+	}
+	return true
+}
+
+// done releases a registration of the delivery of queueID to token, after
+// an attempt that failed with err, or without an attempt when attempted is
+// false. It reports whether deliveries held back in the lane may now go,
+// for the caller to wake the queue processor.
+func (s *laneSet) done(token string, queueID int64, attempted bool, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lanes[token]
+	if !ok {
+		return false
+	}
+	if l.queued[queueID]--; l.queued[queueID] <= 0 {
+		delete(l.queued, queueID)
+	}
+	if attempted {
+		if err != nil && !connectors.IsPermanent(err) {
+			l.retrying[queueID] = true
+		} else {
+			delete(l.retrying, queueID)
+		}
+	}
+	return s.settle(token, l)
+}
+
+// settle drops the lane of token once nothing is registered or retrying,
+// and reports whether deliveries were held back in it meanwhile.
+func (s *laneSet) settle(token string, l *lane) bool {
+	if len(l.queued) > 0 || len(l.retrying) > 0 {
+		return false
+	}
+	delete(s.lanes, token)
+	return l.held
+}
+
+// prune forgets the retrying deliveries that are no longer pending, such
+// as those canceled or delivered by another node.
+func (s *laneSet) prune(pending []store.QueueItem) {
+	ids := make(map[int64]bool, len(pending))
+	for _, item := range pending {
+		ids[item.ID] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, l := range s.lanes {
+		for id := range l.retrying {
+			if !ids[id] {
+				delete(l.retrying, id)
+			}
+		}
+		s.settle(token, l)
+	}
+}
+
+// chains splits deliveries into those to devices with one delivery among
+// them and, in queue order, those of each device with several.
+func chains(ds []delivery) (single []delivery, chained [][]delivery) {
+	byToken := map[string][]delivery{}
+	var tokens []string
+	for _, d := range ds {
+		if _, ok := byToken[d.token]; !ok {
+			tokens = append(tokens, d.token)
+		}
+		byToken[d.token] = append(byToken[d.token], d)
+	}
+	for _, token := range tokens {
+		c := byToken[token]
+		if len(c) == 1 {
+			single = append(single, c[0])
+			continue
+		}
+		slices.SortFunc(c, func(a, b delivery) int { return cmp.Compare(a.queueID, b.queueID) })
+		chained = append(chained, c)
+	}
+	return single, chained
+}
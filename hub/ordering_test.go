@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestLaneSet(t *testing.T) {
+	var s laneSet
+	s.add(delivery{queueID: 1, token: "a"}, delivery{queueID: 2, token: "a"}, delivery{queueID: 3, token: "b"})
+	if !s.ready("a", 1) || s.ready("a", 2) || !s.ready("b", 3) {
+		t.Fatal("Expected only the first delivery of each device to be ready")
+	}
+
+	// A failure for now keeps the later deliveries back until its retry
+	if s.done("a", 1, true, errors.New("unavailable")) {
+		t.Error("Expected no wake while the first delivery waits for a retry")
+	}
+	if s.ready("a", 2) {
+		t.Error("Expected the second delivery held behind the retry")
+	}
+	s.done("a", 2, false, nil)
+	s.add(delivery{queueID: 1, token: "a"}, delivery{queueID: 2, token: "a"})
+	if !s.ready("a", 1) {
+		t.Fatal("Expected the retry to be ready")
+	}
+	s.done("a", 1, true, nil)
+	if !s.ready("a", 2) {
+		t.Error("Expected the second delivery ready once the first went through")
+	}
+	if !s.done("a", 2, true, nil) {
+		t.Error("Expected a wake for the delivery held back earlier")
+	}
+
+	// Retries of items that left the queue elsewhere don't hold the lane
+	s.add(delivery{queueID: 4, token: "c"})
+	s.done("c", 4, true, errors.New("unavailable"))
+	s.prune(nil)
+	if !s.ready("c", 5) {
+		t.Error("Expected a pruned retry not to hold later deliveries")
+	}
+	s.done("b", 3, true, nil)
+	if len(s.lanes) != 0 {
+		t.Errorf("Expected settled lanes to be dropped, got %d", len(s.lanes))
+	}
+}
+
+// sequences returns the sequence numbers of the messages sent to c, in order.
+func sequences(c *MockConnector) []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var seqs []int64
+	for _, m := range c.SentMessages {
+		var n store.Notification
+		json.Unmarshal(m.Payload, &n)
+		seqs = append(seqs, n.Seq)
+	}
+	return seqs
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := NewMockConnector()
+	conn.ShouldFail = true
+	h.RegisterConnector("mock", conn)
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "alice")
+
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if _, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":1}`)}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	waitFor(func() bool {
+		mockStore.mu.Lock()
+		defer mockStore.mu.Unlock()
+		return len(mockStore.Attempts) == 1
+	})
+
+	// The second message waits for the first one's retry
+	if _, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":2}`)}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	waitFor(func() bool {
+		h.lanes.mu.Lock()
+		defer h.lanes.mu.Unlock()
+		l := h.lanes.lanes["device-1"]
+		return l != nil && l.held && len(l.queued) == 0
+	})
+	mockStore.mu.Lock()
+	attempts := len(mockStore.Attempts)
+	mockStore.mu.Unlock()
+	if attempts != 1 {
+		t.Fatalf("Expected the second message not to be attempted, got %d attempts", attempts)
+	}
+
+	conn.mu.Lock()
+	conn.ShouldFail = false
+	conn.mu.Unlock()
+	h.processQueue()
+	if seqs := sequences(conn); !slices.Equal(seqs, []int64{1, 2}) {
+		t.Errorf("Expected sequence numbers 1 and 2 in order, got %v", seqs)
+	}
+}
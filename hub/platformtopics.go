@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// topicConnector returns the connector of provider if its platform fans out
+// topic messages itself.
+func (h *Hub) topicConnector(provider string) (connectors.TopicConnector, bool) {
+	c, ok := h.GetConnector(provider)
+	if !ok {
+		return nil, false
+	}
+	return connectorAs[connectors.TopicConnector](c)
+}
+
+// sendPlatformTopics sends a wrapped topic message once to each platform
+// fanning out topic messages itself, and returns the subscribers left to
+// deliver to token by token and how many were reached through a platform.
+// A platform's subscribers are delivered to token by token instead when only
+// some of them are in the audience, when they would get different payloads,
+// for synchronous sends and when the platform send fails.
+func (h *Hub) sendPlatformTopics(ctx context.Context, topic string, wrapped []byte, variants map[string][]byte, subscribers []store.Subscriber) ([]store.Subscriber, int) {
+	if syncSendFrom(ctx) != nil || len(variants) > 0 || isUnread(wrapped) {
+		return subscribers, 0
+	}
+	audience := map[string]int{}
+	for _, sub := range subscribers {
+		if _, ok := h.topicConnector(sub.Provider); ok {
+			audience[sub.Provider]++
+		}
+	}
+	if len(audience) == 0 {
+		return subscribers, 0
+	}
+	all, err := h.store.GetSubscribers(topic)
+	if err != nil {
+		log.Printf("Failed to count platform topic subscribers of %s: %v", topic, err)
+		return subscribers, 0
+	}
+	total := map[string]int{}
+	for _, sub := range all {
+		total[sub.Provider]++
+	}
+
+	sent := map[string]bool{}
+	for provider, n := range audience {
+		if n != total[provider] {
+			continue // Segmented, or declined by preferences
+		}
+		c, _ := h.topicConnector(provider)
+		if err := c.SendToTopic(ctx, topic, wrapped); err != nil {
+			log.Printf("[%s] Topic message to %s failed, sending to %d tokens instead: %v", provider, topic, n, err)
+			continue
+		}
+		sent[provider] = true
+	}
+	if len(sent) == 0 {
+		return subscribers, 0
+	}
+	remaining := make([]store.Subscriber, 0, len(subscribers))
+	reached := 0
+	for _, sub := range subscribers {
+		if sent[sub.Provider] {
+			reached++
+			continue
+		}
+		remaining = append(remaining, sub)
+	}
+	return remaining, reached
+}
+
+// subscribePlatformTopic registers a subscription with its platform's topic,
+// for providers fanning out topic messages themselves.
+func (h *Hub) subscribePlatformTopic(ctx context.Context, topic string, sub store.Subscriber) error {
+	if c, ok := h.topicConnector(sub.Provider); ok {
+		return c.SubscribeToTopic(ctx, topic, []string{sub.Token})
+	}
+	return nil
+}
+
+// unsubscribePlatformTopic removes a removed subscription from its
+// platform's topic. Failures are only logged, as the subscription is gone.
+func (h *Hub) unsubscribePlatformTopic(topic string, sub store.Subscriber) {
+	c, ok := h.topicConnector(sub.Provider)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.UnsubscribeFromTopic(ctx, topic, []string{sub.Token}); err != nil {
+		log.Printf("[%s] Failed to remove %s from topic %s: %v", sub.Provider, sub.Token, topic, err)
+	}
+}
@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"no-spam/store"
+)
+
+// mockTopicConnector records the platform topic calls of a TopicConnector.
+type mockTopicConnector struct {
+	MockConnector
+	mu           sync.Mutex
+	members      map[string][]string // Tokens by topic
+	topicSends   []string
+	failTopicOps bool
+}
+
+func (m *mockTopicConnector) SubscribeToTopic(ctx context.Context, topic string, tokens []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failTopicOps {
+		return errors.New("mock topic error")
+	}
+	m.members[topic] = append(m.members[topic], tokens...)
+	return nil
+}
+
+func (m *mockTopicConnector) UnsubscribeFromTopic(ctx context.Context, topic string, tokens []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[topic] = slices.DeleteFunc(m.members[topic], func(t string) bool { return slices.Contains(tokens, t) })
+	return nil
+}
+
+func (m *mockTopicConnector) SendToTopic(ctx context.Context, topic string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failTopicOps {
+		return errors.New("mock topic error")
+	}
+	m.topicSends = append(m.topicSends, topic)
+	return nil
+}
+
+func TestPlatformTopics(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	platform := &mockTopicConnector{members: map[string][]string{}}
+	h.RegisterConnector("fcm-topic", platform)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+
+	_ = h.Subscribe("news", store.Subscriber{Token: "phone-1", Provider: "fcm-topic", Platform: "android"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "phone-2", Provider: "fcm-topic", Platform: "ios"})
+	_ = h.Subscribe("news", store.Subscriber{Token: "hook", Provider: "mock"})
+	if got := platform.members["news"]; len(got) != 2 {
+		t.Fatalf("Expected both phones in the platform topic, got %v", got)
+	}
+
+	ctx := context.Background()
+	res, err := h.Publish(ctx, Message{Topic: "news", Payload: []byte(`{"n": 1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(platform.topicSends) != 1 || res.Enqueued != 3 {
+		t.Errorf("Expected one topic message and 3 recipients, got %v and %d", platform.topicSends, res.Enqueued)
+	}
+	if len(mockStore.Queue) != 1 || mockStore.Queue[0].Token != "hook" {
+		t.Errorf("Expected only the webhook queued, got %+v", mockStore.Queue)
+	}
+
+	// Part of the platform's subscribers are targeted: token by token
+	if _, err := h.Publish(ctx, Message{Topic: "news", Payload: []byte(`{"n": 2}`), Segment: "platform=ios"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(platform.topicSends) != 1 || len(mockStore.Queue) != 2 || mockStore.Queue[1].Token != "phone-2" {
+		t.Errorf("Expected phone-2 queued without a topic message, got %v %+v", platform.topicSends, mockStore.Queue)
+	}
+
+	// A failed topic message falls back to the tokens
+	platform.failTopicOps = true
+	if _, err := h.Publish(ctx, Message{Topic: "news", Payload: []byte(`{"n": 3}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mockStore.Queue) != 5 {
+		t.Errorf("Expected all 3 subscribers queued, got %+v", mockStore.Queue)
+	}
+	if err := h.Subscribe("news", store.Subscriber{Token: "phone-3", Provider: "fcm-topic"}); err == nil {
+		t.Error("Expected the subscription to fail when the platform rejects it")
+	}
+	platform.failTopicOps = false
+
+	if err := h.Unsubscribe("news", "phone-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := platform.members["news"]; len(got) != 1 || got[0] != "phone-2" {
+		t.Errorf("Expected phone-1 removed from the platform topic, got %v", got)
+	}
+}
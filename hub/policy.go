@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"no-spam/store"
+)
+
+// MaxRetryBackoff caps the delay before the first retry of a topic's deliveries.
+const MaxRetryBackoff = 24 * time.Hour
+
+// maxBackoffDoublings caps how many times the retry delay doubles, so it
+// can't overflow.
+const maxBackoffDoublings = 16
+
+// deliveryPolicy is how the queue processor retries a topic's deliveries.
+// The zero policy retries on every run until delivered.
+type deliveryPolicy struct {
+	maxAttempts int           // Attempts before a delivery is failed; 0 is unlimited
+	backoff     time.Duration // Delay before the first retry, doubling for each next one
+	ttl         time.Duration // Deliveries pending longer are failed; 0 keeps them
+}
+
+func policyOf(info store.TopicInfo) deliveryPolicy {
+	return deliveryPolicy{
+		maxAttempts: info.MaxAttempts,
+		backoff:     time.Duration(info.RetryBackoffSeconds) * time.Second,
+		ttl:         time.Duration(info.TTLSeconds) * time.Second,
+	}
+}
+
+// topicPolicy returns the delivery policy of a topic, the zero policy if it
+// can't be read.
+func (h *Hub) topicPolicy(topic string) deliveryPolicy {
+	info, err := h.store.GetTopicInfo(topic)
+	if err != nil || info == nil {
+		return deliveryPolicy{}
+	}
+	return policyOf(*info)
+}
+
+// deliveryPolicies returns the delivery policies of the topics that have one.
+func (h *Hub) deliveryPolicies() map[string]deliveryPolicy {
+	topics, err := h.store.ListTopicInfo()
+	if err != nil {
+		log.Printf("[Queue] Failed to read delivery policies: %v", err)
+		return nil
+	}
+	policies := map[string]deliveryPolicy{}
+	for _, t := range topics {
+		if p := policyOf(t); p != (deliveryPolicy{}) {
+			policies[t.Name] = p
+		}
+	}
+	return policies
+}
+
+// expired returns why a pending item can no longer be delivered under p, or
+// nil if it may still be attempted.
+func (p deliveryPolicy) expired(item store.QueueItem, now time.Time) error {
+	if p.ttl > 0 && now.Sub(item.CreatedAt) > p.ttl {
+		return fmt.Errorf("expired: still pending %s after it was queued", p.ttl)
+	}
+	if p.maxAttempts > 0 && item.Attempts >= p.maxAttempts {
+		if item.LastError == "" {
+			return fmt.Errorf("gave up after %d attempts", item.Attempts)
+		}
+		return fmt.Errorf("gave up after %d attempts: %s", item.Attempts, item.LastError)
+	}
+	return nil
+}
+
+// due reports whether the next attempt of a pending item is due at now.
+// Retry n is due backoff·(2ⁿ−1) after the item was queued, so the delays
+// between attempts double without recording when each was made.
+func (p deliveryPolicy) due(item store.QueueItem, now time.Time) bool {
+	if p.backoff <= 0 || item.Attempts == 0 {
+		return true
+	}
+	n := min(item.Attempts, maxBackoffDoublings)
+	wait := p.backoff * time.Duration(1<<n-1)
+	return !now.Before(item.CreatedAt.Add(wait))
+}
+
+// applyPolicies fails the pending items their topic's policy gives up on.
+// It returns the items still pending, and those of them whose attempt is
+// due at now. An item waiting for its retry holds back the later items to
+// its device, which stay in order.
+func (h *Hub) applyPolicies(pending []store.QueueItem, now time.Time) (due, live []store.QueueItem) {
+	policies := h.deliveryPolicies()
+	if len(policies) == 0 {
+		return pending, pending
+	}
+
+	waiting := map[string]int64{} // Lowest queue ID waiting for a retry, by token
+	live = make([]store.QueueItem, 0, len(pending))
+	for _, item := range pending {
+		p := policies[item.Topic]
+		if err := p.expired(item, now); err != nil {
+			log.Printf("[Queue] Failing message %d to %s: %v", item.ID, item.Token, err)
+			h.failDelivery(item.ID, item.Provider, item.Token, item.Payload, err)
+			continue
+		}
+		live = append(live, item)
+		if !p.due(item, now) {
+			if id, ok := waiting[item.Token]; !ok || item.ID < id {
+				waiting[item.Token] = item.ID
+			}
+		}
+	}
+
+	for _, item := range live {
+		if id, ok := waiting[item.Token]; !ok || item.ID < id {
+			due = append(due, item)
+		}
+	}
+	return due, live
+}
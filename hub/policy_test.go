@@ -0,0 +1,108 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestDeliveryPolicyDue(t *testing.T) {
+	now := time.Now()
+	p := deliveryPolicy{backoff: time.Minute}
+	for _, tc := range []struct {
+		attempts int
+		age      time.Duration
+		due      bool
+	}{
+		{0, 0, true},                 // First attempt
+		{1, 30 * time.Second, false}, // First retry after 1m
+		{1, time.Minute, true},
+		{2, 2 * time.Minute, false}, // Second retry 2m after the first, at 3m
+		{2, 3 * time.Minute, true},
+		{100, 1000 * time.Hour, false}, // Doublings are capped, not overflowed
+	} {
+		item := store.QueueItem{Attempts: tc.attempts, CreatedAt: now.Add(-tc.age)}
+		if got := p.due(item, now); got != tc.due {
+			t.Errorf("due after %d attempts at %s = %v, want %v", tc.attempts, tc.age, got, tc.due)
+		}
+	}
+	if !(deliveryPolicy{}).due(store.QueueItem{Attempts: 5, CreatedAt: now}, now) {
+		t.Error("Expected retries due on every run without a backoff")
+	}
+}
+
+func TestProcessQueue_TopicPolicy(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+	h.CreateTopic("alerts")
+	h.CreateTopic("chat")
+	h.CreateTopic("news")
+	if err := h.SetTopicInfo(store.TopicInfo{Name: "alerts", MaxAttempts: 3, TTLSeconds: 3600}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetTopicInfo(store.TopicInfo{Name: "chat", RetryBackoffSeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	mockStore.Queue = append(mockStore.Queue,
+		store.QueueItem{ID: 1, Topic: "alerts", Token: "a", Provider: "mock", Status: "pending", Attempts: 3, CreatedAt: now},
+		store.QueueItem{ID: 2, Topic: "alerts", Token: "b", Provider: "mock", Status: "pending", CreatedAt: now.Add(-2 * time.Hour)},
+		store.QueueItem{ID: 3, Topic: "chat", Token: "c", Provider: "mock", Status: "pending", Attempts: 1, CreatedAt: now},
+		store.QueueItem{ID: 4, Topic: "news", Token: "c", Provider: "mock", Status: "pending", CreatedAt: now},
+		store.QueueItem{ID: 5, Topic: "news", Token: "d", Provider: "mock", Status: "pending", Attempts: 10, CreatedAt: now.Add(-2 * time.Hour)},
+	)
+	h.processQueue()
+
+	mockStore.mu.Lock()
+	status := map[int64]string{}
+	for _, item := range mockStore.Queue {
+		status[item.ID] = item.Status
+	}
+	mockStore.mu.Unlock()
+	if status[1] != "failed" || status[2] != "failed" {
+		t.Errorf("Expected the alerts past their attempts and TTL failed, got %v", status)
+	}
+	if status[3] != "pending" || status[4] != "pending" {
+		t.Errorf("Expected the chat retry and the next item to its device waiting, got %v", status)
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.SentMessages) != 1 || mc.SentMessages[0].Token != "d" {
+		t.Errorf("Expected only the item of a topic without a policy sent, got %+v", mc.SentMessages)
+	}
+}
+
+func TestSubscribe_ReplayTTL(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mc := NewMockConnector()
+	h.RegisterConnector("mock", mc)
+	h.CreateTopic("alerts")
+	h.SetTopicInfo(store.TopicInfo{Name: "alerts", TTLSeconds: 600})
+
+	old, _ := h.store.SaveMessage("alerts", []byte("old"))
+	recent, _ := h.store.SaveMessage("alerts", []byte("new"))
+	mockStore.mu.Lock()
+	for id, age := range map[int64]time.Duration{old: time.Hour, recent: time.Minute} {
+		m := mockStore.Messages[id]
+		m.CreatedAt = time.Now().Add(-age)
+		mockStore.Messages[id] = m
+	}
+	mockStore.mu.Unlock()
+
+	if err := h.Subscribe("alerts", store.Subscriber{Token: "tok", Provider: "mock"}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if len(mc.SentMessages) != 1 {
+		t.Errorf("Expected only the message within the TTL replayed, got %d", len(mc.SentMessages))
+	}
+}
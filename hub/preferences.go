@@ -0,0 +1,123 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"no-spam/notification"
+	"no-spam/store"
+)
+
+// ErrInvalidPreferences is returned by SetPreferences for values that can't
+// be enforced.
+var ErrInvalidPreferences = errors.New("invalid preferences")
+
+// preferencesWindow is the period MaxPerDay counts notifications over.
+const preferencesWindow = 24 * time.Hour
+
+// MaxMutedCategories is the maximum number of categories a subscription
+// may decline.
+const MaxMutedCategories = 50
+
+// UserSubscription returns username's subscription of token to topic, or
+// ErrSubscriptionNotFound.
+func (h *Hub) UserSubscription(username, topic, token string) (*store.Subscriber, error) {
+	subs, err := h.store.GetSubscriptionsByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	for i, sub := range subs {
+		if sub.Topic == topic && sub.Username == username {
+			return &subs[i], nil
+		}
+	}
+	return nil, ErrSubscriptionNotFound
+}
+
+// SetPreferences replaces a subscription's preferences. Preferences without
+// any limit are cleared.
+func (h *Hub) SetPreferences(topic, token string, prefs store.Preferences) error {
+	switch prefs.MinPriority {
+	case "", notification.PriorityNormal, notification.PriorityHigh:
+	default:
+		return fmt.Errorf("%w: min_priority must be %q or %q", ErrInvalidPreferences, notification.PriorityNormal, notification.PriorityHigh)
+	}
+	if prefs.MaxPerDay < 0 {
+		return fmt.Errorf("%w: max_per_day must not be negative", ErrInvalidPreferences)
+	}
+	if len(prefs.MutedCategories) > MaxMutedCategories {
+		return fmt.Errorf("%w: at most %d muted categories are allowed", ErrInvalidPreferences, MaxMutedCategories)
+	}
+	for _, c := range prefs.MutedCategories {
+		if !notification.ValidCategory(c) {
+			return fmt.Errorf("%w: invalid category %q", ErrInvalidPreferences, c)
+		}
+	}
+	if prefs.MinPriority == notification.PriorityNormal {
+		prefs.MinPriority = "" // Every notification is at least normal
+	}
+	prefs.MutedCategories = slices.Compact(slices.Sorted(slices.Values(prefs.MutedCategories)))
+	if prefs.MutedUntil == nil && prefs.MinPriority == "" && prefs.MaxPerDay == 0 && len(prefs.MutedCategories) == 0 {
+		return h.store.SetSubscriptionPreferences(topic, token, nil)
+	}
+	return h.store.SetSubscriptionPreferences(topic, token, &prefs)
+}
+
+// notificationTraits returns the priority and category of a published
+// payload. Payloads that aren't canonical notifications are normal priority,
+// without a category.
+func notificationTraits(payload []byte) (priority, category string) {
+	priority = notification.PriorityNormal
+	if p, err := notification.Parse(payload); err == nil && p != nil {
+		if p.Notification.Priority != "" {
+			priority = p.Notification.Priority
+		}
+		category = p.Notification.Category
+	}
+	return priority, category
+}
+
+// applyPreferences leaves out the subscribers whose preferences decline a
+// notification of the payload's priority and category at now: muted ones,
+// those only taking high priority notifications, those declining the
+// category, and those at their daily cap for the topic.
+func (h *Hub) applyPreferences(topic string, payload []byte, subs []store.Subscriber, now time.Time) ([]store.Subscriber, error) {
+	priority, category := notificationTraits(payload)
+	kept := make([]store.Subscriber, 0, len(subs))
+	var capped []string
+	for _, sub := range subs {
+		if p := sub.Preferences; p != nil {
+			if p.MutedUntil != nil && now.Before(*p.MutedUntil) {
+				continue
+			}
+			if p.MinPriority == notification.PriorityHigh && priority != notification.PriorityHigh {
+				continue
+			}
+			if category != "" && slices.Contains(p.MutedCategories, category) {
+				continue
+			}
+			if p.MaxPerDay > 0 {
+				capped = append(capped, sub.Token)
+			}
+		}
+		kept = append(kept, sub)
+	}
+	if len(capped) == 0 {
+		return kept, nil
+	}
+
+	counts, err := h.store.CountQueuedSince(topic, capped, now.Add(-preferencesWindow))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recent notifications: %v", err)
+	}
+	allowed := kept[:0]
+	for _, sub := range kept {
+		if p := sub.Preferences; p != nil && p.MaxPerDay > 0 && counts[sub.Token] >= p.MaxPerDay {
+			continue
+		}
+		allowed = append(allowed, sub)
+	}
+	return allowed, nil
+}
@@ -0,0 +1,93 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestPreferences(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+	for _, token := range []string{"muted", "unmuted", "urgent-only", "capped", "plain"} {
+		_ = h.Subscribe("news", store.Subscriber{Token: token, Provider: "mock"})
+	}
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	h.SetPreferences("news", "muted", store.Preferences{MutedUntil: &future})
+	h.SetPreferences("news", "unmuted", store.Preferences{MutedUntil: &past})
+	h.SetPreferences("news", "urgent-only", store.Preferences{MinPriority: "high"})
+	h.SetPreferences("news", "capped", store.Preferences{MaxPerDay: 1})
+
+	if err := h.SetPreferences("news", "plain", store.Preferences{MinPriority: "urgent"}); !errors.Is(err, ErrInvalidPreferences) {
+		t.Errorf("Expected ErrInvalidPreferences, got %v", err)
+	}
+	if err := h.SetPreferences("news", "plain", store.Preferences{MinPriority: "normal"}); err != nil {
+		t.Fatal(err)
+	}
+	if sub, _ := mockStore.GetSubscriptionsByToken("plain"); sub[0].Preferences != nil {
+		t.Errorf("Expected preferences without a limit cleared, got %+v", sub[0].Preferences)
+	}
+
+	send := func(payload string) []string {
+		results, err := h.SendSync(context.Background(), Message{Topic: "news", Payload: json.RawMessage(payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for _, r := range results {
+			got[r.Token] = true
+		}
+		var tokens []string
+		for _, token := range []string{"muted", "unmuted", "urgent-only", "capped", "plain"} {
+			if got[token] {
+				tokens = append(tokens, token)
+			}
+		}
+		return tokens
+	}
+
+	if got := send(`{"notification": {"title": "Hi"}}`); len(got) != 3 || got[0] != "unmuted" || got[1] != "capped" || got[2] != "plain" {
+		t.Errorf("Expected only unmuted, capped and plain, got %v", got)
+	}
+	if got := send(`{"notification": {"title": "Alert", "priority": "high"}}`); len(got) != 3 || got[1] != "urgent-only" {
+		t.Errorf("Expected urgent-only to get a high priority notification but capped at its cap, got %v", got)
+	}
+}
+
+func TestPreferencesMutedCategories(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+	_ = h.Subscribe("news", store.Subscriber{Token: "picky", Provider: "mock"})
+
+	if err := h.SetPreferences("news", "picky", store.Preferences{MutedCategories: []string{"Marketing!"}}); !errors.Is(err, ErrInvalidPreferences) {
+		t.Errorf("Expected ErrInvalidPreferences, got %v", err)
+	}
+	if err := h.SetPreferences("news", "picky", store.Preferences{MutedCategories: []string{"marketing", "digest", "marketing"}}); err != nil {
+		t.Fatal(err)
+	}
+	if sub, _ := mockStore.GetSubscriptionsByToken("picky"); len(sub[0].Preferences.MutedCategories) != 2 || sub[0].Preferences.MutedCategories[0] != "digest" {
+		t.Errorf("Expected muted categories sorted and deduplicated, got %v", sub[0].Preferences.MutedCategories)
+	}
+
+	for payload, want := range map[string]int{
+		`{"notification": {"title": "Sale", "category": "marketing"}}`:     0,
+		`{"notification": {"title": "New login", "category": "security"}}`: 1,
+		`{"notification": {"title": "Hi"}}`:                                1,
+	} {
+		results, err := h.SendSync(context.Background(), Message{Topic: "news", Payload: json.RawMessage(payload)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != want {
+			t.Errorf("%s: expected %d deliveries, got %d", payload, want, len(results))
+		}
+	}
+}
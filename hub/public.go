@@ -0,0 +1,93 @@
+package hub
+
+import (
+	"errors"
+
+	"no-spam/store"
+)
+
+// WebPushProvider is the connector public topic pages subscribe browsers with.
+const WebPushProvider = "webpush"
+
+// ErrWebPushDisabled is returned by SubscribePublic when no webpush
+// connector is configured.
+var ErrWebPushDisabled = errors.New("web push is not configured")
+
+// ErrPublicSubscriberLimit is returned by SubscribePublic when a topic has
+// as many anonymous subscriptions as SetMaxPublicSubscribers allows.
+var ErrPublicSubscriberLimit = errors.New("topic has reached its limit of anonymous subscribers")
+
+// DefaultMaxPublicSubscribers is the default cap on a topic's anonymous subscriptions.
+const DefaultMaxPublicSubscribers = 10000
+
+// SetMaxPublicSubscribers caps the anonymous subscriptions of each public
+// topic. 0 means no cap.
+func (h *Hub) SetMaxPublicSubscribers(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publicSubs = n
+}
+
+// PublicTopic returns the metadata of a public topic, resolving aliases.
+// Topics that aren't public are reported as not found.
+func (h *Hub) PublicTopic(name string) (*store.TopicInfo, error) {
+	name, err := h.resolveTopic(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := h.GetTopicInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Public {
+		return nil, ErrTopicNotFound
+	}
+	return info, nil
+}
+
+// WebPushKey returns the VAPID public key browsers subscribe with, or ""
+// if web push isn't configured.
+func (h *Hub) WebPushKey() string {
+	c, ok := h.GetConnector(WebPushProvider)
+	if !ok {
+		return ""
+	}
+	k, ok := connectorAs[interface{ PublicKey() string }](c)
+	if !ok {
+		return ""
+	}
+	return k.PublicKey()
+}
+
+// SubscribePublic subscribes a browser to a public topic without an
+// account. token is the browser's JSON push subscription.
+func (h *Hub) SubscribePublic(topic, token string) (string, error) {
+	info, err := h.PublicTopic(topic)
+	if err != nil {
+		return "", err
+	}
+	if h.WebPushKey() == "" {
+		return "", ErrWebPushDisabled
+	}
+
+	h.mu.RLock()
+	limit := h.publicSubs
+	h.mu.RUnlock()
+	// Counting and subscribing at once keeps concurrent requests within the cap
+	h.publicMu.Lock()
+	defer h.publicMu.Unlock()
+	if limit > 0 {
+		count, err := h.store.CountSubscriptions(info.Name, "")
+		if err != nil {
+			return "", err
+		}
+		if count >= limit {
+			return info.Name, ErrPublicSubscriberLimit
+		}
+	}
+	return info.Name, h.Subscribe(info.Name, store.Subscriber{
+		Token:    token,
+		Provider: WebPushProvider,
+		Platform: "web",
+	})
+}
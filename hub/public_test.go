@@ -0,0 +1,44 @@
+package hub
+
+import (
+	"testing"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+type keyedConnector struct{ MockConnector }
+
+func (*keyedConnector) PublicKey() string { return "vapid-key" }
+
+func TestPublicTopic(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("private")
+	h.CreateTopic("news")
+	if err := h.SetTopicInfo(store.TopicInfo{Name: "news", Public: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.PublicTopic("private"); err != ErrTopicNotFound {
+		t.Errorf("Expected ErrTopicNotFound for a topic that isn't public, got %v", err)
+	}
+	if info, err := h.PublicTopic("news"); err != nil || info.Name != "news" {
+		t.Errorf("PublicTopic = %+v, %v", info, err)
+	}
+	if _, err := h.SubscribePublic("news", "{}"); err != ErrWebPushDisabled {
+		t.Errorf("Expected ErrWebPushDisabled, got %v", err)
+	}
+
+	// The key is found through the breaker wrapping the connector
+	h.RegisterConnector(WebPushProvider, connectors.NewCircuitBreaker(WebPushProvider, &keyedConnector{}, connectors.BreakerConfig{}))
+	if key := h.WebPushKey(); key != "vapid-key" {
+		t.Errorf("Expected the connector's key, got %q", key)
+	}
+	if topic, err := h.SubscribePublic("news", "browser-1"); err != nil || topic != "news" {
+		t.Fatalf("SubscribePublic = %q, %v", topic, err)
+	}
+	if subs, _ := mockStore.GetSubscribers("news"); len(subs) != 1 || subs[0].Provider != WebPushProvider {
+		t.Errorf("Expected one web push subscriber, got %+v", subs)
+	}
+}
@@ -0,0 +1,81 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"no-spam/store"
+)
+
+// ErrDeliveryNotFound is returned by RecordOpen for a message that was never
+// queued for the token.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// OpenRate is how many deliveries of a message were opened.
+type OpenRate struct {
+	store.MessageOpens
+	OpenRate float64 `json:"open_rate"` // Opened over delivered
+}
+
+// RecordOpen records that a device opened a message delivered to its
+// token. Only the first open of each delivery counts.
+func (h *Hub) RecordOpen(messageID int64, token string) error {
+	found, err := h.store.MarkOpened(messageID, token)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// OpenRates returns the open rates of the latest limit messages with
+// deliveries, newest first.
+func (h *Hub) OpenRates(limit int) ([]OpenRate, error) {
+	opens, err := h.store.ListMessageOpens(limit)
+	if err != nil {
+		return nil, err
+	}
+	rates := make([]OpenRate, len(opens))
+	for i, o := range opens {
+		rates[i] = OpenRate{MessageOpens: o, OpenRate: openRate(o.Opened, o.Delivered)}
+	}
+	return rates, nil
+}
+
+// openRate is opened over delivered, capped at 1 since a device may report
+// an open before its delivery is marked.
+func openRate(opened, delivered int) float64 {
+	if delivered == 0 {
+		return 0
+	}
+	return min(float64(opened)/float64(delivered), 1)
+}
+
+// withMessageID adds the message ID to a delivered notification envelope,
+// so client apps can report opens. Other payloads are returned as-is.
+func withMessageID(payload []byte, msgID int64) []byte {
+	var n store.Notification
+	if err := json.Unmarshal(payload, &n); err != nil || len(n.Payload) == 0 || n.MessageID == msgID {
+		return payload
+	}
+	n.MessageID = msgID
+	stamped, err := json.Marshal(n)
+	if err != nil {
+		return payload
+	}
+	return stamped
+}
+
+// newDeliveryID returns the delivery ID of a new topic message. It is
+// stored in the envelope, so retries, lease expiries and resends of the
+// message carry the same ID and client apps can drop the copies they
+// already received.
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestRecordOpen(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := NewMockConnector()
+	h.RegisterConnector("mock", conn)
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "alice")
+	mockStore.AddSubscription("news", "device-2", "mock", "bob")
+
+	res, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":1}`)})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if r, _ := h.MessageStatus(res.MessageID); r.Delivered == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for deliveries")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Client apps get the message ID to report opens with
+	conn.mu.Lock()
+	var delivered store.Notification
+	json.Unmarshal(conn.SentMessages[0].Payload, &delivered)
+	conn.mu.Unlock()
+	if delivered.MessageID != res.MessageID {
+		t.Errorf("Expected message ID %d in the envelope, got %d", res.MessageID, delivered.MessageID)
+	}
+
+	if err := h.RecordOpen(res.MessageID, "device-1"); err != nil {
+		t.Fatalf("RecordOpen failed: %v", err)
+	}
+	if err := h.RecordOpen(res.MessageID, "device-1"); err != nil {
+		t.Errorf("Expected a repeated receipt to be accepted, got %v", err)
+	}
+	if err := h.RecordOpen(res.MessageID, "device-3"); err != ErrDeliveryNotFound {
+		t.Errorf("Expected ErrDeliveryNotFound, got %v", err)
+	}
+
+	r, _ := h.MessageStatus(res.MessageID)
+	if r.Opened != 1 || r.OpenRate != 0.5 {
+		t.Errorf("Expected 1 of 2 deliveries opened, got %d (%v)", r.Opened, r.OpenRate)
+	}
+	rates, err := h.OpenRates(10)
+	if err != nil || len(rates) != 1 || rates[0].MessageID != res.MessageID || rates[0].OpenRate != 0.5 {
+		t.Errorf("Unexpected open rates %+v (%v)", rates, err)
+	}
+}
+
+func TestDeliveryID(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := NewMockConnector()
+	h.RegisterConnector("mock", conn)
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "alice")
+	mockStore.AddSubscription("news", "device-2", "mock", "bob")
+
+	deliveryIDs := func(n int) []string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			conn.mu.Lock()
+			sent := len(conn.SentMessages)
+			conn.mu.Unlock()
+			if sent >= n {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %d deliveries, got %d", n, sent)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		var ids []string
+		for _, m := range conn.SentMessages {
+			var n store.Notification
+			json.Unmarshal(m.Payload, &n)
+			ids = append(ids, n.DeliveryID)
+		}
+		return ids
+	}
+
+	res, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":1}`)})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	ids := deliveryIDs(2)
+	if ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("Expected every delivery of a message to share its delivery ID, got %q", ids)
+	}
+
+	// Redeliveries carry the same ID, so devices can drop them
+	if _, err := h.Resend(context.Background(), "news", res.MessageID, false); err != nil {
+		t.Fatalf("Resend failed: %v", err)
+	}
+	ids = deliveryIDs(4)
+	if ids[2] != ids[0] || ids[3] != ids[0] {
+		t.Errorf("Expected resent deliveries to keep delivery ID %q, got %q", ids[0], ids[2:])
+	}
+
+	if _, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":2}`)}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	ids = deliveryIDs(6)
+	if ids[4] == ids[0] {
+		t.Errorf("Expected a new message to get a new delivery ID, got %q again", ids[4])
+	}
+}
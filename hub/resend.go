@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"no-spam/store"
+)
+
+// ErrMessageNotApproved is returned by Resend for a message that is held
+// for approval or was rejected.
+var ErrMessageNotApproved = errors.New("message was not approved")
+
+// Resend enqueues a stored topic message again for the topic's current
+// subscribers, to recover from a connector outage. With missingOnly, it
+// skips subscribers that already received the message or still have it
+// pending. Every subscriber gets the stored payload, without the send's
+// localized variants or segment. It returns how many were enqueued.
+func (h *Hub) Resend(ctx context.Context, topic string, msgID int64, missingOnly bool) (int, error) {
+	msg, err := h.store.GetMessage(msgID)
+	if err != nil {
+		return 0, err
+	}
+	if msg == nil || msg.Topic != topic {
+		return 0, ErrMessageNotFound
+	}
+	approvals, err := h.store.ListApprovals("")
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range approvals {
+		if a.MessageID == msgID && a.Status != store.ApprovalApproved {
+			return 0, ErrMessageNotApproved
+		}
+	}
+
+	subscribers, err := h.audience(topic, nil)
+	if err != nil {
+		return 0, err
+	}
+	var envelope store.Notification
+	json.Unmarshal(msg.Payload, &envelope)
+	subscribers, err = h.applyPreferences(topic, envelope.Payload, subscribers, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if missingOnly {
+		items, err := h.store.GetQueueItemsByMessage(msgID)
+		if err != nil {
+			return 0, err
+		}
+		got := map[string]bool{}
+		for _, item := range items {
+			if item.Status == "delivered" || item.Status == "pending" {
+				got[item.Token] = true
+			}
+		}
+		var missing []store.Subscriber
+		for _, sub := range subscribers {
+			if !got[sub.Token] {
+				missing = append(missing, sub)
+			}
+		}
+		subscribers = missing
+	}
+
+	log.Printf("[Resend] Resending message %d to %d subscribers of %s", msgID, len(subscribers), topic)
+	return h.fanOut(ctx, topic, msgID, msg.Payload, nil, subscribers), nil
+}
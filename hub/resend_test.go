@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestResend(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.RegisterConnector("gone", permanentConnector{})
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "ok-token", "mock", "alice")
+	mockStore.AddSubscription("news", "bad-token", "gone", "bob")
+
+	res, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{"n":1}`)})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, err := h.MessageStatus(res.MessageID)
+		if err != nil {
+			t.Fatalf("MessageStatus failed: %v", err)
+		}
+		if r.Status == CallbackComplete {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for deliveries, got %+v", r)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r, _ := h.MessageStatus(res.MessageID)
+	for _, d := range r.Deliveries {
+		if d.Attempts != 1 || (d.Token == "bad-token") != (d.LastError != "") {
+			t.Errorf("Expected one recorded attempt per delivery, got %+v", d)
+		}
+	}
+
+	// Only the failed subscriber never got it
+	if n, err := h.Resend(context.Background(), "news", res.MessageID, true); err != nil || n != 1 {
+		t.Errorf("Expected 1 subscriber re-enqueued, got %d (%v)", n, err)
+	}
+	if n, err := h.Resend(context.Background(), "news", res.MessageID, false); err != nil || n != 2 {
+		t.Errorf("Expected 2 subscribers re-enqueued, got %d (%v)", n, err)
+	}
+
+	if _, err := h.Resend(context.Background(), "other", res.MessageID, false); err != ErrMessageNotFound {
+		t.Errorf("Expected ErrMessageNotFound for the wrong topic, got %v", err)
+	}
+	held, _ := mockStore.SaveMessage("news", []byte(`{}`))
+	mockStore.HoldMessage(store.Approval{MessageID: held, Topic: "news", Request: []byte(`{}`)})
+	if _, err := h.Resend(context.Background(), "news", held, false); err != ErrMessageNotApproved {
+		t.Errorf("Expected ErrMessageNotApproved for a held message, got %v", err)
+	}
+}
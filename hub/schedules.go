@@ -0,0 +1,343 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"no-spam/cron"
+	"no-spam/notification"
+	"no-spam/rbac"
+	"no-spam/segment"
+	"no-spam/store"
+)
+
+var (
+	// ErrScheduleNotFound is returned for operations on an unknown schedule.
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrInvalidSchedule is returned when a schedule's expression, time zone
+	// or message is invalid.
+	ErrInvalidSchedule = errors.New("invalid schedule")
+)
+
+// MaxSchedulesPerTopic caps the schedules of a topic.
+const MaxSchedulesPerTopic = 20
+
+// ScheduleLimitError is returned when a topic already has
+// MaxSchedulesPerTopic schedules.
+type ScheduleLimitError struct {
+	Topic string
+	Limit int
+}
+
+func (e *ScheduleLimitError) Error() string {
+	return fmt.Sprintf("topic %s already has %d schedules", e.Topic, e.Limit)
+}
+
+// scheduleInterval is how often due schedules are looked for. Runs start up
+// to this late.
+const scheduleInterval = 15 * time.Second
+
+// scheduleRunTimeout bounds the publish of a schedule run.
+const scheduleRunTimeout = 30 * time.Second
+
+// ScheduleSource is the source of the messages schedules publish.
+const ScheduleSource = "schedule"
+
+// CreateSchedule validates and stores a schedule that publishes msg to its
+// topic as creator whenever expr matches in timezone ("" is UTC). The
+// message is checked like a send, but templates render again on each run.
+func (h *Hub) CreateSchedule(ctx context.Context, creator, expr, timezone string, msg Message) (*store.Schedule, error) {
+	if msg.Topic == "" || msg.Token != "" {
+		return nil, fmt.Errorf("%w: schedules publish to a topic", ErrInvalidSchedule)
+	}
+	topic, err := h.resolveTopic(msg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	msg.Topic = topic
+
+	sched, err := cron.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidSchedule, timezone)
+	}
+	next := sched.Next(time.Now().In(loc))
+	if next.IsZero() {
+		return nil, fmt.Errorf("%w: %q never matches", ErrInvalidSchedule, expr)
+	}
+
+	if err := h.checkStoredMessage(ctx, msg); err != nil {
+		return nil, err
+	}
+	existing, err := h.store.ListSchedules(topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxSchedulesPerTopic {
+		return nil, &ScheduleLimitError{Topic: topic, Limit: MaxSchedulesPerTopic}
+	}
+
+	data, err := storedRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	sc := store.Schedule{
+		Topic:     topic,
+		Cron:      expr,
+		Timezone:  timezone,
+		Request:   data,
+		CreatedBy: creator,
+		NextRun:   next,
+	}
+	id, err := h.store.CreateSchedule(sc)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrTopicNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Schedule] %s scheduled %q to %s, next at %s", creator, expr, topic, next)
+	return h.GetSchedule(id)
+}
+
+// checkStoredMessage checks what can be checked of a message stored to be
+// published later, by a schedule or as a draft.
+func (h *Hub) checkStoredMessage(ctx context.Context, msg Message) error {
+	if err := h.checkCallback(ctx, msg); err != nil {
+		return err
+	}
+	if msg.Segment != "" {
+		if _, err := segment.Parse(msg.Segment); err != nil {
+			return err
+		}
+	}
+	if msg.Template != "" {
+		if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+			return fmt.Errorf("%w: payload and template are mutually exclusive", ErrInvalidTemplate)
+		}
+		if len(msg.Localized) > 0 {
+			return fmt.Errorf("%w: localized payloads can't be combined with a template", ErrInvalidTemplate)
+		}
+		_, err := h.renderTemplate(msg.Topic, msg.Template, msg.Locale, msg.Variables)
+		return err
+	}
+	if err := h.validatePayload(msg.Topic, msg.Payload); err != nil {
+		return err
+	}
+	_, err := notification.Parse(msg.Payload)
+	return err
+}
+
+// storedRequest encodes a message stored to be published later. Its topic
+// is stored alongside, and follows renames.
+func storedRequest(msg Message) ([]byte, error) {
+	msg.Topic, msg.Provider = "", ""
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stored message: %v", err)
+	}
+	return data, nil
+}
+
+func (h *Hub) GetSchedule(id int64) (*store.Schedule, error) {
+	sc, err := h.store.GetSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		return nil, ErrScheduleNotFound
+	}
+	return sc, nil
+}
+
+// ListSchedules lists the schedules of a topic, or of every topic if topic is "".
+func (h *Hub) ListSchedules(topic string) ([]store.Schedule, error) {
+	if topic != "" {
+		resolved, err := h.resolveTopic(topic)
+		if err != nil {
+			return nil, err
+		}
+		topic = resolved
+	}
+	return h.store.ListSchedules(topic)
+}
+
+// PauseSchedule stops a schedule from running until it is resumed.
+func (h *Hub) PauseSchedule(id int64) (*store.Schedule, error) {
+	sc, err := h.GetSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := h.store.SetSchedulePaused(id, true, sc.NextRun); err != nil || !ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrScheduleNotFound
+	}
+	sc.Paused = true
+	return sc, nil
+}
+
+// ResumeSchedule resumes a paused schedule from its next match after now,
+// without catching up on the runs it missed while paused.
+func (h *Hub) ResumeSchedule(id int64) (*store.Schedule, error) {
+	sc, err := h.GetSchedule(id)
+	if err != nil || !sc.Paused {
+		return sc, err
+	}
+	next, err := nextRun(sc, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := h.store.SetSchedulePaused(id, false, next); err != nil || !ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrScheduleNotFound
+	}
+	sc.Paused, sc.NextRun = false, next
+	return sc, nil
+}
+
+func (h *Hub) DeleteSchedule(id int64) error {
+	ok, err := h.store.DeleteSchedule(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// ScheduleRuns lists the latest runs of a schedule, newest first.
+func (h *Hub) ScheduleRuns(id int64, limit int) ([]store.ScheduleRun, error) {
+	if _, err := h.GetSchedule(id); err != nil {
+		return nil, err
+	}
+	return h.store.ListScheduleRuns(id, limit)
+}
+
+// nextRun returns the first time after t a schedule matches.
+func nextRun(sc *store.Schedule, t time.Time) (time.Time, error) {
+	sched, err := cron.Parse(sc.Cron)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	loc, err := time.LoadLocation(sc.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: unknown time zone %q", ErrInvalidSchedule, sc.Timezone)
+	}
+	next := sched.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: %q never matches", ErrInvalidSchedule, sc.Cron)
+	}
+	return next, nil
+}
+
+// StartSchedules starts a background goroutine that runs the due schedules.
+// With several nodes sharing a store, each run happens on one of them.
+func (h *Hub) StartSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.runDueSchedules(ctx, time.Now())
+			}
+		}
+	}()
+}
+
+// runDueSchedules runs the schedules due at now. A schedule that missed
+// several runs, e.g. while the server was down, runs once and then resumes
+// from its next match after now.
+func (h *Hub) runDueSchedules(ctx context.Context, now time.Time) {
+	due, err := h.store.DueSchedules(now)
+	if err != nil {
+		log.Printf("[Schedule] Failed to list due schedules: %v", err)
+		return
+	}
+	for i := range due {
+		sc := &due[i]
+		next, err := nextRun(sc, now)
+		if err != nil {
+			// Stop retrying a schedule that can't run again
+			log.Printf("[Schedule] Pausing schedule %d: %v", sc.ID, err)
+			h.store.SetSchedulePaused(sc.ID, true, sc.NextRun)
+			continue
+		}
+		ok, err := h.store.AdvanceSchedule(sc.ID, sc.NextRun, next)
+		if err != nil {
+			log.Printf("[Schedule] Failed to advance schedule %d: %v", sc.ID, err)
+			continue
+		}
+		if !ok {
+			continue // Paused, deleted or run elsewhere meanwhile
+		}
+		h.runSchedule(ctx, sc, now)
+	}
+}
+
+// runSchedule publishes the message of a schedule and records the run.
+func (h *Hub) runSchedule(ctx context.Context, sc *store.Schedule, now time.Time) {
+	run := store.ScheduleRun{ScheduleID: sc.ID, ScheduledAt: sc.NextRun, RanAt: now}
+	res, err := h.publishScheduled(ctx, sc)
+	var held *PendingApprovalError
+	switch {
+	case errors.As(err, &held):
+		run.Status, run.MessageID = store.ScheduleRunHeld, held.MessageID
+	case err != nil:
+		run.Status, run.Error = store.ScheduleRunFailed, err.Error()
+		log.Printf("[Schedule] Run of schedule %d to %s failed: %v", sc.ID, sc.Topic, err)
+	default:
+		run.Status, run.MessageID, run.Enqueued = store.ScheduleRunSent, res.MessageID, res.Enqueued
+	}
+	if err := h.store.AddScheduleRun(run); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("[Schedule] Failed to record run of schedule %d: %v", sc.ID, err)
+	}
+}
+
+// publishScheduled publishes the message of a schedule as its creator, who
+// must still be an active user allowed to publish to the topic.
+func (h *Hub) publishScheduled(ctx context.Context, sc *store.Schedule) (*PublishResult, error) {
+	var msg Message
+	if err := json.Unmarshal(sc.Request, &msg); err != nil {
+		return nil, fmt.Errorf("invalid scheduled message: %v", err)
+	}
+	if sc.CreatedBy != "" {
+		user, err := h.store.GetUser(sc.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.Disabled {
+			return nil, fmt.Errorf("creator %s is no longer an active user", sc.CreatedBy)
+		}
+		perms, err := rbac.Permissions(h.store, user.Role)
+		if err != nil {
+			return nil, err
+		}
+		if !rbac.Allows(perms, rbac.Publish, sc.Topic) {
+			return nil, fmt.Errorf("creator %s can no longer publish to %s", sc.CreatedBy, sc.Topic)
+		}
+		msg.LargeSend = rbac.Allows(perms, rbac.LargeSend, sc.Topic)
+	}
+	msg.Topic, msg.Publisher, msg.Source = sc.Topic, sc.CreatedBy, ScheduleSource
+
+	ctx, cancel := context.WithTimeout(ctx, scheduleRunTimeout)
+	defer cancel()
+	return h.Publish(ctx, msg)
+}
@@ -0,0 +1,159 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestCreateSchedule(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("news")
+	h.SaveTemplate(store.Template{Topic: "news", Name: "digest", Body: `{"title":{{json .title}}}`})
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		expr, tz string
+		msg      Message
+		wantErr  error
+	}{
+		{"unknown topic", "@daily", "", Message{Topic: "nope", Payload: json.RawMessage(`{}`)}, ErrTopicNotFound},
+		{"direct message", "@daily", "", Message{Token: "device-1", Payload: json.RawMessage(`{}`)}, ErrInvalidSchedule},
+		{"bad expression", "0 25 * * *", "", Message{Topic: "news", Payload: json.RawMessage(`{}`)}, ErrInvalidSchedule},
+		{"never matches", "0 0 30 2 *", "", Message{Topic: "news", Payload: json.RawMessage(`{}`)}, ErrInvalidSchedule},
+		{"bad time zone", "@daily", "Mars/Olympus", Message{Topic: "news", Payload: json.RawMessage(`{}`)}, ErrInvalidSchedule},
+		{"unknown template", "@daily", "", Message{Topic: "news", Template: "nope"}, ErrTemplateNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := h.CreateSchedule(ctx, "alice", tt.expr, tt.tz, tt.msg); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+
+	sc, err := h.CreateSchedule(ctx, "alice", "0 9 * * *", "Europe/Paris", Message{
+		Topic: "news", Template: "digest", Variables: map[string]interface{}{"title": "Morning digest"},
+	})
+	if err != nil {
+		t.Fatalf("CreateSchedule failed: %v", err)
+	}
+	if sc.Topic != "news" || sc.Timezone != "Europe/Paris" || sc.CreatedBy != "alice" || sc.Paused {
+		t.Errorf("Unexpected schedule %+v", sc)
+	}
+	loc, _ := time.LoadLocation("Europe/Paris")
+	if next := sc.NextRun.In(loc); next.Hour() != 9 || next.Minute() != 0 || !next.After(time.Now()) {
+		t.Errorf("Expected the next run at 09:00 Paris time, got %v", next)
+	}
+	var msg Message
+	if err := json.Unmarshal(sc.Request, &msg); err != nil || msg.Template != "digest" || msg.Topic != "" {
+		t.Errorf("Unexpected stored request %s", sc.Request)
+	}
+
+	for i := 1; i < MaxSchedulesPerTopic; i++ {
+		h.CreateSchedule(ctx, "alice", "@hourly", "", Message{Topic: "news", Payload: json.RawMessage(`{"n":1}`)})
+	}
+	var limit *ScheduleLimitError
+	if _, err := h.CreateSchedule(ctx, "alice", "@hourly", "", Message{Topic: "news", Payload: json.RawMessage(`{}`)}); !errors.As(err, &limit) {
+		t.Errorf("Expected ScheduleLimitError, got %v", err)
+	}
+}
+
+func TestRunDueSchedules(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	conn := NewMockConnector()
+	h.RegisterConnector("mock", conn)
+	h.CreateTopic("news")
+	mockStore.CreateUser("alice", "hash", "publisher")
+	mockStore.AddSubscription("news", "device-1", "mock", "bob")
+	ctx := context.Background()
+
+	sc, err := h.CreateSchedule(ctx, "alice", "*/5 * * * *", "", Message{Topic: "news", Payload: json.RawMessage(`{"title":"Scores"}`)})
+	if err != nil {
+		t.Fatalf("CreateSchedule failed: %v", err)
+	}
+	due := sc.NextRun
+
+	// Not due yet
+	h.runDueSchedules(ctx, due.Add(-time.Second))
+	if runs, _ := h.ScheduleRuns(sc.ID, 10); len(runs) != 0 {
+		t.Fatalf("Expected no run before the schedule is due, got %+v", runs)
+	}
+
+	// Several missed runs catch up with one
+	now := due.Add(12 * time.Minute)
+	h.runDueSchedules(ctx, now)
+	h.runDueSchedules(ctx, now)
+	runs, _ := h.ScheduleRuns(sc.ID, 10)
+	if len(runs) != 1 {
+		t.Fatalf("Expected one run, got %+v", runs)
+	}
+	if r := runs[0]; r.Status != store.ScheduleRunSent || r.MessageID == 0 || r.Enqueued != 1 || !r.ScheduledAt.Equal(due) {
+		t.Errorf("Unexpected run %+v", r)
+	}
+	mockStore.mu.Lock()
+	origin := mockStore.Messages[runs[0].MessageID].Origin
+	mockStore.mu.Unlock()
+	if origin.Publisher != "alice" {
+		t.Errorf("Expected the message published as the creator, got %+v", origin)
+	}
+	sc, _ = h.GetSchedule(sc.ID)
+	if want := due.Add(15 * time.Minute); !sc.NextRun.Equal(want) {
+		t.Errorf("Expected the next run at %v, got %v", want, sc.NextRun)
+	}
+
+	// Paused schedules don't run, and resume after now
+	if _, err := h.PauseSchedule(sc.ID); err != nil {
+		t.Fatal(err)
+	}
+	h.runDueSchedules(ctx, now.Add(time.Hour))
+	if runs, _ := h.ScheduleRuns(sc.ID, 10); len(runs) != 1 {
+		t.Errorf("Expected no run while paused, got %d runs", len(runs))
+	}
+	if sc, _ = h.ResumeSchedule(sc.ID); sc.Paused || !sc.NextRun.After(time.Now()) {
+		t.Errorf("Expected the schedule resumed from now, got %+v", sc)
+	}
+
+	// Runs fail once the creator can't publish anymore
+	mockStore.mu.Lock()
+	mockStore.Users["alice"] = store.User{Username: "alice", Role: "subscriber"}
+	mockStore.mu.Unlock()
+	h.runDueSchedules(ctx, sc.NextRun)
+	runs, _ = h.ScheduleRuns(sc.ID, 10)
+	if len(runs) != 2 || runs[0].Status != store.ScheduleRunFailed || !strings.Contains(runs[0].Error, "can no longer publish") {
+		t.Errorf("Expected a failed run, got %+v", runs)
+	}
+
+	if err := h.DeleteSchedule(sc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.ScheduleRuns(sc.ID, 10); err != ErrScheduleNotFound {
+		t.Errorf("Expected ErrScheduleNotFound, got %v", err)
+	}
+}
+
+func TestRunDueSchedules_Held(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+	h.SetApprovalThreshold("news", 1)
+	mockStore.CreateUser("alice", "hash", "publisher")
+	mockStore.AddSubscription("news", "device-1", "mock", "bob")
+
+	sc, err := h.CreateSchedule(context.Background(), "alice", "@daily", "", Message{Topic: "news", Payload: json.RawMessage(`{"title":"Digest"}`)})
+	if err != nil {
+		t.Fatalf("CreateSchedule failed: %v", err)
+	}
+	h.runDueSchedules(context.Background(), sc.NextRun)
+	runs, _ := h.ScheduleRuns(sc.ID, 10)
+	if len(runs) != 1 || runs[0].Status != store.ScheduleRunHeld || runs[0].MessageID == 0 {
+		t.Errorf("Expected a run awaiting approval, got %+v", runs)
+	}
+}
@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ErrInvalidSchema is returned when a topic schema can't be compiled.
+var ErrInvalidSchema = errors.New("invalid JSON schema")
+
+// SchemaError is returned by Route when a payload doesn't match its topic's schema.
+type SchemaError struct {
+	Topic  string
+	Errors []string // One entry per failing keyword, e.g. "/title: missing properties: 'body'"
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("payload does not match schema for topic %s: %s", e.Topic, strings.Join(e.Errors, "; "))
+}
+
+// compileSchema compiles a schema, reusing previously compiled schemas with
+// identical source. Keying by source keeps nodes sharing a store consistent
+// without any cache invalidation.
+func (h *Hub) compileSchema(src string) (*jsonschema.Schema, error) {
+	h.mu.RLock()
+	sch, ok := h.schemas[src]
+	h.mu.RUnlock()
+	if ok {
+		return sch, nil
+	}
+
+	sch, err := jsonschema.CompileString("topic.json", src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+
+	h.mu.Lock()
+	h.schemas[src] = sch
+	h.mu.Unlock()
+	return sch, nil
+}
+
+// SetTopicSchema attaches a JSON Schema to a topic. An empty schema removes it.
+func (h *Hub) SetTopicSchema(topic, schema string) error {
+	exists, err := h.store.TopicExists(topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTopicNotFound
+	}
+	if schema != "" {
+		if _, err := h.compileSchema(schema); err != nil {
+			return err
+		}
+	}
+	return h.store.SetTopicSchema(topic, schema)
+}
+
+// GetTopicSchema returns the topic's schema, or "" if it has none.
+func (h *Hub) GetTopicSchema(topic string) (string, error) {
+	exists, err := h.store.TopicExists(topic)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrTopicNotFound
+	}
+	return h.store.GetTopicSchema(topic)
+}
+
+// validatePayload checks payload against the topic's schema, if any.
+func (h *Hub) validatePayload(topic string, payload []byte) error {
+	src, err := h.store.GetTopicSchema(topic)
+	if err != nil {
+		return fmt.Errorf("failed to load topic schema: %v", err)
+	}
+	if src == "" {
+		return nil
+	}
+
+	sch, err := h.compileSchema(src)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return &SchemaError{Topic: topic, Errors: []string{"payload is not valid JSON"}}
+	}
+
+	if err := sch.Validate(v); err != nil {
+		var ve *jsonschema.ValidationError
+		if !errors.As(err, &ve) {
+			return err
+		}
+		serr := &SchemaError{Topic: topic}
+		for _, e := range ve.BasicOutput().Errors {
+			// Skip the wrapper entries that only say "doesn't validate with ..."
+			if strings.HasPrefix(e.Error, "doesn't validate with") {
+				continue
+			}
+			loc := e.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+			serr.Errors = append(serr.Errors, loc+": "+e.Error)
+		}
+		if len(serr.Errors) == 0 {
+			serr.Errors = []string{ve.Error()}
+		}
+		return serr
+	}
+	return nil
+}
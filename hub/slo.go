@@ -0,0 +1,207 @@
+package hub
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"no-spam/events"
+)
+
+// sloSource marks SLO alert messages so they bypass detection.
+const sloSource = "slo"
+
+// sloInterval is how often delivery objectives are checked.
+var sloInterval = time.Minute
+
+// SLOConfig sets the delivery objectives of each topic. A topic breaches
+// them when its p95 latency or its failure rate, over the deliveries queued
+// in the last Window, exceeds its threshold.
+type SLOConfig struct {
+	Window      time.Duration
+	LatencyP95  time.Duration // 0 disables the latency objective
+	FailureRate float64       // Failed over finished deliveries; 0 disables
+	MinSamples  int           // Topics with fewer finished deliveries aren't checked
+	AlertTopic  string        // Topic receiving breach alerts (optional)
+}
+
+// TopicLatency sums up the finished deliveries of a topic: how long
+// delivered ones took from queueing, and how many failed.
+type TopicLatency struct {
+	Topic       string  `json:"topic"`
+	Delivered   int     `json:"delivered"`
+	Failed      int     `json:"failed"`
+	FailureRate float64 `json:"failure_rate"`
+	P50Ms       int64   `json:"p50_ms"`
+	P95Ms       int64   `json:"p95_ms"`
+	P99Ms       int64   `json:"p99_ms"`
+}
+
+// DeliveryLatency sums up the deliveries queued since a time per topic,
+// ordered by topic.
+func (h *Hub) DeliveryLatency(since time.Time) ([]TopicLatency, error) {
+	items, err := h.store.ListQueueOutcomes(since)
+	if err != nil {
+		return nil, err
+	}
+	latencies := map[string][]int64{}
+	failed := map[string]int{}
+	for _, it := range items {
+		if it.Status == "failed" || it.DeliveredAt == nil {
+			failed[it.Topic]++
+			continue
+		}
+		latencies[it.Topic] = append(latencies[it.Topic], max(it.DeliveredAt.Sub(it.CreatedAt).Milliseconds(), 0))
+	}
+
+	stats := []TopicLatency{}
+	// Topics whose deliveries all failed
+	for topic := range failed {
+		if _, ok := latencies[topic]; !ok {
+			latencies[topic] = nil
+		}
+	}
+	for topic, ms := range latencies {
+		slices.Sort(ms)
+		t := TopicLatency{
+			Topic:     topic,
+			Delivered: len(ms),
+			Failed:    failed[topic],
+			P50Ms:     percentile(ms, 50),
+			P95Ms:     percentile(ms, 95),
+			P99Ms:     percentile(ms, 99),
+		}
+		t.FailureRate = float64(t.Failed) / float64(t.Delivered+t.Failed)
+		stats = append(stats, t)
+	}
+	slices.SortFunc(stats, func(a, b TopicLatency) int { return cmp.Compare(a.Topic, b.Topic) })
+	return stats, nil
+}
+
+// percentile returns the nearest-rank pth percentile of sorted values, 0 if
+// there are none.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// sloMonitor alerts once when a topic starts breaching its objectives, and
+// logs when it recovers.
+type sloMonitor struct {
+	h   *Hub
+	cfg SLOConfig
+
+	mu       sync.Mutex
+	breached map[string]bool
+}
+
+// StartSLO starts a background goroutine that checks delivery objectives
+// every minute.
+func (h *Hub) StartSLO(ctx context.Context, cfg SLOConfig) {
+	m := &sloMonitor{h: h, cfg: cfg, breached: map[string]bool{}}
+	ticker := time.NewTicker(sloInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.check(now)
+			}
+		}
+	}()
+}
+
+// check compares each topic's deliveries in the window before now with the
+// objectives.
+func (m *sloMonitor) check(now time.Time) {
+	stats, err := m.h.DeliveryLatency(now.Add(-m.cfg.Window))
+	if err != nil {
+		log.Printf("[SLO] Failed to measure delivery latency: %v", err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := map[string]bool{}
+	for _, t := range stats {
+		if t.Topic == m.cfg.AlertTopic || t.Delivered+t.Failed < max(m.cfg.MinSamples, 1) {
+			continue
+		}
+		reasons := m.breaches(t)
+		if len(reasons) == 0 {
+			continue
+		}
+		current[t.Topic] = true
+		if !m.breached[t.Topic] {
+			m.alert(t, reasons)
+		}
+	}
+	for topic := range m.breached {
+		if !current[topic] {
+			log.Printf("[SLO] %s is back within its delivery objectives", topic)
+		}
+	}
+	m.breached = current
+}
+
+// breaches describes the objectives a topic's deliveries exceed.
+func (m *sloMonitor) breaches(t TopicLatency) []string {
+	var reasons []string
+	if limit := m.cfg.LatencyP95.Milliseconds(); limit > 0 && t.P95Ms > limit {
+		reasons = append(reasons, fmt.Sprintf("p95 latency %s exceeds %s", time.Duration(t.P95Ms)*time.Millisecond, m.cfg.LatencyP95))
+	}
+	if m.cfg.FailureRate > 0 && t.FailureRate > m.cfg.FailureRate {
+		reasons = append(reasons, fmt.Sprintf("failure rate %.1f%% exceeds %.1f%%", t.FailureRate*100, m.cfg.FailureRate*100))
+	}
+	return reasons
+}
+
+func (m *sloMonitor) alert(t TopicLatency, reasons []string) {
+	log.Printf("[SLO] %s breached its delivery objectives: %v", t.Topic, reasons)
+	events.Emit(events.SLOBreached, "", map[string]any{
+		"topic":        t.Topic,
+		"delivered":    t.Delivered,
+		"failed":       t.Failed,
+		"failure_rate": t.FailureRate,
+		"p50_ms":       t.P50Ms,
+		"p95_ms":       t.P95Ms,
+		"p99_ms":       t.P99Ms,
+		"reasons":      reasons,
+	})
+	if m.cfg.AlertTopic == "" {
+		return
+	}
+	go m.h.publishSLOAlert(m.cfg.AlertTopic, t, reasons)
+}
+
+func (h *Hub) publishSLOAlert(topic string, t TopicLatency, reasons []string) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"notification": map[string]string{
+			"title":    "Delivery objective breached",
+			"body":     fmt.Sprintf("%s: %s.", t.Topic, strings.Join(reasons, " and ")),
+			"priority": "high",
+		},
+		"data": map[string]string{
+			"topic":        t.Topic,
+			"p95_ms":       strconv.FormatInt(t.P95Ms, 10),
+			"failure_rate": strconv.FormatFloat(t.FailureRate, 'f', 3, 64),
+		},
+	})
+	if err != nil {
+		return
+	}
+	if err := h.Route(context.Background(), Message{Topic: topic, Payload: payload, Source: sloSource}); err != nil {
+		log.Printf("[SLO] Failed to publish alert to %s: %v", topic, err)
+	}
+}
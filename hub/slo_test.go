@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+// queueOutcome adds a finished queue item of topic to s, delivered after
+// latency or, if latency is negative, failed.
+func queueOutcome(s *MockStore, topic string, queuedAt time.Time, latency time.Duration) {
+	s.QueueSeq++
+	item := store.QueueItem{ID: s.QueueSeq, Topic: topic, Status: "failed", CreatedAt: queuedAt}
+	if latency >= 0 {
+		delivered := queuedAt.Add(latency)
+		item.Status, item.DeliveredAt = "delivered", &delivered
+	}
+	s.Queue = append(s.Queue, item)
+}
+
+func TestDeliveryLatency(t *testing.T) {
+	s := NewMockStore()
+	h := NewHub(s)
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		queueOutcome(s, "news", now, time.Duration(i)*time.Millisecond)
+	}
+	queueOutcome(s, "news", now, -1)
+	queueOutcome(s, "ops", now, -1)
+	queueOutcome(s, "news", now.Add(-2*time.Hour), time.Hour)
+
+	stats, err := h.DeliveryLatency(now.Add(-time.Hour))
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("Expected 2 topics, got %+v (%v)", stats, err)
+	}
+	want := TopicLatency{Topic: "news", Delivered: 100, Failed: 1, FailureRate: 1.0 / 101, P50Ms: 50, P95Ms: 95, P99Ms: 99}
+	if stats[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, stats[0])
+	}
+	if want := (TopicLatency{Topic: "ops", Failed: 1, FailureRate: 1}); stats[1] != want {
+		t.Errorf("Expected %+v, got %+v", want, stats[1])
+	}
+}
+
+func TestSLOMonitor(t *testing.T) {
+	s := NewMockStore()
+	h := NewHub(s)
+	_ = h.CreateTopic("admin-alerts")
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		queueOutcome(s, "news", now, 2*time.Second)
+		queueOutcome(s, "ops", now, 10*time.Millisecond)
+	}
+	queueOutcome(s, "rare", now, time.Minute)
+
+	m := &sloMonitor{h: h, cfg: SLOConfig{
+		Window:      time.Hour,
+		LatencyP95:  time.Second,
+		FailureRate: 0.2,
+		MinSamples:  5,
+		AlertTopic:  "admin-alerts",
+	}, breached: map[string]bool{}}
+	m.check(now)
+	m.check(now)
+	if !m.breached["news"] || m.breached["ops"] || m.breached["rare"] {
+		t.Errorf("Expected only news to breach, got %v", m.breached)
+	}
+
+	// The alert is published asynchronously, once
+	deadline := time.Now().Add(time.Second)
+	for {
+		msgs, _ := s.GetRecentMessages("admin-alerts", 10)
+		if len(msgs) == 1 {
+			if !strings.Contains(string(msgs[0].Payload), "p95 latency 2s exceeds 1s") {
+				t.Errorf("Unexpected alert payload: %s", msgs[0].Payload)
+			}
+			break
+		}
+		if len(msgs) > 1 || time.Now().After(deadline) {
+			t.Fatalf("Expected one alert on admin-alerts, got %d", len(msgs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		queueOutcome(s, "ops", now, -1)
+	}
+	m.check(now)
+	if !m.breached["ops"] {
+		t.Errorf("Expected ops to breach its failure rate, got %v", m.breached)
+	}
+	m.check(now.Add(2 * time.Hour))
+	if len(m.breached) != 0 {
+		t.Errorf("Expected every topic to recover, got %v", m.breached)
+	}
+}
@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"no-spam/store"
+)
+
+// seenInterval limits how often a token's last successful delivery is
+// written; staleness is measured in days.
+const seenInterval = time.Hour
+
+// maxSeenTokens bounds the tokens whose last write is remembered. Past it
+// the memory is cleared, costing one extra write per token.
+const maxSeenTokens = 100_000
+
+// seenTracker remembers when each token's last delivery was written.
+type seenTracker struct {
+	mu      sync.Mutex
+	written map[string]time.Time
+}
+
+// due returns the tokens whose last delivery should be written at now, and
+// records them as written.
+func (t *seenTracker) due(tokens []string, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.written == nil || len(t.written) > maxSeenTokens {
+		t.written = map[string]time.Time{}
+	}
+	var out []string
+	for _, token := range tokens {
+		if last, ok := t.written[token]; ok && now.Sub(last) < seenInterval {
+			continue
+		}
+		t.written[token] = now
+		out = append(out, token)
+	}
+	return out
+}
+
+// markSeen records successful deliveries to tokens, writing each token at
+// most once per seenInterval.
+func (h *Hub) markSeen(tokens ...string) {
+	now := time.Now()
+	tokens = h.seen.due(tokens, now)
+	if len(tokens) == 0 {
+		return
+	}
+	if err := h.store.MarkTokensSeen(tokens, now); err != nil {
+		log.Printf("[Queue] Failed to record the last delivery to %d tokens: %v", len(tokens), err)
+	}
+}
+
+// SetStaleSubscriptionDays removes, hourly, the subscriptions without a
+// successful delivery for this many days. 0 keeps them.
+func (h *Hub) SetStaleSubscriptionDays(days int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.staleDays = days
+}
+
+// StaleSubscriptions returns up to limit subscriptions neither created nor
+// delivered to since before, least recently seen first.
+func (h *Hub) StaleSubscriptions(before time.Time, limit int) ([]store.Subscriber, error) {
+	return h.store.ListStaleSubscriptions(before, limit)
+}
+
+// pruneSubscriptions removes the subscriptions stale at now, when enabled.
+func (h *Hub) pruneSubscriptions(now time.Time) {
+	h.mu.RLock()
+	days := h.staleDays
+	h.mu.RUnlock()
+	if days <= 0 {
+		return
+	}
+	n, err := h.store.RemoveStaleSubscriptions(now.AddDate(0, 0, -days))
+	if err != nil {
+		log.Printf("[Retention] Failed to prune stale subscriptions: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[Retention] Removed %d subscriptions without a delivery for %d days", n, days)
+	}
+}
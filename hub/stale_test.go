@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestSeenTracker(t *testing.T) {
+	var tr seenTracker
+	now := time.Now()
+	if got := tr.due([]string{"a", "b"}, now); len(got) != 2 {
+		t.Errorf("Expected both tokens due first, got %v", got)
+	}
+	if got := tr.due([]string{"a", "c"}, now.Add(time.Minute)); len(got) != 1 || got[0] != "c" {
+		t.Errorf("Expected only the new token due, got %v", got)
+	}
+	if got := tr.due([]string{"a"}, now.Add(seenInterval)); len(got) != 1 {
+		t.Errorf("Expected the token due again after the interval, got %v", got)
+	}
+}
+
+func TestDeliveryMarksSeen(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+	_ = h.Subscribe("news", store.Subscriber{Token: "device-1", Provider: "mock"})
+
+	if _, err := h.SendSync(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := mockStore.GetSubscriptionsByToken("device-1")
+	if len(subs) != 1 || subs[0].LastSeenAt == nil {
+		t.Errorf("Expected the delivery recorded, got %+v", subs)
+	}
+}
+
+func TestPruneSubscriptions(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("news")
+	now := time.Now()
+	old, recent := now.AddDate(0, 0, -40), now.AddDate(0, 0, -1)
+	mockStore.Subscriptions["news"] = []store.Subscriber{
+		{Topic: "news", Token: "dead", Provider: "mock", CreatedAt: &old},
+		{Topic: "news", Token: "alive", Provider: "mock", CreatedAt: &old, LastSeenAt: &recent},
+	}
+
+	h.pruneSubscriptions(now)
+	if len(mockStore.Subscriptions["news"]) != 2 {
+		t.Error("Expected nothing pruned without a policy")
+	}
+
+	h.SetStaleSubscriptionDays(30)
+	h.pruneSubscriptions(now)
+	if subs := mockStore.Subscriptions["news"]; len(subs) != 1 || subs[0].Token != "alive" {
+		t.Errorf("Expected only the dead token pruned, got %+v", subs)
+	}
+}
@@ -0,0 +1,171 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"no-spam/store"
+)
+
+// Metrics recorded in the hourly stats.
+const (
+	StatPublishes  = "publishes"   // Topic messages accepted
+	StatDeliveries = "deliveries"  // Successful delivery attempts
+	StatFailures   = "failures"    // Failed delivery attempts
+	StatQueueDepth = "queue_depth" // Highest pending deliveries sampled in the hour
+)
+
+// StatsRetention is how long hourly stats are kept.
+const StatsRetention = 90 * 24 * time.Hour
+
+// MaxStatsWindow bounds the window of a stats query.
+const MaxStatsWindow = 31 * 24 * time.Hour
+
+// ProviderStatsWindow is how far back ProviderStats sums up attempts.
+const ProviderStatsWindow = 24 * time.Hour
+
+// statsInterval is how often counted stats are written to the store.
+var statsInterval = time.Minute
+
+// statsCounter counts events between writes to the store.
+type statsCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *statsCounter) add(metric string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addN(metric, 1)
+}
+
+// addN adds n to a metric. The caller holds c.mu.
+func (c *statsCounter) addN(metric string, n int64) {
+	if c.counts == nil {
+		c.counts = map[string]int64{}
+	}
+	c.counts[metric] += n
+}
+
+// StatsBucket holds the metrics of an hour.
+type StatsBucket struct {
+	Time       time.Time `json:"time"`
+	Publishes  int64     `json:"publishes"`
+	Deliveries int64     `json:"deliveries"`
+	Failures   int64     `json:"failures"`
+	QueueDepth int64     `json:"queue_depth"`
+}
+
+func (b *StatsBucket) set(metric string, value int64) {
+	switch metric {
+	case StatPublishes:
+		b.Publishes = value
+	case StatDeliveries:
+		b.Deliveries = value
+	case StatFailures:
+		b.Failures = value
+	case StatQueueDepth:
+		b.QueueDepth = value
+	}
+}
+
+// StartStats starts a background goroutine that writes the counted stats,
+// with a sample of the queue depth, every minute, and drops stats older than
+// StatsRetention every hour.
+func (h *Hub) StartStats(ctx context.Context) {
+	ticker := time.NewTicker(statsInterval)
+	go func() {
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+		for {
+			select {
+			case <-ctx.Done():
+				h.flushStats(time.Now())
+				return
+			case now := <-ticker.C:
+				h.flushStats(now)
+				if now.Sub(lastPrune) >= time.Hour {
+					h.pruneStats(now)
+					lastPrune = now
+				}
+			}
+		}
+	}()
+}
+
+// flushStats writes the counted stats to the hour of now. Counts that fail
+// to be written are kept for the next flush.
+func (h *Hub) flushStats(now time.Time) {
+	h.stats.mu.Lock()
+	counts := h.stats.counts
+	h.stats.counts = nil
+	h.stats.mu.Unlock()
+
+	peaks := map[string]int64{}
+	if depth, err := h.store.CountPending(""); err != nil {
+		log.Printf("[Stats] Failed to sample the queue depth: %v", err)
+	} else {
+		peaks[StatQueueDepth] = depth
+	}
+	if err := h.store.RecordStats(now, counts, peaks); err != nil {
+		log.Printf("[Stats] Failed to record stats: %v", err)
+		h.stats.mu.Lock()
+		for metric, n := range counts {
+			h.stats.addN(metric, n)
+		}
+		h.stats.mu.Unlock()
+	}
+}
+
+// pruneStats deletes the stats older than StatsRetention at now.
+func (h *Hub) pruneStats(now time.Time) {
+	if _, err := h.store.DeleteStatsBefore(now.Add(-StatsRetention)); err != nil {
+		log.Printf("[Stats] Failed to prune stats: %v", err)
+	}
+}
+
+// Stats returns the hourly metrics of the hours in [from, to), one bucket
+// per hour including those without any activity. The current hour includes
+// counts not yet written to the store.
+func (h *Hub) Stats(from, to time.Time) ([]StatsBucket, error) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC()
+	points, err := h.store.ListStats(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []StatsBucket{}
+	index := map[time.Time]int{}
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		index[t] = len(buckets)
+		buckets = append(buckets, StatsBucket{Time: t})
+	}
+	for _, p := range points {
+		if i, ok := index[p.Bucket.UTC()]; ok {
+			buckets[i].set(p.Metric, p.Value)
+		}
+	}
+
+	h.stats.mu.Lock()
+	defer h.stats.mu.Unlock()
+	if i, ok := index[time.Now().UTC().Truncate(time.Hour)]; ok {
+		b := &buckets[i]
+		b.Publishes += h.stats.counts[StatPublishes]
+		b.Deliveries += h.stats.counts[StatDeliveries]
+		b.Failures += h.stats.counts[StatFailures]
+	}
+	return buckets, nil
+}
+
+// ProviderStats sums up the delivery attempts of the last
+// ProviderStatsWindow per provider, so a flaky channel stands out.
+func (h *Hub) ProviderStats() ([]store.ProviderStats, error) {
+	stats, err := h.store.ProviderStats(time.Now().Add(-ProviderStatsWindow))
+	if stats == nil {
+		stats = []store.ProviderStats{}
+	}
+	return stats, err
+}
@@ -0,0 +1,55 @@
+package hub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := NewMockStore()
+	h := NewHub(s)
+	s.Topics["news"] = true
+	id, _ := s.SaveMessage("news", []byte(`{}`))
+	s.EnqueueMessage(id, "tok-1")
+	qid, _ := s.EnqueueMessage(id, "tok-2")
+
+	h.runPublishHooks(Message{Topic: "news"}, id)
+	h.recordAttempt(qid, "fcm", nil, time.Now(), nil)
+	h.recordAttempt(qid, "fcm", nil, time.Now(), errors.New("timeout"))
+	h.recordAttempt(qid, "fcm", nil, time.Now(), errors.New("timeout"))
+
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+	buckets, err := h.Stats(hour.Add(-time.Hour), now)
+	if err != nil || len(buckets) != 2 {
+		t.Fatalf("Expected 2 buckets, got %+v (%v)", buckets, err)
+	}
+	if b := buckets[1]; b.Publishes != 1 || b.Deliveries != 1 || b.Failures != 2 || !b.Time.Equal(hour) {
+		t.Errorf("Expected unwritten counts in the current hour, got %+v", b)
+	}
+	if b := buckets[0]; b != (StatsBucket{Time: hour.Add(-time.Hour)}) {
+		t.Errorf("Expected an empty previous hour, got %+v", b)
+	}
+
+	h.flushStats(now)
+	h.flushStats(now)
+	buckets, _ = h.Stats(hour, now)
+	if b := buckets[0]; b.Publishes != 1 || b.Deliveries != 1 || b.Failures != 2 || b.QueueDepth != 2 {
+		t.Errorf("Expected counts written once with the queue depth, got %+v", b)
+	}
+
+	s.FailAll = true
+	h.stats.add(StatPublishes)
+	h.flushStats(now)
+	s.FailAll = false
+	h.flushStats(now)
+	if buckets, _ = h.Stats(hour, now); buckets[0].Publishes != 2 {
+		t.Errorf("Expected counts kept after a failed write, got %+v", buckets[0])
+	}
+
+	h.pruneStats(now.Add(StatsRetention + time.Hour))
+	if len(s.Stats) != 0 {
+		t.Errorf("Expected old stats pruned, got %+v", s.Stats)
+	}
+}
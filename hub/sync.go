@@ -0,0 +1,157 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"no-spam/connectors"
+	"no-spam/store"
+)
+
+// ErrSyncRequiresTopic is returned by SendSync for a direct message, which
+// Route already delivers before returning.
+var ErrSyncRequiresTopic = errors.New("synchronous sends require a topic")
+
+// SyncLimitError is returned by SendSync when a send would reach more
+// subscribers than the synchronous send limit. Nothing is stored.
+type SyncLimitError struct {
+	Audience int
+	Limit    int
+}
+
+func (e *SyncLimitError) Error() string {
+	return fmt.Sprintf("send reaches %d subscribers, synchronous sends are limited to %d", e.Audience, e.Limit)
+}
+
+// Delivery result statuses
+const (
+	ResultDelivered = "delivered"
+	ResultFailed    = "failed"    // Failed for good, won't be retried
+	ResultPending   = "pending"   // Failed for now, left in the queue for a retry
+	ResultForwarded = "forwarded" // Handed to another node, which has the provider
+)
+
+// DeliveryResult is the outcome of one delivery of a synchronous send.
+type DeliveryResult struct {
+	Token    string `json:"token"`
+	Provider string `json:"provider"`
+	QueueID  int64  `json:"queue_id"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// DefaultSyncLimit is the default synchronous send subscriber limit.
+const DefaultSyncLimit = 100
+
+// SetSyncLimit caps the subscribers a synchronous send may reach. 0 uses DefaultSyncLimit.
+func (h *Hub) SetSyncLimit(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.syncLimit = n
+}
+
+func (h *Hub) getSyncLimit() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.syncLimit <= 0 {
+		return DefaultSyncLimit
+	}
+	return h.syncLimit
+}
+
+type syncKey struct{}
+
+// syncSend collects the results of a synchronous send's deliveries.
+type syncSend struct {
+	mu      sync.Mutex
+	results []DeliveryResult
+}
+
+func syncSendFrom(ctx context.Context) *syncSend {
+	s, _ := ctx.Value(syncKey{}).(*syncSend)
+	return s
+}
+
+// SendSync routes a topic message like Route, but attempts every delivery
+// before returning and reports the result of each, ordered by queue ID.
+// Deliveries that fail for now stay queued and are retried as usual. A send
+// held for approval returns a PendingApprovalError with no results.
+func (h *Hub) SendSync(ctx context.Context, msg Message) ([]DeliveryResult, error) {
+	if msg.Topic == "" {
+		return nil, ErrSyncRequiresTopic
+	}
+	s := &syncSend{results: []DeliveryResult{}}
+	if err := h.Route(context.WithValue(ctx, syncKey{}, s), msg); err != nil {
+		return nil, err
+	}
+	sort.Slice(s.results, func(i, j int) bool { return s.results[i].QueueID < s.results[j].QueueID })
+	return s.results, nil
+}
+
+// checkSyncLimit rejects a synchronous send reaching too many subscribers.
+func (h *Hub) checkSyncLimit(ctx context.Context, audience int) error {
+	if syncSendFrom(ctx) == nil {
+		return nil
+	}
+	if limit := h.getSyncLimit(); audience > limit {
+		return &SyncLimitError{Audience: audience, Limit: limit}
+	}
+	return nil
+}
+
+// deliverSync attempts a freshly enqueued item and reports the outcome.
+func (h *Hub) deliverSync(ctx context.Context, sub store.Subscriber, payload []byte, queueID int64) DeliveryResult {
+	r := DeliveryResult{Token: sub.Token, Provider: sub.Provider, QueueID: queueID, Status: ResultPending}
+	h.lanes.add(delivery{queueID: queueID, token: sub.Token})
+	var attempted bool
+	var err error
+	defer func() {
+		if h.lanes.done(sub.Token, queueID, attempted, err) {
+			h.wakeUp()
+		}
+	}()
+
+	conn, ok := h.GetConnector(sub.Provider)
+	if !ok {
+		if h.forward(sub.Provider, sub.Token, payload, queueID, sub.Options) {
+			r.Status = ResultForwarded
+			return r
+		}
+		r.Error = "no connector for provider " + sub.Provider
+		return r
+	}
+	if !h.lanes.ready(sub.Token, queueID) {
+		r.Error = "waiting for an earlier delivery to the subscriber"
+		return r
+	}
+	if !h.claim(queueID) {
+		r.Error = "claimed by another node"
+		return r
+	}
+	defer h.release(queueID)
+
+	dctx, cancel := context.WithTimeout(ctx, deliveryTimeout(sub.Options))
+	start := time.Now()
+	err = conn.Send(h.deliveryContext(dctx, sub.Options), sub.Token, payload)
+	cancel()
+	attempted = true
+	h.recordAttempt(queueID, sub.Provider, payload, start, err)
+	switch {
+	case err == nil:
+		if err := h.store.MarkDelivered(queueID); err != nil {
+			r.Error = "delivered, but failed to record it: " + err.Error()
+		}
+		h.markSeen(sub.Token)
+		r.Status = ResultDelivered
+	case connectors.IsPermanent(err):
+		h.failDelivery(queueID, sub.Provider, sub.Token, payload, err)
+		r.Status, r.Error = ResultFailed, err.Error()
+	default:
+		r.Error = err.Error()
+	}
+	return r
+}
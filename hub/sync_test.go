@@ -0,0 +1,45 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSendSync(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.RegisterConnector("gone", permanentConnector{})
+	h.CreateTopic("ops")
+	mockStore.AddSubscription("ops", "ok-token", "mock", "alice")
+	mockStore.AddSubscription("ops", "bad-token", "gone", "bob")
+
+	results, err := h.SendSync(context.Background(), Message{Topic: "ops", Payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("SendSync failed: %v", err)
+	}
+	if len(results) != 2 || results[0].QueueID > results[1].QueueID {
+		t.Fatalf("Expected 2 results ordered by queue ID, got %+v", results)
+	}
+	for _, r := range results {
+		want := ResultDelivered
+		if r.Token == "bad-token" {
+			want = ResultFailed
+		}
+		if r.Status != want {
+			t.Errorf("Expected %s for %s, got %+v", want, r.Token, r)
+		}
+	}
+
+	h.SetSyncLimit(1)
+	var limitErr *SyncLimitError
+	if _, err := h.SendSync(context.Background(), Message{Topic: "ops", Payload: json.RawMessage(`{}`)}); !errors.As(err, &limitErr) || limitErr.Audience != 2 {
+		t.Errorf("Expected a SyncLimitError for 2 subscribers, got %v", err)
+	}
+	// The limit only applies to synchronous sends
+	if err := h.Route(context.Background(), Message{Topic: "ops", Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Errorf("Route should ignore the sync limit, got %v", err)
+	}
+}
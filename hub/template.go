@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+
+	"no-spam/store"
+)
+
+var (
+	// ErrTemplateNotFound is returned when a send references an unknown template.
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrInvalidTemplate is returned when a template can't be parsed or rendered.
+	ErrInvalidTemplate = errors.New("invalid template")
+)
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. {"title": {{json .name}}}, so variables are escaped.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// parseTemplate parses a template body, reusing previously parsed templates
+// with identical source.
+func (h *Hub) parseTemplate(body string) (*template.Template, error) {
+	h.mu.RLock()
+	tmpl, ok := h.templates[body]
+	h.mu.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	h.mu.Lock()
+	h.templates[body] = tmpl
+	h.mu.Unlock()
+	return tmpl, nil
+}
+
+// SaveTemplate registers or replaces a topic template variant.
+func (h *Hub) SaveTemplate(t store.Template) error {
+	exists, err := h.store.TopicExists(t.Topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTopicNotFound
+	}
+	if _, err := h.parseTemplate(t.Body); err != nil {
+		return err
+	}
+	t.Locale = normalizeLocale(t.Locale)
+	return h.store.SaveTemplate(t)
+}
+
+// ListTemplates returns every template variant of a topic.
+func (h *Hub) ListTemplates(topic string) ([]store.Template, error) {
+	exists, err := h.store.TopicExists(topic)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrTopicNotFound
+	}
+	return h.store.ListTemplates(topic)
+}
+
+// DeleteTemplate removes one locale variant, or all of them when locale is "*".
+func (h *Hub) DeleteTemplate(topic, name, locale string) error {
+	if locale != "*" {
+		locale = normalizeLocale(locale)
+	}
+	return h.store.DeleteTemplate(topic, name, locale)
+}
+
+// findTemplate returns the best variant of a template for locale.
+func (h *Hub) findTemplate(topic, name, locale string) (*store.Template, error) {
+	for _, l := range localeFallbacks(locale) {
+		t, err := h.store.GetTemplate(topic, name, l)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			return t, nil
+		}
+	}
+	return nil, ErrTemplateNotFound
+}
+
+// renderTemplate renders a topic template with vars into a JSON payload.
+func (h *Hub) renderTemplate(topic, name, locale string, vars map[string]interface{}) ([]byte, error) {
+	t, err := h.findTemplate(topic, name, locale)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := h.parseTemplate(t.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("%w: template %s did not render valid JSON", ErrInvalidTemplate, name)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,309 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"no-spam/store"
+)
+
+// ErrInvalidTopicInfo is returned by SetTopicInfo for settings it can't use.
+var ErrInvalidTopicInfo = errors.New("invalid topic settings")
+
+// ErrTopicExists is returned when a topic or an alias already has a name.
+var ErrTopicExists = errors.New("topic already exists")
+
+// ErrAliasNotFound is returned by DeleteTopicAlias for an unknown alias.
+var ErrAliasNotFound = errors.New("alias not found")
+
+// ErrInvalidTopicName is returned by CreateUserTopic for a name outside the
+// user's namespace or with characters it doesn't allow.
+var ErrInvalidTopicName = errors.New("invalid topic name")
+
+// ErrSelfServiceDisabled is returned by CreateUserTopic when publishers may
+// not create topics.
+var ErrSelfServiceDisabled = errors.New("self-service topic creation is disabled")
+
+// NamespaceSeparator separates a user's namespace from the rest of a topic name.
+const NamespaceSeparator = "/"
+
+// userTopicName matches the part of a self-service topic after the namespace.
+var userTopicName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// DefaultReplayCount is how many recent messages are replayed to a new
+// subscriber of a topic without a replay count.
+const DefaultReplayCount = 20
+
+// MaxReplayCount caps a topic's replay count.
+const MaxReplayCount = 100
+
+// retentionInterval is how often messages past their topic's retention are deleted.
+var retentionInterval = time.Hour
+
+// GetTopicInfo returns a topic's metadata and settings.
+func (h *Hub) GetTopicInfo(topic string) (*store.TopicInfo, error) {
+	info, err := h.store.GetTopicInfo(topic)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, ErrTopicNotFound
+	}
+	return info, nil
+}
+
+// ListTopicInfo returns the metadata and settings of every topic.
+func (h *Hub) ListTopicInfo() ([]store.TopicInfo, error) {
+	return h.store.ListTopicInfo()
+}
+
+// CreateTopicWithInfo creates a topic with a description, owner and settings.
+func (h *Hub) CreateTopicWithInfo(info store.TopicInfo) error {
+	if err := h.checkNewTopic(info); err != nil {
+		return err
+	}
+	if err := h.store.CreateTopic(info.Name); err != nil {
+		return err
+	}
+	if info == (store.TopicInfo{Name: info.Name}) {
+		return nil
+	}
+	return h.store.SetTopicInfo(info)
+}
+
+// checkNewTopic validates the settings of a topic to create, and checks its
+// name isn't an alias.
+func (h *Hub) checkNewTopic(info store.TopicInfo) error {
+	if err := h.validateTopicInfo(info); err != nil {
+		return err
+	}
+	if target, err := h.store.ResolveTopicAlias(info.Name); err != nil {
+		return err
+	} else if target != "" {
+		return fmt.Errorf("%w: %s is an alias of %s", ErrTopicExists, info.Name, target)
+	}
+	return nil
+}
+
+// UserNamespace returns the topic name prefix reserved for a user.
+func UserNamespace(username string) string {
+	return username + NamespaceSeparator
+}
+
+// CreateUserTopic creates a topic owned by username in their namespace,
+// e.g. alice/news. Other topic names stay reserved for admins.
+func (h *Hub) CreateUserTopic(username string, info store.TopicInfo) error {
+	h.mu.RLock()
+	limit := h.userTopics
+	h.mu.RUnlock()
+	if limit <= 0 {
+		return ErrSelfServiceDisabled
+	}
+
+	prefix := UserNamespace(username)
+	if !strings.HasPrefix(info.Name, prefix) || !userTopicName.MatchString(strings.TrimPrefix(info.Name, prefix)) {
+		return fmt.Errorf("%w: topics must be named %s<name>, using letters, digits, '.', '_' or '-'", ErrInvalidTopicName, prefix)
+	}
+
+	info.Owner = username
+	if err := h.checkNewTopic(info); err != nil {
+		return err
+	}
+	// Counted and created at once, so concurrent creates can't pass the limit
+	count, err := h.store.CreateOwnedTopic(info.Name, username, prefix, limit)
+	if errors.Is(err, store.ErrLimitReached) {
+		return &TopicLimitError{Owner: username, Count: count, Limit: limit}
+	}
+	if err != nil {
+		return err
+	}
+	if info == (store.TopicInfo{Name: info.Name, Owner: username}) {
+		return nil
+	}
+	return h.store.SetTopicInfo(info)
+}
+
+// SetTopicInfo updates a topic's description, owner and settings.
+func (h *Hub) SetTopicInfo(info store.TopicInfo) error {
+	exists, err := h.store.TopicExists(info.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTopicNotFound
+	}
+	if err := h.validateTopicInfo(info); err != nil {
+		return err
+	}
+	return h.store.SetTopicInfo(info)
+}
+
+// validateTopicInfo checks a topic's settings. The owner, if any, must be
+// an existing user.
+func (h *Hub) validateTopicInfo(info store.TopicInfo) error {
+	if info.ReplayCount != nil && (*info.ReplayCount < 0 || *info.ReplayCount > MaxReplayCount) {
+		return fmt.Errorf("%w: replay_count must be between 0 and %d", ErrInvalidTopicInfo, MaxReplayCount)
+	}
+	if info.RetentionDays < 0 {
+		return fmt.Errorf("%w: retention_days can't be negative", ErrInvalidTopicInfo)
+	}
+	if info.MaxAttempts < 0 || info.TTLSeconds < 0 {
+		return fmt.Errorf("%w: max_attempts and ttl_seconds can't be negative", ErrInvalidTopicInfo)
+	}
+	if maxBackoff := int(MaxRetryBackoff / time.Second); info.RetryBackoffSeconds < 0 || info.RetryBackoffSeconds > maxBackoff {
+		return fmt.Errorf("%w: retry_backoff_seconds must be between 0 and %d", ErrInvalidTopicInfo, maxBackoff)
+	}
+	if info.Owner != "" {
+		user, err := h.store.GetUser(info.Owner)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return fmt.Errorf("%w: unknown owner %s", ErrInvalidTopicInfo, info.Owner)
+		}
+	}
+	return nil
+}
+
+// RenameTopic renames a topic along with its subscriptions, messages,
+// templates, filter rules and approvals. With alias, publishes and
+// subscriptions to the old name keep working. Stored payloads keep the
+// topic name they were sent with.
+func (h *Hub) RenameTopic(oldName, newName string, alias bool) error {
+	if newName == "" || newName == oldName {
+		return fmt.Errorf("%w: the new name must differ from the old one", ErrInvalidTopicInfo)
+	}
+	exists, err := h.store.TopicExists(oldName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTopicNotFound
+	}
+	if exists, err = h.store.TopicExists(newName); err != nil {
+		return err
+	} else if exists {
+		return ErrTopicExists
+	}
+	if target, err := h.store.ResolveTopicAlias(newName); err != nil {
+		return err
+	} else if target != "" && target != oldName {
+		return fmt.Errorf("%w: %s is an alias of %s", ErrTopicExists, newName, target)
+	}
+	if err := h.store.RenameTopic(oldName, newName, alias); err != nil {
+		return err
+	}
+	log.Printf("[Hub] Renamed topic %s to %s", oldName, newName)
+	return nil
+}
+
+// TopicAliases lists the old names that resolve to a topic.
+func (h *Hub) TopicAliases(topic string) ([]string, error) {
+	all, err := h.store.ListTopicAliases()
+	if err != nil {
+		return nil, err
+	}
+	aliases := []string{}
+	for _, a := range all {
+		if a.Topic == topic {
+			aliases = append(aliases, a.Alias)
+		}
+	}
+	return aliases, nil
+}
+
+// DeleteTopicAlias stops an old name of topic from resolving to it.
+func (h *Hub) DeleteTopicAlias(topic, alias string) error {
+	target, err := h.store.ResolveTopicAlias(alias)
+	if err != nil {
+		return err
+	}
+	if target != topic {
+		return ErrAliasNotFound
+	}
+	_, err = h.store.DeleteTopicAlias(alias)
+	return err
+}
+
+// ResolveTopic returns the topic a name refers to, following an alias of
+// a renamed topic, or ErrTopicNotFound.
+func (h *Hub) ResolveTopic(name string) (string, error) {
+	return h.resolveTopic(name)
+}
+
+// resolveTopic returns the topic a name refers to, following an alias of
+// a renamed topic, or ErrTopicNotFound.
+func (h *Hub) resolveTopic(name string) (string, error) {
+	exists, err := h.store.TopicExists(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to check topic existence: %v", err)
+	}
+	if exists {
+		return name, nil
+	}
+	target, err := h.store.ResolveTopicAlias(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to check topic existence: %v", err)
+	}
+	if target == "" {
+		return "", ErrTopicNotFound
+	}
+	return target, nil
+}
+
+// replayCount returns how many recent messages a new subscriber of topic gets.
+func (h *Hub) replayCount(topic string) int {
+	info, err := h.store.GetTopicInfo(topic)
+	if err != nil || info == nil || info.ReplayCount == nil {
+		return DefaultReplayCount
+	}
+	return *info.ReplayCount
+}
+
+// StartRetention starts a background goroutine that deletes messages older
+// than their topic's retention, and stale subscriptions when enabled, every
+// hour.
+func (h *Hub) StartRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				now := time.Now()
+				h.pruneMessages(now)
+				h.pruneSubscriptions(now)
+			}
+		}
+	}()
+}
+
+// pruneMessages deletes the messages of each topic with a retention that
+// are older than it at now, with their queue items.
+func (h *Hub) pruneMessages(now time.Time) {
+	topics, err := h.store.ListTopicInfo()
+	if err != nil {
+		log.Printf("[Retention] Failed to list topics: %v", err)
+		return
+	}
+	for _, t := range topics {
+		if t.RetentionDays <= 0 {
+			continue
+		}
+		n, err := h.store.DeleteMessagesBefore(t.Name, now.AddDate(0, 0, -t.RetentionDays))
+		if err != nil {
+			log.Printf("[Retention] Failed to prune messages of %s: %v", t.Name, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("[Retention] Deleted %d messages of %s older than %d days", n, t.Name, t.RetentionDays)
+		}
+	}
+}
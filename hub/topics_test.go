@@ -0,0 +1,156 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"no-spam/store"
+)
+
+func TestSetTopicInfo(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("news")
+
+	if n := h.replayCount("news"); n != DefaultReplayCount {
+		t.Errorf("Expected the default replay count, got %d", n)
+	}
+	zero, tooMany := 0, MaxReplayCount+1
+	if err := h.SetTopicInfo(store.TopicInfo{Name: "news", Description: "Headlines", ReplayCount: &zero}); err != nil {
+		t.Fatalf("SetTopicInfo failed: %v", err)
+	}
+	if n := h.replayCount("news"); n != 0 {
+		t.Errorf("Expected no replay, got %d", n)
+	}
+
+	for _, info := range []store.TopicInfo{
+		{Name: "news", ReplayCount: &tooMany},
+		{Name: "news", RetentionDays: -1},
+		{Name: "news", MaxAttempts: -1},
+		{Name: "news", RetryBackoffSeconds: int(MaxRetryBackoff/time.Second) + 1},
+		{Name: "news", Owner: "nobody"},
+	} {
+		if err := h.SetTopicInfo(info); !errors.Is(err, ErrInvalidTopicInfo) {
+			t.Errorf("SetTopicInfo(%+v) = %v, want ErrInvalidTopicInfo", info, err)
+		}
+	}
+	if err := h.SetTopicInfo(store.TopicInfo{Name: "missing"}); err != ErrTopicNotFound {
+		t.Errorf("Expected ErrTopicNotFound, got %v", err)
+	}
+}
+
+func TestPruneMessages(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("news")
+	h.CreateTopic("logs")
+	h.SetTopicInfo(store.TopicInfo{Name: "news", RetentionDays: 7})
+
+	now := time.Now()
+	save := func(topic string, age time.Duration) int64 {
+		id, _ := mockStore.SaveMessage(topic, []byte(`{}`))
+		m := mockStore.Messages[id]
+		m.CreatedAt = now.Add(-age)
+		mockStore.Messages[id] = m
+		return id
+	}
+	oldNews := save("news", 8*24*time.Hour)
+	newNews := save("news", time.Hour)
+	oldLogs := save("logs", 30*24*time.Hour)
+
+	h.pruneMessages(now)
+
+	if _, ok := mockStore.Messages[oldNews]; ok {
+		t.Error("Expected the old news message to be deleted")
+	}
+	if _, ok := mockStore.Messages[newNews]; !ok {
+		t.Error("Expected the recent news message to be kept")
+	}
+	if _, ok := mockStore.Messages[oldLogs]; !ok {
+		t.Error("Expected messages of a topic without retention to be kept")
+	}
+}
+
+func TestRenameTopic(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.RegisterConnector("mock", NewMockConnector())
+	h.CreateTopic("news")
+	h.CreateTopic("sports")
+	mockStore.AddSubscription("news", "device-1", "mock", "alice")
+
+	if err := h.RenameTopic("news", "sports", false); !errors.Is(err, ErrTopicExists) {
+		t.Errorf("Expected ErrTopicExists, got %v", err)
+	}
+	if err := h.RenameTopic("missing", "other", false); err != ErrTopicNotFound {
+		t.Errorf("Expected ErrTopicNotFound, got %v", err)
+	}
+	if err := h.RenameTopic("news", "headlines", true); err != nil {
+		t.Fatalf("RenameTopic failed: %v", err)
+	}
+
+	// Publishers and subscribers still using the old name reach the topic
+	res, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{}`)})
+	if err != nil {
+		t.Fatalf("Publish to the alias failed: %v", err)
+	}
+	if msg, _ := mockStore.GetMessage(res.MessageID); msg == nil || msg.Topic != "headlines" {
+		t.Errorf("Expected the message on headlines, got %+v", msg)
+	}
+	if err := h.Subscribe("news", store.Subscriber{Token: "device-2", Provider: "mock", Username: "bob"}); err != nil {
+		t.Fatalf("Subscribe to the alias failed: %v", err)
+	}
+	if subs, _ := h.GetSubscribers("headlines"); len(subs) != 2 {
+		t.Errorf("Expected 2 subscribers of headlines, got %d", len(subs))
+	}
+
+	if err := h.CreateTopicWithInfo(store.TopicInfo{Name: "news"}); !errors.Is(err, ErrTopicExists) {
+		t.Errorf("Expected ErrTopicExists creating a topic named like an alias, got %v", err)
+	}
+	if aliases, _ := h.TopicAliases("headlines"); len(aliases) != 1 || aliases[0] != "news" {
+		t.Errorf("Expected the news alias, got %v", aliases)
+	}
+	if err := h.DeleteTopicAlias("sports", "news"); err != ErrAliasNotFound {
+		t.Errorf("Expected ErrAliasNotFound for another topic's alias, got %v", err)
+	}
+	if err := h.DeleteTopicAlias("headlines", "news"); err != nil {
+		t.Fatalf("DeleteTopicAlias failed: %v", err)
+	}
+	if _, err := h.Publish(context.Background(), Message{Topic: "news", Payload: json.RawMessage(`{}`)}); err != ErrTopicNotFound {
+		t.Errorf("Expected ErrTopicNotFound once the alias is gone, got %v", err)
+	}
+}
+
+func TestCreateUserTopic(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	mockStore.CreateUser("alice", "hash", "publisher")
+	h.SetMaxUserTopics(2)
+
+	if err := h.CreateUserTopic("alice", store.TopicInfo{Name: "alice/one", Owner: "bob"}); err != nil {
+		t.Fatalf("CreateUserTopic failed: %v", err)
+	}
+	if info, _ := h.GetTopicInfo("alice/one"); info.Owner != "alice" {
+		t.Errorf("Expected alice to own her topic, got %q", info.Owner)
+	}
+	for _, name := range []string{"global", "bob/two", "alice/", "alice/-x", "alice/a b"} {
+		if err := h.CreateUserTopic("alice", store.TopicInfo{Name: name}); !errors.Is(err, ErrInvalidTopicName) {
+			t.Errorf("CreateUserTopic(%q) = %v, want ErrInvalidTopicName", name, err)
+		}
+	}
+
+	// Topics an admin created in the namespace count too, once owned by alice
+	h.CreateTopicWithInfo(store.TopicInfo{Name: "alice/two", Owner: "alice"})
+	var limit *TopicLimitError
+	if err := h.CreateUserTopic("alice", store.TopicInfo{Name: "alice/three"}); !errors.As(err, &limit) || limit.Count != 2 {
+		t.Errorf("Expected TopicLimitError, got %v", err)
+	}
+
+	h.SetMaxUserTopics(0)
+	if err := h.CreateUserTopic("alice", store.TopicInfo{Name: "alice/three"}); err != ErrSelfServiceDisabled {
+		t.Errorf("Expected ErrSelfServiceDisabled, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidUnsubscribeLink is returned by UnsubscribeWithLink for a
+// signature this server didn't make.
+var ErrInvalidUnsubscribeLink = errors.New("invalid unsubscribe link")
+
+// unsubscribeLink is the signed part of an unsubscribe link.
+type unsubscribeLink struct {
+	Topic string `json:"topic"`
+	Token string `json:"token"`
+}
+
+// SetUnsubscribeLinks enables signed one-click unsubscribe links. Their
+// key is derived from secret, and they start with publicURL, e.g.
+// https://push.example.com, or are relative without it.
+func (h *Hub) SetUnsubscribeLinks(secret []byte, publicURL string) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("no-spam unsubscribe link"))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubKey = mac.Sum(nil)
+	h.publicURL = strings.TrimSuffix(publicURL, "/")
+}
+
+// UnsubscribeURL returns a link that removes token's subscription to topic
+// without logging in, or "" if links are disabled. Links don't expire.
+func (h *Hub) UnsubscribeURL(topic, token string) string {
+	h.mu.RLock()
+	key, base := h.unsubKey, h.publicURL
+	h.mu.RUnlock()
+	if key == nil {
+		return ""
+	}
+	data, err := json.Marshal(unsubscribeLink{Topic: topic, Token: token})
+	if err != nil {
+		return ""
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	sig := payload + "." + base64.RawURLEncoding.EncodeToString(signLink(key, payload))
+	return base + "/v1/unsubscribe?sig=" + url.QueryEscape(sig)
+}
+
+// UnsubscribeWithLink verifies the signature of an unsubscribe link and
+// removes the subscription it names. It returns the topic.
+func (h *Hub) UnsubscribeWithLink(sig string) (string, error) {
+	h.mu.RLock()
+	key := h.unsubKey
+	h.mu.RUnlock()
+	payload, mac, ok := strings.Cut(sig, ".")
+	if key == nil || !ok {
+		return "", ErrInvalidUnsubscribeLink
+	}
+	got, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(got, signLink(key, payload)) {
+		return "", ErrInvalidUnsubscribeLink
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidUnsubscribeLink
+	}
+	var link unsubscribeLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return "", ErrInvalidUnsubscribeLink
+	}
+	if err := h.Unsubscribe(link.Topic, link.Token); err != nil {
+		return "", err
+	}
+	return link.Topic, nil
+}
+
+func signLink(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
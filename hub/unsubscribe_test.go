@@ -0,0 +1,49 @@
+package hub
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeWithLink(t *testing.T) {
+	mockStore := NewMockStore()
+	h := NewHub(mockStore)
+	h.CreateTopic("news")
+	mockStore.AddSubscription("news", "device-1", "mock", "alice")
+
+	if link := h.UnsubscribeURL("news", "device-1"); link != "" {
+		t.Errorf("Expected no link while disabled, got %q", link)
+	}
+
+	h.SetUnsubscribeLinks([]byte("secret"), "https://push.example.com/")
+	link := h.UnsubscribeURL("news", "device-1")
+	if !strings.HasPrefix(link, "https://push.example.com/v1/unsubscribe?sig=") {
+		t.Fatalf("Unexpected link %q", link)
+	}
+	u, _ := url.Parse(link)
+	sig := u.Query().Get("sig")
+
+	// A link signed with another secret is rejected
+	other := NewHub(mockStore)
+	other.SetUnsubscribeLinks([]byte("other"), "")
+	if _, err := other.UnsubscribeWithLink(sig); err != ErrInvalidUnsubscribeLink {
+		t.Errorf("Expected ErrInvalidUnsubscribeLink for another key, got %v", err)
+	}
+	payload, mac, _ := strings.Cut(sig, ".")
+	forged := other.UnsubscribeURL("news", "device-2")
+	forgedPayload, _, _ := strings.Cut(strings.TrimPrefix(forged, "/v1/unsubscribe?sig="), ".")
+	for _, bad := range []string{"", payload, forgedPayload + "." + mac, sig + "x"} {
+		if _, err := h.UnsubscribeWithLink(bad); err != ErrInvalidUnsubscribeLink {
+			t.Errorf("Expected ErrInvalidUnsubscribeLink for %q, got %v", bad, err)
+		}
+	}
+
+	topic, err := h.UnsubscribeWithLink(sig)
+	if err != nil || topic != "news" {
+		t.Fatalf("UnsubscribeWithLink = %q, %v", topic, err)
+	}
+	if subs, _ := mockStore.GetSubscribers("news"); len(subs) != 0 {
+		t.Errorf("Expected the subscription to be removed, got %v", subs)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"no-spam/config"
+	"no-spam/errtrack"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take to finish on shutdown.
+const shutdownTimeout = 15 * time.Second
+
+// boundListener is an open listener and whether it serves TLS.
+type boundListener struct {
+	net.Listener
+	tls bool
+}
+
+// listen opens the listeners from the config file, or -addr when there are none.
+func listen(cfg Config, listeners []config.Listener) ([]boundListener, error) {
+	if len(listeners) == 0 {
+		listeners = []config.Listener{{Network: "tcp", Address: cfg.Addr}}
+	}
+
+	var bound []boundListener
+	for _, l := range listeners {
+		ln, err := openListener(l)
+		if err != nil {
+			for _, b := range bound {
+				b.Close()
+			}
+			return nil, err
+		}
+		useTLS := l.Network != "unix" && !cfg.HTTPMode
+		if l.TLS != nil {
+			useTLS = *l.TLS
+		}
+		bound = append(bound, boundListener{Listener: ln, tls: useTLS})
+	}
+	return bound, nil
+}
+
+func openListener(l config.Listener) (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen("tcp", l.Address)
+	}
+
+	// Remove a socket left behind by an unclean exit; a live server still holding it would accept the dial.
+	if conn, err := net.Dial("unix", l.Address); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("socket %s is in use", l.Address)
+	}
+	_ = os.Remove(l.Address)
+
+	ln, err := net.Listen("unix", l.Address)
+	if err != nil {
+		return nil, err
+	}
+	if mode, _ := l.FileMode(); mode != 0 {
+		if err := os.Chmod(l.Address, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// serve runs srv on every listener until one fails or the process receives
+// SIGINT/SIGTERM, then shuts down gracefully, closing all listeners.
+func serve(srv *http.Server, cfg Config, listeners []boundListener) error {
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln boundListener) {
+			var err error
+			if ln.tls {
+				log.Printf("Server listening on %s %s (TLS 1.3 strict)", ln.Addr().Network(), ln.Addr())
+				err = srv.ServeTLS(ln, cfg.CertFile, cfg.KeyFile)
+			} else {
+				log.Printf("Server listening on %s %s (HTTP - TLS Disabled)", ln.Addr().Network(), ln.Addr())
+				err = srv.Serve(ln)
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}(ln)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var serveErr error
+	select {
+	case s := <-sig:
+		log.Printf("Received %s, shutting down", s)
+	case serveErr = <-errs:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	errtrack.Flush(2 * time.Second)
+	if err != nil {
+		return err
+	}
+	return serveErr
+}
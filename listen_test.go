@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"no-spam/config"
+)
+
+func TestListen(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "no-spam.sock")
+	plain := false
+	listeners, err := listen(Config{Addr: "127.0.0.1:0"}, []config.Listener{
+		{Network: "unix", Address: sock, Mode: "0600"},
+		{Address: "127.0.0.1:0"},
+		{Address: "127.0.0.1:0", TLS: &plain},
+	})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+
+	if len(listeners) != 3 {
+		t.Fatalf("Expected 3 listeners, got %d", len(listeners))
+	}
+	if listeners[0].tls || !listeners[1].tls || listeners[2].tls {
+		t.Errorf("Unexpected TLS settings: %v %v %v", listeners[0].tls, listeners[1].tls, listeners[2].tls)
+	}
+	if info, err := os.Stat(sock); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected socket with mode 0600, got %v (%v)", info, err)
+	}
+
+	// A socket still being served is not replaced
+	if _, err := listen(Config{}, []config.Listener{{Network: "unix", Address: sock}}); err == nil {
+		t.Error("Expected error for socket in use")
+	}
+}
+
+func TestListen_StaleSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "no-spam.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close() // Leaves the socket file behind, as after a crash
+
+	listeners, err := listen(Config{}, []config.Listener{{Network: "unix", Address: sock}})
+	if err != nil {
+		t.Fatalf("Expected stale socket to be replaced: %v", err)
+	}
+	listeners[0].Close()
+}
+
+func TestListen_DefaultAddr(t *testing.T) {
+	listeners, err := listen(Config{Addr: "127.0.0.1:0", HTTPMode: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].Close()
+	if len(listeners) != 1 || listeners[0].tls {
+		t.Errorf("Expected a single plain -addr listener, got %+v", listeners)
+	}
+}
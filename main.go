@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -9,48 +12,289 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"flag"
+	"fmt"
 	"log"
 	"math/big"
 	"net"
 	"net/http"
+	"net/url"
+	"no-spam/anomaly"
+	"no-spam/apierror"
+	"no-spam/backup"
+	"no-spam/bridge"
+	"no-spam/cluster"
+	"no-spam/config"
 	"no-spam/connectors"
+	"no-spam/events"
 	"no-spam/handlers"
 	"no-spam/hub"
 	"no-spam/middleware"
+	"no-spam/password"
+	"no-spam/queue"
+	"no-spam/rbac"
+	"no-spam/sso"
 	"no-spam/store"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Config struct {
+	ConfigFile           string // Optional JSON config file
 	Addr                 string
 	CertFile             string
 	KeyFile              string
 	HTTPMode             bool
 	FCMCreds             string
+	FCMTopics            bool          // Register the fcm-topic provider, delivering through FCM topic messaging
+	Store                string        // "sqlite" (default), "bolt" or "memory"
+	DBPath               string        // Database file; defaults to no-spam.db, or no-spam.bolt for bolt
+	SlowStoreQuery       time.Duration // Store calls taking longer are logged; 0 disables
+	StoreCacheTTL        time.Duration // Topic and subscriber lookups are cached this long; 0 disables
+	StaleSubscriptions   int           // Days without a delivery after which subscriptions are pruned; 0 keeps them
+	PayloadCompression   string        // "gzip" or "zstd" compresses large stored payloads (sqlite only)
+	CompressThreshold    int           // Payloads of at least this many bytes are compressed
+	BackupDir            string        // Directory for scheduled backups (optional)
+	BackupS3             backup.S3Config
+	BackupInterval       time.Duration
+	BackupKeep           int // Newest backups kept per target; 0 keeps all
 	InitialAdminPassword *string
+	QueueBackend         string // "sqlite" (poll only) or "redis"
+	RedisAddr            string
+	RedisPassword        string
+	QueueWorkers         int
+	NodeID               string
+	Cluster              bool // Join the Redis cluster bus
+	BreakerThreshold     int  // Consecutive failures before a connector target's circuit opens; 0 disables
+	BreakerCooldown      time.Duration
+	NATSURL              string
+	NATSSubjectPrefix    string
+	NATSMappings         string // "subject=topic,..." consumed from NATS into topics
+	KafkaBrokers         string // Comma-separated broker addresses
+	KafkaGroup           string
+	KafkaMappings        string // "kafka-topic=topic,..."
+	KafkaTemplateDir     string // Directory with <kafka-topic>.tmpl payload templates
+	WebhookAllowPrivate  bool   // Allow webhooks to loopback/private addresses
+	Anomaly              bool   // Enable publish burst detection
+	AnomalyConfig        anomaly.Config
+	AnomalyAlertTopic    string // Topic receiving burst alerts (optional)
+	SLOConfig            hub.SLOConfig
+	Registration         bool   // Enable POST /register with invitation codes
+	PublicURL            string // Base URL of links handed out, e.g. unsubscribe links
+	AppLink              string // Mobile app deep link on public topic pages, with {topic}
+	PublicSubscribe      bool   // Let browsers subscribe to public topics without an account
+	PublicSubscribeRate  int    // Anonymous subscribe requests per minute per client IP; 0 disables the limit
+	MaxPublicSubscribers int    // Anonymous subscriptions per public topic; 0 means no cap
+	ImageProxy           bool   // Serve notification images and icons through /v1/images
+	TrustedProxies       string // Comma-separated proxy IPs/CIDRs allowed to set client IP headers
+	ClientIPHeaders      string // Comma-separated headers read from trusted proxies
+	MaxBodySize          int64  // Request body limit in bytes; 0 disables
+	CompressResponses    int    // Responses of at least this many bytes are compressed; 0 disables
+	AccessLog            bool   // Log every request
+	AccessLogSampled     string // Comma-separated routes whose successful requests are sampled
+	AccessLogSampleRate  float64
+	NoLegacyRoutes       bool   // Serve the API only under /v1, not at its deprecated unversioned paths
+	MaxPayloadSize       int    // Per-message payload cap in bytes; 0 disables
+	SyncSendLimit        int    // Subscriber cap of /send?sync=true
+	LargeSendThreshold   int    // Sends to more subscribers need approval or large_send; 0 disables
+	DeliveryConcurrency  int    // Inline deliveries, or batches of them, in flight; 0 uses the default
+	MaxQueueDepth        int    // Pending deliveries cap; 0 disables
+	MaxTopicQueueDepth   int    // Pending deliveries cap per topic; 0 disables
+	MaxTopicsPerUser     int    // Topics each publisher may create in their namespace; 0 disables
+	ClientCA             string // CA bundle verifying client certificates; enables mTLS
+	ClientAuth           string // "require" or "optional" client certificates with ClientCA
+	ClientCertIdentity   string // Certificate field mapped to a username: "cn" or "san"
+	CertOptions          certOptions
+	File                 *config.File // Parsed -config; loaded by run when nil
+}
+
+// certOptions controls the self-signed certificate generated when -cert is missing.
+type certOptions struct {
+	Hosts    []string      // DNS names and IPs added to localhost and 127.0.0.1
+	KeyType  string        // "rsa" (default) or "ecdsa"
+	Validity time.Duration // Defaults to one year
 }
 
 func main() {
+	configFile := flag.String("config", "", "Path to JSON config file (optional)")
 	certFile := flag.String("cert", "certs/cert.pem", "Path to TLS certificate file")
 	keyFile := flag.String("key", "certs/key.pem", "Path to TLS key file")
 	addr := flag.String("addr", ":8443", "Address to listen on")
 	fcmCreds := flag.String("fcm-creds", "", "Path to Firebase credentials file (optional)")
+	fcmTopics := flag.Bool("fcm-topics", false, "Register the fcm-topic provider: its subscriptions join FCM topics, which get one message per publish")
 	httpMode := flag.Bool("http", false, "Run in HTTP mode (disable TLS)")
-	initialAdminPassword := flag.String("initial-admin-password", "", "Initial password for admin user (optional)")
+	storeBackend := flag.String("store", "sqlite", "Storage backend: sqlite, bolt (pure Go) or memory (nothing persisted)")
+	payloadCompression := flag.String("payload-compression", "", "Compress stored payloads with gzip or zstd (sqlite store only)")
+	compressThreshold := flag.Int("payload-compression-threshold", 1024, "Compress stored payloads of at least this many bytes")
+	slowStoreQuery := flag.Duration("slow-store-query", 250*time.Millisecond, "Log store calls taking longer than this (0 disables)")
+	staleSubscriptions := flag.Int("stale-subscription-days", 0, "Remove subscriptions without a successful delivery for this many days, hourly (0 keeps them)")
+	storeCacheTTL := flag.Duration("store-cache-ttl", 30*time.Second, "Cache topic and subscriber lookups this long (0 disables)")
+	backupDir := flag.String("backup-dir", "", "Directory receiving scheduled SQLite backups (optional)")
+	backupS3Endpoint := flag.String("backup-s3-endpoint", "", "S3-compatible endpoint receiving scheduled backups, e.g. s3.amazonaws.com (optional)")
+	backupS3Bucket := flag.String("backup-s3-bucket", "", "Bucket for scheduled backups")
+	backupS3Prefix := flag.String("backup-s3-prefix", "", "Object key prefix for scheduled backups, e.g. backups/")
+	backupS3Insecure := flag.Bool("backup-s3-insecure", false, "Use plain HTTP for the backup S3 endpoint")
+	backupInterval := flag.Duration("backup-interval", 24*time.Hour, "Time between scheduled backups")
+	backupKeep := flag.Int("backup-keep", 7, "Number of scheduled backups kept per target (0 keeps all)")
+	dbPath := flag.String("db", "", "Path to the database file (default no-spam.db, or no-spam.bolt with -store bolt)")
+	initialAdminPassword := flag.String("initial-admin-password", os.Getenv("INITIAL_ADMIN_PASSWORD"), "Initial password for admin user (optional, defaults to $INITIAL_ADMIN_PASSWORD)")
+	queueBackend := flag.String("queue", "sqlite", "Queue backend: sqlite (poll only) or redis")
+	redisAddr := flag.String("redis-addr", "localhost:6379", "Redis address for the redis queue backend")
+	queueWorkers := flag.Int("queue-workers", 4, "Number of queue workers consuming the push queue")
+	nodeID := flag.String("node-id", "", "Node identifier used when claiming queue items (default hostname-pid)")
+	clusterMode := flag.Bool("cluster", false, "Join the cluster bus on -redis-addr to share deliveries with other nodes")
+	natsURL := flag.String("nats-url", "", "NATS server URL; enables the NATS bridge (optional)")
+	natsPrefix := flag.String("nats-subject-prefix", bridge.DefaultNATSSubjectPrefix, "Subject prefix for messages published to NATS")
+	natsMappings := flag.String("nats-map", "", "Comma-separated subject=topic mappings consumed from NATS")
+	kafkaBrokers := flag.String("kafka-brokers", "", "Comma-separated Kafka brokers; enables Kafka ingestion (requires -tags kafka build)")
+	kafkaGroup := flag.String("kafka-group", "no-spam", "Kafka consumer group ID")
+	kafkaMappings := flag.String("kafka-map", "", "Comma-separated kafka-topic=topic mappings")
+	kafkaTemplateDir := flag.String("kafka-template-dir", "", "Directory containing <kafka-topic>.tmpl payload templates (optional)")
+	breakerThreshold := flag.Int("breaker-threshold", 5, "Consecutive failures before a connector circuit opens (0 disables)")
+	breakerCooldown := flag.Duration("breaker-cooldown", 30*time.Second, "How long an open circuit waits before probing")
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false, "Allow webhook targets on loopback, private and link-local addresses")
+	anomalyEnabled := flag.Bool("anomaly", false, "Detect abnormal publish bursts per publisher and topic")
+	anomalyWindow := flag.Duration("anomaly-window", time.Minute, "Counting window for burst detection")
+	anomalyFactor := flag.Float64("anomaly-factor", 10, "Multiple of the rolling baseline that counts as a burst")
+	anomalyMin := flag.Int("anomaly-min", 30, "Sends per window always allowed before detection applies")
+	anomalyMode := flag.String("anomaly-mode", anomaly.ModeThrottle, "Action on burst: throttle (rest of the window) or quarantine")
+	anomalyCooldown := flag.Duration("anomaly-cooldown", 15*time.Minute, "Quarantine duration (0 = until released by an admin)")
+	anomalyAlertTopic := flag.String("anomaly-alert-topic", "", "Topic that receives burst alerts (optional)")
+	sloLatency := flag.Duration("slo-latency-p95", 0, "Alert when a topic's p95 delivery latency exceeds this (0 disables)")
+	sloFailureRate := flag.Float64("slo-failure-rate", 0, "Alert when a topic's delivery failure rate exceeds this fraction, e.g. 0.05 (0 disables)")
+	sloWindow := flag.Duration("slo-window", time.Hour, "Window of deliveries the objectives are checked over")
+	sloMinDeliveries := flag.Int("slo-min-deliveries", 20, "Topics with fewer finished deliveries in the window aren't checked")
+	sloAlertTopic := flag.String("slo-alert-topic", "", "Topic that receives delivery objective alerts (optional)")
+	registration := flag.Bool("registration", false, "Allow self-registration with admin-issued invitation codes")
+	publicURL := flag.String("public-url", "", "Base URL clients reach the server at, e.g. https://push.example.com, used in unsubscribe links (optional)")
+	imageProxy := flag.Bool("image-proxy", false, "Serve notification images and icons through this server, so devices don't connect to the publisher's hosts (requires -public-url)")
+	publicSubscribe := flag.Bool("public-subscribe", false, "Let browsers subscribe to public topics from their pages without an account (requires a webpush connector)")
+	publicSubscribeRate := flag.Int("public-subscribe-rate", 10, "Anonymous subscribe requests allowed per minute per client IP (0 = unlimited)")
+	maxPublicSubscribers := flag.Int("max-public-subscribers", hub.DefaultMaxPublicSubscribers, "Maximum anonymous subscriptions of each public topic (0 = unlimited)")
+	appLink := flag.String("app-link", "", "Deep link into the mobile app shown on public topic pages, with {topic} for the topic name, e.g. myapp://subscribe?topic={topic} (optional)")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated IPs or CIDRs of reverse proxies whose client IP headers are trusted")
+	clientIPHeaders := flag.String("client-ip-headers", "X-Forwarded-For,X-Real-IP", "Comma-separated headers carrying the client IP from trusted proxies")
+	maxBodySize := flag.Int64("max-body-size", 1<<20, "Maximum request body size in bytes (0 = unlimited)")
+	legacyRoutes := flag.Bool("legacy-routes", true, "Also serve the API at its deprecated unversioned paths, besides /v1")
+	accessLog := flag.Bool("access-log", true, "Log every request with its status, latency, user and client IP")
+	accessLogSampled := flag.String("access-log-sampled", "/send,/receipts", "Comma-separated high-volume routes whose successful requests are sampled")
+	accessLogSampleRate := flag.Float64("access-log-sample-rate", 1, "Share of successful requests to -access-log-sampled routes that are logged, e.g. 0.01")
+	compressResponses := flag.Int("compress-responses", 1024, "Compress text and JSON responses of at least this many bytes with gzip or deflate (0 disables)")
+	maxPayloadSize := flag.Int("max-payload-size", 64<<10, "Maximum size in bytes of a message payload (0 = unlimited)")
+	maxQueueDepth := flag.Int("max-queue-depth", 0, "Maximum pending deliveries before /send is rejected (0 = unlimited)")
+	maxTopicQueueDepth := flag.Int("max-topic-queue-depth", 0, "Maximum pending deliveries of one topic before /send to it is rejected (0 = unlimited)")
+	maxTopicsPerUser := flag.Int("max-topics-per-user", 10, "Maximum topics each publisher may create under <username>/ (0 = publishers can't create topics)")
+	syncSendLimit := flag.Int("sync-send-limit", hub.DefaultSyncLimit, "Maximum subscribers of a synchronous send (/send?sync=true)")
+	largeSendThreshold := flag.Int("large-send-threshold", 0, "Sends to more subscribers are held for approval unless the publisher has large_send (0 disables)")
+	deliveryConcurrency := flag.Int("delivery-concurrency", hub.DefaultDeliveryConcurrency, "Maximum deliveries, or batches of them, attempted at once")
+	clientCA := flag.String("client-ca", "", "PEM CA bundle for verifying client certificates; enables mutual TLS (optional)")
+	clientAuth := flag.String("client-auth", "require", "With -client-ca: require a client certificate, or make it optional so JWTs still work")
+	clientCertIdentity := flag.String("client-cert-identity", middleware.IdentityCN, "Client certificate field used as the username: cn or san")
+	certHosts := flag.String("cert-hosts", "", "Comma-separated extra DNS names and IPs for the generated self-signed certificate")
+	certKeyType := flag.String("cert-key-type", "rsa", "Key type for the generated self-signed certificate: rsa or ecdsa")
+	certValidity := flag.Duration("cert-validity", 365*24*time.Hour, "Validity period of the generated self-signed certificate")
 	flag.Parse()
 
 	cfg := Config{
-		Addr:                 *addr,
-		CertFile:             *certFile,
-		KeyFile:              *keyFile,
-		HTTPMode:             *httpMode,
-		FCMCreds:             *fcmCreds,
+		ConfigFile:         *configFile,
+		Addr:               *addr,
+		CertFile:           *certFile,
+		KeyFile:            *keyFile,
+		HTTPMode:           *httpMode,
+		FCMCreds:           *fcmCreds,
+		FCMTopics:          *fcmTopics,
+		Store:              *storeBackend,
+		DBPath:             *dbPath,
+		SlowStoreQuery:     *slowStoreQuery,
+		PayloadCompression: *payloadCompression,
+		CompressThreshold:  *compressThreshold,
+		BackupDir:          *backupDir,
+		BackupS3: backup.S3Config{
+			Endpoint:  *backupS3Endpoint,
+			Bucket:    *backupS3Bucket,
+			Prefix:    *backupS3Prefix,
+			AccessKey: os.Getenv("BACKUP_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("BACKUP_S3_SECRET_KEY"),
+			Insecure:  *backupS3Insecure,
+		},
+		BackupInterval:       *backupInterval,
+		BackupKeep:           *backupKeep,
 		InitialAdminPassword: initialAdminPassword,
+		QueueBackend:         *queueBackend,
+		RedisAddr:            *redisAddr,
+		RedisPassword:        os.Getenv("REDIS_PASSWORD"),
+		QueueWorkers:         *queueWorkers,
+		NodeID:               *nodeID,
+		Cluster:              *clusterMode,
+		BreakerThreshold:     *breakerThreshold,
+		BreakerCooldown:      *breakerCooldown,
+		WebhookAllowPrivate:  *webhookAllowPrivate,
+		Anomaly:              *anomalyEnabled,
+		AnomalyConfig: anomaly.Config{
+			Window:   *anomalyWindow,
+			Factor:   *anomalyFactor,
+			MinCount: *anomalyMin,
+			Mode:     *anomalyMode,
+			Cooldown: *anomalyCooldown,
+		},
+		AnomalyAlertTopic:    *anomalyAlertTopic,
+		Registration:         *registration,
+		PublicURL:            *publicURL,
+		AppLink:              *appLink,
+		PublicSubscribe:      *publicSubscribe,
+		PublicSubscribeRate:  *publicSubscribeRate,
+		MaxPublicSubscribers: *maxPublicSubscribers,
+		ImageProxy:           *imageProxy,
+		TrustedProxies:       *trustedProxies,
+		ClientIPHeaders:      *clientIPHeaders,
+		MaxBodySize:          *maxBodySize,
+		CompressResponses:    *compressResponses,
+		AccessLog:            *accessLog,
+		AccessLogSampled:     *accessLogSampled,
+		AccessLogSampleRate:  *accessLogSampleRate,
+		NoLegacyRoutes:       !*legacyRoutes,
+		MaxPayloadSize:       *maxPayloadSize,
+		SyncSendLimit:        *syncSendLimit,
+		LargeSendThreshold:   *largeSendThreshold,
+		MaxQueueDepth:        *maxQueueDepth,
+		MaxTopicQueueDepth:   *maxTopicQueueDepth,
+		MaxTopicsPerUser:     *maxTopicsPerUser,
+		ClientCA:             *clientCA,
+		ClientAuth:           *clientAuth,
+		ClientCertIdentity:   *clientCertIdentity,
+		CertOptions: certOptions{
+			Hosts:    splitList(*certHosts),
+			KeyType:  *certKeyType,
+			Validity: *certValidity,
+		},
+		NATSURL:           *natsURL,
+		NATSSubjectPrefix: *natsPrefix,
+		NATSMappings:      *natsMappings,
+		KafkaBrokers:      *kafkaBrokers,
+		KafkaGroup:        *kafkaGroup,
+		KafkaMappings:     *kafkaMappings,
+		KafkaTemplateDir:  *kafkaTemplateDir,
+		SLOConfig: hub.SLOConfig{
+			Window:      *sloWindow,
+			LatencyP95:  *sloLatency,
+			FailureRate: *sloFailureRate,
+			MinSamples:  *sloMinDeliveries,
+			AlertTopic:  *sloAlertTopic,
+		},
+		DeliveryConcurrency: *deliveryConcurrency,
+		StoreCacheTTL:       *storeCacheTTL,
+		StaleSubscriptions:  *staleSubscriptions,
+	}
+
+	if cfg.ConfigFile != "" {
+		f, err := config.Load(cfg.ConfigFile)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		cfg.File = f
 	}
 
 	srv, err := run(cfg)
@@ -58,113 +302,442 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 
-	if cfg.HTTPMode {
-		log.Printf("Server listening on %s (HTTP - TLS Disabled)", cfg.Addr)
-		log.Printf("WARNING: Traffic is unencrypted. Ensure you are running behind a secure proxy.")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("Server failed: ", err)
-		}
-	} else {
-		log.Printf("Server listening on %s (TLS 1.3 strict)", cfg.Addr)
-		// Check/Generate certs logic remains here or moves to run?
-		// Better to keep cert generation in main or run?
-		// Let's keep cert generation in main for now to keep run clean, or move it.
-		// Actually, run needs to set up the server. ListenAndServeTLS blocks.
-		// So run should return the configured server, and main calls ListenAndServe.
+	var listenerConfigs []config.Listener
+	if cfg.File != nil {
+		listenerConfigs = cfg.File.Listeners
+	}
+	listeners, err := listen(cfg, listenerConfigs)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
 
+	useTLS := false
+	for _, ln := range listeners {
+		if ln.tls {
+			useTLS = true
+		} else if ln.Addr().Network() == "tcp" {
+			log.Printf("WARNING: Traffic on %s is unencrypted. Ensure you are running behind a secure proxy.", ln.Addr())
+		}
+	}
+	if useTLS {
 		// Check if cert files exist, generate if not
 		if _, err := os.Stat(cfg.CertFile); os.IsNotExist(err) {
 			log.Printf("Certificate file %s not found. Generating self-signed certificate...", cfg.CertFile)
-			if err := generateSelfSignedCert(cfg.CertFile, cfg.KeyFile); err != nil {
+			if err := generateSelfSignedCert(cfg.CertFile, cfg.KeyFile, cfg.CertOptions); err != nil {
 				log.Fatalf("Failed to generate certificate: %v", err)
 			}
 			log.Printf("Successfully generated self-signed certificate at %s and %s", cfg.CertFile, cfg.KeyFile)
 		} else {
 			log.Printf("Found existing certificate: %s", cfg.CertFile)
 		}
+	}
 
-		if err := srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != nil && err != http.ErrServerClosed {
-			log.Fatal("Server failed: ", err)
-		}
+	if err := serve(srv, cfg, listeners); err != nil {
+		log.Fatal("Server failed: ", err)
 	}
 }
 
 func run(cfg Config) (*http.Server, error) {
+	file := cfg.File
+	if file == nil && cfg.ConfigFile != "" {
+		f, err := config.Load(cfg.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+	}
+
+	applyTokenPolicy(file)
+	applyPasswordPolicy(file)
+	if err := applyErrorTracking(file); err != nil {
+		return nil, err
+	}
+
 	// Initialize Store
-	s, err := store.NewSQLiteStore("no-spam.db")
+	backend, err := openStore(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.PayloadCompression != "" {
+		sqlite, ok := backend.(*store.SQLiteStore)
+		if !ok {
+			return nil, fmt.Errorf("-payload-compression requires -store sqlite")
+		}
+		if err := sqlite.SetPayloadCompression(cfg.PayloadCompression, cfg.CompressThreshold); err != nil {
+			return nil, err
+		}
+	}
+	instrumented := store.Instrument(backend, cfg.SlowStoreQuery)
+	s := store.Cache(instrumented, cfg.StoreCacheTTL)
+
+	if file != nil {
+		if err := bootstrap(s, file.Bootstrap); err != nil {
+			return nil, err
+		}
+	}
 
 	// Check for admin user (logic kept same)
 	setupAdminUser(s, cfg.InitialAdminPassword)
 
 	// Initialize Hub
 	h := hub.NewHub(s)
+	if cfg.NodeID != "" {
+		h.SetNodeID(cfg.NodeID)
+	}
+	h.SetMaxPayloadSize(cfg.MaxPayloadSize)
+	h.SetSyncLimit(cfg.SyncSendLimit)
+	h.SetLargeSendThreshold(cfg.LargeSendThreshold)
+	h.SetDeliveryConcurrency(cfg.DeliveryConcurrency)
+	h.SetMaxQueueDepth(cfg.MaxQueueDepth, cfg.MaxTopicQueueDepth)
+	h.SetMaxUserTopics(cfg.MaxTopicsPerUser)
+	h.SetMaxPublicSubscribers(cfg.MaxPublicSubscribers)
+	if cfg.PublicURL != "" {
+		if u, err := url.Parse(cfg.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid -public-url: expected an http or https URL")
+		}
+	}
+	h.SetUnsubscribeLinks(middleware.GetJWTSecret(), cfg.PublicURL)
+	if cfg.ImageProxy {
+		if cfg.PublicURL == "" {
+			return nil, fmt.Errorf("-image-proxy requires -public-url, as devices need absolute image links")
+		}
+		h.SetImageProxy(middleware.GetJWTSecret(), connectors.NewImageProxy())
+	}
 
-	// Initialize Connectors
-	mockConn := connectors.NewMockConnector()
-	fcmConn := connectors.NewFCMConnector(cfg.FCMCreds)
-	apnsConn := connectors.NewAPNSConnector()
-	webhookConn := connectors.NewWebhookConnector()
+	if err := configureConnectors(h, cfg, file); err != nil {
+		return nil, err
+	}
+	events.SetTransport(h.EventTransport())
+	applyHooks(file)
+	applyCategories(file)
+	applyLogLevel(file)
+	applyRetention(h, cfg, file)
+	reload := func() error { return reloadConfig(h, cfg) }
+	reloadOnSIGHUP(reload)
 
-	// Register Connectors
-	h.RegisterConnector("mock", mockConn)
-	h.RegisterConnector("fcm", fcmConn)
-	h.RegisterConnector("apns", apnsConn)
-	h.RegisterConnector("webhook", webhookConn)
+	if cfg.Anomaly {
+		switch cfg.AnomalyConfig.Mode {
+		case anomaly.ModeThrottle, anomaly.ModeQuarantine:
+		default:
+			return nil, fmt.Errorf("unknown anomaly mode: %s", cfg.AnomalyConfig.Mode)
+		}
+		h.SetAnomalyDetector(anomaly.NewDetector(cfg.AnomalyConfig), cfg.AnomalyAlertTopic)
+		log.Printf("[Anomaly] Burst detection enabled (%s, factor %.1f)", cfg.AnomalyConfig.Mode, cfg.AnomalyConfig.Factor)
+	}
 
 	// Start background queue processor
 	ctx := context.Background()
 	h.StartQueueProcessor(ctx)
+	h.StartRetention(ctx)
+	h.StartStats(ctx)
+	h.StartSchedules(ctx)
+	if slo := cfg.SLOConfig; slo.LatencyP95 > 0 || slo.FailureRate > 0 {
+		if slo.Window <= 0 {
+			return nil, fmt.Errorf("slo window must be positive, got %s", slo.Window)
+		}
+		h.StartSLO(ctx, slo)
+		log.Printf("[SLO] Checking delivery objectives over %s (p95 %s, failure rate %.3f)", slo.Window, slo.LatencyP95, slo.FailureRate)
+	}
+
+	// Optional push queue
+	switch cfg.QueueBackend {
+	case "", "sqlite":
+	case "redis":
+		q, err := queue.NewRedisQueue(cfg.RedisAddr, cfg.RedisPassword, 0, queue.DefaultRedisKey)
+		if err != nil {
+			return nil, err
+		}
+		h.SetQueue(q)
+		workers := cfg.QueueWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+		h.StartQueueWorkers(ctx, workers)
+	default:
+		return nil, fmt.Errorf("unknown queue backend: %s", cfg.QueueBackend)
+	}
+
+	if err := startBackups(ctx, backend, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Cluster {
+		bus, err := cluster.NewRedisBus(cfg.RedisAddr, cfg.RedisPassword, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.StartCluster(ctx, bus); err != nil {
+			return nil, err
+		}
+		if err := shareCacheDrops(ctx, bus, s); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.NATSURL != "" {
+		mappings, err := bridge.ParseMappings(cfg.NATSMappings)
+		if err != nil {
+			return nil, err
+		}
+		nb, err := bridge.NewNATSBridge(cfg.NATSURL, cfg.NATSSubjectPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if err := nb.Attach(h, mappings); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.KafkaBrokers != "" {
+		mappings, err := bridge.ParseMappings(cfg.KafkaMappings)
+		if err != nil {
+			return nil, err
+		}
+		routes, err := bridge.LoadRoutes(mappings, cfg.KafkaTemplateDir)
+		if err != nil {
+			return nil, err
+		}
+		var topics []string
+		for t := range mappings {
+			topics = append(topics, t)
+		}
+		reader, err := bridge.NewKafkaReader(strings.Split(cfg.KafkaBrokers, ","), cfg.KafkaGroup, topics)
+		if err != nil {
+			return nil, err
+		}
+		go bridge.Ingest(ctx, h, reader, routes, "kafka")
+		log.Printf("[Kafka] Consuming %d topics as group %s", len(topics), cfg.KafkaGroup)
+	}
 
 	// Initialize Gin
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
+	// Namespaced topics contain "/", which clients escape as %2F in :name.
+	router.UseRawPath = true
+	if cfg.AccessLog {
+		if cfg.AccessLogSampleRate < 0 || cfg.AccessLogSampleRate > 1 {
+			return nil, fmt.Errorf("invalid -access-log-sample-rate: expected a share between 0 and 1")
+		}
+		// First, to log the 500 of a recovered panic too
+		router.Use(middleware.AccessLog(middleware.AccessLogConfig{
+			Sampled:    splitList(cfg.AccessLogSampled),
+			SampleRate: cfg.AccessLogSampleRate,
+		}))
+	}
 	router.Use(gin.Recovery())
+	router.Use(middleware.ReportPanics())
+	if err := middleware.TrustProxies(router, splitList(cfg.TrustedProxies), splitList(cfg.ClientIPHeaders)); err != nil {
+		return nil, fmt.Errorf("invalid -trusted-proxies: %w", err)
+	}
+	router.Use(middleware.MaxBodySize(cfg.MaxBodySize, "/v1/admin/restore", "/v1/admin/import", "/admin/restore", "/admin/import"))
+	router.Use(middleware.Compress(cfg.CompressResponses))
+	router.NoRoute(func(c *gin.Context) { apierror.Respond(c, http.StatusNotFound, "Not found") })
 
-	// Public routes (no auth)
-	router.POST("/admin/login", handlers.LoginHandler(s))
-
-	// Authenticated routes
-	auth := router.Group("/")
-	auth.Use(middleware.JWTAuthMiddleware())
-	{
-		auth.POST("/refresh", handlers.RefreshHandler())
-
-		// Subscriber routes
-		subscribers := auth.Group("/")
-		subscribers.Use(middleware.RequireRole("subscriber"))
-		{
-			subscribers.POST("/subscribe", handlers.SubscribeHandler(h))
-			subscribers.POST("/unsubscribe", handlers.UnsubscribeHandler(h))
-			subscribers.GET("/topics", handlers.TopicsHandler(h))
+	var provider *sso.Provider
+	if file != nil && file.OIDC != nil {
+		if provider, err = sso.NewProvider(ctx, *file.OIDC); err != nil {
+			return nil, err
 		}
+		log.Printf("[OIDC] Login enabled with issuer %s", file.OIDC.Issuer)
+	}
+	publicPages := handlers.PublicPageConfig{PublicURL: cfg.PublicURL, AppLink: cfg.AppLink, Subscribe: cfg.PublicSubscribe}
+	// Shared by the /v1 and legacy routes, so a client can't double its rate
+	publicSubscribeLimit := middleware.RateLimitByIP(cfg.PublicSubscribeRate)
 
-		// Publisher routes
-		publishers := auth.Group("/")
-		publishers.Use(middleware.RequireRole("publisher"))
-		{
-			publishers.POST("/send", handlers.SendHandler(h))
-			publishers.GET("/stats", handlers.StatsHandler(h))
+	// Every route is served under /v1 and, until clients have moved, at its
+	// legacy unversioned path with deprecation headers.
+	apis := []*gin.RouterGroup{router.Group("/v1")}
+	if !cfg.NoLegacyRoutes {
+		apis = append(apis, router.Group("", middleware.Deprecated("/v1")))
+	}
+	for _, api := range apis {
+		// Public routes (no auth)
+		api.POST("/admin/login", handlers.LoginHandler(s))
+		api.GET("/unsubscribe", handlers.UnsubscribeLinkHandler(h))
+		api.GET("/images", handlers.ImageHandler(h))
+		api.GET("/t/sw.js", handlers.PublicServiceWorkerHandler())
+		api.GET("/t/:name", handlers.PublicTopicPageHandler(h, publicPages))
+		api.GET("/t/:name/qr.png", handlers.PublicTopicQRHandler(h, publicPages))
+		if cfg.PublicSubscribe {
+			api.POST("/t/:name/subscribe", publicSubscribeLimit, handlers.PublicSubscribeHandler(h))
+		}
+		if cfg.Registration {
+			api.POST("/register", handlers.RegisterHandler(s))
+		}
+		if provider != nil {
+			api.GET("/admin/login/oidc", handlers.OIDCLoginHandler(provider))
+			api.GET("/admin/login/oidc/callback", handlers.OIDCCallbackHandler(s, provider))
 		}
 
-		// Admin routes
-		admin := auth.Group("/admin")
-		admin.Use(middleware.RequireRole("admin"))
+		// Authenticated routes
+		auth := api.Group("")
+		if cfg.ClientCA != "" {
+			auth.Use(middleware.ClientCertMiddleware(func(username string) (string, error) {
+				user, err := s.GetUser(username)
+				if err != nil || user == nil || user.Disabled {
+					return "", err
+				}
+				return user.Role, nil
+			}, cfg.ClientCertIdentity))
+		}
+		auth.Use(middleware.JWTAuthMiddleware(handlers.SessionCheck(s)))
 		{
-			admin.GET("/topics", handlers.ListTopicsHandler(h))
-			admin.POST("/topics", handlers.CreateTopicHandler(h))
-			admin.DELETE("/topics/:name", handlers.DeleteTopicHandler(h))
-			admin.GET("/topics/:name/messages", handlers.GetMessagesHandler(h))
-			admin.DELETE("/topics/:name/messages", handlers.ClearMessagesHandler(h))
-			admin.GET("/topics/:name/subscribers", handlers.GetSubscribersHandler(h))
-			admin.DELETE("/topics/:name/subscribers", handlers.ClearSubscribersHandler(h))
-			admin.GET("/topics/:name/queue", handlers.GetQueueHandler(h))
-			admin.POST("/users", handlers.CreateUserHandler(s))
-			admin.DELETE("/users/:username", handlers.DeleteUserHandler(s))
-			admin.GET("/users", handlers.ListUsersHandler(s))
-			admin.GET("/token", handlers.GetTokenHandler(s))
+			auth.POST("/refresh", handlers.RefreshHandler(s))
+			auth.POST("/2fa/enroll", handlers.EnrollTOTPHandler(s))
+			auth.POST("/2fa/verify", handlers.VerifyTOTPHandler(s))
+			auth.POST("/2fa/disable", handlers.DisableTOTPHandler(s))
+			auth.GET("/me", handlers.GetProfileHandler(h, s))
+			auth.PATCH("/me", handlers.UpdateProfileHandler(s))
+
+			// Permissions are resolved from the role on every request, so
+			// custom roles can be edited without reissuing tokens.
+			resolve := func(role string) ([]string, error) { return rbac.Permissions(s, role) }
+			require := func(perm string) gin.HandlerFunc { return middleware.RequirePermission(resolve, perm) }
+
+			// Listings polled by dashboards answer If-None-Match with 304
+			etag := middleware.ETag()
+
+			// Subscriber routes
+			subscribers := auth.Group("/")
+			subscribers.Use(require(rbac.Subscribe))
+			{
+				subscribers.POST("/subscribe", handlers.SubscribeHandler(h))
+				subscribers.POST("/unsubscribe", handlers.UnsubscribeHandler(h))
+				subscribers.POST("/unsubscribe/tag", handlers.UnsubscribeByTagHandler(h))
+				subscribers.POST("/subscriptions/tags", handlers.UpdateTagsHandler(h, false))
+				subscribers.DELETE("/subscriptions/tags", handlers.UpdateTagsHandler(h, true))
+				subscribers.PATCH("/subscriptions/preferences", handlers.UpdatePreferencesHandler(h))
+				subscribers.GET("/topics", etag, handlers.TopicsHandler(h))
+				subscribers.POST("/receipts", handlers.ReceiptHandler(h))
+			}
+
+			// Publisher routes
+			auth.POST("/send", require(rbac.Publish), handlers.SendHandler(h))
+			auth.GET("/messages/:id", require(rbac.Publish), handlers.MessageStatusHandler(h))
+			auth.POST("/topics", require(rbac.Publish), handlers.CreateUserTopicHandler(h))
+			schedules := auth.Group("/schedules", require(rbac.Publish))
+			{
+				schedules.POST("", handlers.CreateScheduleHandler(h))
+				schedules.GET("", handlers.ListSchedulesHandler(h))
+				schedules.GET("/:id", handlers.GetScheduleHandler(h))
+				schedules.DELETE("/:id", handlers.DeleteScheduleHandler(h))
+				schedules.POST("/:id/pause", handlers.PauseScheduleHandler(h, false))
+				schedules.POST("/:id/resume", handlers.PauseScheduleHandler(h, true))
+				schedules.GET("/:id/runs", handlers.ListScheduleRunsHandler(h))
+			}
+			drafts := auth.Group("/drafts", require(rbac.Publish))
+			{
+				drafts.POST("", handlers.CreateDraftHandler(h))
+				drafts.GET("", handlers.ListDraftsHandler(h))
+				drafts.GET("/:id", handlers.GetDraftHandler(h))
+				drafts.PUT("/:id", handlers.UpdateDraftHandler(h))
+				drafts.DELETE("/:id", handlers.DeleteDraftHandler(h))
+				drafts.GET("/:id/preview", handlers.PreviewDraftHandler(h))
+				drafts.POST("/:id/test", handlers.TestDraftHandler(h))
+				drafts.POST("/:id/publish", handlers.PublishDraftHandler(h))
+			}
+			auth.GET("/stats", require(rbac.ViewStats), handlers.StatsHandler(h))
+
+			// Admin routes
+			admin := auth.Group("/admin")
+
+			// Topic routes; :name limits topic-scoped permissions to that topic
+			topics := admin.Group("/topics")
+			topics.Use(require(rbac.ManageTopics))
+			{
+				topics.GET("", etag, handlers.ListTopicsHandler(h))
+				topics.POST("", handlers.CreateTopicHandler(h))
+				topics.GET("/:name", handlers.GetTopicHandler(h))
+				topics.PATCH("/:name", handlers.UpdateTopicHandler(h))
+				topics.DELETE("/:name", handlers.DeleteTopicHandler(h))
+				topics.GET("/:name/aliases", etag, handlers.ListTopicAliasesHandler(h))
+				topics.DELETE("/:name/aliases/:alias", handlers.DeleteTopicAliasHandler(h))
+				topics.GET("/:name/schema", handlers.GetTopicSchemaHandler(h))
+				topics.PUT("/:name/schema", handlers.SetTopicSchemaHandler(h))
+				topics.DELETE("/:name/schema", handlers.DeleteTopicSchemaHandler(h))
+				topics.GET("/:name/templates", etag, handlers.ListTemplatesHandler(h))
+				topics.PUT("/:name/templates/:template", handlers.SaveTemplateHandler(h))
+				topics.DELETE("/:name/templates/:template", handlers.DeleteTemplateHandler(h))
+				topics.GET("/:name/approval", handlers.GetApprovalThresholdHandler(h))
+				topics.PUT("/:name/approval", handlers.SetApprovalThresholdHandler(h))
+				topics.GET("/:name/messages", etag, handlers.GetMessagesHandler(h))
+				topics.GET("/:name/messages/search", etag, handlers.SearchMessagesHandler(h))
+				topics.DELETE("/:name/messages", handlers.ClearMessagesHandler(h))
+				topics.POST("/:name/messages/:id/resend", handlers.ResendMessageHandler(h))
+				topics.GET("/:name/subscribers", etag, handlers.GetSubscribersHandler(h))
+				topics.GET("/:name/subscribers/export", handlers.ExportSubscribersHandler(h))
+				topics.DELETE("/:name/subscribers", handlers.ClearSubscribersHandler(h))
+				topics.GET("/:name/queue", etag, handlers.GetQueueHandler(h))
+				topics.GET("/:name/queue/:id/attempts", etag, handlers.ListAttemptsHandler(h))
+				topics.DELETE("/:name/queue", handlers.PurgeQueueHandler(h))
+				topics.DELETE("/:name/queue/:id", handlers.CancelQueueItemHandler(h))
+				topics.POST("/:name/queue/:id/requeue", handlers.RequeueQueueItemHandler(h))
+			}
+
+			moderation := admin.Group("/")
+			moderation.Use(require(rbac.Moderate))
+			{
+				moderation.GET("/messages/pending", etag, handlers.ListApprovalsHandler(h))
+				moderation.POST("/messages/:id/approve", handlers.ApproveMessageHandler(h))
+				moderation.POST("/messages/:id/reject", handlers.RejectMessageHandler(h))
+				moderation.GET("/connectors/circuits", handlers.GetCircuitsHandler(h))
+				moderation.DELETE("/queue", handlers.PurgeQueueHandler(h))
+				moderation.GET("/anomalies", handlers.GetAnomaliesHandler(h))
+				moderation.POST("/anomalies/release", handlers.ReleaseAnomalyHandler(h))
+				moderation.PUT("/anomalies/exemptions", handlers.SetAnomalyExemptionHandler(h))
+				moderation.GET("/filters", etag, handlers.ListFilterRulesHandler(h))
+				moderation.POST("/filters", handlers.CreateFilterRuleHandler(h))
+				moderation.DELETE("/filters/:id", handlers.DeleteFilterRuleHandler(h))
+				moderation.GET("/moderation/log", etag, handlers.GetModerationLogHandler(h))
+			}
+
+			users := admin.Group("/")
+			users.Use(require(rbac.ManageUsers))
+			{
+				users.POST("/users", handlers.CreateUserHandler(s))
+				users.POST("/users/bulk", handlers.BulkCreateUsersHandler(s))
+				users.PATCH("/users/:username", handlers.UpdateUserHandler(s))
+				users.DELETE("/users/:username", handlers.DeleteUserHandler(s))
+				users.POST("/users/:username/disable", handlers.DisableUserHandler(s))
+				users.POST("/users/:username/enable", handlers.EnableUserHandler(s))
+				users.DELETE("/users/:username/2fa", handlers.ResetTOTPHandler(s))
+				users.GET("/users/:username/sessions", etag, handlers.ListSessionsHandler(s))
+				users.DELETE("/users/:username/sessions", handlers.RevokeSessionsHandler(s))
+				users.DELETE("/users/:username/sessions/:id", handlers.RevokeSessionHandler(s))
+				users.GET("/users", etag, handlers.ListUsersHandler(s))
+				users.GET("/token", handlers.GetTokenHandler(s))
+				users.GET("/invitations", etag, handlers.ListInvitationsHandler(s))
+				users.POST("/invitations", handlers.CreateInvitationHandler(s))
+				users.DELETE("/invitations/:code", handlers.DeleteInvitationHandler(s))
+			}
+
+			roles := admin.Group("/roles")
+			roles.Use(require(rbac.ManageRoles))
+			{
+				roles.GET("", etag, handlers.ListRolesHandler(s))
+				roles.PUT("/:role", handlers.SaveRoleHandler(s))
+				roles.DELETE("/:role", handlers.DeleteRoleHandler(s))
+			}
+
+			admin.GET("/subscriptions/stale", require(rbac.ManageTopics), etag, handlers.GetStaleSubscriptionsHandler(h))
+			admin.GET("/audit", require(rbac.ViewAudit), etag, handlers.GetAuditLogHandler(h))
+			admin.GET("/stats", require(rbac.ViewStats), handlers.HistoryStatsHandler(h))
+			admin.GET("/stats/latency", require(rbac.ViewStats), handlers.LatencyStatsHandler(h))
+			admin.POST("/reload", require(rbac.All), handlers.ReloadHandler(h, reload))
+			admin.GET("/store/stats", require(rbac.All), handlers.GetStoreStatsHandler(instrumented))
+			admin.GET("/export", require(rbac.All), handlers.ExportHandler(s))
+			admin.POST("/import", require(rbac.All), handlers.ImportHandler(s))
+			if _, ok := backend.(handlers.Backuper); ok {
+				// Through the cache, which a restore empties
+				admin.GET("/backup", require(rbac.All), handlers.BackupHandler(s, s))
+				admin.POST("/restore", require(rbac.All), handlers.RestoreHandler(s, s))
+			}
+			if r, ok := backend.(handlers.StorageReporter); ok {
+				admin.GET("/storage", require(rbac.All), handlers.GetStorageHandler(r))
+			}
+			if cp, ok := backend.(handlers.Compacter); ok {
+				admin.POST("/storage/compact", require(rbac.All), handlers.CompactStorageHandler(cp, s))
+			}
 		}
 	}
 
@@ -173,22 +746,140 @@ func run(cfg Config) (*http.Server, error) {
 		Handler: router,
 	}
 
-	if !cfg.HTTPMode {
-		// Configure TLS 1.3 Strict
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS13,
-			CipherSuites: []uint16{
-				tls.TLS_AES_128_GCM_SHA256,
-				tls.TLS_AES_256_GCM_SHA384,
-				tls.TLS_CHACHA20_POLY1305_SHA256,
-			},
+	// Configure TLS 1.3 Strict, used by every TLS listener
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		CipherSuites: []uint16{
+			tls.TLS_AES_128_GCM_SHA256,
+			tls.TLS_AES_256_GCM_SHA384,
+			tls.TLS_CHACHA20_POLY1305_SHA256,
+		},
+	}
+	if cfg.ClientCA != "" {
+		if err := configureClientAuth(tlsConfig, cfg); err != nil {
+			return nil, err
 		}
-		server.TLSConfig = tlsConfig
+		log.Printf("[mTLS] Verifying client certificates against %s (%s)", cfg.ClientCA, cfg.ClientAuth)
 	}
+	server.TLSConfig = tlsConfig
 
 	return server, nil
 }
 
+// configureClientAuth makes tlsConfig verify client certificates against cfg.ClientCA.
+func configureClientAuth(tlsConfig *tls.Config, cfg Config) error {
+	pem, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", cfg.ClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+
+	switch cfg.ClientAuth {
+	case "", "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("invalid -client-auth %q (want require or optional)", cfg.ClientAuth)
+	}
+	switch cfg.ClientCertIdentity {
+	case "", middleware.IdentityCN, middleware.IdentitySAN:
+	default:
+		return fmt.Errorf("invalid -client-cert-identity %q (want cn or san)", cfg.ClientCertIdentity)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// openStore creates the storage backend selected by cfg.Store.
+func openStore(cfg Config) (store.Store, error) {
+	switch cfg.Store {
+	case "", "sqlite":
+		return store.NewSQLiteStore(dbPath(cfg.DBPath, "no-spam.db"))
+	case "bolt":
+		return store.NewBoltStore(dbPath(cfg.DBPath, "no-spam.bolt"))
+	case "memory":
+		log.Printf("[Store] Using in-memory storage; all data is lost on restart")
+		return store.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend: %s", cfg.Store)
+	}
+}
+
+// cacheChannel carries the store cache drops between cluster nodes.
+const cacheChannel = "no-spam:store-cache"
+
+// shareCacheDrops publishes the store cache drops of this node to the
+// cluster and applies those of the others, so a subscription made on one
+// node reaches the fan-out of all of them at once rather than after the TTL.
+func shareCacheDrops(ctx context.Context, bus cluster.Bus, c *store.CachedStore) error {
+	c.OnInvalidate(func(topic string) {
+		if err := bus.Publish(ctx, cacheChannel, []byte(topic)); err != nil {
+			log.Printf("[Cluster] Failed to publish cache invalidation: %v", err)
+		}
+	})
+	return bus.Subscribe(ctx, cacheChannel, func(data []byte) {
+		c.Invalidate(string(data))
+	})
+}
+
+// startBackups schedules backups to the configured directory and bucket.
+func startBackups(ctx context.Context, s store.Store, cfg Config) error {
+	var targets []backup.Target
+	if cfg.BackupDir != "" {
+		targets = append(targets, backup.DirTarget{Dir: cfg.BackupDir})
+	}
+	if cfg.BackupS3.Endpoint != "" {
+		if cfg.BackupS3.Bucket == "" {
+			return fmt.Errorf("-backup-s3-endpoint requires -backup-s3-bucket")
+		}
+		t, err := backup.NewS3Target(cfg.BackupS3)
+		if err != nil {
+			return fmt.Errorf("backup target: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	src, ok := s.(backup.Source)
+	if !ok {
+		return fmt.Errorf("scheduled backups require -store sqlite")
+	}
+	if cfg.BackupInterval <= 0 {
+		return fmt.Errorf("-backup-interval must be positive")
+	}
+	go backup.Run(ctx, src, targets, cfg.BackupInterval, cfg.BackupKeep)
+	log.Printf("[Backup] Backing up every %s to %d target(s)", cfg.BackupInterval, len(targets))
+	return nil
+}
+
+func dbPath(path, fallback string) string {
+	if path == "" {
+		return fallback
+	}
+	return path
+}
+
+// initialAdminPasswordLength is the length of a generated admin password
+// (about 119 bits of entropy).
+const initialAdminPasswordLength = 20
+
 func setupAdminUser(s store.Store, initialPassword *string) {
 	hasAdmin, err := s.HasAdminUser()
 	if err != nil {
@@ -217,42 +908,50 @@ func setupAdminUser(s store.Store, initialPassword *string) {
 		return
 	}
 
-	// Determine password
-	var password string
-	if initialPassword != nil && *initialPassword != "" {
-		password = *initialPassword
-	} else {
-		// Generate random password
-		const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-		var b [8]byte
-		for i := 0; i < 8; i++ {
-			b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
-			time.Sleep(1 * time.Nanosecond)
+	// A generated password is only shown in the log, so it must be replaced
+	// at the first login. A configured one is assumed to be managed already.
+	adminPassword := ""
+	if initialPassword != nil {
+		adminPassword = *initialPassword
+	}
+	generated := adminPassword == ""
+	if generated {
+		var err error
+		if adminPassword, err = password.Generate(initialAdminPasswordLength); err != nil {
+			log.Printf("[AUTH] Failed to generate admin password: %v", err)
+			return
 		}
-		password = string(b[:])
 	}
 
-	// Hash password
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := password.Hash(adminPassword)
 	if err != nil {
 		log.Printf("[AUTH] Failed to hash password: %v", err)
 		return
 	}
 
-	// Create Admin
-	if err := s.CreateUser("admin", string(hash), "admin"); err != nil {
+	if err := s.CreateUser("admin", hash, "admin"); err != nil {
 		log.Printf("[AUTH] Failed to create admin user: %v", err)
 		return
 	}
+	if generated {
+		if err := s.SetMustChangePassword("admin", true); err != nil {
+			log.Printf("[AUTH] Failed to require a password change for admin: %v", err)
+		}
+	}
 
 	log.Printf("==================================================")
 	log.Printf("[AUTH] Admin user created:")
 	log.Printf("[AUTH] Username: admin")
-	log.Printf("[AUTH] Password: %s", password)
+	if generated {
+		log.Printf("[AUTH] Password: %s", adminPassword)
+		log.Printf("[AUTH] A new password must be set at the first login.")
+	} else {
+		log.Printf("[AUTH] Password: (as configured)")
+	}
 	log.Printf("==================================================")
 }
 
-func generateSelfSignedCert(certPath, keyPath string) error {
+func generateSelfSignedCert(certPath, keyPath string, opts certOptions) error {
 	// ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
 		return err
@@ -261,9 +960,36 @@ func generateSelfSignedCert(certPath, keyPath string) error {
 		return err
 	}
 
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return err
+	var priv crypto.Signer
+	var keyBlock *pem.Block
+	keyUsage := x509.KeyUsageDigitalSignature
+	switch opts.KeyType {
+	case "", "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		priv = key
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		priv = key
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return fmt.Errorf("unsupported key type %q (want rsa or ecdsa)", opts.KeyType)
+	}
+
+	validity := opts.Validity
+	if validity <= 0 {
+		validity = 365 * 24 * time.Hour
 	}
 
 	template := x509.Certificate{
@@ -272,18 +998,25 @@ func generateSelfSignedCert(certPath, keyPath string) error {
 			Organization: []string{"no-spam"},
 		},
 		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(365 * 24 * time.Hour),
+		NotAfter:  time.Now().Add(validity),
 
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
 
-	// Add localhost and IP addresses
+	// Add localhost and IP addresses, plus any configured hosts
 	template.DNSNames = append(template.DNSNames, "localhost")
 	template.IPAddresses = append(template.IPAddresses, net.ParseIP("127.0.0.1"))
+	for _, host := range opts.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
 	if err != nil {
 		return err
 	}
@@ -304,8 +1037,7 @@ func generateSelfSignedCert(certPath, keyPath string) error {
 		return err
 	}
 	defer keyOut.Close()
-	privBytes := x509.MarshalPKCS1PrivateKey(priv)
-	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: privBytes}); err != nil {
+	if err := pem.Encode(keyOut, keyBlock); err != nil {
 		return err
 	}
 
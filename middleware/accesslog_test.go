@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	router := gin.New()
+	router.Use(AccessLog(AccessLogConfig{
+		Logger:  slog.New(slog.NewJSONHandler(&buf, nil)),
+		Sampled: []string{"/v1/send"},
+	}))
+	router.Use(func(c *gin.Context) { c.Set("username", "alice") })
+	router.GET("/v1/unsubscribe", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.DELETE("/v1/invitations/:code", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.POST("/v1/send", func(c *gin.Context) {
+		if c.Query("fail") != "" {
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusAccepted)
+	})
+
+	do := func(method, path string) map[string]any {
+		buf.Reset()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if buf.Len() == 0 {
+			return nil
+		}
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid log entry %q: %v", buf.String(), err)
+		}
+		return entry
+	}
+
+	entry := do("GET", "/v1/unsubscribe?topic=news&token=secret-token&sig=abc")
+	if entry == nil {
+		t.Fatal("Expected the request to be logged")
+	}
+	if entry["method"] != "GET" || entry["status"] != float64(200) || entry["user"] != "alice" || entry["ip"] != "192.0.2.1" || entry["level"] != "INFO" {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+	path, _ := entry["path"].(string)
+	if strings.Contains(path, "secret-token") || strings.Contains(path, "abc") || !strings.Contains(path, "topic=news") {
+		t.Errorf("Expected the token and signature redacted, got path %q", path)
+	}
+
+	entry = do("DELETE", "/v1/invitations/invite-code")
+	if path, _ := entry["path"].(string); path != "/v1/invitations/REDACTED" {
+		t.Errorf("Expected the invitation code redacted, got path %q", path)
+	}
+
+	// A sample rate of 0 drops successful sends, but not failed ones
+	if entry := do("POST", "/v1/send"); entry != nil {
+		t.Errorf("Expected a sampled out request not to be logged, got %v", entry)
+	}
+	if entry := do("POST", "/v1/send?fail=1"); entry == nil || entry["level"] != "WARN" {
+		t.Errorf("Expected a failed request to be logged as a warning, got %v", entry)
+	}
+}
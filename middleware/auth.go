@@ -1,12 +1,19 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"no-spam/apierror"
+	"no-spam/rbac"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -21,18 +28,29 @@ func GetJWTSecret() []byte {
 	return []byte(secret)
 }
 
-// JWTAuthMiddleware verifies the Authorization header (Gin version).
-func JWTAuthMiddleware() gin.HandlerFunc {
+// SessionCheck validates a token's session on every request, e.g. to reject
+// revoked tokens. A returned error is sent to the client with a 401.
+type SessionCheck func(c *gin.Context, claims *Claims) error
+
+// JWTAuthMiddleware verifies the Authorization header (Gin version), then
+// runs checks on the token's claims.
+// Requests already authenticated by ClientCertMiddleware pass through.
+func JWTAuthMiddleware(checks ...SessionCheck) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if GetUsername(c) != "" {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
+			apierror.Abort(c, http.StatusUnauthorized, "Authorization header missing")
 			return
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+			apierror.Abort(c, http.StatusUnauthorized, "Invalid Authorization header format")
 			return
 		}
 
@@ -45,13 +63,23 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 		})
 
 		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			apierror.Abort(c, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
 		if claims, ok := token.Claims.(*Claims); ok {
+			for _, check := range checks {
+				if err := check(c, claims); err != nil {
+					apierror.Abort(c, http.StatusUnauthorized, err.Error())
+					return
+				}
+			}
 			c.Set("role", claims.Role)
 			c.Set("username", claims.Subject)
+			c.Set("token_id", claims.ID)
+			if claims.ExpiresAt != nil {
+				c.Set("token_expires_at", claims.ExpiresAt.Time)
+			}
 		}
 
 		c.Next()
@@ -63,13 +91,53 @@ func RequireRole(role string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userRole, exists := c.Get("role")
 		if !exists || userRole != role {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Forbidden: Only %ss can access this endpoint", role)})
+			apierror.Abort(c, http.StatusForbidden, fmt.Sprintf("Forbidden: Only %ss can access this endpoint", role))
+			return
+		}
+		c.Next()
+	}
+}
+
+// PermissionResolver returns the permissions granted to a role.
+type PermissionResolver func(role string) ([]string, error)
+
+// RequirePermission checks that the user's role grants perm. On routes with
+// a :name topic parameter the permission must cover that topic; elsewhere
+// any scope of it will do and handlers check the topic with Can.
+// Permissions are resolved on every request, so role changes apply
+// without reissuing tokens.
+func RequirePermission(resolve PermissionResolver, perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms, err := resolve(GetRole(c))
+		if err != nil {
+			if errors.Is(err, rbac.ErrRoleNotFound) {
+				apierror.Abort(c, http.StatusForbidden, "Forbidden: unknown role")
+				return
+			}
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to resolve permissions")
+			return
+		}
+		c.Set("permissions", perms)
+
+		allowed := rbac.AllowsAny(perms, perm)
+		if topic := c.Param("name"); topic != "" {
+			allowed = rbac.Allows(perms, perm, topic)
+		}
+		if !allowed {
+			apierror.Abort(c, http.StatusForbidden, fmt.Sprintf("Forbidden: missing %s permission", perm))
 			return
 		}
 		c.Next()
 	}
 }
 
+// Can reports whether the permissions set by RequirePermission grant perm on topic.
+func Can(c *gin.Context, perm, topic string) bool {
+	perms, _ := c.Get("permissions")
+	list, _ := perms.([]string)
+	return rbac.Allows(list, perm, topic)
+}
+
 // GetUsername helper for Gin context
 func GetUsername(c *gin.Context) string {
 	if username, exists := c.Get("username"); exists {
@@ -80,6 +148,18 @@ func GetUsername(c *gin.Context) string {
 	return ""
 }
 
+// GetTokenID returns the ID (jti) of the request's access token, or "" for
+// client certificates and tokens issued without one.
+func GetTokenID(c *gin.Context) string {
+	return c.GetString("token_id")
+}
+
+// GetTokenExpiry returns when the request's access token expires, or the
+// zero time for client certificates and tokens without an expiry.
+func GetTokenExpiry(c *gin.Context) time.Time {
+	return c.GetTime("token_expires_at")
+}
+
 // GetRole helper for Gin context
 func GetRole(c *gin.Context) string {
 	if role, exists := c.Get("role"); exists {
@@ -95,18 +175,106 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// ErrLifetimeTooLong is returned when a requested token lifetime exceeds the policy's cap.
+var ErrLifetimeTooLong = errors.New("token lifetime exceeds the maximum")
+
+// DefaultTokenLifetime is used when the policy doesn't set one.
+const DefaultTokenLifetime = 24 * time.Hour
+
+// TokenPolicy controls how long issued access tokens stay valid.
+type TokenPolicy struct {
+	Lifetime time.Duration            // Default lifetime; DefaultTokenLifetime if zero
+	Roles    map[string]time.Duration // Per-role lifetimes overriding Lifetime
+	Max      time.Duration            // Cap on any lifetime; 0 means no cap
+}
+
+var (
+	policyMu    sync.RWMutex
+	tokenPolicy TokenPolicy
+)
+
+// SetTokenPolicy replaces the policy used by GenerateToken.
+func SetTokenPolicy(p TokenPolicy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	tokenPolicy = p
+}
+
+// TokenLifetime returns the default lifetime of tokens issued to role, capped at the policy's maximum.
+func TokenLifetime(role string) time.Duration {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+
+	lifetime := tokenPolicy.Lifetime
+	if d, ok := tokenPolicy.Roles[role]; ok {
+		lifetime = d
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultTokenLifetime
+	}
+	if tokenPolicy.Max > 0 && lifetime > tokenPolicy.Max {
+		lifetime = tokenPolicy.Max
+	}
+	return lifetime
+}
+
+// GenerateToken issues a token with the role's default lifetime.
 func GenerateToken(username, role string) (string, error) {
+	return GenerateTokenWithLifetime(username, role, 0)
+}
+
+// GenerateTokenWithLifetime issues a token valid for lifetime, or the role's
+// default if lifetime is zero. Lifetimes above the policy's cap are refused.
+func GenerateTokenWithLifetime(username, role string, lifetime time.Duration) (string, error) {
+	t, err := IssueToken(username, role, lifetime)
+	if err != nil {
+		return "", err
+	}
+	return t.Token, nil
+}
+
+// IssuedToken is a signed access token and the claims needed to track it.
+type IssuedToken struct {
+	Token     string
+	ID        string // jti, unique per token
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// IssueToken is GenerateTokenWithLifetime, also returning the token's ID and validity.
+func IssueToken(username, role string, lifetime time.Duration) (*IssuedToken, error) {
+	if lifetime <= 0 {
+		lifetime = TokenLifetime(role)
+	}
+	policyMu.RLock()
+	max := tokenPolicy.Max
+	policyMu.RUnlock()
+	if max > 0 && lifetime > max {
+		return nil, ErrLifetimeTooLong
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().Truncate(time.Second) // JWT times have second precision
+	t := &IssuedToken{ID: hex.EncodeToString(id), IssuedAt: now, ExpiresAt: now.Add(lifetime)}
 	claims := Claims{
 		Role: role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        t.ID,
 			Subject:   username,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(t.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(t.IssuedAt),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(GetJWTSecret())
+	var err error
+	t.Token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(GetJWTSecret())
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
 func ParseToken(tokenString string) (*Claims, error) {
@@ -1,11 +1,15 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"no-spam/rbac"
 
 	"github.com/gin-gonic/gin"
 )
@@ -49,6 +53,35 @@ func TestGenerateAndParseToken(t *testing.T) {
 	}
 }
 
+func TestTokenPolicy(t *testing.T) {
+	SetTokenPolicy(TokenPolicy{
+		Lifetime: time.Hour,
+		Roles:    map[string]time.Duration{"subscriber": 30 * 24 * time.Hour},
+		Max:      7 * 24 * time.Hour,
+	})
+	defer SetTokenPolicy(TokenPolicy{})
+
+	if d := TokenLifetime("admin"); d != time.Hour {
+		t.Errorf("Expected 1h for admin, got %v", d)
+	}
+	if d := TokenLifetime("subscriber"); d != 7*24*time.Hour {
+		t.Errorf("Expected subscriber lifetime capped at 168h, got %v", d)
+	}
+
+	tokenString, err := GenerateTokenWithLifetime("bob", "admin", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithLifetime failed: %v", err)
+	}
+	claims, _ := ParseToken(tokenString)
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != 2*time.Hour {
+		t.Errorf("Expected 2h token, got %v", ttl)
+	}
+
+	if _, err := GenerateTokenWithLifetime("bob", "admin", 8*24*time.Hour); err != ErrLifetimeTooLong {
+		t.Errorf("Expected ErrLifetimeTooLong, got %v", err)
+	}
+}
+
 func TestJWTAuthMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -122,6 +155,50 @@ func TestJWTAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestJWTAuthMiddleware_SessionCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	issued, err := IssueToken("user", "user", 0)
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	if issued.ID == "" {
+		t.Fatal("Expected the token to have an ID")
+	}
+
+	revoked := map[string]bool{}
+	check := func(c *gin.Context, claims *Claims) error {
+		if revoked[claims.ID] {
+			return errors.New("Token revoked")
+		}
+		return nil
+	}
+
+	router := gin.New()
+	router.Use(JWTAuthMiddleware(check))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, GetTokenID(c))
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+issued.Token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusOK || w.Body.String() != issued.ID {
+		t.Fatalf("Expected 200 with the token ID, got %d %q", w.Code, w.Body.String())
+	}
+
+	revoked[issued.ID] = true
+	w := serve()
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Token revoked") {
+		t.Errorf("Expected 401 for a revoked token, got %d %q", w.Code, w.Body.String())
+	}
+}
+
 func TestRequireRole(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -182,6 +259,47 @@ func TestRequireRole(t *testing.T) {
 	}
 }
 
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	resolve := func(role string) ([]string, error) {
+		switch role {
+		case "topic-admin":
+			return []string{"manage_topics:news"}, nil
+		case "admin":
+			return []string{"*"}, nil
+		}
+		return nil, rbac.ErrRoleNotFound
+	}
+
+	router := gin.New()
+	router.Use(JWTAuthMiddleware())
+	router.GET("/topics", RequirePermission(resolve, "manage_topics"), func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+	router.GET("/topics/:name", RequirePermission(resolve, "manage_topics"), func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+	router.GET("/users", RequirePermission(resolve, "manage_users"), func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	tests := []struct {
+		role, path     string
+		expectedStatus int
+	}{
+		{"topic-admin", "/topics", http.StatusOK},
+		{"topic-admin", "/topics/news", http.StatusOK},
+		{"topic-admin", "/topics/billing", http.StatusForbidden},
+		{"topic-admin", "/users", http.StatusForbidden},
+		{"admin", "/users", http.StatusOK},
+		{"ghost", "/topics", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+generateTestToken("u", tt.role))
+		router.ServeHTTP(w, req)
+		if w.Code != tt.expectedStatus {
+			t.Errorf("%s %s: expected %d, got %d", tt.role, tt.path, tt.expectedStatus, w.Code)
+		}
+	}
+}
+
 func TestContextHelpers(t *testing.T) {
 	c, _ := gin.CreateTestContext(httptest.NewRecorder())
 
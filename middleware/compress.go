@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressibleTypes are the media types worth compressing; images, backups
+// and other binary downloads are mostly compressed already.
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// Compress compresses responses with gzip or deflate, as the client
+// accepts, when they have a text or JSON content type and reach minSize
+// bytes. Smaller responses aren't worth the overhead and are sent as they
+// are. minSize <= 0 disables compression.
+func Compress(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if minSize <= 0 || c.Request.Method == http.MethodHead {
+			return
+		}
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, minSize: minSize}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks gzip, then deflate, from an Accept-Encoding
+// header, or "" if the client accepts neither.
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[enc]; ok || !listed && accepted["*"] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// to compress it: once minSize bytes are written, on Flush, or when the
+// handler returns.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+
+	buf     bytes.Buffer
+	decided bool
+	zw      io.WriteCloser // nil if the response isn't compressed
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf.Write(p)
+		if w.buf.Len() < w.minSize {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.zw != nil {
+		return w.zw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written counts a buffered response as written, so handlers don't write
+// another.
+func (w *compressWriter) Written() bool {
+	return w.buf.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// Flush sends what is buffered, compressing a streamed response of a
+// compressible type whatever its size so far.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if f, ok := w.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide chooses whether to compress and writes out the buffer.
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	status := w.Status()
+	if large && !w.ResponseWriter.Written() && h.Get("Content-Encoding") == "" &&
+		status != http.StatusNoContent && status != http.StatusNotModified && status >= http.StatusOK &&
+		compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		if w.encoding == "gzip" {
+			zw := gzipWriters.Get().(*gzip.Writer)
+			zw.Reset(w.ResponseWriter)
+			w.zw = zw
+		} else {
+			zw := flateWriters.Get().(*flate.Writer)
+			zw.Reset(w.ResponseWriter)
+			w.zw = zw
+		}
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.zw != nil {
+		_, err = w.zw.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// close writes out a response smaller than minSize and ends a compressed one.
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+		return
+	}
+	if w.zw == nil {
+		return
+	}
+	w.zw.Close()
+	switch zw := w.zw.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(zw)
+	case *flate.Writer:
+		flateWriters.Put(zw)
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	large := strings.Repeat("spam ", 400)
+	router := gin.New()
+	router.Use(Compress(1024))
+	router.GET("/large", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"text": large})
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"text": "spam"})
+	})
+	router.GET("/binary", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte(large))
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+		io.WriteString(c.Writer, "a,b\n")
+		c.Writer.Flush()
+		io.WriteString(c.Writer, "c,d\n")
+	})
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/large", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected a gzipped response, got headers %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if !strings.Contains(string(body), large) {
+		t.Errorf("Unexpected decompressed body %q", body)
+	}
+
+	w = get("/large", "deflate, gzip;q=0")
+	if w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Expected a deflated response, got headers %v", w.Header())
+	}
+	body, _ = io.ReadAll(flate.NewReader(w.Body))
+	if !strings.Contains(string(body), large) {
+		t.Errorf("Unexpected decompressed body %q", body)
+	}
+
+	for _, tc := range []struct{ path, accept string }{
+		{"/large", ""},
+		{"/large", "br"},
+		{"/small", "gzip"},
+		{"/binary", "gzip"},
+	} {
+		w := get(tc.path, tc.accept)
+		if w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s with %q: expected an uncompressed response", tc.path, tc.accept)
+		}
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s with %q: expected the body, got %d", tc.path, tc.accept, w.Code)
+		}
+	}
+
+	// Streamed responses are compressed from the first flush
+	w = get("/stream", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzipped stream, got headers %v", w.Header())
+	}
+	zr, _ = gzip.NewReader(w.Body)
+	if body, _ = io.ReadAll(zr); string(body) != "a,b\nc,d\n" {
+		t.Errorf("Unexpected decompressed stream %q", body)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                  "",
+		"gzip":              "gzip",
+		"GZIP;q=0.5":        "gzip",
+		"deflate":           "deflate",
+		"br, deflate, gzip": "gzip",
+		"gzip;q=0":          "",
+		"*":                 "gzip",
+		"*, gzip;q=0":       "deflate",
+		"identity":          "",
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// Deprecated marks responses of legacy routes as deprecated (RFC 9745)
+// and links each to the same path under prefix, its successor.
+func Deprecated(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+prefix+c.Request.URL.EscapedPath()+`>; rel="successor-version"`)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	topics := []string{"news"}
+	router := gin.New()
+	router.GET("/topics", ETag(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, topics)
+	})
+	router.GET("/missing", ETag(), func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+	})
+
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/topics", "")
+	tag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || tag == "" || w.Body.String() != `["news"]` {
+		t.Fatalf("Expected a tagged listing, got %d %q %q", w.Code, tag, w.Body.String())
+	}
+
+	w = get("/topics", tag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 without a body, got %d %q", w.Code, w.Body.String())
+	}
+	if w = get("/topics", `"other", `+tag[2:]); w.Code != http.StatusNotModified {
+		t.Errorf("Expected a match among several tags, got %d", w.Code)
+	}
+
+	topics = append(topics, "alerts")
+	w = get("/topics", tag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == tag {
+		t.Errorf("Expected a new tag after a change, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = get("/missing", "")
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" || w.Body.Len() == 0 {
+		t.Errorf("Expected errors untagged, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"no-spam/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxBodySize rejects requests whose body is larger than n bytes. Requests
+// declaring a larger Content-Length get 413 right away; for others, reading
+// past the limit fails with an error recognised by IsBodyTooLarge. Routes
+// listed in exempt (e.g. "/admin/restore") are not limited.
+func MaxBodySize(n int64, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if n <= 0 || slices.Contains(exempt, c.FullPath()) {
+			return
+		}
+		if c.Request.ContentLength > n {
+			apierror.Abort(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", n))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+	}
+}
+
+// IsBodyTooLarge reports whether err came from reading past the MaxBodySize limit.
+func IsBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
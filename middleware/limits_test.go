@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MaxBodySize(8))
+	router.POST("/", func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			if IsBodyTooLarge(err) {
+				c.Status(http.StatusRequestEntityTooLarge)
+				return
+			}
+			c.Status(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	post := func(body string, contentLength int64) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post("small", 5); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if code := post("far too large", 13); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 from Content-Length, got %d", code)
+	}
+	if code := post("far too large", -1); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 while reading, got %d", code)
+	}
+}
+
+func TestMaxBodySizeExempt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MaxBodySize(8, "/restore"))
+	handler := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	router.POST("/restore", handler)
+	router.POST("/send", handler)
+
+	for path, want := range map[string]int{"/restore": http.StatusOK, "/send": http.StatusRequestEntityTooLarge} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader("far too large")))
+		if w.Code != want {
+			t.Errorf("POST %s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"no-spam/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sources of the username in a client certificate.
+const (
+	IdentityCN  = "cn"  // Subject common name
+	IdentitySAN = "san" // First email, DNS or URI subject alternative name
+)
+
+// RoleLookup returns the stored role of username, or "" if there is no such user.
+type RoleLookup func(username string) (string, error)
+
+// CertIdentity returns the username carried by cert according to source.
+func CertIdentity(cert *x509.Certificate, source string) string {
+	if source != IdentitySAN {
+		return cert.Subject.CommonName
+	}
+	switch {
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// ClientCertMiddleware authenticates requests presenting a client
+// certificate verified by the TLS server. The certificate identity must
+// match an existing user, whose stored role is used. Requests without a
+// verified certificate are left to JWTAuthMiddleware.
+func ClientCertMiddleware(lookup RoleLookup, source string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			c.Next()
+			return
+		}
+
+		username := CertIdentity(c.Request.TLS.VerifiedChains[0][0], source)
+		if username == "" {
+			apierror.Abort(c, http.StatusUnauthorized, fmt.Sprintf("Client certificate has no %s identity", source))
+			return
+		}
+		role, err := lookup(username)
+		if err != nil {
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to check user")
+			return
+		}
+		if role == "" {
+			apierror.Abort(c, http.StatusUnauthorized, "Unknown client certificate identity")
+			return
+		}
+
+		c.Set("username", username)
+		c.Set("role", role)
+		c.Next()
+	}
+}
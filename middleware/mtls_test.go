@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCertIdentity(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing-service"},
+		DNSNames: []string{"billing.internal"},
+	}
+	if id := CertIdentity(cert, IdentityCN); id != "billing-service" {
+		t.Errorf("Expected CN identity, got %q", id)
+	}
+	if id := CertIdentity(cert, IdentitySAN); id != "billing.internal" {
+		t.Errorf("Expected DNS SAN identity, got %q", id)
+	}
+	cert.EmailAddresses = []string{"ops@example.com"}
+	if id := CertIdentity(cert, IdentitySAN); id != "ops@example.com" {
+		t.Errorf("Expected email SAN identity, got %q", id)
+	}
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lookup := func(username string) (string, error) {
+		if username == "billing-service" {
+			return "publisher", nil
+		}
+		return "", nil
+	}
+
+	router := gin.New()
+	router.Use(ClientCertMiddleware(lookup, IdentityCN), JWTAuthMiddleware())
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, GetUsername(c)+":"+GetRole(c)) })
+
+	request := func(cn string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cn != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("billing-service")
+	if w.Code != http.StatusOK || w.Body.String() != "billing-service:publisher" {
+		t.Errorf("Expected certificate user, got %d %s", w.Code, w.Body.String())
+	}
+	if w := request("stranger"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for unknown identity, got %d", w.Code)
+	}
+	// Without a certificate the JWT is required
+	if w := request(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without certificate or token, got %d", w.Code)
+	}
+}
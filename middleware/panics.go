@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"no-spam/errtrack"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReportPanics reports the panics of later handlers to error tracking, with
+// the request, its route and user, then panics again for gin.Recovery,
+// registered before it, to respond.
+func ReportPanics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			// A client going away isn't a bug
+			if err, ok := r.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+				tags := map[string]string{"route": c.FullPath()}
+				if user := GetUsername(c); user != "" {
+					tags["user"] = user
+				}
+				if topic := c.Param("name"); topic != "" {
+					tags["topic"] = topic
+				}
+				errtrack.CapturePanic(r, c.Request, tags)
+			}
+			panic(r)
+		}()
+		c.Next()
+	}
+}
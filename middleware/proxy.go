@@ -0,0 +1,22 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// DefaultRemoteIPHeaders are checked, in order, for the client IP on requests from trusted proxies.
+var DefaultRemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// TrustProxies configures how c.ClientIP() resolves the client address.
+// Forwarding headers are only honoured on requests whose remote address is
+// in proxies (IPs or CIDRs); with no proxies, the connection's address is
+// always used so clients can't spoof their IP.
+func TrustProxies(router *gin.Engine, proxies, headers []string) error {
+	if len(headers) == 0 {
+		headers = DefaultRemoteIPHeaders
+	}
+	router.RemoteIPHeaders = headers
+	router.ForwardedByClientIP = len(proxies) > 0
+	if len(proxies) == 0 {
+		return router.SetTrustedProxies(nil)
+	}
+	return router.SetTrustedProxies(proxies)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrustProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	clientIP := func(proxies []string, remoteAddr string) string {
+		router := gin.New()
+		if err := TrustProxies(router, proxies, nil); err != nil {
+			t.Fatal(err)
+		}
+		router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if ip := clientIP(nil, "10.0.0.5:1234"); ip != "10.0.0.5" {
+		t.Errorf("Expected headers ignored without trusted proxies, got %s", ip)
+	}
+	if ip := clientIP([]string{"10.0.0.0/8"}, "10.0.0.5:1234"); ip != "203.0.113.7" {
+		t.Errorf("Expected forwarded IP from trusted proxy, got %s", ip)
+	}
+	if ip := clientIP([]string{"10.0.0.0/8"}, "192.0.2.1:1234"); ip != "192.0.2.1" {
+		t.Errorf("Expected headers ignored from untrusted peer, got %s", ip)
+	}
+
+	if err := TrustProxies(gin.New(), []string{"not-an-ip"}, nil); err == nil {
+		t.Error("Expected error for invalid proxy")
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"no-spam/apierror"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// ipLimiters holds a token bucket per client IP.
+type ipLimiters struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+	pruned   time.Time
+}
+
+// allow takes a token from ip's bucket, dropping the buckets that have
+// refilled now and then so idle clients don't accumulate.
+func (l *ipLimiters) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > time.Minute {
+		for key, lim := range l.limiters {
+			if lim.TokensAt(now) >= float64(l.burst) {
+				delete(l.limiters, key)
+			}
+		}
+		l.pruned = now
+	}
+	lim, ok := l.limiters[ip]
+	if !ok {
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[ip] = lim
+	}
+	return lim.AllowN(now, 1)
+}
+
+// RateLimitByIP allows each client IP perMinute requests a minute, in
+// bursts of up to perMinute, and rejects the others with 429. perMinute <= 0
+// disables the limit.
+func RateLimitByIP(perMinute int) gin.HandlerFunc {
+	if perMinute <= 0 {
+		return func(c *gin.Context) {}
+	}
+	l := &ipLimiters{
+		limit:    rate.Limit(float64(perMinute) / 60),
+		burst:    perMinute,
+		limiters: map[string]*rate.Limiter{},
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(60 / float64(perMinute))))
+	return func(c *gin.Context) {
+		if !l.allow(c.ClientIP(), time.Now()) {
+			c.Header("Retry-After", retryAfter)
+			apierror.Abort(c, http.StatusTooManyRequests, "Too many requests")
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/t/:name/subscribe", RateLimitByIP(2), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/t/news/subscribe", nil)
+		req.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := do("192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d within the burst to pass, got %d", i+1, w.Code)
+		}
+	}
+	w := do("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Errorf("Expected 429 with Retry-After 30 past the burst, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := do("192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("Expected another client to have its own limit, got %d", w.Code)
+	}
+}
+
+func TestIPLimitersPrune(t *testing.T) {
+	l := &ipLimiters{limit: 1, burst: 1, limiters: map[string]*rate.Limiter{}}
+	now := time.Now()
+	l.allow("192.0.2.1", now)
+	if l.allow("192.0.2.1", now) {
+		t.Error("Expected the second request at once to be limited")
+	}
+
+	// Buckets that refilled are dropped, and start full again
+	later := now.Add(2 * time.Minute)
+	l.allow("192.0.2.2", later)
+	if _, ok := l.limiters["192.0.2.1"]; ok {
+		t.Error("Expected the idle client's bucket dropped")
+	}
+	if !l.allow("192.0.2.1", later) {
+		t.Error("Expected the idle client allowed again")
+	}
+}
@@ -0,0 +1,269 @@
+// Package migrate applies ordered, versioned schema migrations to a
+// database/sql database. Applied versions are recorded in a schema_version
+// table, and each migration runs in its own transaction.
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations this binary doesn't know.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// ErrUnknownVersion is returned for a target version with no migration.
+var ErrUnknownVersion = errors.New("unknown schema version")
+
+// Migration is one schema change. Versions are applied in ascending order.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load reads migrations from fsys. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, e.g.
+// 0002_add_index.up.sql. Every migration needs an up file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, label, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version", name)
+		}
+		body, err := fs.ReadFile(fsys, path.Clean(name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d: missing up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration // Sorted by version
+}
+
+// New returns a Migrator for db. migrations must be sorted by version, as returned by Load.
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Latest returns the highest known version, or 0 if there are no migrations.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) init() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// Version returns the highest applied version, or 0 for an empty database.
+func (m *Migrator) Version() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, err
+	}
+	var v sql.NullInt64
+	if err := m.db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+// Status lists every known migration with the time it was applied, if it was.
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		statuses[i] = Status{Migration: mig}
+		if at, ok := applied[mig.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies every migration above the current version, up to and including
+// target. A target of 0 means the latest version.
+func (m *Migrator) Up(target int) error {
+	if target == 0 {
+		target = m.Latest()
+	}
+	if target != 0 && !m.known(target) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+	current, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, m.Latest())
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version <= current || mig.Version > target {
+			continue
+		}
+		if err := m.apply(mig.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO schema_version (version, name) VALUES (?, ?)`, mig.Version, mig.Name)
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %d (%s) up: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down reverts applied migrations, newest first, until the database is at
+// target. A target of 0 reverts everything.
+func (m *Migrator) Down(target int) error {
+	if target != 0 && !m.known(target) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+	current, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, m.Latest())
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version > current || mig.Version <= target {
+			continue
+		}
+		if mig.Down == "" {
+			return fmt.Errorf("migration %d (%s) can't be reverted: no down file", mig.Version, mig.Name)
+		}
+		if err := m.apply(mig.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM schema_version WHERE version = ?`, mig.Version)
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %d (%s) down: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Previous returns the version of the migration before version, or 0 if it is the first.
+func (m *Migrator) Previous(version int) int {
+	prev := 0
+	for _, mig := range m.migrations {
+		if mig.Version >= version {
+			break
+		}
+		prev = mig.Version
+	}
+	return prev
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs script and record in one transaction.
+func (m *Migrator) apply(script string, record func(*sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testMigrations(t *testing.T) []Migration {
+	migrations, err := Load(fstest.MapFS{
+		"0001_widgets.up.sql":   {Data: []byte(`CREATE TABLE widgets (id INTEGER PRIMARY KEY);`)},
+		"0001_widgets.down.sql": {Data: []byte(`DROP TABLE widgets;`)},
+		"0002_color.up.sql":     {Data: []byte(`ALTER TABLE widgets ADD COLUMN color TEXT; CREATE INDEX idx_color ON widgets(color);`)},
+		"0002_color.down.sql":   {Data: []byte(`DROP INDEX idx_color; ALTER TABLE widgets DROP COLUMN color;`)},
+		"0010_gadgets.up.sql":   {Data: []byte(`CREATE TABLE gadgets (id INTEGER PRIMARY KEY);`)},
+		"README.md":             {Data: []byte(`ignored`)},
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return migrations
+}
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func hasColumn(db *sql.DB, table, column string) bool {
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	return n > 0
+}
+
+func TestLoad(t *testing.T) {
+	migrations := testMigrations(t)
+	if len(migrations) != 3 {
+		t.Fatalf("Expected 3 migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[1].Version != 2 || migrations[2].Version != 10 {
+		t.Errorf("Migrations not sorted: %+v", migrations)
+	}
+	if migrations[1].Name != "color" || migrations[2].Down != "" {
+		t.Errorf("Unexpected migration: %+v", migrations[1])
+	}
+
+	if _, err := Load(fstest.MapFS{"0001_x.down.sql": {Data: []byte(`SELECT 1;`)}}); err == nil {
+		t.Error("Expected error for missing up file")
+	}
+	if _, err := Load(fstest.MapFS{"init.up.sql": {Data: []byte(`SELECT 1;`)}}); err == nil {
+		t.Error("Expected error for missing version")
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db := openDB(t)
+	m := New(db, testMigrations(t))
+
+	if v, err := m.Version(); err != nil || v != 0 {
+		t.Fatalf("Expected version 0, got %d (%v)", v, err)
+	}
+
+	if err := m.Up(2); err != nil {
+		t.Fatalf("Up(2) failed: %v", err)
+	}
+	if v, _ := m.Version(); v != 2 || !hasColumn(db, "widgets", "color") {
+		t.Errorf("Expected version 2 with color column, got %d", v)
+	}
+
+	if err := m.Up(0); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	statuses, _ := m.Status()
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("Expected migration %d applied", s.Version)
+		}
+	}
+
+	// 0010 has no down file
+	if err := m.Down(2); err == nil {
+		t.Error("Expected error reverting a migration without down file")
+	}
+	if _, err := db.Exec(`DELETE FROM schema_version WHERE version = 10`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Down(m.Previous(2)); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if v, _ := m.Version(); v != 1 || hasColumn(db, "widgets", "color") {
+		t.Errorf("Expected version 1 without color column, got %d", v)
+	}
+
+	if err := m.Up(3); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+func TestMigrator_FailedMigrationRollsBack(t *testing.T) {
+	db := openDB(t)
+	m := New(db, []Migration{
+		{Version: 1, Name: "ok", Up: `CREATE TABLE a (id INTEGER);`},
+		{Version: 2, Name: "broken", Up: `CREATE TABLE b (id INTEGER); SELECT * FROM missing;`},
+	})
+
+	if err := m.Up(0); err == nil {
+		t.Fatal("Expected error from broken migration")
+	}
+	if v, _ := m.Version(); v != 1 {
+		t.Errorf("Expected version 1 after failure, got %d", v)
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'`).Scan(&n)
+	if n != 0 {
+		t.Error("Expected broken migration to be rolled back")
+	}
+}
+
+func TestMigrator_SchemaTooNew(t *testing.T) {
+	db := openDB(t)
+	if err := New(db, testMigrations(t)).Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	older := New(db, testMigrations(t)[:2])
+	if err := older.Up(0); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package notification
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Interruption levels of iOS notifications, from least to most intrusive.
+const (
+	InterruptionPassive       = "passive"
+	InterruptionActive        = "active"
+	InterruptionTimeSensitive = "time-sensitive"
+	InterruptionCritical      = "critical"
+)
+
+// categoryName is the syntax of category names: short lowercase identifiers
+// like "marketing" or "security-alerts".
+var categoryName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// Category maps a notification category to its counterparts on each
+// platform. Empty fields use the defaults below.
+type Category struct {
+	AndroidChannel    string `json:"android_channel,omitempty"`    // Notification channel ID created by the app; defaults to the category name
+	APNSCategory      string `json:"apns_category,omitempty"`      // aps.category registered by the app; defaults to the category name
+	InterruptionLevel string `json:"interruption_level,omitempty"` // iOS 15+; unset leaves the system default ("active")
+}
+
+// Categories are category mappings keyed by category name.
+type Categories map[string]Category
+
+var (
+	categoriesMu sync.RWMutex
+	categories   Categories
+)
+
+// ValidCategory reports whether name is a valid category name.
+func ValidCategory(name string) bool {
+	return categoryName.MatchString(name)
+}
+
+// Validate checks a category mapping.
+func (c Category) Validate() error {
+	switch c.InterruptionLevel {
+	case "", InterruptionPassive, InterruptionActive, InterruptionTimeSensitive, InterruptionCritical:
+		return nil
+	}
+	return fmt.Errorf("interruption_level must be %s, %s, %s or %s",
+		InterruptionPassive, InterruptionActive, InterruptionTimeSensitive, InterruptionCritical)
+}
+
+// SetCategories replaces the configured category mappings.
+func SetCategories(cs Categories) {
+	categoriesMu.Lock()
+	defer categoriesMu.Unlock()
+	categories = cs
+}
+
+// LookupCategory returns the platform mapping of a category, with the
+// defaults filled in. Categories without a mapping use their name on every
+// platform.
+func LookupCategory(name string) Category {
+	categoriesMu.RLock()
+	c := categories[name]
+	categoriesMu.RUnlock()
+	if c.AndroidChannel == "" {
+		c.AndroidChannel = name
+	}
+	if c.APNSCategory == "" {
+		c.APNSCategory = name
+	}
+	return c
+}
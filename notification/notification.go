@@ -0,0 +1,123 @@
+// Package notification defines the canonical notification structure that
+// publishers can use instead of a provider-specific payload. Connectors render
+// it into their own format (FCM notification, APNS aps, Slack blocks, ...).
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrInvalid is returned when a payload carries a malformed canonical notification.
+var ErrInvalid = errors.New("invalid notification")
+
+// Priority values.
+const (
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// MaxActions is the maximum number of action buttons, as Android shows at
+// most three.
+const MaxActions = 3
+
+// Notification is the provider-independent description of a user-visible notification.
+type Notification struct {
+	Title    string   `json:"title,omitempty"`
+	Body     string   `json:"body,omitempty"`
+	Icon     string   `json:"icon,omitempty"`
+	Image    string   `json:"image,omitempty"`
+	URL      string   `json:"url,omitempty"` // Opened when the notification is tapped
+	Actions  []Action `json:"actions,omitempty"`
+	Sound    string   `json:"sound,omitempty"` // Sound file bundled with the app, or "default"
+	Badge    *int     `json:"badge,omitempty"`
+	Unread   bool     `json:"unread,omitempty"`   // Counts toward the user's unread counter, which becomes the badge
+	Thread   string   `json:"thread,omitempty"`   // Groups notifications on iOS (thread-id)
+	Priority string   `json:"priority,omitempty"` // "normal" (default) or "high"
+	Category string   `json:"category,omitempty"` // e.g. "marketing"; maps to platform channels, and subscribers can mute it
+}
+
+// Action is a button shown with the notification.
+type Action struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url,omitempty"` // Web link or app deep link ("myapp://orders/42") opened by the button
+}
+
+// Payload is a published payload using the canonical structure:
+//
+//	{"notification": {"title": "...", "body": "..."}, "data": {"order_id": "42"}}
+type Payload struct {
+	Notification *Notification     `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+// Parse extracts a canonical notification from a published payload.
+// It returns nil without error when the payload doesn't use the canonical
+// structure, so raw provider-specific payloads keep working.
+func Parse(payload []byte) (*Payload, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, nil
+	}
+	if _, ok := probe["notification"]; !ok {
+		return nil, nil
+	}
+
+	var p Payload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if p.Notification == nil {
+		return nil, fmt.Errorf("%w: notification must be an object", ErrInvalid)
+	}
+	if err := p.Notification.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks the notification for values no provider can render.
+func (n *Notification) Validate() error {
+	if n.Title == "" && n.Body == "" {
+		return fmt.Errorf("%w: title or body is required", ErrInvalid)
+	}
+	switch n.Priority {
+	case "", PriorityNormal, PriorityHigh:
+	default:
+		return fmt.Errorf("%w: priority must be %q or %q", ErrInvalid, PriorityNormal, PriorityHigh)
+	}
+	if n.Category != "" && !ValidCategory(n.Category) {
+		return fmt.Errorf("%w: category must be a lowercase name like \"security-alerts\"", ErrInvalid)
+	}
+	for field, link := range map[string]string{"image": n.Image, "icon": n.Icon} {
+		if u, err := url.Parse(link); link != "" && (err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "") {
+			return fmt.Errorf("%w: %s must be an http or https URL", ErrInvalid, field)
+		}
+	}
+	if n.Badge != nil && *n.Badge < 0 {
+		return fmt.Errorf("%w: badge must not be negative", ErrInvalid)
+	}
+	if n.Badge != nil && n.Unread {
+		return fmt.Errorf("%w: badge and unread are mutually exclusive", ErrInvalid)
+	}
+	if len(n.Actions) > MaxActions {
+		return fmt.Errorf("%w: at most %d actions are allowed", ErrInvalid, MaxActions)
+	}
+	ids := map[string]bool{}
+	for i, a := range n.Actions {
+		if a.ID == "" || a.Title == "" {
+			return fmt.Errorf("%w: action %d needs an id and a title", ErrInvalid, i)
+		}
+		if ids[a.ID] {
+			return fmt.Errorf("%w: duplicate action id %q", ErrInvalid, a.ID)
+		}
+		ids[a.ID] = true
+		if u, err := url.Parse(a.URL); a.URL != "" && (err != nil || u.Scheme == "") {
+			return fmt.Errorf("%w: action %q needs an absolute url or deep link", ErrInvalid, a.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		canonical bool
+		wantErr   bool
+	}{
+		{"Raw payload", `{"content": "hi"}`, false, false},
+		{"Not JSON object", `"hello"`, false, false},
+		{"Canonical", `{"notification": {"title": "Hi", "priority": "high"}, "data": {"k": "v"}}`, true, false},
+		{"Null notification", `{"notification": null}`, false, true},
+		{"Missing title and body", `{"notification": {"icon": "x"}}`, false, true},
+		{"Bad priority", `{"notification": {"title": "Hi", "priority": "urgent"}}`, false, true},
+		{"Category", `{"notification": {"title": "Hi", "category": "security-alerts"}}`, true, false},
+		{"Bad category", `{"notification": {"title": "Hi", "category": "Security Alerts"}}`, false, true},
+		{"Relative image", `{"notification": {"title": "Hi", "image": "/img/x.png"}}`, false, true},
+		{"Data URL icon", `{"notification": {"title": "Hi", "icon": "data:image/png;base64,AAAA"}}`, false, true},
+		{"Negative badge", `{"notification": {"title": "Hi", "badge": -1}}`, false, true},
+		{"Unread", `{"notification": {"title": "Hi", "unread": true, "thread": "chat-7"}}`, true, false},
+		{"Badge and unread", `{"notification": {"title": "Hi", "badge": 1, "unread": true}}`, false, true},
+		{"Action without title", `{"notification": {"title": "Hi", "actions": [{"id": "a"}]}}`, false, true},
+		{"Deep link action", `{"notification": {"title": "Hi", "actions": [{"id": "a", "title": "A", "url": "myapp://orders/42"}]}}`, true, false},
+		{"Relative action url", `{"notification": {"title": "Hi", "actions": [{"id": "a", "title": "A", "url": "/orders/42"}]}}`, false, true},
+		{"Duplicate action", `{"notification": {"title": "Hi", "actions": [{"id": "a", "title": "A"}, {"id": "a", "title": "B"}]}}`, false, true},
+		{"Too many actions", `{"notification": {"title": "Hi", "actions": [{"id": "a", "title": "A"}, {"id": "b", "title": "B"}, {"id": "c", "title": "C"}, {"id": "d", "title": "D"}]}}`, false, true},
+		{"Wrong type", `{"notification": {"title": 5}}`, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.payload))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Expected ErrInvalid, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if (p != nil) != tt.canonical {
+				t.Errorf("Expected canonical=%v, got %+v", tt.canonical, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package password
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const generateCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Generate returns a random password of length characters drawn uniformly
+// from letters and digits with crypto/rand.
+func Generate(length int) (string, error) {
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(generateCharset)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = generateCharset[n.Int64()]
+	}
+	return string(b), nil
+}
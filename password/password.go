@@ -0,0 +1,186 @@
+// Package password hashes and verifies user passwords.
+//
+// Hashes carry their algorithm and parameters, so changing the policy never
+// breaks existing hashes: argon2id hashes use the PHC string format
+// ("$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>") and bcrypt hashes their
+// usual "$2a$" form. NeedsRehash reports hashes made with other settings so
+// they can be upgraded the next time the user logs in.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// Argon2Params tune argon2id. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Policy selects how new hashes are made.
+type Policy struct {
+	Algorithm  string // Argon2id (default) or Bcrypt
+	BcryptCost int    // bcrypt.DefaultCost if zero
+	Argon2     Argon2Params
+}
+
+// DefaultArgon2 follows the OWASP minimum recommendation.
+var DefaultArgon2 = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+// ErrMismatch is returned by Verify for a wrong password.
+var ErrMismatch = errors.New("password does not match")
+
+var (
+	mu     sync.RWMutex
+	policy Policy
+)
+
+// SetPolicy replaces the policy used by Hash and NeedsRehash.
+func SetPolicy(p Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+	policy = p
+}
+
+func current() Policy {
+	mu.RLock()
+	defer mu.RUnlock()
+	return policy.withDefaults()
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.Algorithm == "" {
+		p.Algorithm = Argon2id
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = bcrypt.DefaultCost
+	}
+	if p.Argon2.Memory == 0 {
+		p.Argon2.Memory = DefaultArgon2.Memory
+	}
+	if p.Argon2.Iterations == 0 {
+		p.Argon2.Iterations = DefaultArgon2.Iterations
+	}
+	if p.Argon2.Parallelism == 0 {
+		p.Argon2.Parallelism = DefaultArgon2.Parallelism
+	}
+	return p
+}
+
+// Validate checks that the policy can be used to hash passwords.
+func (p Policy) Validate() error {
+	switch p.Algorithm {
+	case "", Argon2id, Bcrypt:
+	default:
+		return fmt.Errorf("unknown password algorithm %q", p.Algorithm)
+	}
+	if p.BcryptCost != 0 && (p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost) {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if p.Argon2.Memory != 0 && p.Argon2.Memory < 8*uint32(max(p.Argon2.Parallelism, 1)) {
+		return fmt.Errorf("argon2 memory must be at least 8 KiB per thread")
+	}
+	return nil
+}
+
+// Hash hashes password with the current policy.
+func Hash(password string) (string, error) {
+	p := current()
+	if p.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	a := p.Argon2
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks password against a hash made by Hash with any policy. It
+// returns ErrMismatch for a wrong password.
+func Verify(hash, password string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		a, salt, key, err := parseArgon2(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	return err
+}
+
+// NeedsRehash reports whether hash was made with an algorithm or parameters
+// other than the current policy's.
+func NeedsRehash(hash string) bool {
+	p := current()
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if p.Algorithm != Argon2id {
+			return true
+		}
+		a, _, _, err := parseArgon2(hash)
+		return err != nil || a != p.Argon2
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false // Not a password hash (e.g. SSO-only accounts)
+	}
+	return p.Algorithm != Bcrypt || cost != p.BcryptCost
+}
+
+func parseArgon2(hash string) (a Argon2Params, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return a, nil, nil, errors.New("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return a, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Iterations, &a.Parallelism); err != nil {
+		return a, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return a, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return a, nil, nil, errors.New("malformed argon2id key")
+	}
+	return a, salt, key, nil
+}
@@ -0,0 +1,120 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	defer SetPolicy(Policy{})
+
+	for _, p := range []Policy{
+		{},
+		{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 64, Iterations: 1, Parallelism: 2}},
+		{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost},
+	} {
+		SetPolicy(p)
+		hash, err := Hash("s3cret")
+		if err != nil {
+			t.Fatalf("%+v: Hash failed: %v", p, err)
+		}
+		if err := Verify(hash, "s3cret"); err != nil {
+			t.Errorf("%+v: expected password to verify, got %v", p, err)
+		}
+		if err := Verify(hash, "wrong"); !errors.Is(err, ErrMismatch) {
+			t.Errorf("%+v: expected ErrMismatch, got %v", p, err)
+		}
+		if NeedsRehash(hash) {
+			t.Errorf("%+v: a hash made with the current policy doesn't need a rehash", p)
+		}
+	}
+}
+
+func TestDefaultIsArgon2id(t *testing.T) {
+	SetPolicy(Policy{})
+	hash, err := Hash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Unexpected hash %s", hash)
+	}
+	other, _ := Hash("s3cret")
+	if hash == other {
+		t.Error("Expected a random salt per hash")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer SetPolicy(Policy{})
+
+	SetPolicy(Policy{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost})
+	legacy, _ := Hash("s3cret")
+
+	// Switching algorithm: old hashes still verify but should be upgraded
+	SetPolicy(Policy{Argon2: Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}})
+	if err := Verify(legacy, "s3cret"); err != nil {
+		t.Errorf("Expected bcrypt hash to keep verifying, got %v", err)
+	}
+	if !NeedsRehash(legacy) {
+		t.Error("Expected bcrypt hash to need a rehash under argon2id")
+	}
+
+	hash, _ := Hash("s3cret")
+	SetPolicy(Policy{Argon2: Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1}})
+	if !NeedsRehash(hash) {
+		t.Error("Expected a rehash after argon2 parameters change")
+	}
+	if err := Verify(hash, "s3cret"); err != nil {
+		t.Errorf("Expected old parameters to keep verifying, got %v", err)
+	}
+
+	SetPolicy(Policy{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1})
+	if !NeedsRehash(legacy) {
+		t.Error("Expected a rehash after the bcrypt cost changes")
+	}
+
+	if NeedsRehash("!") {
+		t.Error("Placeholder hashes of SSO accounts can't be rehashed")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	for _, hash := range []string{"", "!", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if err := Verify(hash, "s3cret"); err == nil {
+			t.Errorf("Expected %q to fail verification", hash)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, p := range []Policy{{Algorithm: "md5"}, {BcryptCost: 99}, {Argon2: Argon2Params{Memory: 4}}} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", p)
+		}
+	}
+	if err := (Policy{Algorithm: Bcrypt, BcryptCost: 12}).Validate(); err != nil {
+		t.Errorf("Expected valid policy, got %v", err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	a, err := Generate(24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 24 {
+		t.Errorf("Expected 24 characters, got %d", len(a))
+	}
+	for _, r := range a {
+		if !strings.ContainsRune(generateCharset, r) {
+			t.Errorf("Unexpected character %q", r)
+		}
+	}
+	if b, _ := Generate(24); a == b {
+		t.Error("Expected different passwords")
+	}
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryQueue is an in-process Queue backed by a buffered channel.
+// It is useful for single-node deployments and tests.
+type MemoryQueue struct {
+	ch   chan Delivery
+	done chan struct{}
+	once sync.Once
+}
+
+// NewMemoryQueue creates a MemoryQueue that can hold size deliveries before Push blocks.
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{
+		ch:   make(chan Delivery, size),
+		done: make(chan struct{}),
+	}
+}
+
+// Push adds a delivery, blocking while the buffer is full.
+func (q *MemoryQueue) Push(ctx context.Context, d Delivery) error {
+	select {
+	case <-q.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case q.ch <- d:
+		return nil
+	case <-q.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pop returns the next delivery.
+func (q *MemoryQueue) Pop(ctx context.Context) (*Delivery, error) {
+	select {
+	case d := <-q.ch:
+		return &d, nil
+	case <-q.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops the queue. Pending deliveries are dropped; they remain pending in the store.
+func (q *MemoryQueue) Close() error {
+	q.once.Do(func() { close(q.done) })
+	return nil
+}
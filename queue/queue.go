@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"context"
+	"errors"
+
+	"no-spam/store"
+)
+
+// ErrClosed is returned by Push and Pop once the queue has been closed.
+var ErrClosed = errors.New("queue closed")
+
+// Delivery is a single pending push handed from the Hub to a worker.
+// The SQLite queue table remains the system of record; a Delivery only
+// carries enough to attempt the send and mark the row delivered.
+type Delivery struct {
+	QueueID   int64                 `json:"queue_id"`
+	MessageID int64                 `json:"message_id"`
+	Token     string                `json:"token"`
+	Provider  string                `json:"provider"`
+	Payload   []byte                `json:"payload"`
+	Options   *store.WebhookOptions `json:"options,omitempty"`
+}
+
+// Queue defines a transport that pushes deliveries to workers as soon as they
+// are enqueued, instead of waiting for the next poll of the store.
+type Queue interface {
+	// Push adds a delivery to the queue.
+	Push(ctx context.Context, d Delivery) error
+	// Pop blocks until a delivery is available or ctx is done.
+	Pop(ctx context.Context) (*Delivery, error)
+	// Close releases resources held by the queue.
+	Close() error
+}
@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueue_PushPop(t *testing.T) {
+	q := NewMemoryQueue(2)
+	defer q.Close()
+
+	ctx := context.Background()
+	if err := q.Push(ctx, Delivery{QueueID: 1, Token: "t1", Provider: "mock"}); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	d, err := q.Pop(ctx)
+	if err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+	if d.QueueID != 1 || d.Token != "t1" {
+		t.Errorf("Unexpected delivery: %+v", d)
+	}
+}
+
+func TestMemoryQueue_PopContextCancel(t *testing.T) {
+	q := NewMemoryQueue(1)
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := q.Pop(ctx); err == nil {
+		t.Error("Expected error when context is done")
+	}
+}
+
+func TestMemoryQueue_Closed(t *testing.T) {
+	q := NewMemoryQueue(1)
+	q.Close()
+
+	if err := q.Push(context.Background(), Delivery{}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed on Push, got %v", err)
+	}
+	if _, err := q.Pop(context.Background()); err != ErrClosed {
+		t.Errorf("Expected ErrClosed on Pop, got %v", err)
+	}
+}
+
+// TestRedisQueue runs only when REDIS_ADDR points at a disposable Redis server.
+func TestRedisQueue(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+
+	q, err := NewRedisQueue(addr, "", 0, "no-spam:test-queue")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer q.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := q.Push(ctx, Delivery{QueueID: 42, Token: "t", Payload: []byte(`{"a":1}`)}); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	d, err := q.Pop(ctx)
+	if err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+	if d.QueueID != 42 || string(d.Payload) != `{"a":1}` {
+		t.Errorf("Unexpected delivery: %+v", d)
+	}
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultRedisKey is the list used when no key is configured.
+const DefaultRedisKey = "no-spam:queue"
+
+// RedisQueue is a Queue backed by a Redis list (LPUSH / BRPOP).
+// Several no-spam workers can share the same list.
+type RedisQueue struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisQueue connects to the Redis server at addr and verifies the connection.
+func NewRedisQueue(addr, password string, db int, key string) (*RedisQueue, error) {
+	if key == "" {
+		key = DefaultRedisKey
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &RedisQueue{client: client, key: key}, nil
+}
+
+// Push appends the delivery to the Redis list.
+func (q *RedisQueue) Push(ctx context.Context, d Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery: %w", err)
+	}
+	return q.client.LPush(ctx, q.key, data).Err()
+}
+
+// Pop blocks on BRPOP until a delivery is available or ctx is done.
+func (q *RedisQueue) Pop(ctx context.Context) (*Delivery, error) {
+	for {
+		res, err := q.client.BRPop(ctx, time.Second, q.key).Result()
+		if errors.Is(err, redis.Nil) {
+			// Timed out without an item; loop so ctx cancellation is noticed.
+			continue
+		}
+		if errors.Is(err, redis.ErrClosed) {
+			return nil, ErrClosed
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		// res[0] is the key, res[1] the value
+		var d Delivery
+		if err := json.Unmarshal([]byte(res[1]), &d); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal delivery: %w", err)
+		}
+		return &d, nil
+	}
+}
+
+// Close closes the Redis client.
+func (q *RedisQueue) Close() error {
+	return q.client.Close()
+}
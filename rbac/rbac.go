@@ -0,0 +1,129 @@
+// Package rbac defines permissions, built-in roles and how scoped
+// permissions are matched against topics.
+//
+// A permission is a name optionally scoped to topics: "publish" allows
+// publishing to every topic, "publish:alerts" only to alerts and
+// "publish:team-a-*" to topics starting with "team-a-". "*" grants everything.
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"no-spam/store"
+)
+
+// Permissions
+const (
+	All          = "*"
+	ManageUsers  = "manage_users"  // Users and tokens
+	ManageRoles  = "manage_roles"  // Role definitions
+	ManageTopics = "manage_topics" // Topics, schemas, templates, history and subscribers (scopable)
+	Moderate     = "moderate"      // Filters, approvals, anomalies and circuits
+	ViewAudit    = "view_audit"
+	Publish      = "publish"    // Scopable
+	LargeSend    = "large_send" // Publish past the large-send threshold without approval (scopable)
+	Subscribe    = "subscribe"  // Scopable
+	ViewStats    = "view_stats"
+)
+
+var (
+	ErrInvalidPermission = errors.New("invalid permission")
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrBuiltinRole       = errors.New("built-in roles can't be changed")
+)
+
+// known maps each permission to whether it accepts a topic scope.
+var known = map[string]bool{
+	ManageUsers:  false,
+	ManageRoles:  false,
+	ManageTopics: true,
+	Moderate:     false,
+	ViewAudit:    false,
+	Publish:      true,
+	LargeSend:    true,
+	Subscribe:    true,
+	ViewStats:    false,
+}
+
+// Builtin holds the roles that always exist and can't be redefined.
+var Builtin = map[string][]string{
+	"admin":      {All},
+	"publisher":  {Publish, ViewStats},
+	"subscriber": {Subscribe},
+}
+
+// RoleStore looks up custom roles.
+type RoleStore interface {
+	GetRole(name string) (*store.Role, error)
+}
+
+// ValidatePermission checks that p is a known permission with a valid scope.
+func ValidatePermission(p string) error {
+	if p == All {
+		return nil
+	}
+	name, scope, scoped := strings.Cut(p, ":")
+	scopable, ok := known[name]
+	if !ok {
+		return fmt.Errorf("%w: unknown permission %q", ErrInvalidPermission, name)
+	}
+	if !scoped {
+		return nil
+	}
+	if !scopable {
+		return fmt.Errorf("%w: %s can't be scoped to topics", ErrInvalidPermission, name)
+	}
+	if scope == "" || strings.ContainsAny(strings.TrimSuffix(scope, "*"), "*: ") {
+		return fmt.Errorf("%w: invalid scope %q", ErrInvalidPermission, scope)
+	}
+	return nil
+}
+
+// Permissions returns the permissions of a built-in or stored role.
+func Permissions(s RoleStore, role string) ([]string, error) {
+	if perms, ok := Builtin[role]; ok {
+		return perms, nil
+	}
+	r, err := s.GetRole(role)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, ErrRoleNotFound
+	}
+	return r.Permissions, nil
+}
+
+// Allows reports whether perms grant perm on topic. An empty topic requires
+// the unscoped permission.
+func Allows(perms []string, perm, topic string) bool {
+	for _, p := range perms {
+		if p == All || p == perm {
+			return true
+		}
+		name, scope, scoped := strings.Cut(p, ":")
+		if !scoped || name != perm || topic == "" {
+			continue
+		}
+		if prefix, wildcard := strings.CutSuffix(scope, "*"); wildcard {
+			if strings.HasPrefix(topic, prefix) {
+				return true
+			}
+		} else if scope == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAny reports whether perms grant perm on at least some topic.
+func AllowsAny(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == All || p == perm || strings.HasPrefix(p, perm+":") {
+			return true
+		}
+	}
+	return false
+}
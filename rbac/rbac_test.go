@@ -0,0 +1,74 @@
+package rbac
+
+import (
+	"testing"
+
+	"no-spam/store"
+)
+
+func TestAllows(t *testing.T) {
+	perms := []string{"manage_topics:news", "publish:team-a-*", "subscribe"}
+
+	tests := []struct {
+		perm, topic string
+		want        bool
+	}{
+		{ManageTopics, "news", true},
+		{ManageTopics, "newsletter", false},
+		{ManageTopics, "", false}, // Scoped permissions don't cover all topics
+		{Publish, "team-a-alerts", true},
+		{Publish, "team-b-alerts", false},
+		{Subscribe, "anything", true},
+		{Subscribe, "", true},
+		{ManageUsers, "", false},
+	}
+	for _, tt := range tests {
+		if got := Allows(perms, tt.perm, tt.topic); got != tt.want {
+			t.Errorf("Allows(%s, %q) = %v, want %v", tt.perm, tt.topic, got, tt.want)
+		}
+	}
+
+	if !AllowsAny(perms, ManageTopics) || AllowsAny(perms, ManageUsers) {
+		t.Error("AllowsAny mismatch")
+	}
+	if !Allows([]string{All}, ManageUsers, "") {
+		t.Error("Expected * to allow everything")
+	}
+}
+
+func TestValidatePermission(t *testing.T) {
+	for _, p := range []string{"*", "publish", "publish:news", "manage_topics:team-*"} {
+		if err := ValidatePermission(p); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", p, err)
+		}
+	}
+	for _, p := range []string{"fly", "manage_users:news", "publish:", "publish:a*b", "publish:a:b"} {
+		if err := ValidatePermission(p); err == nil {
+			t.Errorf("Expected %q to be invalid", p)
+		}
+	}
+}
+
+type roleStore map[string]store.Role
+
+func (r roleStore) GetRole(name string) (*store.Role, error) {
+	role, ok := r[name]
+	if !ok {
+		return nil, nil
+	}
+	return &role, nil
+}
+
+func TestPermissions(t *testing.T) {
+	s := roleStore{"topic-admin": {Name: "topic-admin", Permissions: []string{"manage_topics:news"}}}
+
+	if perms, _ := Permissions(s, "admin"); len(perms) != 1 || perms[0] != All {
+		t.Errorf("Unexpected admin permissions %v", perms)
+	}
+	if perms, _ := Permissions(s, "topic-admin"); len(perms) != 1 {
+		t.Errorf("Unexpected topic-admin permissions %v", perms)
+	}
+	if _, err := Permissions(s, "ghost"); err != ErrRoleNotFound {
+		t.Errorf("Expected ErrRoleNotFound, got %v", err)
+	}
+}
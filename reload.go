@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"no-spam/config"
+	"no-spam/connectors"
+	"no-spam/errtrack"
+	"no-spam/events"
+	"no-spam/hub"
+	"no-spam/middleware"
+	"no-spam/notification"
+	"no-spam/password"
+)
+
+// errNoConfigFile is returned when reloading a server started without -config.
+var errNoConfigFile = errors.New("no config file to reload; start the server with -config")
+
+// configureConnectors builds the built-in and configured connectors, with
+// their webhook policy, rate limits and circuit breakers, and registers them
+// on h. Nothing is registered if any connector fails to build.
+func configureConnectors(h *hub.Hub, cfg Config, file *config.File) error {
+	set, err := buildConnectors(cfg, file)
+	if err != nil {
+		return err
+	}
+	set.register(h)
+	return nil
+}
+
+// connectorSet is the connectors of a configuration, built but not yet
+// registered.
+type connectorSet struct {
+	policy *connectors.URLPolicy
+	names  []string // In the order they were built
+	built  map[string]connectors.Connector
+	file   *config.File
+}
+
+// buildConnectors builds the connectors of configureConnectors without
+// changing anything.
+func buildConnectors(cfg Config, file *config.File) (*connectorSet, error) {
+	// Webhook destination policy (SSRF protection)
+	var policyCfg connectors.URLPolicyConfig
+	if file != nil && file.WebhookPolicy != nil {
+		policyCfg = *file.WebhookPolicy
+	}
+	if cfg.WebhookAllowPrivate {
+		policyCfg.AllowPrivate = true
+	}
+	policy, err := connectors.NewURLPolicy(policyCfg)
+	if err != nil {
+		return nil, fmt.Errorf("webhook policy: %w", err)
+	}
+
+	var names []string
+	built := map[string]connectors.Connector{}
+	add := func(name string, c connectors.Connector) error {
+		if pc, ok := c.(interface{ SetURLPolicy(*connectors.URLPolicy) }); ok {
+			pc.SetURLPolicy(policy)
+		}
+		if file != nil {
+			if rl, ok := file.RateLimits[name]; ok {
+				limited, err := connectors.NewRateLimiter(c, rl)
+				if err != nil {
+					return fmt.Errorf("rate limit for %s: %w", name, err)
+				}
+				c = limited
+			}
+		}
+		if cfg.BreakerThreshold > 0 {
+			c = connectors.NewCircuitBreaker(name, c, connectors.BreakerConfig{
+				FailureThreshold: cfg.BreakerThreshold,
+				Cooldown:         cfg.BreakerCooldown,
+			})
+		}
+		if _, ok := built[name]; !ok {
+			names = append(names, name)
+		}
+		built[name] = c
+		return nil
+	}
+
+	// Initialize built-in Connectors
+	builtins := []connectors.Config{
+		{Type: "mock"},
+		{Type: "fcm", Settings: map[string]string{"credentials": cfg.FCMCreds}},
+		{Type: "apns"},
+		{Type: "webhook"},
+	}
+	if cfg.FCMTopics {
+		builtins = append(builtins, connectors.Config{Type: "fcm-topic", Settings: map[string]string{"credentials": cfg.FCMCreds}})
+	}
+	for _, cc := range builtins {
+		c, err := connectors.New(cc)
+		if err != nil {
+			log.Printf("[Connectors] Skipping %s: %v", cc.ProviderName(), err)
+			continue
+		}
+		if err := add(cc.ProviderName(), c); err != nil {
+			return nil, err
+		}
+	}
+
+	// Configured Connectors (may override built-ins by name)
+	if file != nil {
+		for _, cc := range file.Connectors {
+			c, err := connectors.New(cc)
+			if err != nil {
+				return nil, fmt.Errorf("connector %s: %w", cc.ProviderName(), err)
+			}
+			if err := add(cc.ProviderName(), c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &connectorSet{policy: policy, names: names, built: built, file: file}, nil
+}
+
+// register registers the connectors on h in place of the ones it has, and
+// applies the webhook policy to the image proxy.
+func (s *connectorSet) register(h *hub.Hub) {
+	if p := h.ImageProxy(); p != nil {
+		p.SetURLPolicy(s.policy)
+	}
+	for _, name := range s.names {
+		h.RegisterConnector(name, s.built[name])
+	}
+	for _, name := range h.ConnectorNames() {
+		if _, ok := s.built[name]; !ok {
+			h.UnregisterConnector(name)
+			log.Printf("[Connectors] Unregistered %s", name)
+		}
+	}
+	if s.file != nil {
+		for _, cc := range s.file.Connectors {
+			log.Printf("[Connectors] Registered %s (type %s)", cc.ProviderName(), cc.Type)
+		}
+	}
+}
+
+// applyTokenPolicy sets the token lifetimes from file, or the defaults.
+func applyTokenPolicy(file *config.File) {
+	var policy middleware.TokenPolicy
+	if file != nil && file.Tokens != nil {
+		policy, _ = file.Tokens.Policy() // Validated by config.Load
+	}
+	middleware.SetTokenPolicy(policy)
+}
+
+// applyPasswordPolicy sets how new password hashes are made from file, or the defaults.
+func applyPasswordPolicy(file *config.File) {
+	var policy password.Policy
+	if file != nil && file.Passwords != nil {
+		policy, _ = file.Passwords.Policy() // Validated by config.Load
+	}
+	password.SetPolicy(policy)
+}
+
+// applyHooks sets the event hooks from file, or none.
+func applyHooks(file *config.File) {
+	var hooks []events.Hook
+	if file != nil {
+		hooks = file.Hooks
+	}
+	events.SetHooks(hooks)
+}
+
+// applyCategories sets the notification category mappings from file, or none.
+func applyCategories(file *config.File) {
+	var cs notification.Categories
+	if file != nil {
+		cs = file.Categories
+	}
+	notification.SetCategories(cs)
+}
+
+// applyLogLevel sets the minimum level of structured logs, such as the
+// access log, from file, or info.
+func applyLogLevel(file *config.File) {
+	level := slog.LevelInfo
+	if file != nil && file.LogLevel != "" {
+		level.UnmarshalText([]byte(file.LogLevel)) // Validated by config.Load
+	}
+	slog.SetLogLoggerLevel(level)
+}
+
+// applyRetention sets after how many days without a delivery subscriptions
+// are pruned, from file or -stale-subscription-days.
+func applyRetention(h *hub.Hub, cfg Config, file *config.File) {
+	days := cfg.StaleSubscriptions
+	if file != nil && file.Retention != nil && file.Retention.StaleSubscriptionDays != nil {
+		days = *file.Retention.StaleSubscriptionDays
+	}
+	h.SetStaleSubscriptionDays(days)
+}
+
+// applyErrorTracking reports errors to the DSN of file, or stops reporting.
+func applyErrorTracking(file *config.File) error {
+	apply, err := prepareErrorTracking(file)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// prepareErrorTracking checks the error tracking of file like
+// applyErrorTracking, which the returned function then does.
+func prepareErrorTracking(file *config.File) (func(), error) {
+	var cfg errtrack.Config
+	if file != nil && file.ErrorTracking != nil {
+		cfg = *file.ErrorTracking
+	}
+	apply, err := errtrack.Prepare(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error_tracking: %w", err)
+	}
+	return func() {
+		apply()
+		if cfg.DSN != "" {
+			log.Printf("[ErrorTracking] Reporting panics and errors (environment %q)", cfg.Environment)
+		}
+	}, nil
+}
+
+// reloadConfig re-reads the config file and applies the settings that can
+// change at runtime: connectors and their settings, rate limits, the
+// webhook policy, token lifetimes, password hashing, event hooks,
+// notification categories, error tracking, the log level and retention.
+// Listeners, OIDC and flags need a restart. Everything is checked before
+// anything is applied, so on error the running configuration is kept.
+func reloadConfig(h *hub.Hub, cfg Config) error {
+	if cfg.ConfigFile == "" {
+		return errNoConfigFile
+	}
+	file, err := config.Load(cfg.ConfigFile)
+	if err != nil {
+		return err
+	}
+	set, err := buildConnectors(cfg, file)
+	if err != nil {
+		return err
+	}
+	startTracking, err := prepareErrorTracking(file)
+	if err != nil {
+		return err
+	}
+	set.register(h)
+	startTracking()
+	applyTokenPolicy(file)
+	applyPasswordPolicy(file)
+	applyHooks(file)
+	applyCategories(file)
+	applyLogLevel(file)
+	applyRetention(h, cfg, file)
+	log.Printf("[Config] Reloaded %s", cfg.ConfigFile)
+	return nil
+}
+
+// reloadOnSIGHUP calls reload whenever the process receives SIGHUP.
+func reloadOnSIGHUP(reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload(); err != nil {
+				log.Printf("[Config] Reload failed: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"no-spam/hub"
+	"no-spam/middleware"
+	"no-spam/store"
+)
+
+func TestReloadConfig(t *testing.T) {
+	s, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hub.NewHub(s)
+	defer middleware.SetTokenPolicy(middleware.TokenPolicy{})
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{ConfigFile: path}
+
+	write(`{"connectors": [{"type": "http", "name": "sms", "settings": {"url": "http://sms-a/send"}}]}`)
+	if err := reloadConfig(h, cfg); err != nil {
+		t.Fatalf("reloadConfig failed: %v", err)
+	}
+	first, ok := h.GetConnector("sms")
+	if !ok {
+		t.Fatal("Expected sms connector after reload")
+	}
+
+	write(`{
+		"connectors": [{"type": "http", "name": "sms", "settings": {"url": "http://sms-b/send"}}],
+		"tokens": {"lifetime": "2h"}
+	}`)
+	if err := reloadConfig(h, cfg); err != nil {
+		t.Fatalf("reloadConfig failed: %v", err)
+	}
+	if second, _ := h.GetConnector("sms"); second == first {
+		t.Error("Expected sms connector to be replaced")
+	}
+	if d := middleware.TokenLifetime("admin"); d != 2*time.Hour {
+		t.Errorf("Expected reloaded token lifetime, got %v", d)
+	}
+
+	// A broken file keeps the running configuration
+	write(`{"connectors": [{"type": "nope", "name": "sms"}]}`)
+	if err := reloadConfig(h, cfg); err == nil {
+		t.Error("Expected error for invalid connector")
+	}
+	if _, ok := h.GetConnector("sms"); !ok {
+		t.Error("Expected sms connector to survive a failed reload")
+	}
+	if d := middleware.TokenLifetime("admin"); d != 2*time.Hour {
+		t.Errorf("Expected token lifetime unchanged, got %v", d)
+	}
+	running, _ := h.GetConnector("sms")
+	write(`{
+		"connectors": [{"type": "http", "name": "sms", "settings": {"url": "http://sms-c/send"}}],
+		"error_tracking": {"dsn": "not a dsn"}
+	}`)
+	if err := reloadConfig(h, cfg); err == nil {
+		t.Error("Expected error for invalid error tracking")
+	}
+	if c, _ := h.GetConnector("sms"); c != running {
+		t.Error("Expected connectors kept when error tracking is invalid")
+	}
+
+	// Connectors removed from the file are unregistered; the log level applies
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+	write(`{"log_level": "warn", "retention": {"stale_subscription_days": 30}}`)
+	if err := reloadConfig(h, cfg); err != nil {
+		t.Fatalf("reloadConfig failed: %v", err)
+	}
+	if _, ok := h.GetConnector("sms"); ok {
+		t.Error("Expected sms connector unregistered once removed from the file")
+	}
+	if _, ok := h.GetConnector("webhook"); !ok {
+		t.Error("Expected built-in webhook connector to stay registered")
+	}
+	if slog.Default().Enabled(context.Background(), slog.LevelInfo) || !slog.Default().Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("Expected structured logs below warn to be dropped")
+	}
+
+	if err := reloadConfig(h, Config{}); !errors.Is(err, errNoConfigFile) {
+		t.Errorf("Expected errNoConfigFile, got %v", err)
+	}
+}
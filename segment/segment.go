@@ -0,0 +1,296 @@
+// Package segment parses and evaluates targeting queries over subscription
+// attributes, e.g.
+//
+//	platform=android AND tag=beta
+//	(locale=fr OR locale=de) AND app_version>=2.4 AND NOT tag=internal
+//
+// Fields: platform, app_version (alias version), tag, locale, provider.
+// Operators: = and != for every field; <, <=, >, >= compare app_version
+// numerically by dotted component. tag=x matches subscriptions carrying tag x;
+// locale=fr also matches regional locales such as fr-ca.
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"no-spam/store"
+)
+
+// ErrInvalid is returned for queries that can't be parsed.
+var ErrInvalid = errors.New("invalid segment")
+
+// Expr is a parsed segment query.
+type Expr interface {
+	Match(sub store.Subscriber) bool
+}
+
+type andExpr struct{ left, right Expr }
+
+func (e andExpr) Match(s store.Subscriber) bool { return e.left.Match(s) && e.right.Match(s) }
+
+type orExpr struct{ left, right Expr }
+
+func (e orExpr) Match(s store.Subscriber) bool { return e.left.Match(s) || e.right.Match(s) }
+
+type notExpr struct{ inner Expr }
+
+func (e notExpr) Match(s store.Subscriber) bool { return !e.inner.Match(s) }
+
+type cond struct {
+	field string
+	op    string
+	value string
+}
+
+func (c cond) Match(s store.Subscriber) bool {
+	var ok bool
+	switch c.field {
+	case "platform":
+		ok = strings.EqualFold(s.Platform, c.value)
+	case "provider":
+		ok = strings.EqualFold(s.Provider, c.value)
+	case "locale":
+		l, v := strings.ToLower(s.Locale), strings.ToLower(strings.ReplaceAll(c.value, "_", "-"))
+		ok = l == v || strings.HasPrefix(l, v+"-")
+	case "tag":
+		for _, t := range s.Tags {
+			if t == c.value {
+				ok = true
+				break
+			}
+		}
+	case "app_version":
+		if c.op != "=" && c.op != "!=" {
+			if s.AppVersion == "" {
+				return false
+			}
+			cmp := compareVersions(s.AppVersion, c.value)
+			switch c.op {
+			case "<":
+				return cmp < 0
+			case "<=":
+				return cmp <= 0
+			case ">":
+				return cmp > 0
+			case ">=":
+				return cmp >= 0
+			}
+		}
+		ok = compareVersions(s.AppVersion, c.value) == 0 && s.AppVersion != ""
+	}
+	if c.op == "!=" {
+		return !ok
+	}
+	return ok
+}
+
+// compareVersions compares dotted versions component by component ("2.10" > "2.9").
+// Non-numeric components compare as strings.
+func compareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y string
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		xn, xerr := strconv.Atoi(defaultZero(x))
+		yn, yerr := strconv.Atoi(defaultZero(y))
+		if xerr == nil && yerr == nil {
+			if xn != yn {
+				if xn < yn {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func defaultZero(s string) string {
+	if s == "" {
+		return "0"
+	}
+	return s
+}
+
+var fields = map[string]string{
+	"platform":    "platform",
+	"provider":    "provider",
+	"locale":      "locale",
+	"tag":         "tag",
+	"tags":        "tag",
+	"app_version": "app_version",
+	"version":     "app_version",
+}
+
+// Parse parses a segment query.
+func Parse(query string) (Expr, error) {
+	toks, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalid)
+	}
+	p := &parser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalid, p.toks[p.pos].text)
+	}
+	return e, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(q string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, token{text: string(c)})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			j := i + 1
+			if j < len(q) && q[j] == '=' {
+				j++
+			}
+			op := q[i:j]
+			if op == "!" {
+				return nil, fmt.Errorf("%w: expected != at offset %d", ErrInvalid, i)
+			}
+			toks = append(toks, token{text: op})
+			i = j
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(q[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalid)
+			}
+			toks = append(toks, token{text: q[i+1 : i+1+j], quoted: true})
+			i += j + 2
+		default:
+			j := i
+			for j < len(q) && !strings.ContainsRune(" \t\n()=!<>\"'", rune(q[j])) {
+				j++
+			}
+			toks = append(toks, token{text: q[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peekKeyword(kw string) bool {
+	return p.pos < len(p.toks) && !p.toks[p.pos].quoted && strings.EqualFold(p.toks[p.pos].text, kw)
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("AND") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("%w: unexpected end of query", ErrInvalid)
+	}
+	if p.peekKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner}, nil
+	}
+	if t := p.toks[p.pos]; !t.quoted && t.text == "(" {
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.toks) || p.toks[p.pos].text != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalid)
+		}
+		p.pos++
+		return e, nil
+	}
+	return p.parseCond()
+}
+
+func (p *parser) parseCond() (Expr, error) {
+	if p.pos+3 > len(p.toks) {
+		return nil, fmt.Errorf("%w: incomplete condition", ErrInvalid)
+	}
+	name, op, value := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+
+	field, ok := fields[strings.ToLower(name.text)]
+	if !ok || name.quoted {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrInvalid, name.text)
+	}
+	switch op.text {
+	case "=", "!=":
+	case "<", "<=", ">", ">=":
+		if field != "app_version" {
+			return nil, fmt.Errorf("%w: %s only supports = and !=", ErrInvalid, name.text)
+		}
+	default:
+		return nil, fmt.Errorf("%w: expected operator after %s", ErrInvalid, name.text)
+	}
+	if value.text == "" || (!value.quoted && strings.ContainsAny(value.text, "()=!<>")) {
+		return nil, fmt.Errorf("%w: missing value for %s", ErrInvalid, name.text)
+	}
+
+	p.pos += 3
+	return cond{field: field, op: op.text, value: value.text}, nil
+}
@@ -0,0 +1,80 @@
+package segment
+
+import (
+	"errors"
+	"testing"
+
+	"no-spam/store"
+)
+
+func TestMatch(t *testing.T) {
+	android := store.Subscriber{Provider: "fcm", Platform: "android", AppVersion: "2.10.1", Locale: "fr-ca", Tags: []string{"beta", "marketing"}}
+	ios := store.Subscriber{Provider: "apns", Platform: "ios", AppVersion: "2.9", Locale: "en", Tags: []string{"internal"}}
+	web := store.Subscriber{Provider: "webhook"}
+
+	tests := []struct {
+		query string
+		want  []bool // android, ios, web
+	}{
+		{"platform=android", []bool{true, false, false}},
+		{"platform = ANDROID AND tag=beta", []bool{true, false, false}},
+		{"platform=android OR platform=ios", []bool{true, true, false}},
+		{"tag!=internal", []bool{true, false, true}},
+		{"NOT tag=internal AND provider!=webhook", []bool{true, false, false}},
+		{"app_version>=2.10", []bool{true, false, false}},
+		{"version<2.10", []bool{false, true, false}},
+		{"app_version=2.9.0", []bool{false, true, false}},
+		{"locale=fr", []bool{true, false, false}},
+		{"locale='fr-CA'", []bool{true, false, false}},
+		{"(platform=ios OR tag=beta) and not locale=en", []bool{true, false, false}},
+		{`tag="marketing"`, []bool{true, false, false}},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("%q: parse failed: %v", tt.query, err)
+		}
+		for i, sub := range []store.Subscriber{android, ios, web} {
+			if got := expr.Match(sub); got != tt.want[i] {
+				t.Errorf("%q on %s: expected %v, got %v", tt.query, sub.Provider, tt.want[i], got)
+			}
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, q := range []string{
+		"",
+		"platform",
+		"platform=",
+		"color=red",
+		"platform>android",
+		"platform=android AND",
+		"(platform=android",
+		"platform=android)",
+		"tag='beta",
+		"platform!android",
+	} {
+		if _, err := Parse(q); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: expected ErrInvalid, got %v", q, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0.0", 0},
+		{"2.10", "2.9", 1},
+		{"v3", "2.99", 1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
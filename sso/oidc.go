@@ -0,0 +1,169 @@
+// Package sso delegates login to an OpenID Connect provider.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNonceMismatch   = errors.New("ID token nonce mismatch")
+	ErrMissingUsername = errors.New("ID token has no username claim")
+	ErrEmailUnverified = errors.New("ID token email is not verified")
+)
+
+// RoleMapping grants Role when the ID token claim Claim equals Value, or
+// contains it when the claim is a list (e.g. groups).
+type RoleMapping struct {
+	Claim string `json:"claim"`
+	Value string `json:"value"`
+	Role  string `json:"role"`
+}
+
+// Config is the "oidc" section of the configuration file.
+type Config struct {
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Defaults to $OIDC_CLIENT_SECRET
+	RedirectURL  string   `json:"redirect_url"`  // Must point at /admin/login/oidc/callback
+	Scopes       []string `json:"scopes"`        // Added to "openid"; default "profile", "email"
+
+	UsernameClaim string        `json:"username_claim"` // Default "email", which must be verified
+	RoleMappings  []RoleMapping `json:"role_mappings"`  // First match wins
+	DefaultRole   string        `json:"default_role"`   // For new users matching no mapping; "" rejects them
+	AutoProvision bool          `json:"auto_provision"` // Create unknown users on first login
+
+	// PostLoginRedirect, if set, receives the token in the URL fragment
+	// (e.g. https://dashboard.example.com/#token=...) instead of a JSON response.
+	PostLoginRedirect string `json:"post_login_redirect"`
+}
+
+// Identity is the verified result of a login.
+type Identity struct {
+	Username   string
+	ExternalID string // Issuer and subject, identifying the account at the provider
+	Role       string // Mapped role, or "" if no mapping matched
+	Claims     map[string]interface{}
+}
+
+// Provider performs the authorization code flow against one issuer.
+type Provider struct {
+	cfg      Config
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewProvider fetches the issuer's discovery document.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client_id and redirect_url are required")
+	}
+	if cfg.ClientSecret == "" {
+		cfg.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "email"
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"profile", "email"}
+	}
+
+	p, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+
+	return &Provider{
+		cfg: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     p.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, cfg.Scopes...),
+		},
+		verifier: p.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// Config returns the provider configuration.
+func (p *Provider) Config() Config {
+	return p.cfg
+}
+
+// AuthURL returns the provider login URL for a state and nonce.
+func (p *Provider) AuthURL(state, nonce string) string {
+	return p.oauth.AuthCodeURL(state, oidc.Nonce(nonce))
+}
+
+// Exchange trades an authorization code for a verified identity.
+func (p *Provider) Exchange(ctx context.Context, code, nonce string) (*Identity, error) {
+	token, err := p.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: code exchange failed: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: invalid claims: %w", err)
+	}
+	return p.identity(idToken.Issuer, idToken.Subject, claims)
+}
+
+// identity returns the identity of verified claims. An email used as the
+// username must be verified by the provider.
+func (p *Provider) identity(issuer, subject string, claims map[string]interface{}) (*Identity, error) {
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+	if username == "" {
+		return nil, ErrMissingUsername
+	}
+	if p.cfg.UsernameClaim == "email" && claims["email_verified"] != true {
+		return nil, ErrEmailUnverified
+	}
+
+	return &Identity{
+		Username:   username,
+		ExternalID: issuer + " " + subject,
+		Role:       MapRole(p.cfg.RoleMappings, claims),
+		Claims:     claims,
+	}, nil
+}
+
+// MapRole returns the role of the first mapping matching claims, or "".
+func MapRole(mappings []RoleMapping, claims map[string]interface{}) string {
+	for _, m := range mappings {
+		switch v := claims[m.Claim].(type) {
+		case string:
+			if v == m.Value {
+				return m.Role
+			}
+		case bool:
+			if fmt.Sprint(v) == m.Value {
+				return m.Role
+			}
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok && s == m.Value {
+					return m.Role
+				}
+			}
+		}
+	}
+	return ""
+}
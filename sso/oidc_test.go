@@ -0,0 +1,26 @@
+package sso
+
+import "testing"
+
+func TestMapRole(t *testing.T) {
+	mappings := []RoleMapping{
+		{Claim: "groups", Value: "no-spam-admins", Role: "admin"},
+		{Claim: "department", Value: "marketing", Role: "publisher"},
+		{Claim: "email_verified", Value: "true", Role: "subscriber"},
+	}
+
+	tests := []struct {
+		claims map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"groups": []interface{}{"staff", "no-spam-admins"}}, "admin"},
+		{map[string]interface{}{"groups": []interface{}{"staff"}, "department": "marketing"}, "publisher"},
+		{map[string]interface{}{"email_verified": true}, "subscriber"},
+		{map[string]interface{}{"department": "sales"}, ""},
+	}
+	for _, tt := range tests {
+		if got := MapRole(mappings, tt.claims); got != tt.want {
+			t.Errorf("MapRole(%v) = %q, want %q", tt.claims, got, tt.want)
+		}
+	}
+}
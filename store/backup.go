@@ -0,0 +1,163 @@
+//go:build cgo
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"no-spam/migrate"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BackupTo writes a consistent copy of the database to path using SQLite's
+// online backup API. Writers aren't blocked while it runs. The copy is a
+// single self-contained file (rollback journal, not WAL).
+func (s *SQLiteStore) BackupTo(path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if err := copyDatabase(dest, s.db); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	_, err = dest.Exec(`PRAGMA journal_mode=DELETE`)
+	return err
+}
+
+// Backup streams a consistent copy of the database to w.
+func (s *SQLiteStore) Backup(w io.Writer) error {
+	f, err := os.CreateTemp("", "no-spam-backup-*.db")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	if err := s.BackupTo(path); err != nil {
+		return err
+	}
+	f, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ErrInvalidBackup is returned by Restore for data that isn't a usable backup.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Restore replaces the contents of the database with the backup read from
+// r, then applies any migrations the backup predates. The backup is checked
+// first, so a corrupt or foreign file leaves the database untouched.
+func (s *SQLiteStore) Restore(r io.Reader) error {
+	f, err := os.CreateTemp("", "no-spam-restore-*.db")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	defer os.Remove(path)
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	src, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := checkBackup(src); err != nil {
+		return err
+	}
+
+	if err := copyDatabase(s.writer, src); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	return s.initSchema()
+}
+
+// checkBackup verifies that db is an intact no-spam database this binary
+// can migrate.
+func checkBackup(db *sql.DB) error {
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, result)
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('topics', 'users')`).Scan(&tables); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if tables != 2 {
+		return fmt.Errorf("%w: not a no-spam database", ErrInvalidBackup)
+	}
+
+	migrations, err := SQLiteMigrations()
+	if err != nil {
+		return err
+	}
+	m := migrate.New(db, migrations)
+	version, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", migrate.ErrSchemaTooNew, version, m.Latest())
+	}
+	return nil
+}
+
+// copyDatabase copies every page of src's main database into dest's in a
+// single step, so the copy is a consistent snapshot.
+func copyDatabase(dest, src *sql.DB) error {
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			destSQLite, ok := d.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", d)
+			}
+			srcSQLite, ok := s.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", s)
+			}
+			b, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package store
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrInvalidBackup is returned by Restore for data that isn't a usable backup.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+var errBackupNoCgo = errors.New("SQLite backups require a binary built with cgo")
+
+func (s *SQLiteStore) BackupTo(path string) error { return errBackupNoCgo }
+func (s *SQLiteStore) Backup(w io.Writer) error   { return errBackupNoCgo }
+func (s *SQLiteStore) Restore(r io.Reader) error  { return errBackupNoCgo }
@@ -0,0 +1,85 @@
+//go:build cgo
+
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteStore_BackupRestore(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	s.CreateTopic("news")
+	s.AddSubscription("news", "tok", "fcm", "alice")
+
+	var buf bytes.Buffer
+	if err := s.Backup(&buf); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("SQLite format 3\x00")) {
+		t.Fatal("Expected an SQLite database file")
+	}
+
+	// Changes after the backup are discarded by the restore
+	s.CreateTopic("sports")
+	s.RemoveSubscription("news", "tok")
+
+	if err := s.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	topics, _ := s.ListTopics()
+	if len(topics) != 1 || topics[0] != "news" {
+		t.Errorf("Expected only the backed up topic, got %v", topics)
+	}
+	subs, _ := s.GetSubscribers("news")
+	if len(subs) != 1 {
+		t.Errorf("Expected the backed up subscription, got %v", subs)
+	}
+
+	// The store stays writable
+	if err := s.CreateTopic("weather"); err != nil {
+		t.Errorf("Write after restore failed: %v", err)
+	}
+}
+
+func TestSQLiteStore_RestoreRejectsInvalidBackup(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	s.CreateTopic("news")
+
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	other, err := OpenSQLiteDB(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Exec(`CREATE TABLE notes (body TEXT); PRAGMA journal_mode=DELETE`)
+	other.Close()
+	foreign, err := os.ReadFile(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"garbage": "definitely not a database",
+		"empty":   "",
+		"foreign": string(foreign),
+	} {
+		if err := s.Restore(strings.NewReader(data)); !errors.Is(err, ErrInvalidBackup) {
+			t.Errorf("%s: expected ErrInvalidBackup, got %v", name, err)
+		}
+	}
+
+	topics, _ := s.ListTopics()
+	if len(topics) != 1 {
+		t.Errorf("A rejected restore must leave the database untouched, got %v", topics)
+	}
+}
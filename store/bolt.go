@@ -0,0 +1,2879 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore is a pure-Go embedded backend using bbolt, for builds without
+// cgo (e.g. cross-compiling to ARM). Records are stored as JSON, one bucket
+// per SQLite table, and behave the same as SQLiteStore.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var (
+	bucketTopics        = []byte("topics")
+	bucketSubscriptions = []byte("subscriptions") // topic \x00 token
+	bucketTemplates     = []byte("templates")     // topic \x00 name \x00 locale
+	bucketFilterRules   = []byte("filter_rules")
+	bucketModeration    = []byte("moderation_log")
+	bucketApprovals     = []byte("approvals") // By message ID
+	bucketAudit         = []byte("audit_log")
+	bucketUsers         = []byte("users")
+	bucketInvitations   = []byte("invitations")
+	bucketRoles         = []byte("roles")
+	bucketMessages      = []byte("messages")
+	bucketQueue         = []byte("queue")
+	bucketPending       = []byte("queue_pending")  // IDs of pending queue items
+	bucketSessions      = []byte("sessions")       // By token ID
+	bucketAttempts      = []byte("queue_attempts") // Queue item ID, then sequence
+	bucketAliases       = []byte("topic_aliases")  // Alias to topic name
+	bucketStats         = []byte("stats")          // Hour in Unix seconds, then metric
+	bucketPayloads      = []byte("payloads")       // Queue item payloads by hash
+	bucketSchedules     = []byte("schedules")
+	bucketScheduleRuns  = []byte("schedule_runs") // Schedule ID, then run ID
+	bucketDrafts        = []byte("drafts")
+)
+
+var boltBuckets = [][]byte{
+	bucketTopics, bucketSubscriptions, bucketTemplates, bucketFilterRules, bucketModeration,
+	bucketApprovals, bucketAudit, bucketUsers, bucketInvitations, bucketRoles,
+	bucketMessages, bucketQueue, bucketPending, bucketSessions, bucketAttempts,
+	bucketAliases, bucketStats, bucketPayloads, bucketSchedules, bucketScheduleRuns,
+	bucketDrafts,
+}
+
+type boltTopic struct {
+	Schema            string    `json:"schema,omitempty"`
+	ApprovalThreshold int       `json:"approval_threshold,omitempty"`
+	Description       string    `json:"description,omitempty"`
+	Owner             string    `json:"owner,omitempty"`
+	CreatedAt         time.Time `json:"created_at,omitzero"`
+	ReplayCount       *int      `json:"replay_count,omitempty"`
+	RetentionDays     int       `json:"retention_days,omitempty"`
+	Public            bool      `json:"public,omitempty"`
+	Seq               int64     `json:"seq,omitempty"`
+
+	MaxAttempts         int `json:"max_attempts,omitempty"`
+	RetryBackoffSeconds int `json:"retry_backoff_seconds,omitempty"`
+	TTLSeconds          int `json:"ttl_seconds,omitempty"`
+}
+
+func (t boltTopic) info(name string) TopicInfo {
+	return TopicInfo{
+		Name:          name,
+		Description:   t.Description,
+		Owner:         t.Owner,
+		CreatedAt:     t.CreatedAt,
+		ReplayCount:   t.ReplayCount,
+		RetentionDays: t.RetentionDays,
+		Public:        t.Public,
+
+		MaxAttempts:         t.MaxAttempts,
+		RetryBackoffSeconds: t.RetryBackoffSeconds,
+		TTLSeconds:          t.TTLSeconds,
+	}
+}
+
+// boltSubscriber stores the username, which Subscriber doesn't serialize.
+type boltSubscriber struct {
+	Subscriber
+	Username string `json:"username"`
+}
+
+type boltUser struct {
+	User
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	TOTPStep      int64    `json:"totp_step,omitempty"` // Of the last accepted TOTP code
+}
+
+// boltModerationEntry and boltApproval keep payloads as bytes, since they
+// aren't guaranteed to be valid JSON.
+type boltModerationEntry struct {
+	ModerationEntry
+	Payload []byte `json:"payload"`
+}
+
+type boltApproval struct {
+	Approval
+	Request []byte `json:"request"`
+}
+
+type boltQueueItem struct {
+	MessageID    int64      `json:"message_id"`
+	Token        string     `json:"token"`
+	Provider     string     `json:"provider,omitempty"` // "" uses the subscription's
+	Status       string     `json:"status"`
+	Payload      []byte     `json:"payload,omitempty"`      // Overrides the message payload; of items queued before PayloadHash
+	PayloadHash  string     `json:"payload_hash,omitempty"` // Key of the overriding payload in bucketPayloads
+	ClaimedBy    string     `json:"claimed_by,omitempty"`
+	ClaimedUntil time.Time  `json:"claimed_until"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+	OpenedAt     *time.Time `json:"opened_at,omitempty"`
+	Attempts     int        `json:"attempts,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// item builds the QueueItem returned to callers, without the payload.
+func (q boltQueueItem) item(id int64, m Message) QueueItem {
+	createdAt := q.CreatedAt
+	if createdAt.IsZero() {
+		// Queued before items had their own timestamp
+		createdAt = m.CreatedAt
+	}
+	return QueueItem{
+		ID:          id,
+		MessageID:   q.MessageID,
+		Topic:       m.Topic,
+		Token:       q.Token,
+		Status:      q.Status,
+		CreatedAt:   createdAt,
+		DeliveredAt: q.DeliveredAt,
+		OpenedAt:    q.OpenedAt,
+		Attempts:    q.Attempts,
+		LastError:   q.LastError,
+	}
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close releases the database file lock.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// compositeKey joins parts with \x00, which sorts before any other byte so
+// keys order by each part in turn.
+func compositeKey(parts ...string) []byte {
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// getJSON decodes the value at key into v, returning false if it doesn't exist.
+func getJSON(b *bolt.Bucket, key []byte, v interface{}) (bool, error) {
+	data := b.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// insertJSON stores v under the bucket's next sequence number, which is
+// passed to setID first.
+func insertJSON(b *bolt.Bucket, v interface{}, setID func(int64)) (int64, error) {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	id := int64(seq)
+	setID(id)
+	return id, putJSON(b, itob(id), v)
+}
+
+// Topics
+func (s *BoltStore) CreateTopic(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketTopics)
+		if b.Get([]byte(name)) != nil {
+			return fmt.Errorf("topic %w: %s", ErrDuplicate, name)
+		}
+		return putJSON(b, []byte(name), boltTopic{CreatedAt: now()})
+	})
+}
+
+func (s *BoltStore) CreateOwnedTopic(name, owner, prefix string, limit int) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketTopics)
+		err := b.ForEach(func(k, v []byte) error {
+			if !strings.HasPrefix(string(k), prefix) {
+				return nil
+			}
+			var t boltTopic
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			if t.Owner == owner {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if count >= limit {
+			return fmt.Errorf("topics of %s: %w", owner, ErrLimitReached)
+		}
+		if b.Get([]byte(name)) != nil {
+			return fmt.Errorf("topic %w: %s", ErrDuplicate, name)
+		}
+		return putJSON(b, []byte(name), boltTopic{Owner: owner, CreatedAt: now()})
+	})
+	return count, err
+}
+
+func (s *BoltStore) GetTopicInfo(name string) (*TopicInfo, error) {
+	var info *TopicInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var t boltTopic
+		ok, err := getJSON(tx.Bucket(bucketTopics), []byte(name), &t)
+		if ok {
+			i := t.info(name)
+			info = &i
+		}
+		return err
+	})
+	return info, err
+}
+
+func (s *BoltStore) ListTopicInfo() ([]TopicInfo, error) {
+	var topics []TopicInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTopics).ForEach(func(k, v []byte) error {
+			var t boltTopic
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			topics = append(topics, t.info(string(k)))
+			return nil
+		})
+	})
+	return topics, err
+}
+
+func (s *BoltStore) SetTopicInfo(info TopicInfo) error {
+	return s.updateTopic(info.Name, func(t *boltTopic) {
+		t.Description, t.Owner = info.Description, info.Owner
+		t.ReplayCount, t.RetentionDays = info.ReplayCount, info.RetentionDays
+		t.Public = info.Public
+		t.MaxAttempts, t.RetryBackoffSeconds, t.TTLSeconds = info.MaxAttempts, info.RetryBackoffSeconds, info.TTLSeconds
+	})
+}
+
+func (s *BoltStore) TopicExists(name string) (bool, error) {
+	var exists bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(bucketTopics).Get([]byte(name)) != nil
+		return nil
+	})
+	return exists, err
+}
+
+func (s *BoltStore) getTopic(name string) (boltTopic, error) {
+	var t boltTopic
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, err := getJSON(tx.Bucket(bucketTopics), []byte(name), &t)
+		return err
+	})
+	return t, err
+}
+
+// updateTopic applies fn to an existing topic.
+func (s *BoltStore) updateTopic(name string, fn func(*boltTopic)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketTopics)
+		var t boltTopic
+		ok, err := getJSON(b, []byte(name), &t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("topic %w: %s", ErrNotFound, name)
+		}
+		fn(&t)
+		return putJSON(b, []byte(name), t)
+	})
+}
+
+func (s *BoltStore) SetTopicSchema(name, schema string) error {
+	return s.updateTopic(name, func(t *boltTopic) { t.Schema = schema })
+}
+
+func (s *BoltStore) GetTopicSchema(name string) (string, error) {
+	t, err := s.getTopic(name)
+	return t.Schema, err
+}
+
+func (s *BoltStore) SetTopicApprovalThreshold(name string, threshold int) error {
+	return s.updateTopic(name, func(t *boltTopic) { t.ApprovalThreshold = threshold })
+}
+
+func (s *BoltStore) GetTopicApprovalThreshold(name string) (int, error) {
+	t, err := s.getTopic(name)
+	return t.ApprovalThreshold, err
+}
+
+func (s *BoltStore) ListTopics() ([]string, error) {
+	var topics []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTopics).ForEach(func(k, _ []byte) error {
+			topics = append(topics, string(k))
+			return nil
+		})
+	})
+	return topics, err
+}
+
+func (s *BoltStore) DeleteTopic(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		msgCount := 0
+		err := tx.Bucket(bucketMessages).ForEach(func(_, v []byte) error {
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if m.Topic == name {
+				msgCount++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check messages: %w", err)
+		}
+		if msgCount > 0 {
+			return fmt.Errorf("cannot delete topic: %w: has %d messages", ErrInUse, msgCount)
+		}
+
+		subCount := 0
+		prefix := compositeKey(name, "")
+		c := tx.Bucket(bucketSubscriptions).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			subCount++
+		}
+		if subCount > 0 {
+			return fmt.Errorf("cannot delete topic: %w: has %d subscribers", ErrInUse, subCount)
+		}
+
+		// Delete topic, its templates, schedules, drafts and aliases
+		if err := deletePrefix(tx.Bucket(bucketTemplates), prefix); err != nil {
+			return err
+		}
+		if err := deleteTopicSchedules(tx, name); err != nil {
+			return err
+		}
+		if err := deleteTopicDrafts(tx, name); err != nil {
+			return err
+		}
+		aliases := tx.Bucket(bucketAliases)
+		var stale [][]byte
+		aliases.ForEach(func(k, v []byte) error {
+			if string(v) == name {
+				stale = append(stale, slices.Clone(k))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := aliases.Delete(k); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketTopics).Delete([]byte(name))
+	})
+}
+
+func (s *BoltStore) RenameTopic(oldName, newName string, alias bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		topics, aliases := tx.Bucket(bucketTopics), tx.Bucket(bucketAliases)
+		t := topics.Get([]byte(oldName))
+		if t == nil {
+			return fmt.Errorf("topic %w: %s", ErrNotFound, oldName)
+		}
+		if topics.Get([]byte(newName)) != nil {
+			return fmt.Errorf("topic %w: %s", ErrDuplicate, newName)
+		}
+		if target := aliases.Get([]byte(newName)); target != nil && string(target) != oldName {
+			return fmt.Errorf("topic %w: %s is an alias of %s", ErrDuplicate, newName, target)
+		}
+
+		if err := topics.Put([]byte(newName), slices.Clone(t)); err != nil {
+			return err
+		}
+		if err := topics.Delete([]byte(oldName)); err != nil {
+			return err
+		}
+		err := moveTopicKeys(tx.Bucket(bucketSubscriptions), oldName, newName, func(sub *boltSubscriber) []byte {
+			sub.Topic = newName
+			return compositeKey(newName, sub.Token)
+		})
+		if err != nil {
+			return err
+		}
+		err = moveTopicKeys(tx.Bucket(bucketTemplates), oldName, newName, func(t *Template) []byte {
+			t.Topic = newName
+			return compositeKey(newName, t.Name, t.Locale)
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteJSON(tx.Bucket(bucketMessages), func(m *Message) bool {
+			if m.Topic != oldName {
+				return false
+			}
+			m.Topic = newName
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteJSON(tx.Bucket(bucketFilterRules), func(r *FilterRule) bool {
+			if r.Topic != oldName {
+				return false
+			}
+			r.Topic = newName
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteJSON(tx.Bucket(bucketApprovals), func(a *boltApproval) bool {
+			if a.Topic != oldName {
+				return false
+			}
+			a.Topic = newName
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteJSON(tx.Bucket(bucketSchedules), func(sc *Schedule) bool {
+			if sc.Topic != oldName {
+				return false
+			}
+			sc.Topic = newName
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		err = rewriteJSON(tx.Bucket(bucketDrafts), func(d *Draft) bool {
+			if d.Topic != oldName {
+				return false
+			}
+			d.Topic = newName
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		var moved [][]byte
+		aliases.ForEach(func(k, v []byte) error {
+			if string(v) == oldName {
+				moved = append(moved, slices.Clone(k))
+			}
+			return nil
+		})
+		for _, k := range moved {
+			if err := aliases.Put(k, []byte(newName)); err != nil {
+				return err
+			}
+		}
+		if err := aliases.Delete([]byte(newName)); err != nil {
+			return err
+		}
+		if alias {
+			return aliases.Put([]byte(oldName), []byte(newName))
+		}
+		return nil
+	})
+}
+
+// moveTopicKeys rewrites the values keyed under topic oldName with fn, which
+// returns their key under newName.
+func moveTopicKeys[T any](b *bolt.Bucket, oldName, newName string, fn func(*T) []byte) error {
+	prefix := compositeKey(oldName, "")
+	var keys [][]byte
+	var vals []T
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var val T
+		if err := json.Unmarshal(v, &val); err != nil {
+			return err
+		}
+		keys, vals = append(keys, slices.Clone(k)), append(vals, val)
+	}
+	for i, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+		if err := putJSON(b, fn(&vals[i]), vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteJSON stores again the values of b that fn changes.
+func rewriteJSON[T any](b *bolt.Bucket, fn func(*T) bool) error {
+	var keys [][]byte
+	var vals []T
+	err := b.ForEach(func(k, v []byte) error {
+		var val T
+		if err := json.Unmarshal(v, &val); err != nil {
+			return err
+		}
+		if fn(&val) {
+			keys, vals = append(keys, slices.Clone(k)), append(vals, val)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if err := putJSON(b, k, vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) ResolveTopicAlias(name string) (string, error) {
+	var topic string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		topic = string(tx.Bucket(bucketAliases).Get([]byte(name)))
+		return nil
+	})
+	return topic, err
+}
+
+func (s *BoltStore) ListTopicAliases() ([]TopicAlias, error) {
+	var aliases []TopicAlias
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAliases).ForEach(func(k, v []byte) error {
+			aliases = append(aliases, TopicAlias{Alias: string(k), Topic: string(v)})
+			return nil
+		})
+	})
+	return aliases, err
+}
+
+func (s *BoltStore) DeleteTopicAlias(alias string) (bool, error) {
+	return s.deleteKey(bucketAliases, []byte(alias))
+}
+
+// deletePrefix removes every key starting with prefix.
+func deletePrefix(b *bolt.Bucket, prefix []byte) error {
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Templates
+func (s *BoltStore) SaveTemplate(t Template) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketTopics).Get([]byte(t.Topic)) == nil {
+			return fmt.Errorf("topic %w: %s", ErrNotFound, t.Topic)
+		}
+		return putJSON(tx.Bucket(bucketTemplates), compositeKey(t.Topic, t.Name, t.Locale), t)
+	})
+}
+
+func (s *BoltStore) GetTemplate(topic, name, locale string) (*Template, error) {
+	var t Template
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ok, err = getJSON(tx.Bucket(bucketTemplates), compositeKey(topic, name, locale), &t)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *BoltStore) ListTemplates(topic string) ([]Template, error) {
+	templates := []Template{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := compositeKey(topic, "")
+		c := tx.Bucket(bucketTemplates).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var t Template
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			templates = append(templates, t)
+		}
+		return nil
+	})
+	return templates, err
+}
+
+func (s *BoltStore) DeleteTemplate(topic, name, locale string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketTemplates)
+		if locale == "*" {
+			return deletePrefix(b, compositeKey(topic, name, ""))
+		}
+		return b.Delete(compositeKey(topic, name, locale))
+	})
+}
+
+// Content filtering
+func (s *BoltStore) CreateFilterRule(r FilterRule) (int64, error) {
+	var id int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		r.CreatedAt = now()
+		var err error
+		id, err = insertJSON(tx.Bucket(bucketFilterRules), &r, func(id int64) { r.ID = id })
+		return err
+	})
+	return id, err
+}
+
+func (s *BoltStore) ListFilterRules() ([]FilterRule, error) {
+	rules := []FilterRule{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketFilterRules).ForEach(func(_, v []byte) error {
+			var r FilterRule
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			rules = append(rules, r)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+// deleteKey removes key, reporting whether it existed.
+func (s *BoltStore) deleteKey(bucket, key []byte) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if found = b.Get(key) != nil; !found {
+			return nil
+		}
+		return b.Delete(key)
+	})
+	return found, err
+}
+
+func (s *BoltStore) DeleteFilterRule(id int64) (bool, error) {
+	return s.deleteKey(bucketFilterRules, itob(id))
+}
+
+func (s *BoltStore) AddModerationEntry(e ModerationEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		e.CreatedAt = now()
+		rec := boltModerationEntry{ModerationEntry: e, Payload: e.Payload}
+		_, err := insertJSON(tx.Bucket(bucketModeration), &rec, func(id int64) { rec.ID = id })
+		return err
+	})
+}
+
+func (s *BoltStore) ListModerationEntries(limit int) ([]ModerationEntry, error) {
+	entries := []ModerationEntry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketModeration).Cursor()
+		for k, v := c.Last(); k != nil && (limit < 0 || len(entries) < limit); k, v = c.Prev() {
+			var rec boltModerationEntry
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			rec.ModerationEntry.Payload = rec.Payload
+			entries = append(entries, rec.ModerationEntry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Approvals
+func (s *BoltStore) HoldMessage(a Approval) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketApprovals)
+		if b.Get(itob(a.MessageID)) != nil {
+			return fmt.Errorf("message %d is already held", a.MessageID)
+		}
+		if tx.Bucket(bucketMessages).Get(itob(a.MessageID)) == nil {
+			return fmt.Errorf("message %w: %d", ErrNotFound, a.MessageID)
+		}
+		a.Status, a.DecidedBy, a.DecidedAt = ApprovalPending, "", nil
+		a.CreatedAt = now()
+		return putJSON(b, itob(a.MessageID), boltApproval{Approval: a, Request: a.Request})
+	})
+}
+
+func decodeApproval(v []byte) (Approval, error) {
+	var rec boltApproval
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return Approval{}, err
+	}
+	rec.Approval.Request = rec.Request
+	return rec.Approval, nil
+}
+
+func (s *BoltStore) ListApprovals(status string) ([]Approval, error) {
+	approvals := []Approval{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketApprovals).ForEach(func(_, v []byte) error {
+			a, err := decodeApproval(v)
+			if err != nil {
+				return err
+			}
+			if status == "" || a.Status == status {
+				approvals = append(approvals, a)
+			}
+			return nil
+		})
+	})
+	return approvals, err
+}
+
+func (s *BoltStore) DecideApproval(messageID int64, status, decidedBy string) (*Approval, error) {
+	var decided *Approval
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketApprovals)
+		v := b.Get(itob(messageID))
+		if v == nil {
+			return nil
+		}
+		a, err := decodeApproval(v)
+		if err != nil || a.Status != ApprovalPending {
+			return err
+		}
+		decidedAt := now()
+		a.Status, a.DecidedBy, a.DecidedAt = status, decidedBy, &decidedAt
+		decided = &a
+		return putJSON(b, itob(messageID), boltApproval{Approval: a, Request: a.Request})
+	})
+	return decided, err
+}
+
+// Schedules
+func (s *BoltStore) CreateSchedule(sc Schedule) (int64, error) {
+	var id int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketTopics).Get([]byte(sc.Topic)) == nil {
+			return fmt.Errorf("topic %w: %s", ErrNotFound, sc.Topic)
+		}
+		sc.CreatedAt, sc.NextRun = now(), sc.NextRun.UTC()
+		var err error
+		id, err = insertJSON(tx.Bucket(bucketSchedules), &sc, func(id int64) { sc.ID = id })
+		return err
+	})
+	return id, err
+}
+
+func (s *BoltStore) GetSchedule(id int64) (*Schedule, error) {
+	var sc Schedule
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ok, err = getJSON(tx.Bucket(bucketSchedules), itob(id), &sc)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// schedulesWhere lists the schedules keep accepts, ordered by ID.
+func (s *BoltStore) schedulesWhere(keep func(Schedule) bool) ([]Schedule, error) {
+	schedules := []Schedule{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSchedules).ForEach(func(_, v []byte) error {
+			var sc Schedule
+			if err := json.Unmarshal(v, &sc); err != nil {
+				return err
+			}
+			if keep(sc) {
+				schedules = append(schedules, sc)
+			}
+			return nil
+		})
+	})
+	return schedules, err
+}
+
+func (s *BoltStore) ListSchedules(topic string) ([]Schedule, error) {
+	return s.schedulesWhere(func(sc Schedule) bool { return topic == "" || sc.Topic == topic })
+}
+
+// updateSchedule applies fn to a stored schedule and saves it if fn returns
+// true. It reports whether it was saved.
+func (s *BoltStore) updateSchedule(id int64, fn func(*Schedule) bool) (bool, error) {
+	var saved bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+		var sc Schedule
+		if ok, err := getJSON(b, itob(id), &sc); err != nil || !ok || !fn(&sc) {
+			return err
+		}
+		saved = true
+		return putJSON(b, itob(id), sc)
+	})
+	return saved, err
+}
+
+func (s *BoltStore) SetSchedulePaused(id int64, paused bool, nextRun time.Time) (bool, error) {
+	return s.updateSchedule(id, func(sc *Schedule) bool {
+		sc.Paused, sc.NextRun = paused, nextRun.UTC()
+		return true
+	})
+}
+
+func (s *BoltStore) DeleteSchedule(id int64) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+		if found = b.Get(itob(id)) != nil; !found {
+			return nil
+		}
+		if err := deletePrefix(tx.Bucket(bucketScheduleRuns), itob(id)); err != nil {
+			return err
+		}
+		return b.Delete(itob(id))
+	})
+	return found, err
+}
+
+// deleteTopicSchedules deletes the schedules of a topic with their runs.
+func deleteTopicSchedules(tx *bolt.Tx, topic string) error {
+	b := tx.Bucket(bucketSchedules)
+	var stale [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var sc Schedule
+		if err := json.Unmarshal(v, &sc); err != nil {
+			return err
+		}
+		if sc.Topic == topic {
+			stale = append(stale, slices.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := deletePrefix(tx.Bucket(bucketScheduleRuns), k); err != nil {
+			return err
+		}
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) DueSchedules(now time.Time) ([]Schedule, error) {
+	due, err := s.schedulesWhere(func(sc Schedule) bool { return !sc.Paused && !sc.NextRun.After(now) })
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(due, func(a, b Schedule) int { return a.NextRun.Compare(b.NextRun) })
+	return due, nil
+}
+
+func (s *BoltStore) AdvanceSchedule(id int64, from, to time.Time) (bool, error) {
+	return s.updateSchedule(id, func(sc *Schedule) bool {
+		if sc.Paused || !sc.NextRun.Equal(from) {
+			return false
+		}
+		sc.NextRun = to.UTC()
+		return true
+	})
+}
+
+func (s *BoltStore) AddScheduleRun(r ScheduleRun) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketSchedules).Get(itob(r.ScheduleID)) == nil {
+			return fmt.Errorf("schedule %w: %d", ErrNotFound, r.ScheduleID)
+		}
+		b := tx.Bucket(bucketScheduleRuns)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		r.ID, r.ScheduledAt, r.RanAt = int64(seq), r.ScheduledAt.UTC(), r.RanAt.UTC()
+		prefix := itob(r.ScheduleID)
+		if err := putJSON(b, append(prefix, itob(r.ID)...), r); err != nil {
+			return err
+		}
+
+		// Drop the oldest runs past the limit
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, slices.Clone(k))
+		}
+		for len(keys) > MaxScheduleRuns {
+			if err := b.Delete(keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) ListScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) {
+	runs := []ScheduleRun{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := itob(scheduleID)
+		c := tx.Bucket(bucketScheduleRuns).Cursor()
+		k, v := c.Seek(itob(scheduleID + 1))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(runs) < limit; k, v = c.Prev() {
+			var r ScheduleRun
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			runs = append(runs, r)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Drafts
+func (s *BoltStore) CreateDraft(d Draft) (int64, error) {
+	var id int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketTopics).Get([]byte(d.Topic)) == nil {
+			return fmt.Errorf("topic %w: %s", ErrNotFound, d.Topic)
+		}
+		d.Status, d.MessageID, d.PublishedAt = DraftOpen, 0, nil
+		d.CreatedAt = now()
+		d.UpdatedAt = d.CreatedAt
+		var err error
+		id, err = insertJSON(tx.Bucket(bucketDrafts), &d, func(id int64) { d.ID = id })
+		return err
+	})
+	return id, err
+}
+
+func (s *BoltStore) GetDraft(id int64) (*Draft, error) {
+	var d Draft
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ok, err = getJSON(tx.Bucket(bucketDrafts), itob(id), &d)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *BoltStore) ListDrafts(topic string) ([]Draft, error) {
+	drafts := []Draft{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDrafts).ForEach(func(_, v []byte) error {
+			var d Draft
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if topic == "" || d.Topic == topic {
+				drafts = append(drafts, d)
+			}
+			return nil
+		})
+	})
+	return drafts, err
+}
+
+// updateDraft applies fn to a stored draft and saves it if fn returns true.
+// It reports whether it was saved.
+func (s *BoltStore) updateDraft(id int64, fn func(*Draft) bool) (bool, error) {
+	var saved bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDrafts)
+		var d Draft
+		if ok, err := getJSON(b, itob(id), &d); err != nil || !ok || !fn(&d) {
+			return err
+		}
+		saved = true
+		return putJSON(b, itob(id), d)
+	})
+	return saved, err
+}
+
+func (s *BoltStore) UpdateDraft(id int64, request json.RawMessage) (bool, error) {
+	return s.updateDraft(id, func(d *Draft) bool {
+		if d.Status != DraftOpen {
+			return false
+		}
+		d.Request, d.UpdatedAt = request, now()
+		return true
+	})
+}
+
+func (s *BoltStore) DeleteDraft(id int64) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDrafts)
+		if found = b.Get(itob(id)) != nil; !found {
+			return nil
+		}
+		return b.Delete(itob(id))
+	})
+	return found, err
+}
+
+func (s *BoltStore) SetDraftStatus(id int64, from, to string, messageID int64) (bool, error) {
+	return s.updateDraft(id, func(d *Draft) bool {
+		if d.Status != from {
+			return false
+		}
+		d.Status, d.MessageID, d.PublishedAt = to, messageID, nil
+		if to == DraftPublished {
+			t := now()
+			d.PublishedAt = &t
+		}
+		return true
+	})
+}
+
+// deleteTopicDrafts deletes the drafts of a topic.
+func deleteTopicDrafts(tx *bolt.Tx, topic string) error {
+	b := tx.Bucket(bucketDrafts)
+	var stale [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var d Draft
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if d.Topic == topic {
+			stale = append(stale, slices.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Audit log
+func (s *BoltStore) AddAuditEvent(e AuditEvent) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		e.CreatedAt = now()
+		if len(e.Details) == 0 {
+			e.Details = nil
+		}
+		_, err := insertJSON(tx.Bucket(bucketAudit), &e, func(id int64) { e.ID = id })
+		return err
+	})
+}
+
+func (s *BoltStore) ListAuditEvents(f AuditFilter) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAudit).Cursor()
+		for k, v := c.Last(); k != nil && len(events) < f.limit(); k, v = c.Prev() {
+			var e AuditEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if f.matches(e) {
+				events = append(events, e)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// Subscriptions
+func (s *BoltStore) AddSubscription(topic, token, provider, username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketTopics).Get([]byte(topic)) == nil {
+			return fmt.Errorf("failed to subscribe: topic %w: %s", ErrNotFound, topic)
+		}
+		b := tx.Bucket(bucketSubscriptions)
+		key := compositeKey(topic, token)
+		if b.Get(key) != nil {
+			return fmt.Errorf("failed to subscribe: %w", ErrDuplicate)
+		}
+		now := time.Now()
+		return putJSON(b, key, boltSubscriber{
+			Subscriber: Subscriber{Topic: topic, Token: token, Provider: provider, CreatedAt: &now},
+			Username:   username,
+		})
+	})
+}
+
+func (s *BoltStore) RemoveSubscription(topic, token string) error {
+	_, err := s.deleteKey(bucketSubscriptions, compositeKey(topic, token))
+	return err
+}
+
+func (s *BoltStore) ClearTopicSubscribers(topic string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deletePrefix(tx.Bucket(bucketSubscriptions), compositeKey(topic, ""))
+	})
+}
+
+func decodeSubscriber(v []byte) (Subscriber, error) {
+	var rec boltSubscriber
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return Subscriber{}, err
+	}
+	rec.Subscriber.Username = rec.Username
+	return rec.Subscriber, nil
+}
+
+// subscribersWhere lists the subscriptions under prefix matching keep.
+func (s *BoltStore) subscribersWhere(prefix []byte, keep func(Subscriber) bool) ([]Subscriber, error) {
+	var subs []Subscriber
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		subs, err = subscribersIn(tx, prefix, keep)
+		return err
+	})
+	return subs, err
+}
+
+func subscribersIn(tx *bolt.Tx, prefix []byte, keep func(Subscriber) bool) ([]Subscriber, error) {
+	var subs []Subscriber
+	c := tx.Bucket(bucketSubscriptions).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		sub, err := decodeSubscriber(v)
+		if err != nil {
+			return nil, err
+		}
+		if keep(sub) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+// disabledUsers returns the names of the disabled users, whose
+// subscriptions are paused.
+func disabledUsers(tx *bolt.Tx) (map[string]bool, error) {
+	disabled := map[string]bool{}
+	err := tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error {
+		var u boltUser
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+		if u.Disabled {
+			disabled[string(k)] = true
+		}
+		return nil
+	})
+	return disabled, err
+}
+
+func (s *BoltStore) GetSubscribers(topic string) ([]Subscriber, error) {
+	var subs []Subscriber
+	err := s.db.View(func(tx *bolt.Tx) error {
+		disabled, err := disabledUsers(tx)
+		if err != nil {
+			return err
+		}
+		subs, err = subscribersIn(tx, compositeKey(topic, ""), func(sub Subscriber) bool { return !disabled[sub.Username] })
+		return err
+	})
+	return subs, err
+}
+
+func (s *BoltStore) ListSubscribersAfter(topic, after string, limit int) ([]Subscriber, error) {
+	prefix := compositeKey(topic, "")
+	var subs []Subscriber
+	err := s.db.View(func(tx *bolt.Tx) error {
+		disabled, err := disabledUsers(tx)
+		if err != nil {
+			return err
+		}
+		c := tx.Bucket(bucketSubscriptions).Cursor()
+		k, v := c.Seek(compositeKey(topic, after))
+		if k != nil && string(k) == string(compositeKey(topic, after)) {
+			k, v = c.Next()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(subs) < limit; k, v = c.Next() {
+			sub, err := decodeSubscriber(v)
+			if err != nil {
+				return err
+			}
+			if !disabled[sub.Username] {
+				subs = append(subs, sub)
+			}
+		}
+		return nil
+	})
+	return subs, err
+}
+
+func (s *BoltStore) GetSubscriptionsByUser(username string) ([]Subscriber, error) {
+	return s.subscribersWhere(nil, func(sub Subscriber) bool { return sub.Username == username })
+}
+
+func (s *BoltStore) GetSubscriptionsByToken(token string) ([]Subscriber, error) {
+	return s.subscribersWhere(nil, func(sub Subscriber) bool { return sub.Token == token })
+}
+
+// updateSubscription applies fn to the subscription inside tx. It reports
+// false, without error, if the subscription doesn't exist.
+func updateSubscription(tx *bolt.Tx, topic, token string, fn func(*Subscriber)) (bool, error) {
+	b := tx.Bucket(bucketSubscriptions)
+	key := compositeKey(topic, token)
+	v := b.Get(key)
+	if v == nil {
+		return false, nil
+	}
+	sub, err := decodeSubscriber(v)
+	if err != nil {
+		return false, err
+	}
+	fn(&sub)
+	return true, putJSON(b, key, boltSubscriber{Subscriber: sub, Username: sub.Username})
+}
+
+func (s *BoltStore) SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := updateSubscription(tx, topic, token, func(sub *Subscriber) {
+			sub.Platform, sub.AppVersion, sub.Tags = platform, appVersion, tags
+		})
+		return err
+	})
+}
+
+func (s *BoltStore) UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error) {
+	var tags []string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		ok, err := updateSubscription(tx, topic, token, func(sub *Subscriber) {
+			tags = mergeTags(sub.Tags, add, remove)
+			sub.Tags = tags
+		})
+		if err == nil && !ok {
+			err = fmt.Errorf("subscription %w", ErrNotFound)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (s *BoltStore) RemoveSubscriptionsByTag(username, tag string) (int64, error) {
+	var removed int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSubscriptions)
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			sub, err := decodeSubscriber(v)
+			if err != nil {
+				return err
+			}
+			if sub.Username == username && slices.Contains(sub.Tags, tag) {
+				keys = append(keys, slices.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = int64(len(keys))
+		return nil
+	})
+	return removed, err
+}
+
+func (s *BoltStore) ReassignSubscriptions(from, to string) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSubscriptions)
+		subs := map[string]Subscriber{}
+		err := b.ForEach(func(k, v []byte) error {
+			sub, err := decodeSubscriber(v)
+			if err != nil {
+				return err
+			}
+			if sub.Username == from {
+				subs[string(k)] = sub
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, sub := range subs {
+			if to == "" {
+				err = b.Delete([]byte(k))
+			} else {
+				err = putJSON(b, []byte(k), boltSubscriber{Subscriber: sub, Username: to})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		n = int64(len(subs))
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) MarkTokensSeen(tokens []string, at time.Time) error {
+	seen := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		seen[token] = true
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		subs, err := subscribersIn(tx, nil, func(sub Subscriber) bool { return seen[sub.Token] })
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if _, err := updateSubscription(tx, sub.Topic, sub.Token, func(sub *Subscriber) { sub.LastSeenAt = &at }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) ListStaleSubscriptions(before time.Time, limit int) ([]Subscriber, error) {
+	subs, err := s.subscribersWhere(nil, func(sub Subscriber) bool { return isStale(sub, before) })
+	if err != nil {
+		return nil, err
+	}
+	subs = staleSubscriptions(subs, before)
+	if len(subs) > limit {
+		subs = subs[:limit]
+	}
+	return subs, nil
+}
+
+func (s *BoltStore) RemoveStaleSubscriptions(before time.Time) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		subs, err := subscribersIn(tx, nil, func(sub Subscriber) bool { return isStale(sub, before) })
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket(bucketSubscriptions)
+		for _, sub := range subs {
+			if err := b.Delete(compositeKey(sub.Topic, sub.Token)); err != nil {
+				return err
+			}
+		}
+		n = int64(len(subs))
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) SetSubscriptionOptions(topic, token string, opts *WebhookOptions) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := updateSubscription(tx, topic, token, func(sub *Subscriber) { sub.Options = opts })
+		return err
+	})
+}
+
+func (s *BoltStore) SetSubscriptionPreferences(topic, token string, prefs *Preferences) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := updateSubscription(tx, topic, token, func(sub *Subscriber) { sub.Preferences = prefs })
+		return err
+	})
+}
+
+func (s *BoltStore) SetSubscriptionLocale(topic, token, locale string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := updateSubscription(tx, topic, token, func(sub *Subscriber) { sub.Locale = locale })
+		return err
+	})
+}
+
+func (s *BoltStore) GetSubscriptionCount() (int, error) {
+	var count int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(bucketSubscriptions).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+func (s *BoltStore) CountSubscriptions(topic, username string) (int, error) {
+	var subs []Subscriber
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		subs, err = subscribersIn(tx, compositeKey(topic, ""), func(sub Subscriber) bool { return sub.Username == username })
+		return err
+	})
+	return len(subs), err
+}
+
+// Users
+func (s *BoltStore) CreateUser(username, passwordHash, role string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return createBoltUser(tx, username, passwordHash, role)
+	})
+}
+
+func createBoltUser(tx *bolt.Tx, username, passwordHash, role string) error {
+	b := tx.Bucket(bucketUsers)
+	if b.Get([]byte(username)) != nil {
+		return fmt.Errorf("user %w: %s", ErrDuplicate, username)
+	}
+	return putJSON(b, []byte(username), boltUser{User: User{Username: username, PasswordHash: passwordHash, Role: role}})
+}
+
+func (s *BoltStore) DeleteUser(username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketUsers)
+		if b.Get([]byte(username)) == nil {
+			return fmt.Errorf("user %w", ErrNotFound)
+		}
+		if err := guardLastAdmin(tx, username); err != nil {
+			return err
+		}
+		return b.Delete([]byte(username))
+	})
+}
+
+// guardLastAdmin returns ErrLastAdmin if username is the last admin who
+// isn't disabled.
+func guardLastAdmin(tx *bolt.Tx, username string) error {
+	var target, others bool
+	err := tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error {
+		var u boltUser
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+		if u.Role == "admin" && !u.Disabled {
+			if string(k) == username {
+				target = true
+			} else {
+				others = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if target && !others {
+		return fmt.Errorf("user %s: %w", username, ErrLastAdmin)
+	}
+	return nil
+}
+
+func (s *BoltStore) ListUsers() ([]User, error) {
+	var users []User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(_, v []byte) error {
+			var u boltUser
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			users = append(users, User{
+				Username: u.Username, PasswordHash: u.PasswordHash, Role: u.Role,
+				Disabled: u.Disabled, DisplayName: u.DisplayName, Email: u.Email,
+				ExternalID: u.ExternalID,
+			})
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *BoltStore) GetUser(username string) (*User, error) {
+	var u boltUser
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ok, err = getJSON(tx.Bucket(bucketUsers), []byte(username), &u)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err // nil if not found
+	}
+	return &u.User, nil
+}
+
+func (s *BoltStore) HasAdminUser() (bool, error) {
+	users, err := s.ListUsers()
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(users, func(u User) bool { return u.Role == "admin" }), nil
+}
+
+// updateUser applies fn to the user. It reports false if the user doesn't exist.
+func (s *BoltStore) updateUser(username string, fn func(*boltUser) bool) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		found, err = updateBoltUser(tx, username, fn)
+		return err
+	})
+	return found, err
+}
+
+func updateBoltUser(tx *bolt.Tx, username string, fn func(*boltUser) bool) (bool, error) {
+	b := tx.Bucket(bucketUsers)
+	var u boltUser
+	ok, err := getJSON(b, []byte(username), &u)
+	if err != nil || !ok {
+		return false, err
+	}
+	if !fn(&u) {
+		return true, nil
+	}
+	return true, putJSON(b, []byte(username), u)
+}
+
+func (s *BoltStore) UpdateUserRole(username, role string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if role != "admin" {
+			if err := guardLastAdmin(tx, username); err != nil {
+				return err
+			}
+		}
+		_, err := updateBoltUser(tx, username, func(u *boltUser) bool {
+			u.Role = role
+			return true
+		})
+		return err
+	})
+}
+
+func (s *BoltStore) SetUserPassword(username, passwordHash string) error {
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		u.PasswordHash = passwordHash
+		return true
+	})
+	if err == nil && !found {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return err
+}
+
+func (s *BoltStore) SetMustChangePassword(username string, required bool) error {
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		u.MustChangePassword = required
+		return true
+	})
+	if err == nil && !found {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return err
+}
+
+func (s *BoltStore) SetUserDisabled(username string, disabled bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if disabled {
+			if err := guardLastAdmin(tx, username); err != nil {
+				return err
+			}
+		}
+		found, err := updateBoltUser(tx, username, func(u *boltUser) bool {
+			u.Disabled = disabled
+			return true
+		})
+		if err == nil && !found {
+			return fmt.Errorf("user %w: %s", ErrNotFound, username)
+		}
+		return err
+	})
+}
+
+func (s *BoltStore) SetUserAccess(username, role string, disabled bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if role != "admin" || disabled {
+			if err := guardLastAdmin(tx, username); err != nil {
+				return err
+			}
+		}
+		found, err := updateBoltUser(tx, username, func(u *boltUser) bool {
+			u.Role, u.Disabled = role, disabled
+			return true
+		})
+		if err == nil && !found {
+			return fmt.Errorf("user %w: %s", ErrNotFound, username)
+		}
+		return err
+	})
+}
+
+func (s *BoltStore) SetUserProfile(username, displayName, email string) error {
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		u.DisplayName, u.Email = displayName, email
+		return true
+	})
+	if err == nil && !found {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return err
+}
+
+func (s *BoltStore) LinkUser(username, externalID string) (bool, error) {
+	linked := false
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		if u.ExternalID != "" {
+			return false
+		}
+		u.ExternalID, linked = externalID, true
+		return true
+	})
+	if err == nil && !found {
+		return false, fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return linked, err
+}
+
+func (s *BoltStore) IncrementUnread(usernames []string) (map[string]int, error) {
+	counts := make(map[string]int, len(usernames))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, username := range usernames {
+			_, err := updateBoltUser(tx, username, func(u *boltUser) bool {
+				u.Unread++
+				counts[username] = u.Unread
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (s *BoltStore) SetUnread(username string, count int) error {
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		u.Unread = count
+		return true
+	})
+	if err == nil && !found {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return err
+}
+
+func (s *BoltStore) SetUserTOTP(username, secret string, enabled bool) error {
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		u.TOTPSecret, u.TOTPEnabled = secret, enabled && secret != ""
+		if secret == "" {
+			u.RecoveryCodes = nil
+		}
+		if !u.TOTPEnabled {
+			u.TOTPStep, u.TOTPFailures = 0, 0
+		}
+		return true
+	})
+	if err == nil && !found {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return err
+}
+
+func (s *BoltStore) SetRecoveryCodes(username string, hashes []string) error {
+	_, err := s.updateUser(username, func(u *boltUser) bool {
+		u.RecoveryCodes = hashes
+		return true
+	})
+	return err
+}
+
+func (s *BoltStore) UseRecoveryCode(username, hash string) (bool, error) {
+	var used bool
+	_, err := s.updateUser(username, func(u *boltUser) bool {
+		i := slices.Index(u.RecoveryCodes, hash)
+		if i < 0 {
+			return false
+		}
+		u.RecoveryCodes = slices.Delete(u.RecoveryCodes, i, i+1)
+		u.TOTPFailures = 0
+		used = true
+		return true
+	})
+	return used, err
+}
+
+func (s *BoltStore) UseTOTPStep(username string, step int64) (bool, error) {
+	var used bool
+	_, err := s.updateUser(username, func(u *boltUser) bool {
+		if step <= u.TOTPStep {
+			return false
+		}
+		u.TOTPStep, u.TOTPFailures = step, 0
+		used = true
+		return true
+	})
+	return used, err
+}
+
+func (s *BoltStore) AddTOTPFailure(username string) (int, error) {
+	var failures int
+	found, err := s.updateUser(username, func(u *boltUser) bool {
+		u.TOTPFailures++
+		u.TOTPFailedAt = now()
+		failures = u.TOTPFailures
+		return true
+	})
+	if err == nil && !found {
+		return 0, fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return failures, err
+}
+
+// Invitations
+func (s *BoltStore) CreateInvitation(inv Invitation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketInvitations)
+		if b.Get([]byte(inv.Code)) != nil {
+			return fmt.Errorf("invitation %w: %s", ErrDuplicate, inv.Code)
+		}
+		if inv.ExpiresAt != nil {
+			expires := inv.ExpiresAt.UTC()
+			inv.ExpiresAt = &expires
+		}
+		inv.Uses, inv.CreatedAt = 0, now()
+		return putJSON(b, []byte(inv.Code), inv)
+	})
+}
+
+func (s *BoltStore) ListInvitations() ([]Invitation, error) {
+	invitations := []Invitation{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketInvitations).ForEach(func(_, v []byte) error {
+			var inv Invitation
+			if err := json.Unmarshal(v, &inv); err != nil {
+				return err
+			}
+			invitations = append(invitations, inv)
+			return nil
+		})
+	})
+	// Keys are codes, so a stable sort keeps codes ordered within a second.
+	sort.SliceStable(invitations, func(i, j int) bool {
+		return invitations[i].CreatedAt.Before(invitations[j].CreatedAt)
+	})
+	return invitations, err
+}
+
+func (s *BoltStore) DeleteInvitation(code string) (bool, error) {
+	return s.deleteKey(bucketInvitations, []byte(code))
+}
+
+func (s *BoltStore) RedeemInvitation(code, username, passwordHash string) (*Invitation, error) {
+	var redeemed *Invitation
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketInvitations)
+		var inv Invitation
+		ok, err := getJSON(b, []byte(code), &inv)
+		if err != nil || !ok {
+			return err
+		}
+		if inv.Uses >= inv.MaxUses || (inv.ExpiresAt != nil && !inv.ExpiresAt.After(time.Now())) {
+			return nil
+		}
+		if err := createBoltUser(tx, username, passwordHash, inv.Role); err != nil {
+			return err
+		}
+		inv.Uses++
+		redeemed = &Invitation{Code: code, Role: inv.Role, MaxUses: inv.MaxUses, Uses: inv.Uses}
+		return putJSON(b, []byte(code), inv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redeemed, nil
+}
+
+// Roles
+func (s *BoltStore) SaveRole(r Role) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(bucketRoles), []byte(r.Name), r)
+	})
+}
+
+func (s *BoltStore) GetRole(name string) (*Role, error) {
+	var r Role
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ok, err = getJSON(tx.Bucket(bucketRoles), []byte(name), &r)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *BoltStore) ListRoles() ([]Role, error) {
+	roles := []Role{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRoles).ForEach(func(_, v []byte) error {
+			var r Role
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			roles = append(roles, r)
+			return nil
+		})
+	})
+	return roles, err
+}
+
+func (s *BoltStore) DeleteRole(name string) (bool, error) {
+	return s.deleteKey(bucketRoles, []byte(name))
+}
+
+// Save Message
+func (s *BoltStore) SaveMessage(topic string, payload []byte) (int64, error) {
+	return s.SaveMessageFrom(topic, payload, MessageOrigin{})
+}
+
+func (s *BoltStore) SaveMessageFrom(topic string, payload []byte, origin MessageOrigin) (int64, error) {
+	var id int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		m := Message{Topic: topic, Payload: payload, CreatedAt: now(), Origin: origin}
+		var err error
+		id, err = insertJSON(tx.Bucket(bucketMessages), &m, func(id int64) { m.ID = id })
+		return err
+	})
+	return id, err
+}
+
+// sealSequenced increments the topic's message sequence in tx and returns
+// the payload seal makes for it.
+func sealSequenced(tx *bolt.Tx, topic string, seal func(seq int64) ([]byte, error)) ([]byte, error) {
+	b := tx.Bucket(bucketTopics)
+	var t boltTopic
+	ok, err := getJSON(b, []byte(topic), &t)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("topic %w: %s", ErrNotFound, topic)
+	}
+	t.Seq++
+	if err := putJSON(b, []byte(topic), t); err != nil {
+		return nil, err
+	}
+	return seal(t.Seq)
+}
+
+func (s *BoltStore) SaveSequencedMessage(topic string, origin MessageOrigin, seal func(seq int64) ([]byte, error)) (int64, error) {
+	var id int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		payload, err := sealSequenced(tx, topic, seal)
+		if err != nil {
+			return err
+		}
+		m := Message{Topic: topic, Payload: payload, CreatedAt: now(), Origin: origin}
+		id, err = insertJSON(tx.Bucket(bucketMessages), &m, func(id int64) { m.ID = id })
+		return err
+	})
+	return id, err
+}
+
+func (s *BoltStore) SequenceMessage(id int64, seal func(seq int64) ([]byte, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMessages)
+		var m Message
+		ok, err := getJSON(b, itob(id), &m)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("message %w: %d", ErrNotFound, id)
+		}
+		if m.Payload, err = sealSequenced(tx, m.Topic, seal); err != nil {
+			return err
+		}
+		return putJSON(b, itob(id), m)
+	})
+}
+
+func (s *BoltStore) GetMessage(id int64) (*Message, error) {
+	var msg *Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketMessages).Get(itob(id))
+		if v == nil {
+			return nil
+		}
+		msg = &Message{}
+		return json.Unmarshal(v, msg)
+	})
+	return msg, err
+}
+
+func (s *BoltStore) GetRecentMessages(topic string, limit int) ([]Message, error) {
+	var msgs []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketMessages).Cursor()
+		for k, v := c.Last(); k != nil && (limit < 0 || len(msgs) < limit); k, v = c.Prev() {
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if m.Topic == topic {
+				msgs = append(msgs, m)
+			}
+		}
+		return nil
+	})
+	// Oldest -> Newest (Chronological)
+	slices.Reverse(msgs)
+	return msgs, err
+}
+
+func (s *BoltStore) SearchMessages(f MessageSearch) ([]Message, error) {
+	msgs := []Message{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketMessages).Cursor()
+		for k, v := c.Last(); k != nil && len(msgs) < f.limit(); k, v = c.Prev() {
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if f.matches(m) {
+				msgs = append(msgs, m)
+			}
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+func (s *BoltStore) ClearTopicMessages(topic string) error {
+	_, err := s.deleteMessages(func(m Message) bool { return m.Topic == topic })
+	return err
+}
+
+func (s *BoltStore) DeleteMessagesBefore(topic string, t time.Time) (int64, error) {
+	t = t.UTC().Truncate(time.Second)
+	return s.deleteMessages(func(m Message) bool { return m.Topic == topic && m.CreatedAt.Before(t) })
+}
+
+// deleteMessages deletes the messages matching match, with their queue
+// items and attempts, and returns how many were deleted.
+func (s *BoltStore) deleteMessages(match func(Message) bool) (int64, error) {
+	removed := map[int64]bool{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		err := messages.ForEach(func(_, v []byte) error {
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if match(m) {
+				removed[m.ID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete from queue first
+		queue := tx.Bucket(bucketQueue)
+		var queued [][]byte
+		used := map[string]bool{} // Payload hashes of the items kept
+		err = queue.ForEach(func(k, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if removed[q.MessageID] {
+				queued = append(queued, slices.Clone(k))
+			} else {
+				used[q.PayloadHash] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range queued {
+			if err := queue.Delete(k); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketPending).Delete(k); err != nil {
+				return err
+			}
+			if err := deletePrefix(tx.Bucket(bucketAttempts), k); err != nil {
+				return err
+			}
+		}
+		var unused [][]byte
+		payloads := tx.Bucket(bucketPayloads)
+		err = payloads.ForEach(func(k, _ []byte) error {
+			if !used[string(k)] {
+				unused = append(unused, slices.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range unused {
+			if err := payloads.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for id := range removed {
+			if err := messages.Delete(itob(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(removed)), nil
+}
+
+// Queue
+func (s *BoltStore) EnqueueMessage(messageID int64, token string) (int64, error) {
+	return s.EnqueueMessagePayload(messageID, token, nil)
+}
+
+func (s *BoltStore) EnqueueMessagePayload(messageID int64, token string, payload []byte) (int64, error) {
+	ids, err := s.EnqueueMessages(messageID, []QueueEntry{{Token: token, Payload: payload}})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (s *BoltStore) EnqueueMessages(messageID int64, entries []QueueEntry) ([]int64, error) {
+	ids := make([]int64, len(entries))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketMessages).Get(itob(messageID)) == nil {
+			return fmt.Errorf("message %w: %d", ErrNotFound, messageID)
+		}
+		now := queueNow()
+		queue, pending, payloads := tx.Bucket(bucketQueue), tx.Bucket(bucketPending), tx.Bucket(bucketPayloads)
+		for i, e := range entries {
+			q := boltQueueItem{MessageID: messageID, Token: e.Token, Provider: e.Provider, Status: "pending", CreatedAt: now}
+			if e.Payload != nil {
+				q.PayloadHash = payloadHash(e.Payload)
+				if payloads.Get([]byte(q.PayloadHash)) == nil {
+					if err := payloads.Put([]byte(q.PayloadHash), e.Payload); err != nil {
+						return err
+					}
+				}
+			}
+			id, err := insertJSON(queue, &q, func(int64) {})
+			if err != nil {
+				return err
+			}
+			if err := pending.Put(itob(id), nil); err != nil {
+				return err
+			}
+			ids[i] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// pendingItems lists pending queue items with their message's topic,
+// created_at and payload filled in, in queue order. Each message and stored
+// payload is decoded once, and shared by the items using it.
+func pendingItems(tx *bolt.Tx, keep func(q boltQueueItem, m Message) bool) ([]QueueItem, []string, error) {
+	var items []QueueItem
+	var topics []string
+	queue, messages, payloads := tx.Bucket(bucketQueue), tx.Bucket(bucketMessages), tx.Bucket(bucketPayloads)
+	decoded := map[int64]*Message{}
+	stored := map[string][]byte{}
+	err := tx.Bucket(bucketPending).ForEach(func(k, _ []byte) error {
+		var q boltQueueItem
+		if ok, err := getJSON(queue, k, &q); err != nil || !ok {
+			return err
+		}
+		m, seen := decoded[q.MessageID]
+		if !seen {
+			var msg Message
+			found, err := getJSON(messages, itob(q.MessageID), &msg)
+			if err != nil {
+				return err
+			}
+			if found {
+				m = &msg
+			}
+			decoded[q.MessageID] = m
+		}
+		if m == nil || !keep(q, *m) {
+			return nil
+		}
+		payload := q.Payload
+		switch {
+		case q.PayloadHash != "":
+			var ok bool
+			if payload, ok = stored[q.PayloadHash]; !ok {
+				// Bolt's bytes are only valid in the transaction
+				payload = bytes.Clone(payloads.Get([]byte(q.PayloadHash)))
+				stored[q.PayloadHash] = payload
+			}
+		case payload == nil:
+			payload = m.Payload
+		}
+		item := q.item(int64(binary.BigEndian.Uint64(k)), *m)
+		item.Provider, item.Payload = q.Provider, payload
+		items = append(items, item)
+		topics = append(topics, m.Topic)
+		return nil
+	})
+	return items, topics, err
+}
+
+func (s *BoltStore) GetPendingMessages(token string) ([]QueueItem, error) {
+	var items []QueueItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		items, _, err = pendingItems(tx, func(q boltQueueItem, _ Message) bool { return q.Token == token })
+		return err
+	})
+	for i := range items {
+		// Not selected by SQLiteStore either
+		items[i].Provider, items[i].CreatedAt = "", time.Time{}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].MessageID < items[j].MessageID })
+	return items, err
+}
+
+// pendingWithSubscription lists pending items whose message matches keep,
+// along with their provider and the options of their token's subscription to
+// the message's topic. Items without one are skipped.
+func (s *BoltStore) pendingWithSubscription(keep func(Message) bool) ([]QueueItem, error) {
+	var out []QueueItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		items, topics, err := pendingItems(tx, func(_ boltQueueItem, m Message) bool { return keep(m) })
+		if err != nil {
+			return err
+		}
+		disabled, err := disabledUsers(tx)
+		if err != nil {
+			return err
+		}
+		subs := tx.Bucket(bucketSubscriptions)
+		for i, item := range items {
+			v := subs.Get(compositeKey(topics[i], item.Token))
+			if v == nil {
+				continue
+			}
+			sub, err := decodeSubscriber(v)
+			if err != nil {
+				return err
+			}
+			if disabled[sub.Username] {
+				continue
+			}
+			if item.Provider == "" {
+				item.Provider = sub.Provider
+			}
+			item.Options = sub.Options
+			out = append(out, item)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltStore) GetAllPendingMessages() ([]QueueItem, error) {
+	return s.pendingWithSubscription(func(Message) bool { return true })
+}
+
+func (s *BoltStore) GetPendingMessagesByTopic(topic string) ([]QueueItem, error) {
+	return s.pendingWithSubscription(func(m Message) bool { return m.Topic == topic })
+}
+
+func (s *BoltStore) CountPending(topic string) (int64, error) {
+	var count int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(bucketPending)
+		if topic == "" {
+			count = int64(pending.Stats().KeyN)
+			return nil
+		}
+		queue, messages := tx.Bucket(bucketQueue), tx.Bucket(bucketMessages)
+		return pending.ForEach(func(k, _ []byte) error {
+			var q boltQueueItem
+			if ok, err := getJSON(queue, k, &q); err != nil || !ok {
+				return err
+			}
+			var m Message
+			if ok, err := getJSON(messages, itob(q.MessageID), &m); err != nil || !ok {
+				return err
+			}
+			if m.Topic == topic {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+func (s *BoltStore) CountQueuedSince(topic string, tokens []string, since time.Time) (map[string]int, error) {
+	wanted := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		wanted[token] = true
+	}
+	counts := map[string]int{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		topics := map[int64]string{} // Topic by message ID, read once each
+		return tx.Bucket(bucketQueue).ForEach(func(_, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if !wanted[q.Token] || q.CreatedAt.Before(since) {
+				return nil
+			}
+			t, ok := topics[q.MessageID]
+			if !ok {
+				var m Message
+				if _, err := getJSON(messages, itob(q.MessageID), &m); err != nil {
+					return err
+				}
+				t = m.Topic
+				topics[q.MessageID] = t
+			}
+			if t == topic {
+				counts[q.Token]++
+			}
+			return nil
+		})
+	})
+	return counts, err
+}
+
+func (s *BoltStore) GetQueueItemsByMessage(messageID int64) ([]QueueItem, error) {
+	var items []QueueItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var m Message
+		if ok, err := getJSON(tx.Bucket(bucketMessages), itob(messageID), &m); err != nil || !ok {
+			return err
+		}
+		return tx.Bucket(bucketQueue).ForEach(func(k, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if q.MessageID == messageID {
+				items = append(items, q.item(int64(binary.BigEndian.Uint64(k)), m))
+			}
+			return nil
+		})
+	})
+	return items, err
+}
+
+func (s *BoltStore) ListQueueOutcomes(since time.Time) ([]QueueItem, error) {
+	var items []QueueItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		return tx.Bucket(bucketQueue).ForEach(func(k, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if q.Status != "delivered" && q.Status != "failed" {
+				return nil
+			}
+			var m Message
+			if _, err := getJSON(messages, itob(q.MessageID), &m); err != nil {
+				return err
+			}
+			if item := q.item(btoi(k), m); !item.CreatedAt.Before(since) {
+				items = append(items, item)
+			}
+			return nil
+		})
+	})
+	return items, err
+}
+
+// updateQueueItem applies fn to a queue item; fn reports whether to save it.
+func (s *BoltStore) updateQueueItem(queueID int64, fn func(*boltQueueItem) bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateQueueItemTx(tx, queueID, fn)
+	})
+}
+
+// updateQueueItemTx is updateQueueItem within tx.
+func updateQueueItemTx(tx *bolt.Tx, queueID int64, fn func(*boltQueueItem) bool) error {
+	b := tx.Bucket(bucketQueue)
+	var q boltQueueItem
+	ok, err := getJSON(b, itob(queueID), &q)
+	if err != nil || !ok || !fn(&q) {
+		return err
+	}
+	if q.Status != "pending" {
+		if err := tx.Bucket(bucketPending).Delete(itob(queueID)); err != nil {
+			return err
+		}
+	}
+	return putJSON(b, itob(queueID), q)
+}
+
+func (s *BoltStore) MarkDelivered(queueID int64) error {
+	return s.updateQueueItem(queueID, func(q *boltQueueItem) bool {
+		t := queueNow()
+		q.Status, q.DeliveredAt = "delivered", &t
+		return true
+	})
+}
+
+func (s *BoltStore) MarkOpened(messageID int64, token string) (bool, error) {
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketQueue)
+		opened := map[string]boltQueueItem{}
+		err := b.ForEach(func(k, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			if q.MessageID == messageID && q.Token == token {
+				found = true
+				if q.OpenedAt == nil {
+					t := now()
+					q.OpenedAt = &t
+					opened[string(k)] = q
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, q := range opened {
+			if err := putJSON(b, []byte(k), q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (s *BoltStore) ListMessageOpens(limit int) ([]MessageOpens, error) {
+	byMessage := map[int64]*MessageOpens{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		return tx.Bucket(bucketQueue).ForEach(func(_, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			o := byMessage[q.MessageID]
+			if o == nil {
+				var m Message
+				if _, err := getJSON(messages, itob(q.MessageID), &m); err != nil {
+					return err
+				}
+				o = &MessageOpens{MessageID: q.MessageID, Topic: m.Topic}
+				byMessage[q.MessageID] = o
+			}
+			o.count(q.Status, q.OpenedAt)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latestOpens(byMessage, limit), nil
+}
+
+func (s *BoltStore) RecordAttempt(a Attempt) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return recordAttemptTx(tx, a, false)
+	})
+}
+
+func (s *BoltStore) SettleAttempts(attempts []Attempt) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, a := range attempts {
+			if err := recordAttemptTx(tx, a, a.Error == ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recordAttemptTx logs an attempt within tx, marking its queue item
+// delivered if delivered is set.
+func recordAttemptTx(tx *bolt.Tx, a Attempt, delivered bool) error {
+	a.AttemptedAt = a.AttemptedAt.UTC()
+	found := false
+	err := updateQueueItemTx(tx, a.QueueID, func(q *boltQueueItem) bool {
+		found = true
+		q.Attempts++
+		if a.Error != "" {
+			q.LastError = a.Error
+		}
+		if delivered {
+			t := queueNow()
+			q.Status, q.DeliveredAt = "delivered", &t
+		}
+		return true
+	})
+	if err != nil || !found {
+		return err
+	}
+	b := tx.Bucket(bucketAttempts)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	return putJSON(b, append(itob(a.QueueID), itob(int64(seq))...), a)
+}
+
+func (s *BoltStore) ListAttempts(topic string, queueID int64) ([]Attempt, error) {
+	var attempts []Attempt
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var q boltQueueItem
+		if ok, err := getJSON(tx.Bucket(bucketQueue), itob(queueID), &q); err != nil || !ok {
+			return err
+		}
+		var m Message
+		if ok, err := getJSON(tx.Bucket(bucketMessages), itob(q.MessageID), &m); err != nil || !ok || m.Topic != topic {
+			return err
+		}
+		prefix := itob(queueID)
+		c := tx.Bucket(bucketAttempts).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var a Attempt
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			attempts = append(attempts, a)
+		}
+		return nil
+	})
+	return attempts, err
+}
+
+func (s *BoltStore) ProviderStats(since time.Time) ([]ProviderStats, error) {
+	var agg providerStatsAggregator
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var last []byte
+		return tx.Bucket(bucketAttempts).ForEach(func(k, v []byte) error {
+			retry := bytes.Equal(k[:8], last)
+			last = k[:8]
+			var a Attempt
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if !a.AttemptedAt.Before(since) {
+				agg.add(a, retry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return agg.stats(), nil
+}
+
+func (s *BoltStore) MarkFailed(queueID int64) error {
+	return s.updateQueueItem(queueID, func(q *boltQueueItem) bool {
+		if q.Status != "pending" {
+			return false
+		}
+		q.Status = "failed"
+		return true
+	})
+}
+
+func (s *BoltStore) CancelQueueItem(topic string, queueID int64) (bool, error) {
+	return s.setQueueStatus(topic, queueID, "canceled", "pending")
+}
+
+func (s *BoltStore) RequeueQueueItem(topic string, queueID int64) (bool, error) {
+	return s.setQueueStatus(topic, queueID, "pending", "failed", "canceled")
+}
+
+// setQueueStatus moves a queue item of a topic's message from one of the
+// from statuses to status, releasing its claim.
+func (s *BoltStore) setQueueStatus(topic string, queueID int64, status string, from ...string) (bool, error) {
+	var changed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketQueue)
+		var q boltQueueItem
+		if ok, err := getJSON(b, itob(queueID), &q); err != nil || !ok || !slices.Contains(from, q.Status) {
+			return err
+		}
+		var m Message
+		if ok, err := getJSON(tx.Bucket(bucketMessages), itob(q.MessageID), &m); err != nil || !ok || m.Topic != topic {
+			return err
+		}
+		q.Status, q.ClaimedBy, q.ClaimedUntil = status, "", time.Time{}
+		pending := tx.Bucket(bucketPending)
+		var err error
+		if status == "pending" {
+			err = pending.Put(itob(queueID), nil)
+		} else {
+			err = pending.Delete(itob(queueID))
+		}
+		if err != nil {
+			return err
+		}
+		changed = true
+		return putJSON(b, itob(queueID), q)
+	})
+	return changed, err
+}
+
+func (s *BoltStore) PurgeQueue(topic, token string) (int64, error) {
+	if topic == "" && token == "" {
+		return 0, fmt.Errorf("topic or token is required")
+	}
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		queue, pending := tx.Bucket(bucketQueue), tx.Bucket(bucketPending)
+		type match struct {
+			key  []byte
+			item boltQueueItem
+		}
+		var matches []match
+		err := pending.ForEach(func(k, _ []byte) error {
+			var q boltQueueItem
+			if ok, err := getJSON(queue, k, &q); err != nil || !ok {
+				return err
+			}
+			if token != "" && q.Token != token {
+				return nil
+			}
+			if topic != "" {
+				var m Message
+				if ok, err := getJSON(tx.Bucket(bucketMessages), itob(q.MessageID), &m); err != nil || !ok || m.Topic != topic {
+					return err
+				}
+			}
+			matches = append(matches, match{slices.Clone(k), q})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			m.item.Status, m.item.ClaimedBy, m.item.ClaimedUntil = "canceled", "", time.Time{}
+			if err := putJSON(queue, m.key, m.item); err != nil {
+				return err
+			}
+			if err := pending.Delete(m.key); err != nil {
+				return err
+			}
+		}
+		n = int64(len(matches))
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) ClaimQueueItem(queueID int64, nodeID string, lease time.Duration) (bool, error) {
+	var claimed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		claimed, err = claimQueueItemTx(tx, queueID, nodeID, lease)
+		return err
+	})
+	return claimed, err
+}
+
+func (s *BoltStore) ClaimQueueItems(queueIDs []int64, nodeID string, lease time.Duration) ([]int64, error) {
+	claimed := make([]int64, 0, len(queueIDs))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range queueIDs {
+			ok, err := claimQueueItemTx(tx, id, nodeID, lease)
+			if err != nil {
+				return err
+			}
+			if ok {
+				claimed = append(claimed, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// claimQueueItemTx leases a pending queue item to nodeID within tx.
+func claimQueueItemTx(tx *bolt.Tx, queueID int64, nodeID string, lease time.Duration) (bool, error) {
+	var claimed bool
+	err := updateQueueItemTx(tx, queueID, func(q *boltQueueItem) bool {
+		t := time.Now().UTC()
+		if q.Status != "pending" || (q.ClaimedBy != "" && q.ClaimedBy != nodeID && !q.ClaimedUntil.Before(t)) {
+			return false
+		}
+		q.ClaimedBy, q.ClaimedUntil = nodeID, t.Add(lease)
+		claimed = true
+		return true
+	})
+	return claimed, err
+}
+
+// Stats
+func (s *BoltStore) GetTotalMessagesSent() (int64, error) {
+	var count int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = int64(tx.Bucket(bucketMessages).Stats().KeyN)
+		return nil
+	})
+	return count, err
+}
+
+func (s *BoltStore) RecordStats(bucket time.Time, counts, peaks map[string]int64) error {
+	hour := itob(bucket.UTC().Truncate(time.Hour).Unix())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketStats)
+		update := func(values map[string]int64, merge func(old, n int64) int64) error {
+			for metric, n := range values {
+				k := append(slices.Clone(hour), metric...)
+				if v := b.Get(k); v != nil {
+					n = merge(btoi(v), n)
+				}
+				if err := b.Put(k, itob(n)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := update(counts, func(old, n int64) int64 { return old + n }); err != nil {
+			return err
+		}
+		return update(peaks, func(old, n int64) int64 { return max(old, n) })
+	})
+}
+
+func (s *BoltStore) ListStats(from, to time.Time) ([]StatPoint, error) {
+	var points []StatPoint
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketStats).Cursor()
+		end := to.Unix()
+		for k, v := c.Seek(itob(from.Unix())); k != nil; k, v = c.Next() {
+			hour := btoi(k[:8])
+			if hour >= end {
+				break
+			}
+			points = append(points, StatPoint{Bucket: time.Unix(hour, 0).UTC(), Metric: string(k[8:]), Value: btoi(v)})
+		}
+		return nil
+	})
+	return points, err
+}
+
+func (s *BoltStore) DeleteStatsBefore(t time.Time) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketStats).Cursor()
+		end := t.Unix()
+		for k, _ := c.First(); k != nil && btoi(k[:8]) < end; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Sessions
+
+func (s *BoltStore) CreateSession(sess Session) error {
+	sess.LastUsedAt, sess.LastIP = nil, ""
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		if b.Get([]byte(sess.ID)) != nil {
+			return fmt.Errorf("session %w: %s", ErrDuplicate, sess.ID)
+		}
+		// Drop the user's expired sessions, collecting keys first since
+		// deleting while iterating isn't safe
+		t := time.Now()
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var other Session
+			if err := json.Unmarshal(v, &other); err != nil {
+				return err
+			}
+			if other.Username == sess.Username && !other.ExpiresAt.After(t) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return putJSON(b, []byte(sess.ID), sess)
+	})
+}
+
+func (s *BoltStore) GetSession(id string) (*Session, error) {
+	var sess Session
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ok, err = getJSON(tx.Bucket(bucketSessions), []byte(id), &sess)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, err // nil if not found
+	}
+	return &sess, nil
+}
+
+func (s *BoltStore) TouchSession(id, ip string, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		var sess Session
+		ok, err := getJSON(b, []byte(id), &sess)
+		if err != nil || !ok {
+			return err
+		}
+		sess.LastUsedAt, sess.LastIP = &at, ip
+		return putJSON(b, []byte(id), sess)
+	})
+}
+
+func (s *BoltStore) ListSessions(username string) ([]Session, error) {
+	t := time.Now()
+	sessions := []Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).ForEach(func(_, v []byte) error {
+			var sess Session
+			if err := json.Unmarshal(v, &sess); err != nil {
+				return err
+			}
+			if sess.Username == username && sess.ExpiresAt.After(t) {
+				sessions = append(sessions, sess)
+			}
+			return nil
+		})
+	})
+	sortSessions(sessions)
+	return sessions, err
+}
+
+func (s *BoltStore) DeleteSession(username, id string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		var sess Session
+		ok, err := getJSON(b, []byte(id), &sess)
+		if err != nil || !ok || sess.Username != username {
+			return err
+		}
+		found = true
+		return b.Delete([]byte(id))
+	})
+	return found, err
+}
+
+func (s *BoltStore) DeleteUserSessions(username string) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var sess Session
+			if err := json.Unmarshal(v, &sess); err != nil {
+				return err
+			}
+			if sess.Username == username {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = int64(len(keys))
+		return nil
+	})
+	return n, err
+}
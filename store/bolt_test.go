@@ -0,0 +1,39 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.bolt")
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore failed: %v", err)
+	}
+	s.CreateTopic("news")
+	s.AddSubscription("news", "tok", "webhook", "alice")
+	id, _ := s.SaveMessage("news", []byte(`{"n":1}`))
+	s.EnqueueMessage(id, "tok")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	defer s.Close()
+	subs, _ := s.GetSubscriptionsByUser("alice")
+	if len(subs) != 1 || subs[0].Topic != "news" {
+		t.Errorf("Expected alice's subscription after reopening, got %v", subs)
+	}
+	items, _ := s.GetAllPendingMessages()
+	if len(items) != 1 || string(items[0].Payload) != `{"n":1}` {
+		t.Errorf("Expected the pending delivery after reopening, got %v", items)
+	}
+	// IDs keep increasing after a restart
+	if next, _ := s.SaveMessage("news", []byte(`{}`)); next <= id {
+		t.Errorf("Expected message ID above %d, got %d", id, next)
+	}
+}
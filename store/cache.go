@@ -0,0 +1,274 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds each lookup cache, which is emptied when full, so
+// lookups of many different names can't grow it without bound.
+const maxCacheEntries = 10000
+
+// CachedStore wraps a Store and caches the lookups made on every publish and
+// queue tick: whether a topic exists, what a name is an alias of and a
+// topic's subscribers. Writes through it drop the entries they affect.
+// Entries also expire after a TTL, which bounds how long a write made
+// elsewhere goes unseen; in a cluster, OnInvalidate and Invalidate carry the
+// drops between nodes.
+type CachedStore struct {
+	Store
+	ttl time.Duration // 0 disables caching
+	now func() time.Time
+
+	mu           sync.Mutex
+	gen          uint64 // Bumped by every drop, so a lookup racing a write isn't cached
+	exists       map[string]cacheEntry[bool]
+	aliases      map[string]cacheEntry[string]
+	subscribers  map[string]cacheEntry[[]Subscriber]
+	onInvalidate func(topic string)
+}
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// Cache wraps s, caching lookups for ttl. A ttl of 0 disables caching.
+func Cache(s Store, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		Store:       s,
+		ttl:         ttl,
+		now:         time.Now,
+		exists:      map[string]cacheEntry[bool]{},
+		aliases:     map[string]cacheEntry[string]{},
+		subscribers: map[string]cacheEntry[[]Subscriber]{},
+	}
+}
+
+// Unwrap returns the underlying Store.
+func (c *CachedStore) Unwrap() Store {
+	return c.Store
+}
+
+// OnInvalidate registers fn, called after a write through c dropped the
+// entries of a topic, or of every topic when topic is "".
+func (c *CachedStore) OnInvalidate(fn func(topic string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onInvalidate = fn
+}
+
+// Invalidate drops the entries of a topic, or every entry when topic is "",
+// without calling the OnInvalidate hook. It applies the drops of other nodes.
+func (c *CachedStore) Invalidate(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if topic == "" {
+		clear(c.exists)
+		clear(c.aliases)
+		clear(c.subscribers)
+		return
+	}
+	delete(c.exists, topic)
+	delete(c.subscribers, topic)
+	for alias, e := range c.aliases {
+		if alias == topic || e.value == topic {
+			delete(c.aliases, alias)
+		}
+	}
+}
+
+// drop invalidates the entries of a topic, or every entry when topic is "",
+// after a write.
+func (c *CachedStore) drop(topic string) {
+	c.Invalidate(topic)
+	c.mu.Lock()
+	fn := c.onInvalidate
+	c.mu.Unlock()
+	if fn != nil {
+		fn(topic)
+	}
+}
+
+// lookup returns the cached value of key in m, loading it on a miss.
+func lookup[T any](c *CachedStore, m map[string]cacheEntry[T], key string, load func() (T, error)) (T, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+	c.mu.Lock()
+	if e, ok := m[key]; ok && c.now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.value, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		if len(m) >= maxCacheEntries {
+			clear(m)
+		}
+		m[key] = cacheEntry[T]{value: v, expires: c.now().Add(c.ttl)}
+	}
+	return v, nil
+}
+
+func (c *CachedStore) TopicExists(name string) (bool, error) {
+	return lookup(c, c.exists, name, func() (bool, error) { return c.Store.TopicExists(name) })
+}
+
+func (c *CachedStore) ResolveTopicAlias(name string) (string, error) {
+	return lookup(c, c.aliases, name, func() (string, error) { return c.Store.ResolveTopicAlias(name) })
+}
+
+// GetSubscribers returns a copy of the cached subscribers, which callers may
+// filter in place.
+func (c *CachedStore) GetSubscribers(topic string) ([]Subscriber, error) {
+	subs, err := lookup(c, c.subscribers, topic, func() ([]Subscriber, error) { return c.Store.GetSubscribers(topic) })
+	return slices.Clone(subs), err
+}
+
+func (c *CachedStore) CreateTopic(name string) error {
+	defer c.drop(name)
+	return c.Store.CreateTopic(name)
+}
+
+func (c *CachedStore) CreateOwnedTopic(name, owner, prefix string, limit int) (int, error) {
+	defer c.drop(name)
+	return c.Store.CreateOwnedTopic(name, owner, prefix, limit)
+}
+
+// DeleteTopic drops every entry, as the topic's aliases go with it.
+func (c *CachedStore) DeleteTopic(name string) error {
+	defer c.drop("")
+	return c.Store.DeleteTopic(name)
+}
+
+func (c *CachedStore) RenameTopic(oldName, newName string, alias bool) error {
+	defer c.drop("")
+	return c.Store.RenameTopic(oldName, newName, alias)
+}
+
+func (c *CachedStore) DeleteTopicAlias(alias string) (bool, error) {
+	defer c.drop("")
+	return c.Store.DeleteTopicAlias(alias)
+}
+
+func (c *CachedStore) AddSubscription(topic, token, provider, username string) error {
+	defer c.drop(topic)
+	return c.Store.AddSubscription(topic, token, provider, username)
+}
+
+func (c *CachedStore) RemoveSubscription(topic, token string) error {
+	defer c.drop(topic)
+	return c.Store.RemoveSubscription(topic, token)
+}
+
+func (c *CachedStore) ClearTopicSubscribers(topic string) error {
+	defer c.drop(topic)
+	return c.Store.ClearTopicSubscribers(topic)
+}
+
+func (c *CachedStore) SetSubscriptionOptions(topic, token string, opts *WebhookOptions) error {
+	defer c.drop(topic)
+	return c.Store.SetSubscriptionOptions(topic, token, opts)
+}
+
+func (c *CachedStore) SetSubscriptionLocale(topic, token, locale string) error {
+	defer c.drop(topic)
+	return c.Store.SetSubscriptionLocale(topic, token, locale)
+}
+
+func (c *CachedStore) SetSubscriptionPreferences(topic, token string, prefs *Preferences) error {
+	defer c.drop(topic)
+	return c.Store.SetSubscriptionPreferences(topic, token, prefs)
+}
+
+func (c *CachedStore) SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
+	defer c.drop(topic)
+	return c.Store.SetSubscriptionAttributes(topic, token, platform, appVersion, tags)
+}
+
+func (c *CachedStore) UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error) {
+	defer c.drop(topic)
+	return c.Store.UpdateSubscriptionTags(topic, token, add, remove)
+}
+
+// RemoveSubscriptionsByTag, ReassignSubscriptions and
+// RemoveStaleSubscriptions drop every entry, as the subscriptions may be to
+// any topic.
+func (c *CachedStore) RemoveSubscriptionsByTag(username, tag string) (int64, error) {
+	defer c.drop("")
+	return c.Store.RemoveSubscriptionsByTag(username, tag)
+}
+
+func (c *CachedStore) ReassignSubscriptions(from, to string) (int64, error) {
+	defer c.drop("")
+	return c.Store.ReassignSubscriptions(from, to)
+}
+
+func (c *CachedStore) RemoveStaleSubscriptions(before time.Time) (int64, error) {
+	defer c.drop("")
+	return c.Store.RemoveStaleSubscriptions(before)
+}
+
+// SetUserDisabled drops every entry, as it pauses or resumes the user's
+// subscriptions.
+func (c *CachedStore) SetUserDisabled(username string, disabled bool) error {
+	defer c.drop("")
+	return c.Store.SetUserDisabled(username, disabled)
+}
+
+// SetUserAccess drops every entry, like SetUserDisabled.
+func (c *CachedStore) SetUserAccess(username, role string, disabled bool) error {
+	defer c.drop("")
+	return c.Store.SetUserAccess(username, role, disabled)
+}
+
+// errNoBackup is returned by Backup and Restore when the backend has neither.
+var errNoBackup = errors.New("the store backend doesn't support backups")
+
+// backuper is implemented by backends that back up and restore online.
+type backuper interface {
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// backend returns the Store under every wrapper of s.
+func backend(s Store) Store {
+	for {
+		w, ok := s.(interface{ Unwrap() Store })
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+// Backup streams a backup of the backend to w.
+func (c *CachedStore) Backup(w io.Writer) error {
+	b, ok := backend(c.Store).(backuper)
+	if !ok {
+		return errNoBackup
+	}
+	return b.Backup(w)
+}
+
+// Restore replaces the backend's data with a backup and drops every entry.
+func (c *CachedStore) Restore(r io.Reader) error {
+	b, ok := backend(c.Store).(backuper)
+	if !ok {
+		return errNoBackup
+	}
+	defer c.drop("")
+	return b.Restore(r)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// calls returns how often each method of s was called.
+func calls(s *InstrumentedStore) map[string]int64 {
+	n := map[string]int64{}
+	for _, m := range s.Snapshot() {
+		n[m.Method] = m.Calls
+	}
+	return n
+}
+
+func TestCachedStore(t *testing.T) {
+	backend := Instrument(NewMemoryStore(), 0)
+	c := Cache(backend, time.Minute)
+	var _ Store = c
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.CreateTopic("news")
+	c.AddSubscription("news", "a", "webhook", "alice")
+	for i := 0; i < 3; i++ {
+		if ok, err := c.TopicExists("news"); err != nil || !ok {
+			t.Fatalf("TopicExists = %v, %v", ok, err)
+		}
+		subs, err := c.GetSubscribers("news")
+		if err != nil || len(subs) != 1 {
+			t.Fatalf("GetSubscribers = %v, %v", subs, err)
+		}
+		subs[0].Token = "changed" // Callers filter in place
+	}
+	if n := calls(backend); n["TopicExists"] != 1 || n["GetSubscribers"] != 1 {
+		t.Errorf("Expected one backend lookup each, got %v", n)
+	}
+	if subs, _ := c.GetSubscribers("news"); subs[0].Token != "a" {
+		t.Errorf("Expected the cached subscribers untouched, got %v", subs)
+	}
+
+	var dropped []string
+	c.OnInvalidate(func(topic string) { dropped = append(dropped, topic) })
+	c.AddSubscription("news", "b", "webhook", "bob")
+	if subs, _ := c.GetSubscribers("news"); len(subs) != 2 {
+		t.Errorf("Expected the new subscriber after invalidation, got %v", subs)
+	}
+	c.RenameTopic("news", "updates", true)
+	if target, _ := c.ResolveTopicAlias("news"); target != "updates" {
+		t.Errorf("Expected news to alias updates, got %q", target)
+	}
+	if ok, _ := c.TopicExists("news"); ok {
+		t.Error("Expected the renamed topic to be gone")
+	}
+	if len(dropped) != 2 || dropped[0] != "news" || dropped[1] != "" {
+		t.Errorf("Expected drops of news and everything, got %q", dropped)
+	}
+
+	// A write elsewhere, e.g. on another node, shows after the TTL
+	backend.CreateTopic("sports")
+	c.TopicExists("sports")
+	backend.DeleteTopic("sports")
+	if ok, _ := c.TopicExists("sports"); !ok {
+		t.Error("Expected the cached lookup before the TTL")
+	}
+	now = now.Add(time.Minute)
+	if ok, _ := c.TopicExists("sports"); ok {
+		t.Error("Expected the lookup to expire after the TTL")
+	}
+	backend.CreateTopic("sports")
+	c.Invalidate("sports")
+	if ok, _ := c.TopicExists("sports"); !ok {
+		t.Error("Expected Invalidate to drop the entry")
+	}
+}
+
+func TestCachedStoreDisabled(t *testing.T) {
+	backend := Instrument(NewMemoryStore(), 0)
+	c := Cache(backend, 0)
+	c.CreateTopic("news")
+	c.TopicExists("news")
+	c.TopicExists("news")
+	if n := calls(backend)["TopicExists"]; n != 2 {
+		t.Errorf("Expected every lookup to reach the backend, got %d", n)
+	}
+	if err := c.Restore(nil); err != errNoBackup {
+		t.Errorf("Expected errNoBackup from the memory store, got %v", err)
+	}
+}
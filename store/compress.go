@@ -0,0 +1,123 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Payload compression algorithms.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// sqliteDriver is go-sqlite3 with payload_text(), which decompresses a
+// stored payload, registered on every connection for the search index
+// triggers and queries.
+const sqliteDriver = "sqlite3_nospam"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("payload_text", payloadText, true)
+		},
+	})
+}
+
+// payloadText returns a stored payload as text, for indexing and LIKE.
+func payloadText(v any) string {
+	switch p := v.(type) {
+	case []byte:
+		return string(decompressPayload(p))
+	case string:
+		return p
+	}
+	return ""
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// The zstd encoder and decoder are safe for concurrent EncodeAll and
+	// DecodeAll calls.
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// ValidCompression reports whether algo is a supported payload compression.
+func ValidCompression(algo string) bool {
+	switch algo {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// SetPayloadCompression compresses payloads of at least threshold bytes
+// with algo when they are stored. Payloads are recognized by their magic
+// bytes on read, so the setting can change, or be turned off, at any time.
+func (s *SQLiteStore) SetPayloadCompression(algo string, threshold int) error {
+	if !ValidCompression(algo) {
+		return fmt.Errorf("unknown payload compression: %q", algo)
+	}
+	if threshold < 0 {
+		return fmt.Errorf("payload compression threshold must not be negative")
+	}
+	s.compression, s.compressAt = algo, threshold
+	return nil
+}
+
+// compress returns the payload as it should be stored.
+func (s *SQLiteStore) compress(p []byte) []byte {
+	algo := s.compression
+	if algo == CompressionNone || len(p) < s.compressAt {
+		// A payload that looks compressed must be wrapped, or it would be
+		// decompressed when read back.
+		if !compressed(p) {
+			return p
+		}
+		if algo == CompressionNone {
+			algo = CompressionGzip
+		}
+	}
+	if algo == CompressionZstd {
+		return zstdEncoder.EncodeAll(p, nil)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(p)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func compressed(p []byte) bool {
+	return bytes.HasPrefix(p, gzipMagic) || bytes.HasPrefix(p, zstdMagic)
+}
+
+// decompressPayload returns a stored payload as it was saved. Payloads
+// that aren't compressed, or fail to decompress, are returned as they are.
+func decompressPayload(p []byte) []byte {
+	switch {
+	case bytes.HasPrefix(p, zstdMagic):
+		if out, err := zstdDecoder.DecodeAll(p, nil); err == nil {
+			return out
+		}
+	case bytes.HasPrefix(p, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return p
+		}
+		if out, err := io.ReadAll(zr); err == nil {
+			return out
+		}
+	}
+	return p
+}
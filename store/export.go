@@ -0,0 +1,328 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DumpVersion is the format version written by Export and accepted by Import.
+const DumpVersion = 1
+
+// Dump is a portable JSON copy of a store's topics, users and subscriptions,
+// used to move data between instances or store backends.
+type Dump struct {
+	Version       int                `json:"version"`
+	ExportedAt    time.Time          `json:"exported_at"`
+	Topics        []DumpTopic        `json:"topics"`
+	Templates     []Template         `json:"templates,omitempty"`
+	Roles         []Role             `json:"roles,omitempty"`
+	Users         []DumpUser         `json:"users"`
+	Subscriptions []DumpSubscription `json:"subscriptions"`
+	Messages      []DumpMessage      `json:"messages,omitempty"`
+}
+
+type DumpTopic struct {
+	Name              string          `json:"name"`
+	Schema            json.RawMessage `json:"schema,omitempty"`
+	ApprovalThreshold int             `json:"approval_threshold,omitempty"`
+	Description       string          `json:"description,omitempty"`
+	Owner             string          `json:"owner,omitempty"`
+	ReplayCount       *int            `json:"replay_count,omitempty"`
+	RetentionDays     int             `json:"retention_days,omitempty"`
+	Public            bool            `json:"public,omitempty"`
+
+	MaxAttempts         int `json:"max_attempts,omitempty"`
+	RetryBackoffSeconds int `json:"retry_backoff_seconds,omitempty"`
+	TTLSeconds          int `json:"ttl_seconds,omitempty"`
+}
+
+// DumpUser carries the password hash, never a plaintext password. Two-factor
+// enrollment isn't exported.
+type DumpUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+	ExternalID   string `json:"external_id,omitempty"`
+}
+
+type DumpSubscription struct {
+	Subscriber
+	Username string `json:"username"`
+}
+
+type DumpMessage struct {
+	Topic     string          `json:"topic"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	Origin    MessageOrigin   `json:"origin,omitzero"`
+}
+
+// ExportOptions selects optional parts of a dump.
+type ExportOptions struct {
+	Messages int // Most recent messages per topic to include; 0 includes none
+}
+
+// Export reads a dump from s.
+func Export(s Store, opts ExportOptions) (*Dump, error) {
+	d := &Dump{
+		Version:       DumpVersion,
+		ExportedAt:    time.Now().UTC().Truncate(time.Second),
+		Topics:        []DumpTopic{},
+		Users:         []DumpUser{},
+		Subscriptions: []DumpSubscription{},
+	}
+
+	topics, err := s.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("list topics: %w", err)
+	}
+	for _, name := range topics {
+		t := DumpTopic{Name: name}
+		schema, err := s.GetTopicSchema(name)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+		if schema != "" {
+			t.Schema = json.RawMessage(schema)
+		}
+		if t.ApprovalThreshold, err = s.GetTopicApprovalThreshold(name); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+		info, err := s.GetTopicInfo(name)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+		if info != nil {
+			t.Description, t.Owner = info.Description, info.Owner
+			t.ReplayCount, t.RetentionDays = info.ReplayCount, info.RetentionDays
+			t.Public = info.Public
+			t.MaxAttempts, t.RetryBackoffSeconds, t.TTLSeconds = info.MaxAttempts, info.RetryBackoffSeconds, info.TTLSeconds
+		}
+		d.Topics = append(d.Topics, t)
+
+		templates, err := s.ListTemplates(name)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+		d.Templates = append(d.Templates, templates...)
+
+		subs, err := s.GetSubscribers(name)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+		for _, sub := range subs {
+			d.Subscriptions = append(d.Subscriptions, DumpSubscription{Subscriber: sub, Username: sub.Username})
+		}
+
+		if opts.Messages > 0 {
+			msgs, err := s.GetRecentMessages(name, opts.Messages)
+			if err != nil {
+				return nil, fmt.Errorf("topic %s: %w", name, err)
+			}
+			// Oldest first, so an import saves them in their original order
+			for i := len(msgs) - 1; i >= 0; i-- {
+				d.Messages = append(d.Messages, DumpMessage{Topic: name, Payload: msgs[i].Payload, CreatedAt: msgs[i].CreatedAt, Origin: msgs[i].Origin})
+			}
+		}
+	}
+
+	if d.Roles, err = s.ListRoles(); err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+
+	users, err := s.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	for _, u := range users {
+		d.Users = append(d.Users, DumpUser{Username: u.Username, PasswordHash: u.PasswordHash, Role: u.Role, ExternalID: u.ExternalID})
+	}
+	return d, nil
+}
+
+// ImportResult counts what Import created and what it skipped because it
+// already existed.
+type ImportResult struct {
+	Topics        int `json:"topics"`
+	Templates     int `json:"templates"`
+	Roles         int `json:"roles"`
+	Users         int `json:"users"`
+	Subscriptions int `json:"subscriptions"`
+	Messages      int `json:"messages"`
+	Skipped       int `json:"skipped"`
+}
+
+// ErrInvalidDump is returned by Import for a dump it can't apply.
+var ErrInvalidDump = errors.New("invalid dump")
+
+// Import merges d into s. Existing topics, users and subscriptions are left
+// as they are; templates and roles in the dump replace stored ones with the
+// same name. Messages are appended to the topic history with new IDs and are
+// not delivered. Import isn't transactional: on error, everything before the
+// failing entry has been applied, and importing the same dump again is safe.
+func Import(s Store, d *Dump) (ImportResult, error) {
+	var res ImportResult
+	if err := validateDump(d); err != nil {
+		return res, err
+	}
+
+	for _, t := range d.Topics {
+		exists, err := s.TopicExists(t.Name)
+		if err != nil {
+			return res, err
+		}
+		if exists {
+			res.Skipped++
+			continue
+		}
+		if err := s.CreateTopic(t.Name); err != nil {
+			return res, fmt.Errorf("topic %s: %w", t.Name, err)
+		}
+		if len(t.Schema) > 0 {
+			if err := s.SetTopicSchema(t.Name, string(t.Schema)); err != nil {
+				return res, fmt.Errorf("topic %s: %w", t.Name, err)
+			}
+		}
+		if t.ApprovalThreshold > 0 {
+			if err := s.SetTopicApprovalThreshold(t.Name, t.ApprovalThreshold); err != nil {
+				return res, fmt.Errorf("topic %s: %w", t.Name, err)
+			}
+		}
+		info := TopicInfo{
+			Name: t.Name, Description: t.Description, Owner: t.Owner, ReplayCount: t.ReplayCount, RetentionDays: t.RetentionDays, Public: t.Public,
+			MaxAttempts: t.MaxAttempts, RetryBackoffSeconds: t.RetryBackoffSeconds, TTLSeconds: t.TTLSeconds,
+		}
+		if info != (TopicInfo{Name: t.Name}) {
+			if err := s.SetTopicInfo(info); err != nil {
+				return res, fmt.Errorf("topic %s: %w", t.Name, err)
+			}
+		}
+		res.Topics++
+	}
+
+	for _, t := range d.Templates {
+		if err := s.SaveTemplate(t); err != nil {
+			return res, fmt.Errorf("template %s/%s: %w", t.Topic, t.Name, err)
+		}
+		res.Templates++
+	}
+
+	for _, r := range d.Roles {
+		if err := s.SaveRole(r); err != nil {
+			return res, fmt.Errorf("role %s: %w", r.Name, err)
+		}
+		res.Roles++
+	}
+
+	for _, u := range d.Users {
+		existing, err := s.GetUser(u.Username)
+		if err != nil {
+			return res, err
+		}
+		if existing != nil {
+			res.Skipped++
+			continue
+		}
+		if err := s.CreateUser(u.Username, u.PasswordHash, u.Role); err != nil {
+			return res, fmt.Errorf("user %s: %w", u.Username, err)
+		}
+		if u.ExternalID != "" {
+			if _, err := s.LinkUser(u.Username, u.ExternalID); err != nil {
+				return res, fmt.Errorf("user %s: %w", u.Username, err)
+			}
+		}
+		res.Users++
+	}
+
+	for _, sub := range d.Subscriptions {
+		existing, err := s.GetSubscriptionsByToken(sub.Token)
+		if err != nil {
+			return res, err
+		}
+		if subscribed(existing, sub.Topic) {
+			res.Skipped++
+			continue
+		}
+		if err := importSubscription(s, sub); err != nil {
+			return res, fmt.Errorf("subscription %s/%s: %w", sub.Topic, sub.Token, err)
+		}
+		res.Subscriptions++
+	}
+
+	for _, m := range d.Messages {
+		if _, err := s.SaveMessageFrom(m.Topic, m.Payload, m.Origin); err != nil {
+			return res, fmt.Errorf("message on %s: %w", m.Topic, err)
+		}
+		res.Messages++
+	}
+	return res, nil
+}
+
+func importSubscription(s Store, sub DumpSubscription) error {
+	if err := s.AddSubscription(sub.Topic, sub.Token, sub.Provider, sub.Username); err != nil {
+		return err
+	}
+	if sub.Options != nil {
+		if err := s.SetSubscriptionOptions(sub.Topic, sub.Token, sub.Options); err != nil {
+			return err
+		}
+	}
+	if sub.Locale != "" {
+		if err := s.SetSubscriptionLocale(sub.Topic, sub.Token, sub.Locale); err != nil {
+			return err
+		}
+	}
+	if sub.Platform != "" || sub.AppVersion != "" || len(sub.Tags) > 0 {
+		return s.SetSubscriptionAttributes(sub.Topic, sub.Token, sub.Platform, sub.AppVersion, sub.Tags)
+	}
+	return nil
+}
+
+func subscribed(subs []Subscriber, topic string) bool {
+	for _, s := range subs {
+		if s.Topic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDump checks the whole dump before anything is written.
+func validateDump(d *Dump) error {
+	if d.Version != DumpVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidDump, d.Version)
+	}
+	for _, t := range d.Topics {
+		if t.Name == "" {
+			return fmt.Errorf("%w: topic without a name", ErrInvalidDump)
+		}
+	}
+	for _, u := range d.Users {
+		if u.Username == "" || u.PasswordHash == "" || u.Role == "" {
+			return fmt.Errorf("%w: user %q needs a username, password_hash and role", ErrInvalidDump, u.Username)
+		}
+	}
+	for _, sub := range d.Subscriptions {
+		if sub.Topic == "" || sub.Token == "" || sub.Provider == "" {
+			return fmt.Errorf("%w: subscription needs a topic, token and provider", ErrInvalidDump)
+		}
+	}
+	for _, m := range d.Messages {
+		if m.Topic == "" || !json.Valid(m.Payload) {
+			return fmt.Errorf("%w: message needs a topic and a JSON payload", ErrInvalidDump)
+		}
+	}
+	for _, t := range d.Templates {
+		if t.Topic == "" || t.Name == "" {
+			return fmt.Errorf("%w: template needs a topic and a name", ErrInvalidDump)
+		}
+	}
+	for _, r := range d.Roles {
+		if r.Name == "" {
+			return fmt.Errorf("%w: role without a name", ErrInvalidDump)
+		}
+	}
+	return nil
+}
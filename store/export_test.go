@@ -0,0 +1,122 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	src := NewMemoryStore()
+	src.CreateTopic("news")
+	src.SetTopicSchema("news", `{"type":"object"}`)
+	src.SetTopicApprovalThreshold("news", 100)
+	src.SaveTemplate(Template{Topic: "news", Name: "alert", Locale: "fr", Body: `{"t":"{{.title}}"}`})
+	src.SaveRole(Role{Name: "editor", Permissions: []string{"publish:news"}})
+	src.CreateUser("alice", "$2a$10$hash", "editor")
+	src.AddSubscription("news", "tok", "webhook", "alice")
+	src.SetSubscriptionOptions("news", "tok", &WebhookOptions{Method: "PUT"})
+	src.SetSubscriptionLocale("news", "tok", "fr")
+	src.SetSubscriptionAttributes("news", "tok", "ios", "2.1.0", []string{"beta"})
+	src.SaveMessage("news", []byte(`{"n":1}`))
+	src.SaveMessageFrom("news", []byte(`{"n":2}`), MessageOrigin{Publisher: "alice", IP: "10.0.0.1"})
+
+	d, err := Export(src, ExportOptions{Messages: 10})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	// The dump survives a JSON round trip
+	data, _ := json.Marshal(d)
+	var decoded Dump
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	forEachBackend(t, func(t *testing.T, s Store) {
+		res, err := Import(s, &decoded)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		want := ImportResult{Topics: 1, Templates: 1, Roles: 1, Users: 1, Subscriptions: 1, Messages: 2}
+		if res != want {
+			t.Errorf("Expected %+v, got %+v", want, res)
+		}
+
+		if schema, _ := s.GetTopicSchema("news"); schema != `{"type":"object"}` {
+			t.Errorf("Unexpected schema %q", schema)
+		}
+		if n, _ := s.GetTopicApprovalThreshold("news"); n != 100 {
+			t.Errorf("Expected threshold 100, got %d", n)
+		}
+		if tpl, _ := s.GetTemplate("news", "alert", "fr"); tpl == nil {
+			t.Error("Expected template to be imported")
+		}
+		if r, _ := s.GetRole("editor"); r == nil || !reflect.DeepEqual(r.Permissions, []string{"publish:news"}) {
+			t.Errorf("Unexpected role %+v", r)
+		}
+		if u, _ := s.GetUser("alice"); u == nil || u.PasswordHash != "$2a$10$hash" || u.Role != "editor" {
+			t.Errorf("Unexpected user %+v", u)
+		}
+		subs, _ := s.GetSubscribers("news")
+		if len(subs) != 1 {
+			t.Fatalf("Expected 1 subscriber, got %v", subs)
+		}
+		sub := subs[0]
+		if sub.Username != "alice" || sub.Locale != "fr" || sub.Platform != "ios" || sub.AppVersion != "2.1.0" ||
+			!reflect.DeepEqual(sub.Tags, []string{"beta"}) || sub.Options == nil || sub.Options.Method != "PUT" {
+			t.Errorf("Unexpected subscriber %+v", sub)
+		}
+		msgs, _ := s.GetRecentMessages("news", 10)
+		if len(msgs) != 2 || string(msgs[0].Payload) != `{"n":2}` {
+			t.Errorf("Expected messages in original order, got %v", msgs)
+		} else if msgs[0].Origin.Publisher != "alice" || msgs[0].Origin.IP != "10.0.0.1" || msgs[1].Origin != (MessageOrigin{}) {
+			t.Errorf("Expected message origins to be kept, got %+v", msgs)
+		}
+
+		// Importing again changes nothing but the message history
+		res, err = Import(s, &decoded)
+		if err != nil {
+			t.Fatalf("Second import failed: %v", err)
+		}
+		if res.Topics != 0 || res.Users != 0 || res.Subscriptions != 0 || res.Skipped != 3 {
+			t.Errorf("Expected existing entries to be skipped, got %+v", res)
+		}
+	})
+}
+
+func TestExportWithoutMessages(t *testing.T) {
+	s := NewMemoryStore()
+	s.CreateTopic("news")
+	s.SaveMessage("news", []byte(`{}`))
+
+	d, err := Export(s, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(d.Messages) != 0 {
+		t.Errorf("Expected no messages, got %v", d.Messages)
+	}
+	if d.Version != DumpVersion || len(d.Topics) != 1 {
+		t.Errorf("Unexpected dump %+v", d)
+	}
+}
+
+func TestImportRejectsInvalidDump(t *testing.T) {
+	s := NewMemoryStore()
+	for name, d := range map[string]*Dump{
+		"version":  {Version: 99},
+		"topic":    {Version: DumpVersion, Topics: []DumpTopic{{Name: "news"}, {}}},
+		"user":     {Version: DumpVersion, Users: []DumpUser{{Username: "bob", Role: "admin"}}},
+		"message":  {Version: DumpVersion, Messages: []DumpMessage{{Topic: "news", Payload: json.RawMessage(`{`)}}},
+		"template": {Version: DumpVersion, Templates: []Template{{Name: "alert"}}},
+	} {
+		if _, err := Import(s, d); !errors.Is(err, ErrInvalidDump) {
+			t.Errorf("%s: expected ErrInvalidDump, got %v", name, err)
+		}
+	}
+	// Validation happens before anything is written
+	if topics, _ := s.ListTopics(); len(topics) != 0 {
+		t.Errorf("Expected nothing imported, got %v", topics)
+	}
+}
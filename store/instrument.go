@@ -0,0 +1,708 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"no-spam/errtrack"
+)
+
+// InstrumentedStore wraps a Store and records call counts, latency, row
+// counts and errors per method, so a slow or failing backend shows up in
+// GET /admin/store/stats instead of having to be guessed at.
+type InstrumentedStore struct {
+	next Store
+	slow time.Duration // Calls taking longer are logged; 0 disables
+
+	mu    sync.Mutex
+	stats map[string]*methodStats
+}
+
+// MethodStats is a snapshot of one Store method's counters.
+type MethodStats struct {
+	Method    string  `json:"method"`
+	Calls     int64   `json:"calls"`
+	Errors    int64   `json:"errors"`
+	Rows      int64   `json:"rows"` // Rows returned or affected, for methods that report them
+	Slow      int64   `json:"slow"` // Calls slower than the slow query threshold
+	AvgMs     float64 `json:"avg_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+type methodStats struct {
+	calls, errors, rows, slow int64
+	total, max                time.Duration
+	lastError                 string
+}
+
+// Instrument wraps s, logging calls that take longer than slow.
+func Instrument(s Store, slow time.Duration) *InstrumentedStore {
+	return &InstrumentedStore{next: s, slow: slow, stats: map[string]*methodStats{}}
+}
+
+// Unwrap returns the underlying Store.
+func (s *InstrumentedStore) Unwrap() Store {
+	return s.next
+}
+
+// Snapshot returns the counters of every method called so far, sorted by name.
+func (s *InstrumentedStore) Snapshot() []MethodStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make([]MethodStats, 0, len(s.stats))
+	for method, m := range s.stats {
+		snapshot = append(snapshot, MethodStats{
+			Method:    method,
+			Calls:     m.calls,
+			Errors:    m.errors,
+			Rows:      m.rows,
+			Slow:      m.slow,
+			AvgMs:     milliseconds(m.total / time.Duration(m.calls)),
+			MaxMs:     milliseconds(m.max),
+			LastError: m.lastError,
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Method < snapshot[j].Method })
+	return snapshot
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (s *InstrumentedStore) record(method string, start time.Time, rows int64, err error) {
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	m, ok := s.stats[method]
+	if !ok {
+		m = &methodStats{}
+		s.stats[method] = m
+	}
+	m.calls++
+	m.rows += rows
+	m.total += elapsed
+	if elapsed > m.max {
+		m.max = elapsed
+	}
+	if err != nil {
+		m.errors++
+		m.lastError = err.Error()
+	}
+	slow := s.slow > 0 && elapsed > s.slow
+	if slow {
+		m.slow++
+	}
+	s.mu.Unlock()
+
+	if slow {
+		log.Printf("[Store] Slow %s took %s", method, elapsed)
+	}
+	if err != nil && !expectedError(err) {
+		errtrack.Capture(err, "store."+method, map[string]string{"store_method": method})
+	}
+}
+
+// expectedError reports whether err is an outcome callers handle, such as
+// a missing row, rather than a failure of the backend.
+func expectedError(err error) bool {
+	for _, target := range []error{ErrNotFound, ErrDuplicate, ErrInUse, ErrLastAdmin, ErrLimitReached, ErrInvalidBackup, ErrInvalidDump} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe times a method that returns only an error.
+func observe(s *InstrumentedStore, method string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	s.record(method, start, 0, err)
+	return err
+}
+
+// observeValue times a method returning a single value.
+func observeValue[T any](s *InstrumentedStore, method string, fn func() (T, error)) (T, error) {
+	start := time.Now()
+	v, err := fn()
+	s.record(method, start, 0, err)
+	return v, err
+}
+
+// observeRows times a method returning a list, counting its rows.
+func observeRows[T any](s *InstrumentedStore, method string, fn func() ([]T, error)) ([]T, error) {
+	start := time.Now()
+	v, err := fn()
+	s.record(method, start, int64(len(v)), err)
+	return v, err
+}
+
+// Topics
+func (s *InstrumentedStore) CreateTopic(name string) error {
+	return observe(s, "CreateTopic", func() error { return s.next.CreateTopic(name) })
+}
+
+func (s *InstrumentedStore) CreateOwnedTopic(name, owner, prefix string, limit int) (int, error) {
+	return observeValue(s, "CreateOwnedTopic", func() (int, error) { return s.next.CreateOwnedTopic(name, owner, prefix, limit) })
+}
+
+func (s *InstrumentedStore) DeleteTopic(name string) error {
+	return observe(s, "DeleteTopic", func() error { return s.next.DeleteTopic(name) })
+}
+
+func (s *InstrumentedStore) TopicExists(name string) (bool, error) {
+	return observeValue(s, "TopicExists", func() (bool, error) { return s.next.TopicExists(name) })
+}
+
+func (s *InstrumentedStore) GetTopicInfo(name string) (*TopicInfo, error) {
+	return observeValue(s, "GetTopicInfo", func() (*TopicInfo, error) { return s.next.GetTopicInfo(name) })
+}
+
+func (s *InstrumentedStore) ListTopicInfo() ([]TopicInfo, error) {
+	return observeRows(s, "ListTopicInfo", s.next.ListTopicInfo)
+}
+
+func (s *InstrumentedStore) SetTopicInfo(info TopicInfo) error {
+	return observe(s, "SetTopicInfo", func() error { return s.next.SetTopicInfo(info) })
+}
+
+func (s *InstrumentedStore) RenameTopic(oldName, newName string, alias bool) error {
+	return observe(s, "RenameTopic", func() error { return s.next.RenameTopic(oldName, newName, alias) })
+}
+
+func (s *InstrumentedStore) ResolveTopicAlias(name string) (string, error) {
+	return observeValue(s, "ResolveTopicAlias", func() (string, error) { return s.next.ResolveTopicAlias(name) })
+}
+
+func (s *InstrumentedStore) ListTopicAliases() ([]TopicAlias, error) {
+	return observeRows(s, "ListTopicAliases", s.next.ListTopicAliases)
+}
+
+func (s *InstrumentedStore) DeleteTopicAlias(alias string) (bool, error) {
+	return observeValue(s, "DeleteTopicAlias", func() (bool, error) { return s.next.DeleteTopicAlias(alias) })
+}
+
+func (s *InstrumentedStore) ListTopics() ([]string, error) {
+	return observeRows(s, "ListTopics", s.next.ListTopics)
+}
+
+func (s *InstrumentedStore) SetTopicSchema(name, schema string) error {
+	return observe(s, "SetTopicSchema", func() error { return s.next.SetTopicSchema(name, schema) })
+}
+
+func (s *InstrumentedStore) GetTopicSchema(name string) (string, error) {
+	return observeValue(s, "GetTopicSchema", func() (string, error) { return s.next.GetTopicSchema(name) })
+}
+
+func (s *InstrumentedStore) SetTopicApprovalThreshold(name string, threshold int) error {
+	return observe(s, "SetTopicApprovalThreshold", func() error { return s.next.SetTopicApprovalThreshold(name, threshold) })
+}
+
+func (s *InstrumentedStore) GetTopicApprovalThreshold(name string) (int, error) {
+	return observeValue(s, "GetTopicApprovalThreshold", func() (int, error) { return s.next.GetTopicApprovalThreshold(name) })
+}
+
+// Templates
+func (s *InstrumentedStore) SaveTemplate(t Template) error {
+	return observe(s, "SaveTemplate", func() error { return s.next.SaveTemplate(t) })
+}
+
+func (s *InstrumentedStore) GetTemplate(topic, name, locale string) (*Template, error) {
+	return observeValue(s, "GetTemplate", func() (*Template, error) { return s.next.GetTemplate(topic, name, locale) })
+}
+
+func (s *InstrumentedStore) ListTemplates(topic string) ([]Template, error) {
+	return observeRows(s, "ListTemplates", func() ([]Template, error) { return s.next.ListTemplates(topic) })
+}
+
+func (s *InstrumentedStore) DeleteTemplate(topic, name, locale string) error {
+	return observe(s, "DeleteTemplate", func() error { return s.next.DeleteTemplate(topic, name, locale) })
+}
+
+// Content filtering
+func (s *InstrumentedStore) CreateFilterRule(r FilterRule) (int64, error) {
+	return observeValue(s, "CreateFilterRule", func() (int64, error) { return s.next.CreateFilterRule(r) })
+}
+
+func (s *InstrumentedStore) ListFilterRules() ([]FilterRule, error) {
+	return observeRows(s, "ListFilterRules", s.next.ListFilterRules)
+}
+
+func (s *InstrumentedStore) DeleteFilterRule(id int64) (bool, error) {
+	return observeValue(s, "DeleteFilterRule", func() (bool, error) { return s.next.DeleteFilterRule(id) })
+}
+
+func (s *InstrumentedStore) AddModerationEntry(e ModerationEntry) error {
+	return observe(s, "AddModerationEntry", func() error { return s.next.AddModerationEntry(e) })
+}
+
+func (s *InstrumentedStore) ListModerationEntries(limit int) ([]ModerationEntry, error) {
+	return observeRows(s, "ListModerationEntries", func() ([]ModerationEntry, error) { return s.next.ListModerationEntries(limit) })
+}
+
+// Approvals
+func (s *InstrumentedStore) HoldMessage(a Approval) error {
+	return observe(s, "HoldMessage", func() error { return s.next.HoldMessage(a) })
+}
+
+func (s *InstrumentedStore) ListApprovals(status string) ([]Approval, error) {
+	return observeRows(s, "ListApprovals", func() ([]Approval, error) { return s.next.ListApprovals(status) })
+}
+
+func (s *InstrumentedStore) DecideApproval(messageID int64, status, decidedBy string) (*Approval, error) {
+	return observeValue(s, "DecideApproval", func() (*Approval, error) { return s.next.DecideApproval(messageID, status, decidedBy) })
+}
+
+// Schedules
+func (s *InstrumentedStore) CreateSchedule(sc Schedule) (int64, error) {
+	return observeValue(s, "CreateSchedule", func() (int64, error) { return s.next.CreateSchedule(sc) })
+}
+
+func (s *InstrumentedStore) GetSchedule(id int64) (*Schedule, error) {
+	return observeValue(s, "GetSchedule", func() (*Schedule, error) { return s.next.GetSchedule(id) })
+}
+
+func (s *InstrumentedStore) ListSchedules(topic string) ([]Schedule, error) {
+	return observeRows(s, "ListSchedules", func() ([]Schedule, error) { return s.next.ListSchedules(topic) })
+}
+
+func (s *InstrumentedStore) SetSchedulePaused(id int64, paused bool, nextRun time.Time) (bool, error) {
+	return observeValue(s, "SetSchedulePaused", func() (bool, error) { return s.next.SetSchedulePaused(id, paused, nextRun) })
+}
+
+func (s *InstrumentedStore) DeleteSchedule(id int64) (bool, error) {
+	return observeValue(s, "DeleteSchedule", func() (bool, error) { return s.next.DeleteSchedule(id) })
+}
+
+func (s *InstrumentedStore) DueSchedules(now time.Time) ([]Schedule, error) {
+	return observeRows(s, "DueSchedules", func() ([]Schedule, error) { return s.next.DueSchedules(now) })
+}
+
+func (s *InstrumentedStore) AdvanceSchedule(id int64, from, to time.Time) (bool, error) {
+	return observeValue(s, "AdvanceSchedule", func() (bool, error) { return s.next.AdvanceSchedule(id, from, to) })
+}
+
+func (s *InstrumentedStore) AddScheduleRun(r ScheduleRun) error {
+	return observe(s, "AddScheduleRun", func() error { return s.next.AddScheduleRun(r) })
+}
+
+func (s *InstrumentedStore) ListScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) {
+	return observeRows(s, "ListScheduleRuns", func() ([]ScheduleRun, error) { return s.next.ListScheduleRuns(scheduleID, limit) })
+}
+
+// Drafts
+func (s *InstrumentedStore) CreateDraft(d Draft) (int64, error) {
+	return observeValue(s, "CreateDraft", func() (int64, error) { return s.next.CreateDraft(d) })
+}
+
+func (s *InstrumentedStore) GetDraft(id int64) (*Draft, error) {
+	return observeValue(s, "GetDraft", func() (*Draft, error) { return s.next.GetDraft(id) })
+}
+
+func (s *InstrumentedStore) ListDrafts(topic string) ([]Draft, error) {
+	return observeRows(s, "ListDrafts", func() ([]Draft, error) { return s.next.ListDrafts(topic) })
+}
+
+func (s *InstrumentedStore) UpdateDraft(id int64, request json.RawMessage) (bool, error) {
+	return observeValue(s, "UpdateDraft", func() (bool, error) { return s.next.UpdateDraft(id, request) })
+}
+
+func (s *InstrumentedStore) DeleteDraft(id int64) (bool, error) {
+	return observeValue(s, "DeleteDraft", func() (bool, error) { return s.next.DeleteDraft(id) })
+}
+
+func (s *InstrumentedStore) SetDraftStatus(id int64, from, to string, messageID int64) (bool, error) {
+	return observeValue(s, "SetDraftStatus", func() (bool, error) { return s.next.SetDraftStatus(id, from, to, messageID) })
+}
+
+// Audit log
+func (s *InstrumentedStore) AddAuditEvent(e AuditEvent) error {
+	return observe(s, "AddAuditEvent", func() error { return s.next.AddAuditEvent(e) })
+}
+
+func (s *InstrumentedStore) ListAuditEvents(f AuditFilter) ([]AuditEvent, error) {
+	return observeRows(s, "ListAuditEvents", func() ([]AuditEvent, error) { return s.next.ListAuditEvents(f) })
+}
+
+// Subscriptions
+func (s *InstrumentedStore) AddSubscription(topic, token, provider, username string) error {
+	return observe(s, "AddSubscription", func() error { return s.next.AddSubscription(topic, token, provider, username) })
+}
+
+func (s *InstrumentedStore) RemoveSubscription(topic, token string) error {
+	return observe(s, "RemoveSubscription", func() error { return s.next.RemoveSubscription(topic, token) })
+}
+
+func (s *InstrumentedStore) ClearTopicSubscribers(topic string) error {
+	return observe(s, "ClearTopicSubscribers", func() error { return s.next.ClearTopicSubscribers(topic) })
+}
+
+func (s *InstrumentedStore) GetSubscribers(topic string) ([]Subscriber, error) {
+	return observeRows(s, "GetSubscribers", func() ([]Subscriber, error) { return s.next.GetSubscribers(topic) })
+}
+
+func (s *InstrumentedStore) ListSubscribersAfter(topic, after string, limit int) ([]Subscriber, error) {
+	return observeRows(s, "ListSubscribersAfter", func() ([]Subscriber, error) { return s.next.ListSubscribersAfter(topic, after, limit) })
+}
+
+func (s *InstrumentedStore) GetSubscriptionsByUser(username string) ([]Subscriber, error) {
+	return observeRows(s, "GetSubscriptionsByUser", func() ([]Subscriber, error) { return s.next.GetSubscriptionsByUser(username) })
+}
+
+func (s *InstrumentedStore) GetSubscriptionsByToken(token string) ([]Subscriber, error) {
+	return observeRows(s, "GetSubscriptionsByToken", func() ([]Subscriber, error) { return s.next.GetSubscriptionsByToken(token) })
+}
+
+func (s *InstrumentedStore) CountSubscriptions(topic, username string) (int, error) {
+	return observeValue(s, "CountSubscriptions", func() (int, error) { return s.next.CountSubscriptions(topic, username) })
+}
+
+func (s *InstrumentedStore) GetSubscriptionCount() (int, error) {
+	return observeValue(s, "GetSubscriptionCount", s.next.GetSubscriptionCount)
+}
+
+func (s *InstrumentedStore) SetSubscriptionOptions(topic, token string, opts *WebhookOptions) error {
+	return observe(s, "SetSubscriptionOptions", func() error { return s.next.SetSubscriptionOptions(topic, token, opts) })
+}
+
+func (s *InstrumentedStore) SetSubscriptionLocale(topic, token, locale string) error {
+	return observe(s, "SetSubscriptionLocale", func() error { return s.next.SetSubscriptionLocale(topic, token, locale) })
+}
+
+func (s *InstrumentedStore) SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
+	return observe(s, "SetSubscriptionAttributes", func() error {
+		return s.next.SetSubscriptionAttributes(topic, token, platform, appVersion, tags)
+	})
+}
+
+func (s *InstrumentedStore) UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error) {
+	return observeValue(s, "UpdateSubscriptionTags", func() ([]string, error) {
+		return s.next.UpdateSubscriptionTags(topic, token, add, remove)
+	})
+}
+
+func (s *InstrumentedStore) RemoveSubscriptionsByTag(username, tag string) (int64, error) {
+	start := time.Now()
+	n, err := s.next.RemoveSubscriptionsByTag(username, tag)
+	s.record("RemoveSubscriptionsByTag", start, n, err)
+	return n, err
+}
+
+func (s *InstrumentedStore) ReassignSubscriptions(from, to string) (int64, error) {
+	start := time.Now()
+	n, err := s.next.ReassignSubscriptions(from, to)
+	s.record("ReassignSubscriptions", start, n, err)
+	return n, err
+}
+
+func (s *InstrumentedStore) SetSubscriptionPreferences(topic, token string, prefs *Preferences) error {
+	return observe(s, "SetSubscriptionPreferences", func() error { return s.next.SetSubscriptionPreferences(topic, token, prefs) })
+}
+
+func (s *InstrumentedStore) CountQueuedSince(topic string, tokens []string, since time.Time) (map[string]int, error) {
+	return observeValue(s, "CountQueuedSince", func() (map[string]int, error) { return s.next.CountQueuedSince(topic, tokens, since) })
+}
+
+func (s *InstrumentedStore) MarkTokensSeen(tokens []string, at time.Time) error {
+	return observe(s, "MarkTokensSeen", func() error { return s.next.MarkTokensSeen(tokens, at) })
+}
+
+func (s *InstrumentedStore) ListStaleSubscriptions(before time.Time, limit int) ([]Subscriber, error) {
+	return observeRows(s, "ListStaleSubscriptions", func() ([]Subscriber, error) { return s.next.ListStaleSubscriptions(before, limit) })
+}
+
+func (s *InstrumentedStore) RemoveStaleSubscriptions(before time.Time) (int64, error) {
+	start := time.Now()
+	n, err := s.next.RemoveStaleSubscriptions(before)
+	s.record("RemoveStaleSubscriptions", start, n, err)
+	return n, err
+}
+
+// Users
+func (s *InstrumentedStore) CreateUser(username, passwordHash, role string) error {
+	return observe(s, "CreateUser", func() error { return s.next.CreateUser(username, passwordHash, role) })
+}
+
+func (s *InstrumentedStore) DeleteUser(username string) error {
+	return observe(s, "DeleteUser", func() error { return s.next.DeleteUser(username) })
+}
+
+func (s *InstrumentedStore) ListUsers() ([]User, error) {
+	return observeRows(s, "ListUsers", s.next.ListUsers)
+}
+
+func (s *InstrumentedStore) GetUser(username string) (*User, error) {
+	return observeValue(s, "GetUser", func() (*User, error) { return s.next.GetUser(username) })
+}
+
+func (s *InstrumentedStore) HasAdminUser() (bool, error) {
+	return observeValue(s, "HasAdminUser", s.next.HasAdminUser)
+}
+
+func (s *InstrumentedStore) UpdateUserRole(username, role string) error {
+	return observe(s, "UpdateUserRole", func() error { return s.next.UpdateUserRole(username, role) })
+}
+
+func (s *InstrumentedStore) SetUserPassword(username, passwordHash string) error {
+	return observe(s, "SetUserPassword", func() error { return s.next.SetUserPassword(username, passwordHash) })
+}
+
+func (s *InstrumentedStore) SetMustChangePassword(username string, required bool) error {
+	return observe(s, "SetMustChangePassword", func() error { return s.next.SetMustChangePassword(username, required) })
+}
+
+func (s *InstrumentedStore) SetUserDisabled(username string, disabled bool) error {
+	return observe(s, "SetUserDisabled", func() error { return s.next.SetUserDisabled(username, disabled) })
+}
+
+func (s *InstrumentedStore) SetUserAccess(username, role string, disabled bool) error {
+	return observe(s, "SetUserAccess", func() error { return s.next.SetUserAccess(username, role, disabled) })
+}
+
+func (s *InstrumentedStore) SetUserProfile(username, displayName, email string) error {
+	return observe(s, "SetUserProfile", func() error { return s.next.SetUserProfile(username, displayName, email) })
+}
+
+func (s *InstrumentedStore) LinkUser(username, externalID string) (bool, error) {
+	return observeValue(s, "LinkUser", func() (bool, error) { return s.next.LinkUser(username, externalID) })
+}
+
+func (s *InstrumentedStore) IncrementUnread(usernames []string) (map[string]int, error) {
+	return observeValue(s, "IncrementUnread", func() (map[string]int, error) { return s.next.IncrementUnread(usernames) })
+}
+
+func (s *InstrumentedStore) SetUnread(username string, count int) error {
+	return observe(s, "SetUnread", func() error { return s.next.SetUnread(username, count) })
+}
+
+func (s *InstrumentedStore) SetUserTOTP(username, secret string, enabled bool) error {
+	return observe(s, "SetUserTOTP", func() error { return s.next.SetUserTOTP(username, secret, enabled) })
+}
+
+func (s *InstrumentedStore) SetRecoveryCodes(username string, hashes []string) error {
+	return observe(s, "SetRecoveryCodes", func() error { return s.next.SetRecoveryCodes(username, hashes) })
+}
+
+func (s *InstrumentedStore) UseRecoveryCode(username, hash string) (bool, error) {
+	return observeValue(s, "UseRecoveryCode", func() (bool, error) { return s.next.UseRecoveryCode(username, hash) })
+}
+
+func (s *InstrumentedStore) UseTOTPStep(username string, step int64) (bool, error) {
+	return observeValue(s, "UseTOTPStep", func() (bool, error) { return s.next.UseTOTPStep(username, step) })
+}
+
+func (s *InstrumentedStore) AddTOTPFailure(username string) (int, error) {
+	return observeValue(s, "AddTOTPFailure", func() (int, error) { return s.next.AddTOTPFailure(username) })
+}
+
+// Invitations
+func (s *InstrumentedStore) CreateInvitation(inv Invitation) error {
+	return observe(s, "CreateInvitation", func() error { return s.next.CreateInvitation(inv) })
+}
+
+func (s *InstrumentedStore) ListInvitations() ([]Invitation, error) {
+	return observeRows(s, "ListInvitations", s.next.ListInvitations)
+}
+
+func (s *InstrumentedStore) DeleteInvitation(code string) (bool, error) {
+	return observeValue(s, "DeleteInvitation", func() (bool, error) { return s.next.DeleteInvitation(code) })
+}
+
+func (s *InstrumentedStore) RedeemInvitation(code, username, passwordHash string) (*Invitation, error) {
+	return observeValue(s, "RedeemInvitation", func() (*Invitation, error) {
+		return s.next.RedeemInvitation(code, username, passwordHash)
+	})
+}
+
+// Roles
+func (s *InstrumentedStore) SaveRole(r Role) error {
+	return observe(s, "SaveRole", func() error { return s.next.SaveRole(r) })
+}
+
+func (s *InstrumentedStore) GetRole(name string) (*Role, error) {
+	return observeValue(s, "GetRole", func() (*Role, error) { return s.next.GetRole(name) })
+}
+
+func (s *InstrumentedStore) ListRoles() ([]Role, error) {
+	return observeRows(s, "ListRoles", s.next.ListRoles)
+}
+
+func (s *InstrumentedStore) DeleteRole(name string) (bool, error) {
+	return observeValue(s, "DeleteRole", func() (bool, error) { return s.next.DeleteRole(name) })
+}
+
+// Save Message
+func (s *InstrumentedStore) SaveMessage(topic string, payload []byte) (int64, error) {
+	return observeValue(s, "SaveMessage", func() (int64, error) { return s.next.SaveMessage(topic, payload) })
+}
+
+func (s *InstrumentedStore) SaveMessageFrom(topic string, payload []byte, origin MessageOrigin) (int64, error) {
+	return observeValue(s, "SaveMessageFrom", func() (int64, error) { return s.next.SaveMessageFrom(topic, payload, origin) })
+}
+
+func (s *InstrumentedStore) SaveSequencedMessage(topic string, origin MessageOrigin, seal func(seq int64) ([]byte, error)) (int64, error) {
+	return observeValue(s, "SaveSequencedMessage", func() (int64, error) { return s.next.SaveSequencedMessage(topic, origin, seal) })
+}
+
+func (s *InstrumentedStore) SequenceMessage(id int64, seal func(seq int64) ([]byte, error)) error {
+	return observe(s, "SequenceMessage", func() error { return s.next.SequenceMessage(id, seal) })
+}
+
+func (s *InstrumentedStore) GetMessage(id int64) (*Message, error) {
+	return observeValue(s, "GetMessage", func() (*Message, error) { return s.next.GetMessage(id) })
+}
+
+func (s *InstrumentedStore) GetRecentMessages(topic string, limit int) ([]Message, error) {
+	return observeRows(s, "GetRecentMessages", func() ([]Message, error) { return s.next.GetRecentMessages(topic, limit) })
+}
+
+func (s *InstrumentedStore) SearchMessages(f MessageSearch) ([]Message, error) {
+	return observeRows(s, "SearchMessages", func() ([]Message, error) { return s.next.SearchMessages(f) })
+}
+
+func (s *InstrumentedStore) DeleteMessagesBefore(topic string, t time.Time) (int64, error) {
+	return observeValue(s, "DeleteMessagesBefore", func() (int64, error) { return s.next.DeleteMessagesBefore(topic, t) })
+}
+
+func (s *InstrumentedStore) ClearTopicMessages(topic string) error {
+	return observe(s, "ClearTopicMessages", func() error { return s.next.ClearTopicMessages(topic) })
+}
+
+// Queue
+func (s *InstrumentedStore) EnqueueMessage(messageID int64, token string) (int64, error) {
+	return observeValue(s, "EnqueueMessage", func() (int64, error) { return s.next.EnqueueMessage(messageID, token) })
+}
+
+func (s *InstrumentedStore) EnqueueMessages(messageID int64, entries []QueueEntry) ([]int64, error) {
+	return observeValue(s, "EnqueueMessages", func() ([]int64, error) { return s.next.EnqueueMessages(messageID, entries) })
+}
+
+func (s *InstrumentedStore) EnqueueMessagePayload(messageID int64, token string, payload []byte) (int64, error) {
+	return observeValue(s, "EnqueueMessagePayload", func() (int64, error) {
+		return s.next.EnqueueMessagePayload(messageID, token, payload)
+	})
+}
+
+func (s *InstrumentedStore) GetPendingMessages(token string) ([]QueueItem, error) {
+	return observeRows(s, "GetPendingMessages", func() ([]QueueItem, error) { return s.next.GetPendingMessages(token) })
+}
+
+func (s *InstrumentedStore) GetAllPendingMessages() ([]QueueItem, error) {
+	return observeRows(s, "GetAllPendingMessages", s.next.GetAllPendingMessages)
+}
+
+func (s *InstrumentedStore) GetPendingMessagesByTopic(topic string) ([]QueueItem, error) {
+	return observeRows(s, "GetPendingMessagesByTopic", func() ([]QueueItem, error) { return s.next.GetPendingMessagesByTopic(topic) })
+}
+
+func (s *InstrumentedStore) CountPending(topic string) (int64, error) {
+	return observeValue(s, "CountPending", func() (int64, error) { return s.next.CountPending(topic) })
+}
+
+func (s *InstrumentedStore) GetQueueItemsByMessage(messageID int64) ([]QueueItem, error) {
+	return observeRows(s, "GetQueueItemsByMessage", func() ([]QueueItem, error) { return s.next.GetQueueItemsByMessage(messageID) })
+}
+
+func (s *InstrumentedStore) ListQueueOutcomes(since time.Time) ([]QueueItem, error) {
+	return observeRows(s, "ListQueueOutcomes", func() ([]QueueItem, error) { return s.next.ListQueueOutcomes(since) })
+}
+
+func (s *InstrumentedStore) MarkDelivered(queueID int64) error {
+	return observe(s, "MarkDelivered", func() error { return s.next.MarkDelivered(queueID) })
+}
+
+func (s *InstrumentedStore) MarkOpened(messageID int64, token string) (bool, error) {
+	return observeValue(s, "MarkOpened", func() (bool, error) { return s.next.MarkOpened(messageID, token) })
+}
+
+func (s *InstrumentedStore) ListMessageOpens(limit int) ([]MessageOpens, error) {
+	return observeRows(s, "ListMessageOpens", func() ([]MessageOpens, error) { return s.next.ListMessageOpens(limit) })
+}
+
+func (s *InstrumentedStore) RecordAttempt(a Attempt) error {
+	return observe(s, "RecordAttempt", func() error { return s.next.RecordAttempt(a) })
+}
+
+func (s *InstrumentedStore) SettleAttempts(attempts []Attempt) error {
+	return observe(s, "SettleAttempts", func() error { return s.next.SettleAttempts(attempts) })
+}
+
+func (s *InstrumentedStore) ListAttempts(topic string, queueID int64) ([]Attempt, error) {
+	return observeRows(s, "ListAttempts", func() ([]Attempt, error) { return s.next.ListAttempts(topic, queueID) })
+}
+
+func (s *InstrumentedStore) ProviderStats(since time.Time) ([]ProviderStats, error) {
+	return observeRows(s, "ProviderStats", func() ([]ProviderStats, error) { return s.next.ProviderStats(since) })
+}
+
+func (s *InstrumentedStore) MarkFailed(queueID int64) error {
+	return observe(s, "MarkFailed", func() error { return s.next.MarkFailed(queueID) })
+}
+
+func (s *InstrumentedStore) CancelQueueItem(topic string, queueID int64) (bool, error) {
+	return observeValue(s, "CancelQueueItem", func() (bool, error) { return s.next.CancelQueueItem(topic, queueID) })
+}
+
+func (s *InstrumentedStore) RequeueQueueItem(topic string, queueID int64) (bool, error) {
+	return observeValue(s, "RequeueQueueItem", func() (bool, error) { return s.next.RequeueQueueItem(topic, queueID) })
+}
+
+func (s *InstrumentedStore) PurgeQueue(topic, token string) (int64, error) {
+	return observeValue(s, "PurgeQueue", func() (int64, error) { return s.next.PurgeQueue(topic, token) })
+}
+
+func (s *InstrumentedStore) ClaimQueueItem(queueID int64, nodeID string, lease time.Duration) (bool, error) {
+	return observeValue(s, "ClaimQueueItem", func() (bool, error) { return s.next.ClaimQueueItem(queueID, nodeID, lease) })
+}
+
+func (s *InstrumentedStore) ClaimQueueItems(queueIDs []int64, nodeID string, lease time.Duration) ([]int64, error) {
+	return observeValue(s, "ClaimQueueItems", func() ([]int64, error) { return s.next.ClaimQueueItems(queueIDs, nodeID, lease) })
+}
+
+// Stats
+func (s *InstrumentedStore) GetTotalMessagesSent() (int64, error) {
+	return observeValue(s, "GetTotalMessagesSent", s.next.GetTotalMessagesSent)
+}
+
+func (s *InstrumentedStore) RecordStats(bucket time.Time, counts, peaks map[string]int64) error {
+	return observe(s, "RecordStats", func() error { return s.next.RecordStats(bucket, counts, peaks) })
+}
+
+func (s *InstrumentedStore) ListStats(from, to time.Time) ([]StatPoint, error) {
+	return observeRows(s, "ListStats", func() ([]StatPoint, error) { return s.next.ListStats(from, to) })
+}
+
+func (s *InstrumentedStore) DeleteStatsBefore(t time.Time) (int64, error) {
+	return observeValue(s, "DeleteStatsBefore", func() (int64, error) { return s.next.DeleteStatsBefore(t) })
+}
+
+func (s *InstrumentedStore) CreateSession(sess Session) error {
+	return observe(s, "CreateSession", func() error { return s.next.CreateSession(sess) })
+}
+
+func (s *InstrumentedStore) GetSession(id string) (*Session, error) {
+	return observeValue(s, "GetSession", func() (*Session, error) { return s.next.GetSession(id) })
+}
+
+func (s *InstrumentedStore) TouchSession(id, ip string, at time.Time) error {
+	return observe(s, "TouchSession", func() error { return s.next.TouchSession(id, ip, at) })
+}
+
+func (s *InstrumentedStore) ListSessions(username string) ([]Session, error) {
+	return observeRows(s, "ListSessions", func() ([]Session, error) { return s.next.ListSessions(username) })
+}
+
+func (s *InstrumentedStore) DeleteSession(username, id string) (bool, error) {
+	return observeValue(s, "DeleteSession", func() (bool, error) { return s.next.DeleteSession(username, id) })
+}
+
+func (s *InstrumentedStore) DeleteUserSessions(username string) (int64, error) {
+	return observeValue(s, "DeleteUserSessions", func() (int64, error) { return s.next.DeleteUserSessions(username) })
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	s := Instrument(NewMemoryStore(), time.Nanosecond)
+	var _ Store = s
+
+	s.CreateTopic("news")
+	s.AddSubscription("news", "a", "webhook", "alice")
+	s.AddSubscription("news", "b", "webhook", "bob")
+	if subs, err := s.GetSubscribers("news"); err != nil || len(subs) != 2 {
+		t.Fatalf("GetSubscribers = %v, %v", subs, err)
+	}
+	s.GetSubscribers("news")
+	if err := s.DeleteUser("missing"); err == nil {
+		t.Fatal("Expected error from the wrapped store")
+	}
+
+	stats := map[string]MethodStats{}
+	for _, m := range s.Snapshot() {
+		stats[m.Method] = m
+	}
+	if got := stats["AddSubscription"]; got.Calls != 2 || got.Errors != 0 {
+		t.Errorf("Unexpected AddSubscription stats %+v", got)
+	}
+	if got := stats["GetSubscribers"]; got.Calls != 2 || got.Rows != 4 {
+		t.Errorf("Expected 2 calls returning 4 rows, got %+v", got)
+	}
+	if got := stats["DeleteUser"]; got.Errors != 1 || got.LastError != "user not found" {
+		t.Errorf("Expected the DeleteUser error to be recorded, got %+v", got)
+	}
+	if got := stats["CreateTopic"]; got.Slow != 1 || got.MaxMs <= 0 {
+		t.Errorf("Expected CreateTopic to exceed a 1ns threshold, got %+v", got)
+	}
+	if _, ok := stats["ListUsers"]; ok {
+		t.Error("Methods that weren't called should not be listed")
+	}
+}
@@ -0,0 +1,2192 @@
+package store
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in process memory. Data is lost on restart,
+// which suits demos, tests and deployments that don't need persistence. It
+// follows the same semantics as SQLiteStore.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	topics        map[string]*memTopic
+	subscriptions []Subscriber // Insertion order, unique by (topic, token)
+	templates     map[templateKey]Template
+	filterRules   []FilterRule
+	moderation    []ModerationEntry
+	approvals     map[int64]*Approval
+	audit         []AuditEvent
+	users         map[string]*memUser
+	invitations   map[string]*Invitation
+	sessions      map[string]*Session
+	roles         map[string]Role
+	messages      []Message
+	queue         []*memQueueItem
+	payloads      map[string][]byte   // Queue item payloads by hash, shared by the items
+	attempts      map[int64][]Attempt // Key: queue item ID
+	aliases       map[string]string   // Alias to topic
+	stats         map[statKey]int64
+	schedules     []*Schedule             // Ordered by ID
+	scheduleRuns  map[int64][]ScheduleRun // Key: schedule ID, oldest first
+	drafts        []*Draft                // Ordered by ID
+
+	lastFilterRule int64
+	lastModeration int64
+	lastAudit      int64
+	lastMessage    int64
+	lastQueueItem  int64
+	lastSchedule   int64
+	lastRun        int64
+	lastDraft      int64
+}
+
+type memTopic struct {
+	schema            string
+	approvalThreshold int
+	info              TopicInfo
+	seq               int64
+}
+
+type templateKey struct {
+	topic, name, locale string
+}
+
+type statKey struct {
+	bucket time.Time
+	metric string
+}
+
+type memUser struct {
+	User
+	recoveryCodes []string // nil when none have been generated
+	totpStep      int64    // Of the last accepted TOTP code
+}
+
+type memQueueItem struct {
+	id           int64
+	messageID    int64
+	token        string
+	provider     string // "" uses the subscription's
+	status       string
+	payload      []byte // Overrides the message payload when set
+	payloadHash  string // Key of payload in MemoryStore.payloads
+	claimedBy    string
+	claimedUntil time.Time
+	createdAt    time.Time
+	deliveredAt  *time.Time
+	openedAt     *time.Time
+	attempts     int
+	lastError    string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		topics:       map[string]*memTopic{},
+		templates:    map[templateKey]Template{},
+		approvals:    map[int64]*Approval{},
+		users:        map[string]*memUser{},
+		invitations:  map[string]*Invitation{},
+		sessions:     map[string]*Session{},
+		roles:        map[string]Role{},
+		attempts:     map[int64][]Attempt{},
+		payloads:     map[string][]byte{},
+		aliases:      map[string]string{},
+		stats:        map[statKey]int64{},
+		scheduleRuns: map[int64][]ScheduleRun{},
+	}
+}
+
+// now matches the second resolution of SQLite's CURRENT_TIMESTAMP.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// queueNow matches the millisecond resolution of queue timestamps in SQLite.
+func queueNow() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// Topics
+func (s *MemoryStore) CreateTopic(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[name]; ok {
+		return fmt.Errorf("topic %w: %s", ErrDuplicate, name)
+	}
+	s.topics[name] = &memTopic{info: TopicInfo{Name: name, CreatedAt: now()}}
+	return nil
+}
+
+func (s *MemoryStore) CreateOwnedTopic(name, owner, prefix string, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for topic, t := range s.topics {
+		if t.info.Owner == owner && strings.HasPrefix(topic, prefix) {
+			count++
+		}
+	}
+	if count >= limit {
+		return count, fmt.Errorf("topics of %s: %w", owner, ErrLimitReached)
+	}
+	if _, ok := s.topics[name]; ok {
+		return count, fmt.Errorf("topic %w: %s", ErrDuplicate, name)
+	}
+	s.topics[name] = &memTopic{info: TopicInfo{Name: name, Owner: owner, CreatedAt: now()}}
+	return count, nil
+}
+
+func cloneTopicInfo(info TopicInfo) TopicInfo {
+	if info.ReplayCount != nil {
+		n := *info.ReplayCount
+		info.ReplayCount = &n
+	}
+	return info
+}
+
+func (s *MemoryStore) GetTopicInfo(name string) (*TopicInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.topics[name]
+	if !ok {
+		return nil, nil
+	}
+	info := cloneTopicInfo(t.info)
+	return &info, nil
+}
+
+func (s *MemoryStore) ListTopicInfo() ([]TopicInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var topics []TopicInfo
+	for _, t := range s.topics {
+		topics = append(topics, cloneTopicInfo(t.info))
+	}
+	slices.SortFunc(topics, func(a, b TopicInfo) int { return strings.Compare(a.Name, b.Name) })
+	return topics, nil
+}
+
+func (s *MemoryStore) SetTopicInfo(info TopicInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[info.Name]
+	if !ok {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, info.Name)
+	}
+	info.CreatedAt = t.info.CreatedAt
+	t.info = cloneTopicInfo(info)
+	return nil
+}
+
+func (s *MemoryStore) TopicExists(name string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.topics[name]
+	return ok, nil
+}
+
+func (s *MemoryStore) SetTopicSchema(name, schema string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, name)
+	}
+	t.schema = schema
+	return nil
+}
+
+func (s *MemoryStore) GetTopicSchema(name string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.topics[name]; ok {
+		return t.schema, nil
+	}
+	return "", nil
+}
+
+func (s *MemoryStore) SetTopicApprovalThreshold(name string, threshold int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[name]
+	if !ok {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, name)
+	}
+	t.approvalThreshold = threshold
+	return nil
+}
+
+func (s *MemoryStore) GetTopicApprovalThreshold(name string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.topics[name]; ok {
+		return t.approvalThreshold, nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryStore) ListTopics() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var topics []string
+	for name := range s.topics {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (s *MemoryStore) DeleteTopic(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgCount := 0
+	for _, m := range s.messages {
+		if m.Topic == name {
+			msgCount++
+		}
+	}
+	if msgCount > 0 {
+		return fmt.Errorf("cannot delete topic: %w: has %d messages", ErrInUse, msgCount)
+	}
+
+	subCount := 0
+	for _, sub := range s.subscriptions {
+		if sub.Topic == name {
+			subCount++
+		}
+	}
+	if subCount > 0 {
+		return fmt.Errorf("cannot delete topic: %w: has %d subscribers", ErrInUse, subCount)
+	}
+
+	for k := range s.templates {
+		if k.topic == name {
+			delete(s.templates, k)
+		}
+	}
+	s.schedules = slices.DeleteFunc(s.schedules, func(sc *Schedule) bool {
+		if sc.Topic != name {
+			return false
+		}
+		delete(s.scheduleRuns, sc.ID)
+		return true
+	})
+	s.drafts = slices.DeleteFunc(s.drafts, func(d *Draft) bool { return d.Topic == name })
+	for alias, topic := range s.aliases {
+		if topic == name {
+			delete(s.aliases, alias)
+		}
+	}
+	delete(s.topics, name)
+	return nil
+}
+
+func (s *MemoryStore) RenameTopic(oldName, newName string, alias bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.topics[oldName]
+	if !ok {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, oldName)
+	}
+	if _, ok := s.topics[newName]; ok {
+		return fmt.Errorf("topic %w: %s", ErrDuplicate, newName)
+	}
+	if target, ok := s.aliases[newName]; ok && target != oldName {
+		return fmt.Errorf("topic %w: %s is an alias of %s", ErrDuplicate, newName, target)
+	}
+
+	t.info.Name = newName
+	s.topics[newName] = t
+	delete(s.topics, oldName)
+	for i := range s.subscriptions {
+		if s.subscriptions[i].Topic == oldName {
+			s.subscriptions[i].Topic = newName
+		}
+	}
+	for i := range s.messages {
+		if s.messages[i].Topic == oldName {
+			s.messages[i].Topic = newName
+		}
+	}
+	for k, tmpl := range s.templates {
+		if k.topic == oldName {
+			delete(s.templates, k)
+			k.topic, tmpl.Topic = newName, newName
+			s.templates[k] = tmpl
+		}
+	}
+	for i := range s.filterRules {
+		if s.filterRules[i].Topic == oldName {
+			s.filterRules[i].Topic = newName
+		}
+	}
+	for _, a := range s.approvals {
+		if a.Topic == oldName {
+			a.Topic = newName
+		}
+	}
+	for _, sc := range s.schedules {
+		if sc.Topic == oldName {
+			sc.Topic = newName
+		}
+	}
+	for _, d := range s.drafts {
+		if d.Topic == oldName {
+			d.Topic = newName
+		}
+	}
+	for a, topic := range s.aliases {
+		if topic == oldName {
+			s.aliases[a] = newName
+		}
+	}
+	delete(s.aliases, newName)
+	if alias {
+		s.aliases[oldName] = newName
+	}
+	return nil
+}
+
+func (s *MemoryStore) ResolveTopicAlias(name string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.aliases[name], nil
+}
+
+func (s *MemoryStore) ListTopicAliases() ([]TopicAlias, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var aliases []TopicAlias
+	for alias, topic := range s.aliases {
+		aliases = append(aliases, TopicAlias{Alias: alias, Topic: topic})
+	}
+	slices.SortFunc(aliases, func(a, b TopicAlias) int { return strings.Compare(a.Alias, b.Alias) })
+	return aliases, nil
+}
+
+func (s *MemoryStore) DeleteTopicAlias(alias string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.aliases[alias]
+	delete(s.aliases, alias)
+	return ok, nil
+}
+
+// Templates
+func (s *MemoryStore) SaveTemplate(t Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[t.Topic]; !ok {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, t.Topic)
+	}
+	s.templates[templateKey{t.Topic, t.Name, t.Locale}] = t
+	return nil
+}
+
+func (s *MemoryStore) GetTemplate(topic, name, locale string) (*Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.templates[templateKey{topic, name, locale}]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (s *MemoryStore) ListTemplates(topic string) ([]Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	templates := []Template{}
+	for k, t := range s.templates {
+		if k.topic == topic {
+			templates = append(templates, t)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name != templates[j].Name {
+			return templates[i].Name < templates[j].Name
+		}
+		return templates[i].Locale < templates[j].Locale
+	})
+	return templates, nil
+}
+
+func (s *MemoryStore) DeleteTemplate(topic, name, locale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.templates {
+		if k.topic == topic && k.name == name && (locale == "*" || k.locale == locale) {
+			delete(s.templates, k)
+		}
+	}
+	return nil
+}
+
+// Content filtering
+func (s *MemoryStore) CreateFilterRule(r FilterRule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFilterRule++
+	r.ID, r.CreatedAt = s.lastFilterRule, now()
+	s.filterRules = append(s.filterRules, r)
+	return r.ID, nil
+}
+
+func (s *MemoryStore) ListFilterRules() ([]FilterRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]FilterRule{}, s.filterRules...), nil
+}
+
+func (s *MemoryStore) DeleteFilterRule(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.filterRules {
+		if r.ID == id {
+			s.filterRules = slices.Delete(s.filterRules, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) AddModerationEntry(e ModerationEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastModeration++
+	e.ID, e.CreatedAt = s.lastModeration, now()
+	e.Payload = bytes.Clone(e.Payload)
+	s.moderation = append(s.moderation, e)
+	return nil
+}
+
+func (s *MemoryStore) ListModerationEntries(limit int) ([]ModerationEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []ModerationEntry{}
+	for i := len(s.moderation) - 1; i >= 0 && (limit < 0 || len(entries) < limit); i-- {
+		entries = append(entries, s.moderation[i])
+	}
+	return entries, nil
+}
+
+// Approvals
+func (s *MemoryStore) HoldMessage(a Approval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.approvals[a.MessageID]; ok {
+		return fmt.Errorf("message %d is already held", a.MessageID)
+	}
+	if _, ok := s.message(a.MessageID); !ok {
+		return fmt.Errorf("message %w: %d", ErrNotFound, a.MessageID)
+	}
+	a.Request = bytes.Clone(a.Request)
+	a.Status, a.DecidedBy, a.DecidedAt = ApprovalPending, "", nil
+	a.CreatedAt = now()
+	s.approvals[a.MessageID] = &a
+	return nil
+}
+
+func (s *MemoryStore) ListApprovals(status string) ([]Approval, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	approvals := []Approval{}
+	for _, a := range s.approvals {
+		if status == "" || a.Status == status {
+			approvals = append(approvals, *a)
+		}
+	}
+	sort.Slice(approvals, func(i, j int) bool { return approvals[i].MessageID < approvals[j].MessageID })
+	return approvals, nil
+}
+
+func (s *MemoryStore) DecideApproval(messageID int64, status, decidedBy string) (*Approval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.approvals[messageID]
+	if !ok || a.Status != ApprovalPending {
+		return nil, nil
+	}
+	decidedAt := now()
+	a.Status, a.DecidedBy, a.DecidedAt = status, decidedBy, &decidedAt
+	decided := *a
+	return &decided, nil
+}
+
+// Schedules
+func (s *MemoryStore) CreateSchedule(sc Schedule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[sc.Topic]; !ok {
+		return 0, fmt.Errorf("topic %w: %s", ErrNotFound, sc.Topic)
+	}
+	s.lastSchedule++
+	sc.ID, sc.CreatedAt, sc.NextRun = s.lastSchedule, now(), sc.NextRun.UTC()
+	sc.Request = slices.Clone(sc.Request)
+	s.schedules = append(s.schedules, &sc)
+	return sc.ID, nil
+}
+
+// schedule returns the schedule with id, or nil. s.mu must be held.
+func (s *MemoryStore) schedule(id int64) *Schedule {
+	for _, sc := range s.schedules {
+		if sc.ID == id {
+			return sc
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetSchedule(id int64) (*Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sc := s.schedule(id)
+	if sc == nil {
+		return nil, nil
+	}
+	c := *sc
+	return &c, nil
+}
+
+func (s *MemoryStore) ListSchedules(topic string) ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedules := []Schedule{}
+	for _, sc := range s.schedules {
+		if topic == "" || sc.Topic == topic {
+			schedules = append(schedules, *sc)
+		}
+	}
+	return schedules, nil
+}
+
+func (s *MemoryStore) SetSchedulePaused(id int64, paused bool, nextRun time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc := s.schedule(id)
+	if sc == nil {
+		return false, nil
+	}
+	sc.Paused, sc.NextRun = paused, nextRun.UTC()
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteSchedule(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.schedules)
+	s.schedules = slices.DeleteFunc(s.schedules, func(sc *Schedule) bool { return sc.ID == id })
+	delete(s.scheduleRuns, id)
+	return len(s.schedules) < n, nil
+}
+
+func (s *MemoryStore) DueSchedules(now time.Time) ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	due := []Schedule{}
+	for _, sc := range s.schedules {
+		if !sc.Paused && !sc.NextRun.After(now) {
+			due = append(due, *sc)
+		}
+	}
+	slices.SortStableFunc(due, func(a, b Schedule) int { return a.NextRun.Compare(b.NextRun) })
+	return due, nil
+}
+
+func (s *MemoryStore) AdvanceSchedule(id int64, from, to time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc := s.schedule(id)
+	if sc == nil || sc.Paused || !sc.NextRun.Equal(from) {
+		return false, nil
+	}
+	sc.NextRun = to.UTC()
+	return true, nil
+}
+
+func (s *MemoryStore) AddScheduleRun(r ScheduleRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.schedule(r.ScheduleID) == nil {
+		return fmt.Errorf("schedule %w: %d", ErrNotFound, r.ScheduleID)
+	}
+	s.lastRun++
+	r.ID, r.ScheduledAt, r.RanAt = s.lastRun, r.ScheduledAt.UTC(), r.RanAt.UTC()
+	runs := append(s.scheduleRuns[r.ScheduleID], r)
+	if len(runs) > MaxScheduleRuns {
+		runs = slices.Clone(runs[len(runs)-MaxScheduleRuns:])
+	}
+	s.scheduleRuns[r.ScheduleID] = runs
+	return nil
+}
+
+func (s *MemoryStore) ListScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	runs := []ScheduleRun{}
+	all := s.scheduleRuns[scheduleID]
+	for i := len(all) - 1; i >= 0 && len(runs) < limit; i-- {
+		runs = append(runs, all[i])
+	}
+	return runs, nil
+}
+
+// Drafts
+func (s *MemoryStore) CreateDraft(d Draft) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[d.Topic]; !ok {
+		return 0, fmt.Errorf("topic %w: %s", ErrNotFound, d.Topic)
+	}
+	s.lastDraft++
+	d.ID, d.Status, d.MessageID, d.PublishedAt = s.lastDraft, DraftOpen, 0, nil
+	d.CreatedAt = now()
+	d.UpdatedAt = d.CreatedAt
+	d.Request = slices.Clone(d.Request)
+	s.drafts = append(s.drafts, &d)
+	return d.ID, nil
+}
+
+// draft returns the draft with id, or nil. s.mu must be held.
+func (s *MemoryStore) draft(id int64) *Draft {
+	for _, d := range s.drafts {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetDraft(id int64) (*Draft, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := s.draft(id)
+	if d == nil {
+		return nil, nil
+	}
+	c := *d
+	return &c, nil
+}
+
+func (s *MemoryStore) ListDrafts(topic string) ([]Draft, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	drafts := []Draft{}
+	for _, d := range s.drafts {
+		if topic == "" || d.Topic == topic {
+			drafts = append(drafts, *d)
+		}
+	}
+	return drafts, nil
+}
+
+func (s *MemoryStore) UpdateDraft(id int64, request json.RawMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.draft(id)
+	if d == nil || d.Status != DraftOpen {
+		return false, nil
+	}
+	d.Request, d.UpdatedAt = slices.Clone(request), now()
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteDraft(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.drafts)
+	s.drafts = slices.DeleteFunc(s.drafts, func(d *Draft) bool { return d.ID == id })
+	return len(s.drafts) < n, nil
+}
+
+func (s *MemoryStore) SetDraftStatus(id int64, from, to string, messageID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.draft(id)
+	if d == nil || d.Status != from {
+		return false, nil
+	}
+	d.Status, d.MessageID, d.PublishedAt = to, messageID, nil
+	if to == DraftPublished {
+		t := now()
+		d.PublishedAt = &t
+	}
+	return true, nil
+}
+
+// Audit log
+func (s *MemoryStore) AddAuditEvent(e AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAudit++
+	e.ID, e.CreatedAt = s.lastAudit, now()
+	if len(e.Details) > 0 {
+		e.Details = maps.Clone(e.Details)
+	} else {
+		e.Details = nil
+	}
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func (s *MemoryStore) ListAuditEvents(f AuditFilter) ([]AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []AuditEvent{}
+	for i := len(s.audit) - 1; i >= 0 && len(events) < f.limit(); i-- {
+		if e := s.audit[i]; f.matches(e) {
+			e.Details = maps.Clone(e.Details)
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// limit returns f.Limit, defaulting to 100.
+func (f AuditFilter) limit() int {
+	if f.Limit <= 0 {
+		return 100
+	}
+	return f.Limit
+}
+
+// matches reports whether e passes the filter, comparing times at the
+// second resolution SQLite stores.
+func (f AuditFilter) matches(e AuditEvent) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case strings.HasSuffix(f.Action, ".*"):
+		if !strings.HasPrefix(e.Action, strings.TrimSuffix(f.Action, "*")) {
+			return false
+		}
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	}
+	if f.Target != "" && e.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && e.CreatedAt.Before(f.Since.UTC().Truncate(time.Second)) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.CreatedAt.Before(f.Until.UTC().Truncate(time.Second)) {
+		return false
+	}
+	return true
+}
+
+// Subscriptions
+func (s *MemoryStore) AddSubscription(topic, token, provider, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[topic]; !ok {
+		return fmt.Errorf("failed to subscribe: topic %w: %s", ErrNotFound, topic)
+	}
+	if s.findSubscription(topic, token) >= 0 {
+		return fmt.Errorf("failed to subscribe: %w", ErrDuplicate)
+	}
+	now := time.Now()
+	s.subscriptions = append(s.subscriptions, Subscriber{Topic: topic, Token: token, Provider: provider, Username: username, CreatedAt: &now})
+	return nil
+}
+
+// findSubscription returns the index of the subscription, or -1. The caller holds mu.
+func (s *MemoryStore) findSubscription(topic, token string) int {
+	return slices.IndexFunc(s.subscriptions, func(sub Subscriber) bool {
+		return sub.Topic == topic && sub.Token == token
+	})
+}
+
+func (s *MemoryStore) RemoveSubscription(topic, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.findSubscription(topic, token); i >= 0 {
+		s.subscriptions = slices.Delete(s.subscriptions, i, i+1)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClearTopicSubscribers(topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions = slices.DeleteFunc(s.subscriptions, func(sub Subscriber) bool { return sub.Topic == topic })
+	return nil
+}
+
+// subscribersWhere copies the subscriptions matching keep. The caller holds mu.
+func (s *MemoryStore) subscribersWhere(keep func(Subscriber) bool) []Subscriber {
+	var subs []Subscriber
+	for _, sub := range s.subscriptions {
+		if keep(sub) {
+			subs = append(subs, cloneSubscriber(sub))
+		}
+	}
+	return subs
+}
+
+func cloneSubscriber(sub Subscriber) Subscriber {
+	sub.Options = cloneOptions(sub.Options)
+	sub.Tags = slices.Clone(sub.Tags)
+	sub.Preferences = clonePreferences(sub.Preferences)
+	return sub
+}
+
+func cloneOptions(opts *WebhookOptions) *WebhookOptions {
+	if opts == nil {
+		return nil
+	}
+	c := *opts
+	c.Headers = maps.Clone(opts.Headers)
+	return &c
+}
+
+// paused reports whether the subscription's user is disabled. The caller holds mu.
+func (s *MemoryStore) paused(sub Subscriber) bool {
+	u, ok := s.users[sub.Username]
+	return ok && u.Disabled
+}
+
+func (s *MemoryStore) GetSubscribers(topic string) ([]Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subscribersWhere(func(sub Subscriber) bool { return sub.Topic == topic && !s.paused(sub) }), nil
+}
+
+func (s *MemoryStore) ListSubscribersAfter(topic, after string, limit int) ([]Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := s.subscribersWhere(func(sub Subscriber) bool { return sub.Topic == topic && sub.Token > after && !s.paused(sub) })
+	slices.SortFunc(subs, func(a, b Subscriber) int { return strings.Compare(a.Token, b.Token) })
+	if len(subs) > limit {
+		subs = subs[:limit]
+	}
+	return subs, nil
+}
+
+func (s *MemoryStore) GetSubscriptionsByUser(username string) ([]Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subscribersWhere(func(sub Subscriber) bool { return sub.Username == username }), nil
+}
+
+func (s *MemoryStore) GetSubscriptionsByToken(token string) ([]Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subscribersWhere(func(sub Subscriber) bool { return sub.Token == token }), nil
+}
+
+// updateSubscription applies fn to the subscription, doing nothing if it doesn't exist.
+func (s *MemoryStore) updateSubscription(topic, token string, fn func(*Subscriber)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.findSubscription(topic, token); i >= 0 {
+		fn(&s.subscriptions[i])
+	}
+}
+
+func (s *MemoryStore) SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
+	s.updateSubscription(topic, token, func(sub *Subscriber) {
+		sub.Platform, sub.AppVersion = platform, appVersion
+		sub.Tags = nil
+		if len(tags) > 0 {
+			sub.Tags = slices.Clone(tags)
+		}
+	})
+	return nil
+}
+
+func (s *MemoryStore) UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findSubscription(topic, token)
+	if i < 0 {
+		return nil, fmt.Errorf("subscription %w", ErrNotFound)
+	}
+	tags := mergeTags(s.subscriptions[i].Tags, add, remove)
+	s.subscriptions[i].Tags = nil
+	if len(tags) > 0 {
+		s.subscriptions[i].Tags = slices.Clone(tags)
+	}
+	return tags, nil
+}
+
+func (s *MemoryStore) RemoveSubscriptionsByTag(username, tag string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.subscriptions)
+	s.subscriptions = slices.DeleteFunc(s.subscriptions, func(sub Subscriber) bool {
+		return sub.Username == username && slices.Contains(sub.Tags, tag)
+	})
+	return int64(before - len(s.subscriptions)), nil
+}
+
+func (s *MemoryStore) ReassignSubscriptions(from, to string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if to == "" {
+		before := len(s.subscriptions)
+		s.subscriptions = slices.DeleteFunc(s.subscriptions, func(sub Subscriber) bool { return sub.Username == from })
+		return int64(before - len(s.subscriptions)), nil
+	}
+	var n int64
+	for i := range s.subscriptions {
+		if s.subscriptions[i].Username == from {
+			s.subscriptions[i].Username = to
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) MarkTokensSeen(tokens []string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.subscriptions {
+		if slices.Contains(tokens, s.subscriptions[i].Token) {
+			s.subscriptions[i].LastSeenAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListStaleSubscriptions(before time.Time, limit int) ([]Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := staleSubscriptions(s.subscribersWhere(func(Subscriber) bool { return true }), before)
+	if len(subs) > limit {
+		subs = subs[:limit]
+	}
+	return subs, nil
+}
+
+func (s *MemoryStore) RemoveStaleSubscriptions(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.subscriptions)
+	s.subscriptions = slices.DeleteFunc(s.subscriptions, func(sub Subscriber) bool { return isStale(sub, before) })
+	return int64(n - len(s.subscriptions)), nil
+}
+
+// lastSeen returns when the subscription was last delivered to, or else
+// created. It's nil for subscriptions of unknown age.
+func lastSeen(sub Subscriber) *time.Time {
+	if sub.LastSeenAt != nil {
+		return sub.LastSeenAt
+	}
+	return sub.CreatedAt
+}
+
+// isStale reports whether the subscription wasn't seen since before.
+// Subscriptions of unknown age are never stale.
+func isStale(sub Subscriber, before time.Time) bool {
+	seen := lastSeen(sub)
+	return seen != nil && seen.Before(before)
+}
+
+// staleSubscriptions keeps the stale subscriptions, least recently seen
+// first.
+func staleSubscriptions(subs []Subscriber, before time.Time) []Subscriber {
+	subs = slices.DeleteFunc(subs, func(sub Subscriber) bool { return !isStale(sub, before) })
+	slices.SortFunc(subs, func(a, b Subscriber) int {
+		return cmp.Or(lastSeen(a).Compare(*lastSeen(b)), strings.Compare(a.Topic, b.Topic), strings.Compare(a.Token, b.Token))
+	})
+	return subs
+}
+
+func (s *MemoryStore) SetSubscriptionOptions(topic, token string, opts *WebhookOptions) error {
+	s.updateSubscription(topic, token, func(sub *Subscriber) { sub.Options = cloneOptions(opts) })
+	return nil
+}
+
+func (s *MemoryStore) SetSubscriptionPreferences(topic, token string, prefs *Preferences) error {
+	s.updateSubscription(topic, token, func(sub *Subscriber) { sub.Preferences = clonePreferences(prefs) })
+	return nil
+}
+
+func clonePreferences(prefs *Preferences) *Preferences {
+	if prefs == nil {
+		return nil
+	}
+	c := *prefs
+	c.MutedCategories = slices.Clone(prefs.MutedCategories)
+	return &c
+}
+
+func (s *MemoryStore) SetSubscriptionLocale(topic, token, locale string) error {
+	s.updateSubscription(topic, token, func(sub *Subscriber) { sub.Locale = locale })
+	return nil
+}
+
+func (s *MemoryStore) GetSubscriptionCount() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.subscriptions), nil
+}
+
+func (s *MemoryStore) CountSubscriptions(topic, username string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.subscribersWhere(func(sub Subscriber) bool { return sub.Topic == topic && sub.Username == username })), nil
+}
+
+// Users
+func (s *MemoryStore) CreateUser(username, passwordHash, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createUser(username, passwordHash, role)
+}
+
+// createUser adds a user, failing if the name is taken. The caller holds mu.
+func (s *MemoryStore) createUser(username, passwordHash, role string) error {
+	if _, ok := s.users[username]; ok {
+		return fmt.Errorf("user %w: %s", ErrDuplicate, username)
+	}
+	s.users[username] = &memUser{User: User{Username: username, PasswordHash: passwordHash, Role: role}}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; !ok {
+		return fmt.Errorf("user %w", ErrNotFound)
+	}
+	if s.lastAdmin(username) {
+		return fmt.Errorf("user %s: %w", username, ErrLastAdmin)
+	}
+	delete(s.users, username)
+	return nil
+}
+
+// lastAdmin reports whether username is the last admin who isn't disabled.
+// The caller holds mu.
+func (s *MemoryStore) lastAdmin(username string) bool {
+	if u, ok := s.users[username]; !ok || u.Role != "admin" || u.Disabled {
+		return false
+	}
+	for _, u := range s.users {
+		if u.Username != username && u.Role == "admin" && !u.Disabled {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) ListUsers() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var users []User
+	for _, u := range s.users {
+		users = append(users, User{
+			Username: u.Username, PasswordHash: u.PasswordHash, Role: u.Role,
+			Disabled: u.Disabled, DisplayName: u.DisplayName, Email: u.Email,
+			ExternalID: u.ExternalID,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (s *MemoryStore) GetUser(username string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[username]
+	if !ok {
+		return nil, nil // Not found
+	}
+	user := u.User
+	return &user, nil
+}
+
+func (s *MemoryStore) HasAdminUser() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Role == "admin" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) UpdateUserRole(username, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[username]; ok {
+		if role != "admin" && s.lastAdmin(username) {
+			return fmt.Errorf("user %s: %w", username, ErrLastAdmin)
+		}
+		u.Role = role
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetUserPassword(username, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	u.PasswordHash = passwordHash
+	return nil
+}
+
+func (s *MemoryStore) SetMustChangePassword(username string, required bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	u.MustChangePassword = required
+	return nil
+}
+
+func (s *MemoryStore) SetUserDisabled(username string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	if disabled && s.lastAdmin(username) {
+		return fmt.Errorf("user %s: %w", username, ErrLastAdmin)
+	}
+	u.Disabled = disabled
+	return nil
+}
+
+func (s *MemoryStore) SetUserAccess(username, role string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	if (role != "admin" || disabled) && s.lastAdmin(username) {
+		return fmt.Errorf("user %s: %w", username, ErrLastAdmin)
+	}
+	u.Role, u.Disabled = role, disabled
+	return nil
+}
+
+func (s *MemoryStore) SetUserProfile(username, displayName, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	u.DisplayName, u.Email = displayName, email
+	return nil
+}
+
+func (s *MemoryStore) LinkUser(username, externalID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return false, fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	if u.ExternalID != "" {
+		return false, nil
+	}
+	u.ExternalID = externalID
+	return true, nil
+}
+
+func (s *MemoryStore) IncrementUnread(usernames []string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int, len(usernames))
+	for _, username := range usernames {
+		if u, ok := s.users[username]; ok {
+			u.Unread++
+			counts[username] = u.Unread
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) SetUnread(username string, count int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	u.Unread = count
+	return nil
+}
+
+func (s *MemoryStore) SetUserTOTP(username, secret string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	u.TOTPSecret, u.TOTPEnabled = secret, enabled && secret != ""
+	if secret == "" {
+		u.recoveryCodes = nil
+	}
+	if !u.TOTPEnabled {
+		u.totpStep, u.TOTPFailures = 0, 0
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetRecoveryCodes(username string, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[username]; ok {
+		u.recoveryCodes = append([]string{}, hashes...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(username, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return false, nil
+	}
+	i := slices.Index(u.recoveryCodes, hash)
+	if i < 0 {
+		return false, nil
+	}
+	u.recoveryCodes = slices.Delete(u.recoveryCodes, i, i+1)
+	u.TOTPFailures = 0
+	return true, nil
+}
+
+func (s *MemoryStore) UseTOTPStep(username string, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok || step <= u.totpStep {
+		return false, nil
+	}
+	u.totpStep, u.TOTPFailures = step, 0
+	return true, nil
+}
+
+func (s *MemoryStore) AddTOTPFailure(username string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return 0, fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	u.TOTPFailures++
+	u.TOTPFailedAt = now()
+	return u.TOTPFailures, nil
+}
+
+// Invitations
+func (s *MemoryStore) CreateInvitation(inv Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.invitations[inv.Code]; ok {
+		return fmt.Errorf("invitation %w: %s", ErrDuplicate, inv.Code)
+	}
+	if inv.ExpiresAt != nil {
+		expires := inv.ExpiresAt.UTC()
+		inv.ExpiresAt = &expires
+	}
+	inv.Uses, inv.CreatedAt = 0, now()
+	s.invitations[inv.Code] = &inv
+	return nil
+}
+
+func (s *MemoryStore) ListInvitations() ([]Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	invitations := []Invitation{}
+	for _, inv := range s.invitations {
+		invitations = append(invitations, *inv)
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		a, b := invitations[i], invitations[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.Code < b.Code
+	})
+	return invitations, nil
+}
+
+func (s *MemoryStore) DeleteInvitation(code string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.invitations[code]; !ok {
+		return false, nil
+	}
+	delete(s.invitations, code)
+	return true, nil
+}
+
+func (s *MemoryStore) RedeemInvitation(code, username, passwordHash string) (*Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invitations[code]
+	if !ok || inv.Uses >= inv.MaxUses || (inv.ExpiresAt != nil && !inv.ExpiresAt.After(time.Now())) {
+		return nil, nil
+	}
+	if err := s.createUser(username, passwordHash, inv.Role); err != nil {
+		return nil, err
+	}
+	inv.Uses++
+	return &Invitation{Code: code, Role: inv.Role, MaxUses: inv.MaxUses, Uses: inv.Uses}, nil
+}
+
+// Roles
+func (s *MemoryStore) SaveRole(r Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Permissions = slices.Clone(r.Permissions)
+	s.roles[r.Name] = r
+	return nil
+}
+
+func (s *MemoryStore) GetRole(name string) (*Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.roles[name]
+	if !ok {
+		return nil, nil
+	}
+	r.Permissions = slices.Clone(r.Permissions)
+	return &r, nil
+}
+
+func (s *MemoryStore) ListRoles() ([]Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	roles := []Role{}
+	for _, r := range s.roles {
+		r.Permissions = slices.Clone(r.Permissions)
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (s *MemoryStore) DeleteRole(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[name]; !ok {
+		return false, nil
+	}
+	delete(s.roles, name)
+	return true, nil
+}
+
+// Save Message
+func (s *MemoryStore) SaveMessage(topic string, payload []byte) (int64, error) {
+	return s.SaveMessageFrom(topic, payload, MessageOrigin{})
+}
+
+func (s *MemoryStore) SaveMessageFrom(topic string, payload []byte, origin MessageOrigin) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMessage++
+	s.messages = append(s.messages, Message{ID: s.lastMessage, Topic: topic, Payload: bytes.Clone(payload), CreatedAt: now(), Origin: origin})
+	return s.lastMessage, nil
+}
+
+// sealSequenced numbers a message of topic with seal, counting the number
+// only once seal succeeds. The caller holds mu.
+func (s *MemoryStore) sealSequenced(topic string, seal func(seq int64) ([]byte, error)) ([]byte, error) {
+	t, ok := s.topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("topic %w: %s", ErrNotFound, topic)
+	}
+	payload, err := seal(t.seq + 1)
+	if err != nil {
+		return nil, err
+	}
+	t.seq++
+	return bytes.Clone(payload), nil
+}
+
+func (s *MemoryStore) SaveSequencedMessage(topic string, origin MessageOrigin, seal func(seq int64) ([]byte, error)) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payload, err := s.sealSequenced(topic, seal)
+	if err != nil {
+		return 0, err
+	}
+	s.lastMessage++
+	s.messages = append(s.messages, Message{ID: s.lastMessage, Topic: topic, Payload: payload, CreatedAt: now(), Origin: origin})
+	return s.lastMessage, nil
+}
+
+func (s *MemoryStore) SequenceMessage(id int64, seal func(seq int64) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := slices.BinarySearchFunc(s.messages, id, func(m Message, id int64) int {
+		return cmp.Compare(m.ID, id)
+	})
+	if !ok {
+		return fmt.Errorf("message %w: %d", ErrNotFound, id)
+	}
+	payload, err := s.sealSequenced(s.messages[i].Topic, seal)
+	if err != nil {
+		return err
+	}
+	s.messages[i].Payload = payload
+	return nil
+}
+
+// message looks up a message by ID. The caller holds mu.
+func (s *MemoryStore) message(id int64) (Message, bool) {
+	// IDs are assigned in increasing order, so messages is sorted by ID.
+	i, ok := slices.BinarySearchFunc(s.messages, id, func(m Message, id int64) int {
+		return cmp.Compare(m.ID, id)
+	})
+	if !ok {
+		return Message{}, false
+	}
+	return s.messages[i], true
+}
+
+func (s *MemoryStore) GetMessage(id int64) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.message(id)
+	if !ok {
+		return nil, nil
+	}
+	m.Payload = bytes.Clone(m.Payload)
+	return &m, nil
+}
+
+func (s *MemoryStore) GetRecentMessages(topic string, limit int) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var msgs []Message
+	for i := len(s.messages) - 1; i >= 0 && (limit < 0 || len(msgs) < limit); i-- {
+		if m := s.messages[i]; m.Topic == topic {
+			m.Payload = bytes.Clone(m.Payload)
+			msgs = append(msgs, m)
+		}
+	}
+	// Oldest -> Newest (Chronological)
+	slices.Reverse(msgs)
+	return msgs, nil
+}
+
+func (s *MemoryStore) SearchMessages(f MessageSearch) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	msgs := []Message{}
+	for i := len(s.messages) - 1; i >= 0 && len(msgs) < f.limit(); i-- {
+		if m := s.messages[i]; f.matches(m) {
+			m.Payload = bytes.Clone(m.Payload)
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+// limit returns f.Limit, defaulting to 100.
+func (f MessageSearch) limit() int {
+	if f.Limit <= 0 {
+		return 100
+	}
+	return f.Limit
+}
+
+// terms returns the words of f.Query.
+func (f MessageSearch) terms() []string {
+	return strings.Fields(f.Query)
+}
+
+// matches reports whether m passes the search, matching words as
+// case-insensitive substrings of the payload and comparing times at the
+// second resolution SQLite stores.
+func (f MessageSearch) matches(m Message) bool {
+	if m.Topic != f.Topic {
+		return false
+	}
+	if !f.From.IsZero() && m.CreatedAt.Before(f.From.UTC().Truncate(time.Second)) {
+		return false
+	}
+	if !f.To.IsZero() && !m.CreatedAt.Before(f.To.UTC().Truncate(time.Second)) {
+		return false
+	}
+	payload := strings.ToLower(string(m.Payload))
+	for _, t := range f.terms() {
+		if !strings.Contains(payload, strings.ToLower(t)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) ClearTopicMessages(topic string) error {
+	s.deleteMessages(func(m Message) bool { return m.Topic == topic })
+	return nil
+}
+
+func (s *MemoryStore) DeleteMessagesBefore(topic string, t time.Time) (int64, error) {
+	t = t.UTC().Truncate(time.Second)
+	return s.deleteMessages(func(m Message) bool { return m.Topic == topic && m.CreatedAt.Before(t) }), nil
+}
+
+// deleteMessages deletes the messages matching match, with their queue
+// items and attempts, and returns how many were deleted.
+func (s *MemoryStore) deleteMessages(match func(Message) bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := map[int64]bool{}
+	s.messages = slices.DeleteFunc(s.messages, func(m Message) bool {
+		if match(m) {
+			removed[m.ID] = true
+		}
+		return removed[m.ID]
+	})
+	s.queue = slices.DeleteFunc(s.queue, func(q *memQueueItem) bool {
+		if removed[q.messageID] {
+			delete(s.attempts, q.id)
+			return true
+		}
+		return false
+	})
+	used := map[string]bool{}
+	for _, q := range s.queue {
+		used[q.payloadHash] = true
+	}
+	maps.DeleteFunc(s.payloads, func(hash string, _ []byte) bool { return !used[hash] })
+	return int64(len(removed))
+}
+
+// Queue
+func (s *MemoryStore) EnqueueMessage(messageID int64, token string) (int64, error) {
+	return s.EnqueueMessagePayload(messageID, token, nil)
+}
+
+func (s *MemoryStore) EnqueueMessagePayload(messageID int64, token string, payload []byte) (int64, error) {
+	ids, err := s.EnqueueMessages(messageID, []QueueEntry{{Token: token, Payload: payload}})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (s *MemoryStore) EnqueueMessages(messageID int64, entries []QueueEntry) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.message(messageID); !ok {
+		return nil, fmt.Errorf("message %w: %d", ErrNotFound, messageID)
+	}
+	now := queueNow()
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		s.lastQueueItem++
+		q := &memQueueItem{
+			id:        s.lastQueueItem,
+			messageID: messageID,
+			token:     e.Token,
+			provider:  e.Provider,
+			status:    "pending",
+			createdAt: now,
+		}
+		if e.Payload != nil {
+			q.payloadHash = payloadHash(e.Payload)
+			if _, ok := s.payloads[q.payloadHash]; !ok {
+				s.payloads[q.payloadHash] = bytes.Clone(e.Payload)
+			}
+			q.payload = s.payloads[q.payloadHash]
+		}
+		s.queue = append(s.queue, q)
+		ids[i] = s.lastQueueItem
+	}
+	return ids, nil
+}
+
+// queueItem builds the QueueItem returned to callers, without the payload.
+// The caller holds mu.
+func (s *MemoryStore) queueItem(q *memQueueItem, m Message) QueueItem {
+	return QueueItem{
+		ID:          q.id,
+		MessageID:   q.messageID,
+		Topic:       m.Topic,
+		Token:       q.token,
+		Status:      q.status,
+		CreatedAt:   q.createdAt,
+		DeliveredAt: q.deliveredAt,
+		OpenedAt:    q.openedAt,
+		Attempts:    q.attempts,
+		LastError:   q.lastError,
+	}
+}
+
+// payloadCopies hands out copies of the payloads of queue items, made once
+// per distinct payload in a read and shared by the items using it.
+type payloadCopies map[payloadKey][]byte
+
+type payloadKey struct {
+	hash      string
+	messageID int64 // Of items without a payload of their own
+}
+
+func (c payloadCopies) of(q *memQueueItem, m Message) []byte {
+	key, payload := payloadKey{hash: q.payloadHash}, q.payload
+	if payload == nil {
+		key, payload = payloadKey{messageID: m.ID}, m.Payload
+	}
+	p, ok := c[key]
+	if !ok {
+		p = bytes.Clone(payload)
+		c[key] = p
+	}
+	return p
+}
+
+func (s *MemoryStore) GetPendingMessages(token string) ([]QueueItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var items []QueueItem
+	payloads := payloadCopies{}
+	for _, q := range s.queue {
+		if q.token != token || q.status != "pending" {
+			continue
+		}
+		if m, ok := s.message(q.messageID); ok {
+			item := s.queueItem(q, m)
+			item.Payload = payloads.of(q, m)
+			item.CreatedAt = time.Time{} // Not selected by SQLiteStore either
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].MessageID < items[j].MessageID })
+	return items, nil
+}
+
+// pendingWhere lists pending items whose message matches keep, along with
+// their provider and the options of their token's subscription to the
+// message's topic. Items without one are skipped. The caller holds mu.
+func (s *MemoryStore) pendingWhere(keep func(Message) bool) []QueueItem {
+	var items []QueueItem
+	payloads := payloadCopies{}
+	for _, q := range s.queue {
+		if q.status != "pending" {
+			continue
+		}
+		m, ok := s.message(q.messageID)
+		if !ok || !keep(m) {
+			continue
+		}
+		i := s.findSubscription(m.Topic, q.token)
+		if i < 0 || s.paused(s.subscriptions[i]) {
+			continue
+		}
+		item := s.queueItem(q, m)
+		item.Payload = payloads.of(q, m)
+		item.Provider = q.provider
+		if item.Provider == "" {
+			item.Provider = s.subscriptions[i].Provider
+		}
+		item.Options = cloneOptions(s.subscriptions[i].Options)
+		items = append(items, item)
+	}
+	return items
+}
+
+func (s *MemoryStore) GetAllPendingMessages() ([]QueueItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pendingWhere(func(Message) bool { return true }), nil
+}
+
+func (s *MemoryStore) GetPendingMessagesByTopic(topic string) ([]QueueItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pendingWhere(func(m Message) bool { return m.Topic == topic }), nil
+}
+
+func (s *MemoryStore) CountPending(topic string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var count int64
+	for _, q := range s.queue {
+		if q.status != "pending" {
+			continue
+		}
+		if m, ok := s.message(q.messageID); ok && (topic == "" || m.Topic == topic) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) CountQueuedSince(topic string, tokens []string, since time.Time) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := map[string]int{}
+	for _, q := range s.queue {
+		if q.createdAt.Before(since) || !slices.Contains(tokens, q.token) {
+			continue
+		}
+		if m, ok := s.message(q.messageID); ok && m.Topic == topic {
+			counts[q.token]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) GetQueueItemsByMessage(messageID int64) ([]QueueItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.message(messageID)
+	if !ok {
+		return nil, nil
+	}
+	var items []QueueItem
+	for _, q := range s.queue {
+		if q.messageID == messageID {
+			item := s.queueItem(q, m)
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (s *MemoryStore) ListQueueOutcomes(since time.Time) ([]QueueItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var items []QueueItem
+	for _, q := range s.queue {
+		if q.createdAt.Before(since) || q.status != "delivered" && q.status != "failed" {
+			continue
+		}
+		m, _ := s.message(q.messageID)
+		items = append(items, s.queueItem(q, m))
+	}
+	return items, nil
+}
+
+// queueItemByID looks up a queue item. The caller holds mu.
+func (s *MemoryStore) queueItemByID(id int64) *memQueueItem {
+	i, ok := slices.BinarySearchFunc(s.queue, id, func(q *memQueueItem, id int64) int {
+		return cmp.Compare(q.id, id)
+	})
+	if !ok {
+		return nil
+	}
+	return s.queue[i]
+}
+
+func (s *MemoryStore) MarkDelivered(queueID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q := s.queueItemByID(queueID); q != nil {
+		t := queueNow()
+		q.status, q.deliveredAt = "delivered", &t
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkOpened(messageID int64, token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, q := range s.queue {
+		if q.messageID == messageID && q.token == token {
+			if q.openedAt == nil {
+				t := now()
+				q.openedAt = &t
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+func (s *MemoryStore) ListMessageOpens(limit int) ([]MessageOpens, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	byMessage := map[int64]*MessageOpens{}
+	for _, q := range s.queue {
+		o := byMessage[q.messageID]
+		if o == nil {
+			m, _ := s.message(q.messageID)
+			o = &MessageOpens{MessageID: q.messageID, Topic: m.Topic}
+			byMessage[q.messageID] = o
+		}
+		o.count(q.status, q.openedAt)
+	}
+	return latestOpens(byMessage, limit), nil
+}
+
+// count adds a queue item to the totals.
+func (o *MessageOpens) count(status string, openedAt *time.Time) {
+	if status == "delivered" {
+		o.Delivered++
+	}
+	if openedAt != nil {
+		o.Opened++
+	}
+}
+
+// latestOpens returns up to limit of the messages with deliveries, newest first.
+func latestOpens(byMessage map[int64]*MessageOpens, limit int) []MessageOpens {
+	opens := []MessageOpens{}
+	for _, o := range byMessage {
+		if o.Delivered > 0 {
+			opens = append(opens, *o)
+		}
+	}
+	slices.SortFunc(opens, func(a, b MessageOpens) int { return cmp.Compare(b.MessageID, a.MessageID) })
+	if len(opens) > limit {
+		opens = opens[:limit]
+	}
+	return opens
+}
+
+func (s *MemoryStore) RecordAttempt(a Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordAttempt(a)
+	return nil
+}
+
+func (s *MemoryStore) SettleAttempts(attempts []Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attempts {
+		if q := s.recordAttempt(a); q != nil && a.Error == "" {
+			t := queueNow()
+			q.status, q.deliveredAt = "delivered", &t
+		}
+	}
+	return nil
+}
+
+// recordAttempt logs an attempt and returns its queue item, nil if it
+// doesn't exist. The caller holds mu.
+func (s *MemoryStore) recordAttempt(a Attempt) *memQueueItem {
+	q := s.queueItemByID(a.QueueID)
+	if q == nil {
+		return nil
+	}
+	q.attempts++
+	if a.Error != "" {
+		q.lastError = a.Error
+	}
+	a.AttemptedAt = a.AttemptedAt.UTC()
+	s.attempts[a.QueueID] = append(s.attempts[a.QueueID], a)
+	return q
+}
+
+func (s *MemoryStore) ListAttempts(topic string, queueID int64) ([]Attempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q := s.queueItemByID(queueID)
+	if q == nil {
+		return nil, nil
+	}
+	if m, ok := s.message(q.messageID); !ok || m.Topic != topic {
+		return nil, nil
+	}
+	return slices.Clone(s.attempts[queueID]), nil
+}
+
+func (s *MemoryStore) ProviderStats(since time.Time) ([]ProviderStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var agg providerStatsAggregator
+	for _, attempts := range s.attempts {
+		for i, a := range attempts {
+			if !a.AttemptedAt.Before(since) {
+				agg.add(a, i > 0)
+			}
+		}
+	}
+	return agg.stats(), nil
+}
+
+// providerStatsAggregator sums up attempts per provider.
+type providerStatsAggregator struct {
+	byProvider map[string]*ProviderStats
+	latency    map[string]int64
+}
+
+// add counts an attempt, a retry if it isn't the first of its queue item.
+func (g *providerStatsAggregator) add(a Attempt, retry bool) {
+	if a.Provider == "" {
+		return
+	}
+	if g.byProvider == nil {
+		g.byProvider, g.latency = map[string]*ProviderStats{}, map[string]int64{}
+	}
+	p, ok := g.byProvider[a.Provider]
+	if !ok {
+		p = &ProviderStats{Provider: a.Provider}
+		g.byProvider[a.Provider] = p
+	}
+	if a.Error == "" {
+		p.Sent++
+	} else {
+		p.Failed++
+	}
+	if retry {
+		p.Retried++
+	}
+	g.latency[a.Provider] += a.DurationMs
+}
+
+func (g *providerStatsAggregator) stats() []ProviderStats {
+	var stats []ProviderStats
+	for name, p := range g.byProvider {
+		p.AvgLatencyMs = float64(g.latency[name]) / float64(p.Sent+p.Failed)
+		stats = append(stats, *p)
+	}
+	slices.SortFunc(stats, func(a, b ProviderStats) int { return cmp.Compare(a.Provider, b.Provider) })
+	return stats
+}
+
+func (s *MemoryStore) MarkFailed(queueID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q := s.queueItemByID(queueID); q != nil && q.status == "pending" {
+		q.status = "failed"
+	}
+	return nil
+}
+
+func (s *MemoryStore) CancelQueueItem(topic string, queueID int64) (bool, error) {
+	return s.setQueueStatus(topic, queueID, "canceled", "pending"), nil
+}
+
+func (s *MemoryStore) RequeueQueueItem(topic string, queueID int64) (bool, error) {
+	return s.setQueueStatus(topic, queueID, "pending", "failed", "canceled"), nil
+}
+
+// setQueueStatus moves a queue item of a topic's message from one of the
+// from statuses to status, releasing its claim.
+func (s *MemoryStore) setQueueStatus(topic string, queueID int64, status string, from ...string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queueItemByID(queueID)
+	if q == nil || !slices.Contains(from, q.status) {
+		return false
+	}
+	if m, ok := s.message(q.messageID); !ok || m.Topic != topic {
+		return false
+	}
+	q.status, q.claimedBy, q.claimedUntil = status, "", time.Time{}
+	return true
+}
+
+func (s *MemoryStore) PurgeQueue(topic, token string) (int64, error) {
+	if topic == "" && token == "" {
+		return 0, fmt.Errorf("topic or token is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, q := range s.queue {
+		if q.status != "pending" || (token != "" && q.token != token) {
+			continue
+		}
+		if m, ok := s.message(q.messageID); !ok || (topic != "" && m.Topic != topic) {
+			continue
+		}
+		q.status = "canceled"
+		n++
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) ClaimQueueItem(queueID int64, nodeID string, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claim(queueID, nodeID, lease), nil
+}
+
+func (s *MemoryStore) ClaimQueueItems(queueIDs []int64, nodeID string, lease time.Duration) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claimed := make([]int64, 0, len(queueIDs))
+	for _, id := range queueIDs {
+		if s.claim(id, nodeID, lease) {
+			claimed = append(claimed, id)
+		}
+	}
+	return claimed, nil
+}
+
+// claim leases a pending queue item to nodeID. The caller holds mu.
+func (s *MemoryStore) claim(queueID int64, nodeID string, lease time.Duration) bool {
+	q := s.queueItemByID(queueID)
+	if q == nil || q.status != "pending" {
+		return false
+	}
+	t := time.Now().UTC()
+	if q.claimedBy != "" && q.claimedBy != nodeID && !q.claimedUntil.Before(t) {
+		return false
+	}
+	q.claimedBy, q.claimedUntil = nodeID, t.Add(lease)
+	return true
+}
+
+// Stats
+func (s *MemoryStore) GetTotalMessagesSent() (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.messages)), nil
+}
+
+func (s *MemoryStore) RecordStats(bucket time.Time, counts, peaks map[string]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket = bucket.UTC().Truncate(time.Hour)
+	for metric, n := range counts {
+		s.stats[statKey{bucket, metric}] += n
+	}
+	for metric, n := range peaks {
+		k := statKey{bucket, metric}
+		s.stats[k] = max(s.stats[k], n)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListStats(from, to time.Time) ([]StatPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var points []StatPoint
+	for k, v := range s.stats {
+		if !k.bucket.Before(from) && k.bucket.Before(to) {
+			points = append(points, StatPoint{Bucket: k.bucket, Metric: k.metric, Value: v})
+		}
+	}
+	slices.SortFunc(points, func(a, b StatPoint) int {
+		return cmp.Or(a.Bucket.Compare(b.Bucket), cmp.Compare(a.Metric, b.Metric))
+	})
+	return points, nil
+}
+
+func (s *MemoryStore) DeleteStatsBefore(t time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for k := range s.stats {
+		if k.bucket.Before(t) {
+			delete(s.stats, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Sessions
+
+func (s *MemoryStore) CreateSession(sess Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[sess.ID]; ok {
+		return fmt.Errorf("session %w: %s", ErrDuplicate, sess.ID)
+	}
+	t := time.Now()
+	for id, other := range s.sessions {
+		if other.Username == sess.Username && !other.ExpiresAt.After(t) {
+			delete(s.sessions, id)
+		}
+	}
+	sess.LastUsedAt, sess.LastIP = nil, ""
+	s.sessions[sess.ID] = &sess
+	return nil
+}
+
+func cloneSession(sess *Session) *Session {
+	c := *sess
+	if sess.LastUsedAt != nil {
+		at := *sess.LastUsedAt
+		c.LastUsedAt = &at
+	}
+	return &c
+}
+
+func (s *MemoryStore) GetSession(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneSession(sess), nil
+}
+
+func (s *MemoryStore) TouchSession(id, ip string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[id]; ok {
+		sess.LastUsedAt, sess.LastIP = &at, ip
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListSessions(username string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t := time.Now()
+	sessions := []Session{}
+	for _, sess := range s.sessions {
+		if sess.Username == username && sess.ExpiresAt.After(t) {
+			sessions = append(sessions, *cloneSession(sess))
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+// sortSessions orders sessions newest first.
+func sortSessions(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].IssuedAt.Equal(sessions[j].IssuedAt) {
+			return sessions[i].IssuedAt.After(sessions[j].IssuedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+}
+
+func (s *MemoryStore) DeleteSession(username, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok || sess.Username != username {
+		return false, nil
+	}
+	delete(s.sessions, id)
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(username string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, sess := range s.sessions {
+		if sess.Username == username {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"no-spam/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// legacyColumns were added with ALTER TABLE before versioned migrations
+// existed. Databases from that time may lack some of them, so they are added
+// before the initial migration is recorded.
+var legacyColumns = []string{
+	`ALTER TABLE subscriptions ADD COLUMN username TEXT;`,
+	`ALTER TABLE subscriptions ADD COLUMN options TEXT;`,
+	`ALTER TABLE subscriptions ADD COLUMN locale TEXT;`,
+	`ALTER TABLE subscriptions ADD COLUMN platform TEXT;`,
+	`ALTER TABLE subscriptions ADD COLUMN app_version TEXT;`,
+	`ALTER TABLE subscriptions ADD COLUMN tags TEXT;`,
+	`ALTER TABLE queue ADD COLUMN payload BLOB;`,
+	`ALTER TABLE queue ADD COLUMN claimed_by TEXT;`,
+	`ALTER TABLE queue ADD COLUMN claimed_until DATETIME;`,
+	`ALTER TABLE topics ADD COLUMN schema TEXT;`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT;`,
+	`ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN DEFAULT 0;`,
+	`ALTER TABLE users ADD COLUMN recovery_codes TEXT;`,
+	`ALTER TABLE topics ADD COLUMN approval_threshold INTEGER DEFAULT 0;`,
+}
+
+// SQLiteMigrations returns the embedded SQLite migrations in version order.
+func SQLiteMigrations() ([]migrate.Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(sub)
+}
+
+// NewSQLiteMigrator returns a migrator for db. A database created before
+// versioned migrations is first given the columns the initial migration
+// expects, so that applying it only records the version.
+func NewSQLiteMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	migrations, err := SQLiteMigrations()
+	if err != nil {
+		return nil, err
+	}
+	m := migrate.New(db, migrations)
+
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		var legacy int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'topics'`).Scan(&legacy); err != nil {
+			return nil, err
+		}
+		if legacy > 0 {
+			for _, q := range legacyColumns {
+				_, _ = db.Exec(q) // Fails if the column already exists
+			}
+		}
+	}
+	return m, nil
+}
+
+// OpenSQLiteDB opens the database at path with the store's connection
+// settings, without migrating it.
+func OpenSQLiteDB(path string) (*sql.DB, error) {
+	db, err := openSQLite(sqliteDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (s *SQLiteStore) initSchema() error {
+	m, err := NewSQLiteMigrator(s.writer)
+	if err != nil {
+		return fmt.Errorf("error loading migrations: %v", err)
+	}
+	if err := m.Up(0); err != nil {
+		return fmt.Errorf("error migrating schema: %v", err)
+	}
+	if s.fts, err = initSearchIndex(s.writer); err != nil {
+		return fmt.Errorf("error creating search index: %v", err)
+	}
+	return nil
+}
+
+// searchTriggers keep messages_fts in step with the messages table,
+// indexing payloads decompressed.
+var searchTriggers = map[string]string{
+	"messages_fts_insert": `CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts(rowid, payload) VALUES (new.id, payload_text(new.payload));
+	END`,
+	"messages_fts_delete": `CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, payload) VALUES ('delete', old.id, payload_text(old.payload));
+	END`,
+	"messages_fts_update": `CREATE TRIGGER messages_fts_update AFTER UPDATE OF payload ON messages BEGIN
+		INSERT INTO messages_fts(messages_fts, rowid, payload) VALUES ('delete', old.id, payload_text(old.payload));
+		INSERT INTO messages_fts(rowid, payload) VALUES (new.id, payload_text(new.payload));
+	END`,
+}
+
+// initSearchIndex indexes message payloads with FTS5 and reports whether it
+// could. FTS5 is only compiled in with the sqlite_fts5 build tag, so it
+// isn't a migration: without it, the triggers that would fail every insert
+// are dropped, and the index is rebuilt the next time FTS5 is available.
+func initSearchIndex(db *sql.DB) (bool, error) {
+	var enabled bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return false, err
+	}
+	if !enabled {
+		for name := range searchTriggers {
+			if _, err := db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	var triggers int
+	// Triggers from before payload compression are replaced
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'messages_fts_%' AND sql LIKE '%payload_text%'`).Scan(&triggers); err != nil {
+		return false, err
+	}
+	if triggers == len(searchTriggers) {
+		return true, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(payload, content='messages', content_rowid='id')`); err != nil {
+		return false, err
+	}
+	for name, q := range searchTriggers {
+		if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(q); err != nil {
+			return false, err
+		}
+	}
+	// 'rebuild' would index the stored, possibly compressed, payloads
+	if _, err := tx.Exec(`INSERT INTO messages_fts(messages_fts) VALUES ('delete-all')`); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO messages_fts(rowid, payload) SELECT id, payload_text(payload) FROM messages`); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
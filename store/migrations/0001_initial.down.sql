@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS roles;
+DROP TRIGGER IF EXISTS audit_log_no_delete;
+DROP TRIGGER IF EXISTS audit_log_no_update;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS approvals;
+DROP TABLE IF EXISTS moderation_log;
+DROP TABLE IF EXISTS filter_rules;
+DROP TABLE IF EXISTS templates;
+DROP TABLE IF EXISTS queue;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS topics;
@@ -0,0 +1,125 @@
+-- Schema as of the introduction of versioned migrations. Statements use
+-- IF NOT EXISTS so that databases created earlier can adopt it.
+
+CREATE TABLE IF NOT EXISTS topics (
+	name TEXT PRIMARY KEY,
+	schema TEXT,
+	approval_threshold INTEGER DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	topic TEXT,
+	token TEXT,
+	provider TEXT,
+	username TEXT,
+	options TEXT,
+	locale TEXT,
+	platform TEXT,
+	app_version TEXT,
+	tags TEXT,
+	PRIMARY KEY (topic, token),
+	FOREIGN KEY(topic) REFERENCES topics(name)
+);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_token ON subscriptions(token);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_username ON subscriptions(username);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT,
+	payload BLOB,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_messages_topic_created ON messages(topic, created_at);
+
+CREATE TABLE IF NOT EXISTS queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER,
+	token TEXT,
+	status TEXT DEFAULT 'pending',
+	payload BLOB,
+	claimed_by TEXT,
+	claimed_until DATETIME,
+	FOREIGN KEY(message_id) REFERENCES messages(id)
+);
+CREATE INDEX IF NOT EXISTS idx_queue_token_status ON queue(token, status);
+CREATE INDEX IF NOT EXISTS idx_queue_status ON queue(status);
+
+CREATE TABLE IF NOT EXISTS templates (
+	topic TEXT,
+	name TEXT,
+	locale TEXT DEFAULT '',
+	body TEXT,
+	PRIMARY KEY (topic, name, locale),
+	FOREIGN KEY(topic) REFERENCES topics(name)
+);
+
+CREATE TABLE IF NOT EXISTS filter_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT DEFAULT '',
+	type TEXT,
+	pattern TEXT,
+	record BOOLEAN DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	rule_id INTEGER,
+	publisher TEXT,
+	topic TEXT,
+	reason TEXT,
+	payload BLOB,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS approvals (
+	message_id INTEGER PRIMARY KEY,
+	topic TEXT,
+	publisher TEXT,
+	audience INTEGER,
+	request BLOB,
+	status TEXT DEFAULT 'pending',
+	decided_by TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	decided_at DATETIME,
+	FOREIGN KEY(message_id) REFERENCES messages(id)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor TEXT,
+	ip TEXT,
+	action TEXT,
+	target TEXT,
+	details TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+
+CREATE TABLE IF NOT EXISTS roles (
+	name TEXT PRIMARY KEY,
+	permissions TEXT
+);
+
+CREATE TABLE IF NOT EXISTS invitations (
+	code TEXT PRIMARY KEY,
+	role TEXT,
+	max_uses INTEGER DEFAULT 1,
+	uses INTEGER DEFAULT 0,
+	expires_at DATETIME,
+	created_by TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	username TEXT PRIMARY KEY,
+	password_hash TEXT,
+	role TEXT,
+	totp_secret TEXT,
+	totp_enabled BOOLEAN DEFAULT 0,
+	recovery_codes TEXT
+);
@@ -0,0 +1,3 @@
+-- Users who must pick a new password at their next login, such as the
+-- generated initial admin.
+ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT 0;
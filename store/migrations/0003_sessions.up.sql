@@ -0,0 +1,12 @@
+-- Issued access tokens, so they can be listed and revoked per user.
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	method TEXT,
+	ip TEXT,
+	issued_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	last_used_at DATETIME,
+	last_ip TEXT
+);
+CREATE INDEX idx_sessions_username ON sessions(username);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_queue_message;
@@ -0,0 +1,2 @@
+-- Looks up the queue items of a message, e.g. to report on its fan-out.
+CREATE INDEX IF NOT EXISTS idx_queue_message ON queue(message_id);
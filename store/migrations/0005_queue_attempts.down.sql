@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS queue_attempts;
+ALTER TABLE queue DROP COLUMN last_error;
+ALTER TABLE queue DROP COLUMN attempts;
+ALTER TABLE queue DROP COLUMN delivered_at;
+ALTER TABLE queue DROP COLUMN created_at;
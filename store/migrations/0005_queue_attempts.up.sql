@@ -0,0 +1,16 @@
+-- When each queue item was queued and delivered, and a log of its delivery
+-- attempts.
+ALTER TABLE queue ADD COLUMN created_at DATETIME;
+ALTER TABLE queue ADD COLUMN delivered_at DATETIME;
+ALTER TABLE queue ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE queue ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+UPDATE queue SET created_at = (SELECT created_at FROM messages WHERE messages.id = queue.message_id);
+
+CREATE TABLE queue_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	queue_id INTEGER NOT NULL,
+	node TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	attempted_at DATETIME NOT NULL
+);
+CREATE INDEX idx_queue_attempts_queue ON queue_attempts(queue_id);
@@ -0,0 +1,5 @@
+ALTER TABLE topics DROP COLUMN retention_days;
+ALTER TABLE topics DROP COLUMN replay_count;
+ALTER TABLE topics DROP COLUMN created_at;
+ALTER TABLE topics DROP COLUMN owner;
+ALTER TABLE topics DROP COLUMN description;
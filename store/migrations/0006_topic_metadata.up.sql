@@ -0,0 +1,7 @@
+-- Topic metadata and default settings. Topics created earlier have no
+-- created_at.
+ALTER TABLE topics ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE topics ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE topics ADD COLUMN created_at DATETIME;
+ALTER TABLE topics ADD COLUMN replay_count INTEGER;
+ALTER TABLE topics ADD COLUMN retention_days INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Old names of renamed topics that still resolve to them.
+CREATE TABLE topic_aliases (
+	alias TEXT PRIMARY KEY,
+	topic TEXT NOT NULL
+);
+CREATE INDEX idx_topic_aliases_topic ON topic_aliases(topic);
@@ -0,0 +1 @@
+ALTER TABLE queue DROP COLUMN opened_at;
@@ -0,0 +1,2 @@
+-- When a delivered notification was opened, as reported by the device.
+ALTER TABLE queue ADD COLUMN opened_at DATETIME;
@@ -0,0 +1,2 @@
+-- Public topics have a subscription page anyone can open.
+ALTER TABLE topics ADD COLUMN public INTEGER NOT NULL DEFAULT 0;
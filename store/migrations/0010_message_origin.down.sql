@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN user_agent;
+ALTER TABLE messages DROP COLUMN source_ip;
+ALTER TABLE messages DROP COLUMN publisher;
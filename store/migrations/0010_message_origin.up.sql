@@ -0,0 +1,4 @@
+-- Who published a message and from where. Empty for messages stored earlier.
+ALTER TABLE messages ADD COLUMN publisher TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN source_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- Hourly metrics behind GET /admin/stats.
+CREATE TABLE stats (
+	bucket DATETIME NOT NULL,
+	metric TEXT NOT NULL,
+	value INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (bucket, metric)
+);
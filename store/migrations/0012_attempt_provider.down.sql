@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_queue_attempts_time;
+ALTER TABLE queue_attempts DROP COLUMN duration_ms;
+ALTER TABLE queue_attempts DROP COLUMN provider;
@@ -0,0 +1,4 @@
+-- The provider and duration of each delivery attempt, for per-provider stats.
+ALTER TABLE queue_attempts ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE queue_attempts ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_queue_attempts_time ON queue_attempts(attempted_at);
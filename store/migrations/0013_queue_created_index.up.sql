@@ -0,0 +1,2 @@
+-- Delivery latency is measured over the items queued in a recent window.
+CREATE INDEX idx_queue_created ON queue(created_at);
@@ -0,0 +1,5 @@
+UPDATE queue SET payload = (SELECT data FROM payloads WHERE hash = queue.payload_hash)
+WHERE payload_hash IS NOT NULL;
+DROP INDEX IF EXISTS idx_queue_payload_hash;
+ALTER TABLE queue DROP COLUMN payload_hash;
+DROP TABLE IF EXISTS payloads;
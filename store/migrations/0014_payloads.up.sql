@@ -0,0 +1,9 @@
+-- Per-subscriber payloads stored once per distinct content, by SHA-256, and
+-- shared by every queue item of a fan-out. Items queued before keep their
+-- own copy in queue.payload.
+CREATE TABLE payloads (
+	hash TEXT PRIMARY KEY,
+	data BLOB NOT NULL
+);
+ALTER TABLE queue ADD COLUMN payload_hash TEXT;
+CREATE INDEX idx_queue_payload_hash ON queue(payload_hash) WHERE payload_hash IS NOT NULL;
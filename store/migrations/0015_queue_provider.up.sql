@@ -0,0 +1,4 @@
+-- The provider each queue item is delivered through, bound at enqueue time
+-- from the subscription it was queued for. Items queued before have '' and
+-- use their subscription's current provider.
+ALTER TABLE queue ADD COLUMN provider TEXT NOT NULL DEFAULT '';
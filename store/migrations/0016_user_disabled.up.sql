@@ -0,0 +1,3 @@
+-- Disabled users can't log in or get tokens, and nothing is delivered to
+-- their subscriptions until they are reactivated.
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Profile fields users edit themselves through PATCH /me.
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN last_seen_at;
+ALTER TABLE subscriptions DROP COLUMN created_at;
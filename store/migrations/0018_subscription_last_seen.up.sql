@@ -0,0 +1,5 @@
+-- When each subscription was created and last delivered to, to find dead
+-- device tokens. Existing subscriptions count from the upgrade.
+ALTER TABLE subscriptions ADD COLUMN created_at DATETIME;
+ALTER TABLE subscriptions ADD COLUMN last_seen_at DATETIME;
+UPDATE subscriptions SET created_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,3 @@
+-- Subscribers' own delivery preferences, as JSON: mute, minimum priority
+-- and daily cap.
+ALTER TABLE subscriptions ADD COLUMN preferences TEXT;
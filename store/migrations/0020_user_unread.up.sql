@@ -0,0 +1,2 @@
+-- Server-side unread counter shown as the badge of "unread" notifications.
+ALTER TABLE users ADD COLUMN unread INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Last sequence number given to a message of the topic.
+ALTER TABLE topics ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,26 @@
+-- Recurring publishes to a topic on a cron expression, and their latest runs.
+CREATE TABLE schedules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT NOT NULL,
+	cron TEXT NOT NULL,
+	timezone TEXT NOT NULL DEFAULT 'UTC',
+	request BLOB NOT NULL,
+	paused INTEGER NOT NULL DEFAULT 0,
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	next_run DATETIME NOT NULL
+);
+CREATE INDEX idx_schedules_topic ON schedules(topic);
+CREATE INDEX idx_schedules_next_run ON schedules(next_run);
+
+CREATE TABLE schedule_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	schedule_id INTEGER NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+	scheduled_at DATETIME NOT NULL,
+	ran_at DATETIME NOT NULL,
+	status TEXT NOT NULL,
+	message_id INTEGER NOT NULL DEFAULT 0,
+	enqueued INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_schedule_runs_schedule ON schedule_runs(schedule_id, id);
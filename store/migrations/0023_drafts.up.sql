@@ -0,0 +1,13 @@
+-- Topic sends saved for preview and test sends before they are published.
+CREATE TABLE drafts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT NOT NULL,
+	request BLOB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'draft',
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	published_at DATETIME,
+	message_id INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_drafts_topic ON drafts(topic);
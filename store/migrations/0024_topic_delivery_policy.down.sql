@@ -0,0 +1,3 @@
+ALTER TABLE topics DROP COLUMN ttl_seconds;
+ALTER TABLE topics DROP COLUMN retry_backoff_seconds;
+ALTER TABLE topics DROP COLUMN max_attempts;
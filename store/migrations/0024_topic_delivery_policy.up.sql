@@ -0,0 +1,4 @@
+-- Per-topic delivery policy; 0 keeps retrying until delivered.
+ALTER TABLE topics ADD COLUMN max_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE topics ADD COLUMN retry_backoff_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE topics ADD COLUMN ttl_seconds INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Identity provider account (issuer and subject) of users created through OIDC.
+ALTER TABLE users ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
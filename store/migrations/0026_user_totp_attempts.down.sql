@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN totp_failed_at;
+ALTER TABLE users DROP COLUMN totp_failures;
+ALTER TABLE users DROP COLUMN totp_step;
@@ -0,0 +1,5 @@
+-- Time step of the last accepted TOTP code, so a code can't be used twice,
+-- and the failed second factor attempts in a row.
+ALTER TABLE users ADD COLUMN totp_step INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_failed_at TIMESTAMP;
@@ -2,81 +2,309 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type SQLiteStore struct {
-	db *sql.DB
+	db     *sql.DB // Reads; WAL lets them run alongside the writer
+	writer *sql.DB // Writes and transactions, serialized over a single connection
+	stmts  statements
+	fts    bool // Message payloads are indexed with FTS5
+
+	compression string // Algorithm for payloads of at least compressAt bytes
+	compressAt  int
+}
+
+// sqliteQueueNow is the time queue items are queued and delivered at, to the
+// millisecond so delivery latency can be measured.
+const sqliteQueueNow = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// statements are prepared once for the queries on the publish and delivery hot path.
+type statements struct {
+	enqueue     *sql.Stmt
+	pending     *sql.Stmt
+	subscribers *sql.Stmt
+}
+
+const pendingMessagesQuery = `
+	SELECT q.id, q.message_id, m.topic, q.token, COALESCE(NULLIF(q.provider, ''), s.provider), q.status,
+		q.payload, q.payload_hash, q.created_at, q.delivered_at, q.attempts, q.last_error, s.options
+	FROM queue q
+	JOIN messages m ON q.message_id = m.id
+	JOIN subscriptions s ON s.topic = m.topic AND s.token = q.token
+	WHERE q.status = 'pending' AND NOT EXISTS (SELECT 1 FROM users u WHERE u.username = s.username AND u.disabled)`
+
+// pendingItems reads the rows of pendingMessagesQuery, then their payloads.
+func (s *SQLiteStore) pendingItems(rows *sql.Rows) ([]QueueItem, error) {
+	defer rows.Close()
+	var items []QueueItem
+	var hashes []string
+	for rows.Next() {
+		var i QueueItem
+		var hash, options sql.NullString
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&i.ID, &i.MessageID, &i.Topic, &i.Token, &i.Provider, &i.Status, &i.Payload, &hash,
+			&i.CreatedAt, &deliveredAt, &i.Attempts, &i.LastError, &options); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			i.DeliveredAt = &deliveredAt.Time
+		}
+		i.Options = decodeOptions(options)
+		items = append(items, i)
+		hashes = append(hashes, hash.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, s.loadPayloads(items, hashes)
+}
+
+// payloadBatch caps the keys of a payload lookup, below SQLite's bound
+// parameter limit.
+const payloadBatch = 500
+
+// loadPayloads fills in the payloads of queue items read with their own
+// payload, if queued with one before payloads were stored by hash, and the
+// hash of their stored payload, if any. Each distinct message and stored
+// payload is read and decompressed once, and shared by the items using it,
+// rather than joined into every row of a fan-out.
+func (s *SQLiteStore) loadPayloads(items []QueueItem, hashes []string) error {
+	messages := map[int64][]byte{}
+	stored := map[string][]byte{}
+	for i, item := range items {
+		switch {
+		case item.Payload != nil:
+			items[i].Payload = decompressPayload(item.Payload)
+		case hashes[i] != "":
+			stored[hashes[i]] = nil
+		default:
+			messages[item.MessageID] = nil
+		}
+	}
+	if err := selectPayloads(s.db, `SELECT id, payload FROM messages WHERE id IN `, messages); err != nil {
+		return err
+	}
+	if err := selectPayloads(s.db, `SELECT hash, data FROM payloads WHERE hash IN `, stored); err != nil {
+		return err
+	}
+	for i, item := range items {
+		switch {
+		case item.Payload != nil:
+		case hashes[i] != "":
+			items[i].Payload = stored[hashes[i]]
+		default:
+			items[i].Payload = messages[item.MessageID]
+		}
+	}
+	return nil
+}
+
+// selectPayloads runs query, selecting a key and a payload with the keys of
+// m appended as an IN list, and sets the decompressed payloads in m.
+func selectPayloads[K comparable](db *sql.DB, query string, m map[K][]byte) error {
+	keys := make([]any, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	for len(keys) > 0 {
+		n := min(len(keys), payloadBatch)
+		rows, err := db.Query(query+`(?`+strings.Repeat(`, ?`, n-1)+`)`, keys[:n]...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var k K
+			var payload []byte
+			if err := rows.Scan(&k, &payload); err != nil {
+				rows.Close()
+				return err
+			}
+			m[k] = decompressPayload(payload)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
 }
 
+// sqliteParams tune every connection: WAL for concurrent readers, a busy
+// timeout instead of immediate "database is locked" errors, enforced
+// foreign keys, and transactions that take the write lock when they begin
+// so they can't deadlock upgrading from a read lock.
+const sqliteParams = "_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on&_synchronous=NORMAL&_txlock=immediate"
+
 func NewSQLiteStore(path string) (*SQLiteStore, error) {
-	db, err := sql.Open("sqlite3", path)
+	dsn := sqliteDSN(path)
+	writer, err := openSQLite(dsn)
 	if err != nil {
 		return nil, err
 	}
+	writer.SetMaxOpenConns(1)
 
-	if err := db.Ping(); err != nil {
-		return nil, err
+	db := writer
+	// Every connection to an in-memory database gets its own database, so
+	// reads must share the writer's connection.
+	if !isMemoryPath(path) {
+		if db, err = openSQLite(dsn); err != nil {
+			writer.Close()
+			return nil, err
+		}
 	}
 
-	s := &SQLiteStore{db: db}
+	s := &SQLiteStore{db: db, writer: writer}
 	if err := s.initSchema(); err != nil {
 		return nil, err
 	}
+	if err := s.prepare(); err != nil {
+		return nil, err
+	}
 
 	return s, nil
 }
 
-func (s *SQLiteStore) initSchema() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS topics (
-			name TEXT PRIMARY KEY
-		);`,
-		`CREATE TABLE IF NOT EXISTS subscriptions (
-			topic TEXT,
-			token TEXT,
-			provider TEXT,
-			username TEXT,
-			PRIMARY KEY (topic, token),
-			FOREIGN KEY(topic) REFERENCES topics(name)
-		);`,
-		`CREATE TABLE IF NOT EXISTS messages (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			topic TEXT,
-			payload BLOB,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`,
-		`CREATE TABLE IF NOT EXISTS queue (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			message_id INTEGER,
-			token TEXT,
-			status TEXT DEFAULT 'pending',
-			FOREIGN KEY(message_id) REFERENCES messages(id)
-		);`,
-		`CREATE INDEX IF NOT EXISTS idx_queue_token_status ON queue(token, status);`,
-		`CREATE TABLE IF NOT EXISTS users (
-			username TEXT PRIMARY KEY,
-			password_hash TEXT,
-			role TEXT
-		);`,
-	}
-
-	for _, q := range queries {
-		if _, err := s.db.Exec(q); err != nil {
-			return fmt.Errorf("error creating schema: %v", err)
-		}
-	}
-	// Attempt to add username column if it doesn't exist (migration for dev)
-	_, _ = s.db.Exec(`ALTER TABLE subscriptions ADD COLUMN username TEXT;`)
+// prepare compiles the hot-path statements; it must run after initSchema.
+func (s *SQLiteStore) prepare() error {
+	var err error
+	if s.stmts.enqueue, err = s.writer.Prepare(`INSERT INTO queue (message_id, token, status, created_at) VALUES (?, ?, 'pending', ` + sqliteQueueNow + `)`); err != nil {
+		return fmt.Errorf("prepare enqueue: %w", err)
+	}
+	if s.stmts.pending, err = s.db.Prepare(pendingMessagesQuery); err != nil {
+		return fmt.Errorf("prepare pending messages: %w", err)
+	}
+	if s.stmts.subscribers, err = s.db.Prepare(`SELECT ` + subscriberColumns + ` FROM subscriptions WHERE topic = ? AND ` + subscriberActive); err != nil {
+		return fmt.Errorf("prepare subscribers: %w", err)
+	}
 	return nil
 }
 
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + sqliteParams
+	}
+	return path + "?" + sqliteParams
+}
+
+func openSQLite(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func isMemoryPath(path string) bool {
+	return path == ":memory:" || strings.Contains(path, "mode=memory")
+}
+
 // Topics
 func (s *SQLiteStore) CreateTopic(name string) error {
-	_, err := s.db.Exec(`INSERT INTO topics (name) VALUES (?)`, name)
-	return err
+	_, err := s.writer.Exec(`INSERT INTO topics (name, created_at) VALUES (?, CURRENT_TIMESTAMP)`, name)
+	return sqliteError(err)
+}
+
+func (s *SQLiteStore) CreateOwnedTopic(name, owner, prefix string, limit int) (int, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM topics WHERE owner = ? AND instr(name, ?) = 1`, owner, prefix).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count >= limit {
+		return count, fmt.Errorf("topics of %s: %w", owner, ErrLimitReached)
+	}
+	if _, err := tx.Exec(`INSERT INTO topics (name, owner, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, name, owner); err != nil {
+		return count, sqliteError(err)
+	}
+	return count, tx.Commit()
+}
+
+const topicInfoColumns = `name, description, owner, created_at, replay_count, retention_days, public,
+	max_attempts, retry_backoff_seconds, ttl_seconds`
+
+func scanTopicInfo(row interface{ Scan(...any) error }) (TopicInfo, error) {
+	var t TopicInfo
+	var createdAt sql.NullTime
+	var replay sql.NullInt64
+	if err := row.Scan(&t.Name, &t.Description, &t.Owner, &createdAt, &replay, &t.RetentionDays, &t.Public,
+		&t.MaxAttempts, &t.RetryBackoffSeconds, &t.TTLSeconds); err != nil {
+		return t, err
+	}
+	if createdAt.Valid {
+		t.CreatedAt = createdAt.Time
+	}
+	if replay.Valid {
+		n := int(replay.Int64)
+		t.ReplayCount = &n
+	}
+	return t, nil
+}
+
+func (s *SQLiteStore) GetTopicInfo(name string) (*TopicInfo, error) {
+	t, err := scanTopicInfo(s.db.QueryRow(`SELECT `+topicInfoColumns+` FROM topics WHERE name = ?`, name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *SQLiteStore) ListTopicInfo() ([]TopicInfo, error) {
+	rows, err := s.db.Query(`SELECT ` + topicInfoColumns + ` FROM topics ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []TopicInfo
+	for rows.Next() {
+		t, err := scanTopicInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+	return topics, rows.Err()
+}
+
+func (s *SQLiteStore) SetTopicInfo(info TopicInfo) error {
+	var replay interface{}
+	if info.ReplayCount != nil {
+		replay = *info.ReplayCount
+	}
+	res, err := s.writer.Exec(`
+		UPDATE topics SET description = ?, owner = ?, replay_count = ?, retention_days = ?, public = ?,
+			max_attempts = ?, retry_backoff_seconds = ?, ttl_seconds = ?
+		WHERE name = ?`,
+		info.Description, info.Owner, replay, info.RetentionDays, info.Public,
+		info.MaxAttempts, info.RetryBackoffSeconds, info.TTLSeconds, info.Name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, info.Name)
+	}
+	return nil
 }
 
 func (s *SQLiteStore) TopicExists(name string) (bool, error) {
@@ -85,6 +313,50 @@ func (s *SQLiteStore) TopicExists(name string) (bool, error) {
 	return exists, err
 }
 
+func (s *SQLiteStore) SetTopicSchema(name, schema string) error {
+	var value interface{}
+	if schema != "" {
+		value = schema
+	}
+	res, err := s.writer.Exec(`UPDATE topics SET schema = ? WHERE name = ?`, value, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, name)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetTopicSchema(name string) (string, error) {
+	var schema sql.NullString
+	err := s.db.QueryRow(`SELECT schema FROM topics WHERE name = ?`, name).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return schema.String, err
+}
+
+func (s *SQLiteStore) SetTopicApprovalThreshold(name string, threshold int) error {
+	res, err := s.writer.Exec(`UPDATE topics SET approval_threshold = ? WHERE name = ?`, threshold, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, name)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetTopicApprovalThreshold(name string) (int, error) {
+	var threshold sql.NullInt64
+	err := s.db.QueryRow(`SELECT approval_threshold FROM topics WHERE name = ?`, name).Scan(&threshold)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return int(threshold.Int64), err
+}
+
 func (s *SQLiteStore) ListTopics() ([]string, error) {
 	rows, err := s.db.Query(`SELECT name FROM topics`)
 	if err != nil {
@@ -111,7 +383,7 @@ func (s *SQLiteStore) DeleteTopic(name string) error {
 		return fmt.Errorf("failed to check messages: %w", err)
 	}
 	if msgCount > 0 {
-		return fmt.Errorf("cannot delete topic: has %d messages", msgCount)
+		return fmt.Errorf("cannot delete topic: %w: has %d messages", ErrInUse, msgCount)
 	}
 
 	// Check if topic has subscribers
@@ -121,225 +393,1654 @@ func (s *SQLiteStore) DeleteTopic(name string) error {
 		return fmt.Errorf("failed to check subscribers: %w", err)
 	}
 	if subCount > 0 {
-		return fmt.Errorf("cannot delete topic: has %d subscribers", subCount)
+		return fmt.Errorf("cannot delete topic: %w: has %d subscribers", ErrInUse, subCount)
 	}
 
-	// Delete topic
-	_, err = s.db.Exec(`DELETE FROM topics WHERE name = ?`, name)
+	// Delete topic, its templates, schedules, drafts and aliases
+	if _, err = s.writer.Exec(`DELETE FROM templates WHERE topic = ?`, name); err != nil {
+		return err
+	}
+	if _, err = s.writer.Exec(`DELETE FROM schedules WHERE topic = ?`, name); err != nil {
+		return err
+	}
+	if _, err = s.writer.Exec(`DELETE FROM drafts WHERE topic = ?`, name); err != nil {
+		return err
+	}
+	if _, err = s.writer.Exec(`DELETE FROM topic_aliases WHERE topic = ?`, name); err != nil {
+		return err
+	}
+	_, err = s.writer.Exec(`DELETE FROM topics WHERE name = ?`, name)
 	return err
 }
 
-// Subscriptions
-func (s *SQLiteStore) AddSubscription(topic, token, provider, username string) error {
-	_, err := s.db.Exec(`INSERT INTO subscriptions (topic, token, provider, username) VALUES (?, ?, ?, ?)`, topic, token, provider, username)
+func (s *SQLiteStore) RenameTopic(oldName, newName string, alias bool) error {
+	tx, err := s.writer.Begin()
 	if err != nil {
-		// Check for constraint violation? For now, standard error is fine, caller can infer.
-		// Or return specific error "already subscribed"
-		return fmt.Errorf("failed to subscribe: %w", err)
+		return err
 	}
-	return nil
-}
+	defer func() {
+		_ = tx.Rollback()
+	}()
 
-func (s *SQLiteStore) RemoveSubscription(topic, token string) error {
-	_, err := s.db.Exec(`DELETE FROM subscriptions WHERE topic = ? AND token = ?`, topic, token)
-	return err
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM topics WHERE name = ?)`, newName).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("topic %w: %s", ErrDuplicate, newName)
+	}
+	var target string
+	err = tx.QueryRow(`SELECT topic FROM topic_aliases WHERE alias = ?`, newName).Scan(&target)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && target != oldName {
+		return fmt.Errorf("topic %w: %s is an alias of %s", ErrDuplicate, newName, target)
+	}
+
+	// Copy the topic under its new name, move everything referencing it,
+	// then drop the old row, so foreign keys hold throughout
+	res, err := tx.Exec(`
+		INSERT INTO topics (name, schema, approval_threshold, description, owner, created_at, replay_count, retention_days, public, seq,
+			max_attempts, retry_backoff_seconds, ttl_seconds)
+		SELECT ?, schema, approval_threshold, description, owner, created_at, replay_count, retention_days, public, seq,
+			max_attempts, retry_backoff_seconds, ttl_seconds
+		FROM topics WHERE name = ?`,
+		newName, oldName)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("topic %w: %s", ErrNotFound, oldName)
+	}
+	for _, stmt := range []string{
+		`UPDATE subscriptions SET topic = ? WHERE topic = ?`,
+		`UPDATE messages SET topic = ? WHERE topic = ?`,
+		`UPDATE templates SET topic = ? WHERE topic = ?`,
+		`UPDATE filter_rules SET topic = ? WHERE topic = ?`,
+		`UPDATE approvals SET topic = ? WHERE topic = ?`,
+		`UPDATE schedules SET topic = ? WHERE topic = ?`,
+		`UPDATE drafts SET topic = ? WHERE topic = ?`,
+		`UPDATE topic_aliases SET topic = ? WHERE topic = ?`,
+	} {
+		if _, err := tx.Exec(stmt, newName, oldName); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM topic_aliases WHERE alias = ?`, newName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM topics WHERE name = ?`, oldName); err != nil {
+		return err
+	}
+	if alias {
+		if _, err := tx.Exec(`INSERT INTO topic_aliases (alias, topic) VALUES (?, ?)`, oldName, newName); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (s *SQLiteStore) ClearTopicSubscribers(topic string) error {
-	_, err := s.db.Exec(`DELETE FROM subscriptions WHERE topic = ?`, topic)
-	return err
+func (s *SQLiteStore) ResolveTopicAlias(name string) (string, error) {
+	var topic string
+	err := s.db.QueryRow(`SELECT topic FROM topic_aliases WHERE alias = ?`, name).Scan(&topic)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return topic, err
 }
 
-func (s *SQLiteStore) GetSubscribers(topic string) ([]Subscriber, error) {
-	rows, err := s.db.Query(`SELECT topic, token, provider FROM subscriptions WHERE topic = ?`, topic)
+func (s *SQLiteStore) ListTopicAliases() ([]TopicAlias, error) {
+	rows, err := s.db.Query(`SELECT alias, topic FROM topic_aliases ORDER BY alias`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var subs []Subscriber
+	var aliases []TopicAlias
 	for rows.Next() {
-		var sub Subscriber
-		if err := rows.Scan(&sub.Topic, &sub.Token, &sub.Provider); err != nil {
+		var a TopicAlias
+		if err := rows.Scan(&a.Alias, &a.Topic); err != nil {
 			return nil, err
 		}
-		subs = append(subs, sub)
+		aliases = append(aliases, a)
 	}
-	return subs, nil
+	return aliases, rows.Err()
 }
 
-func (s *SQLiteStore) GetSubscriptionsByUser(username string) ([]Subscriber, error) {
-	rows, err := s.db.Query(`SELECT topic, token, provider FROM subscriptions WHERE username = ?`, username)
+func (s *SQLiteStore) DeleteTopicAlias(alias string) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM topic_aliases WHERE alias = ?`, alias)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer rows.Close()
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
 
-	var subs []Subscriber
-	for rows.Next() {
-		var sub Subscriber
-		if err := rows.Scan(&sub.Topic, &sub.Token, &sub.Provider); err != nil {
-			return nil, err
-		}
-		subs = append(subs, sub)
+// Templates
+func (s *SQLiteStore) SaveTemplate(t Template) error {
+	_, err := s.writer.Exec(`INSERT OR REPLACE INTO templates (topic, name, locale, body) VALUES (?, ?, ?, ?)`,
+		t.Topic, t.Name, t.Locale, t.Body)
+	return err
+}
+
+func (s *SQLiteStore) GetTemplate(topic, name, locale string) (*Template, error) {
+	t := Template{Topic: topic, Name: name, Locale: locale}
+	err := s.db.QueryRow(`SELECT body FROM templates WHERE topic = ? AND name = ? AND locale = ?`,
+		topic, name, locale).Scan(&t.Body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-	return subs, nil
+	return &t, nil
 }
 
-func (s *SQLiteStore) GetSubscriptionsByToken(token string) ([]Subscriber, error) {
-	rows, err := s.db.Query(`SELECT topic, token, provider FROM subscriptions WHERE token = ?`, token)
+func (s *SQLiteStore) ListTemplates(topic string) ([]Template, error) {
+	rows, err := s.db.Query(`SELECT topic, name, locale, body FROM templates WHERE topic = ? ORDER BY name, locale`, topic)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var subs []Subscriber
+	templates := []Template{}
 	for rows.Next() {
-		var sub Subscriber
-		if err := rows.Scan(&sub.Topic, &sub.Token, &sub.Provider); err != nil {
+		var t Template
+		if err := rows.Scan(&t.Topic, &t.Name, &t.Locale, &t.Body); err != nil {
 			return nil, err
 		}
-		subs = append(subs, sub)
+		templates = append(templates, t)
 	}
-	return subs, nil
-}
-
-func (s *SQLiteStore) GetSubscriptionCount() (int, error) {
-	var count int
-	err := s.db.QueryRow(`SELECT count(*) FROM subscriptions`).Scan(&count)
-	return count, err
+	return templates, rows.Err()
 }
 
-// Users
-func (s *SQLiteStore) CreateUser(username, passwordHash, role string) error {
-	_, err := s.db.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`, username, passwordHash, role)
+func (s *SQLiteStore) DeleteTemplate(topic, name, locale string) error {
+	if locale == "*" {
+		_, err := s.writer.Exec(`DELETE FROM templates WHERE topic = ? AND name = ?`, topic, name)
+		return err
+	}
+	_, err := s.writer.Exec(`DELETE FROM templates WHERE topic = ? AND name = ? AND locale = ?`, topic, name, locale)
 	return err
 }
 
-func (s *SQLiteStore) DeleteUser(username string) error {
-	res, err := s.db.Exec(`DELETE FROM users WHERE username = ?`, username)
+// Content filtering
+func (s *SQLiteStore) CreateFilterRule(r FilterRule) (int64, error) {
+	res, err := s.writer.Exec(`INSERT INTO filter_rules (topic, type, pattern, record) VALUES (?, ?, ?, ?)`,
+		r.Topic, r.Type, r.Pattern, r.Record)
 	if err != nil {
-		return err
-	}
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("user not found")
+		return 0, err
 	}
-	return nil
+	return res.LastInsertId()
 }
 
-func (s *SQLiteStore) ListUsers() ([]User, error) {
-	rows, err := s.db.Query(`SELECT username, password_hash, role FROM users`)
+func (s *SQLiteStore) ListFilterRules() ([]FilterRule, error) {
+	rows, err := s.db.Query(`SELECT id, topic, type, pattern, record, created_at FROM filter_rules ORDER BY id`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []User
+	rules := []FilterRule{}
 	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.Username, &u.PasswordHash, &u.Role); err != nil {
+		var r FilterRule
+		if err := rows.Scan(&r.ID, &r.Topic, &r.Type, &r.Pattern, &r.Record, &r.CreatedAt); err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		rules = append(rules, r)
 	}
-	return users, nil
+	return rules, rows.Err()
 }
 
-func (s *SQLiteStore) GetUser(username string) (*User, error) {
-	var u User
-	err := s.db.QueryRow(`SELECT username, password_hash, role FROM users WHERE username = ?`, username).Scan(&u.Username, &u.PasswordHash, &u.Role)
-	if err == sql.ErrNoRows {
-		return nil, nil // Not found
-	}
+func (s *SQLiteStore) DeleteFilterRule(id int64) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM filter_rules WHERE id = ?`, id)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return &u, nil
-}
-
-func (s *SQLiteStore) HasAdminUser() (bool, error) {
-	var exists bool
-	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE role = 'admin')`).Scan(&exists)
-	return exists, err
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-func (s *SQLiteStore) UpdateUserRole(username, role string) error {
-	_, err := s.db.Exec(`UPDATE users SET role = ? WHERE username = ?`, role, username)
+func (s *SQLiteStore) AddModerationEntry(e ModerationEntry) error {
+	_, err := s.writer.Exec(`INSERT INTO moderation_log (rule_id, publisher, topic, reason, payload) VALUES (?, ?, ?, ?, ?)`,
+		e.RuleID, e.Publisher, e.Topic, e.Reason, []byte(e.Payload))
 	return err
 }
 
-// Save Message
-func (s *SQLiteStore) SaveMessage(topic string, payload []byte) (int64, error) {
-	res, err := s.db.Exec(`INSERT INTO messages (topic, payload) VALUES (?, ?)`, topic, payload)
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
-}
-
-func (s *SQLiteStore) GetRecentMessages(topic string, limit int) ([]Message, error) {
-	// Fetch newest first to respect limit
-	query := `SELECT id, topic, payload, created_at FROM messages WHERE topic = ? ORDER BY created_at DESC LIMIT ?`
-	rows, err := s.db.Query(query, topic, limit)
+func (s *SQLiteStore) ListModerationEntries(limit int) ([]ModerationEntry, error) {
+	rows, err := s.db.Query(`SELECT id, rule_id, publisher, topic, reason, payload, created_at
+		FROM moderation_log ORDER BY id DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var msgs []Message
+	entries := []ModerationEntry{}
 	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.Topic, &msg.Payload, &msg.CreatedAt); err != nil {
+		var e ModerationEntry
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.Publisher, &e.Topic, &e.Reason, &payload, &e.CreatedAt); err != nil {
 			return nil, err
 		}
-		msgs = append(msgs, msg)
+		e.Payload = payload
+		entries = append(entries, e)
 	}
+	return entries, rows.Err()
+}
 
-	// Reverse to return Oldest -> Newest (Chronological)
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
+// Approvals
+const approvalColumns = `message_id, topic, publisher, audience, request, status, COALESCE(decided_by, ''), created_at, decided_at`
+
+func scanApproval(row interface{ Scan(...interface{}) error }) (*Approval, error) {
+	var a Approval
+	var request []byte
+	var decidedAt sql.NullTime
+	if err := row.Scan(&a.MessageID, &a.Topic, &a.Publisher, &a.Audience, &request, &a.Status, &a.DecidedBy, &a.CreatedAt, &decidedAt); err != nil {
+		return nil, err
 	}
+	a.Request = request
+	if decidedAt.Valid {
+		a.DecidedAt = &decidedAt.Time
+	}
+	return &a, nil
+}
 
-	return msgs, nil
+func (s *SQLiteStore) HoldMessage(a Approval) error {
+	_, err := s.writer.Exec(`INSERT INTO approvals (message_id, topic, publisher, audience, request, status) VALUES (?, ?, ?, ?, ?, ?)`,
+		a.MessageID, a.Topic, a.Publisher, a.Audience, []byte(a.Request), ApprovalPending)
+	return err
 }
 
-func (s *SQLiteStore) ClearTopicMessages(topic string) error {
-	tx, err := s.db.Begin()
+func (s *SQLiteStore) ListApprovals(status string) ([]Approval, error) {
+	query := `SELECT ` + approvalColumns + ` FROM approvals`
+	args := []interface{}{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	rows, err := s.db.Query(query+` ORDER BY message_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := []Approval{}
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, *a)
+	}
+	return approvals, rows.Err()
+}
+
+func (s *SQLiteStore) DecideApproval(messageID int64, status, decidedBy string) (*Approval, error) {
+	res, err := s.writer.Exec(`UPDATE approvals SET status = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP
+		WHERE message_id = ? AND status = ?`, status, decidedBy, messageID, ApprovalPending)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	return scanApproval(s.db.QueryRow(`SELECT `+approvalColumns+` FROM approvals WHERE message_id = ?`, messageID))
+}
+
+// Schedules
+const scheduleColumns = `id, topic, cron, timezone, request, paused, created_by, created_at, next_run`
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (*Schedule, error) {
+	var sc Schedule
+	var request []byte
+	if err := row.Scan(&sc.ID, &sc.Topic, &sc.Cron, &sc.Timezone, &request, &sc.Paused, &sc.CreatedBy, &sc.CreatedAt, &sc.NextRun); err != nil {
+		return nil, err
+	}
+	sc.Request = request
+	return &sc, nil
+}
+
+func (s *SQLiteStore) querySchedules(query string, args ...interface{}) ([]Schedule, error) {
+	rows, err := s.db.Query(`SELECT `+scheduleColumns+` FROM schedules `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		sc, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *sc)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *SQLiteStore) CreateSchedule(sc Schedule) (int64, error) {
+	res, err := s.writer.Exec(`INSERT INTO schedules (topic, cron, timezone, request, paused, created_by, next_run)
+		SELECT ?, ?, ?, ?, ?, ?, ? WHERE EXISTS(SELECT 1 FROM topics WHERE name = ?)`,
+		sc.Topic, sc.Cron, sc.Timezone, []byte(sc.Request), sc.Paused, sc.CreatedBy, sc.NextRun.UTC(), sc.Topic)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, fmt.Errorf("topic %w: %s", ErrNotFound, sc.Topic)
+	}
+	return res.LastInsertId()
+}
+
+func (s *SQLiteStore) GetSchedule(id int64) (*Schedule, error) {
+	sc, err := scanSchedule(s.db.QueryRow(`SELECT `+scheduleColumns+` FROM schedules WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sc, err
+}
+
+func (s *SQLiteStore) ListSchedules(topic string) ([]Schedule, error) {
+	if topic == "" {
+		return s.querySchedules(`ORDER BY id`)
+	}
+	return s.querySchedules(`WHERE topic = ? ORDER BY id`, topic)
+}
+
+func (s *SQLiteStore) SetSchedulePaused(id int64, paused bool, nextRun time.Time) (bool, error) {
+	res, err := s.writer.Exec(`UPDATE schedules SET paused = ?, next_run = ? WHERE id = ?`, paused, nextRun.UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) DeleteSchedule(id int64) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM schedules WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) DueSchedules(now time.Time) ([]Schedule, error) {
+	return s.querySchedules(`WHERE paused = 0 AND next_run <= ? ORDER BY next_run, id`, now.UTC())
+}
+
+func (s *SQLiteStore) AdvanceSchedule(id int64, from, to time.Time) (bool, error) {
+	res, err := s.writer.Exec(`UPDATE schedules SET next_run = ? WHERE id = ? AND paused = 0 AND next_run = ?`,
+		to.UTC(), id, from.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) AddScheduleRun(r ScheduleRun) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.Exec(`INSERT INTO schedule_runs (schedule_id, scheduled_at, ran_at, status, message_id, enqueued, error)
+		SELECT ?, ?, ?, ?, ?, ?, ? WHERE EXISTS(SELECT 1 FROM schedules WHERE id = ?)`,
+		r.ScheduleID, r.ScheduledAt.UTC(), r.RanAt.UTC(), r.Status, r.MessageID, r.Enqueued, r.Error, r.ScheduleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("schedule %w: %d", ErrNotFound, r.ScheduleID)
+	}
+	_, err = tx.Exec(`DELETE FROM schedule_runs WHERE schedule_id = ? AND id NOT IN
+		(SELECT id FROM schedule_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?)`,
+		r.ScheduleID, r.ScheduleID, MaxScheduleRuns)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) {
+	rows, err := s.db.Query(`SELECT id, schedule_id, scheduled_at, ran_at, status, message_id, enqueued, error
+		FROM schedule_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?`, scheduleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var r ScheduleRun
+		if err := rows.Scan(&r.ID, &r.ScheduleID, &r.ScheduledAt, &r.RanAt, &r.Status, &r.MessageID, &r.Enqueued, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// Drafts
+const draftColumns = `id, topic, request, status, created_by, created_at, updated_at, published_at, message_id`
+
+func scanDraft(row interface{ Scan(...interface{}) error }) (*Draft, error) {
+	var d Draft
+	var request []byte
+	var publishedAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.Topic, &request, &d.Status, &d.CreatedBy, &d.CreatedAt, &d.UpdatedAt, &publishedAt, &d.MessageID); err != nil {
+		return nil, err
+	}
+	d.Request = request
+	if publishedAt.Valid {
+		d.PublishedAt = &publishedAt.Time
+	}
+	return &d, nil
+}
+
+func (s *SQLiteStore) CreateDraft(d Draft) (int64, error) {
+	res, err := s.writer.Exec(`INSERT INTO drafts (topic, request, status, created_by)
+		SELECT ?, ?, ?, ? WHERE EXISTS(SELECT 1 FROM topics WHERE name = ?)`,
+		d.Topic, []byte(d.Request), DraftOpen, d.CreatedBy, d.Topic)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, fmt.Errorf("topic %w: %s", ErrNotFound, d.Topic)
+	}
+	return res.LastInsertId()
+}
+
+func (s *SQLiteStore) GetDraft(id int64) (*Draft, error) {
+	d, err := scanDraft(s.db.QueryRow(`SELECT `+draftColumns+` FROM drafts WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+func (s *SQLiteStore) ListDrafts(topic string) ([]Draft, error) {
+	query := `SELECT ` + draftColumns + ` FROM drafts`
+	args := []interface{}{}
+	if topic != "" {
+		query += ` WHERE topic = ?`
+		args = append(args, topic)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, *d)
+	}
+	return drafts, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateDraft(id int64, request json.RawMessage) (bool, error) {
+	res, err := s.writer.Exec(`UPDATE drafts SET request = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		[]byte(request), id, DraftOpen)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) DeleteDraft(id int64) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM drafts WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) SetDraftStatus(id int64, from, to string, messageID int64) (bool, error) {
+	var publishedAt interface{}
+	if to == DraftPublished {
+		publishedAt = time.Now().UTC()
+	}
+	res, err := s.writer.Exec(`UPDATE drafts SET status = ?, message_id = ?, published_at = ? WHERE id = ? AND status = ?`,
+		to, messageID, publishedAt, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Audit log
+func (s *SQLiteStore) AddAuditEvent(e AuditEvent) error {
+	var details interface{}
+	if len(e.Details) > 0 {
+		data, err := json.Marshal(e.Details)
+		if err != nil {
+			return err
+		}
+		details = string(data)
+	}
+	_, err := s.writer.Exec(`INSERT INTO audit_log (actor, ip, action, target, details) VALUES (?, ?, ?, ?, ?)`,
+		e.Actor, e.IP, e.Action, e.Target, details)
+	return err
+}
+
+func (s *SQLiteStore) ListAuditEvents(f AuditFilter) ([]AuditEvent, error) {
+	query := `SELECT id, actor, ip, action, target, details, created_at FROM audit_log WHERE 1=1`
+	args := []interface{}{}
+	if f.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if strings.HasSuffix(f.Action, ".*") {
+		query += ` AND action LIKE ?`
+		args = append(args, strings.TrimSuffix(f.Action, "*")+"%")
+	} else if f.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, f.Action)
+	}
+	if f.Target != "" {
+		query += ` AND target = ?`
+		args = append(args, f.Target)
+	}
+	if !f.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.Until.UTC().Format("2006-01-02 15:04:05"))
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var target, details sql.NullString
+		if err := rows.Scan(&e.ID, &e.Actor, &e.IP, &e.Action, &target, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Target = target.String
+		if details.Valid {
+			if err := json.Unmarshal([]byte(details.String), &e.Details); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Subscriptions
+func (s *SQLiteStore) AddSubscription(topic, token, provider, username string) error {
+	_, err := s.writer.Exec(`INSERT INTO subscriptions (topic, token, provider, username, created_at) VALUES (?, ?, ?, ?, ?)`,
+		topic, token, provider, username, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", sqliteError(err))
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RemoveSubscription(topic, token string) error {
+	_, err := s.writer.Exec(`DELETE FROM subscriptions WHERE topic = ? AND token = ?`, topic, token)
+	return err
+}
+
+func (s *SQLiteStore) ClearTopicSubscribers(topic string) error {
+	_, err := s.writer.Exec(`DELETE FROM subscriptions WHERE topic = ?`, topic)
+	return err
+}
+
+// subscriberColumns is the column list read by scanSubscribers.
+const subscriberColumns = `topic, token, provider, COALESCE(username, ''), options, COALESCE(locale, ''), COALESCE(platform, ''), COALESCE(app_version, ''), tags, created_at, last_seen_at, preferences`
+
+// subscriberActive leaves out the subscriptions of disabled users.
+const subscriberActive = `NOT EXISTS (SELECT 1 FROM users u WHERE u.username = subscriptions.username AND u.disabled)`
+
+func scanSubscribers(rows *sql.Rows) ([]Subscriber, error) {
+	defer rows.Close()
+
+	var subs []Subscriber
+	for rows.Next() {
+		var sub Subscriber
+		var options, tags, prefs sql.NullString
+		var created, seen sql.NullTime
+		if err := rows.Scan(&sub.Topic, &sub.Token, &sub.Provider, &sub.Username, &options, &sub.Locale, &sub.Platform, &sub.AppVersion, &tags, &created, &seen, &prefs); err != nil {
+			return nil, err
+		}
+		if prefs.Valid && prefs.String != "" {
+			sub.Preferences = &Preferences{}
+			if err := json.Unmarshal([]byte(prefs.String), sub.Preferences); err != nil {
+				sub.Preferences = nil
+			}
+		}
+		if created.Valid {
+			sub.CreatedAt = &created.Time
+		}
+		if seen.Valid {
+			sub.LastSeenAt = &seen.Time
+		}
+		sub.Options = decodeOptions(options)
+		if tags.Valid && tags.String != "" {
+			_ = json.Unmarshal([]byte(tags.String), &sub.Tags)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *SQLiteStore) GetSubscribers(topic string) ([]Subscriber, error) {
+	rows, err := s.stmts.subscribers.Query(topic)
+	if err != nil {
+		return nil, err
+	}
+	return scanSubscribers(rows)
+}
+
+func (s *SQLiteStore) ListSubscribersAfter(topic, after string, limit int) ([]Subscriber, error) {
+	rows, err := s.db.Query(`SELECT `+subscriberColumns+` FROM subscriptions WHERE topic = ? AND token > ? AND `+subscriberActive+` ORDER BY token LIMIT ?`, topic, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanSubscribers(rows)
+}
+
+func (s *SQLiteStore) GetSubscriptionsByUser(username string) ([]Subscriber, error) {
+	rows, err := s.db.Query(`SELECT `+subscriberColumns+` FROM subscriptions WHERE username = ?`, username)
+	if err != nil {
+		return nil, err
+	}
+	return scanSubscribers(rows)
+}
+
+func (s *SQLiteStore) GetSubscriptionsByToken(token string) ([]Subscriber, error) {
+	rows, err := s.db.Query(`SELECT `+subscriberColumns+` FROM subscriptions WHERE token = ?`, token)
+	if err != nil {
+		return nil, err
+	}
+	return scanSubscribers(rows)
+}
+
+// SetSubscriptionAttributes stores the device attributes used for segmentation.
+func (s *SQLiteStore) SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error {
+	var tagsValue interface{}
+	if len(tags) > 0 {
+		data, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		tagsValue = string(data)
+	}
+	_, err := s.writer.Exec(`UPDATE subscriptions SET platform = ?, app_version = ?, tags = ? WHERE topic = ? AND token = ?`,
+		platform, appVersion, tagsValue, topic, token)
+	return err
+}
+
+// UpdateSubscriptionTags adds and removes tags on a subscription and returns the resulting tags.
+func (s *SQLiteStore) UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var raw sql.NullString
+	err = tx.QueryRow(`SELECT tags FROM subscriptions WHERE topic = ? AND token = ?`, topic, token).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("subscription %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	if raw.Valid && raw.String != "" {
+		_ = json.Unmarshal([]byte(raw.String), &tags)
+	}
+	tags = mergeTags(tags, add, remove)
+
+	var value interface{}
+	if len(tags) > 0 {
+		data, _ := json.Marshal(tags)
+		value = string(data)
+	}
+	if _, err := tx.Exec(`UPDATE subscriptions SET tags = ? WHERE topic = ? AND token = ?`, value, topic, token); err != nil {
+		return nil, err
+	}
+	return tags, tx.Commit()
+}
+
+// mergeTags applies additions then removals, keeping order and dropping duplicates.
+func mergeTags(tags, add, remove []string) []string {
+	drop := map[string]bool{}
+	for _, t := range remove {
+		drop[t] = true
+	}
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range append(tags, add...) {
+		if seen[t] || drop[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// RemoveSubscriptionsByTag deletes all of a user's subscriptions carrying tag.
+func (s *SQLiteStore) RemoveSubscriptionsByTag(username, tag string) (int64, error) {
+	res, err := s.writer.Exec(`
+		DELETE FROM subscriptions
+		WHERE username = ? AND tags IS NOT NULL
+		AND EXISTS (SELECT 1 FROM json_each(subscriptions.tags) WHERE value = ?)
+	`, username, tag)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteStore) MarkTokensSeen(tokens []string, at time.Time) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	stmt, err := tx.Prepare(`UPDATE subscriptions SET last_seen_at = ? WHERE token = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, token := range tokens {
+		if _, err := stmt.Exec(at.UTC(), token); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// subscriptionStale matches subscriptions not seen since the argument.
+// Subscriptions of unknown age are never stale.
+const subscriptionStale = `COALESCE(last_seen_at, created_at) < ?`
+
+func (s *SQLiteStore) ListStaleSubscriptions(before time.Time, limit int) ([]Subscriber, error) {
+	rows, err := s.db.Query(`SELECT `+subscriberColumns+` FROM subscriptions WHERE `+subscriptionStale+`
+		ORDER BY COALESCE(last_seen_at, created_at), topic, token LIMIT ?`, before.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanSubscribers(rows)
+}
+
+func (s *SQLiteStore) RemoveStaleSubscriptions(before time.Time) (int64, error) {
+	res, err := s.writer.Exec(`DELETE FROM subscriptions WHERE `+subscriptionStale, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteStore) ReassignSubscriptions(from, to string) (int64, error) {
+	var res sql.Result
+	var err error
+	if to == "" {
+		res, err = s.writer.Exec(`DELETE FROM subscriptions WHERE username = ?`, from)
+	} else {
+		res, err = s.writer.Exec(`UPDATE subscriptions SET username = ? WHERE username = ?`, to, from)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteStore) SetSubscriptionOptions(topic, token string, opts *WebhookOptions) error {
+	var value interface{}
+	if opts != nil {
+		data, err := json.Marshal(opts)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	_, err := s.writer.Exec(`UPDATE subscriptions SET options = ? WHERE topic = ? AND token = ?`, value, topic, token)
+	return err
+}
+
+func (s *SQLiteStore) SetSubscriptionPreferences(topic, token string, prefs *Preferences) error {
+	var value any
+	if prefs != nil {
+		data, err := json.Marshal(prefs)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	_, err := s.writer.Exec(`UPDATE subscriptions SET preferences = ? WHERE topic = ? AND token = ?`, value, topic, token)
+	return err
+}
+
+func (s *SQLiteStore) CountQueuedSince(topic string, tokens []string, since time.Time) (map[string]int, error) {
+	list, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT q.token, COUNT(*)
+		FROM queue q
+		JOIN messages m ON q.message_id = m.id
+		WHERE m.topic = ? AND q.created_at >= ? AND q.token IN (SELECT value FROM json_each(?))
+		GROUP BY q.token
+	`, topic, since.UTC().Format("2006-01-02 15:04:05.000"), string(list))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var token string
+		var n int
+		if err := rows.Scan(&token, &n); err != nil {
+			return nil, err
+		}
+		counts[token] = n
+	}
+	return counts, rows.Err()
+}
+
+func (s *SQLiteStore) SetSubscriptionLocale(topic, token, locale string) error {
+	_, err := s.writer.Exec(`UPDATE subscriptions SET locale = ? WHERE topic = ? AND token = ?`, locale, topic, token)
+	return err
+}
+
+// decodeOptions parses a subscription's options column, ignoring invalid data.
+func decodeOptions(ns sql.NullString) *WebhookOptions {
+	if !ns.Valid || ns.String == "" {
+		return nil
+	}
+	var opts WebhookOptions
+	if err := json.Unmarshal([]byte(ns.String), &opts); err != nil {
+		return nil
+	}
+	return &opts
+}
+
+func (s *SQLiteStore) GetSubscriptionCount() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT count(*) FROM subscriptions`).Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteStore) CountSubscriptions(topic, username string) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT count(*) FROM subscriptions WHERE topic = ? AND COALESCE(username, '') = ?`, topic, username).Scan(&count)
+	return count, err
+}
+
+// Users
+func (s *SQLiteStore) CreateUser(username, passwordHash, role string) error {
+	_, err := s.writer.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`, username, passwordHash, role)
+	return sqliteError(err)
+}
+
+func (s *SQLiteStore) DeleteUser(username string) error {
+	return s.withoutLastAdmin(username, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM users WHERE username = ?`, username)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("user %w", ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// withoutLastAdmin runs fn, which removes username as an admin, in a
+// transaction. It fails with ErrLastAdmin if username is the last admin who
+// isn't disabled.
+func (s *SQLiteStore) withoutLastAdmin(username string, fn func(tx *sql.Tx) error) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var last bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = ? AND role = 'admin' AND NOT disabled)
+		AND NOT EXISTS(SELECT 1 FROM users WHERE username != ? AND role = 'admin' AND NOT disabled)`, username, username).Scan(&last)
+	if err != nil {
+		return err
+	}
+	if last {
+		return fmt.Errorf("user %s: %w", username, ErrLastAdmin)
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListUsers() ([]User, error) {
+	rows, err := s.db.Query(`SELECT username, password_hash, role, disabled, display_name, email, external_id FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.PasswordHash, &u.Role, &u.Disabled, &u.DisplayName, &u.Email, &u.ExternalID); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (s *SQLiteStore) GetUser(username string) (*User, error) {
+	var u User
+	var secret sql.NullString
+	var enabled sql.NullBool
+	var failedAt sql.NullTime
+	err := s.db.QueryRow(`SELECT username, password_hash, role, totp_secret, totp_enabled, totp_failures, totp_failed_at,
+		must_change_password, disabled, display_name, email, unread, external_id FROM users WHERE username = ?`, username).
+		Scan(&u.Username, &u.PasswordHash, &u.Role, &secret, &enabled, &u.TOTPFailures, &failedAt,
+			&u.MustChangePassword, &u.Disabled, &u.DisplayName, &u.Email, &u.Unread, &u.ExternalID)
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.TOTPSecret, u.TOTPEnabled, u.TOTPFailedAt = secret.String, enabled.Bool, failedAt.Time
+	return &u, nil
+}
+
+func (s *SQLiteStore) HasAdminUser() (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE role = 'admin')`).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLiteStore) SetUserPassword(username, passwordHash string) error {
+	res, err := s.writer.Exec(`UPDATE users SET password_hash = ? WHERE username = ?`, passwordHash, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetMustChangePassword(username string, required bool) error {
+	res, err := s.writer.Exec(`UPDATE users SET must_change_password = ? WHERE username = ?`, required, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetUserDisabled(username string, disabled bool) error {
+	if !disabled {
+		res, err := s.writer.Exec(`UPDATE users SET disabled = 0 WHERE username = ?`, username)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("user %w: %s", ErrNotFound, username)
+		}
+		return nil
+	}
+	return s.withoutLastAdmin(username, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE users SET disabled = 1 WHERE username = ?`, username)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("user %w: %s", ErrNotFound, username)
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) SetUserAccess(username, role string, disabled bool) error {
+	update := func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE users SET role = ?, disabled = ? WHERE username = ?`, role, disabled, username)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("user %w: %s", ErrNotFound, username)
+		}
+		return nil
+	}
+	if role == "admin" && !disabled {
+		tx, err := s.writer.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		if err := update(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	return s.withoutLastAdmin(username, update)
+}
+
+func (s *SQLiteStore) SetUserProfile(username, displayName, email string) error {
+	res, err := s.writer.Exec(`UPDATE users SET display_name = ?, email = ? WHERE username = ?`, displayName, email, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) LinkUser(username, externalID string) (bool, error) {
+	res, err := s.writer.Exec(`UPDATE users SET external_id = ? WHERE username = ? AND external_id = ''`, externalID, username)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	var exists bool
+	if err := s.writer.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)`, username).Scan(&exists); err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return false, nil
+}
+
+func (s *SQLiteStore) IncrementUnread(usernames []string) (map[string]int, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	stmt, err := tx.Prepare(`UPDATE users SET unread = unread + 1 WHERE username = ? RETURNING unread`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	counts := make(map[string]int, len(usernames))
+	for _, username := range usernames {
+		var n int
+		err := stmt.QueryRow(username).Scan(&n)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		counts[username] = n
+	}
+	return counts, tx.Commit()
+}
+
+func (s *SQLiteStore) SetUnread(username string, count int) error {
+	res, err := s.writer.Exec(`UPDATE users SET unread = ? WHERE username = ?`, count, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdateUserRole(username, role string) error {
+	if role == "admin" {
+		_, err := s.writer.Exec(`UPDATE users SET role = ? WHERE username = ?`, role, username)
+		return err
+	}
+	return s.withoutLastAdmin(username, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE users SET role = ? WHERE username = ?`, role, username)
+		return err
+	})
+}
+
+func (s *SQLiteStore) SetUserTOTP(username, secret string, enabled bool) error {
+	var value interface{}
+	if secret != "" {
+		value = secret
+	}
+	enabled = enabled && secret != ""
+	res, err := s.writer.Exec(`UPDATE users SET totp_secret = ?, totp_enabled = ?,
+		recovery_codes = CASE WHEN ? IS NULL THEN NULL ELSE recovery_codes END,
+		totp_step = CASE WHEN ? THEN totp_step ELSE 0 END,
+		totp_failures = CASE WHEN ? THEN totp_failures ELSE 0 END WHERE username = ?`,
+		value, enabled, value, enabled, enabled, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetRecoveryCodes(username string, hashes []string) error {
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Exec(`UPDATE users SET recovery_codes = ? WHERE username = ?`, string(data), username)
+	return err
+}
+
+func (s *SQLiteStore) UseRecoveryCode(username, hash string) (bool, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var data sql.NullString
+	err = tx.QueryRow(`SELECT recovery_codes FROM users WHERE username = ?`, username).Scan(&data)
+	if err == sql.ErrNoRows || !data.Valid {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var hashes []string
+	if err := json.Unmarshal([]byte(data.String), &hashes); err != nil {
+		return false, err
+	}
+	for i, h := range hashes {
+		if h != hash {
+			continue
+		}
+		remaining, _ := json.Marshal(append(hashes[:i], hashes[i+1:]...))
+		if _, err := tx.Exec(`UPDATE users SET recovery_codes = ?, totp_failures = 0 WHERE username = ?`, string(remaining), username); err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+	return false, nil
+}
+
+func (s *SQLiteStore) UseTOTPStep(username string, step int64) (bool, error) {
+	res, err := s.writer.Exec(`UPDATE users SET totp_step = ?, totp_failures = 0 WHERE username = ? AND totp_step < ?`, step, username, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) AddTOTPFailure(username string) (int, error) {
+	var failures int
+	err := s.writer.QueryRow(`UPDATE users SET totp_failures = totp_failures + 1, totp_failed_at = CURRENT_TIMESTAMP
+		WHERE username = ? RETURNING totp_failures`, username).Scan(&failures)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("user %w: %s", ErrNotFound, username)
+	}
+	return failures, err
+}
+
+// Invitations
+func (s *SQLiteStore) CreateInvitation(inv Invitation) error {
+	var expires interface{}
+	if inv.ExpiresAt != nil {
+		expires = inv.ExpiresAt.UTC()
+	}
+	_, err := s.writer.Exec(`INSERT INTO invitations (code, role, max_uses, expires_at, created_by) VALUES (?, ?, ?, ?, ?)`,
+		inv.Code, inv.Role, inv.MaxUses, expires, inv.CreatedBy)
+	return sqliteError(err)
+}
+
+func (s *SQLiteStore) ListInvitations() ([]Invitation, error) {
+	rows, err := s.db.Query(`SELECT code, role, max_uses, uses, expires_at, created_by, created_at FROM invitations ORDER BY created_at, code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		var expires sql.NullTime
+		if err := rows.Scan(&inv.Code, &inv.Role, &inv.MaxUses, &inv.Uses, &expires, &inv.CreatedBy, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			inv.ExpiresAt = &expires.Time
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteInvitation(code string) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM invitations WHERE code = ?`, code)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) RedeemInvitation(code, username, passwordHash string) (*Invitation, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE invitations SET uses = uses + 1
+		WHERE code = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)`, code, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+
+	inv := Invitation{Code: code}
+	if err := tx.QueryRow(`SELECT role, max_uses, uses FROM invitations WHERE code = ?`, code).Scan(&inv.Role, &inv.MaxUses, &inv.Uses); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`, username, passwordHash, inv.Role); err != nil {
+		return nil, sqliteError(err)
+	}
+	return &inv, tx.Commit()
+}
+
+// Roles
+func (s *SQLiteStore) SaveRole(r Role) error {
+	perms, err := json.Marshal(r.Permissions)
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Exec(`INSERT OR REPLACE INTO roles (name, permissions) VALUES (?, ?)`, r.Name, string(perms))
+	return err
+}
+
+func (s *SQLiteStore) GetRole(name string) (*Role, error) {
+	var perms string
+	err := s.db.QueryRow(`SELECT permissions FROM roles WHERE name = ?`, name).Scan(&perms)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := Role{Name: name}
+	if err := json.Unmarshal([]byte(perms), &r.Permissions); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *SQLiteStore) ListRoles() ([]Role, error) {
+	rows, err := s.db.Query(`SELECT name, permissions FROM roles ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var r Role
+		var perms string
+		if err := rows.Scan(&r.Name, &perms); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(perms), &r.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteRole(name string) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM roles WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Save Message
+func (s *SQLiteStore) SaveMessage(topic string, payload []byte) (int64, error) {
+	return s.SaveMessageFrom(topic, payload, MessageOrigin{})
+}
+
+func (s *SQLiteStore) SaveMessageFrom(topic string, payload []byte, origin MessageOrigin) (int64, error) {
+	res, err := s.writer.Exec(`INSERT INTO messages (topic, payload, publisher, source_ip, user_agent) VALUES (?, ?, ?, ?, ?)`,
+		topic, s.compress(payload), origin.Publisher, origin.IP, origin.UserAgent)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// nextTopicSequence increments the topic's message sequence in tx.
+func nextTopicSequence(tx *sql.Tx, name string) (int64, error) {
+	var seq int64
+	err := tx.QueryRow(`UPDATE topics SET seq = seq + 1 WHERE name = ? RETURNING seq`, name).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("topic %w: %s", ErrNotFound, name)
+	}
+	return seq, err
+}
+
+func (s *SQLiteStore) SaveSequencedMessage(topic string, origin MessageOrigin, seal func(seq int64) ([]byte, error)) (int64, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	seq, err := nextTopicSequence(tx, topic)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := seal(seq)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`INSERT INTO messages (topic, payload, publisher, source_ip, user_agent) VALUES (?, ?, ?, ?, ?)`,
+		topic, s.compress(payload), origin.Publisher, origin.IP, origin.UserAgent)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *SQLiteStore) SequenceMessage(id int64, seal func(seq int64) ([]byte, error)) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	var topic string
+	err = tx.QueryRow(`SELECT topic FROM messages WHERE id = ?`, id).Scan(&topic)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("message %w: %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return err
+	}
+	seq, err := nextTopicSequence(tx, topic)
+	if err != nil {
+		return err
+	}
+	payload, err := seal(seq)
 	if err != nil {
 		return err
 	}
+	if _, err := tx.Exec(`UPDATE messages SET payload = ? WHERE id = ?`, s.compress(payload), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const messageColumns = `id, topic, payload, created_at, publisher, source_ip, user_agent`
+
+func scanMessage(row interface{ Scan(...any) error }) (Message, error) {
+	var msg Message
+	err := row.Scan(&msg.ID, &msg.Topic, &msg.Payload, &msg.CreatedAt, &msg.Origin.Publisher, &msg.Origin.IP, &msg.Origin.UserAgent)
+	msg.Payload = decompressPayload(msg.Payload)
+	return msg, err
+}
+
+func (s *SQLiteStore) GetMessage(id int64) (*Message, error) {
+	msg, err := scanMessage(s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *SQLiteStore) GetRecentMessages(topic string, limit int) ([]Message, error) {
+	// Fetch newest first to respect limit
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE topic = ? ORDER BY created_at DESC, id DESC LIMIT ?`
+	rows, err := s.db.Query(query, topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	// Reverse to return Oldest -> Newest (Chronological)
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+
+	return msgs, nil
+}
+
+func (s *SQLiteStore) SearchMessages(f MessageSearch) ([]Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE topic = ?`
+	args := []interface{}{f.Topic}
+	switch terms := f.terms(); {
+	case len(terms) == 0:
+	case s.fts:
+		query += ` AND id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)`
+		args = append(args, ftsQuery(terms))
+	default:
+		for _, t := range terms {
+			query += ` AND payload_text(payload) LIKE ? ESCAPE '\'`
+			args = append(args, "%"+likeEscaper.Replace(t)+"%")
+		}
+	}
+	if !f.From.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.To.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, f.limit())
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+// ftsQuery quotes each term as an FTS5 string, so the search can't contain
+// query syntax, and requires all of them.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " AND ")
+}
+
+// likeEscaper escapes the LIKE wildcards of a literal, for ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *SQLiteStore) ClearTopicMessages(topic string) error {
+	_, err := s.deleteMessages(topic, `1`)
+	return err
+}
+
+func (s *SQLiteStore) DeleteMessagesBefore(topic string, t time.Time) (int64, error) {
+	return s.deleteMessages(topic, `created_at < ?`, t.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// deleteMessages deletes the topic's messages matching cond, with their
+// queue items and attempts.
+func (s *SQLiteStore) deleteMessages(topic, cond string, args ...any) (int64, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return 0, err
+	}
 	defer func() {
 		_ = tx.Rollback()
 	}()
 
+	messages := `SELECT id FROM messages WHERE topic = ? AND ` + cond
+	args = append([]any{topic}, args...)
+
 	// Delete from queue first (constraint)
-	_, err = tx.Exec(`DELETE FROM queue WHERE message_id IN (SELECT id FROM messages WHERE topic = ?)`, topic)
+	_, err = tx.Exec(`
+		DELETE FROM queue_attempts WHERE queue_id IN (
+			SELECT id FROM queue WHERE message_id IN (`+messages+`)
+		)`, args...)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	_, err = tx.Exec(`DELETE FROM queue WHERE message_id IN (`+messages+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`DELETE FROM payloads WHERE hash NOT IN (SELECT payload_hash FROM queue WHERE payload_hash IS NOT NULL)`)
+	if err != nil {
+		return 0, err
 	}
 
 	// Delete messages
-	_, err = tx.Exec(`DELETE FROM messages WHERE topic = ?`, topic)
+	res, err := tx.Exec(`DELETE FROM messages WHERE topic = ? AND `+cond, args...)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	return tx.Commit()
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
 }
 
 // Queue
 func (s *SQLiteStore) EnqueueMessage(messageID int64, token string) (int64, error) {
-	res, err := s.db.Exec(`INSERT INTO queue (message_id, token, status) VALUES (?, ?, 'pending')`, messageID, token)
+	res, err := s.stmts.enqueue.Exec(messageID, token)
 	if err != nil {
 		return 0, err
 	}
 	return res.LastInsertId()
 }
 
+func (s *SQLiteStore) EnqueueMessagePayload(messageID int64, token string, payload []byte) (int64, error) {
+	ids, err := s.EnqueueMessages(messageID, []QueueEntry{{Token: token, Payload: payload}})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (s *SQLiteStore) EnqueueMessages(messageID int64, entries []QueueEntry) ([]int64, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	stmt, err := tx.Prepare(`INSERT INTO queue (message_id, token, provider, status, payload_hash, created_at) VALUES (?, ?, ?, 'pending', ?, ` + sqliteQueueNow + `)`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	savePayload, err := tx.Prepare(`INSERT OR IGNORE INTO payloads (hash, data) VALUES (?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer savePayload.Close()
+
+	ids := make([]int64, len(entries))
+	saved := map[string]bool{}
+	for i, e := range entries {
+		var hash any // NULL uses the message payload
+		if e.Payload != nil {
+			h := payloadHash(e.Payload)
+			if !saved[h] {
+				if _, err := savePayload.Exec(h, s.compress(e.Payload)); err != nil {
+					return nil, err
+				}
+				saved[h] = true
+			}
+			hash = h
+		}
+		res, err := stmt.Exec(messageID, e.Token, e.Provider, hash)
+		if err != nil {
+			return nil, err
+		}
+		if ids[i], err = res.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
+
 func (s *SQLiteStore) GetPendingMessages(token string) ([]QueueItem, error) {
 	query := `
-		SELECT q.id, q.message_id, q.token, q.status, m.payload 
+		SELECT q.id, q.message_id, m.topic, q.token, q.status, q.payload, q.payload_hash
 		FROM queue q
 		JOIN messages m ON q.message_id = m.id
 		WHERE q.token = ? AND q.status = 'pending'
@@ -352,73 +2053,509 @@ func (s *SQLiteStore) GetPendingMessages(token string) ([]QueueItem, error) {
 	defer rows.Close()
 
 	var items []QueueItem
+	var hashes []string
 	for rows.Next() {
 		var item QueueItem
-		if err := rows.Scan(&item.ID, &item.MessageID, &item.Token, &item.Status, &item.Payload); err != nil {
+		var hash sql.NullString
+		if err := rows.Scan(&item.ID, &item.MessageID, &item.Topic, &item.Token, &item.Status, &item.Payload, &hash); err != nil {
 			return nil, err
 		}
 		items = append(items, item)
+		hashes = append(hashes, hash.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return items, nil
+	return items, s.loadPayloads(items, hashes)
 }
 
 func (s *SQLiteStore) GetAllPendingMessages() ([]QueueItem, error) {
+	rows, err := s.stmts.pending.Query()
+	if err != nil {
+		return nil, err
+	}
+	return s.pendingItems(rows)
+}
+
+// GetPendingMessagesByTopic retrieves all pending messages for a specific topic.
+func (s *SQLiteStore) GetPendingMessagesByTopic(topic string) ([]QueueItem, error) {
+	rows, err := s.db.Query(pendingMessagesQuery+` AND m.topic = ?`, topic)
+	if err != nil {
+		return nil, err
+	}
+	return s.pendingItems(rows)
+}
+
+func (s *SQLiteStore) CountPending(topic string) (int64, error) {
+	var count int64
+	var err error
+	if topic == "" {
+		err = s.db.QueryRow(`SELECT count(*) FROM queue WHERE status = 'pending'`).Scan(&count)
+	} else {
+		err = s.db.QueryRow(`
+			SELECT count(*) FROM queue q
+			JOIN messages m ON q.message_id = m.id
+			WHERE q.status = 'pending' AND m.topic = ?
+		`, topic).Scan(&count)
+	}
+	return count, err
+}
+
+func (s *SQLiteStore) GetQueueItemsByMessage(messageID int64) ([]QueueItem, error) {
 	rows, err := s.db.Query(`
-		SELECT q.id, q.message_id, q.token, s.provider, q.status, m.payload, m.created_at
+		SELECT q.id, q.message_id, m.topic, q.token, q.status, q.created_at, q.delivered_at, q.opened_at, q.attempts, q.last_error
 		FROM queue q
-		JOIN subscriptions s ON q.token = s.token
 		JOIN messages m ON q.message_id = m.id
-		WHERE q.status = 'pending'
-	`)
+		WHERE q.message_id = ?
+		ORDER BY q.id
+	`, messageID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanQueueItems(rows)
+}
 
+// scanQueueItems reads queue items without payloads and closes rows.
+func scanQueueItems(rows *sql.Rows) ([]QueueItem, error) {
+	defer rows.Close()
 	var items []QueueItem
 	for rows.Next() {
 		var i QueueItem
-		if err := rows.Scan(&i.ID, &i.MessageID, &i.Token, &i.Provider, &i.Status, &i.Payload, &i.CreatedAt); err != nil {
+		var deliveredAt, openedAt sql.NullTime
+		if err := rows.Scan(&i.ID, &i.MessageID, &i.Topic, &i.Token, &i.Status, &i.CreatedAt, &deliveredAt, &openedAt, &i.Attempts, &i.LastError); err != nil {
 			return nil, err
 		}
+		if deliveredAt.Valid {
+			i.DeliveredAt = &deliveredAt.Time
+		}
+		if openedAt.Valid {
+			i.OpenedAt = &openedAt.Time
+		}
 		items = append(items, i)
 	}
-	return items, nil
+	return items, rows.Err()
 }
 
-// GetPendingMessagesByTopic retrieves all pending messages for a specific topic.
-func (s *SQLiteStore) GetPendingMessagesByTopic(topic string) ([]QueueItem, error) {
+func (s *SQLiteStore) ListQueueOutcomes(since time.Time) ([]QueueItem, error) {
+	rows, err := s.db.Query(`
+		SELECT q.id, q.message_id, m.topic, q.token, q.status, q.created_at, q.delivered_at, q.opened_at, q.attempts, q.last_error
+		FROM queue q
+		JOIN messages m ON q.message_id = m.id
+		WHERE q.created_at >= ? AND q.status IN ('delivered', 'failed')
+		ORDER BY q.id
+	`, since.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	return scanQueueItems(rows)
+}
+
+func (s *SQLiteStore) MarkDelivered(queueID int64) error {
+	_, err := s.writer.Exec(`UPDATE queue SET status = 'delivered', delivered_at = `+sqliteQueueNow+` WHERE id = ?`, queueID)
+	return err
+}
+
+func (s *SQLiteStore) MarkOpened(messageID int64, token string) (bool, error) {
+	res, err := s.writer.Exec(`UPDATE queue SET opened_at = COALESCE(opened_at, CURRENT_TIMESTAMP) WHERE message_id = ? AND token = ?`, messageID, token)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) ListMessageOpens(limit int) ([]MessageOpens, error) {
 	rows, err := s.db.Query(`
-		SELECT q.id, q.message_id, q.token, s.provider, q.status, m.payload, m.created_at
+		SELECT q.message_id, m.topic, SUM(q.status = 'delivered'), COUNT(q.opened_at)
 		FROM queue q
-		JOIN subscriptions s ON q.token = s.token
 		JOIN messages m ON q.message_id = m.id
-		WHERE q.status = 'pending' AND m.topic = ?
-	`, topic)
+		GROUP BY q.message_id
+		HAVING SUM(q.status = 'delivered') > 0
+		ORDER BY q.message_id DESC
+		LIMIT ?
+	`, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []QueueItem
+	opens := []MessageOpens{}
 	for rows.Next() {
-		var i QueueItem
-		if err := rows.Scan(&i.ID, &i.MessageID, &i.Token, &i.Provider, &i.Status, &i.Payload, &i.CreatedAt); err != nil {
+		var o MessageOpens
+		if err := rows.Scan(&o.MessageID, &o.Topic, &o.Delivered, &o.Opened); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		opens = append(opens, o)
 	}
-	return items, nil
+	return opens, rows.Err()
 }
 
-func (s *SQLiteStore) MarkDelivered(queueID int64) error {
-	_, err := s.db.Exec(`UPDATE queue SET status = 'delivered' WHERE id = ?`, queueID)
+func (s *SQLiteStore) SettleAttempts(attempts []Attempt) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	insert, err := tx.Prepare(`INSERT INTO queue_attempts (queue_id, node, provider, error, duration_ms, attempted_at) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	delivered, err := tx.Prepare(`UPDATE queue SET attempts = attempts + 1, status = 'delivered', delivered_at = ` + sqliteQueueNow + ` WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer delivered.Close()
+	failed, err := tx.Prepare(`UPDATE queue SET attempts = attempts + 1, last_error = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer failed.Close()
+
+	for _, a := range attempts {
+		if _, err := insert.Exec(a.QueueID, a.Node, a.Provider, a.Error, a.DurationMs, a.AttemptedAt.UTC()); err != nil {
+			return err
+		}
+		if a.Error == "" {
+			_, err = delivered.Exec(a.QueueID)
+		} else {
+			_, err = failed.Exec(a.Error, a.QueueID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RecordAttempt(a Attempt) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.Exec(`INSERT INTO queue_attempts (queue_id, node, provider, error, duration_ms, attempted_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		a.QueueID, a.Node, a.Provider, a.Error, a.DurationMs, a.AttemptedAt.UTC()); err != nil {
+		return err
+	}
+	if a.Error == "" {
+		_, err = tx.Exec(`UPDATE queue SET attempts = attempts + 1 WHERE id = ?`, a.QueueID)
+	} else {
+		_, err = tx.Exec(`UPDATE queue SET attempts = attempts + 1, last_error = ? WHERE id = ?`, a.Error, a.QueueID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListAttempts(topic string, queueID int64) ([]Attempt, error) {
+	rows, err := s.db.Query(`
+		SELECT a.queue_id, a.node, a.provider, a.error, a.duration_ms, a.attempted_at
+		FROM queue_attempts a
+		JOIN queue q ON a.queue_id = q.id
+		JOIN messages m ON q.message_id = m.id
+		WHERE a.queue_id = ? AND m.topic = ?
+		ORDER BY a.id
+	`, queueID, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []Attempt
+	for rows.Next() {
+		var a Attempt
+		if err := rows.Scan(&a.QueueID, &a.Node, &a.Provider, &a.Error, &a.DurationMs, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+func (s *SQLiteStore) ProviderStats(since time.Time) ([]ProviderStats, error) {
+	rows, err := s.db.Query(`
+		SELECT a.provider,
+			SUM(a.error = ''), SUM(a.error != ''),
+			SUM(a.id != (SELECT MIN(f.id) FROM queue_attempts f WHERE f.queue_id = a.queue_id)),
+			AVG(a.duration_ms)
+		FROM queue_attempts a
+		WHERE a.attempted_at >= ? AND a.provider != ''
+		GROUP BY a.provider
+		ORDER BY a.provider
+	`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ProviderStats
+	for rows.Next() {
+		var p ProviderStats
+		if err := rows.Scan(&p.Provider, &p.Sent, &p.Failed, &p.Retried, &p.AvgLatencyMs); err != nil {
+			return nil, err
+		}
+		stats = append(stats, p)
+	}
+	return stats, rows.Err()
+}
+
+func (s *SQLiteStore) MarkFailed(queueID int64) error {
+	_, err := s.writer.Exec(`UPDATE queue SET status = 'failed' WHERE id = ? AND status = 'pending'`, queueID)
 	return err
 }
 
+func (s *SQLiteStore) CancelQueueItem(topic string, queueID int64) (bool, error) {
+	res, err := s.writer.Exec(`
+		UPDATE queue SET status = 'canceled'
+		WHERE id = ? AND status = 'pending'
+		AND message_id IN (SELECT id FROM messages WHERE topic = ?)
+	`, queueID, topic)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+func (s *SQLiteStore) RequeueQueueItem(topic string, queueID int64) (bool, error) {
+	res, err := s.writer.Exec(`
+		UPDATE queue SET status = 'pending', claimed_by = NULL, claimed_until = NULL
+		WHERE id = ? AND status IN ('failed', 'canceled')
+		AND message_id IN (SELECT id FROM messages WHERE topic = ?)
+	`, queueID, topic)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+func (s *SQLiteStore) PurgeQueue(topic, token string) (int64, error) {
+	if topic == "" && token == "" {
+		return 0, fmt.Errorf("topic or token is required")
+	}
+	res, err := s.writer.Exec(`
+		UPDATE queue SET status = 'canceled'
+		WHERE status = 'pending'
+		AND (? = '' OR token = ?)
+		AND (? = '' OR message_id IN (SELECT id FROM messages WHERE topic = ?))
+	`, token, token, topic, topic)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// claimQuery leases a pending queue item unless another node holds an
+// unexpired claim.
+const claimQuery = `
+		UPDATE queue SET claimed_by = ?, claimed_until = ?
+		WHERE id = ? AND status = 'pending'
+		AND (claimed_until IS NULL OR claimed_until < ? OR claimed_by = ?)
+	`
+
+// ClaimQueueItem uses optimistic locking so that only one node processes a
+// given delivery: the UPDATE only matches while the item is pending and
+// unclaimed (or its previous lease has expired, or this node holds it).
+func (s *SQLiteStore) ClaimQueueItem(queueID int64, nodeID string, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	res, err := s.writer.Exec(claimQuery, nodeID, now.Add(lease), queueID, now, nodeID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (s *SQLiteStore) ClaimQueueItems(queueIDs []int64, nodeID string, lease time.Duration) ([]int64, error) {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	stmt, err := tx.Prepare(claimQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	claimed := make([]int64, 0, len(queueIDs))
+	for _, id := range queueIDs {
+		res, err := stmt.Exec(nodeID, now.Add(lease), id, now, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			claimed = append(claimed, id)
+		}
+	}
+	return claimed, tx.Commit()
+}
+
 // Stats
 func (s *SQLiteStore) GetTotalMessagesSent() (int64, error) {
 	var count int64
 	err := s.db.QueryRow(`SELECT count(*) FROM messages`).Scan(&count)
 	return count, err
 }
+
+// statsBucket formats the start of an hour as stats buckets are stored.
+func statsBucket(t time.Time) string {
+	return t.UTC().Truncate(time.Hour).Format("2006-01-02 15:04:05")
+}
+
+func (s *SQLiteStore) RecordStats(bucket time.Time, counts, peaks map[string]int64) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	b := statsBucket(bucket)
+	for metric, n := range counts {
+		if _, err := tx.Exec(`
+			INSERT INTO stats (bucket, metric, value) VALUES (?, ?, ?)
+			ON CONFLICT (bucket, metric) DO UPDATE SET value = value + excluded.value
+		`, b, metric, n); err != nil {
+			return err
+		}
+	}
+	for metric, n := range peaks {
+		if _, err := tx.Exec(`
+			INSERT INTO stats (bucket, metric, value) VALUES (?, ?, ?)
+			ON CONFLICT (bucket, metric) DO UPDATE SET value = max(value, excluded.value)
+		`, b, metric, n); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListStats(from, to time.Time) ([]StatPoint, error) {
+	rows, err := s.db.Query(`
+		SELECT bucket, metric, value FROM stats
+		WHERE bucket >= ? AND bucket < ?
+		ORDER BY bucket, metric
+	`, from.UTC().Format("2006-01-02 15:04:05"), to.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []StatPoint
+	for rows.Next() {
+		var p StatPoint
+		if err := rows.Scan(&p.Bucket, &p.Metric, &p.Value); err != nil {
+			return nil, err
+		}
+		p.Bucket = p.Bucket.UTC()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteStatsBefore(t time.Time) (int64, error) {
+	res, err := s.writer.Exec(`DELETE FROM stats WHERE bucket < ?`, t.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Sessions
+
+func (s *SQLiteStore) CreateSession(sess Session) error {
+	tx, err := s.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE username = ? AND expires_at <= ?`, sess.Username, time.Now().UTC()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO sessions (id, username, method, ip, issued_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		sess.ID, sess.Username, sess.Method, sess.IP, sess.IssuedAt.UTC(), sess.ExpiresAt.UTC()); err != nil {
+		return sqliteError(err)
+	}
+	return tx.Commit()
+}
+
+const sessionColumns = `id, username, COALESCE(method, ''), COALESCE(ip, ''), issued_at, expires_at, last_used_at, COALESCE(last_ip, '')`
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var sess Session
+	var lastUsed sql.NullTime
+	err := row.Scan(&sess.ID, &sess.Username, &sess.Method, &sess.IP, &sess.IssuedAt, &sess.ExpiresAt, &lastUsed, &sess.LastIP)
+	if lastUsed.Valid {
+		sess.LastUsedAt = &lastUsed.Time
+	}
+	return sess, err
+}
+
+func (s *SQLiteStore) GetSession(id string) (*Session, error) {
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *SQLiteStore) TouchSession(id, ip string, at time.Time) error {
+	_, err := s.writer.Exec(`UPDATE sessions SET last_used_at = ?, last_ip = ? WHERE id = ?`, at.UTC(), ip, id)
+	return err
+}
+
+func (s *SQLiteStore) ListSessions(username string) ([]Session, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE username = ? AND expires_at > ? ORDER BY issued_at DESC, id`,
+		username, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteSession(username, id string) (bool, error) {
+	res, err := s.writer.Exec(`DELETE FROM sessions WHERE id = ? AND username = ?`, id, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStore) DeleteUserSessions(username string) (int64, error) {
+	res, err := s.writer.Exec(`DELETE FROM sessions WHERE username = ?`, username)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// setupBenchStore creates a file-backed store, like production: "news" has
+// 10 subscribers with pending queue items, "other" has n subscribers spread
+// over 50 users.
+func setupBenchStore(b *testing.B, n int) *SQLiteStore {
+	s, err := NewSQLiteStore(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	_ = s.CreateTopic("news")
+	_ = s.CreateTopic("other")
+	msgID, _ := s.SaveMessage("news", []byte(`{"title": "hi"}`))
+	for i := 0; i < 10; i++ {
+		token := fmt.Sprintf("token-%d", i)
+		if err := s.AddSubscription("news", token, "mock", "reader"); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := s.EnqueueMessage(msgID, token); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	tx, err := s.writer.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		_, err := tx.Exec(`INSERT INTO subscriptions (topic, token, provider, username) VALUES ('other', ?, 'mock', ?)`,
+			fmt.Sprintf("other-%d", i), fmt.Sprintf("user-%d", i%50))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+// The adhoc sub-benchmarks run the same SQL without the prepared statement,
+// and the unindexed ones drop the index, for comparison.
+
+func BenchmarkGetSubscribers(b *testing.B) {
+	s := setupBenchStore(b, 1000)
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetSubscribers("news"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("adhoc", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows, err := s.db.Query(`SELECT `+subscriberColumns+` FROM subscriptions WHERE topic = ?`, "news")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := scanSubscribers(rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetAllPendingMessages(b *testing.B) {
+	s := setupBenchStore(b, 1000)
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetAllPendingMessages(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("adhoc", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows, err := s.db.Query(pendingMessagesQuery)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for rows.Next() {
+			}
+			rows.Close()
+		}
+	})
+}
+
+func BenchmarkEnqueueMessage(b *testing.B) {
+	s := setupBenchStore(b, 0)
+	msgID, _ := s.SaveMessage("news", []byte(`{}`))
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.EnqueueMessage(msgID, "token-1"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("adhoc", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.writer.Exec(`INSERT INTO queue (message_id, token, status) VALUES (?, ?, 'pending')`, msgID, "token-1"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetSubscriptionsByToken(b *testing.B) {
+	s := setupBenchStore(b, 20000)
+	run := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetSubscriptionsByToken("other-777"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("indexed", run)
+	_, _ = s.writer.Exec(`DROP INDEX idx_subscriptions_token`)
+	b.Run("unindexed", run)
+}
+
+func BenchmarkGetSubscriptionsByUser(b *testing.B) {
+	s := setupBenchStore(b, 20000)
+	run := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.GetSubscriptionsByUser("reader"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("indexed", run)
+	_, _ = s.writer.Exec(`DROP INDEX idx_subscriptions_username`)
+	b.Run("unindexed", run)
+}
+
+// BenchmarkEnqueueMessages enqueues a message for 10k subscribers, in one
+// transaction or one statement per subscriber.
+func BenchmarkEnqueueMessages(b *testing.B) {
+	s := setupBenchStore(b, 0)
+	msgID, _ := s.SaveMessage("news", []byte(`{}`))
+	entries := make([]QueueEntry, 10000)
+	for i := range entries {
+		entries[i].Token = fmt.Sprintf("token-%d", i)
+	}
+	b.Run("bulk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.EnqueueMessages(msgID, entries); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, e := range entries {
+				if _, err := s.EnqueueMessage(msgID, e.Token); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,19 @@
+//go:build cgo
+
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteError wraps unique constraint failures in ErrDuplicate.
+func sqliteError(err error) error {
+	var se sqlite3.Error
+	if errors.As(err, &se) && (se.ExtendedCode == sqlite3.ErrConstraintUnique || se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	}
+	return err
+}
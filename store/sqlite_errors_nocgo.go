@@ -0,0 +1,6 @@
+//go:build !cgo
+
+package store
+
+// sqliteError is a no-op: without cgo, SQLite never opens.
+func sqliteError(err error) error { return err }
@@ -1,7 +1,12 @@
 package store
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // setupTestStore creates an in-memory SQLite database for testing
@@ -204,7 +209,7 @@ func TestDeleteUser(t *testing.T) {
 
 	// Create user
 	// Create user
-	err := store.CreateUser("testuser", "hash", "admin")
+	err := store.CreateUser("testuser", "hash", "publisher")
 	if err != nil {
 		t.Fatalf("Failed to create user: %v", err)
 	}
@@ -349,12 +354,13 @@ func TestGetRecentMessages(t *testing.T) {
 		t.Fatalf("Expected 3 messages, got %d", len(messages))
 	}
 
-	// Messages should be in reverse chronological order (newest first)
-	if string(messages[0].Payload) != `{"msg": "3"}` {
-		t.Fatalf("Expected first message to be msg:3 (newest), got %s", messages[0].Payload)
+	// Messages should be in chronological order (oldest first), even
+	// when saved within the same second
+	if string(messages[0].Payload) != `{"msg": "1"}` {
+		t.Fatalf("Expected first message to be msg:1 (oldest), got %s", messages[0].Payload)
 	}
-	if string(messages[2].Payload) != `{"msg": "1"}` {
-		t.Fatalf("Expected last message to be msg:1 (oldest), got %s", messages[2].Payload)
+	if string(messages[2].Payload) != `{"msg": "3"}` {
+		t.Fatalf("Expected last message to be msg:3 (newest), got %s", messages[2].Payload)
 	}
 }
 
@@ -757,3 +763,447 @@ func TestGetTotalMessagesSent(t *testing.T) {
 		t.Fatalf("Expected 2 messages, got %d", count)
 	}
 }
+
+// TestClaimQueueItem tests that only one node can claim a pending item
+func TestClaimQueueItem(t *testing.T) {
+	store := setupTestStore(t)
+
+	store.CreateTopic("claim-topic")
+	store.AddSubscription("claim-topic", "token1", "mock", "user1")
+	msgID, _ := store.SaveMessage("claim-topic", []byte("payload"))
+	queueID, _ := store.EnqueueMessage(msgID, "token1")
+
+	ok, err := store.ClaimQueueItem(queueID, "node-a", time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("Expected node-a to claim item, got ok=%v err=%v", ok, err)
+	}
+
+	ok, err = store.ClaimQueueItem(queueID, "node-b", time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimQueueItem failed: %v", err)
+	}
+	if ok {
+		t.Error("node-b should not claim an item leased to node-a")
+	}
+
+	// Re-claiming by the owner extends the lease
+	ok, _ = store.ClaimQueueItem(queueID, "node-a", time.Minute)
+	if !ok {
+		t.Error("node-a should be able to renew its own claim")
+	}
+
+	// Expired lease can be taken over
+	ok, _ = store.ClaimQueueItem(queueID, "node-a", -time.Second)
+	if !ok {
+		t.Fatal("node-a should renew with an already expired lease")
+	}
+	ok, _ = store.ClaimQueueItem(queueID, "node-b", time.Minute)
+	if !ok {
+		t.Error("node-b should claim an item whose lease expired")
+	}
+
+	// Delivered items cannot be claimed
+	store.MarkDelivered(queueID)
+	ok, _ = store.ClaimQueueItem(queueID, "node-c", time.Minute)
+	if ok {
+		t.Error("Delivered item should not be claimable")
+	}
+}
+
+// TestSubscriptionOptions tests storing per-subscription webhook options
+func TestSubscriptionOptions(t *testing.T) {
+	store := setupTestStore(t)
+
+	store.CreateTopic("hooks")
+	store.AddSubscription("hooks", "https://example.com/hook", "webhook", "user1")
+
+	opts := &WebhookOptions{
+		Method:     "PUT",
+		Headers:    map[string]string{"Authorization": "Bearer abc"},
+		TimeoutMs:  2000,
+		MaxRetries: 2,
+	}
+	if err := store.SetSubscriptionOptions("hooks", "https://example.com/hook", opts); err != nil {
+		t.Fatalf("SetSubscriptionOptions failed: %v", err)
+	}
+
+	subs, err := store.GetSubscribers("hooks")
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("GetSubscribers failed: %v", err)
+	}
+	got := subs[0].Options
+	if got == nil || got.Method != "PUT" || got.Headers["Authorization"] != "Bearer abc" || got.MaxRetries != 2 {
+		t.Errorf("Unexpected options: %+v", got)
+	}
+
+	// Options travel with queue items
+	msgID, _ := store.SaveMessage("hooks", []byte(`{}`))
+	store.EnqueueMessage(msgID, "https://example.com/hook")
+	items, _ := store.GetAllPendingMessages()
+	if len(items) != 1 || items[0].Options == nil || items[0].Options.TimeoutMs != 2000 {
+		t.Errorf("Expected options on queue item, got %+v", items)
+	}
+
+	// Clearing
+	store.SetSubscriptionOptions("hooks", "https://example.com/hook", nil)
+	subs, _ = store.GetSubscribers("hooks")
+	if subs[0].Options != nil {
+		t.Errorf("Expected options to be cleared, got %+v", subs[0].Options)
+	}
+}
+
+// TestSubscriptionLocale tests subscriber locales and per-subscriber queue payloads
+func TestSubscriptionLocale(t *testing.T) {
+	store := setupTestStore(t)
+
+	store.CreateTopic("news")
+	store.AddSubscription("news", "device-fr", "fcm", "user1")
+	store.AddSubscription("news", "device-en", "fcm", "user2")
+
+	if err := store.SetSubscriptionLocale("news", "device-fr", "fr"); err != nil {
+		t.Fatalf("SetSubscriptionLocale failed: %v", err)
+	}
+	subs, _ := store.GetSubscriptionsByToken("device-fr")
+	if len(subs) != 1 || subs[0].Locale != "fr" {
+		t.Errorf("Expected locale fr, got %+v", subs)
+	}
+
+	msgID, _ := store.SaveMessage("news", []byte(`{"text":"hello"}`))
+	store.EnqueueMessagePayload(msgID, "device-fr", []byte(`{"text":"bonjour"}`))
+	store.EnqueueMessage(msgID, "device-en")
+
+	fr, _ := store.GetPendingMessages("device-fr")
+	en, _ := store.GetPendingMessages("device-en")
+	if len(fr) != 1 || string(fr[0].Payload) != `{"text":"bonjour"}` {
+		t.Errorf("Expected localized payload, got %+v", fr)
+	}
+	if len(en) != 1 || string(en[0].Payload) != `{"text":"hello"}` {
+		t.Errorf("Expected default payload, got %+v", en)
+	}
+}
+
+// TestSubscriptionTags tests tag updates and tag-based unsubscribe
+func TestSubscriptionTags(t *testing.T) {
+	store := setupTestStore(t)
+
+	store.CreateTopic("promo")
+	store.CreateTopic("news")
+	store.AddSubscription("promo", "device1", "fcm", "user1")
+	store.AddSubscription("news", "device1", "fcm", "user1")
+	store.AddSubscription("promo", "device2", "fcm", "user2")
+
+	tags, err := store.UpdateSubscriptionTags("promo", "device1", []string{"marketing", "beta", "marketing"}, nil)
+	if err != nil || len(tags) != 2 {
+		t.Fatalf("Expected 2 tags, got %v (%v)", tags, err)
+	}
+	tags, _ = store.UpdateSubscriptionTags("promo", "device1", nil, []string{"beta"})
+	if len(tags) != 1 || tags[0] != "marketing" {
+		t.Errorf("Expected [marketing], got %v", tags)
+	}
+	store.UpdateSubscriptionTags("promo", "device2", []string{"marketing"}, nil)
+
+	if _, err := store.UpdateSubscriptionTags("promo", "missing", []string{"x"}, nil); err == nil {
+		t.Error("Expected error for missing subscription")
+	}
+
+	n, err := store.RemoveSubscriptionsByTag("user1", "marketing")
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 removed, got %d (%v)", n, err)
+	}
+	subs, _ := store.GetSubscriptionsByToken("device1")
+	if len(subs) != 1 || subs[0].Topic != "news" {
+		t.Errorf("Expected only news subscription left, got %+v", subs)
+	}
+	// Other users are untouched
+	if subs, _ := store.GetSubscriptionsByToken("device2"); len(subs) != 1 {
+		t.Errorf("Expected user2's subscription to remain, got %+v", subs)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	store := setupTestStore(t)
+
+	store.AddAuditEvent(AuditEvent{Actor: "admin", IP: "10.0.0.1", Action: "user.create", Target: "bob", Details: map[string]string{"role": "publisher"}})
+	store.AddAuditEvent(AuditEvent{Actor: "admin", IP: "10.0.0.1", Action: "user.delete", Target: "bob"})
+	store.AddAuditEvent(AuditEvent{Actor: "mallory", IP: "10.0.0.9", Action: "login.failure", Target: "mallory"})
+
+	events, err := store.ListAuditEvents(AuditFilter{Action: "user.*"})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Expected 2 user events, got %v (%v)", events, err)
+	}
+	if events[0].Action != "user.delete" || events[1].Details["role"] != "publisher" {
+		t.Errorf("Expected newest first with details, got %+v", events)
+	}
+	if events, _ := store.ListAuditEvents(AuditFilter{Actor: "mallory"}); len(events) != 1 || events[0].IP != "10.0.0.9" {
+		t.Errorf("Expected 1 event for mallory, got %+v", events)
+	}
+	if events, _ := store.ListAuditEvents(AuditFilter{Since: time.Now().Add(time.Hour)}); len(events) != 0 {
+		t.Errorf("Expected no events in the future, got %+v", events)
+	}
+
+	// Append-only
+	if _, err := store.db.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Error("Expected delete from audit log to fail")
+	}
+	if _, err := store.db.Exec(`UPDATE audit_log SET actor = 'x'`); err == nil {
+		t.Error("Expected update of audit log to fail")
+	}
+}
+
+func TestRedeemInvitation_Expired(t *testing.T) {
+	store := setupTestStore(t)
+
+	past := time.Now().Add(-time.Minute)
+	store.CreateInvitation(Invitation{Code: "old", Role: "subscriber", MaxUses: 5, ExpiresAt: &past})
+	if inv, err := store.RedeemInvitation("old", "frank", "hash"); err != nil || inv != nil {
+		t.Errorf("Expected expired invitation to be rejected, got %+v (%v)", inv, err)
+	}
+	if user, _ := store.GetUser("frank"); user != nil {
+		t.Error("Expected no user for expired invitation")
+	}
+
+	future := time.Now().Add(time.Hour)
+	store.CreateInvitation(Invitation{Code: "new", Role: "subscriber", MaxUses: 2, ExpiresAt: &future})
+	if inv, err := store.RedeemInvitation("new", "frank", "hash"); err != nil || inv == nil || inv.Uses != 1 {
+		t.Errorf("Expected redemption, got %+v (%v)", inv, err)
+	}
+	// A failed user insert doesn't consume a use
+	store.RedeemInvitation("new", "frank", "hash")
+	invs, _ := store.ListInvitations()
+	for _, inv := range invs {
+		if inv.Code == "new" && inv.Uses != 1 {
+			t.Errorf("Expected 1 use after duplicate username, got %d", inv.Uses)
+		}
+	}
+}
+
+func TestSQLiteStore_ConcurrentWrites(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	var mode string
+	if err := s.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("Expected WAL journal mode, got %q (%v)", mode, err)
+	}
+	var fk int
+	if err := s.db.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 {
+		t.Errorf("Expected foreign keys on, got %d (%v)", fk, err)
+	}
+
+	if err := s.CreateTopic("load"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 400)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := s.SaveMessage("load", []byte(`{}`)); err != nil {
+					errs <- err
+				}
+				if err := s.AddSubscription("load", fmt.Sprintf("token-%d-%d", i, j), "mock", "user"); err != nil {
+					errs <- err
+				}
+				if _, err := s.GetSubscribers("load"); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent operation failed: %v", err)
+	}
+
+	subs, _ := s.GetSubscribers("load")
+	if len(subs) != 200 {
+		t.Errorf("Expected 200 subscribers, got %d", len(subs))
+	}
+}
+
+func TestSQLiteStore_AdoptsLegacySchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := OpenSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A database from before versioned migrations, missing later columns
+	_, err = db.Exec(`
+		CREATE TABLE topics (name TEXT PRIMARY KEY);
+		CREATE TABLE subscriptions (topic TEXT, token TEXT, provider TEXT, PRIMARY KEY (topic, token));
+		CREATE TABLE users (username TEXT PRIMARY KEY, password_hash TEXT, role TEXT);
+		INSERT INTO topics (name) VALUES ('news');
+		INSERT INTO subscriptions (topic, token, provider) VALUES ('news', 'tok', 'fcm');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	subs, err := s.GetSubscribers("news")
+	if err != nil || len(subs) != 1 {
+		t.Fatalf("Expected legacy subscription, got %v (%v)", subs, err)
+	}
+	if err := s.SetSubscriptionLocale("news", "tok", "fr"); err != nil {
+		t.Errorf("Expected locale column to be added: %v", err)
+	}
+
+	m, _ := NewSQLiteMigrator(s.writer)
+	if v, _ := m.Version(); v != m.Latest() {
+		t.Errorf("Expected latest version %d, got %d", m.Latest(), v)
+	}
+}
+
+func TestSQLiteStore_MigrationsRoundTrip(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	m, _ := NewSQLiteMigrator(s.writer)
+
+	// Every migration can be reverted down to the initial schema and reapplied
+	if err := m.Down(1); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if err := m.Up(m.Latest()); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if err := s.CreateUser("alice", "hash", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMustChangePassword("alice", true); err != nil {
+		t.Errorf("Expected the latest schema after migrating up: %v", err)
+	}
+}
+
+// TestSearchIndexRebuild checks that messages saved while the FTS5 triggers
+// were missing, e.g. by a build without FTS5, are indexed on the next open.
+func TestSearchIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if !s.fts {
+		t.Skip("built without the sqlite_fts5 tag")
+	}
+	s.CreateTopic("news")
+	s.SaveMessage("news", []byte(`{"title": "Disk full"}`))
+	for name := range searchTriggers {
+		if _, err := s.writer.Exec(`DROP TRIGGER ` + name); err != nil {
+			t.Fatalf("Failed to drop %s: %v", name, err)
+		}
+	}
+	s.SaveMessage("news", []byte(`{"title": "Disk slow"}`))
+
+	if s, err = NewSQLiteStore(path); err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	if msgs, _ := s.SearchMessages(MessageSearch{Topic: "news", Query: "disk"}); len(msgs) != 2 {
+		t.Errorf("Expected both messages after the rebuild, got %d", len(msgs))
+	}
+}
+
+// TestPayloadCompression checks that large payloads are stored compressed
+// and read back, and searched, as they were saved.
+func TestPayloadCompression(t *testing.T) {
+	large := []byte(`{"title": "Disk full", "body": "` + strings.Repeat("lorem ipsum ", 100) + `"}`)
+	for _, algo := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(algo, func(t *testing.T) {
+			s := setupTestStore(t)
+			if err := s.SetPayloadCompression(algo, 64); err != nil {
+				t.Fatal(err)
+			}
+			s.CreateTopic("news")
+			s.AddSubscription("news", "tok", "webhook", "")
+			small, _ := s.SaveMessage("news", []byte(`{"title": "Disk slow"}`))
+			id, _ := s.SaveMessage("news", large)
+
+			var stored []byte
+			s.db.QueryRow(`SELECT payload FROM messages WHERE id = ?`, id).Scan(&stored)
+			if !compressed(stored) || len(stored) >= len(large) {
+				t.Errorf("Expected the large payload stored compressed, got %d bytes", len(stored))
+			}
+			s.db.QueryRow(`SELECT payload FROM messages WHERE id = ?`, small).Scan(&stored)
+			if string(stored) != `{"title": "Disk slow"}` {
+				t.Errorf("Expected the small payload stored as is, got %q", stored)
+			}
+
+			if msg, _ := s.GetMessage(id); msg == nil || string(msg.Payload) != string(large) {
+				t.Errorf("Expected the payload decompressed on read")
+			}
+			msgs, err := s.SearchMessages(MessageSearch{Topic: "news", Query: "lorem"})
+			if err != nil || len(msgs) != 1 || msgs[0].ID != id {
+				t.Errorf("Expected the compressed message to be searchable, got %v (%v)", msgs, err)
+			}
+
+			s.EnqueueMessagePayload(id, "tok", large)
+			items, _ := s.GetPendingMessages("tok")
+			if len(items) != 1 || string(items[0].Payload) != string(large) {
+				t.Errorf("Expected the queued payload decompressed, got %v", items)
+			}
+			items, _ = s.GetAllPendingMessages()
+			if len(items) != 1 || string(items[0].Payload) != string(large) {
+				t.Errorf("Expected the queued payload decompressed, got %v", items)
+			}
+		})
+	}
+}
+
+// TestPayloadsStoredOnce checks that identical queue item payloads are
+// stored once, and removed with the last item using them, while items queued
+// with their own copy before are still read.
+func TestPayloadsStoredOnce(t *testing.T) {
+	s := setupTestStore(t)
+	s.CreateTopic("news")
+	s.AddSubscription("news", "tok", "webhook", "")
+	id, _ := s.SaveMessage("news", []byte(`{}`))
+	entries := make([]QueueEntry, 100)
+	for i := range entries {
+		entries[i] = QueueEntry{Token: "tok", Payload: []byte(`{"title":"Hello"}`)}
+	}
+	s.EnqueueMessages(id, entries)
+	s.EnqueueMessagePayload(id, "tok", []byte(`{"title":"Bonjour"}`))
+	s.writer.Exec(`INSERT INTO queue (message_id, token, status, payload) VALUES (?, 'tok', 'pending', '{"title":"Hallo"}')`, id)
+
+	count := func() (n int) {
+		s.db.QueryRow(`SELECT COUNT(*) FROM payloads`).Scan(&n)
+		return n
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected 2 stored payloads, got %d", n)
+	}
+	items, _ := s.GetPendingMessages("tok")
+	if len(items) != 102 || string(items[100].Payload) != `{"title":"Bonjour"}` || string(items[101].Payload) != `{"title":"Hallo"}` {
+		t.Errorf("Unexpected pending items %v", items[100:])
+	}
+	s.ClearTopicMessages("news")
+	if n := count(); n != 0 {
+		t.Errorf("Expected the payloads removed with their items, got %d", n)
+	}
+}
+
+// TestPayloadCompressionMagic checks that a payload that merely looks
+// compressed round trips, with or without compression enabled.
+func TestPayloadCompressionMagic(t *testing.T) {
+	s := setupTestStore(t)
+	s.CreateTopic("news")
+	payload := append([]byte{0x1f, 0x8b}, "not gzip"...)
+	id, _ := s.SaveMessage("news", payload)
+	if msg, _ := s.GetMessage(id); msg == nil || string(msg.Payload) != string(payload) {
+		t.Errorf("Expected the payload unchanged, got %v", msg)
+	}
+	if err := s.SetPayloadCompression("brotli", 0); err == nil {
+		t.Error("Expected an unknown algorithm to be rejected")
+	}
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StorageUsage is the disk space a store uses, and what uses it.
+type StorageUsage struct {
+	Backend       string       `json:"backend"`
+	DatabaseBytes int64        `json:"database_bytes"`      // Size of the database file
+	WALBytes      int64        `json:"wal_bytes,omitempty"` // Size of the write-ahead log (sqlite)
+	FreeBytes     int64        `json:"free_bytes"`          // Unused pages within the file, reclaimed by compacting
+	Queue         QueueUsage   `json:"queue"`
+	Topics        []TopicUsage `json:"topics"`
+}
+
+// QueueUsage is the size of the delivery queue.
+type QueueUsage struct {
+	Items        int64 `json:"items"`
+	Pending      int64 `json:"pending"`
+	PayloadBytes int64 `json:"payload_bytes"` // Per-subscriber payloads stored for queue items
+}
+
+// TopicUsage is the size of a topic's stored messages.
+type TopicUsage struct {
+	Topic        string `json:"topic"`
+	Messages     int64  `json:"messages"`
+	MessageBytes int64  `json:"message_bytes"` // Payloads as stored, i.e. compressed when they are
+}
+
+// CompactResult is the size of a store's files before and after compacting.
+type CompactResult struct {
+	BytesBefore int64 `json:"bytes_before"`
+	BytesAfter  int64 `json:"bytes_after"`
+}
+
+// Storage reports the disk usage of the database.
+func (s *SQLiteStore) Storage() (*StorageUsage, error) {
+	u := &StorageUsage{Backend: "sqlite", Topics: []TopicUsage{}}
+	if err := s.fileUsage(u); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT topic, COUNT(*), COALESCE(SUM(LENGTH(payload)), 0) FROM messages GROUP BY topic ORDER BY topic`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TopicUsage
+		if err := rows.Scan(&t.Topic, &t.Messages, &t.MessageBytes); err != nil {
+			return nil, err
+		}
+		u.Topics = append(u.Topics, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var inline, stored int64
+	err = s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(status = 'pending'), 0), COALESCE(SUM(LENGTH(payload)), 0) FROM queue`).
+		Scan(&u.Queue.Items, &u.Queue.Pending, &inline)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRow(`SELECT COALESCE(SUM(LENGTH(data)), 0) FROM payloads`).Scan(&stored); err != nil {
+		return nil, err
+	}
+	u.Queue.PayloadBytes = inline + stored
+	return u, nil
+}
+
+// fileUsage fills in the sizes of the database and WAL files, and of the
+// free pages. An in-memory database is measured in pages.
+func (s *SQLiteStore) fileUsage(u *StorageUsage) error {
+	var pageSize, pages, free int64
+	for pragma, v := range map[string]*int64{"page_size": &pageSize, "page_count": &pages, "freelist_count": &free} {
+		if err := s.db.QueryRow(`PRAGMA ` + pragma).Scan(v); err != nil {
+			return err
+		}
+	}
+	u.DatabaseBytes, u.FreeBytes, u.WALBytes = pages*pageSize, free*pageSize, 0
+
+	var seq int
+	var name, file string
+	if err := s.db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file); err != nil {
+		return err
+	}
+	if file == "" {
+		return nil
+	}
+	if fi, err := os.Stat(file); err == nil {
+		u.DatabaseBytes = fi.Size()
+	}
+	if fi, err := os.Stat(file + "-wal"); err == nil {
+		u.WALBytes = fi.Size()
+	}
+	return nil
+}
+
+// Compact rebuilds the database with VACUUM, dropping its free pages, and
+// truncates the WAL. Writes wait while it runs, which takes about as long as
+// copying the database.
+func (s *SQLiteStore) Compact() (*CompactResult, error) {
+	var before, after StorageUsage
+	if err := s.fileUsage(&before); err != nil {
+		return nil, err
+	}
+	if _, err := s.writer.Exec(`VACUUM`); err != nil {
+		return nil, err
+	}
+	if _, err := s.writer.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return nil, err
+	}
+	if err := s.fileUsage(&after); err != nil {
+		return nil, err
+	}
+	return &CompactResult{
+		BytesBefore: before.DatabaseBytes + before.WALBytes,
+		BytesAfter:  after.DatabaseBytes + after.WALBytes,
+	}, nil
+}
+
+// Storage reports the disk usage of the database. bbolt files can't be
+// compacted in place, so there is no Compact.
+func (s *BoltStore) Storage() (*StorageUsage, error) {
+	u := &StorageUsage{Backend: "bolt", Topics: []TopicUsage{}}
+	if fi, err := os.Stat(s.db.Path()); err == nil {
+		u.DatabaseBytes = fi.Size()
+	}
+	stats := s.db.Stats()
+	u.FreeBytes = int64(stats.FreePageN+stats.PendingPageN) * int64(s.db.Info().PageSize)
+
+	topics := map[string]*TopicUsage{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketMessages).ForEach(func(_, v []byte) error {
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			t, ok := topics[m.Topic]
+			if !ok {
+				t = &TopicUsage{Topic: m.Topic}
+				topics[m.Topic] = t
+			}
+			t.Messages++
+			t.MessageBytes += int64(len(m.Payload))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(bucketQueue).ForEach(func(_, v []byte) error {
+			var q boltQueueItem
+			if err := json.Unmarshal(v, &q); err != nil {
+				return err
+			}
+			u.Queue.Items++
+			u.Queue.PayloadBytes += int64(len(q.Payload))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		u.Queue.Pending = int64(tx.Bucket(bucketPending).Stats().KeyN)
+		return tx.Bucket(bucketPayloads).ForEach(func(_, v []byte) error {
+			u.Queue.PayloadBytes += int64(len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range topics {
+		u.Topics = append(u.Topics, *t)
+	}
+	sort.Slice(u.Topics, func(i, j int) bool { return u.Topics[i].Topic < u.Topics[j].Topic })
+	return u, nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fillStorage saves n 1KB messages to news and one to sports, and queues
+// the sports message with its own payload.
+func fillStorage(t *testing.T, s Store, n int) {
+	t.Helper()
+	s.CreateTopic("news")
+	s.CreateTopic("sports")
+	payload := []byte(`{"body":"` + strings.Repeat("x", 1000) + `"}`)
+	for i := 0; i < n; i++ {
+		if _, err := s.SaveMessage("news", payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, _ := s.SaveMessage("sports", []byte(`{}`))
+	s.EnqueueMessagePayload(id, "tok", []byte(`{"n":1}`))
+	s.EnqueueMessage(id, "tok-2")
+}
+
+func checkUsage(t *testing.T, u *StorageUsage, news int64) {
+	t.Helper()
+	if u.DatabaseBytes == 0 {
+		t.Error("Expected the database size")
+	}
+	want := []TopicUsage{{Topic: "news", Messages: news, MessageBytes: news * 1011}, {Topic: "sports", Messages: 1, MessageBytes: 2}}
+	if fmt.Sprint(u.Topics) != fmt.Sprint(want) {
+		t.Errorf("Expected topics %v, got %v", want, u.Topics)
+	}
+	if u.Queue != (QueueUsage{Items: 2, Pending: 2, PayloadBytes: 7}) {
+		t.Errorf("Unexpected queue usage %+v", u.Queue)
+	}
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStorage(t, s, 500)
+	u, err := s.Storage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, u, 500)
+
+	s.DeleteMessagesBefore("news", time.Now().Add(time.Hour))
+	res, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.BytesAfter >= res.BytesBefore/2 {
+		t.Errorf("Expected compacting to free the purged messages, got %+v", res)
+	}
+	if u, _ = s.Storage(); u.FreeBytes != 0 || u.WALBytes != 0 || u.DatabaseBytes != res.BytesAfter {
+		t.Errorf("Expected no free pages and an empty WAL after compacting, got %+v", u)
+	}
+}
+
+func TestBoltStorage(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "test.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	fillStorage(t, s, 3)
+	u, err := s.Storage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, u, 3)
+}
@@ -1,21 +1,138 @@
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"time"
 )
 
+// Errors every backend wraps, so callers can classify failures with
+// errors.Is instead of matching messages.
+var (
+	// ErrNotFound is returned when the record an operation targets doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when a record with the same key already exists.
+	ErrDuplicate = errors.New("already exists")
+	// ErrInUse is returned when a record can't be deleted while others refer to it.
+	ErrInUse = errors.New("in use")
+	// ErrLastAdmin is returned when deleting, demoting or disabling a user
+	// would leave no admin who isn't disabled.
+	ErrLastAdmin = errors.New("last admin")
+	// ErrLimitReached is returned when creating a record would exceed a cap.
+	ErrLimitReached = errors.New("limit reached")
+)
+
 type Subscriber struct {
-	Topic    string `json:"topic"`
-	Token    string `json:"token"`
-	Provider string `json:"provider"`
-	Username string `json:"-"` // Internal use, don't expose
+	Topic    string          `json:"topic"`
+	Token    string          `json:"token"`
+	Provider string          `json:"provider"`
+	Username string          `json:"-"` // Internal use, don't expose
+	Options  *WebhookOptions `json:"options,omitempty"`
+	Locale   string          `json:"locale,omitempty"` // e.g. "fr-ca"; picks localized payload variants
+
+	// Device attributes used by segment queries
+	Platform   string   `json:"platform,omitempty"`    // e.g. "android", "ios", "web"
+	AppVersion string   `json:"app_version,omitempty"` // Dotted version, e.g. "2.4.1"
+	Tags       []string `json:"tags,omitempty"`
+
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"` // Last successful delivery
+
+	Preferences *Preferences `json:"preferences,omitempty"`
+}
+
+// Preferences are a subscriber's own limits on the notifications delivered
+// to a subscription.
+type Preferences struct {
+	MutedUntil  *time.Time `json:"muted_until,omitempty"`
+	MinPriority string     `json:"min_priority,omitempty"` // "high" declines normal priority notifications
+	MaxPerDay   int        `json:"max_per_day,omitempty"`  // Notifications in any 24 hours; 0 is unlimited
+
+	MutedCategories []string `json:"muted_categories,omitempty"` // Notification categories declined, e.g. "marketing"
+}
+
+// WebhookOptions customizes how a webhook subscription is delivered.
+type WebhookOptions struct {
+	Method     string            `json:"method,omitempty"`      // HTTP method, default POST
+	Headers    map[string]string `json:"headers,omitempty"`     // Extra request headers (e.g. Authorization)
+	TimeoutMs  int               `json:"timeout_ms,omitempty"`  // Per-attempt timeout
+	MaxRetries int               `json:"max_retries,omitempty"` // Immediate retries before the item is left pending
+	Format     string            `json:"format,omitempty"`      // Render canonical notifications as "json", "slack" or "discord"
+
+	ContentEncoding string `json:"content_encoding,omitempty"` // "gzip" compresses request bodies
 }
 
 type User struct {
 	Username     string
 	PasswordHash string
 	Role         string
+	TOTPSecret   string // Set during enrollment, before TOTPEnabled
+	TOTPEnabled  bool
+	TOTPFailures int       // Failed second factor attempts in a row
+	TOTPFailedAt time.Time // Last failed second factor attempt
+
+	MustChangePassword bool   // Login requires a new password, e.g. for a generated initial password
+	Disabled           bool   // Can't log in or get tokens, and its subscriptions are paused
+	ExternalID         string // Identity provider account it logs in with; "" for local accounts
+
+	// Profile, edited by the user
+	DisplayName string
+	Email       string // Contact address, not verified
+
+	Unread int // Badge of "unread" notifications; incremented by them, set by the app
+}
+
+// Role is a custom role and the permissions it grants (see package rbac).
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// Invitation lets someone register an account with the given role.
+type Invitation struct {
+	Code      string     `json:"code"`
+	Role      string     `json:"role"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Session is an issued access token, identified by its JWT ID.
+type Session struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Method     string     `json:"method"` // How it was issued, e.g. "password", "oidc", "refresh", "mint"
+	IP         string     `json:"ip"`     // Client IP it was issued to
+	IssuedAt   time.Time  `json:"issued_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastIP     string     `json:"last_ip,omitempty"`
+}
+
+// TopicInfo is the metadata and default settings of a topic.
+type TopicInfo struct {
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	Owner         string    `json:"owner"`          // Username responsible for the topic
+	CreatedAt     time.Time `json:"created_at"`     // Zero for topics created before it was recorded
+	ReplayCount   *int      `json:"replay_count"`   // Recent messages replayed to new subscribers; nil for the default
+	RetentionDays int       `json:"retention_days"` // Messages older than this are deleted; 0 keeps them
+	Public        bool      `json:"public"`         // Anyone may open the topic's subscription page
+
+	// Delivery policy; 0 keeps the server's behavior of retrying until delivered
+	MaxAttempts         int `json:"max_attempts"`          // Attempts of a delivery before it's failed
+	RetryBackoffSeconds int `json:"retry_backoff_seconds"` // Delay before the first retry, doubling for each next one
+	TTLSeconds          int `json:"ttl_seconds"`           // Deliveries pending longer are failed, and older messages aren't replayed
+}
+
+// TopicAlias is an old name of a renamed topic that still resolves to it.
+type TopicAlias struct {
+	Alias string `json:"alias"`
+	Topic string `json:"topic"`
 }
 
 type Message struct {
@@ -23,29 +140,317 @@ type Message struct {
 	Topic     string
 	Payload   []byte // JSON raw
 	CreatedAt time.Time
+	Origin    MessageOrigin `json:",omitzero"`
+}
+
+// MessageOrigin records who published a message and from where.
+type MessageOrigin struct {
+	Publisher string `json:"publisher,omitempty"` // Username, or "source:<name>" for ingested messages
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
 }
 
 type Notification struct {
-	Topic   string          `json:"topic"`
-	Payload json.RawMessage `json:"payload"`
+	Topic      string          `json:"topic"`
+	MessageID  int64           `json:"message_id,omitempty"`  // Set on delivery, for read receipts
+	DeliveryID string          `json:"delivery_id,omitempty"` // Same on every redelivery, for client dedupe
+	Seq        int64           `json:"seq,omitempty"`         // Increases by one per message of the topic, for gap detection
+	From       string          `json:"from,omitempty"`        // Publisher's username, if the send asked for it
+	Payload    json.RawMessage `json:"payload"`
 }
 
-type QueueItem struct {
+// Template is a named payload template for a topic. Locale "" is the default variant.
+type Template struct {
+	Topic  string `json:"topic"`
+	Name   string `json:"name"`
+	Locale string `json:"locale,omitempty"`
+	Body   string `json:"body"` // Go text/template producing the JSON payload
+}
+
+// FilterRule is an admin-managed content rule evaluated on publish.
+type FilterRule struct {
 	ID        int64     `json:"id"`
-	MessageID int64     `json:"message_id"`
-	Token     string    `json:"token"`
-	Provider  string    `json:"provider"`
-	Status    string    `json:"status"`
-	Payload   []byte    `json:"payload"`
+	Topic     string    `json:"topic,omitempty"` // "" applies to every topic
+	Type      string    `json:"type"`            // keyword, regex, max_size or url_allowlist
+	Pattern   string    `json:"pattern"`
+	Record    bool      `json:"record"` // Write rejections to the moderation log
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ModerationEntry records a publish rejected by a filter rule.
+type ModerationEntry struct {
+	ID        int64           `json:"id"`
+	RuleID    int64           `json:"rule_id"`
+	Publisher string          `json:"publisher"`
+	Topic     string          `json:"topic"`
+	Reason    string          `json:"reason"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Approval is a topic message held for admin review before fan-out.
+type Approval struct {
+	MessageID int64           `json:"message_id"`
+	Topic     string          `json:"topic"`
+	Publisher string          `json:"publisher"`
+	Audience  int             `json:"audience"` // Matching subscribers when the message was held
+	Request   json.RawMessage `json:"request"`  // Everything needed to fan out later
+	Status    string          `json:"status"`   // pending, approved or rejected
+	DecidedBy string          `json:"decided_by,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	DecidedAt *time.Time      `json:"decided_at,omitempty"`
+}
+
+// Approval statuses
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// Schedule publishes a message to a topic whenever its cron expression
+// matches.
+type Schedule struct {
+	ID        int64           `json:"id"`
+	Topic     string          `json:"topic"`
+	Cron      string          `json:"cron"`
+	Timezone  string          `json:"timezone"` // IANA name the expression is evaluated in
+	Request   json.RawMessage `json:"request"`  // Publish request sent on each run
+	Paused    bool            `json:"paused"`
+	CreatedBy string          `json:"created_by"` // Runs publish as this user
+	CreatedAt time.Time       `json:"created_at"`
+	NextRun   time.Time       `json:"next_run"`
+}
+
+// ScheduleRun is one run of a schedule.
+type ScheduleRun struct {
+	ID          int64     `json:"id"`
+	ScheduleID  int64     `json:"schedule_id"`
+	ScheduledAt time.Time `json:"scheduled_at"` // When the run was due
+	RanAt       time.Time `json:"ran_at"`
+	Status      string    `json:"status"` // sent, held or failed
+	MessageID   int64     `json:"message_id,omitempty"`
+	Enqueued    int       `json:"enqueued"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Schedule run statuses
+const (
+	ScheduleRunSent   = "sent"
+	ScheduleRunHeld   = "held" // Awaiting approval
+	ScheduleRunFailed = "failed"
+)
+
+// MaxScheduleRuns is how many of its latest runs a schedule keeps.
+const MaxScheduleRuns = 100
+
+// Draft is a topic send saved for review, preview and test sends before
+// it is published.
+type Draft struct {
+	ID          int64           `json:"id"`
+	Topic       string          `json:"topic"`
+	Request     json.RawMessage `json:"request"` // Send request published in the end
+	Status      string          `json:"status"`  // draft, publishing or published
+	CreatedBy   string          `json:"created_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	PublishedAt *time.Time      `json:"published_at,omitempty"`
+	MessageID   int64           `json:"message_id,omitempty"` // Message it was published as
+}
+
+// Draft statuses
+const (
+	DraftOpen       = "draft"
+	DraftPublishing = "publishing"
+	DraftPublished  = "published"
+)
+
+// AuditEvent is an entry in the append-only audit log.
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	Actor     string            `json:"actor"` // Username, or the attempted username for login failures
+	IP        string            `json:"ip"`
+	Action    string            `json:"action"` // e.g. "user.create", "login.failure"
+	Target    string            `json:"target,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// AuditFilter narrows ListAuditEvents. Zero values match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string // Exact action, or a prefix ending in ".*" (e.g. "user.*")
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// MessageSearch narrows SearchMessages to one topic. Other zero values
+// match everything.
+type MessageSearch struct {
+	Topic string
+	Query string // Words that must all appear in the payload
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// MessageOpens is how many deliveries of a message were opened.
+type MessageOpens struct {
+	MessageID int64  `json:"message_id"`
+	Topic     string `json:"topic"`
+	Delivered int    `json:"delivered"`
+	Opened    int    `json:"opened"`
+}
+
+type QueueItem struct {
+	ID          int64           `json:"id"`
+	MessageID   int64           `json:"message_id"`
+	Topic       string          `json:"topic,omitempty"`
+	Token       string          `json:"token"`
+	Provider    string          `json:"provider"`
+	Status      string          `json:"status"`
+	Payload     []byte          `json:"payload"`    // Shared by the items read with the same payload; don't modify
+	CreatedAt   time.Time       `json:"created_at"` // When the item was queued
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+	OpenedAt    *time.Time      `json:"opened_at,omitempty"` // When the device reported it opened
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"` // Error of the last failed attempt
+	Options     *WebhookOptions `json:"options,omitempty"`
+}
+
+// QueueEntry is a subscriber to enqueue a message for with EnqueueMessages.
+type QueueEntry struct {
+	Token    string
+	Provider string // Bound to the item; "" uses the subscription's provider when read
+	Payload  []byte // Replaces the stored message payload when set; stored once per distinct content
+}
+
+// payloadHash is the key a queue item payload is stored under, so identical
+// payloads of a fan-out are stored once.
+func payloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Attempt is one delivery attempt of a queue item.
+type Attempt struct {
+	QueueID     int64     `json:"queue_id"`
+	Node        string    `json:"node,omitempty"`
+	Provider    string    `json:"provider,omitempty"`
+	Error       string    `json:"error,omitempty"` // "" if the attempt succeeded
+	DurationMs  int64     `json:"duration_ms,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// ProviderStats sums up the delivery attempts through a provider.
+type ProviderStats struct {
+	Provider     string  `json:"provider"`
+	Sent         int64   `json:"sent"`    // Successful attempts
+	Failed       int64   `json:"failed"`  // Failed attempts
+	Retried      int64   `json:"retried"` // Attempts after the first of their queue item
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// StatPoint is the value of a metric over an hour.
+type StatPoint struct {
+	Bucket time.Time `json:"bucket"` // Start of the hour, in UTC
+	Metric string    `json:"metric"`
+	Value  int64     `json:"value"`
+}
+
 type Store interface {
 	// Topics
 	CreateTopic(name string) error
-	DeleteTopic(name string) error
+	// CreateOwnedTopic creates a topic owned by owner, unless owner already
+	// owns limit topics named with prefix, returning ErrLimitReached then.
+	// The count and the creation are atomic. It returns how many such
+	// topics owner had.
+	CreateOwnedTopic(name, owner, prefix string, limit int) (int, error)
+	DeleteTopic(name string) error // Also deletes its templates, schedules, drafts and aliases
 	TopicExists(name string) (bool, error)
 	ListTopics() ([]string, error)
+	// SetTopicSchema stores a JSON Schema for the topic's payloads; "" removes it.
+	SetTopicSchema(name, schema string) error
+	GetTopicSchema(name string) (string, error)
+	// SetTopicApprovalThreshold holds sends reaching at least threshold subscribers for approval; 0 disables.
+	SetTopicApprovalThreshold(name string, threshold int) error
+	GetTopicApprovalThreshold(name string) (int, error)
+	// GetTopicInfo returns a topic's metadata, or nil if there is no such topic.
+	GetTopicInfo(name string) (*TopicInfo, error)
+	// ListTopicInfo returns the metadata of every topic, ordered by name.
+	ListTopicInfo() ([]TopicInfo, error)
+	// SetTopicInfo updates a topic's description, owner and settings. The
+	// name identifies the topic; its creation time is kept.
+	SetTopicInfo(info TopicInfo) error
+	// RenameTopic renames a topic along with its subscriptions, messages,
+	// templates, filter rules, approvals, schedules, drafts and aliases. With
+	// alias, the old name becomes an alias of the new one. An alias of the
+	// topic may be taken as the new name.
+	RenameTopic(oldName, newName string, alias bool) error
+	// ResolveTopicAlias returns the topic an alias points to, or "" if name isn't an alias.
+	ResolveTopicAlias(name string) (string, error)
+	ListTopicAliases() ([]TopicAlias, error)
+	// DeleteTopicAlias removes an alias, reporting whether it existed.
+	DeleteTopicAlias(alias string) (bool, error)
+
+	// Templates
+	SaveTemplate(t Template) error                             // Inserts or replaces
+	GetTemplate(topic, name, locale string) (*Template, error) // nil if not found
+	ListTemplates(topic string) ([]Template, error)
+	DeleteTemplate(topic, name, locale string) error // locale "*" deletes every variant
+
+	// Content filtering
+	CreateFilterRule(r FilterRule) (int64, error)
+	ListFilterRules() ([]FilterRule, error)
+	DeleteFilterRule(id int64) (bool, error) // false if no such rule
+	AddModerationEntry(e ModerationEntry) error
+	ListModerationEntries(limit int) ([]ModerationEntry, error) // Newest first
+
+	// Approvals
+	HoldMessage(a Approval) error
+	ListApprovals(status string) ([]Approval, error) // "" lists every status
+	// DecideApproval moves a pending approval to status. It returns nil if the
+	// message isn't pending, so only one caller ever fans out a message.
+	DecideApproval(messageID int64, status, decidedBy string) (*Approval, error)
+
+	// Schedules
+	CreateSchedule(sc Schedule) (int64, error)      // ErrNotFound if the topic doesn't exist
+	GetSchedule(id int64) (*Schedule, error)        // nil if not found
+	ListSchedules(topic string) ([]Schedule, error) // "" lists every topic's, ordered by ID
+	// SetSchedulePaused pauses or resumes a schedule, setting its next run.
+	// It returns false if there is no such schedule.
+	SetSchedulePaused(id int64, paused bool, nextRun time.Time) (bool, error)
+	DeleteSchedule(id int64) (bool, error) // Also deletes its runs; false if no such schedule
+	// DueSchedules lists the unpaused schedules whose next run is at or
+	// before now, earliest first.
+	DueSchedules(now time.Time) ([]Schedule, error)
+	// AdvanceSchedule moves the next run of an unpaused schedule from from to
+	// to. It returns false if the next run is no longer from, so only one
+	// caller ever runs it.
+	AdvanceSchedule(id int64, from, to time.Time) (bool, error)
+	// AddScheduleRun records a run, keeping the latest MaxScheduleRuns. It
+	// returns ErrNotFound if the schedule was deleted meanwhile.
+	AddScheduleRun(r ScheduleRun) error
+	ListScheduleRuns(scheduleID int64, limit int) ([]ScheduleRun, error) // Newest first
+
+	// Drafts
+	CreateDraft(d Draft) (int64, error)       // ErrNotFound if the topic doesn't exist
+	GetDraft(id int64) (*Draft, error)        // nil if not found
+	ListDrafts(topic string) ([]Draft, error) // "" lists every topic's, ordered by ID
+	// UpdateDraft replaces the request of an unpublished draft. It returns
+	// false if there is no such draft or it is being or was published.
+	UpdateDraft(id int64, request json.RawMessage) (bool, error)
+	DeleteDraft(id int64) (bool, error) // false if no such draft
+	// SetDraftStatus moves a draft from status from to to, with the message
+	// it was published as. It returns false if the draft isn't in status
+	// from, so only one caller ever publishes a draft.
+	SetDraftStatus(id int64, from, to string, messageID int64) (bool, error)
+
+	// Audit log (append-only)
+	AddAuditEvent(e AuditEvent) error
+	ListAuditEvents(f AuditFilter) ([]AuditEvent, error) // Newest first
 
 	// Subscriptions
 	// username is now required
@@ -53,30 +458,198 @@ type Store interface {
 	RemoveSubscription(topic, token string) error
 	ClearTopicSubscribers(topic string) error
 	GetSubscribers(topic string) ([]Subscriber, error)
+	// ListSubscribersAfter returns up to limit subscribers of topic with a
+	// token after the given one, ordered by token, to page through large topics.
+	ListSubscribersAfter(topic, after string, limit int) ([]Subscriber, error)
 	GetSubscriptionsByUser(username string) ([]Subscriber, error)
 	GetSubscriptionsByToken(token string) ([]Subscriber, error)
 	GetSubscriptionCount() (int, error) // For stats
+	// CountSubscriptions counts username's subscriptions to topic; "" counts
+	// the anonymous ones, e.g. from public topic pages.
+	CountSubscriptions(topic, username string) (int, error)
+	SetSubscriptionOptions(topic, token string, opts *WebhookOptions) error
+	SetSubscriptionLocale(topic, token, locale string) error
+	// SetSubscriptionPreferences replaces the subscription's preferences;
+	// nil clears them.
+	SetSubscriptionPreferences(topic, token string, prefs *Preferences) error
+	SetSubscriptionAttributes(topic, token, platform, appVersion string, tags []string) error
+	UpdateSubscriptionTags(topic, token string, add, remove []string) ([]string, error)
+	RemoveSubscriptionsByTag(username, tag string) (int64, error)
+	// ReassignSubscriptions moves a user's subscriptions to another user, or
+	// removes them when to is "", and returns how many there were.
+	ReassignSubscriptions(from, to string) (int64, error)
+	// CountQueuedSince returns, per token, how many items were queued since
+	// the given time for the topic's messages. Tokens without any are left out.
+	CountQueuedSince(topic string, tokens []string, since time.Time) (map[string]int, error)
+	// MarkTokensSeen records a successful delivery to each token, on all of
+	// its subscriptions.
+	MarkTokensSeen(tokens []string, at time.Time) error
+	// ListStaleSubscriptions returns up to limit subscriptions neither
+	// created nor delivered to since before, least recently seen first.
+	ListStaleSubscriptions(before time.Time, limit int) ([]Subscriber, error)
+	// RemoveStaleSubscriptions removes all the subscriptions
+	// ListStaleSubscriptions would list, and returns how many there were.
+	RemoveStaleSubscriptions(before time.Time) (int64, error)
 
 	// Users
 	CreateUser(username, passwordHash, role string) error
-	DeleteUser(username string) error // New method
-	ListUsers() ([]User, error)       // New method
+	// DeleteUser, UpdateUserRole, SetUserDisabled and SetUserAccess refuse
+	// with ErrLastAdmin to remove the last admin who isn't disabled.
+	DeleteUser(username string) error
+	ListUsers() ([]User, error)
 	GetUser(username string) (*User, error)
 	HasAdminUser() (bool, error)
 	UpdateUserRole(username, role string) error
+	SetUserPassword(username, passwordHash string) error
+	SetMustChangePassword(username string, required bool) error
+	// SetUserDisabled disables or reactivates a user. GetSubscribers,
+	// ListSubscribersAfter and the pending queue skip the subscriptions of
+	// disabled users.
+	SetUserDisabled(username string, disabled bool) error
+	// SetUserAccess sets a user's role and whether they are disabled at
+	// once: either both change or neither does.
+	SetUserAccess(username, role string, disabled bool) error
+	SetUserProfile(username, displayName, email string) error
+	// LinkUser links a user to an identity provider account, unless it is
+	// already linked to one, in which case it returns false.
+	LinkUser(username, externalID string) (bool, error)
+	// IncrementUnread adds one to the unread counter of each user and returns
+	// the new counters. Unknown users are left out.
+	IncrementUnread(usernames []string) (map[string]int, error)
+	// SetUnread sets a user's unread counter, e.g. to 0 once the app is opened.
+	SetUnread(username string, count int) error
+	// SetUserTOTP stores a TOTP secret; "" removes 2FA along with recovery
+	// codes. Unless enabled, it also clears the used time step and failures.
+	SetUserTOTP(username, secret string, enabled bool) error
+	SetRecoveryCodes(username string, hashes []string) error
+	// UseRecoveryCode removes a recovery code hash, returning false if the
+	// user doesn't have it. A used code clears the failures.
+	UseRecoveryCode(username, hash string) (bool, error)
+	// UseTOTPStep records the time step of an accepted TOTP code and clears
+	// the failures, returning false if a code of that step or a later one
+	// was already used.
+	UseTOTPStep(username string, step int64) (bool, error)
+	// AddTOTPFailure counts a failed second factor attempt and returns the
+	// failures in a row.
+	AddTOTPFailure(username string) (int, error)
+
+	// Sessions
+	CreateSession(sess Session) error       // Also drops the user's expired sessions
+	GetSession(id string) (*Session, error) // nil if not found
+	TouchSession(id, ip string, at time.Time) error
+	ListSessions(username string) ([]Session, error) // Unexpired, newest first
+	DeleteSession(username, id string) (bool, error) // false if the user has no such session
+	DeleteUserSessions(username string) (int64, error)
+
+	// Invitations
+	CreateInvitation(inv Invitation) error
+	ListInvitations() ([]Invitation, error)
+	DeleteInvitation(code string) (bool, error) // false if no such invitation
+	// RedeemInvitation atomically consumes one use of a valid, unexpired
+	// invitation and creates the user with its role. It returns nil if the
+	// code is unknown, used up or expired.
+	RedeemInvitation(code, username, passwordHash string) (*Invitation, error)
+
+	// Roles
+	SaveRole(r Role) error              // Inserts or replaces
+	GetRole(name string) (*Role, error) // nil if not found
+	ListRoles() ([]Role, error)
+	DeleteRole(name string) (bool, error) // false if no such role
 
 	// Save Message
 	SaveMessage(topic string, payload []byte) (int64, error)
+	// SaveMessageFrom saves a message with its origin.
+	SaveMessageFrom(topic string, payload []byte, origin MessageOrigin) (int64, error)
+	// SaveSequencedMessage increments the topic's message sequence and saves
+	// the payload seal returns for it, in one transaction, so sequence
+	// numbers follow the saved messages without gaps. The first message of
+	// a topic gets 1.
+	SaveSequencedMessage(topic string, origin MessageOrigin, seal func(seq int64) ([]byte, error)) (int64, error)
+	// SequenceMessage numbers a saved message like SaveSequencedMessage,
+	// replacing its payload with the one seal returns, e.g. when a held
+	// message is released.
+	SequenceMessage(id int64, seal func(seq int64) ([]byte, error)) error
+	// GetMessage returns a stored message, or nil if there is none with the ID.
+	GetMessage(id int64) (*Message, error)
 	GetRecentMessages(topic string, limit int) ([]Message, error)
+	// SearchMessages returns the messages matching f, newest first.
+	SearchMessages(f MessageSearch) ([]Message, error)
 	ClearTopicMessages(topic string) error
+	// DeleteMessagesBefore deletes a topic's messages created before t, with
+	// their queue items, and returns how many messages were deleted.
+	DeleteMessagesBefore(topic string, t time.Time) (int64, error)
 
 	// Queue
 	EnqueueMessage(messageID int64, token string) (int64, error)
+	// EnqueueMessagePayload enqueues a message with a subscriber-specific payload
+	// (e.g. a localized variant) that replaces the stored message payload.
+	EnqueueMessagePayload(messageID int64, token string, payload []byte) (int64, error)
+	// EnqueueMessages enqueues a message for many subscribers at once and
+	// returns the queue IDs in the order of entries.
+	EnqueueMessages(messageID int64, entries []QueueEntry) ([]int64, error)
 	GetPendingMessages(token string) ([]QueueItem, error)
+	// GetAllPendingMessages returns the pending items whose token is still
+	// subscribed to their message's topic, once each, with the provider they
+	// were queued for and the subscription's webhook options.
 	GetAllPendingMessages() ([]QueueItem, error)
 	GetPendingMessagesByTopic(topic string) ([]QueueItem, error) // New method
+	// CountPending counts the pending queue items of a topic's messages, or
+	// of every topic if topic is "".
+	CountPending(topic string) (int64, error)
+	// GetQueueItemsByMessage lists every queue item of a message, whatever
+	// its status, oldest first. Payloads aren't included.
+	GetQueueItemsByMessage(messageID int64) ([]QueueItem, error)
+	// ListQueueOutcomes lists the delivered and failed queue items queued at
+	// or after since, without payloads, for measuring delivery latency.
+	ListQueueOutcomes(since time.Time) ([]QueueItem, error)
 	MarkDelivered(queueID int64) error
+	// MarkOpened records when a message delivered to token was first opened.
+	// It returns false if the message was never queued for token.
+	MarkOpened(messageID int64, token string) (bool, error)
+	// ListMessageOpens counts the delivered and opened items of the latest
+	// messages with deliveries, newest first.
+	ListMessageOpens(limit int) ([]MessageOpens, error)
+	// RecordAttempt logs a delivery attempt and counts it on the queue item.
+	RecordAttempt(a Attempt) error
+	// SettleAttempts records attempts like RecordAttempt and marks the items
+	// of those without an error delivered, all at once.
+	SettleAttempts(attempts []Attempt) error
+	// ListAttempts lists the attempts of a queue item of a topic's message,
+	// oldest first.
+	ListAttempts(topic string, queueID int64) ([]Attempt, error)
+	// ProviderStats sums up the attempts made since a time per provider,
+	// ordered by provider. Attempts recorded without a provider are left out.
+	ProviderStats(since time.Time) ([]ProviderStats, error)
+	// MarkFailed takes an item out of the pending queue after a delivery
+	// failure that retrying can't fix.
+	MarkFailed(queueID int64) error
+	// CancelQueueItem cancels a pending queue item of a topic's message. It
+	// reports whether one was canceled.
+	CancelQueueItem(topic string, queueID int64) (bool, error)
+	// RequeueQueueItem moves a failed or canceled queue item of a topic's
+	// message back to pending. It reports whether one was requeued.
+	RequeueQueueItem(topic string, queueID int64) (bool, error)
+	// PurgeQueue cancels every pending queue item of a topic's messages, of a
+	// token, or of both when both are set. It returns how many were canceled.
+	PurgeQueue(topic, token string) (int64, error)
+	// ClaimQueueItem atomically leases a pending item to nodeID until the lease
+	// expires. It returns false if another node holds an unexpired claim;
+	// nodeID may claim an item it holds again, so callers on the same node
+	// keep their own deliveries apart.
+	ClaimQueueItem(queueID int64, nodeID string, lease time.Duration) (bool, error)
+	// ClaimQueueItems claims items like ClaimQueueItem, all at once, and
+	// returns the IDs of those claimed.
+	ClaimQueueItems(queueIDs []int64, nodeID string, lease time.Duration) ([]int64, error)
 
 	// Stats
 	GetTotalMessagesSent() (int64, error)
+	// RecordStats adds counts to the metrics of the hour starting at bucket,
+	// and raises the metrics in peaks to their value if it is higher.
+	RecordStats(bucket time.Time, counts, peaks map[string]int64) error
+	// ListStats lists the metrics of the hours starting in [from, to), oldest
+	// first.
+	ListStats(from, to time.Time) ([]StatPoint, error)
+	// DeleteStatsBefore deletes the metrics of hours starting before t. It
+	// returns how many were deleted.
+	DeleteStatsBefore(t time.Time) (int64, error)
 }
@@ -0,0 +1,1707 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeBackends lists every Store implementation; the conformance tests
+// below run against each of them.
+var storeBackends = map[string]func(t *testing.T) Store{
+	"sqlite": func(t *testing.T) Store { return setupTestStore(t) },
+	"memory": func(t *testing.T) Store { return NewMemoryStore() },
+	"bolt": func(t *testing.T) Store {
+		s, err := NewBoltStore(filepath.Join(t.TempDir(), "test.bolt"))
+		if err != nil {
+			t.Fatalf("Failed to create bolt store: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, s Store)) {
+	for name, newStore := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			test(t, newStore(t))
+		})
+	}
+}
+
+func TestStoreTopics(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		if err := s.CreateTopic("news"); err != nil {
+			t.Fatalf("CreateTopic failed: %v", err)
+		}
+		if err := s.CreateTopic("news"); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Expected ErrDuplicate for duplicate topic, got %v", err)
+		}
+		if err := s.SetTopicSchema("missing", `{}`); err == nil {
+			t.Fatal("Expected error setting schema of missing topic")
+		}
+		if err := s.SetTopicSchema("news", `{"type":"object"}`); err != nil {
+			t.Fatalf("SetTopicSchema failed: %v", err)
+		}
+		if schema, _ := s.GetTopicSchema("news"); schema != `{"type":"object"}` {
+			t.Errorf("Unexpected schema %q", schema)
+		}
+		if err := s.SetTopicApprovalThreshold("news", 50); err != nil {
+			t.Fatalf("SetTopicApprovalThreshold failed: %v", err)
+		}
+		if n, _ := s.GetTopicApprovalThreshold("news"); n != 50 {
+			t.Errorf("Expected threshold 50, got %d", n)
+		}
+
+		info, err := s.GetTopicInfo("news")
+		if err != nil || info == nil || info.CreatedAt.IsZero() || time.Since(info.CreatedAt) > time.Minute {
+			t.Fatalf("Expected news with its creation time, got %+v, %v", info, err)
+		}
+		replay := 5
+		if err := s.SetTopicInfo(TopicInfo{Name: "news", Description: "Headlines", Owner: "alice", ReplayCount: &replay, RetentionDays: 7, Public: true,
+			MaxAttempts: 3, RetryBackoffSeconds: 30, TTLSeconds: 600}); err != nil {
+			t.Fatalf("SetTopicInfo failed: %v", err)
+		}
+		replay = 6
+		got, _ := s.GetTopicInfo("news")
+		if got.Description != "Headlines" || got.Owner != "alice" || got.ReplayCount == nil || *got.ReplayCount != 5 || got.RetentionDays != 7 || !got.Public || !got.CreatedAt.Equal(info.CreatedAt) {
+			t.Errorf("Unexpected topic info %+v", got)
+		}
+		if got.MaxAttempts != 3 || got.RetryBackoffSeconds != 30 || got.TTLSeconds != 600 {
+			t.Errorf("Unexpected delivery policy %+v", got)
+		}
+		if err := s.SetTopicInfo(TopicInfo{Name: "missing"}); err == nil {
+			t.Error("Expected error setting info of missing topic")
+		}
+		if info, _ := s.GetTopicInfo("missing"); info != nil {
+			t.Errorf("Expected nil for missing topic, got %+v", info)
+		}
+		s.CreateTopic("alerts")
+		if topics, _ := s.ListTopicInfo(); len(topics) != 2 || topics[0].Name != "alerts" || topics[1].Owner != "alice" || topics[0].ReplayCount != nil {
+			t.Errorf("Unexpected topic list %+v", topics)
+		}
+
+		s.SaveMessage("news", []byte(`{}`))
+		if n, err := s.DeleteMessagesBefore("news", time.Now().Add(-time.Hour)); err != nil || n != 0 {
+			t.Errorf("Expected no old messages deleted, got %d, %v", n, err)
+		}
+		if n, _ := s.DeleteMessagesBefore("news", time.Now().Add(time.Hour)); n != 1 {
+			t.Errorf("Expected 1 message deleted, got %d", n)
+		}
+
+		s.SaveTemplate(Template{Topic: "news", Name: "alert", Body: "{}"})
+		s.SaveTemplate(Template{Topic: "news", Name: "alert", Locale: "fr", Body: "{}"})
+		s.AddSubscription("news", "tok", "webhook", "alice")
+		if err := s.DeleteTopic("news"); err == nil {
+			t.Fatal("Expected error deleting topic with subscribers")
+		}
+		s.ClearTopicSubscribers("news")
+		if err := s.DeleteTopic("news"); err != nil {
+			t.Fatalf("DeleteTopic failed: %v", err)
+		}
+		if exists, _ := s.TopicExists("news"); exists {
+			t.Error("Topic should be deleted")
+		}
+		if templates, _ := s.ListTemplates("news"); len(templates) != 0 {
+			t.Errorf("Templates should be deleted with the topic, got %v", templates)
+		}
+	})
+}
+
+func TestStoreMessageOrigin(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		origin := MessageOrigin{Publisher: "alice", IP: "203.0.113.7", UserAgent: "curl/8.5.0"}
+		id, err := s.SaveMessageFrom("news", []byte(`{"title": "Hello"}`), origin)
+		if err != nil {
+			t.Fatalf("SaveMessageFrom failed: %v", err)
+		}
+		s.SaveMessage("news", []byte(`{}`))
+
+		if msg, _ := s.GetMessage(id); msg == nil || msg.Origin != origin {
+			t.Errorf("Expected the origin from GetMessage, got %+v", msg)
+		}
+		msgs, _ := s.GetRecentMessages("news", 10)
+		if len(msgs) != 2 || msgs[0].Origin != origin || msgs[1].Origin != (MessageOrigin{}) {
+			t.Errorf("Unexpected recent messages %+v", msgs)
+		}
+		if msgs, _ := s.SearchMessages(MessageSearch{Topic: "news", Query: "hello"}); len(msgs) != 1 || msgs[0].Origin != origin {
+			t.Errorf("Expected the origin from SearchMessages, got %+v", msgs)
+		}
+	})
+}
+
+func TestStoreSearchMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("ops")
+		s.SaveMessage("news", []byte(`{"title": "Disk full on db-1"}`))
+		s.SaveMessage("news", []byte(`{"title": "CPU at 100%"}`))
+		s.SaveMessage("ops", []byte(`{"title": "Disk full"}`))
+
+		search := func(f MessageSearch) []Message {
+			t.Helper()
+			f.Topic = "news"
+			msgs, err := s.SearchMessages(f)
+			if err != nil {
+				t.Fatalf("SearchMessages(%+v) failed: %v", f, err)
+			}
+			return msgs
+		}
+
+		if msgs := search(MessageSearch{Query: "DISK full"}); len(msgs) != 1 || msgs[0].Topic != "news" {
+			t.Errorf("Expected the news disk alert, got %+v", msgs)
+		}
+		if msgs := search(MessageSearch{Query: "disk cpu"}); len(msgs) != 0 {
+			t.Errorf("Expected every word to be required, got %+v", msgs)
+		}
+		if msgs := search(MessageSearch{Query: "100%"}); len(msgs) != 1 {
+			t.Errorf("Expected the CPU alert, got %+v", msgs)
+		}
+		if msgs := search(MessageSearch{Query: `1_0 "`}); len(msgs) != 0 {
+			t.Errorf("Expected no match for a query with wildcards, got %+v", msgs)
+		}
+		if msgs := search(MessageSearch{Limit: 1}); len(msgs) != 1 || !strings.Contains(string(msgs[0].Payload), "CPU") {
+			t.Errorf("Expected the newest message, got %+v", msgs)
+		}
+		if msgs := search(MessageSearch{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)}); len(msgs) != 2 {
+			t.Errorf("Expected 2 messages in range, got %d", len(msgs))
+		}
+		if msgs := search(MessageSearch{Query: "disk", From: time.Now().Add(time.Hour)}); len(msgs) != 0 {
+			t.Errorf("Expected no messages after the range, got %+v", msgs)
+		}
+
+		s.ClearTopicMessages("news")
+		if msgs := search(MessageSearch{Query: "disk"}); len(msgs) != 0 {
+			t.Errorf("Expected deleted messages to be unsearchable, got %+v", msgs)
+		}
+	})
+}
+
+func TestStoreMessageOpens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.AddSubscription("news", "tok-1", "webhook", "alice")
+		s.AddSubscription("news", "tok-2", "webhook", "bob")
+		s.SaveMessage("news", []byte(`{}`)) // Never delivered
+		id, _ := s.SaveMessage("news", []byte(`{}`))
+		for _, token := range []string{"tok-1", "tok-2"} {
+			q, _ := s.EnqueueMessage(id, token)
+			s.MarkDelivered(q)
+		}
+
+		for i := 0; i < 2; i++ {
+			if ok, err := s.MarkOpened(id, "tok-1"); err != nil || !ok {
+				t.Fatalf("MarkOpened = %v, %v", ok, err)
+			}
+		}
+		if ok, _ := s.MarkOpened(id, "tok-3"); ok {
+			t.Error("Expected no delivery to an unknown token")
+		}
+
+		items, _ := s.GetQueueItemsByMessage(id)
+		for _, item := range items {
+			if (item.Token == "tok-1") != (item.OpenedAt != nil) {
+				t.Errorf("Unexpected opened_at on %+v", item)
+			}
+		}
+		opens, err := s.ListMessageOpens(10)
+		if err != nil {
+			t.Fatalf("ListMessageOpens failed: %v", err)
+		}
+		if want := []MessageOpens{{MessageID: id, Topic: "news", Delivered: 2, Opened: 1}}; !reflect.DeepEqual(opens, want) {
+			t.Errorf("Expected %+v, got %+v", want, opens)
+		}
+	})
+}
+
+func TestStoreRenameTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		s.SetTopicInfo(TopicInfo{Name: "news", Description: "Headlines"})
+		s.AddSubscription("news", "tok", "webhook", "alice")
+		s.SaveTemplate(Template{Topic: "news", Name: "alert", Body: "{}"})
+		s.CreateFilterRule(FilterRule{Topic: "news", Type: "keyword", Pattern: "spam"})
+		msgID, _ := s.SaveMessage("news", []byte(`{}`))
+		s.HoldMessage(Approval{MessageID: msgID, Topic: "news", Request: []byte(`{}`)})
+
+		if err := s.RenameTopic("news", "sports", false); err == nil {
+			t.Fatal("Expected error renaming onto an existing topic")
+		}
+		if err := s.RenameTopic("missing", "other", false); err == nil {
+			t.Fatal("Expected error renaming a missing topic")
+		}
+		if err := s.RenameTopic("news", "headlines", true); err != nil {
+			t.Fatalf("RenameTopic failed: %v", err)
+		}
+
+		if exists, _ := s.TopicExists("news"); exists {
+			t.Error("Old name should be gone")
+		}
+		if info, _ := s.GetTopicInfo("headlines"); info == nil || info.Description != "Headlines" {
+			t.Errorf("Expected metadata to follow the topic, got %+v", info)
+		}
+		if subs, _ := s.GetSubscribers("headlines"); len(subs) != 1 || subs[0].Topic != "headlines" || subs[0].Username != "alice" {
+			t.Errorf("Expected the subscription to move, got %+v", subs)
+		}
+		if tmpl, _ := s.GetTemplate("headlines", "alert", ""); tmpl == nil || tmpl.Topic != "headlines" {
+			t.Errorf("Expected the template to move, got %+v", tmpl)
+		}
+		if rules, _ := s.ListFilterRules(); len(rules) != 1 || rules[0].Topic != "headlines" {
+			t.Errorf("Expected the filter rule to move, got %+v", rules)
+		}
+		if msg, _ := s.GetMessage(msgID); msg == nil || msg.Topic != "headlines" {
+			t.Errorf("Expected the message to move, got %+v", msg)
+		}
+		if approvals, _ := s.ListApprovals(""); len(approvals) != 1 || approvals[0].Topic != "headlines" || string(approvals[0].Request) != `{}` {
+			t.Errorf("Expected the approval to move, got %+v", approvals)
+		}
+		if topic, _ := s.ResolveTopicAlias("news"); topic != "headlines" {
+			t.Errorf("Expected news to alias headlines, got %q", topic)
+		}
+
+		// Renaming again keeps older aliases pointing at the topic, and the
+		// topic may take back an alias of its own
+		if err := s.RenameTopic("headlines", "news", false); err != nil {
+			t.Fatalf("RenameTopic back failed: %v", err)
+		}
+		if aliases, _ := s.ListTopicAliases(); len(aliases) != 0 {
+			t.Errorf("Expected the alias to be taken back, got %+v", aliases)
+		}
+		s.RenameTopic("news", "headlines", true)
+		s.RenameTopic("headlines", "top", true)
+		if aliases, _ := s.ListTopicAliases(); !reflect.DeepEqual(aliases, []TopicAlias{{"headlines", "top"}, {"news", "top"}}) {
+			t.Errorf("Unexpected aliases %+v", aliases)
+		}
+		if err := s.RenameTopic("sports", "news", false); err == nil {
+			t.Error("Expected error renaming onto another topic's alias")
+		}
+		if ok, _ := s.DeleteTopicAlias("headlines"); !ok {
+			t.Error("Expected the alias to be deleted")
+		}
+		if topic, _ := s.ResolveTopicAlias("headlines"); topic != "" {
+			t.Errorf("Expected no alias, got %q", topic)
+		}
+	})
+}
+
+func TestStoreTemplates(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.SaveTemplate(Template{Topic: "news", Name: "b", Body: "1"})
+		s.SaveTemplate(Template{Topic: "news", Name: "a", Locale: "fr", Body: "2"})
+		s.SaveTemplate(Template{Topic: "news", Name: "a", Body: "3"})
+		s.SaveTemplate(Template{Topic: "news", Name: "a", Body: "4"}) // Replaces
+
+		templates, err := s.ListTemplates("news")
+		if err != nil {
+			t.Fatalf("ListTemplates failed: %v", err)
+		}
+		want := []Template{
+			{Topic: "news", Name: "a", Body: "4"},
+			{Topic: "news", Name: "a", Locale: "fr", Body: "2"},
+			{Topic: "news", Name: "b", Body: "1"},
+		}
+		if !reflect.DeepEqual(templates, want) {
+			t.Errorf("Expected %v, got %v", want, templates)
+		}
+
+		if tmpl, _ := s.GetTemplate("news", "a", "de"); tmpl != nil {
+			t.Errorf("Expected nil for missing template, got %v", tmpl)
+		}
+		s.DeleteTemplate("news", "a", "*")
+		if templates, _ := s.ListTemplates("news"); len(templates) != 1 || templates[0].Name != "b" {
+			t.Errorf("Expected only template b to remain, got %v", templates)
+		}
+	})
+}
+
+func TestStoreSubscriptions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		if err := s.AddSubscription("missing", "tok", "webhook", "alice"); err == nil {
+			t.Fatal("Expected error subscribing to a missing topic")
+		}
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		if err := s.AddSubscription("news", "tok", "webhook", "alice"); err != nil {
+			t.Fatalf("AddSubscription failed: %v", err)
+		}
+		if err := s.AddSubscription("news", "tok", "webhook", "alice"); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Expected ErrDuplicate for duplicate subscription, got %v", err)
+		}
+		s.AddSubscription("sports", "tok", "webhook", "alice")
+		s.AddSubscription("sports", "other", "fcm", "bob")
+
+		opts := &WebhookOptions{Method: "PUT", Headers: map[string]string{"X-Key": "1"}}
+		s.SetSubscriptionOptions("news", "tok", opts)
+		opts.Headers["X-Key"] = "changed"
+		s.SetSubscriptionLocale("news", "tok", "fr")
+		s.SetSubscriptionAttributes("news", "tok", "ios", "2.0.1", []string{"beta"})
+
+		subs, _ := s.GetSubscribers("news")
+		if len(subs) != 1 {
+			t.Fatalf("Expected 1 subscriber, got %d", len(subs))
+		}
+		sub := subs[0]
+		if sub.Options == nil || sub.Options.Method != "PUT" || sub.Options.Headers["X-Key"] != "1" {
+			t.Errorf("Unexpected options %+v", sub.Options)
+		}
+		if sub.Locale != "fr" || sub.Platform != "ios" || sub.AppVersion != "2.0.1" || !reflect.DeepEqual(sub.Tags, []string{"beta"}) {
+			t.Errorf("Unexpected subscriber %+v", sub)
+		}
+
+		tags, err := s.UpdateSubscriptionTags("news", "tok", []string{"vip", "beta"}, []string{"beta"})
+		if err != nil || !reflect.DeepEqual(tags, []string{"vip"}) {
+			t.Errorf("UpdateSubscriptionTags = %v, %v", tags, err)
+		}
+		if _, err := s.UpdateSubscriptionTags("news", "missing", nil, nil); err == nil {
+			t.Error("Expected error updating tags of a missing subscription")
+		}
+
+		if subs, _ := s.GetSubscriptionsByUser("alice"); len(subs) != 2 {
+			t.Errorf("Expected 2 subscriptions for alice, got %d", len(subs))
+		}
+		if subs, _ := s.GetSubscriptionsByToken("tok"); len(subs) != 2 {
+			t.Errorf("Expected 2 subscriptions for tok, got %d", len(subs))
+		}
+		var paged []string
+		for after := ""; ; {
+			page, err := s.ListSubscribersAfter("sports", after, 1)
+			if err != nil {
+				t.Fatalf("ListSubscribersAfter failed: %v", err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			after = page[0].Token
+			paged = append(paged, after)
+		}
+		if !reflect.DeepEqual(paged, []string{"other", "tok"}) {
+			t.Errorf("Expected sports subscribers in token order, got %v", paged)
+		}
+		if n, _ := s.RemoveSubscriptionsByTag("alice", "vip"); n != 1 {
+			t.Errorf("Expected 1 subscription removed by tag, got %d", n)
+		}
+		s.RemoveSubscription("sports", "other")
+		if n, _ := s.GetSubscriptionCount(); n != 1 {
+			t.Errorf("Expected 1 subscription left, got %d", n)
+		}
+	})
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		if err := s.CreateUser("alice", "hash", "admin"); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+		if err := s.CreateUser("alice", "hash", "admin"); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Expected ErrDuplicate for duplicate user, got %v", err)
+		}
+		if ok, _ := s.HasAdminUser(); !ok {
+			t.Error("Expected an admin user")
+		}
+		if err := s.UpdateUserRole("alice", "publisher"); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("Expected ErrLastAdmin demoting the only admin, got %v", err)
+		}
+		s.CreateUser("bob", "hash", "publisher")
+		s.UpdateUserRole("bob", "admin")
+		s.UpdateUserRole("alice", "publisher")
+		s.UpdateUserRole("bob", "publisher")
+		if ok, _ := s.HasAdminUser(); !ok {
+			t.Error("Expected bob to stay the last admin")
+		}
+
+		if err := s.SetUserPassword("missing", "hash"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound setting the password of a missing user, got %v", err)
+		}
+		if err := s.SetUserPassword("alice", "new-hash"); err != nil {
+			t.Fatalf("SetUserPassword failed: %v", err)
+		}
+		if u, _ := s.GetUser("alice"); u.PasswordHash != "new-hash" {
+			t.Errorf("Expected updated hash, got %q", u.PasswordHash)
+		}
+		if u, _ := s.GetUser("alice"); u.MustChangePassword {
+			t.Error("Users don't need to change their password by default")
+		}
+		if err := s.SetMustChangePassword("missing", true); err == nil {
+			t.Error("Expected error for a missing user")
+		}
+		s.SetMustChangePassword("alice", true)
+		if u, _ := s.GetUser("alice"); !u.MustChangePassword {
+			t.Error("Expected a password change to be required")
+		}
+
+		if err := s.SetUserTOTP("missing", "secret", true); err == nil {
+			t.Error("Expected error enabling TOTP for a missing user")
+		}
+		s.SetUserTOTP("alice", "secret", true)
+		s.SetRecoveryCodes("alice", []string{"h1", "h2"})
+		if ok, _ := s.UseRecoveryCode("alice", "h1"); !ok {
+			t.Error("Expected recovery code h1 to be accepted")
+		}
+		if ok, _ := s.UseRecoveryCode("alice", "h1"); ok {
+			t.Error("Recovery code h1 should only work once")
+		}
+		u, _ := s.GetUser("alice")
+		if u == nil || u.Role != "publisher" || u.TOTPSecret != "secret" || !u.TOTPEnabled {
+			t.Errorf("Unexpected user %+v", u)
+		}
+		s.SetUserTOTP("alice", "", false)
+		if ok, _ := s.UseRecoveryCode("alice", "h2"); ok {
+			t.Error("Disabling TOTP should remove recovery codes")
+		}
+
+		if err := s.DeleteUser("alice"); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+		if err := s.DeleteUser("alice"); err == nil {
+			t.Error("Expected error deleting a missing user")
+		}
+		if u, _ := s.GetUser("alice"); u != nil {
+			t.Errorf("Expected nil for deleted user, got %+v", u)
+		}
+	})
+}
+
+func TestStoreInvitationsAndRoles(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		past := time.Now().Add(-time.Hour)
+		s.CreateInvitation(Invitation{Code: "once", Role: "subscriber", MaxUses: 1, CreatedBy: "admin"})
+		s.CreateInvitation(Invitation{Code: "expired", Role: "subscriber", MaxUses: 5, ExpiresAt: &past, CreatedBy: "admin"})
+
+		inv, err := s.RedeemInvitation("once", "alice", "hash")
+		if err != nil || inv == nil || inv.Role != "subscriber" || inv.Uses != 1 {
+			t.Fatalf("RedeemInvitation = %+v, %v", inv, err)
+		}
+		if inv, _ := s.RedeemInvitation("once", "bob", "hash"); inv != nil {
+			t.Error("Used-up invitation should not redeem")
+		}
+		if inv, _ := s.RedeemInvitation("expired", "bob", "hash"); inv != nil {
+			t.Error("Expired invitation should not redeem")
+		}
+		if u, _ := s.GetUser("alice"); u == nil || u.Role != "subscriber" {
+			t.Errorf("Expected alice to be created as subscriber, got %+v", u)
+		}
+		if invs, _ := s.ListInvitations(); len(invs) != 2 {
+			t.Errorf("Expected 2 invitations, got %d", len(invs))
+		}
+		if ok, _ := s.DeleteInvitation("once"); !ok {
+			t.Error("Expected invitation to be deleted")
+		}
+
+		s.SaveRole(Role{Name: "support", Permissions: []string{"users.view"}})
+		s.SaveRole(Role{Name: "auditor", Permissions: []string{"audit.view"}})
+		roles, _ := s.ListRoles()
+		if len(roles) != 2 || roles[0].Name != "auditor" {
+			t.Errorf("Expected roles sorted by name, got %v", roles)
+		}
+		if ok, _ := s.DeleteRole("support"); !ok {
+			t.Error("Expected role to be deleted")
+		}
+		if r, _ := s.GetRole("support"); r != nil {
+			t.Errorf("Expected nil for deleted role, got %v", r)
+		}
+	})
+}
+
+func TestStoreMessagesAndQueue(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.AddSubscription("news", "tok", "webhook", "alice")
+		s.SetSubscriptionOptions("news", "tok", &WebhookOptions{Method: "PUT"})
+
+		var ids []int64
+		for _, p := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+			id, err := s.SaveMessage("news", []byte(p))
+			if err != nil {
+				t.Fatalf("SaveMessage failed: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		msgs, _ := s.GetRecentMessages("news", 2)
+		if len(msgs) != 2 || string(msgs[0].Payload) != `{"n":2}` || string(msgs[1].Payload) != `{"n":3}` {
+			t.Errorf("Expected the 2 newest messages oldest first, got %v", msgs)
+		}
+
+		q1, _ := s.EnqueueMessage(ids[0], "tok")
+		s.EnqueueMessagePayload(ids[1], "tok", []byte(`{"localized":true}`))
+		s.EnqueueMessage(ids[2], "unsubscribed")
+
+		items, _ := s.GetPendingMessages("tok")
+		if len(items) != 2 || string(items[1].Payload) != `{"localized":true}` {
+			t.Errorf("Unexpected pending items %v", items)
+		}
+		all, _ := s.GetAllPendingMessages()
+		if len(all) != 2 {
+			t.Fatalf("Expected 2 pending items with a subscription, got %d", len(all))
+		}
+		if all[0].Provider != "webhook" || all[0].Options == nil || all[0].Options.Method != "PUT" {
+			t.Errorf("Expected subscription details on queue item, got %+v", all[0])
+		}
+
+		if ok, _ := s.ClaimQueueItem(q1, "node-a", time.Minute); !ok {
+			t.Error("Expected node-a to claim the item")
+		}
+		if ok, _ := s.ClaimQueueItem(q1, "node-b", time.Minute); ok {
+			t.Error("node-b should not claim an item leased to node-a")
+		}
+		if ok, _ := s.ClaimQueueItem(q1, "node-a", time.Minute); !ok {
+			t.Error("node-a should be able to renew its own claim")
+		}
+		s.MarkDelivered(q1)
+		if items, _ := s.GetPendingMessagesByTopic("news"); len(items) != 1 {
+			t.Errorf("Expected 1 pending item after delivery, got %d", len(items))
+		}
+		if ok, _ := s.ClaimQueueItem(q1, "node-a", time.Minute); ok {
+			t.Error("Delivered items should not be claimable")
+		}
+		if err := s.MarkFailed(all[1].ID); err != nil {
+			t.Fatalf("MarkFailed failed: %v", err)
+		}
+		if items, _ := s.GetPendingMessages("tok"); len(items) != 0 {
+			t.Errorf("Expected no pending items after a failure, got %v", items)
+		}
+		if ok, _ := s.ClaimQueueItem(all[1].ID, "node-a", time.Minute); ok {
+			t.Error("Failed items should not be claimable")
+		}
+		for i, want := range []string{"delivered", "failed", "pending"} {
+			items, err := s.GetQueueItemsByMessage(ids[i])
+			if err != nil || len(items) != 1 || items[0].Status != want || items[0].Token == "" {
+				t.Errorf("Expected one %s item for message %d, got %+v (%v)", want, ids[i], items, err)
+			}
+		}
+
+		if m, err := s.GetMessage(ids[0]); err != nil || m == nil || m.Topic != "news" || m.ID != ids[0] {
+			t.Errorf("Unexpected message %+v (%v)", m, err)
+		}
+		if m, err := s.GetMessage(9999); err != nil || m != nil {
+			t.Errorf("Expected no message for an unknown ID, got %+v (%v)", m, err)
+		}
+
+		if n, _ := s.GetTotalMessagesSent(); n != 3 {
+			t.Errorf("Expected 3 messages, got %d", n)
+		}
+		if err := s.ClearTopicMessages("news"); err != nil {
+			t.Fatalf("ClearTopicMessages failed: %v", err)
+		}
+		if items, _ := s.GetPendingMessages("tok"); len(items) != 0 {
+			t.Errorf("Expected queue to be cleared with messages, got %v", items)
+		}
+	})
+}
+
+func TestStoreQueueManagement(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("ops")
+		s.AddSubscription("news", "tok-a", "fcm", "alice")
+		s.AddSubscription("news", "tok-b", "fcm", "bob")
+		s.AddSubscription("ops", "tok-a", "fcm", "alice")
+		news, _ := s.SaveMessage("news", []byte(`{}`))
+		ops, _ := s.SaveMessage("ops", []byte(`{}`))
+		a, _ := s.EnqueueMessage(news, "tok-a")
+		b, _ := s.EnqueueMessage(news, "tok-b")
+		c, _ := s.EnqueueMessage(ops, "tok-a")
+
+		if n, err := s.CountPending(""); err != nil || n != 3 {
+			t.Errorf("Expected 3 pending items, got %d (%v)", n, err)
+		}
+		if n, _ := s.CountPending("news"); n != 2 {
+			t.Errorf("Expected 2 pending items on news, got %d", n)
+		}
+
+		if ok, err := s.CancelQueueItem("ops", a); err != nil || ok {
+			t.Errorf("Expected no cancel through the wrong topic, got %v (%v)", ok, err)
+		}
+		if ok, err := s.CancelQueueItem("news", a); err != nil || !ok {
+			t.Fatalf("Expected the item to be canceled, got %v (%v)", ok, err)
+		}
+		if ok, _ := s.CancelQueueItem("news", a); ok {
+			t.Error("A canceled item should not be canceled again")
+		}
+		if ok, _ := s.ClaimQueueItem(a, "node-a", time.Minute); ok {
+			t.Error("Canceled items should not be claimable")
+		}
+		if items, _ := s.GetPendingMessagesByTopic("news"); len(items) != 1 || items[0].ID != b {
+			t.Errorf("Expected only %d pending, got %+v", b, items)
+		}
+
+		s.MarkFailed(b)
+		if ok, err := s.RequeueQueueItem("news", b); err != nil || !ok {
+			t.Fatalf("Expected the failed item to be requeued, got %v (%v)", ok, err)
+		}
+		if ok, _ := s.RequeueQueueItem("news", b); ok {
+			t.Error("A pending item should not be requeued")
+		}
+		if ok, _ := s.ClaimQueueItem(b, "node-a", time.Minute); !ok {
+			t.Error("A requeued item should be claimable")
+		}
+
+		if _, err := s.PurgeQueue("", ""); err == nil {
+			t.Error("Expected an error purging without topic or token")
+		}
+		if n, err := s.PurgeQueue("", "tok-a"); err != nil || n != 1 {
+			t.Errorf("Expected 1 item of tok-a purged, got %d (%v)", n, err)
+		}
+		if n, err := s.PurgeQueue("news", ""); err != nil || n != 1 {
+			t.Errorf("Expected 1 item of news purged, got %d (%v)", n, err)
+		}
+		if items, _ := s.GetAllPendingMessages(); len(items) != 0 {
+			t.Errorf("Expected an empty queue, got %+v", items)
+		}
+		if n, _ := s.CountPending(""); n != 0 {
+			t.Errorf("Expected no pending items, got %d", n)
+		}
+		if items, _ := s.GetQueueItemsByMessage(ops); len(items) != 1 || items[0].ID != c || items[0].Status != "canceled" {
+			t.Errorf("Expected %d canceled, got %+v", c, items)
+		}
+	})
+}
+
+func TestStoreAttempts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.AddSubscription("news", "tok", "fcm", "alice")
+		id, _ := s.SaveMessage("news", []byte(`{}`))
+		qid, _ := s.EnqueueMessage(id, "tok")
+
+		start := time.Now().Add(-time.Minute)
+		s.RecordAttempt(Attempt{QueueID: qid, Node: "node-a", Error: "timeout", AttemptedAt: start})
+		if items, _ := s.GetAllPendingMessages(); len(items) != 1 || items[0].Attempts != 1 || items[0].LastError != "timeout" || items[0].CreatedAt.IsZero() || items[0].Topic != "news" {
+			t.Errorf("Unexpected pending item %+v", items)
+		}
+		s.RecordAttempt(Attempt{QueueID: qid, Node: "node-b", AttemptedAt: start.Add(time.Second)})
+		s.MarkDelivered(qid)
+
+		items, err := s.GetQueueItemsByMessage(id)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("Expected one item, got %+v (%v)", items, err)
+		}
+		if it := items[0]; it.Attempts != 2 || it.LastError != "timeout" || it.DeliveredAt == nil || it.CreatedAt.IsZero() {
+			t.Errorf("Unexpected delivered item %+v", it)
+		}
+
+		attempts, err := s.ListAttempts("news", qid)
+		if err != nil || len(attempts) != 2 {
+			t.Fatalf("Expected 2 attempts, got %+v (%v)", attempts, err)
+		}
+		if attempts[0].Node != "node-a" || attempts[0].Error != "timeout" || attempts[1].Error != "" || !attempts[0].AttemptedAt.Equal(start) {
+			t.Errorf("Unexpected attempts %+v", attempts)
+		}
+		if attempts, _ := s.ListAttempts("ops", qid); len(attempts) != 0 {
+			t.Errorf("Expected no attempts through the wrong topic, got %+v", attempts)
+		}
+
+		s.ClearTopicMessages("news")
+		if attempts, _ := s.ListAttempts("news", qid); len(attempts) != 0 {
+			t.Errorf("Expected attempts to be cleared with messages, got %+v", attempts)
+		}
+	})
+}
+
+func TestStoreProviderStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		id, _ := s.SaveMessage("news", []byte(`{}`))
+		q1, _ := s.EnqueueMessage(id, "tok-1")
+		q2, _ := s.EnqueueMessage(id, "tok-2")
+		q3, _ := s.EnqueueMessage(id, "tok-3")
+
+		now := time.Now()
+		s.RecordAttempt(Attempt{QueueID: q1, Provider: "webhook", Error: "timeout", DurationMs: 300, AttemptedAt: now.Add(-2 * time.Hour)})
+		s.RecordAttempt(Attempt{QueueID: q1, Provider: "webhook", DurationMs: 100, AttemptedAt: now})
+		s.RecordAttempt(Attempt{QueueID: q2, Provider: "fcm", DurationMs: 40, AttemptedAt: now})
+		s.RecordAttempt(Attempt{QueueID: q3, Provider: "fcm", Error: "unregistered", DurationMs: 20, AttemptedAt: now})
+		s.RecordAttempt(Attempt{QueueID: q3, Error: "legacy", AttemptedAt: now})
+
+		if attempts, _ := s.ListAttempts("news", q1); len(attempts) != 2 || attempts[0].Provider != "webhook" || attempts[0].DurationMs != 300 {
+			t.Errorf("Expected the provider and duration of attempts, got %+v", attempts)
+		}
+
+		stats, err := s.ProviderStats(now.Add(-3 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []ProviderStats{
+			{Provider: "fcm", Sent: 1, Failed: 1, AvgLatencyMs: 30},
+			{Provider: "webhook", Sent: 1, Failed: 1, Retried: 1, AvgLatencyMs: 200},
+		}
+		if !reflect.DeepEqual(stats, want) {
+			t.Errorf("Expected %+v, got %+v", want, stats)
+		}
+
+		stats, _ = s.ProviderStats(now.Add(-time.Hour))
+		if len(stats) != 2 || stats[1] != (ProviderStats{Provider: "webhook", Sent: 1, Retried: 1, AvgLatencyMs: 100}) {
+			t.Errorf("Expected the retry counted without the older attempt, got %+v", stats)
+		}
+	})
+}
+
+func TestStoreQueueOutcomes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		id, _ := s.SaveMessage("news", []byte(`{}`))
+		delivered, _ := s.EnqueueMessage(id, "tok-1")
+		failed, _ := s.EnqueueMessage(id, "tok-2")
+		s.EnqueueMessage(id, "tok-3")
+		s.MarkDelivered(delivered)
+		s.MarkFailed(failed)
+
+		items, err := s.ListQueueOutcomes(time.Now().Add(-time.Minute))
+		if err != nil || len(items) != 2 {
+			t.Fatalf("Expected the delivered and failed items, got %+v (%v)", items, err)
+		}
+		if it := items[0]; it.ID != delivered || it.Topic != "news" || it.DeliveredAt == nil || it.DeliveredAt.Before(it.CreatedAt) {
+			t.Errorf("Unexpected delivered item %+v", it)
+		}
+		if it := items[1]; it.ID != failed || it.Status != "failed" || it.DeliveredAt != nil {
+			t.Errorf("Unexpected failed item %+v", it)
+		}
+		if items, _ := s.ListQueueOutcomes(time.Now().Add(time.Minute)); len(items) != 0 {
+			t.Errorf("Expected no items queued in the future, got %+v", items)
+		}
+	})
+}
+
+func TestStoreBulkQueue(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		for _, token := range []string{"tok-1", "tok-2", "tok-3"} {
+			s.AddSubscription("news", token, "mock", "reader")
+		}
+		id, _ := s.SaveMessage("news", []byte(`{"a":1}`))
+		ids, err := s.EnqueueMessages(id, []QueueEntry{{Token: "tok-1"}, {Token: "tok-2", Payload: []byte(`{"a":2}`)}, {Token: "tok-3"}})
+		if err != nil || len(ids) != 3 || ids[0] >= ids[1] || ids[1] >= ids[2] {
+			t.Fatalf("Expected 3 increasing queue IDs, got %v (%v)", ids, err)
+		}
+		pending, _ := s.GetAllPendingMessages()
+		if len(pending) != 3 || string(pending[0].Payload) != `{"a":1}` || string(pending[1].Payload) != `{"a":2}` {
+			t.Fatalf("Unexpected pending items %+v", pending)
+		}
+		if _, err := s.EnqueueMessages(id+100, []QueueEntry{{Token: "tok-1"}}); err == nil {
+			t.Error("Expected an error enqueueing a missing message")
+		}
+
+		if ok, _ := s.ClaimQueueItem(ids[2], "node-b", time.Minute); !ok {
+			t.Fatal("Expected node-b to claim the third item")
+		}
+		claimed, err := s.ClaimQueueItems(ids, "node-a", time.Minute)
+		if err != nil || !reflect.DeepEqual(claimed, ids[:2]) {
+			t.Fatalf("Expected node-a to claim %v, got %v (%v)", ids[:2], claimed, err)
+		}
+
+		now := time.Now()
+		err = s.SettleAttempts([]Attempt{
+			{QueueID: ids[0], Node: "node-a", Provider: "mock", AttemptedAt: now},
+			{QueueID: ids[1], Node: "node-a", Provider: "mock", Error: "boom", AttemptedAt: now},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, _ = s.GetAllPendingMessages()
+		if len(pending) != 2 || pending[0].ID != ids[1] || pending[0].Attempts != 1 || pending[0].LastError != "boom" {
+			t.Fatalf("Expected the failed and unattempted items pending, got %+v", pending)
+		}
+		if attempts, _ := s.ListAttempts("news", ids[0]); len(attempts) != 1 || attempts[0].Error != "" {
+			t.Errorf("Expected one successful attempt, got %+v", attempts)
+		}
+		if items, _ := s.ListQueueOutcomes(now.Add(-time.Minute)); len(items) != 1 || items[0].ID != ids[0] || items[0].DeliveredAt == nil {
+			t.Errorf("Expected the first item delivered, got %+v", items)
+		}
+	})
+}
+
+func TestStorePendingProvider(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		s.AddSubscription("news", "shared", "fcm", "reader")
+		s.AddSubscription("sports", "shared", "webhook", "reader")
+		s.SetSubscriptionOptions("sports", "shared", &WebhookOptions{Method: "PUT"})
+		s.AddSubscription("sports", "gone", "webhook", "reader")
+
+		news, _ := s.SaveMessage("news", []byte(`{}`))
+		sports, _ := s.SaveMessage("sports", []byte(`{}`))
+		bound, _ := s.EnqueueMessages(news, []QueueEntry{{Token: "shared", Provider: "fcm"}, {Token: "gone", Provider: "webhook"}})
+		legacy, _ := s.EnqueueMessage(sports, "shared")
+
+		pending, err := s.GetAllPendingMessages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 2 {
+			t.Fatalf("Expected each item once, without the unsubscribed one, got %+v", pending)
+		}
+		if p := pending[0]; p.ID != bound[0] || p.Provider != "fcm" || p.Options != nil {
+			t.Errorf("Expected the news item through fcm without options, got %+v", p)
+		}
+		if p := pending[1]; p.ID != legacy || p.Provider != "webhook" || p.Options == nil || p.Options.Method != "PUT" {
+			t.Errorf("Expected the sports item through its subscription's webhook, got %+v", p)
+		}
+		if byTopic, _ := s.GetPendingMessagesByTopic("news"); len(byTopic) != 1 || byTopic[0].ID != bound[0] {
+			t.Errorf("Expected only the news item, got %+v", byTopic)
+		}
+	})
+}
+
+func TestStoreDisabledUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateUser("alice", "hash", "subscriber")
+		s.CreateUser("bob", "hash", "subscriber")
+		s.AddSubscription("news", "a", "webhook", "alice")
+		s.AddSubscription("news", "b", "webhook", "bob")
+		s.AddSubscription("news", "anon", "webhook", "")
+		id, _ := s.SaveMessage("news", []byte(`{}`))
+		s.EnqueueMessages(id, []QueueEntry{{Token: "a"}, {Token: "b"}, {Token: "anon"}})
+
+		if err := s.SetUserDisabled("alice", true); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetUserDisabled("nobody", true); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+		}
+		if u, _ := s.GetUser("alice"); u == nil || !u.Disabled {
+			t.Errorf("Expected alice disabled, got %+v", u)
+		}
+		if users, _ := s.ListUsers(); len(users) != 2 || users[0].Disabled == users[1].Disabled {
+			t.Errorf("Expected one disabled user in the list, got %+v", users)
+		}
+
+		tokens := func(subs []Subscriber) string {
+			var out []string
+			for _, sub := range subs {
+				out = append(out, sub.Token)
+			}
+			slices.Sort(out)
+			return strings.Join(out, ",")
+		}
+		if subs, _ := s.GetSubscribers("news"); tokens(subs) != "anon,b" {
+			t.Errorf("Expected alice's subscription paused, got %v", tokens(subs))
+		}
+		if subs, _ := s.ListSubscribersAfter("news", "", 10); tokens(subs) != "anon,b" {
+			t.Errorf("Expected alice's subscription paused when paging, got %v", tokens(subs))
+		}
+		if subs, _ := s.GetSubscriptionsByUser("alice"); len(subs) != 1 {
+			t.Errorf("Expected alice to still see her subscription, got %v", subs)
+		}
+		if pending, _ := s.GetAllPendingMessages(); len(pending) != 2 {
+			t.Errorf("Expected alice's queue item held back, got %+v", pending)
+		}
+
+		s.SetUserDisabled("alice", false)
+		if pending, _ := s.GetPendingMessagesByTopic("news"); len(pending) != 3 {
+			t.Errorf("Expected alice's queue item after reactivation, got %+v", pending)
+		}
+
+		if n, err := s.ReassignSubscriptions("alice", "bob"); err != nil || n != 1 {
+			t.Fatalf("ReassignSubscriptions = %d, %v", n, err)
+		}
+		if subs, _ := s.GetSubscriptionsByUser("bob"); tokens(subs) != "a,b" {
+			t.Errorf("Expected bob to own both subscriptions, got %v", tokens(subs))
+		}
+		if n, _ := s.ReassignSubscriptions("bob", ""); n != 2 {
+			t.Errorf("Expected both of bob's subscriptions removed, got %d", n)
+		}
+		if subs, _ := s.GetSubscribers("news"); tokens(subs) != "anon" {
+			t.Errorf("Expected only the anonymous subscription left, got %v", tokens(subs))
+		}
+	})
+}
+
+func TestStoreLastAdmin(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateUser("root", "hash", "admin")
+		s.CreateUser("ops", "hash", "admin")
+		s.CreateUser("bob", "hash", "publisher")
+
+		if err := s.SetUserDisabled("ops", true); err != nil {
+			t.Fatalf("Expected disabling one of two admins to work, got %v", err)
+		}
+		for name, err := range map[string]error{
+			"delete":  s.DeleteUser("root"),
+			"demote":  s.UpdateUserRole("root", "subscriber"),
+			"disable": s.SetUserDisabled("root", true),
+		} {
+			if !errors.Is(err, ErrLastAdmin) {
+				t.Errorf("Expected ErrLastAdmin to %s the last enabled admin, got %v", name, err)
+			}
+		}
+		if u, _ := s.GetUser("root"); u == nil || u.Role != "admin" || u.Disabled {
+			t.Errorf("Expected root untouched, got %+v", u)
+		}
+		if err := s.DeleteUser("ops"); err != nil {
+			t.Errorf("Expected a disabled admin to be deletable, got %v", err)
+		}
+		if err := s.DeleteUser("bob"); err != nil {
+			t.Errorf("Expected other users unaffected, got %v", err)
+		}
+		if err := s.UpdateUserRole("root", "admin"); err != nil {
+			t.Errorf("Expected keeping the admin role to work, got %v", err)
+		}
+
+		// Access changes apply together or not at all
+		if err := s.SetUserAccess("root", "publisher", false); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("Expected ErrLastAdmin demoting the last enabled admin, got %v", err)
+		}
+		if u, _ := s.GetUser("root"); u == nil || u.Role != "admin" || u.Disabled {
+			t.Errorf("Expected root untouched, got %+v", u)
+		}
+		s.CreateUser("eve", "hash", "subscriber")
+		if err := s.SetUserAccess("eve", "admin", false); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetUserAccess("root", "publisher", true); err != nil {
+			t.Errorf("Expected root demoted and disabled once eve is admin, got %v", err)
+		}
+		if u, _ := s.GetUser("root"); u == nil || u.Role != "publisher" || !u.Disabled {
+			t.Errorf("Expected root demoted and disabled, got %+v", u)
+		}
+		if err := s.SetUserAccess("nobody", "admin", false); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+		}
+	})
+}
+
+func TestStoreUserProfile(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateUser("alice", "hash", "subscriber")
+		if err := s.SetUserProfile("alice", "Alice", "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if u, _ := s.GetUser("alice"); u.DisplayName != "Alice" || u.Email != "alice@example.com" {
+			t.Errorf("Expected the profile stored, got %+v", u)
+		}
+		if err := s.SetUserProfile("nobody", "", ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+		}
+	})
+}
+
+func TestStoreCountSubscriptions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("news-archive")
+		s.AddSubscription("news", "t1", "webpush", "")
+		s.AddSubscription("news", "t2", "webpush", "")
+		s.AddSubscription("news", "t3", "fcm", "alice")
+		s.AddSubscription("news-archive", "t4", "webpush", "")
+
+		if n, err := s.CountSubscriptions("news", ""); err != nil || n != 2 {
+			t.Errorf("Expected 2 anonymous subscriptions, got %d (%v)", n, err)
+		}
+		if n, _ := s.CountSubscriptions("news", "alice"); n != 1 {
+			t.Errorf("Expected 1 subscription of alice, got %d", n)
+		}
+		if n, _ := s.CountSubscriptions("other", ""); n != 0 {
+			t.Errorf("Expected none for an unknown topic, got %d", n)
+		}
+	})
+}
+
+func TestStoreLinkUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateUser("alice", "!", "subscriber")
+		if ok, err := s.LinkUser("alice", "https://idp.example.com 1"); err != nil || !ok {
+			t.Fatalf("Expected the user linked, got %v, %v", ok, err)
+		}
+		if ok, err := s.LinkUser("alice", "https://idp.example.com 2"); err != nil || ok {
+			t.Errorf("Expected a linked user not to be relinked, got %v, %v", ok, err)
+		}
+		if u, _ := s.GetUser("alice"); u.ExternalID != "https://idp.example.com 1" {
+			t.Errorf("Expected the first link kept, got %q", u.ExternalID)
+		}
+		if users, _ := s.ListUsers(); len(users) != 1 || users[0].ExternalID != "https://idp.example.com 1" {
+			t.Errorf("Expected the link listed, got %+v", users)
+		}
+		if _, err := s.LinkUser("nobody", "https://idp.example.com 3"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+		}
+	})
+}
+
+func TestStoreTOTPAttempts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateUser("alice", "hash", "subscriber")
+		s.SetUserTOTP("alice", "secret", true)
+
+		for want := 1; want <= 2; want++ {
+			if n, err := s.AddTOTPFailure("alice"); err != nil || n != want {
+				t.Fatalf("Expected %d failures, got %d (%v)", want, n, err)
+			}
+		}
+		if u, _ := s.GetUser("alice"); u.TOTPFailures != 2 || time.Since(u.TOTPFailedAt) > time.Minute {
+			t.Errorf("Expected 2 failures just now, got %d at %v", u.TOTPFailures, u.TOTPFailedAt)
+		}
+		if ok, err := s.UseTOTPStep("alice", 100); err != nil || !ok {
+			t.Fatalf("Expected the step accepted, got %v, %v", ok, err)
+		}
+		if u, _ := s.GetUser("alice"); u.TOTPFailures != 0 {
+			t.Errorf("Expected an accepted code to clear the failures, got %d", u.TOTPFailures)
+		}
+		for _, step := range []int64{100, 99} {
+			if ok, _ := s.UseTOTPStep("alice", step); ok {
+				t.Errorf("Expected step %d refused after step 100", step)
+			}
+		}
+		if ok, _ := s.UseTOTPStep("alice", 101); !ok {
+			t.Error("Expected a later step accepted")
+		}
+
+		s.AddTOTPFailure("alice")
+		s.SetRecoveryCodes("alice", []string{"h1"})
+		s.UseRecoveryCode("alice", "h1")
+		if u, _ := s.GetUser("alice"); u.TOTPFailures != 0 {
+			t.Errorf("Expected a recovery code to clear the failures, got %d", u.TOTPFailures)
+		}
+
+		// Turning 2FA off forgets the used step and the failures
+		s.AddTOTPFailure("alice")
+		s.SetUserTOTP("alice", "", false)
+		if u, _ := s.GetUser("alice"); u.TOTPFailures != 0 {
+			t.Errorf("Expected disabling 2FA to clear the failures, got %d", u.TOTPFailures)
+		}
+		s.SetUserTOTP("alice", "other", true)
+		if ok, _ := s.UseTOTPStep("alice", 50); !ok {
+			t.Error("Expected a new secret to start over")
+		}
+
+		if ok, _ := s.UseTOTPStep("nobody", 1); ok {
+			t.Error("Expected no step recorded for an unknown user")
+		}
+		if _, err := s.AddTOTPFailure("nobody"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+		}
+	})
+}
+
+func TestStoreUnread(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateUser("alice", "hash", "subscriber")
+		s.CreateUser("bob", "hash", "subscriber")
+		s.IncrementUnread([]string{"alice"})
+		counts, err := s.IncrementUnread([]string{"alice", "bob", "nobody"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 2 || counts["alice"] != 2 || counts["bob"] != 1 {
+			t.Errorf("Expected alice at 2 and bob at 1, got %v", counts)
+		}
+		if err := s.SetUnread("alice", 0); err != nil {
+			t.Fatal(err)
+		}
+		if u, _ := s.GetUser("alice"); u.Unread != 0 {
+			t.Errorf("Expected the counter reset, got %d", u.Unread)
+		}
+		if u, _ := s.GetUser("bob"); u.Unread != 1 {
+			t.Errorf("Expected bob's counter kept, got %d", u.Unread)
+		}
+		if err := s.SetUnread("nobody", 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+		}
+	})
+}
+
+func TestStoreTopicSequence(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		numbered := func(seq int64) ([]byte, error) { return []byte(fmt.Sprintf(`{"seq":%d}`, seq)), nil }
+		save := func(topic string) int64 {
+			t.Helper()
+			id, err := s.SaveSequencedMessage(topic, MessageOrigin{Publisher: "alice"}, numbered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		payload := func(id int64) string {
+			m, _ := s.GetMessage(id)
+			if m == nil {
+				return ""
+			}
+			return string(m.Payload)
+		}
+
+		for want := 1; want <= 3; want++ {
+			if p := payload(save("news")); p != fmt.Sprintf(`{"seq":%d}`, want) {
+				t.Fatalf("Expected sequence %d, got %s", want, p)
+			}
+		}
+		if p := payload(save("sports")); p != `{"seq":1}` {
+			t.Errorf("Expected topics to count separately, got %s", p)
+		}
+
+		// A failed seal saves nothing and uses no number
+		if _, err := s.SaveSequencedMessage("news", MessageOrigin{}, func(int64) ([]byte, error) { return nil, errors.New("boom") }); err == nil {
+			t.Error("Expected the seal error")
+		}
+		// Held messages are saved unnumbered and numbered when released
+		held, _ := s.SaveMessageFrom("news", []byte(`{}`), MessageOrigin{})
+		if err := s.SequenceMessage(held, numbered); err != nil || payload(held) != `{"seq":4}` {
+			t.Errorf("Expected the released message numbered 4, got %s (%v)", payload(held), err)
+		}
+
+		if err := s.RenameTopic("news", "headlines", false); err != nil {
+			t.Fatal(err)
+		}
+		if p := payload(save("headlines")); p != `{"seq":5}` {
+			t.Errorf("Expected a renamed topic to keep counting, got %s", p)
+		}
+		if _, err := s.SaveSequencedMessage("nope", MessageOrigin{}, numbered); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown topic, got %v", err)
+		}
+		if err := s.SequenceMessage(9999, numbered); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown message, got %v", err)
+		}
+	})
+}
+
+func TestStoreCreateOwnedTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("alice/admin-made")
+		s.SetTopicInfo(TopicInfo{Name: "alice/admin-made", Owner: "bob"})
+
+		// Concurrent creates stop at the limit
+		var wg sync.WaitGroup
+		errs := make([]error, 6)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = s.CreateOwnedTopic(fmt.Sprintf("alice/t%d", i), "alice", "alice/", 3)
+			}()
+		}
+		wg.Wait()
+		created := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case !errors.Is(err, ErrLimitReached):
+				t.Errorf("Expected ErrLimitReached past the limit, got %v", err)
+			}
+		}
+		if created != 3 {
+			t.Errorf("Expected 3 topics created, got %d", created)
+		}
+
+		count, err := s.CreateOwnedTopic("alice/more", "alice", "alice/", 3)
+		if !errors.Is(err, ErrLimitReached) || count != 3 {
+			t.Errorf("Expected ErrLimitReached with a count of 3, got %d, %v", count, err)
+		}
+		if info, _ := s.GetTopicInfo("alice/t0"); info != nil && info.Owner != "alice" {
+			t.Errorf("Expected the topic owned by alice, got %+v", info)
+		}
+		if _, err := s.CreateOwnedTopic("alice/admin-made", "alice", "alice/", 10); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected ErrDuplicate for an existing topic, got %v", err)
+		}
+	})
+}
+
+func TestStoreSchedules(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		if _, err := s.CreateSchedule(Schedule{Topic: "nope", Cron: "@daily", Request: json.RawMessage(`{}`)}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown topic, got %v", err)
+		}
+
+		nine := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+		digest, err := s.CreateSchedule(Schedule{
+			Topic: "news", Cron: "0 9 * * *", Timezone: "UTC", CreatedBy: "alice",
+			Request: json.RawMessage(`{"template":"digest"}`), NextRun: nine,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		scores, _ := s.CreateSchedule(Schedule{
+			Topic: "sports", Cron: "@hourly", Timezone: "UTC", Request: json.RawMessage(`{}`), NextRun: nine.Add(-time.Hour),
+		})
+		sc, err := s.GetSchedule(digest)
+		if err != nil || sc == nil {
+			t.Fatalf("GetSchedule failed: %v", err)
+		}
+		if sc.Topic != "news" || sc.Cron != "0 9 * * *" || sc.CreatedBy != "alice" || string(sc.Request) != `{"template":"digest"}` ||
+			!sc.NextRun.Equal(nine) || sc.CreatedAt.IsZero() {
+			t.Errorf("Unexpected schedule %+v", sc)
+		}
+		if sc, _ := s.GetSchedule(999); sc != nil {
+			t.Errorf("Expected nil for an unknown schedule, got %+v", sc)
+		}
+		if all, _ := s.ListSchedules(""); len(all) != 2 || all[0].ID != digest {
+			t.Errorf("Expected both schedules ordered by ID, got %+v", all)
+		}
+		if news, _ := s.ListSchedules("news"); len(news) != 1 || news[0].ID != digest {
+			t.Errorf("Expected the news schedule, got %+v", news)
+		}
+
+		// Due earliest first; only one caller advances a run
+		due, _ := s.DueSchedules(nine)
+		if len(due) != 2 || due[0].ID != scores || due[1].ID != digest {
+			t.Fatalf("Expected both schedules due, earliest first, got %+v", due)
+		}
+		if due, _ := s.DueSchedules(nine.Add(-time.Minute)); len(due) != 1 {
+			t.Errorf("Expected only the earlier schedule due, got %+v", due)
+		}
+		tomorrow := nine.AddDate(0, 0, 1)
+		if ok, err := s.AdvanceSchedule(digest, nine, tomorrow); err != nil || !ok {
+			t.Fatalf("AdvanceSchedule failed: %v", err)
+		}
+		if ok, _ := s.AdvanceSchedule(digest, nine, tomorrow); ok {
+			t.Error("Expected a run that was already advanced not to advance again")
+		}
+
+		// Paused schedules are never due
+		if ok, _ := s.SetSchedulePaused(scores, true, nine); !ok {
+			t.Fatal("Expected the schedule to be paused")
+		}
+		if due, _ := s.DueSchedules(tomorrow); len(due) != 1 || due[0].ID != digest {
+			t.Errorf("Expected the paused schedule not to be due, got %+v", due)
+		}
+		if ok, _ := s.AdvanceSchedule(scores, nine, tomorrow); ok {
+			t.Error("Expected a paused schedule not to advance")
+		}
+		if ok, _ := s.SetSchedulePaused(999, true, nine); ok {
+			t.Error("Expected false for an unknown schedule")
+		}
+
+		// Runs are listed newest first, keeping the latest
+		for i := 0; i < MaxScheduleRuns+2; i++ {
+			err := s.AddScheduleRun(ScheduleRun{ScheduleID: digest, ScheduledAt: nine, RanAt: nine, Status: ScheduleRunSent, MessageID: int64(i + 1), Enqueued: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.AddScheduleRun(ScheduleRun{ScheduleID: scores, ScheduledAt: nine, RanAt: nine, Status: ScheduleRunFailed, Error: "boom"})
+		runs, err := s.ListScheduleRuns(digest, 1000)
+		if err != nil || len(runs) != MaxScheduleRuns {
+			t.Fatalf("Expected %d runs, got %d (%v)", MaxScheduleRuns, len(runs), err)
+		}
+		if runs[0].MessageID != MaxScheduleRuns+2 || runs[0].Enqueued != 3 || runs[0].Status != ScheduleRunSent || !runs[0].RanAt.Equal(nine) {
+			t.Errorf("Expected the newest run first, got %+v", runs[0])
+		}
+		if runs, _ := s.ListScheduleRuns(scores, 10); len(runs) != 1 || runs[0].Error != "boom" {
+			t.Errorf("Expected the failed run, got %+v", runs)
+		}
+		if runs, _ := s.ListScheduleRuns(digest, 2); len(runs) != 2 {
+			t.Errorf("Expected the limit to apply, got %d runs", len(runs))
+		}
+
+		// Schedules follow their topic
+		if err := s.RenameTopic("news", "headlines", false); err != nil {
+			t.Fatal(err)
+		}
+		if sc, _ := s.GetSchedule(digest); sc == nil || sc.Topic != "headlines" {
+			t.Errorf("Expected the schedule to move to the renamed topic, got %+v", sc)
+		}
+		if ok, err := s.DeleteSchedule(digest); err != nil || !ok {
+			t.Fatalf("DeleteSchedule failed: %v", err)
+		}
+		if ok, _ := s.DeleteSchedule(digest); ok {
+			t.Error("Expected false deleting a deleted schedule")
+		}
+		if runs, _ := s.ListScheduleRuns(digest, 10); len(runs) != 0 {
+			t.Errorf("Expected the runs deleted with the schedule, got %d", len(runs))
+		}
+		if err := s.AddScheduleRun(ScheduleRun{ScheduleID: digest, ScheduledAt: nine, RanAt: nine, Status: ScheduleRunSent}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound recording a run of a deleted schedule, got %v", err)
+		}
+		if err := s.DeleteTopic("sports"); err != nil {
+			t.Fatal(err)
+		}
+		if all, _ := s.ListSchedules(""); len(all) != 0 {
+			t.Errorf("Expected the schedules deleted with their topic, got %+v", all)
+		}
+	})
+}
+
+func TestStoreDrafts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		if _, err := s.CreateDraft(Draft{Topic: "nope", Request: json.RawMessage(`{}`)}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown topic, got %v", err)
+		}
+
+		launch, err := s.CreateDraft(Draft{Topic: "news", CreatedBy: "alice", Request: json.RawMessage(`{"payload":{"title":"Launch"}}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		scores, _ := s.CreateDraft(Draft{Topic: "sports", Request: json.RawMessage(`{}`)})
+		d, err := s.GetDraft(launch)
+		if err != nil || d == nil {
+			t.Fatalf("GetDraft failed: %v", err)
+		}
+		if d.Topic != "news" || d.CreatedBy != "alice" || d.Status != DraftOpen || string(d.Request) != `{"payload":{"title":"Launch"}}` ||
+			d.CreatedAt.IsZero() || d.PublishedAt != nil || d.MessageID != 0 {
+			t.Errorf("Unexpected draft %+v", d)
+		}
+		if d, _ := s.GetDraft(999); d != nil {
+			t.Errorf("Expected nil for an unknown draft, got %+v", d)
+		}
+		if all, _ := s.ListDrafts(""); len(all) != 2 || all[0].ID != launch {
+			t.Errorf("Expected both drafts ordered by ID, got %+v", all)
+		}
+		if news, _ := s.ListDrafts("news"); len(news) != 1 || news[0].ID != launch {
+			t.Errorf("Expected the news draft, got %+v", news)
+		}
+
+		if ok, err := s.UpdateDraft(launch, json.RawMessage(`{"payload":{"title":"Launch day"}}`)); err != nil || !ok {
+			t.Fatalf("UpdateDraft failed: %v", err)
+		}
+		if d, _ := s.GetDraft(launch); string(d.Request) != `{"payload":{"title":"Launch day"}}` {
+			t.Errorf("Expected the request updated, got %s", d.Request)
+		}
+		if ok, _ := s.UpdateDraft(999, json.RawMessage(`{}`)); ok {
+			t.Error("Expected false updating an unknown draft")
+		}
+
+		// Only one caller claims a draft, which can't change meanwhile
+		if ok, err := s.SetDraftStatus(launch, DraftOpen, DraftPublishing, 0); err != nil || !ok {
+			t.Fatalf("SetDraftStatus failed: %v", err)
+		}
+		if ok, _ := s.SetDraftStatus(launch, DraftOpen, DraftPublishing, 0); ok {
+			t.Error("Expected a claimed draft not to be claimed again")
+		}
+		if ok, _ := s.UpdateDraft(launch, json.RawMessage(`{}`)); ok {
+			t.Error("Expected a draft being published not to be updated")
+		}
+		if ok, _ := s.SetDraftStatus(launch, DraftPublishing, DraftPublished, 42); !ok {
+			t.Fatal("Expected the draft to be published")
+		}
+		d, _ = s.GetDraft(launch)
+		if d.Status != DraftPublished || d.MessageID != 42 || d.PublishedAt == nil {
+			t.Errorf("Unexpected published draft %+v", d)
+		}
+
+		// Drafts follow their topic
+		if err := s.RenameTopic("news", "headlines", false); err != nil {
+			t.Fatal(err)
+		}
+		if d, _ := s.GetDraft(launch); d == nil || d.Topic != "headlines" {
+			t.Errorf("Expected the draft to move to the renamed topic, got %+v", d)
+		}
+		if ok, err := s.DeleteDraft(launch); err != nil || !ok {
+			t.Fatalf("DeleteDraft failed: %v", err)
+		}
+		if ok, _ := s.DeleteDraft(launch); ok {
+			t.Error("Expected false deleting a deleted draft")
+		}
+		if err := s.DeleteTopic("sports"); err != nil {
+			t.Fatal(err)
+		}
+		if d, _ := s.GetDraft(scores); d != nil {
+			t.Errorf("Expected the drafts deleted with their topic, got %+v", d)
+		}
+	})
+}
+
+func TestStoreSharedPayloads(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		tokens := []string{"en-1", "en-2", "fr-1", "en-3", "plain-1", "plain-2"}
+		for _, token := range tokens {
+			s.AddSubscription("news", token, "mock", "reader")
+		}
+		id, _ := s.SaveMessage("news", []byte(`{"title":"?"}`))
+		en, fr := []byte(`{"title":"Hello"}`), []byte(`{"title":"Bonjour"}`)
+		_, err := s.EnqueueMessages(id, []QueueEntry{
+			{Token: "en-1", Payload: en}, {Token: "en-2", Payload: bytes.Clone(en)}, {Token: "fr-1", Payload: fr},
+			{Token: "en-3", Payload: en}, {Token: "plain-1"}, {Token: "plain-2"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.EnqueueMessagePayload(id, "en-1", en); err != nil {
+			t.Fatal(err)
+		}
+
+		pending, _ := s.GetAllPendingMessages()
+		want := []string{`{"title":"Hello"}`, `{"title":"Hello"}`, `{"title":"Bonjour"}`, `{"title":"Hello"}`, `{"title":"?"}`, `{"title":"?"}`, `{"title":"Hello"}`}
+		if len(pending) != len(want) {
+			t.Fatalf("Expected %d pending items, got %+v", len(want), pending)
+		}
+		for i, item := range pending {
+			if string(item.Payload) != want[i] {
+				t.Errorf("Expected item %d to carry %s, got %s", i, want[i], item.Payload)
+			}
+		}
+		if &pending[0].Payload[0] != &pending[6].Payload[0] || &pending[4].Payload[0] != &pending[5].Payload[0] {
+			t.Error("Expected items with the same payload to share it")
+		}
+		if mine, _ := s.GetPendingMessages("en-1"); len(mine) != 2 || string(mine[1].Payload) != `{"title":"Hello"}` {
+			t.Errorf("Unexpected pending items of en-1 %+v", mine)
+		}
+
+		// Payloads left without queue items go with the messages
+		s.ClearTopicMessages("news")
+		id, _ = s.SaveMessage("news", []byte(`{}`))
+		s.EnqueueMessagePayload(id, "fr-1", fr)
+		if pending, _ := s.GetAllPendingMessages(); len(pending) != 1 || string(pending[0].Payload) != `{"title":"Bonjour"}` {
+			t.Errorf("Expected the payload stored again, got %+v", pending)
+		}
+	})
+}
+
+func TestStoreStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		hour := time.Date(2024, 5, 7, 9, 0, 0, 0, time.UTC)
+		if err := s.RecordStats(hour.Add(20*time.Minute), map[string]int64{"publishes": 2}, map[string]int64{"queue_depth": 5}); err != nil {
+			t.Fatal(err)
+		}
+		s.RecordStats(hour, map[string]int64{"publishes": 3, "failures": 1}, map[string]int64{"queue_depth": 4})
+		s.RecordStats(hour.Add(time.Hour), map[string]int64{"publishes": 1}, map[string]int64{"queue_depth": 7})
+
+		points, err := s.ListStats(hour, hour.Add(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []StatPoint{
+			{hour, "failures", 1},
+			{hour, "publishes", 5},
+			{hour, "queue_depth", 5},
+			{hour.Add(time.Hour), "publishes", 1},
+			{hour.Add(time.Hour), "queue_depth", 7},
+		}
+		if len(points) != len(want) {
+			t.Fatalf("Expected %d points, got %+v", len(want), points)
+		}
+		for i, p := range points {
+			if !p.Bucket.Equal(want[i].Bucket) || p.Metric != want[i].Metric || p.Value != want[i].Value {
+				t.Errorf("Point %d: expected %+v, got %+v", i, want[i], p)
+			}
+		}
+		if points, _ := s.ListStats(hour.Add(time.Hour), hour.Add(2*time.Hour)); len(points) != 2 {
+			t.Errorf("Expected the second hour only, got %+v", points)
+		}
+
+		if n, err := s.DeleteStatsBefore(hour.Add(time.Hour)); err != nil || n != 3 {
+			t.Errorf("Expected 3 points deleted, got %d (%v)", n, err)
+		}
+		if points, _ := s.ListStats(hour, hour.Add(2*time.Hour)); len(points) != 2 {
+			t.Errorf("Expected the second hour to remain, got %+v", points)
+		}
+	})
+}
+
+func TestStoreModeration(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		id, _ := s.SaveMessage("news", []byte(`{}`))
+		s.HoldMessage(Approval{MessageID: id, Topic: "news", Publisher: "pub", Audience: 10, Request: []byte(`{}`)})
+		if a, _ := s.DecideApproval(id, ApprovalApproved, "admin"); a == nil || a.Status != ApprovalApproved || a.DecidedAt == nil {
+			t.Errorf("Unexpected decided approval %+v", a)
+		}
+		if a, _ := s.DecideApproval(id, ApprovalRejected, "admin"); a != nil {
+			t.Error("An approval should only be decided once")
+		}
+		if approvals, _ := s.ListApprovals(ApprovalPending); len(approvals) != 0 {
+			t.Errorf("Expected no pending approvals, got %v", approvals)
+		}
+
+		r1, _ := s.CreateFilterRule(FilterRule{Type: "keyword", Pattern: "spam", Record: true})
+		r2, _ := s.CreateFilterRule(FilterRule{Type: "keyword", Pattern: "scam"})
+		if r2 <= r1 {
+			t.Errorf("Expected increasing rule IDs, got %d then %d", r1, r2)
+		}
+		if ok, _ := s.DeleteFilterRule(r1); !ok {
+			t.Error("Expected rule to be deleted")
+		}
+		if rules, _ := s.ListFilterRules(); len(rules) != 1 || rules[0].ID != r2 {
+			t.Errorf("Unexpected rules %v", rules)
+		}
+
+		s.AddModerationEntry(ModerationEntry{RuleID: r1, Reason: "first", Payload: []byte(`{}`)})
+		s.AddModerationEntry(ModerationEntry{RuleID: r1, Reason: "second", Payload: []byte(`{}`)})
+		if entries, _ := s.ListModerationEntries(1); len(entries) != 1 || entries[0].Reason != "second" {
+			t.Errorf("Expected newest moderation entry first, got %v", entries)
+		}
+	})
+}
+
+func TestStoreAuditLog(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.AddAuditEvent(AuditEvent{Actor: "admin", Action: "user.create", Target: "alice", Details: map[string]string{"role": "publisher"}})
+		s.AddAuditEvent(AuditEvent{Actor: "admin", Action: "user.delete", Target: "alice"})
+		s.AddAuditEvent(AuditEvent{Actor: "alice", Action: "login.failure"})
+
+		events, _ := s.ListAuditEvents(AuditFilter{Action: "user.*"})
+		if len(events) != 2 || events[0].Action != "user.delete" {
+			t.Errorf("Expected user events newest first, got %v", events)
+		}
+		if events[1].Details["role"] != "publisher" {
+			t.Errorf("Expected details to round-trip, got %v", events[1].Details)
+		}
+		if events, _ := s.ListAuditEvents(AuditFilter{Actor: "alice"}); len(events) != 1 {
+			t.Errorf("Expected 1 event for alice, got %d", len(events))
+		}
+		if events, _ := s.ListAuditEvents(AuditFilter{Until: time.Now().Add(-time.Hour)}); len(events) != 0 {
+			t.Errorf("Expected no events before an hour ago, got %d", len(events))
+		}
+		if events, _ := s.ListAuditEvents(AuditFilter{Since: time.Now().Add(-time.Hour), Limit: 1}); len(events) != 1 {
+			t.Errorf("Expected limit to apply, got %d", len(events))
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		issued := time.Now().Truncate(time.Second)
+		for i, id := range []string{"a", "b"} {
+			err := s.CreateSession(Session{ID: id, Username: "alice", Method: "password", IP: "192.0.2.1",
+				IssuedAt: issued.Add(time.Duration(i) * time.Second), ExpiresAt: issued.Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateSession failed: %v", err)
+			}
+		}
+		if err := s.CreateSession(Session{ID: "a", Username: "alice", IssuedAt: issued, ExpiresAt: issued.Add(time.Hour)}); err == nil {
+			t.Error("Expected error for a duplicate session ID")
+		}
+		s.CreateSession(Session{ID: "c", Username: "bob", IssuedAt: issued, ExpiresAt: issued.Add(time.Hour)})
+
+		sess, err := s.GetSession("a")
+		if err != nil || sess == nil {
+			t.Fatalf("GetSession failed: %v %v", sess, err)
+		}
+		if sess.Username != "alice" || sess.Method != "password" || sess.IP != "192.0.2.1" ||
+			!sess.IssuedAt.Equal(issued) || !sess.ExpiresAt.Equal(issued.Add(time.Hour)) || sess.LastUsedAt != nil {
+			t.Errorf("Unexpected session %+v", sess)
+		}
+		if sess, _ := s.GetSession("missing"); sess != nil {
+			t.Errorf("Expected nil for a missing session, got %+v", sess)
+		}
+
+		used := issued.Add(time.Minute)
+		s.TouchSession("a", "198.51.100.2", used)
+		if sess, _ := s.GetSession("a"); sess.LastUsedAt == nil || !sess.LastUsedAt.Equal(used) || sess.LastIP != "198.51.100.2" {
+			t.Errorf("Expected last use to be recorded, got %+v", sess)
+		}
+
+		sessions, _ := s.ListSessions("alice")
+		if len(sessions) != 2 || sessions[0].ID != "b" || sessions[1].ID != "a" {
+			t.Errorf("Expected alice's sessions newest first, got %+v", sessions)
+		}
+
+		// Expired sessions aren't listed and are dropped on the user's next session
+		s.CreateSession(Session{ID: "old", Username: "alice", IssuedAt: issued.Add(-2 * time.Hour), ExpiresAt: issued.Add(-time.Hour)})
+		if sessions, _ := s.ListSessions("alice"); len(sessions) != 2 {
+			t.Errorf("Expected expired session to be hidden, got %+v", sessions)
+		}
+		s.CreateSession(Session{ID: "d", Username: "alice", IssuedAt: issued, ExpiresAt: issued.Add(time.Hour)})
+		if sess, _ := s.GetSession("old"); sess != nil {
+			t.Error("Expected expired session to be dropped")
+		}
+
+		if ok, _ := s.DeleteSession("bob", "a"); ok {
+			t.Error("A session can only be deleted for its own user")
+		}
+		if ok, _ := s.DeleteSession("alice", "a"); !ok {
+			t.Error("Expected session a to be deleted")
+		}
+		if n, _ := s.DeleteUserSessions("alice"); n != 2 {
+			t.Errorf("Expected 2 remaining sessions deleted, got %d", n)
+		}
+		if sessions, _ := s.ListSessions("alice"); len(sessions) != 0 {
+			t.Errorf("Expected no sessions, got %+v", sessions)
+		}
+		if sess, _ := s.GetSession("c"); sess == nil {
+			t.Error("Other users' sessions must be kept")
+		}
+	})
+}
+
+func TestStoreStaleSubscriptions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		s.AddSubscription("news", "alive", "webhook", "")
+		s.AddSubscription("sports", "alive", "webhook", "")
+		s.AddSubscription("news", "dead", "webhook", "")
+		s.AddSubscription("sports", "new", "webhook", "")
+
+		now := time.Now()
+		seen := now.Add(2 * time.Hour)
+		if err := s.MarkTokensSeen([]string{"alive", "unknown"}, seen); err != nil {
+			t.Fatal(err)
+		}
+		subs, _ := s.GetSubscriptionsByToken("alive")
+		if len(subs) != 2 || subs[0].LastSeenAt == nil || !subs[0].LastSeenAt.Equal(seen) || subs[0].CreatedAt == nil {
+			t.Fatalf("Expected both of alive's subscriptions seen, got %+v", subs)
+		}
+
+		before := now.Add(time.Hour)
+		stale, err := s.ListStaleSubscriptions(before, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stale) != 2 || stale[0].Token != "dead" || stale[1].Token != "new" || stale[0].LastSeenAt != nil {
+			t.Errorf("Expected the never delivered subscriptions in creation order, got %+v", stale)
+		}
+		if stale, _ := s.ListStaleSubscriptions(before, 1); len(stale) != 1 {
+			t.Errorf("Expected the limit applied, got %+v", stale)
+		}
+		if stale, _ := s.ListStaleSubscriptions(now.Add(-time.Hour), 10); len(stale) != 0 {
+			t.Errorf("Expected nothing stale an hour ago, got %+v", stale)
+		}
+
+		if n, err := s.RemoveStaleSubscriptions(before); err != nil || n != 2 {
+			t.Errorf("Expected 2 stale subscriptions removed, got %d, %v", n, err)
+		}
+		if n, _ := s.GetSubscriptionCount(); n != 2 {
+			t.Errorf("Expected alive's subscriptions kept, got %d", n)
+		}
+	})
+}
+
+func TestStoreSubscriptionPreferences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s Store) {
+		s.CreateTopic("news")
+		s.CreateTopic("sports")
+		s.AddSubscription("news", "a", "webhook", "")
+		s.AddSubscription("news", "b", "webhook", "")
+
+		muted := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		prefs := &Preferences{MutedUntil: &muted, MinPriority: "high", MaxPerDay: 3}
+		if err := s.SetSubscriptionPreferences("news", "a", prefs); err != nil {
+			t.Fatal(err)
+		}
+		subs, _ := s.GetSubscriptionsByToken("a")
+		if len(subs) != 1 || subs[0].Preferences == nil {
+			t.Fatalf("Expected preferences, got %+v", subs)
+		}
+		if got := subs[0].Preferences; !got.MutedUntil.Equal(muted) || got.MinPriority != "high" || got.MaxPerDay != 3 {
+			t.Errorf("Expected %+v, got %+v", prefs, got)
+		}
+		s.SetSubscriptionPreferences("news", "a", nil)
+		if subs, _ := s.GetSubscribers("news"); subs[0].Preferences != nil || subs[1].Preferences != nil {
+			t.Errorf("Expected preferences cleared, got %+v", subs)
+		}
+
+		news, _ := s.SaveMessage("news", []byte(`{}`))
+		sports, _ := s.SaveMessage("sports", []byte(`{}`))
+		s.EnqueueMessages(news, []QueueEntry{{Token: "a"}, {Token: "b"}})
+		s.EnqueueMessages(sports, []QueueEntry{{Token: "a"}})
+		s.EnqueueMessage(news, "a")
+
+		counts, err := s.CountQueuedSince("news", []string{"a", "c"}, time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(counts) != 1 || counts["a"] != 2 {
+			t.Errorf("Expected 2 items for a, got %v", counts)
+		}
+		if counts, _ := s.CountQueuedSince("news", []string{"a"}, time.Now().Add(time.Hour)); len(counts) != 0 {
+			t.Errorf("Expected nothing queued since the future, got %v", counts)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"no-spam/password"
+	"no-spam/store"
+)
+
+func TestOpenStore(t *testing.T) {
+	s, err := openStore(Config{Store: "memory"})
+	if err != nil {
+		t.Fatalf("openStore(memory) failed: %v", err)
+	}
+	if _, ok := s.(*store.MemoryStore); !ok {
+		t.Errorf("Expected *store.MemoryStore, got %T", s)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err = openStore(Config{DBPath: path})
+	if err != nil {
+		t.Fatalf("openStore(sqlite) failed: %v", err)
+	}
+	if _, ok := s.(*store.SQLiteStore); !ok {
+		t.Errorf("Expected *store.SQLiteStore by default, got %T", s)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected database at %s: %v", path, err)
+	}
+
+	s, err = openStore(Config{Store: "bolt", DBPath: filepath.Join(t.TempDir(), "test.bolt")})
+	if err != nil {
+		t.Fatalf("openStore(bolt) failed: %v", err)
+	}
+	s.(*store.BoltStore).Close()
+
+	if _, err := openStore(Config{Store: "postgres"}); err == nil {
+		t.Error("Expected error for unknown store backend")
+	}
+}
+
+func TestSetupAdminUser(t *testing.T) {
+	s := store.NewMemoryStore()
+	setupAdminUser(s, nil)
+	u, _ := s.GetUser("admin")
+	if u == nil || u.Role != "admin" {
+		t.Fatalf("Expected an admin user, got %+v", u)
+	}
+	if !u.MustChangePassword {
+		t.Error("A generated password must be changed at the first login")
+	}
+
+	configured := "configured-secret"
+	s = store.NewMemoryStore()
+	setupAdminUser(s, &configured)
+	u, _ = s.GetUser("admin")
+	if u == nil || u.MustChangePassword {
+		t.Fatalf("A configured password doesn't need changing, got %+v", u)
+	}
+	if err := password.Verify(u.PasswordHash, configured); err != nil {
+		t.Errorf("Expected the configured password to work: %v", err)
+	}
+}
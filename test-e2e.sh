@@ -11,10 +11,13 @@ echo "=== E2E Test: Admin Creates Publisher and Topic ==="
 echo ""
 
 # Step 1: Login as admin
+# Start the server with INITIAL_ADMIN_PASSWORD set to the same value, since a
+# generated password must be changed before it can be used.
+ADMIN_PASSWORD="${ADMIN_PASSWORD:-REPLACE_WITH_ADMIN_PASSWORD}"
 echo "Step 1: Login as admin..."
 ADMIN_RESPONSE=$($CURL -X POST "$BASE_URL/admin/login" \
   -H "Content-Type: application/json" \
-  -d '{"username": "admin", "password": "REPLACE_WITH_ADMIN_PASSWORD"}')
+  -d '{"username": "admin", "password": "'"$ADMIN_PASSWORD"'"}')
 
 ADMIN_TOKEN=$(echo $ADMIN_RESPONSE | grep -o '"token":"[^"]*' | cut -d'"' -f4)
 